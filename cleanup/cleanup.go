@@ -44,12 +44,13 @@ func PruneOldTags(ctx context.Context, c ImageCleaner, imageName string, retenti
 }
 
 // NewCleaner returns an ImageCleaner for the given runtime.
-// "compose" → docker, "k3s" → nerdctl/buildctl.
-func NewCleaner(runtime string, ssh SSHRunner) ImageCleaner {
+// "compose" → docker, "k3s" → nerdctl/buildctl. images are built image
+// names outside the ssd- prefix whose tags it may remove.
+func NewCleaner(runtime string, ssh SSHRunner, images ...string) ImageCleaner {
 	if runtime == "k3s" {
-		return NewK3sCleaner(ssh)
+		return NewK3sCleaner(ssh, images...)
 	}
-	return NewComposeCleaner(ssh)
+	return NewComposeCleaner(ssh, images...)
 }
 
 // Tag describes a single image tag on the server.
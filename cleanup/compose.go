@@ -3,6 +3,7 @@ package cleanup
 import (
 	"context"
 	"fmt"
+	"slices"
 	"strconv"
 	"strings"
 
@@ -30,19 +31,23 @@ type ImageCleaner interface {
 const buildCacheMaxAge = "168h"
 
 // ssdImagePrefix is the required prefix for any image ssd is allowed to
-// remove. Protects foreign images (nginx, postgres, user-pushed) from
+// remove, unless the image was named by image_template and handed to the
+// cleaner. Protects foreign images (nginx, postgres, user-pushed) from
 // accidental deletion.
 const ssdImagePrefix = "ssd-"
 
 // ComposeCleaner implements ImageCleaner for the compose runtime via
 // docker commands over SSH.
 type ComposeCleaner struct {
-	ssh SSHRunner
+	ssh    SSHRunner
+	images []string
 }
 
-// NewComposeCleaner wires a compose cleaner to an SSH runner.
-func NewComposeCleaner(ssh SSHRunner) *ComposeCleaner {
-	return &ComposeCleaner{ssh: ssh}
+// NewComposeCleaner wires a compose cleaner to an SSH runner. images are
+// built image names (without tag) outside the ssd- prefix, rendered from
+// image_template, whose tags it may also remove.
+func NewComposeCleaner(ssh SSHRunner, images ...string) *ComposeCleaner {
+	return &ComposeCleaner{ssh: ssh, images: images}
 }
 
 // ListTags returns every tag of the given repository on the server.
@@ -58,9 +63,10 @@ func (c *ComposeCleaner) ListTags(ctx context.Context, imageName string) ([]Tag,
 }
 
 // RemoveImage runs `docker rmi` against the given reference.
-// Refuses any reference not prefixed with "ssd-" for safety.
+// Refuses any reference not prefixed with "ssd-" or of an allowed image
+// for safety.
 func (c *ComposeCleaner) RemoveImage(ctx context.Context, imageRef string) error {
-	if err := guardImageRef(imageRef); err != nil {
+	if err := guardImageRef(imageRef, c.images); err != nil {
 		return err
 	}
 	cmd := fmt.Sprintf("docker rmi %s", shellescape.Quote(imageRef))
@@ -112,11 +118,15 @@ func parseRepoTagLines(out, expectedRepo string) []Tag {
 	return tags
 }
 
-// guardImageRef rejects anything not produced by ssd.
+// guardImageRef rejects anything not produced by ssd: refs must carry the
+// ssd- prefix or be a tag of one of images.
 // Safety net — never trust callers to have pre-filtered.
-func guardImageRef(ref string) error {
-	if !strings.HasPrefix(ref, ssdImagePrefix) {
-		return fmt.Errorf("refusing to remove non-ssd image %q", ref)
+func guardImageRef(ref string, images []string) error {
+	if strings.HasPrefix(ref, ssdImagePrefix) {
+		return nil
 	}
-	return nil
+	if i := strings.LastIndex(ref, ":"); i > 0 && slices.Contains(images, ref[:i]) {
+		return nil
+	}
+	return fmt.Errorf("refusing to remove non-ssd image %q", ref)
 }
@@ -111,3 +111,19 @@ func TestComposeCleaner_RemoveImage_RejectsForeignRef(t *testing.T) {
 	client.AssertNotCalled(t, "SSH", mock.Anything)
 }
 
+// Images named by image_template are removable only when handed to the
+// cleaner; other repositories stay protected.
+func TestComposeCleaner_RemoveImage_AllowsTemplateImage(t *testing.T) {
+	client := &testhelpers.MockRemoteClient{}
+	client.On("SSH", mock.MatchedBy(func(cmd string) bool {
+		return strings.Contains(cmd, "docker rmi") && strings.Contains(cmd, "registry.example.com/shop/web:3")
+	})).Return("", nil)
+
+	cleaner := NewComposeCleaner(client, "registry.example.com/shop/web")
+	require.NoError(t, cleaner.RemoveImage(context.Background(), "registry.example.com/shop/web:3"))
+
+	err := cleaner.RemoveImage(context.Background(), "registry.example.com/shop/api:3")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "refusing")
+	client.AssertNumberOfCalls(t, "SSH", 1)
+}
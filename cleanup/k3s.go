@@ -21,14 +21,16 @@ import (
 //     `buildctl --addr unix:///run/buildkit/buildkitd.sock prune`, which
 //     requires sudo.
 type K3sCleaner struct {
-	ssh SSHRunner
+	ssh    SSHRunner
+	images []string
 }
 
 const buildkitSocket = "unix:///run/buildkit/buildkitd.sock"
 
-// NewK3sCleaner wires a k3s cleaner to an SSH runner.
-func NewK3sCleaner(ssh SSHRunner) *K3sCleaner {
-	return &K3sCleaner{ssh: ssh}
+// NewK3sCleaner wires a k3s cleaner to an SSH runner. images are allowed
+// as for NewComposeCleaner.
+func NewK3sCleaner(ssh SSHRunner, images ...string) *K3sCleaner {
+	return &K3sCleaner{ssh: ssh, images: images}
 }
 
 // ListTags lists all images in the k8s.io namespace and filters to the
@@ -45,7 +47,7 @@ func (c *K3sCleaner) ListTags(ctx context.Context, imageName string) ([]Tag, err
 // RemoveImage runs `nerdctl rmi` in the k8s.io namespace.
 // Refuses non-ssd refs for safety.
 func (c *K3sCleaner) RemoveImage(ctx context.Context, imageRef string) error {
-	if err := guardImageRef(imageRef); err != nil {
+	if err := guardImageRef(imageRef, c.images); err != nil {
 		return err
 	}
 	cmd := fmt.Sprintf("nerdctl --namespace k8s.io rmi %s", shellescape.Quote(imageRef))
@@ -0,0 +1,105 @@
+// Package completion generates shell completion scripts for ssd. The
+// scripts complete subcommand names statically and service names
+// dynamically, by calling back into ssd (see ServicesCommand) so they
+// always reflect the ssd.yaml of the directory the user is in.
+package completion
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// ServicesCommand is the hidden ssd subcommand the scripts run to list
+// service names, one per line. It must print nothing on error.
+const ServicesCommand = "__complete-services"
+
+// Shells lists the shells Write supports.
+var Shells = []string{"bash", "zsh", "fish"}
+
+// Spec describes the CLI surface to complete.
+type Spec struct {
+	// Commands is every user-facing subcommand, aliases included.
+	Commands []string
+	// ServiceCommands are the subcommands whose first argument is a
+	// service name.
+	ServiceCommands []string
+}
+
+// Write renders the completion script for shell to w.
+func Write(w io.Writer, shell string, spec Spec) error {
+	var script string
+	switch shell {
+	case "bash":
+		script = bashScript(spec)
+	case "zsh":
+		script = zshScript(spec)
+	case "fish":
+		script = fishScript(spec)
+	default:
+		return fmt.Errorf("unsupported shell %q (supported: %s)", shell, strings.Join(Shells, ", "))
+	}
+	_, err := io.WriteString(w, script)
+	return err
+}
+
+func bashScript(spec Spec) string {
+	return `# bash completion for ssd
+# Load with: source <(ssd completion bash)
+_ssd() {
+    local cur="${COMP_WORDS[COMP_CWORD]}"
+    if [ "$COMP_CWORD" -eq 1 ]; then
+        COMPREPLY=($(compgen -W "` + strings.Join(spec.Commands, " ") + `" -- "$cur"))
+        return
+    fi
+    if [ "$COMP_CWORD" -eq 2 ]; then
+        case "${COMP_WORDS[1]}" in
+            ` + strings.Join(spec.ServiceCommands, "|") + `)
+                COMPREPLY=($(compgen -W "$(ssd ` + ServicesCommand + ` 2>/dev/null)" -- "$cur"))
+                ;;
+            completion)
+                COMPREPLY=($(compgen -W "` + strings.Join(Shells, " ") + `" -- "$cur"))
+                ;;
+        esac
+    fi
+}
+complete -F _ssd ssd
+`
+}
+
+func zshScript(spec Spec) string {
+	return `#compdef ssd
+# zsh completion for ssd
+# Load with: source <(ssd completion zsh)
+_ssd() {
+    local -a commands
+    commands=(` + strings.Join(spec.Commands, " ") + `)
+    if (( CURRENT == 2 )); then
+        compadd -a commands
+        return
+    fi
+    if (( CURRENT == 3 )); then
+        case "$words[2]" in
+            ` + strings.Join(spec.ServiceCommands, "|") + `)
+                compadd -- ${(f)"$(ssd ` + ServicesCommand + ` 2>/dev/null)"}
+                ;;
+            completion)
+                compadd ` + strings.Join(Shells, " ") + `
+                ;;
+        esac
+    fi
+}
+compdef _ssd ssd
+`
+}
+
+func fishScript(spec Spec) string {
+	services := strings.Join(spec.ServiceCommands, " ")
+	return `# fish completion for ssd
+# Load with: ssd completion fish | source
+complete -c ssd -f
+complete -c ssd -n __fish_use_subcommand -a "` + strings.Join(spec.Commands, " ") + `"
+complete -c ssd -n "__fish_seen_subcommand_from ` + services + `; and test (count (commandline -opc)) -eq 2" -a "(ssd ` + ServicesCommand + ` 2>/dev/null)"
+complete -c ssd -n "__fish_seen_subcommand_from completion" -a "` + strings.Join(Shells, " ") + `"
+`
+}
@@ -0,0 +1,59 @@
+package completion
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+var spec = Spec{
+	Commands:        []string{"deploy", "up", "logs", "ps", "completion"},
+	ServiceCommands: []string{"deploy", "up", "logs"},
+}
+
+func TestWrite_AllShells(t *testing.T) {
+	for _, shell := range Shells {
+		t.Run(shell, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := Write(&buf, shell, spec); err != nil {
+				t.Fatalf("Write: %v", err)
+			}
+			out := buf.String()
+			if out == "" {
+				t.Fatal("empty script")
+			}
+			for _, cmd := range spec.Commands {
+				if !strings.Contains(out, cmd) {
+					t.Errorf("script does not mention command %q", cmd)
+				}
+			}
+			if !strings.Contains(out, "ssd "+ServicesCommand) {
+				t.Errorf("script does not complete service names via %s", ServicesCommand)
+			}
+		})
+	}
+}
+
+func TestWrite_ServiceCommandsMatched(t *testing.T) {
+	var buf bytes.Buffer
+	if err := Write(&buf, "bash", spec); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	if !strings.Contains(buf.String(), "deploy|up|logs)") {
+		t.Errorf("bash case pattern missing service commands:\n%s", buf.String())
+	}
+}
+
+func TestWrite_UnsupportedShell(t *testing.T) {
+	var buf bytes.Buffer
+	err := Write(&buf, "powershell", spec)
+	if err == nil {
+		t.Fatal("expected error for unsupported shell")
+	}
+	if !strings.Contains(err.Error(), "bash, zsh, fish") {
+		t.Errorf("error should list supported shells: %v", err)
+	}
+	if buf.Len() != 0 {
+		t.Errorf("wrote %q on error", buf.String())
+	}
+}
@@ -1,9 +1,13 @@
 package compose
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
+	"maps"
 	"os"
 	"path/filepath"
+	"slices"
 	"strings"
 
 	"github.com/byteink/ssd/config"
@@ -12,31 +16,141 @@ import (
 
 // ComposeFile represents the structure of a docker-compose.yaml file
 type ComposeFile struct {
+	Name     string                     `yaml:"name,omitempty"`
+	SSD      *Metadata                  `yaml:"x-ssd,omitempty"`
 	Services map[string]Service         `yaml:"services"`
 	Networks map[string]Network         `yaml:"networks"`
 	Volumes  map[string]interface{}     `yaml:"volumes,omitempty"`
 }
 
+// MetadataKey is the top-level extension field ssd writes into every
+// compose file it generates. Compose ignores x- keys.
+const MetadataKey = "x-ssd"
+
+// Metadata is the x-ssd block: it marks the file as generated by ssd, so
+// deploys can tell it from a hand-written compose file, and describes the
+// deploy that wrote it. Only ManagedBy and Project are always set;
+// GenerateCompose leaves the rest empty so its output is reproducible.
+type Metadata struct {
+	ManagedBy string `yaml:"managed-by"`
+	Project   string `yaml:"project,omitempty"`
+	// GeneratedAt is when a deploy wrote the file, in RFC 3339 UTC.
+	GeneratedAt string `yaml:"generated-at,omitempty"`
+	// SSDVersion is the version of the ssd binary that wrote the file.
+	SSDVersion string `yaml:"ssd-version,omitempty"`
+	// Sources maps built services to the git tree SHA of the source their
+	// current image was built from.
+	Sources map[string]string `yaml:"sources,omitempty"`
+}
+
+// ParseMetadata returns the x-ssd block of compose content, and false
+// when there is none or the content is unparseable.
+func ParseMetadata(content string) (Metadata, bool) {
+	var file struct {
+		SSD *Metadata `yaml:"x-ssd"`
+	}
+	if err := yaml.Unmarshal([]byte(content), &file); err != nil || file.SSD == nil {
+		return Metadata{}, false
+	}
+	return *file.SSD, true
+}
+
+// HasMetadata reports whether compose content carries the x-ssd block.
+// Unparseable content has none.
+func HasMetadata(content string) bool {
+	var file map[string]yaml.Node
+	if err := yaml.Unmarshal([]byte(content), &file); err != nil {
+		return false
+	}
+	_, ok := file[MetadataKey]
+	return ok
+}
+
 // Service represents a Docker Compose service definition
 type Service struct {
-	Image       string            `yaml:"image"`
-	Restart     string            `yaml:"restart"`
-	EnvFile     string            `yaml:"env_file,omitempty"`
-	Ports       []string          `yaml:"ports,omitempty"`
-	Command     []string          `yaml:"command,omitempty"`
-	Networks    []string          `yaml:"networks"`
-	Volumes     []string          `yaml:"volumes,omitempty"`
-	Labels      []string          `yaml:"labels,omitempty"`
-	DependsOn   *ComposeDependsOn `yaml:"depends_on,omitempty"`
-	HealthCheck *HealthCheck      `yaml:"healthcheck,omitempty"`
-	Deploy      *ComposeDeploy    `yaml:"deploy,omitempty"`
+	Image       string                   `yaml:"image"`
+	Profiles    []string                 `yaml:"profiles,omitempty"`
+	Restart     string                   `yaml:"restart"`
+	EnvFile     ComposeEnvFiles          `yaml:"env_file,omitempty"`
+	Ports       []string                 `yaml:"ports,omitempty"`
+	ExtraHosts  []string                 `yaml:"extra_hosts,omitempty"`
+	CapAdd      []string                 `yaml:"cap_add,omitempty"`
+	CapDrop     []string                 `yaml:"cap_drop,omitempty"`
+	Privileged  bool                     `yaml:"privileged,omitempty"`
+	Command     []string                 `yaml:"command,omitempty"`
+	Networks    []string                 `yaml:"networks"`
+	Volumes     []string                 `yaml:"volumes,omitempty"`
+	Labels      []string                 `yaml:"labels,omitempty"`
+	DependsOn   *ComposeDependsOn        `yaml:"depends_on,omitempty"`
+	HealthCheck *HealthCheck             `yaml:"healthcheck,omitempty"`
+	Ulimits     map[string]ComposeUlimit `yaml:"ulimits,omitempty"`
+	Sysctls     map[string]string        `yaml:"sysctls,omitempty"`
+	Deploy      *ComposeDeploy           `yaml:"deploy,omitempty"`
+}
+
+// ComposeUlimit marshals as a single number when soft and hard are equal,
+// otherwise as a {soft, hard} map.
+type ComposeUlimit config.Ulimit
+
+// MarshalYAML implements yaml.Marshaler.
+func (u ComposeUlimit) MarshalYAML() (interface{}, error) {
+	if u.Soft == u.Hard {
+		return u.Soft, nil
+	}
+	return map[string]int64{"soft": u.Soft, "hard": u.Hard}, nil
+}
+
+// UnmarshalYAML implements yaml.Unmarshaler, accepting both forms.
+func (u *ComposeUlimit) UnmarshalYAML(node *yaml.Node) error {
+	return (*config.Ulimit)(u).UnmarshalYAML(node)
+}
+
+// ComposeEnvFiles is a service's `env_file:`. It marshals as a single path
+// when there is one file, otherwise as a list; compose loads them in order,
+// later files overriding earlier ones.
+type ComposeEnvFiles []string
+
+// MarshalYAML implements yaml.Marshaler.
+func (f ComposeEnvFiles) MarshalYAML() (interface{}, error) {
+	if len(f) == 1 {
+		return f[0], nil
+	}
+	return []string(f), nil
+}
+
+// UnmarshalYAML implements yaml.Unmarshaler, accepting both forms.
+func (f *ComposeEnvFiles) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		*f = ComposeEnvFiles{node.Value}
+		return nil
+	}
+	var files []string
+	if err := node.Decode(&files); err != nil {
+		return err
+	}
+	*f = files
+	return nil
 }
 
 // ComposeDeploy is the generated `deploy:` block for Compose. Only emits
 // replicas when >1 (Compose honors `deploy.replicas` in non-swarm mode
 // only with `--compatibility`; documented in README.md).
 type ComposeDeploy struct {
-	Replicas int `yaml:"replicas"`
+	Replicas  int               `yaml:"replicas,omitempty"`
+	Resources *ComposeResources `yaml:"resources,omitempty"`
+}
+
+// ComposeResources is `deploy.resources`: hard limits and the guaranteed
+// reservations, each emitted only when set.
+type ComposeResources struct {
+	Limits       *ComposeResourceSpec `yaml:"limits,omitempty"`
+	Reservations *ComposeResourceSpec `yaml:"reservations,omitempty"`
+}
+
+// ComposeResourceSpec is one of `limits` or `reservations`.
+type ComposeResourceSpec struct {
+	CPUs   string `yaml:"cpus,omitempty"`
+	Memory string `yaml:"memory,omitempty"`
 }
 
 // ComposeDependsOn marshals as a simple list when no conditions are set,
@@ -77,10 +191,11 @@ func (c ComposeDependsOn) MarshalYAML() (interface{}, error) {
 
 // HealthCheck represents a Docker Compose healthcheck definition
 type HealthCheck struct {
-	Test     []string `yaml:"test"`
-	Interval string   `yaml:"interval,omitempty"`
-	Timeout  string   `yaml:"timeout,omitempty"`
-	Retries  int      `yaml:"retries,omitempty"`
+	Test        []string `yaml:"test"`
+	Interval    string   `yaml:"interval,omitempty"`
+	Timeout     string   `yaml:"timeout,omitempty"`
+	Retries     int      `yaml:"retries,omitempty"`
+	StartPeriod string   `yaml:"start_period,omitempty"`
 }
 
 // Network represents a Docker Compose network definition
@@ -91,17 +206,27 @@ type Network struct {
 
 // GenerateCompose generates a docker-compose.yaml file for the given services
 // services: map of service name to config
-// stack: full path to stack directory (used to derive project name)
+// stack: full path to stack directory (used to derive project name unless
+// the services carry an explicit project)
 // version: version number to tag built images with
 //
 // Returns the generated YAML as a string, or an error
 func GenerateCompose(services map[string]*config.Config, stack string, versions map[string]int) (string, error) {
+	return GenerateComposeWithMetadata(services, stack, versions, Metadata{})
+}
+
+// GenerateComposeWithMetadata is GenerateCompose with meta's deploy
+// details (generated-at, ssd version, sources) in the x-ssd block.
+// ManagedBy and Project are always set from the services.
+func GenerateComposeWithMetadata(services map[string]*config.Config, stack string, versions map[string]int, meta Metadata) (string, error) {
 	if len(services) == 0 {
 		return "", fmt.Errorf("at least one service is required")
 	}
 
-	project := filepath.Base(stack)
+	project := config.ProjectName(services, stack)
 	internalNetwork := project + "_internal"
+	meta.ManagedBy = "ssd"
+	meta.Project = project
 
 	// Check if any service needs Traefik (has a domain configured)
 	needsTraefik := false
@@ -113,6 +238,7 @@ func GenerateCompose(services map[string]*config.Config, stack string, versions
 	}
 
 	compose := ComposeFile{
+		SSD:      &meta,
 		Services: make(map[string]Service),
 		Networks: map[string]Network{
 			internalNetwork: {
@@ -124,6 +250,12 @@ func GenerateCompose(services map[string]*config.Config, stack string, versions
 		compose.Networks["traefik_web"] = Network{External: true}
 	}
 
+	// Pin the compose project name only when it was overridden; otherwise
+	// compose derives the same name from the stack directory.
+	if project != filepath.Base(stack) {
+		compose.Name = project
+	}
+
 	// Track which volumes are used
 	volumesUsed := make(map[string]bool)
 
@@ -135,34 +267,64 @@ func GenerateCompose(services map[string]*config.Config, stack string, versions
 		}
 
 		svc := Service{
-			Restart:  "unless-stopped",
-			EnvFile:  fmt.Sprintf("./%s.env", name),
-			Networks: networks,
-			Ports:    cfg.Ports,
+			Restart:    "unless-stopped",
+			EnvFile:    envFiles(name, cfg.EnvFiles),
+			Networks:   networks,
+			Ports:      cfg.Ports,
+			Profiles:   cfg.Profiles,
+			CapAdd:     cfg.CapAdd,
+			CapDrop:    cfg.CapDrop,
+			Privileged: cfg.Privileged,
+		}
+		if len(cfg.Ulimits) > 0 {
+			svc.Ulimits = make(map[string]ComposeUlimit, len(cfg.Ulimits))
+			for ulimit, value := range cfg.Ulimits {
+				svc.Ulimits[ulimit] = ComposeUlimit(value)
+			}
+		}
+		if len(cfg.Sysctls) > 0 {
+			svc.Sysctls = cfg.Sysctls
+		}
+		for _, host := range slices.Sorted(maps.Keys(cfg.ExtraHosts)) {
+			svc.ExtraHosts = append(svc.ExtraHosts, host+":"+cfg.ExtraHosts[host])
+		}
+		if cfg.ManualStart() {
+			svc.Restart = "no"
+			svc.Profiles = []string{manualStartProfile}
 		}
 
 		// Set image name
 		if cfg.IsPrebuilt() {
 			svc.Image = cfg.Image
 		} else {
-			svc.Image = fmt.Sprintf("ssd-%s-%s:%d", project, name, versions[name])
+			svc.Image = fmt.Sprintf("%s:%d", config.RenderImageName(cfg.ImageTemplate, project, name), versions[name])
 		}
 
 		// Add volume mounts
 		if len(cfg.Volumes) > 0 || len(cfg.Files) > 0 {
 			svc.Volumes = make([]string, 0, len(cfg.Volumes)+len(cfg.Files))
 			for volumeName, mountPath := range cfg.Volumes {
+				// mountPath may carry a ":mode" suffix, passed through as-is
+				if _, mode := config.SplitVolumeMount(mountPath); mode != "" {
+					if err := config.ValidateVolumeMode(mode); err != nil {
+						return "", fmt.Errorf("service %q: invalid mode for volume %q: %w", name, volumeName, err)
+					}
+				}
 				svc.Volumes = append(svc.Volumes, fmt.Sprintf("%s:%s", volumeName, mountPath))
-				volumesUsed[volumeName] = true
+				// Bind mounts are not declared in the top-level volumes section
+				if !config.IsBindMount(volumeName) {
+					volumesUsed[volumeName] = true
+				}
 			}
 			for localPath, containerPath := range cfg.Files {
 				svc.Volumes = append(svc.Volumes, fmt.Sprintf("./%s:%s", filepath.Base(localPath), containerPath))
 			}
 		}
 
-		// Add depends_on if configured
-		if len(cfg.DependsOn) > 0 {
-			svc.DependsOn = &ComposeDependsOn{Deps: cfg.DependsOn}
+		// Add depends_on if configured. Manual-start services are left
+		// out: compose would otherwise start them with their dependents.
+		if deps := startedDeps(cfg.DependsOn, services); len(deps) > 0 {
+			svc.DependsOn = &ComposeDependsOn{Deps: deps}
 		}
 
 		// Add healthcheck if configured. Two forms:
@@ -177,16 +339,17 @@ func GenerateCompose(services map[string]*config.Config, stack string, versions
 				test = []string{"CMD", "sh", "-c", cfg.HealthCheck.Cmd}
 			}
 			svc.HealthCheck = &HealthCheck{
-				Test:     test,
-				Interval: cfg.HealthCheck.Interval,
-				Timeout:  cfg.HealthCheck.Timeout,
-				Retries:  cfg.HealthCheck.Retries,
+				Test:        test,
+				Interval:    cfg.HealthCheck.Interval,
+				Timeout:     cfg.HealthCheck.Timeout,
+				Retries:     cfg.HealthCheck.Retries,
+				StartPeriod: cfg.HealthCheck.StartPeriod,
 			}
 		}
 
 		// Add Traefik labels if domain is configured
 		if cfg.PrimaryDomain() != "" {
-			svc.Labels = generateTraefikLabels(project, name, cfg)
+			svc.Labels = generateTraefikLabels(RouterName(project, name, stack), cfg)
 		}
 
 		// Emit deploy.replicas only when explicitly set to >1; Compose v2
@@ -194,8 +357,22 @@ func GenerateCompose(services map[string]*config.Config, stack string, versions
 		if r := cfg.Replicas(); r > 1 {
 			svc.Deploy = &ComposeDeploy{Replicas: r}
 		}
+		if res := composeResources(cfg); res != nil {
+			if svc.Deploy == nil {
+				svc.Deploy = &ComposeDeploy{}
+			}
+			svc.Deploy.Resources = res
+		}
 
 		compose.Services[name] = svc
+
+		if cfg.PreStart != nil {
+			jobName := config.PreStartJobName(name)
+			if _, clash := services[jobName]; clash {
+				return "", fmt.Errorf("service %q clashes with the pre_start job of %q", jobName, name)
+			}
+			compose.Services[jobName] = preStartJob(svc, cfg.PreStart, internalNetwork)
+		}
 	}
 
 	// Add volumes section if any volumes are used
@@ -215,33 +392,104 @@ func GenerateCompose(services map[string]*config.Config, stack string, versions
 	return string(data), nil
 }
 
+// preStartJobProfile keeps pre_start jobs out of 'docker compose up'; they
+// only run through 'docker compose run'.
+const preStartJobProfile = "ssd-jobs"
+
+// manualStartProfile keeps restart: false services out of 'docker compose
+// up'; they run only when targeted, e.g. 'docker compose run --rm <name>'.
+const manualStartProfile = "ssd-manual"
+
+// envFiles returns a service's env_file entries: its env_files, then its
+// own {service}.env last so values managed with ssd env win.
+func envFiles(name string, extra []string) ComposeEnvFiles {
+	files := make(ComposeEnvFiles, 0, len(extra)+1)
+	for _, f := range extra {
+		files = append(files, "./"+f)
+	}
+	return append(files, fmt.Sprintf("./%s.env", name))
+}
+
+// startedDeps returns deps without the services that have restart: false.
+func startedDeps(deps config.Dependencies, services map[string]*config.Config) config.Dependencies {
+	var out config.Dependencies
+	for _, d := range deps {
+		if dep, ok := services[d.Name]; ok && dep.ManualStart() {
+			continue
+		}
+		out = append(out, d)
+	}
+	return out
+}
+
+// preStartJob derives the compose service for a pre_start job from the
+// service it runs before: same image (unless overridden), env file,
+// volumes and dependencies, internal network only, never restarted.
+func preStartJob(svc Service, ps *config.PreStartConfig, internalNetwork string) Service {
+	job := Service{
+		Image:     svc.Image,
+		Profiles:  []string{preStartJobProfile},
+		Restart:   "no",
+		EnvFile:   svc.EnvFile,
+		Command:   []string{"sh", "-c", ps.Command},
+		Networks:  []string{internalNetwork},
+		Volumes:   svc.Volumes,
+		DependsOn: svc.DependsOn,
+	}
+	if ps.Image != "" {
+		job.Image = ps.Image
+	}
+	return job
+}
+
+// RouterName returns the base name of a service's Traefik routers,
+// services and middlewares: {project}-{service}-{id}. Traefik names are
+// global across stacks, so id, a short hash of the stack path, keeps two
+// stacks whose project and service names coincide from sharing routes.
+func RouterName(project, name, stack string) string {
+	return fmt.Sprintf("%s-%s-%s", project, name, stackID(stack))
+}
+
+// stackID returns the first 6 hex characters of the SHA-256 of the
+// cleaned stack path.
+func stackID(stack string) string {
+	sum := sha256.Sum256([]byte(filepath.Clean(stack)))
+	return hex.EncodeToString(sum[:3])
+}
+
+func routerMiddlewaresLabel(router, middlewares string) string {
+	return fmt.Sprintf("traefik.http.routers.%s.middlewares=%s", router, middlewares)
+}
+
+func routerPriorityLabel(router string, priority int) string {
+	return fmt.Sprintf("traefik.http.routers.%s.priority=%d", router, priority)
+}
+
 // generateTraefikLabels creates Traefik routing labels for a service
-// project: project name from stack path
-// name: service name
+// router: base router name from RouterName
 // cfg: service configuration
 //
 // Returns a slice of label strings in Docker Compose format
-func routerMiddlewaresLabel(router, middlewares string) string {
-	return fmt.Sprintf("traefik.http.routers.%s.middlewares=%s", router, middlewares)
-}
+func generateTraefikLabels(router string, cfg *config.Config) []string {
+	if cfg.IsTCPRouter() {
+		return generateTCPLabels(router, cfg)
+	}
 
-func generateTraefikLabels(project, name string, cfg *config.Config) []string {
 	primaryDomain := cfg.PrimaryDomain()
 	aliasDomains := cfg.AliasDomains()
 
-	labels := generatePrimaryDomainLabels(project, name, cfg, primaryDomain)
+	labels := generatePrimaryDomainLabels(router, cfg, primaryDomain)
 
 	// Add redirect labels for alias domains
 	for _, aliasDomain := range aliasDomains {
-		labels = append(labels, generateAliasRedirectLabels(project, name, cfg, aliasDomain, primaryDomain)...)
+		labels = append(labels, generateAliasRedirectLabels(router, cfg, aliasDomain, primaryDomain)...)
 	}
 
 	return labels
 }
 
 // generatePrimaryDomainLabels creates Traefik labels for the primary domain
-func generatePrimaryDomainLabels(project, name string, cfg *config.Config, domain string) []string {
-	routerName := fmt.Sprintf("%s-%s", project, name)
+func generatePrimaryDomainLabels(routerName string, cfg *config.Config, domain string) []string {
 
 	// Root path "/" is equivalent to no path (matches everything)
 	hasSubPath := cfg.Path != "" && cfg.Path != "/"
@@ -256,6 +504,9 @@ func generatePrimaryDomainLabels(project, name string, cfg *config.Config, domai
 		fmt.Sprintf("traefik.http.routers.%s.rule=%s", routerName, rule),
 		fmt.Sprintf("traefik.http.services.%s.loadbalancer.server.port=%d", routerName, cfg.Port),
 	}
+	if cfg.RoutePriority > 0 {
+		labels = append(labels, routerPriorityLabel(routerName, cfg.RoutePriority))
+	}
 
 	// StripPrefix middleware when sub-path routing is used (not for root "/")
 	stripMiddleware := ""
@@ -267,15 +518,19 @@ func generatePrimaryDomainLabels(project, name string, cfg *config.Config, domai
 		)
 	}
 
+	entrypoints, redirectFromWeb := httpEntrypoints(cfg)
 	if cfg.UseHTTPS() {
 		if stripMiddleware != "" {
 			labels = append(labels, routerMiddlewaresLabel(routerName, stripMiddleware))
 		}
 		labels = append(labels,
-			fmt.Sprintf("traefik.http.routers.%s.entrypoints=websecure", routerName),
+			fmt.Sprintf("traefik.http.routers.%s.entrypoints=%s", routerName, entrypoints),
 			fmt.Sprintf("traefik.http.routers.%s.tls=true", routerName),
 			fmt.Sprintf("traefik.http.routers.%s.tls.certresolver=letsencrypt", routerName),
 		)
+		if !redirectFromWeb {
+			return labels
+		}
 
 		httpRouterName := fmt.Sprintf("%s-http", routerName)
 		httpMiddlewares := "redirect-to-https"
@@ -288,24 +543,78 @@ func generatePrimaryDomainLabels(project, name string, cfg *config.Config, domai
 			routerMiddlewaresLabel(httpRouterName, httpMiddlewares),
 			"traefik.http.middlewares.redirect-to-https.redirectscheme.scheme=https",
 		)
+		if cfg.RoutePriority > 0 {
+			labels = append(labels, routerPriorityLabel(httpRouterName, cfg.RoutePriority))
+		}
 	} else {
 		if stripMiddleware != "" {
 			labels = append(labels, routerMiddlewaresLabel(routerName, stripMiddleware))
 		}
 		labels = append(labels,
-			fmt.Sprintf("traefik.http.routers.%s.entrypoints=web", routerName),
+			fmt.Sprintf("traefik.http.routers.%s.entrypoints=%s", routerName, entrypoints),
 		)
 	}
 
 	return labels
 }
 
+// httpEntrypoints returns the entrypoints, comma-separated, that a
+// service's HTTP routers attach to, and whether the router on web that
+// redirects plain HTTP to HTTPS applies. By default that is websecure plus
+// the redirect with HTTPS, and web without. Custom entrypoints replace
+// both; the redirect is kept only when they include websecure, where it
+// sends clients, and not web itself.
+func httpEntrypoints(cfg *config.Config) (string, bool) {
+	if len(cfg.Entrypoints) == 0 {
+		if cfg.UseHTTPS() {
+			return "websecure", true
+		}
+		return "web", false
+	}
+	redirect := cfg.UseHTTPS() && slices.Contains(cfg.Entrypoints, "websecure") && !slices.Contains(cfg.Entrypoints, "web")
+	return strings.Join(cfg.Entrypoints, ","), redirect
+}
+
+// generateTCPLabels creates Traefik TCP router labels on the tcp
+// entrypoint. With HTTPS, Traefik terminates TLS and matches every domain
+// by SNI; without it there is no SNI to match, so the router takes all
+// connections on the entrypoint (HostSNI(`*`)).
+func generateTCPLabels(routerName string, cfg *config.Config) []string {
+
+	rule := "HostSNI(`*`)"
+	if cfg.UseHTTPS() {
+		domains := cfg.Domains
+		if cfg.Domain != "" {
+			domains = []string{cfg.Domain}
+		}
+		rules := make([]string, 0, len(domains))
+		for _, d := range domains {
+			rules = append(rules, fmt.Sprintf("HostSNI(`%s`)", d))
+		}
+		rule = strings.Join(rules, " || ")
+	}
+
+	labels := []string{
+		"traefik.enable=true",
+		fmt.Sprintf("traefik.tcp.routers.%s.rule=%s", routerName, rule),
+		fmt.Sprintf("traefik.tcp.routers.%s.entrypoints=%s", routerName, TCPEntrypoint),
+		fmt.Sprintf("traefik.tcp.services.%s.loadbalancer.server.port=%d", routerName, cfg.Port),
+	}
+	if cfg.UseHTTPS() {
+		labels = append(labels,
+			fmt.Sprintf("traefik.tcp.routers.%s.tls=true", routerName),
+			fmt.Sprintf("traefik.tcp.routers.%s.tls.certresolver=letsencrypt", routerName),
+		)
+	}
+	return labels
+}
+
 // generateAliasRedirectLabels creates Traefik labels to redirect an alias domain to the primary domain
-func generateAliasRedirectLabels(project, name string, cfg *config.Config, aliasDomain, primaryDomain string) []string {
+func generateAliasRedirectLabels(router string, cfg *config.Config, aliasDomain, primaryDomain string) []string {
 	// Sanitize domain for use in label names (replace dots with hyphens)
 	sanitizedAlias := strings.ReplaceAll(aliasDomain, ".", "-")
-	routerName := fmt.Sprintf("%s-%s-alias-%s", project, name, sanitizedAlias)
-	middlewareName := fmt.Sprintf("%s-%s-redirect-%s", project, name, sanitizedAlias)
+	routerName := fmt.Sprintf("%s-alias-%s", router, sanitizedAlias)
+	middlewareName := fmt.Sprintf("%s-redirect-%s", router, sanitizedAlias)
 
 	scheme := "http"
 	if cfg.UseHTTPS() {
@@ -324,34 +633,45 @@ func generateAliasRedirectLabels(project, name string, cfg *config.Config, alias
 		fmt.Sprintf("traefik.http.middlewares.%s.redirectregex.permanent=false", middlewareName),
 	}
 
+	entrypoints, redirectFromWeb := httpEntrypoints(cfg)
 	if cfg.UseHTTPS() {
 		labels = append(labels,
-			fmt.Sprintf("traefik.http.routers.%s.entrypoints=websecure", routerName),
+			fmt.Sprintf("traefik.http.routers.%s.entrypoints=%s", routerName, entrypoints),
 			fmt.Sprintf("traefik.http.routers.%s.tls=true", routerName),
 			fmt.Sprintf("traefik.http.routers.%s.tls.certresolver=letsencrypt", routerName),
 		)
 
 		// HTTP router for alias (redirects to HTTPS first, then HTTPS redirects to primary domain)
-		httpRouterName := fmt.Sprintf("%s-http", routerName)
-		labels = append(labels,
-			fmt.Sprintf("traefik.http.routers.%s.rule=Host(`%s`)", httpRouterName, aliasDomain),
-			fmt.Sprintf("traefik.http.routers.%s.entrypoints=web", httpRouterName),
-			fmt.Sprintf("traefik.http.routers.%s.middlewares=redirect-to-https", httpRouterName),
-		)
+		if redirectFromWeb {
+			httpRouterName := fmt.Sprintf("%s-http", routerName)
+			labels = append(labels,
+				fmt.Sprintf("traefik.http.routers.%s.rule=Host(`%s`)", httpRouterName, aliasDomain),
+				fmt.Sprintf("traefik.http.routers.%s.entrypoints=web", httpRouterName),
+				fmt.Sprintf("traefik.http.routers.%s.middlewares=redirect-to-https", httpRouterName),
+			)
+		}
 	} else {
 		labels = append(labels,
-			fmt.Sprintf("traefik.http.routers.%s.entrypoints=web", routerName),
+			fmt.Sprintf("traefik.http.routers.%s.entrypoints=%s", routerName, entrypoints),
 		)
 	}
 
 	return labels
 }
 
+// TCPEntrypoint is the Traefik entrypoint TCP routers (router: tcp) attach
+// to, listening on TCPEntrypointPort.
+const (
+	TCPEntrypoint     = "tcp"
+	TCPEntrypointPort = 8443
+)
+
 // GenerateTraefikCompose generates a docker-compose.yaml for Traefik reverse proxy.
 // email: email address for ACME/Let's Encrypt certificate registration
 //
 // Returns a compose file configured for:
 // - Traefik v3 with HTTP (80) and HTTPS (443) entrypoints
+// - A TCP entrypoint on TCPEntrypointPort for router: tcp services
 // - Let's Encrypt ACME with provided email
 // - Certificate resolver named "letsencrypt"
 // - Volume for acme.json persistence
@@ -365,6 +685,7 @@ func GenerateTraefikCompose(email string) string {
 				Ports: []string{
 					"80:80",
 					"443:443",
+					fmt.Sprintf("%d:%d", TCPEntrypointPort, TCPEntrypointPort),
 				},
 				Command: []string{
 					"--ping=true",
@@ -374,6 +695,7 @@ func GenerateTraefikCompose(email string) string {
 					"--providers.docker.network=traefik_web",
 					"--entrypoints.web.address=:80",
 					"--entrypoints.websecure.address=:443",
+					fmt.Sprintf("--entrypoints.%s.address=:%d", TCPEntrypoint, TCPEntrypointPort),
 					"--certificatesresolvers.letsencrypt.acme.email=" + email,
 					"--certificatesresolvers.letsencrypt.acme.storage=/acme.json",
 					"--certificatesresolvers.letsencrypt.acme.httpchallenge.entrypoint=web",
@@ -430,3 +752,19 @@ func AtomicWrite(content, destPath string) error {
 
 	return nil
 }
+
+// composeResources builds deploy.resources from the service's CPU and
+// memory limits and reservations, or returns nil when none are set.
+func composeResources(cfg *config.Config) *ComposeResources {
+	var res ComposeResources
+	if cfg.CPULimit != "" || cfg.MemoryLimit != "" {
+		res.Limits = &ComposeResourceSpec{CPUs: cfg.CPULimit, Memory: cfg.MemoryLimit}
+	}
+	if cfg.CPUReservation != "" || cfg.MemoryReservation != "" {
+		res.Reservations = &ComposeResourceSpec{CPUs: cfg.CPUReservation, Memory: cfg.MemoryReservation}
+	}
+	if res.Limits == nil && res.Reservations == nil {
+		return nil
+	}
+	return &res
+}
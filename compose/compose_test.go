@@ -2,6 +2,8 @@ package compose
 
 import (
 	"os"
+	"reflect"
+	"slices"
 	"strings"
 	"testing"
 
@@ -202,6 +204,33 @@ func TestGenerateCompose_PrebuiltImage(t *testing.T) {
 	}
 }
 
+func TestGenerateCompose_DigestImage(t *testing.T) {
+	image := "postgres@sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+	services := map[string]*config.Config{
+		"postgres": {
+			Name:   "postgres",
+			Server: "myserver",
+			Stack:  "/stacks/myapp",
+			Image:  image,
+		},
+	}
+
+	result, err := GenerateCompose(services, "/stacks/myapp", map[string]int{"postgres": 1})
+	if err != nil {
+		t.Fatalf("GenerateCompose failed: %v", err)
+	}
+
+	var parsed map[string]interface{}
+	if err := yaml.Unmarshal([]byte(result), &parsed); err != nil {
+		t.Fatalf("Generated YAML is invalid: %v", err)
+	}
+
+	postgresService := parsed["services"].(map[string]interface{})["postgres"].(map[string]interface{})
+	if postgresService["image"] != image {
+		t.Errorf("postgres image = %v, want %s", postgresService["image"], image)
+	}
+}
+
 func TestGenerateCompose_WithVolumes(t *testing.T) {
 	services := map[string]*config.Config{
 		"postgres": {
@@ -253,6 +282,137 @@ func TestGenerateCompose_WithVolumes(t *testing.T) {
 	}
 }
 
+func TestGenerateCompose_BindMountVsNamedVolume(t *testing.T) {
+	services := map[string]*config.Config{
+		"web": {
+			Name:   "web",
+			Server: "myserver",
+			Stack:  "/stacks/myapp",
+			Volumes: map[string]string{
+				"cache":        "/app/cache",
+				"/srv/uploads": "/app/uploads",
+			},
+		},
+	}
+
+	result, err := GenerateCompose(services, "/stacks/myapp", map[string]int{"web": 1})
+	if err != nil {
+		t.Fatalf("GenerateCompose failed: %v", err)
+	}
+
+	var parsed map[string]interface{}
+	if err := yaml.Unmarshal([]byte(result), &parsed); err != nil {
+		t.Fatalf("Generated YAML is invalid: %v", err)
+	}
+
+	webService := parsed["services"].(map[string]interface{})["web"].(map[string]interface{})
+	mounts := make(map[string]bool)
+	for _, v := range webService["volumes"].([]interface{}) {
+		mounts[v.(string)] = true
+	}
+	if !mounts["cache:/app/cache"] {
+		t.Error("named volume mount missing")
+	}
+	if !mounts["/srv/uploads:/app/uploads"] {
+		t.Error("bind mount missing")
+	}
+
+	topVolumes, ok := parsed["volumes"].(map[string]interface{})
+	if !ok {
+		t.Fatal("top-level volumes section missing")
+	}
+	if _, ok := topVolumes["cache"]; !ok {
+		t.Error("named volume 'cache' missing from top-level volumes")
+	}
+	if len(topVolumes) != 1 {
+		t.Errorf("top-level volumes = %v, want only the named volume", topVolumes)
+	}
+}
+
+func TestGenerateCompose_BindMountOnlyHasNoTopLevelVolumes(t *testing.T) {
+	services := map[string]*config.Config{
+		"web": {
+			Name:    "web",
+			Server:  "myserver",
+			Stack:   "/stacks/myapp",
+			Volumes: map[string]string{"/srv/uploads": "/app/uploads"},
+		},
+	}
+
+	result, err := GenerateCompose(services, "/stacks/myapp", map[string]int{"web": 1})
+	if err != nil {
+		t.Fatalf("GenerateCompose failed: %v", err)
+	}
+
+	var parsed map[string]interface{}
+	if err := yaml.Unmarshal([]byte(result), &parsed); err != nil {
+		t.Fatalf("Generated YAML is invalid: %v", err)
+	}
+	if _, ok := parsed["volumes"]; ok {
+		t.Errorf("top-level volumes should be omitted for bind mounts, got %v", parsed["volumes"])
+	}
+}
+
+func TestGenerateCompose_VolumeModes(t *testing.T) {
+	services := map[string]*config.Config{
+		"web": {
+			Name:   "web",
+			Server: "myserver",
+			Stack:  "/stacks/myapp",
+			Volumes: map[string]string{
+				"assets":       "/app/assets:ro",
+				"cache":        "/app/cache",
+				"/srv/uploads": "/app/uploads:ro,z",
+			},
+		},
+	}
+
+	result, err := GenerateCompose(services, "/stacks/myapp", map[string]int{"web": 1})
+	if err != nil {
+		t.Fatalf("GenerateCompose failed: %v", err)
+	}
+
+	var parsed map[string]interface{}
+	if err := yaml.Unmarshal([]byte(result), &parsed); err != nil {
+		t.Fatalf("Generated YAML is invalid: %v", err)
+	}
+
+	webService := parsed["services"].(map[string]interface{})["web"].(map[string]interface{})
+	mounts := make(map[string]bool)
+	for _, v := range webService["volumes"].([]interface{}) {
+		mounts[v.(string)] = true
+	}
+	for _, want := range []string{"assets:/app/assets:ro", "cache:/app/cache", "/srv/uploads:/app/uploads:ro,z"} {
+		if !mounts[want] {
+			t.Errorf("mount %q missing from %v", want, mounts)
+		}
+	}
+
+	topVolumes := parsed["volumes"].(map[string]interface{})
+	if _, ok := topVolumes["assets"]; !ok {
+		t.Error("named volume 'assets' missing from top-level volumes")
+	}
+}
+
+func TestGenerateCompose_InvalidVolumeMode(t *testing.T) {
+	services := map[string]*config.Config{
+		"web": {
+			Name:    "web",
+			Server:  "myserver",
+			Stack:   "/stacks/myapp",
+			Volumes: map[string]string{"data": "/app/data:readonly"},
+		},
+	}
+
+	_, err := GenerateCompose(services, "/stacks/myapp", map[string]int{"web": 1})
+	if err == nil {
+		t.Fatal("expected error for invalid volume mode")
+	}
+	if !strings.Contains(err.Error(), "invalid mode for volume") {
+		t.Errorf("error = %q, want invalid mode message", err.Error())
+	}
+}
+
 func TestGenerateCompose_EmptyServices(t *testing.T) {
 	services := map[string]*config.Config{}
 
@@ -510,6 +670,44 @@ func TestGenerateCompose_WithHealthCheck(t *testing.T) {
 	}
 }
 
+func TestGenerateCompose_HealthCheckStartPeriod(t *testing.T) {
+	services := map[string]*config.Config{
+		"web": {
+			Name:  "web",
+			Stack: "/stacks/myapp",
+			HealthCheck: &config.HealthCheck{
+				Cmd:         "exit 0",
+				StartPeriod: "40s",
+			},
+		},
+		"api": {
+			Name:        "api",
+			Stack:       "/stacks/myapp",
+			HealthCheck: &config.HealthCheck{Cmd: "exit 0"},
+		},
+	}
+
+	result, err := GenerateCompose(services, "/stacks/myapp", map[string]int{"web": 1, "api": 1})
+	if err != nil {
+		t.Fatalf("GenerateCompose failed: %v", err)
+	}
+
+	var parsed map[string]interface{}
+	if err := yaml.Unmarshal([]byte(result), &parsed); err != nil {
+		t.Fatalf("Generated YAML is invalid: %v", err)
+	}
+	servicesMap := parsed["services"].(map[string]interface{})
+
+	web := servicesMap["web"].(map[string]interface{})["healthcheck"].(map[string]interface{})
+	if web["start_period"] != "40s" {
+		t.Errorf("web start_period = %v, want 40s", web["start_period"])
+	}
+	api := servicesMap["api"].(map[string]interface{})["healthcheck"].(map[string]interface{})
+	if _, ok := api["start_period"]; ok {
+		t.Errorf("api should not emit start_period, got %v", api["start_period"])
+	}
+}
+
 func TestGenerateCompose_WithExecHealthCheck(t *testing.T) {
 	// Direct exec form: scratch images (no shell) need this. Renders to
 	// ["CMD", arg0, arg1, ...] with no sh -c wrap.
@@ -617,7 +815,7 @@ func TestGenerateCompose_WithDomainAndHTTPS(t *testing.T) {
 
 	labelStrings := make([]string, len(labels))
 	for i, label := range labels {
-		labelStrings[i] = label.(string)
+		labelStrings[i] = withoutStackID(label.(string), "/stacks/myapp")
 	}
 
 	// Required labels for HTTPS service
@@ -648,6 +846,154 @@ func TestGenerateCompose_WithDomainAndHTTPS(t *testing.T) {
 	}
 }
 
+func serviceLabels(t *testing.T, result, name string) []interface{} {
+	t.Helper()
+	services := parseYAML(t, result)["services"].(map[string]interface{})
+	labels, ok := services[name].(map[string]interface{})["labels"].([]interface{})
+	if !ok {
+		t.Fatal("labels missing or not an array")
+	}
+	for i, label := range labels {
+		labels[i] = withoutStackID(label.(string), "/stacks/myapp")
+	}
+	return labels
+}
+
+// withoutStackID drops the stack hash RouterName appends, so label
+// expectations can use the readable {project}-{service} names.
+func withoutStackID(label, stack string) string {
+	return strings.ReplaceAll(label, "-"+stackID(stack), "")
+}
+
+func TestRouterName_DistinctAcrossStacks(t *testing.T) {
+	services := func(stack string) map[string]*config.Config {
+		return map[string]*config.Config{
+			"web": {Name: "web", Stack: stack, Domain: "example.com", Port: 3000},
+		}
+	}
+
+	a, err := GenerateCompose(services("/a/web"), "/a/web", map[string]int{"web": 1})
+	if err != nil {
+		t.Fatalf("GenerateCompose failed: %v", err)
+	}
+	b, err := GenerateCompose(services("/b/web"), "/b/web", map[string]int{"web": 1})
+	if err != nil {
+		t.Fatalf("GenerateCompose failed: %v", err)
+	}
+
+	routerA := RouterName("web", "web", "/a/web")
+	routerB := RouterName("web", "web", "/b/web")
+	if routerA == routerB {
+		t.Fatalf("stacks /a/web and /b/web share router name %q", routerA)
+	}
+	if !strings.HasPrefix(routerA, "web-web-") || len(routerA) != len("web-web-")+6 {
+		t.Errorf("router name %q should be web-web-<6 hex chars>", routerA)
+	}
+	if !strings.Contains(a, "traefik.http.routers."+routerA+".rule=") || strings.Contains(a, routerB) {
+		t.Errorf("stack /a/web should only use router %s", routerA)
+	}
+	if !strings.Contains(b, "traefik.http.routers."+routerB+".rule=") || strings.Contains(b, routerA) {
+		t.Errorf("stack /b/web should only use router %s", routerB)
+	}
+}
+
+func TestRouterName_Stable(t *testing.T) {
+	if RouterName("myapp", "web", "/stacks/myapp") != RouterName("myapp", "web", "/stacks/myapp/") {
+		t.Error("router name must not depend on a trailing slash in the stack path")
+	}
+}
+
+func TestGenerateCompose_TCPRouter(t *testing.T) {
+	services := map[string]*config.Config{
+		"pg": {
+			Name:    "pg",
+			Stack:   "/stacks/myapp",
+			Image:   "postgres:16",
+			Domains: []string{"db.example.com", "db2.example.com"},
+			Router:  "tcp",
+			Port:    5432,
+		},
+	}
+
+	result, err := GenerateCompose(services, "/stacks/myapp", map[string]int{})
+	if err != nil {
+		t.Fatalf("GenerateCompose failed: %v", err)
+	}
+	labels := serviceLabels(t, result, "pg")
+
+	expected := []string{
+		"traefik.enable=true",
+		"traefik.tcp.routers.myapp-pg.rule=HostSNI(`db.example.com`) || HostSNI(`db2.example.com`)",
+		"traefik.tcp.routers.myapp-pg.entrypoints=tcp",
+		"traefik.tcp.routers.myapp-pg.tls=true",
+		"traefik.tcp.routers.myapp-pg.tls.certresolver=letsencrypt",
+		"traefik.tcp.services.myapp-pg.loadbalancer.server.port=5432",
+	}
+	for _, label := range expected {
+		if !containsString(labels, label) {
+			t.Errorf("missing label %q", label)
+		}
+	}
+	if len(labels) != len(expected) {
+		t.Errorf("got %d labels, want %d: %v", len(labels), len(expected), labels)
+	}
+	if containsSubstring(labels, "traefik.http.") {
+		t.Error("tcp router must not emit HTTP labels")
+	}
+}
+
+func TestGenerateCompose_TCPRouter_NoHTTPS(t *testing.T) {
+	httpsDisabled := false
+	services := map[string]*config.Config{
+		"game": {
+			Name:   "game",
+			Stack:  "/stacks/myapp",
+			Domain: "play.example.com",
+			Router: "tcp",
+			HTTPS:  &httpsDisabled,
+			Port:   7777,
+		},
+	}
+
+	result, err := GenerateCompose(services, "/stacks/myapp", map[string]int{"game": 1})
+	if err != nil {
+		t.Fatalf("GenerateCompose failed: %v", err)
+	}
+	labels := serviceLabels(t, result, "game")
+
+	if !containsString(labels, "traefik.tcp.routers.myapp-game.rule=HostSNI(`*`)") {
+		t.Errorf("want catch-all HostSNI rule without TLS, got %v", labels)
+	}
+	if containsSubstring(labels, ".tls") {
+		t.Errorf("no TLS labels expected without https, got %v", labels)
+	}
+}
+
+func TestGenerateCompose_HTTPRouterExplicit(t *testing.T) {
+	services := map[string]*config.Config{
+		"web": {
+			Name:   "web",
+			Stack:  "/stacks/myapp",
+			Domain: "example.com",
+			Router: "http",
+			Port:   3000,
+		},
+	}
+
+	result, err := GenerateCompose(services, "/stacks/myapp", map[string]int{"web": 1})
+	if err != nil {
+		t.Fatalf("GenerateCompose failed: %v", err)
+	}
+	labels := serviceLabels(t, result, "web")
+
+	if !containsString(labels, "traefik.http.routers.myapp-web.rule=Host(`example.com`)") {
+		t.Errorf("router: http must keep the HTTP labels, got %v", labels)
+	}
+	if containsSubstring(labels, "traefik.tcp.") {
+		t.Error("http router must not emit TCP labels")
+	}
+}
+
 func TestGenerateCompose_WithDomainNoHTTPS(t *testing.T) {
 	falseVal := false
 	services := map[string]*config.Config{
@@ -682,7 +1028,7 @@ func TestGenerateCompose_WithDomainNoHTTPS(t *testing.T) {
 
 	labelStrings := make([]string, len(labels))
 	for i, label := range labels {
-		labelStrings[i] = label.(string)
+		labelStrings[i] = withoutStackID(label.(string), "/stacks/myapp")
 	}
 
 	// Required labels for HTTP-only service
@@ -759,7 +1105,7 @@ func TestGenerateCompose_WithDomainAndPath_HTTPS(t *testing.T) {
 
 	labelStrings := make([]string, len(labels))
 	for i, label := range labels {
-		labelStrings[i] = label.(string)
+		labelStrings[i] = withoutStackID(label.(string), "/stacks/myapp")
 	}
 
 	expectedLabels := []string{
@@ -791,21 +1137,30 @@ func TestGenerateCompose_WithDomainAndPath_HTTPS(t *testing.T) {
 	}
 }
 
-func TestGenerateCompose_WithDomainAndPath_NoHTTPS(t *testing.T) {
-	falseVal := false
+func TestGenerateCompose_RoutePriority(t *testing.T) {
+	trueVal := true
 	services := map[string]*config.Config{
 		"api": {
-			Name:   "api",
+			Name:          "api",
+			Server:        "myserver",
+			Stack:         "/stacks/myapp",
+			Domain:        "example.com",
+			Path:          "/api/v2",
+			HTTPS:         &trueVal,
+			Port:          8080,
+			RoutePriority: 100,
+		},
+		"web": {
+			Name:   "web",
 			Server: "myserver",
 			Stack:  "/stacks/myapp",
 			Domain: "example.com",
-			Path:   "/api",
-			HTTPS:  &falseVal,
-			Port:   8080,
+			HTTPS:  &trueVal,
+			Port:   3000,
 		},
 	}
 
-	result, err := GenerateCompose(services, "/stacks/myapp", map[string]int{"api": 1})
+	result, err := GenerateCompose(services, "/stacks/myapp", map[string]int{"api": 1, "web": 1})
 	if err != nil {
 		t.Fatalf("GenerateCompose failed: %v", err)
 	}
@@ -814,43 +1169,182 @@ func TestGenerateCompose_WithDomainAndPath_NoHTTPS(t *testing.T) {
 	if err := yaml.Unmarshal([]byte(result), &parsed); err != nil {
 		t.Fatalf("Generated YAML is invalid: %v", err)
 	}
-
 	servicesMap := parsed["services"].(map[string]interface{})
-	apiService := servicesMap["api"].(map[string]interface{})
-
-	labels, ok := apiService["labels"].([]interface{})
-	if !ok {
-		t.Fatal("labels missing or not an array")
-	}
 
-	labelStrings := make([]string, len(labels))
-	for i, label := range labels {
-		labelStrings[i] = label.(string)
-	}
-
-	expectedLabels := []string{
-		"traefik.enable=true",
-		"traefik.http.routers.myapp-api.rule=Host(`example.com`) && PathPrefix(`/api`)",
-		"traefik.http.services.myapp-api.loadbalancer.server.port=8080",
-		"traefik.http.middlewares.myapp-api-strip.stripprefix.prefixes=/api",
-		"traefik.http.routers.myapp-api.middlewares=myapp-api-strip",
-		"traefik.http.routers.myapp-api.entrypoints=web",
+	labelsOf := func(name string) []string {
+		labels := servicesMap[name].(map[string]interface{})["labels"].([]interface{})
+		out := make([]string, len(labels))
+		for i, label := range labels {
+			out[i] = withoutStackID(label.(string), "/stacks/myapp")
+		}
+		return out
 	}
 
-	for _, expected := range expectedLabels {
-		found := false
-		for _, actual := range labelStrings {
-			if actual == expected {
-				found = true
-				break
-			}
+	api := labelsOf("api")
+	for _, expected := range []string{
+		"traefik.http.routers.myapp-api.priority=100",
+		"traefik.http.routers.myapp-api-http.priority=100",
+	} {
+		if !slices.Contains(api, expected) {
+			t.Errorf("Expected label %q not found in %v", expected, api)
 		}
-		if !found {
-			t.Errorf("Expected label %q not found", expected)
+	}
+	for _, label := range labelsOf("web") {
+		if strings.Contains(label, ".priority=") {
+			t.Errorf("unexpected priority label without route_priority: %q", label)
 		}
 	}
+}
 
-	// No TLS labels should be present
+func TestGenerateCompose_Entrypoints(t *testing.T) {
+	trueVal, falseVal := true, false
+	tests := []struct {
+		name        string
+		entrypoints []string
+		https       *bool
+		want        []string
+		wantMissing []string
+	}{
+		{
+			name:        "custom entrypoint replaces websecure and drops the web redirect",
+			entrypoints: []string{"internal"},
+			https:       &trueVal,
+			want: []string{
+				"traefik.http.routers.myapp-api.entrypoints=internal",
+				"traefik.http.routers.myapp-api.tls=true",
+			},
+			wantMissing: []string{"traefik.http.routers.myapp-api-http.entrypoints=web"},
+		},
+		{
+			name:        "websecure among custom entrypoints keeps the web redirect",
+			entrypoints: []string{"websecure", "internal"},
+			https:       &trueVal,
+			want: []string{
+				"traefik.http.routers.myapp-api.entrypoints=websecure,internal",
+				"traefik.http.routers.myapp-api-http.entrypoints=web",
+				"traefik.http.routers.myapp-api-http.middlewares=redirect-to-https",
+			},
+		},
+		{
+			name:        "plain HTTP on a custom entrypoint",
+			entrypoints: []string{"internal"},
+			https:       &falseVal,
+			want:        []string{"traefik.http.routers.myapp-api.entrypoints=internal"},
+			wantMissing: []string{"traefik.http.routers.myapp-api.tls=true"},
+		},
+		{
+			name:  "default",
+			https: &trueVal,
+			want: []string{
+				"traefik.http.routers.myapp-api.entrypoints=websecure",
+				"traefik.http.routers.myapp-api-http.entrypoints=web",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			services := map[string]*config.Config{
+				"api": {
+					Name:        "api",
+					Server:      "myserver",
+					Stack:       "/stacks/myapp",
+					Domain:      "example.com",
+					HTTPS:       tt.https,
+					Port:        8080,
+					Entrypoints: tt.entrypoints,
+				},
+			}
+
+			result, err := GenerateCompose(services, "/stacks/myapp", map[string]int{"api": 1})
+			if err != nil {
+				t.Fatalf("GenerateCompose failed: %v", err)
+			}
+
+			var parsed map[string]interface{}
+			if err := yaml.Unmarshal([]byte(result), &parsed); err != nil {
+				t.Fatalf("Generated YAML is invalid: %v", err)
+			}
+			labels := parsed["services"].(map[string]interface{})["api"].(map[string]interface{})["labels"].([]interface{})
+			labelStrings := make([]string, len(labels))
+			for i, label := range labels {
+				labelStrings[i] = withoutStackID(label.(string), "/stacks/myapp")
+			}
+
+			for _, expected := range tt.want {
+				if !slices.Contains(labelStrings, expected) {
+					t.Errorf("Expected label %q not found in %v", expected, labelStrings)
+				}
+			}
+			for _, unexpected := range tt.wantMissing {
+				if slices.Contains(labelStrings, unexpected) {
+					t.Errorf("Unexpected label %q", unexpected)
+				}
+			}
+		})
+	}
+}
+
+func TestGenerateCompose_WithDomainAndPath_NoHTTPS(t *testing.T) {
+	falseVal := false
+	services := map[string]*config.Config{
+		"api": {
+			Name:   "api",
+			Server: "myserver",
+			Stack:  "/stacks/myapp",
+			Domain: "example.com",
+			Path:   "/api",
+			HTTPS:  &falseVal,
+			Port:   8080,
+		},
+	}
+
+	result, err := GenerateCompose(services, "/stacks/myapp", map[string]int{"api": 1})
+	if err != nil {
+		t.Fatalf("GenerateCompose failed: %v", err)
+	}
+
+	var parsed map[string]interface{}
+	if err := yaml.Unmarshal([]byte(result), &parsed); err != nil {
+		t.Fatalf("Generated YAML is invalid: %v", err)
+	}
+
+	servicesMap := parsed["services"].(map[string]interface{})
+	apiService := servicesMap["api"].(map[string]interface{})
+
+	labels, ok := apiService["labels"].([]interface{})
+	if !ok {
+		t.Fatal("labels missing or not an array")
+	}
+
+	labelStrings := make([]string, len(labels))
+	for i, label := range labels {
+		labelStrings[i] = withoutStackID(label.(string), "/stacks/myapp")
+	}
+
+	expectedLabels := []string{
+		"traefik.enable=true",
+		"traefik.http.routers.myapp-api.rule=Host(`example.com`) && PathPrefix(`/api`)",
+		"traefik.http.services.myapp-api.loadbalancer.server.port=8080",
+		"traefik.http.middlewares.myapp-api-strip.stripprefix.prefixes=/api",
+		"traefik.http.routers.myapp-api.middlewares=myapp-api-strip",
+		"traefik.http.routers.myapp-api.entrypoints=web",
+	}
+
+	for _, expected := range expectedLabels {
+		found := false
+		for _, actual := range labelStrings {
+			if actual == expected {
+				found = true
+				break
+			}
+		}
+		if !found {
+			t.Errorf("Expected label %q not found", expected)
+		}
+	}
+
+	// No TLS labels should be present
 	for _, actual := range labelStrings {
 		if strings.Contains(actual, "tls") || strings.Contains(actual, "certresolver") {
 			t.Errorf("Unexpected TLS label in HTTP-only path config: %q", actual)
@@ -892,7 +1386,7 @@ func TestGenerateCompose_WithDomainAndRootPath_HTTPS(t *testing.T) {
 
 	labelStrings := make([]string, len(labels))
 	for i, label := range labels {
-		labelStrings[i] = label.(string)
+		labelStrings[i] = withoutStackID(label.(string), "/stacks/myapp")
 	}
 
 	// Root path should use Host-only rule (PathPrefix('/') is redundant)
@@ -1233,8 +1727,8 @@ func checkTraefikPorts(t *testing.T, svc map[string]interface{}) {
 	if !ok {
 		t.Fatal("ports missing or not an array")
 	}
-	if len(ports) != 2 {
-		t.Fatalf("ports count = %d, want 2", len(ports))
+	if len(ports) != 3 {
+		t.Fatalf("ports count = %d, want 3", len(ports))
 	}
 	if !containsString(ports, "80:80") {
 		t.Error("port 80:80 missing")
@@ -1242,6 +1736,9 @@ func checkTraefikPorts(t *testing.T, svc map[string]interface{}) {
 	if !containsString(ports, "443:443") {
 		t.Error("port 443:443 missing")
 	}
+	if !containsString(ports, "8443:8443") {
+		t.Error("port 8443:8443 (tcp entrypoint) missing")
+	}
 }
 
 func checkTraefikCommand(t *testing.T, svc map[string]interface{}, email string) {
@@ -1259,6 +1756,9 @@ func checkTraefikCommand(t *testing.T, svc map[string]interface{}, email string)
 	if !containsString(command, "--ping=true") {
 		t.Error("--ping=true missing from command (required for healthcheck)")
 	}
+	if !containsString(command, "--entrypoints.tcp.address=:8443") {
+		t.Error("tcp entrypoint missing from command")
+	}
 }
 
 func checkTraefikVolumes(t *testing.T, svc map[string]interface{}) {
@@ -1376,7 +1876,7 @@ func TestGenerateCompose_WithMultipleDomainsHTTPS(t *testing.T) {
 
 	labelStrings := make([]string, len(labels))
 	for i, label := range labels {
-		labelStrings[i] = label.(string)
+		labelStrings[i] = withoutStackID(label.(string), "/stacks/myapp")
 	}
 
 	// Primary domain router labels (example.com)
@@ -1470,7 +1970,7 @@ func TestGenerateCompose_WithMultipleDomainsNoHTTPS(t *testing.T) {
 
 	labelStrings := make([]string, len(labels))
 	for i, label := range labels {
-		labelStrings[i] = label.(string)
+		labelStrings[i] = withoutStackID(label.(string), "/stacks/myapp")
 	}
 
 	// Primary domain router (HTTP only)
@@ -1540,7 +2040,7 @@ func TestGenerateCompose_WithSingleDomainInDomainsArray(t *testing.T) {
 
 	labelStrings := make([]string, len(labels))
 	for i, label := range labels {
-		labelStrings[i] = label.(string)
+		labelStrings[i] = withoutStackID(label.(string), "/stacks/myapp")
 	}
 
 	// Should behave exactly like single domain field (no redirect labels)
@@ -1607,7 +2107,7 @@ func TestGenerateCompose_WithMultipleDomainsNoRedirect(t *testing.T) {
 
 	labelStrings := make([]string, len(labels))
 	for i, label := range labels {
-		labelStrings[i] = label.(string)
+		labelStrings[i] = withoutStackID(label.(string), "/stacks/myapp")
 	}
 
 	// Should only have primary domain labels, no redirects
@@ -1963,3 +2463,607 @@ func TestGenerateCompose_ReplicasEmittedWhenSet(t *testing.T) {
 		t.Errorf("replicas = %v, want 4", deploy["replicas"])
 	}
 }
+
+func TestGenerateCompose_ResourcesOmittedWhenUnset(t *testing.T) {
+	services := map[string]*config.Config{
+		"web": {Name: "web", Stack: "/stacks/myapp", Port: 80},
+	}
+	out, err := GenerateCompose(services, "/stacks/myapp", map[string]int{"web": 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(out, "deploy:") || strings.Contains(out, "resources:") {
+		t.Errorf("expected no deploy block without resources; got:\n%s", out)
+	}
+}
+
+func TestGenerateCompose_ReservationsOnly(t *testing.T) {
+	services := map[string]*config.Config{
+		"web": {
+			Name:              "web",
+			Stack:             "/stacks/myapp",
+			Port:              80,
+			CPUReservation:    "0.25",
+			MemoryReservation: "128m",
+		},
+	}
+	out, err := GenerateCompose(services, "/stacks/myapp", map[string]int{"web": 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var parsed composeServices
+	if err := yaml.Unmarshal([]byte(out), &parsed); err != nil {
+		t.Fatal(err)
+	}
+	deploy := parsed.Services["web"].Deploy
+	if deploy == nil || deploy.Resources == nil {
+		t.Fatalf("expected deploy.resources; got:\n%s", out)
+	}
+	if deploy.Replicas != 0 || deploy.Resources.Limits != nil {
+		t.Errorf("expected reservations only; got:\n%s", out)
+	}
+	if r := deploy.Resources.Reservations; r == nil || r.CPUs != "0.25" || r.Memory != "128m" {
+		t.Errorf("reservations = %+v, want cpus 0.25 memory 128m", r)
+	}
+}
+
+func TestGenerateCompose_ReservationsWithLimits(t *testing.T) {
+	n := 2
+	services := map[string]*config.Config{
+		"web": {
+			Name:              "web",
+			Stack:             "/stacks/myapp",
+			Port:              80,
+			Deploy:            &config.DeployConfig{Replicas: &n},
+			CPULimit:          "1.5",
+			MemoryLimit:       "1g",
+			CPUReservation:    "0.5",
+			MemoryReservation: "256m",
+		},
+	}
+	out, err := GenerateCompose(services, "/stacks/myapp", map[string]int{"web": 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var parsed composeServices
+	if err := yaml.Unmarshal([]byte(out), &parsed); err != nil {
+		t.Fatal(err)
+	}
+	deploy := parsed.Services["web"].Deploy
+	if deploy == nil || deploy.Resources == nil {
+		t.Fatalf("expected deploy.resources; got:\n%s", out)
+	}
+	if deploy.Replicas != 2 {
+		t.Errorf("replicas = %d, want 2", deploy.Replicas)
+	}
+	if l := deploy.Resources.Limits; l == nil || l.CPUs != "1.5" || l.Memory != "1g" {
+		t.Errorf("limits = %+v, want cpus 1.5 memory 1g", l)
+	}
+	if r := deploy.Resources.Reservations; r == nil || r.CPUs != "0.5" || r.Memory != "256m" {
+		t.Errorf("reservations = %+v, want cpus 0.5 memory 256m", r)
+	}
+}
+
+func TestGenerateCompose_MemoryLimitOnly(t *testing.T) {
+	services := map[string]*config.Config{
+		"web": {Name: "web", Stack: "/stacks/myapp", Port: 80, MemoryLimit: "512m"},
+	}
+	out, err := GenerateCompose(services, "/stacks/myapp", map[string]int{"web": 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out, "memory: 512m") {
+		t.Errorf("expected memory limit; got:\n%s", out)
+	}
+	if strings.Contains(out, "cpus:") || strings.Contains(out, "reservations:") {
+		t.Errorf("expected only the memory limit; got:\n%s", out)
+	}
+}
+
+func TestGenerateCompose_UlimitsAndSysctls(t *testing.T) {
+	services := map[string]*config.Config{
+		"db": {
+			Name:  "db",
+			Stack: "/stacks/myapp",
+			Port:  5432,
+			Ulimits: map[string]config.Ulimit{
+				"nofile": {Soft: 65536, Hard: 65536},
+				"nproc":  {Soft: 1024, Hard: 4096},
+			},
+			Sysctls: map[string]string{"net.core.somaxconn": "1024"},
+		},
+	}
+	out, err := GenerateCompose(services, "/stacks/myapp", map[string]int{"db": 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var parsed map[string]interface{}
+	if err := yaml.Unmarshal([]byte(out), &parsed); err != nil {
+		t.Fatal(err)
+	}
+	db := parsed["services"].(map[string]interface{})["db"].(map[string]interface{})
+
+	ulimits, ok := db["ulimits"].(map[string]interface{})
+	if !ok {
+		t.Fatalf("expected ulimits; got:\n%s", out)
+	}
+	if ulimits["nofile"] != 65536 {
+		t.Errorf("nofile = %v, want a single 65536 when soft == hard", ulimits["nofile"])
+	}
+	nproc, ok := ulimits["nproc"].(map[string]interface{})
+	if !ok || nproc["soft"] != 1024 || nproc["hard"] != 4096 {
+		t.Errorf("nproc = %v, want soft 1024 hard 4096", ulimits["nproc"])
+	}
+
+	sysctls, ok := db["sysctls"].(map[string]interface{})
+	if !ok || sysctls["net.core.somaxconn"] != "1024" {
+		t.Errorf("sysctls = %v, want net.core.somaxconn: \"1024\"", db["sysctls"])
+	}
+}
+
+func TestGenerateCompose_UlimitsAndSysctlsOmittedWhenUnset(t *testing.T) {
+	services := map[string]*config.Config{
+		"web": {Name: "web", Stack: "/stacks/myapp", Port: 80},
+	}
+	out, err := GenerateCompose(services, "/stacks/myapp", map[string]int{"web": 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(out, "ulimits:") || strings.Contains(out, "sysctls:") {
+		t.Errorf("expected no ulimits or sysctls; got:\n%s", out)
+	}
+}
+
+func TestGenerateCompose_ExtraHosts(t *testing.T) {
+	services := map[string]*config.Config{
+		"web": {
+			Name:  "web",
+			Stack: "/stacks/myapp",
+			Port:  80,
+			ExtraHosts: map[string]string{
+				"ldap.internal": "10.0.0.5",
+				"db.internal":   "10.0.0.7",
+				"host.internal": "host-gateway",
+			},
+		},
+		"api": {Name: "api", Stack: "/stacks/myapp", Port: 80},
+	}
+	out, err := GenerateCompose(services, "/stacks/myapp", map[string]int{"web": 1, "api": 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var parsed composeServices
+	if err := yaml.Unmarshal([]byte(out), &parsed); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"db.internal:10.0.0.7", "host.internal:host-gateway", "ldap.internal:10.0.0.5"}
+	if got := parsed.Services["web"].ExtraHosts; !slices.Equal(got, want) {
+		t.Errorf("extra_hosts = %v, want %v", got, want)
+	}
+	if got := parsed.Services["api"].ExtraHosts; got != nil {
+		t.Errorf("api extra_hosts = %v, want none", got)
+	}
+}
+
+func TestGenerateCompose_Capabilities(t *testing.T) {
+	services := map[string]*config.Config{
+		"vpn": {
+			Name:    "vpn",
+			Stack:   "/stacks/myapp",
+			Port:    80,
+			CapAdd:  []string{"NET_ADMIN", "SYS_MODULE"},
+			CapDrop: []string{"ALL"},
+		},
+	}
+	out, err := GenerateCompose(services, "/stacks/myapp", map[string]int{"vpn": 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var parsed composeServices
+	if err := yaml.Unmarshal([]byte(out), &parsed); err != nil {
+		t.Fatal(err)
+	}
+	vpn := parsed.Services["vpn"]
+	if !slices.Equal(vpn.CapAdd, []string{"NET_ADMIN", "SYS_MODULE"}) {
+		t.Errorf("cap_add = %v, want [NET_ADMIN SYS_MODULE]", vpn.CapAdd)
+	}
+	if !slices.Equal(vpn.CapDrop, []string{"ALL"}) {
+		t.Errorf("cap_drop = %v, want [ALL]", vpn.CapDrop)
+	}
+	if strings.Contains(out, "privileged:") {
+		t.Errorf("expected no privileged key when unset; got:\n%s", out)
+	}
+}
+
+func TestGenerateCompose_Privileged(t *testing.T) {
+	services := map[string]*config.Config{
+		"agent": {Name: "agent", Stack: "/stacks/myapp", Port: 80, Privileged: true},
+	}
+	out, err := GenerateCompose(services, "/stacks/myapp", map[string]int{"agent": 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var parsed composeServices
+	if err := yaml.Unmarshal([]byte(out), &parsed); err != nil {
+		t.Fatal(err)
+	}
+	if !parsed.Services["agent"].Privileged {
+		t.Errorf("expected privileged: true; got:\n%s", out)
+	}
+	if strings.Contains(out, "cap_add:") || strings.Contains(out, "cap_drop:") {
+		t.Errorf("expected no capabilities when unset; got:\n%s", out)
+	}
+}
+
+func TestGenerateCompose_SecurityOptionsOmittedWhenUnset(t *testing.T) {
+	services := map[string]*config.Config{
+		"web": {Name: "web", Stack: "/stacks/myapp", Port: 80},
+	}
+	out, err := GenerateCompose(services, "/stacks/myapp", map[string]int{"web": 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"cap_add:", "cap_drop:", "privileged:"} {
+		if strings.Contains(out, key) {
+			t.Errorf("expected no %s when unset; got:\n%s", key, out)
+		}
+	}
+}
+
+func TestGenerateCompose_CustomProjectName(t *testing.T) {
+	services := map[string]*config.Config{
+		"web": {
+			Name:    "web",
+			Server:  "myserver",
+			Stack:   "/a/web",
+			Project: "shop",
+			Domain:  "example.com",
+			Port:    3000,
+		},
+	}
+
+	result, err := GenerateCompose(services, "/a/web", map[string]int{"web": 7})
+	if err != nil {
+		t.Fatalf("GenerateCompose failed: %v", err)
+	}
+
+	var parsed map[string]interface{}
+	if err := yaml.Unmarshal([]byte(result), &parsed); err != nil {
+		t.Fatalf("Generated YAML is invalid: %v", err)
+	}
+
+	if parsed["name"] != "shop" {
+		t.Errorf("compose name = %v, want shop", parsed["name"])
+	}
+
+	networksMap := parsed["networks"].(map[string]interface{})
+	if _, ok := networksMap["shop_internal"]; !ok {
+		t.Errorf("shop_internal network missing; networks: %v", networksMap)
+	}
+	if _, ok := networksMap["web_internal"]; ok {
+		t.Error("stack-derived web_internal network should not exist when project is set")
+	}
+
+	webService := parsed["services"].(map[string]interface{})["web"].(map[string]interface{})
+	if webService["image"] != "ssd-shop-web:7" {
+		t.Errorf("image = %v, want ssd-shop-web:7", webService["image"])
+	}
+	if !strings.Contains(result, "traefik.http.routers.shop-web-"+stackID("/a/web")+".rule=") {
+		t.Error("expected Traefik router named after the custom project")
+	}
+}
+
+func TestGenerateCompose_DerivedProjectOmitsName(t *testing.T) {
+	services := map[string]*config.Config{
+		"web": {Name: "web", Server: "myserver", Stack: "/stacks/myapp", Port: 80},
+	}
+
+	result, err := GenerateCompose(services, "/stacks/myapp", map[string]int{"web": 1})
+	if err != nil {
+		t.Fatalf("GenerateCompose failed: %v", err)
+	}
+	if strings.HasPrefix(result, "name:") {
+		t.Errorf("compose name should be omitted when project is derived from stack:\n%s", result)
+	}
+}
+
+func TestGenerateCompose_Profiles(t *testing.T) {
+	services := map[string]*config.Config{
+		"seed": {
+			Name:     "seed",
+			Stack:    "/stacks/myapp",
+			Image:    "myapp/seed:1",
+			Profiles: []string{"setup", "debug"},
+		},
+		"web": {
+			Name:  "web",
+			Stack: "/stacks/myapp",
+		},
+	}
+
+	result, err := GenerateCompose(services, "/stacks/myapp", map[string]int{"web": 1})
+	if err != nil {
+		t.Fatalf("GenerateCompose failed: %v", err)
+	}
+
+	var parsed map[string]interface{}
+	if err := yaml.Unmarshal([]byte(result), &parsed); err != nil {
+		t.Fatalf("Generated YAML is invalid: %v", err)
+	}
+	servicesMap := parsed["services"].(map[string]interface{})
+
+	seed := servicesMap["seed"].(map[string]interface{})
+	profiles, ok := seed["profiles"].([]interface{})
+	if !ok || len(profiles) != 2 || profiles[0] != "setup" || profiles[1] != "debug" {
+		t.Errorf("seed profiles = %v, want [setup debug]", seed["profiles"])
+	}
+
+	web := servicesMap["web"].(map[string]interface{})
+	if _, ok := web["profiles"]; ok {
+		t.Errorf("web should not have profiles, got %v", web["profiles"])
+	}
+}
+
+// composeServices is a read-only view of generated services for tests that
+// need typed access without round-tripping depends_on.
+type composeServices struct {
+	Services map[string]struct {
+		Image      string         `yaml:"image"`
+		Restart    string         `yaml:"restart"`
+		EnvFile    string         `yaml:"env_file"`
+		Profiles   []string       `yaml:"profiles"`
+		Command    []string       `yaml:"command"`
+		Networks   []string       `yaml:"networks"`
+		Volumes    []string       `yaml:"volumes"`
+		Labels     []string       `yaml:"labels"`
+		Deploy     *ComposeDeploy `yaml:"deploy"`
+		ExtraHosts []string       `yaml:"extra_hosts"`
+		CapAdd     []string       `yaml:"cap_add"`
+		CapDrop    []string       `yaml:"cap_drop"`
+		Privileged bool           `yaml:"privileged"`
+	} `yaml:"services"`
+}
+
+func TestGenerateCompose_ManualStart(t *testing.T) {
+	manual := false
+	services := map[string]*config.Config{
+		"backup": {
+			Name:     "backup",
+			Stack:    "/stacks/myapp",
+			Restart:  &manual,
+			Profiles: []string{"ops"},
+		},
+		"web": {
+			Name:      "web",
+			Stack:     "/stacks/myapp",
+			DependsOn: config.Dependencies{{Name: "backup"}, {Name: "db"}},
+		},
+		"db": {Name: "db", Stack: "/stacks/myapp", Image: "postgres:16"},
+	}
+
+	result, err := GenerateCompose(services, "/stacks/myapp", map[string]int{"backup": 2, "web": 1})
+	if err != nil {
+		t.Fatalf("GenerateCompose failed: %v", err)
+	}
+
+	var parsed map[string]interface{}
+	if err := yaml.Unmarshal([]byte(result), &parsed); err != nil {
+		t.Fatalf("Generated YAML is invalid: %v", err)
+	}
+	servicesMap := parsed["services"].(map[string]interface{})
+
+	backup := servicesMap["backup"].(map[string]interface{})
+	if backup["restart"] != "no" {
+		t.Errorf("backup restart = %v, want no", backup["restart"])
+	}
+	profiles, ok := backup["profiles"].([]interface{})
+	if !ok || len(profiles) != 1 || profiles[0] != manualStartProfile {
+		t.Errorf("backup profiles = %v, want [%s]", backup["profiles"], manualStartProfile)
+	}
+	if backup["image"] != "ssd-myapp-backup:2" {
+		t.Errorf("backup image = %v, want it built like any service", backup["image"])
+	}
+
+	web := servicesMap["web"].(map[string]interface{})
+	deps, ok := web["depends_on"].([]interface{})
+	if !ok || len(deps) != 1 || deps[0] != "db" {
+		t.Errorf("web depends_on = %v, want [db]", web["depends_on"])
+	}
+}
+
+func TestGenerateCompose_PreStartJob(t *testing.T) {
+	services := map[string]*config.Config{
+		"web": {
+			Name:      "web",
+			Stack:     "/stacks/myapp",
+			Domain:    "example.com",
+			Volumes:   map[string]string{"uploads": "/app/uploads"},
+			DependsOn: config.Dependencies{{Name: "db"}},
+			PreStart:  &config.PreStartConfig{Command: "npm run migrate"},
+		},
+		"db": {
+			Name:  "db",
+			Stack: "/stacks/myapp",
+			Image: "postgres:16",
+		},
+	}
+
+	result, err := GenerateCompose(services, "/stacks/myapp", map[string]int{"web": 7})
+	if err != nil {
+		t.Fatalf("GenerateCompose failed: %v", err)
+	}
+
+	var parsed composeServices
+	if err := yaml.Unmarshal([]byte(result), &parsed); err != nil {
+		t.Fatalf("Generated YAML is invalid: %v", err)
+	}
+	job, ok := parsed.Services["web-pre-start"]
+	if !ok {
+		t.Fatalf("expected web-pre-start job service, got:\n%s", result)
+	}
+	if job.Image != "ssd-myapp-web:7" {
+		t.Errorf("job image = %q, want ssd-myapp-web:7", job.Image)
+	}
+	if job.Restart != "no" {
+		t.Errorf("job restart = %q, want no", job.Restart)
+	}
+	if len(job.Profiles) != 1 || job.Profiles[0] != "ssd-jobs" {
+		t.Errorf("job profiles = %v, want [ssd-jobs] so compose up skips it", job.Profiles)
+	}
+	if strings.Join(job.Command, " ") != "sh -c npm run migrate" {
+		t.Errorf("job command = %v", job.Command)
+	}
+	if job.EnvFile != "./web.env" || len(job.Volumes) != 1 || job.Volumes[0] != "uploads:/app/uploads" {
+		t.Errorf("job should share env file and volumes with web, got %+v", job)
+	}
+	if len(job.Networks) != 1 || job.Networks[0] != "myapp_internal" {
+		t.Errorf("job networks = %v, want internal only", job.Networks)
+	}
+	if len(job.Labels) != 0 {
+		t.Errorf("job must not get Traefik labels, got %v", job.Labels)
+	}
+	if !strings.Contains(result, "restart: \"no\"") {
+		t.Errorf("restart must be quoted so compose reads a string:\n%s", result)
+	}
+}
+
+func TestGenerateCompose_PreStartJobImageOverride(t *testing.T) {
+	services := map[string]*config.Config{
+		"web": {Name: "web", Stack: "/stacks/myapp", PreStart: &config.PreStartConfig{Command: "migrate up", Image: "migrate/migrate:v4"}},
+	}
+
+	result, err := GenerateCompose(services, "/stacks/myapp", map[string]int{"web": 1})
+	if err != nil {
+		t.Fatalf("GenerateCompose failed: %v", err)
+	}
+	var parsed composeServices
+	if err := yaml.Unmarshal([]byte(result), &parsed); err != nil {
+		t.Fatalf("Generated YAML is invalid: %v", err)
+	}
+	if got := parsed.Services["web-pre-start"].Image; got != "migrate/migrate:v4" {
+		t.Errorf("job image = %q, want migrate/migrate:v4", got)
+	}
+}
+
+func TestGenerateCompose_PreStartJobNameClash(t *testing.T) {
+	services := map[string]*config.Config{
+		"web":           {Name: "web", Stack: "/stacks/myapp", PreStart: &config.PreStartConfig{Command: "true"}},
+		"web-pre-start": {Name: "web-pre-start", Stack: "/stacks/myapp", Image: "busybox"},
+	}
+
+	_, err := GenerateCompose(services, "/stacks/myapp", map[string]int{"web": 1})
+	if err == nil || !strings.Contains(err.Error(), "clashes") {
+		t.Errorf("expected clash error, got %v", err)
+	}
+}
+
+func TestGenerateCompose_EnvFiles(t *testing.T) {
+	services := map[string]*config.Config{
+		"web": {Name: "web", Stack: "/stacks/myapp", EnvFiles: []string{"common.env", "web-secrets.env"}},
+		"api": {Name: "api", Stack: "/stacks/myapp"},
+	}
+
+	result, err := GenerateCompose(services, "/stacks/myapp", map[string]int{"web": 1, "api": 1})
+	if err != nil {
+		t.Fatalf("GenerateCompose failed: %v", err)
+	}
+	if !strings.Contains(result, "env_file:\n            - ./common.env\n            - ./web-secrets.env\n            - ./web.env\n") {
+		t.Errorf("web should load env_files before its own env file, got:\n%s", result)
+	}
+	if !strings.Contains(result, "env_file: ./api.env\n") {
+		t.Errorf("api without env_files should keep a single env_file, got:\n%s", result)
+	}
+
+	var parsed ComposeFile
+	if err := yaml.Unmarshal([]byte(result), &parsed); err != nil {
+		t.Fatalf("Generated YAML is invalid: %v", err)
+	}
+	if got := parsed.Services["web"].EnvFile; len(got) != 3 || got[2] != "./web.env" {
+		t.Errorf("web env_file = %v", got)
+	}
+	if got := parsed.Services["api"].EnvFile; len(got) != 1 || got[0] != "./api.env" {
+		t.Errorf("api env_file = %v", got)
+	}
+}
+
+func TestGenerateCompose_Metadata(t *testing.T) {
+	services := map[string]*config.Config{
+		"web": {Name: "web", Server: "myserver", Stack: "/stacks/myapp"},
+	}
+
+	result, err := GenerateCompose(services, "/stacks/myapp", map[string]int{"web": 1})
+	if err != nil {
+		t.Fatalf("GenerateCompose failed: %v", err)
+	}
+
+	if !strings.HasPrefix(result, "x-ssd:\n    managed-by: ssd\n") {
+		t.Errorf("x-ssd block missing from the top of the file:\n%s", result)
+	}
+	if !HasMetadata(result) {
+		t.Error("HasMetadata = false for generated compose")
+	}
+}
+
+func TestGenerateComposeWithMetadata(t *testing.T) {
+	services := map[string]*config.Config{
+		"web": {Name: "web", Server: "myserver", Stack: "/stacks/myapp"},
+		"db":  {Name: "db", Server: "myserver", Stack: "/stacks/myapp", Image: "postgres:16"},
+	}
+	meta := Metadata{
+		GeneratedAt: "2026-10-16T08:00:00Z",
+		SSDVersion:  "1.4.0",
+		Sources:     map[string]string{"web": "4b825dc642cb6eb9a060e54bf8d69288fbee4904"},
+	}
+
+	result, err := GenerateComposeWithMetadata(services, "/stacks/myapp", map[string]int{"web": 3}, meta)
+	if err != nil {
+		t.Fatalf("GenerateComposeWithMetadata failed: %v", err)
+	}
+
+	got, ok := ParseMetadata(result)
+	if !ok {
+		t.Fatalf("no x-ssd block in:\n%s", result)
+	}
+	want := Metadata{ManagedBy: "ssd", Project: "myapp", GeneratedAt: meta.GeneratedAt, SSDVersion: "1.4.0", Sources: meta.Sources}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ParseMetadata = %+v, want %+v", got, want)
+	}
+
+	// The block is a top-level x- extension; services parse as before
+	var file ComposeFile
+	if err := yaml.Unmarshal([]byte(result), &file); err != nil {
+		t.Fatalf("generated compose does not parse: %v", err)
+	}
+	if file.Services["web"].Image != "ssd-myapp-web:3" || len(file.Services) != 2 {
+		t.Errorf("services = %+v", file.Services)
+	}
+}
+
+func TestParseMetadata_Missing(t *testing.T) {
+	for _, content := range []string{"", "services:\n  web:\n    image: nginx\n", "x-ssd: ["} {
+		if _, ok := ParseMetadata(content); ok {
+			t.Errorf("ParseMetadata(%q) found a block", content)
+		}
+	}
+}
+
+func TestHasMetadata(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    bool
+	}{
+		{"marker", "x-ssd:\n  managed-by: ssd\nservices: {}\n", true},
+		{"hand-written", "services:\n  web:\n    image: nginx\n", false},
+		{"marker in a service", "services:\n  web:\n    x-ssd: {}\n", false},
+		{"empty", "", false},
+		{"invalid yaml", "x-ssd: [", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := HasMetadata(tt.content); got != tt.want {
+				t.Errorf("HasMetadata() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,129 @@
+package compose
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/byteink/ssd/config"
+	"gopkg.in/yaml.v3"
+)
+
+// ParsedService is what ssd can read from a service in a compose file it
+// did not write.
+type ParsedService struct {
+	Image      string
+	Context    string
+	Dockerfile string
+	// Port is the container port of the first ports or expose entry.
+	Port      int
+	DependsOn config.Dependencies
+}
+
+// parsedBuild accepts both the string and the mapping form of build.
+type parsedBuild struct {
+	Context    string
+	Dockerfile string
+}
+
+func (b *parsedBuild) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		b.Context = node.Value
+		return nil
+	}
+	var spec struct {
+		Context    string `yaml:"context"`
+		Dockerfile string `yaml:"dockerfile"`
+	}
+	if err := node.Decode(&spec); err != nil {
+		return fmt.Errorf("build must be a path or a map with context and dockerfile")
+	}
+	*b = parsedBuild{Context: spec.Context, Dockerfile: spec.Dockerfile}
+	return nil
+}
+
+// ParseServices reads the services of a compose file, keeping only what
+// maps onto ssd.yaml. Fields ssd has no equivalent for are ignored.
+func ParseServices(content string) (map[string]ParsedService, error) {
+	var file struct {
+		Services map[string]struct {
+			Image     string              `yaml:"image"`
+			Build     *parsedBuild        `yaml:"build"`
+			Ports     []yaml.Node         `yaml:"ports"`
+			Expose    []yaml.Node         `yaml:"expose"`
+			DependsOn config.Dependencies `yaml:"depends_on"`
+		} `yaml:"services"`
+	}
+	if err := yaml.Unmarshal([]byte(content), &file); err != nil {
+		return nil, fmt.Errorf("invalid compose file: %w", err)
+	}
+
+	services := make(map[string]ParsedService, len(file.Services))
+	for name, svc := range file.Services {
+		parsed := ParsedService{Image: svc.Image, DependsOn: svc.DependsOn}
+		if svc.Build != nil {
+			parsed.Context = svc.Build.Context
+			parsed.Dockerfile = svc.Build.Dockerfile
+		}
+		parsed.Port = containerPort(append(svc.Ports, svc.Expose...))
+		services[name] = parsed
+	}
+	return services, nil
+}
+
+// containerPort returns the container port of the first entry it can
+// read: "8080:3000/tcp" and "3000" give 3000, the long syntax its target.
+func containerPort(entries []yaml.Node) int {
+	for _, entry := range entries {
+		value := entry.Value
+		if entry.Kind == yaml.MappingNode {
+			var long struct {
+				Target string `yaml:"target"`
+			}
+			if err := entry.Decode(&long); err != nil {
+				continue
+			}
+			value = long.Target
+		}
+		value = value[strings.LastIndex(value, ":")+1:]
+		if i := strings.IndexByte(value, '/'); i >= 0 {
+			value = value[:i]
+		}
+		if port, err := strconv.Atoi(value); err == nil && port > 0 && port <= 65535 {
+			return port
+		}
+	}
+	return 0
+}
+
+// Config maps the service onto an ssd service config. A service that
+// builds keeps its build context and drops the image, which ssd names
+// itself; one that doesn't becomes a pre-built image.
+func (s ParsedService) Config(name string) *config.Config {
+	cfg := &config.Config{
+		Name:      name,
+		Port:      s.Port,
+		DependsOn: s.DependsOn,
+	}
+	if s.Context != "" {
+		cfg.Context = s.Context
+		cfg.Dockerfile = s.Dockerfile
+	} else {
+		cfg.Image = s.Image
+	}
+	return cfg
+}
+
+// MarkManaged adds the x-ssd block to compose content ssd did not write,
+// leaving the rest of the file as it is. Content that already has it is
+// returned unchanged.
+func MarkManaged(content string) string {
+	if HasMetadata(content) {
+		return content
+	}
+	marker := MetadataKey + ":\n  managed-by: ssd\n"
+	if rest, ok := strings.CutPrefix(content, "---\n"); ok {
+		return "---\n" + marker + rest
+	}
+	return marker + content
+}
@@ -0,0 +1,104 @@
+package compose
+
+import (
+	"slices"
+	"testing"
+)
+
+const foreignCompose = `services:
+  web:
+    build:
+      context: ./web
+      dockerfile: Dockerfile.prod
+    ports:
+      - "8080:3000"
+    depends_on:
+      db:
+        condition: service_healthy
+  worker:
+    build: ./worker
+    depends_on: [db]
+  db:
+    image: postgres:16
+    expose:
+      - "5432"
+  proxy:
+    image: nginx
+    ports:
+      - target: 80
+        published: 80
+`
+
+func TestParseServices(t *testing.T) {
+	services, err := ParseServices(foreignCompose)
+	if err != nil {
+		t.Fatalf("ParseServices failed: %v", err)
+	}
+	if len(services) != 4 {
+		t.Fatalf("got %d services, want 4", len(services))
+	}
+
+	web := services["web"]
+	if web.Context != "./web" || web.Dockerfile != "Dockerfile.prod" || web.Port != 3000 {
+		t.Errorf("web = %+v", web)
+	}
+	if len(web.DependsOn) != 1 || web.DependsOn[0].Condition != "service_healthy" {
+		t.Errorf("web depends_on = %+v", web.DependsOn)
+	}
+	if worker := services["worker"]; worker.Context != "./worker" || !slices.Equal(worker.DependsOn.Names(), []string{"db"}) {
+		t.Errorf("worker = %+v", worker)
+	}
+	if db := services["db"]; db.Image != "postgres:16" || db.Port != 5432 {
+		t.Errorf("db = %+v", db)
+	}
+	if proxy := services["proxy"]; proxy.Port != 80 {
+		t.Errorf("proxy port = %d, want 80", proxy.Port)
+	}
+}
+
+func TestParseServices_Invalid(t *testing.T) {
+	if _, err := ParseServices("services: ["); err == nil {
+		t.Error("expected error for invalid YAML")
+	}
+}
+
+func TestParsedService_Config(t *testing.T) {
+	services, err := ParseServices(foreignCompose)
+	if err != nil {
+		t.Fatalf("ParseServices failed: %v", err)
+	}
+
+	web := services["web"].Config("web")
+	if web.Name != "web" || web.Image != "" || web.Context != "./web" || web.Dockerfile != "Dockerfile.prod" || web.Port != 3000 {
+		t.Errorf("web config = %+v", web)
+	}
+	if web.IsPrebuilt() {
+		t.Error("a service with build should not be pre-built")
+	}
+
+	db := services["db"].Config("db")
+	if db.Image != "postgres:16" || !db.IsPrebuilt() {
+		t.Errorf("db config = %+v", db)
+	}
+}
+
+func TestMarkManaged(t *testing.T) {
+	marked := MarkManaged(foreignCompose)
+	if !HasMetadata(marked) {
+		t.Fatal("marked content has no x-ssd block")
+	}
+	if marked != "x-ssd:\n  managed-by: ssd\n"+foreignCompose {
+		t.Errorf("the rest of the file changed:\n%s", marked)
+	}
+	if again := MarkManaged(marked); again != marked {
+		t.Errorf("marking twice changed the file:\n%s", again)
+	}
+
+	withMarker := MarkManaged("---\n" + foreignCompose)
+	if withMarker != "---\nx-ssd:\n  managed-by: ssd\n"+foreignCompose {
+		t.Errorf("document marker not kept first:\n%s", withMarker)
+	}
+	if _, err := ParseServices(withMarker); err != nil {
+		t.Errorf("marked content no longer parses: %v", err)
+	}
+}
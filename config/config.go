@@ -1,12 +1,27 @@
 package config
 
 import (
+	"bytes"
+	"encoding/base64"
+	"errors"
 	"fmt"
+	"io"
+	"io/fs"
+	"maps"
+	"math"
+	"net"
+	"net/url"
 	"os"
+	"path"
 	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
 	"strings"
+	"time"
 	"unicode"
 
+	"github.com/byteink/ssd/schedule"
 	"gopkg.in/yaml.v3"
 )
 
@@ -56,6 +71,43 @@ func (d *Dependencies) UnmarshalYAML(node *yaml.Node) error {
 	}
 }
 
+// Ulimit is a soft/hard resource limit pair. In ssd.yaml it is either a
+// single number, used for both, or a map with soft and hard.
+type Ulimit struct {
+	Soft int64
+	Hard int64
+}
+
+// UnmarshalYAML handles both the number and the {soft, hard} forms.
+func (u *Ulimit) UnmarshalYAML(node *yaml.Node) error {
+	switch node.Kind {
+	case yaml.ScalarNode:
+		var v int64
+		if err := node.Decode(&v); err != nil {
+			return fmt.Errorf("ulimit %q must be a number", node.Value)
+		}
+		*u = Ulimit{Soft: v, Hard: v}
+		return nil
+
+	case yaml.MappingNode:
+		var spec struct {
+			Soft *int64 `yaml:"soft"`
+			Hard *int64 `yaml:"hard"`
+		}
+		if err := node.Decode(&spec); err != nil {
+			return fmt.Errorf("ulimit soft and hard must be numbers")
+		}
+		if spec.Soft == nil || spec.Hard == nil {
+			return fmt.Errorf("ulimit needs both soft and hard")
+		}
+		*u = Ulimit{Soft: *spec.Soft, Hard: *spec.Hard}
+		return nil
+
+	default:
+		return fmt.Errorf("ulimit must be a number or a map with soft and hard")
+	}
+}
+
 // Names returns the dependency names as a string slice.
 func (d Dependencies) Names() []string {
 	if len(d) == 0 {
@@ -85,17 +137,66 @@ func (d Dependencies) HasConditions() bool {
 // required for scratch and other images that ship no shell. Exactly one
 // of Cmd or Exec must be set.
 type HealthCheck struct {
-	Cmd      string   `yaml:"cmd,omitempty"`
-	Exec     []string `yaml:"exec,omitempty"`
-	Interval string   `yaml:"interval"`
-	Timeout  string   `yaml:"timeout"`
-	Retries  int      `yaml:"retries"`
+	Cmd         string   `yaml:"cmd,omitempty"`
+	Exec        []string `yaml:"exec,omitempty"`
+	Interval    string   `yaml:"interval"`
+	Timeout     string   `yaml:"timeout"`
+	Retries     int      `yaml:"retries"`
+	StartPeriod string   `yaml:"start_period"` // failures during startup don't count
+}
+
+// PreStartConfig is a one-off job that must run to completion before the
+// service starts, e.g. database migrations. Command runs with sh -c.
+// Image defaults to the service's own image (the version being deployed).
+type PreStartConfig struct {
+	Command string `yaml:"command"`
+	Image   string `yaml:"image"`
+}
+
+// SmokeTestConfig is an HTTP request made once a deploy has started the
+// service (and found it healthy, when it waits). A response other than
+// the expected status fails the deploy. Set URL, or Path to request it
+// under the service's public URL.
+type SmokeTestConfig struct {
+	URL        string `yaml:"url"`
+	Path       string `yaml:"path"`
+	Status     int    `yaml:"status"`      // expected status; default any 2xx
+	Timeout    string `yaml:"timeout"`     // how long to keep trying (default 30s)
+	FromServer bool   `yaml:"from_server"` // request with curl on the server instead of from here
+}
+
+// PreStartJobName returns the name of the compose service / pod that runs
+// serviceName's pre_start job.
+func PreStartJobName(serviceName string) string {
+	return serviceName + "-pre-start"
 }
 
 // DeployConfig holds deployment strategy options
 type DeployConfig struct {
-	Strategy string `yaml:"strategy"`           // "rollout" (default) or "recreate"
+	Strategy string `yaml:"strategy"`           // "rollout" (default), "recreate" or "none"
 	Replicas *int   `yaml:"replicas,omitempty"` // number of replicas (default: 1); nil means unset
+
+	// HealthGate waits for the service to become healthy after it starts
+	// and rolls back to the previous version if it doesn't.
+	HealthGate    bool   `yaml:"health_gate,omitempty"`
+	HealthTimeout string `yaml:"health_timeout,omitempty"` // how long to wait (default 60s)
+	HealthGrace   string `yaml:"health_grace,omitempty"`   // without a healthcheck: must stay running this long
+
+	// PinDigest makes deploys of a pre-built image tag resolve the pulled
+	// image's digest and write name@sha256:... into the manifest.
+	PinDigest bool `yaml:"pin_digest,omitempty"`
+
+	// SkipUnchanged makes deploy a no-op when the build context's git tree
+	// matches the one recorded by the last deploy (override with --force).
+	SkipUnchanged bool `yaml:"skip_unchanged,omitempty"`
+}
+
+// BuildConfig holds image build options
+type BuildConfig struct {
+	BuildKit bool     `yaml:"buildkit"` // export DOCKER_BUILDKIT=1 for the remote build
+	Pull     bool     `yaml:"pull"`     // always pull the Dockerfile's base images (build --pull)
+	SSH      []string `yaml:"ssh"`      // build --ssh: "default" (the server's SSH agent) or id=/path/on/server; implies buildkit
+	Secrets  []string `yaml:"secrets"`  // build --secret: id=<id>,src=/path/on/server or id=<id>,env=VAR; implies buildkit
 }
 
 // CleanupConfig holds post-deploy image retention options.
@@ -107,37 +208,93 @@ type CleanupConfig struct {
 
 // Config represents a single service configuration
 type Config struct {
-	Name        string            `yaml:"name"`
-	Server      string            `yaml:"server"`
-	Stack       string            `yaml:"stack"`
-	Dockerfile  string            `yaml:"dockerfile"`
-	Context     string            `yaml:"context"`
-	Domain      string            `yaml:"domain"`       // optional, enables Traefik (single domain)
-	Domains     []string          `yaml:"domains"`      // optional, multi-domain support
-	RedirectTo  string            `yaml:"redirect_to"`  // optional, domain to redirect all others to (must be in Domains)
-	Path        string            `yaml:"path"`         // optional, path prefix for Traefik routing
-	HTTPS       *bool             `yaml:"https"`       // default true, pointer for nil check
-	Port        int               `yaml:"port"`        // default 80
-	Image       string            `yaml:"image"`       // if set, skip build (pre-built)
-	Ports       []string          `yaml:"ports"`       // host:container port mappings
-	Target      string            `yaml:"target"`      // Docker build target stage
-	Deploy      *DeployConfig     `yaml:"deploy"`      // deployment strategy options
-	DependsOn   Dependencies      `yaml:"depends_on"`
-	Volumes     map[string]string `yaml:"volumes"`     // name: mount_path
-	Files       map[string]string `yaml:"files"`       // local_path: container_mount_path
-	EnvFile     string            `yaml:"env_file"`    // local path to .env file (relative to project root); overwrites {service}.env on deploy
-	HealthCheck *HealthCheck      `yaml:"healthcheck"`
-	Cleanup     *CleanupConfig    `yaml:"cleanup"`     // post-deploy image tag retention; inherits from root
+	Name              string            `yaml:"name"`
+	Server            string            `yaml:"server"`
+	Servers           []string          `yaml:"servers"` // deploy to each of these hosts instead of server; Server is the first
+	Stack             string            `yaml:"stack"`
+	Dockerfile        string            `yaml:"dockerfile"`
+	Context           string            `yaml:"context"`
+	Domain            string            `yaml:"domain"`             // optional, enables Traefik (single domain)
+	Domains           []string          `yaml:"domains"`            // optional, multi-domain support
+	RedirectTo        string            `yaml:"redirect_to"`        // optional, domain to redirect all others to (must be in Domains)
+	Path              string            `yaml:"path"`               // optional, path prefix for Traefik routing
+	Router            string            `yaml:"router"`             // "http" (default) or "tcp" for a Traefik TCP router
+	RoutePriority     int               `yaml:"route_priority"`     // optional, Traefik router priority; 0 keeps Traefik's default (rule length)
+	Entrypoints       []string          `yaml:"entrypoints"`        // optional, Traefik entrypoints instead of web/websecure
+	HTTPS             *bool             `yaml:"https"`              // default true, pointer for nil check
+	Port              int               `yaml:"port"`               // default 80
+	Image             string            `yaml:"image"`              // if set, skip build (pre-built)
+	Ports             []string          `yaml:"ports"`              // host:container port mappings
+	CPULimit          string            `yaml:"cpu_limit"`          // max CPUs (e.g. "1.5"); deploy.resources.limits
+	MemoryLimit       string            `yaml:"memory_limit"`       // max memory (e.g. "512m"); deploy.resources.limits
+	CPUReservation    string            `yaml:"cpu_reservation"`    // guaranteed CPUs; deploy.resources.reservations
+	MemoryReservation string            `yaml:"memory_reservation"` // guaranteed memory; deploy.resources.reservations
+	Ulimits           map[string]Ulimit `yaml:"ulimits"`            // e.g. nofile: 65536 or nofile: {soft: 1024, hard: 65536}
+	Sysctls           map[string]string `yaml:"sysctls"`            // kernel parameters, e.g. net.core.somaxconn: 1024
+	ExtraHosts        map[string]string `yaml:"extra_hosts"`        // hostname: ip, added to the container's /etc/hosts
+	CapAdd            []string          `yaml:"cap_add"`            // Linux capabilities to grant (e.g. NET_ADMIN)
+	CapDrop           []string          `yaml:"cap_drop"`           // Linux capabilities to drop (ALL for every one)
+	Privileged        bool              `yaml:"privileged"`         // run with all capabilities and host devices
+	Target            string            `yaml:"target"`             // Docker build target stage
+	Platform          string            `yaml:"platform"`           // target build platform (e.g. linux/amd64)
+	BuildArgs         map[string]string `yaml:"build_args"`         // --build-arg KEY=VALUE passed to the image build
+	Build             *BuildConfig      `yaml:"build"`              // image build options
+	Deploy            *DeployConfig     `yaml:"deploy"`             // deployment strategy options
+	DependsOn         Dependencies      `yaml:"depends_on"`
+	Volumes           map[string]string `yaml:"volumes"`  // name or /host/path: mount_path[:mode]
+	Files             map[string]string `yaml:"files"`    // local_path: container_mount_path
+	EnvFile           string            `yaml:"env_file"` // local path to .env file (relative to project root); overwrites {service}.env on deploy
+	EnvFiles          []string          `yaml:"env_files"` // extra env files in the stack dir (e.g. common.env), loaded before {service}.env
+	HealthCheck       *HealthCheck      `yaml:"healthcheck"`
+	Cleanup           *CleanupConfig    `yaml:"cleanup"`   // post-deploy image tag retention; inherits from root
+	Profiles          []string          `yaml:"profiles"`  // compose profiles; service only runs when one is selected
+	Restart           *bool             `yaml:"restart"`   // default true; false = manual start: built and written, never started by ssd
+	Schedule          string            `yaml:"schedule"`  // cron expression; runs the service via a systemd timer (compose)
+	PreStart          *PreStartConfig   `yaml:"pre_start"` // job run to completion before the service starts
+	SmokeTest         *SmokeTestConfig  `yaml:"smoke_test"` // HTTP check after the service starts
+	Project           string            `yaml:"-"`         // inherited from root project; see ProjectName
+	ImageTemplate     string            `yaml:"-"`         // inherited from root image_template; see BuiltImageName
+	StacksRoot        string            `yaml:"-"`         // inherited from root stacks_root; parent of the default stack and of a relative stack
+	ComposeFile       string            `yaml:"-"`         // inherited from root compose_filename; see ComposeFilename
+	SSHClient         string            `yaml:"-"`         // inherited from root ssh_client: openssh (default) or native
+	ProjectDir        string            `yaml:"-"`         // inherited from root project_dir (or --project-dir); git root builds are archived from
+	Events            string            `yaml:"-"`         // inherited from root events; see deploy.Event
+	// ActiveProfiles are the profiles selected with --profile. Set by the
+	// CLI, not ssd.yaml; passed to compose commands that start services.
+	ActiveProfiles []string `yaml:"-"`
+	// HostKey and HostKeyChecking are inherited from the root host_key and
+	// strict_host_key_checking; see RootConfig.
+	HostKey         string `yaml:"-"`
+	HostKeyChecking string `yaml:"-"`
 }
 
 // RootConfig represents the ssd.yaml file structure
 type RootConfig struct {
-	Runtime  string              `yaml:"runtime"`
-	Server   string              `yaml:"server"`
-	Stack    string              `yaml:"stack"`
-	Deploy   *DeployConfig       `yaml:"deploy"`
-	Cleanup  *CleanupConfig      `yaml:"cleanup"`
-	Services map[string]*Config `yaml:"services"`
+	Runtime     string             `yaml:"runtime"`
+	Project     string             `yaml:"project"` // overrides the project name derived from the stack path
+	// ImageTemplate names built images: {project} and {service} are
+	// replaced, the :version tag is appended. Default ssd-{project}-{service}.
+	ImageTemplate string `yaml:"image_template"`
+	Server      string             `yaml:"server"`
+	Stack       string             `yaml:"stack"`
+	StacksRoot  string             `yaml:"stacks_root"`      // parent of default and relative stack paths ({stacks_root}/{name}); default /stacks
+	ComposeFile string             `yaml:"compose_filename"` // compose file in the stack dir; default compose.yaml
+	SSHClient   string             `yaml:"ssh_client"`       // openssh (default, shells out to ssh) or native (one in-process connection)
+	ProjectDir  string             `yaml:"project_dir"`      // git repository root to archive builds from; default: detected from each context
+	Events      string             `yaml:"events"`           // file or unix socket that deploys write JSON events to; default: none
+	Deploy      *DeployConfig      `yaml:"deploy"`
+	Cleanup     *CleanupConfig     `yaml:"cleanup"`
+	Services    map[string]*Config `yaml:"services"`
+	// HostKey pins the server's SSH host key: a public key as printed by
+	// ssh-keyscan ("ssh-ed25519 AAAA..."), or with ssh_client: native a
+	// SHA256 fingerprint as printed by ssh-keygen -lf.
+	HostKey string `yaml:"host_key"`
+	// HostKeyChecking is strict_host_key_checking: yes (default),
+	// accept-new or no.
+	HostKeyChecking string `yaml:"strict_host_key_checking"`
+	// ActiveProfiles are the compose profiles selected with --profile,
+	// handed to every service config; see Config.ActiveProfiles.
+	ActiveProfiles []string `yaml:"-"`
 }
 
 // Load reads and parses an ssd config from disk.
@@ -146,7 +303,8 @@ type RootConfig struct {
 //  1. .ssd/ssd.yaml (preferred layout, keeps repo root clean)
 //  2. ssd.yaml      (legacy layout, kept for back-compat)
 //
-// An explicit non-empty path is read verbatim with no fallback.
+// An explicit non-empty path is read verbatim with no fallback, and
+// StdinPath reads the config from stdin.
 func Load(path string) (*RootConfig, error) {
 	if path == "" {
 		resolved, err := DefaultConfigPath()
@@ -156,7 +314,7 @@ func Load(path string) (*RootConfig, error) {
 		path = resolved
 	}
 
-	data, err := os.ReadFile(path)
+	data, err := readConfigFile(path)
 	if err != nil {
 		return nil, fmt.Errorf("failed to read config file: %w", err)
 	}
@@ -164,6 +322,35 @@ func Load(path string) (*RootConfig, error) {
 	return LoadFromBytes(data)
 }
 
+// StdinPath as the config path (--config -) reads the config YAML from
+// stdin, e.g. one generated by another tool and piped into ssd.
+const StdinPath = "-"
+
+// stdin is where a StdinPath config is read from; tests replace it.
+var stdin io.Reader = os.Stdin
+
+// stdinData holds the config read from stdin. Stdin can only be read
+// once, so every later load in the process reuses it.
+var (
+	stdinData []byte
+	stdinRead bool
+)
+
+// readConfigFile reads the config at path, or from stdin for StdinPath.
+func readConfigFile(path string) ([]byte, error) {
+	if path != StdinPath {
+		return os.ReadFile(path)
+	}
+	if !stdinRead {
+		data, err := io.ReadAll(stdin)
+		if err != nil {
+			return nil, fmt.Errorf("reading stdin: %w", err)
+		}
+		stdinData, stdinRead = data, true
+	}
+	return stdinData, nil
+}
+
 // DefaultConfigPath returns the first existing ssd config path under the
 // current working directory, preferring .ssd/ssd.yaml over the legacy
 // ssd.yaml. Returns "ssd.yaml" when neither exists so callers get a
@@ -200,10 +387,15 @@ func EnvConfigPath(basePath, env string) string {
 // Resolve loads the base config (and an optional environment overlay),
 // returning the merged RootConfig and the base path it was loaded from.
 //
-// configPath: explicit path or "" to auto-detect (see DefaultConfigPath).
+// configPath: explicit path, StdinPath, or "" to auto-detect (see
+// DefaultConfigPath).
 // env: environment name; when set, ".ssd/ssd.<env>.yaml" is deep-merged
-// onto the base. Missing overlay file is an error (explicit env requested
+// onto the base. A config read from stdin takes its overlay from beside
+// the auto-detected config path. Missing overlay file is an error (explicit env requested
 // but not provided is almost certainly a typo).
+//
+// Precedence, lowest first: the global config (see GlobalConfigPath), the
+// project config, the env overlay. A missing global config is not an error.
 func Resolve(configPath, env string) (*RootConfig, string, error) {
 	if configPath == "" {
 		p, err := DefaultConfigPath()
@@ -213,7 +405,12 @@ func Resolve(configPath, env string) (*RootConfig, string, error) {
 		configPath = p
 	}
 
-	if env == "" {
+	global, err := readGlobalConfig()
+	if err != nil {
+		return nil, configPath, err
+	}
+
+	if env == "" && global == nil {
 		base, err := Load(configPath)
 		if err != nil {
 			return nil, configPath, err
@@ -221,31 +418,119 @@ func Resolve(configPath, env string) (*RootConfig, string, error) {
 		return base, configPath, nil
 	}
 
-	// With an overlay, merge at the YAML node level on the raw bytes —
-	// re-marshalling a parsed RootConfig would materialise zero-valued
-	// fields (e.g. domains: []) and break validation.
-	baseData, err := os.ReadFile(configPath)
+	// Merge at the YAML node level on the raw bytes — re-marshalling a
+	// parsed RootConfig would materialise zero-valued fields (e.g.
+	// domains: []) and break validation.
+	data, err := readConfigFile(configPath)
 	if err != nil {
 		return nil, configPath, fmt.Errorf("failed to read config file: %w", err)
 	}
 
-	overlayPath := EnvConfigPath(configPath, env)
-	overlayData, err := os.ReadFile(overlayPath)
-	if err != nil {
-		return nil, configPath, fmt.Errorf("failed to read env overlay %q: %w", overlayPath, err)
+	var overlayPath string
+	if env != "" {
+		overlayBase := configPath
+		if configPath == StdinPath {
+			if overlayBase, err = DefaultConfigPath(); err != nil {
+				return nil, configPath, err
+			}
+		}
+		overlayPath = EnvConfigPath(overlayBase, env)
+		overlayData, err := os.ReadFile(overlayPath)
+		if err != nil {
+			return nil, configPath, fmt.Errorf("failed to read env overlay %q: %w", overlayPath, err)
+		}
+		if data, err = mergeRawYAML(data, overlayData); err != nil {
+			return nil, configPath, fmt.Errorf("failed to apply env overlay %q: %w", overlayPath, err)
+		}
 	}
 
-	merged, err := mergeRawYAML(baseData, overlayData)
-	if err != nil {
-		return nil, configPath, fmt.Errorf("failed to apply env overlay %q: %w", overlayPath, err)
+	if global != nil {
+		if data, err = mergeRawYAML(global, data); err != nil {
+			return nil, configPath, fmt.Errorf("failed to apply global config %q: %w", GlobalConfigPath(), err)
+		}
 	}
-	cfg, err := LoadFromBytes(merged)
+
+	cfg, err := LoadFromBytes(data)
 	if err != nil {
-		return nil, configPath, fmt.Errorf("failed to apply env overlay %q: %w", overlayPath, err)
+		if overlayPath != "" {
+			return nil, configPath, fmt.Errorf("failed to apply env overlay %q: %w", overlayPath, err)
+		}
+		return nil, configPath, err
 	}
 	return cfg, configPath, nil
 }
 
+// GlobalConfig is the user-level defaults file shared by every project.
+// Only settings that make sense across repositories are allowed; anything
+// else (services, stack, project) is rejected so a typo can't silently
+// leak into every deploy.
+type GlobalConfig struct {
+	Runtime    string         `yaml:"runtime"`
+	Server     string         `yaml:"server"`
+	StacksRoot string         `yaml:"stacks_root"`
+	Deploy     *DeployConfig  `yaml:"deploy"`
+	Cleanup    *CleanupConfig `yaml:"cleanup"`
+	// The SSH connection settings, so projects on the same server share
+	// them; see RootConfig.
+	SSHClient       string `yaml:"ssh_client"`
+	HostKey         string `yaml:"host_key"`
+	HostKeyChecking string `yaml:"strict_host_key_checking"`
+}
+
+// GlobalConfigPath returns the user-level config path:
+// $XDG_CONFIG_HOME/ssd/config.yaml, or ~/.config/ssd/config.yaml.
+// Returns "" when neither location can be determined.
+func GlobalConfigPath() string {
+	if dir := os.Getenv("XDG_CONFIG_HOME"); dir != "" {
+		return filepath.Join(dir, "ssd", "config.yaml")
+	}
+	home, err := os.UserHomeDir()
+	if err != nil || home == "" {
+		return ""
+	}
+	return filepath.Join(home, ".config", "ssd", "config.yaml")
+}
+
+// readGlobalConfig returns the raw global config, or nil when the file
+// doesn't exist. The content is validated against GlobalConfig.
+func readGlobalConfig() ([]byte, error) {
+	path := GlobalConfigPath()
+	if path == "" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read global config %q: %w", path, err)
+	}
+
+	var global GlobalConfig
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(&global); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("invalid global config %q: %w", path, err)
+	}
+	if global.Runtime != "" {
+		if err := ValidateRuntime(global.Runtime); err != nil {
+			return nil, fmt.Errorf("invalid global config %q: %w", path, err)
+		}
+	}
+	if err := ValidateSSHClient(global.SSHClient); err != nil {
+		return nil, fmt.Errorf("invalid global config %q: invalid ssh_client: %w", path, err)
+	}
+	if err := ValidateHostKeyChecking(global.HostKeyChecking); err != nil {
+		return nil, fmt.Errorf("invalid global config %q: invalid strict_host_key_checking: %w", path, err)
+	}
+	if global.HostKey != "" {
+		if err := ValidateHostKey(global.HostKey); err != nil {
+			return nil, fmt.Errorf("invalid global config %q: invalid host_key: %w", path, err)
+		}
+	}
+	return data, nil
+}
+
 // Layout describes which config files exist in the working directory.
 // Used to decide whether to nudge the user toward the modern layout.
 type Layout struct {
@@ -355,18 +640,227 @@ func mappingIndex(node *yaml.Node, key string) int {
 // Does not panic on any input, returns error instead
 // Enables fuzz testing without file system
 func LoadFromBytes(data []byte) (*RootConfig, error) {
-	var cfg RootConfig
-	if err := yaml.Unmarshal(data, &cfg); err != nil {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
 		return nil, fmt.Errorf("failed to parse config: %w", err)
 	}
+	if err := resolveExtends(&doc); err != nil {
+		return nil, err
+	}
+
+	var cfg RootConfig
+	if doc.Kind != 0 {
+		if err := doc.Decode(&cfg); err != nil {
+			return nil, fmt.Errorf("failed to parse config: %w", err)
+		}
+	}
 
 	if cfg.Runtime == "" {
 		cfg.Runtime = "compose"
 	}
 
+	if err := validatePortConflicts(cfg.Services); err != nil {
+		return nil, err
+	}
+	if err := validateRouteConflicts(cfg.Services); err != nil {
+		return nil, err
+	}
+
 	return &cfg, nil
 }
 
+// validatePortConflicts rejects two services publishing the same host
+// port. Malformed mappings are left to ValidatePortMapping.
+func validatePortConflicts(services map[string]*Config) error {
+	owners := make(map[string]string)
+	for _, name := range slices.Sorted(maps.Keys(services)) {
+		svc := services[name]
+		if svc == nil {
+			continue
+		}
+		for _, mapping := range svc.Ports {
+			host, _, ok := strings.Cut(mapping, ":")
+			if !ok {
+				continue
+			}
+			if other, taken := owners[host]; taken && other != name {
+				return fmt.Errorf("host port %s is published by both %q and %q", host, other, name)
+			}
+			owners[host] = name
+		}
+	}
+	return nil
+}
+
+// validateRouteConflicts rejects two services routing the same path
+// prefix on a domain, or prefixes where one contains the other (/api and
+// /api/v2): Traefik's PathPrefix matches both for the longer one, so
+// requests silently go to whichever router wins, unless the two set
+// different route_priority values to make the order explicit. A service
+// without a path (or with "/") is the domain's catch-all and only
+// conflicts with another catch-all. Routers on disjoint entrypoints
+// never see the same requests, and TCP routers are left out; they route
+// by SNI.
+func validateRouteConflicts(services map[string]*Config) error {
+	type route struct {
+		service, path string
+		priority      int
+		entrypoints   []string
+	}
+	routes := make(map[string][]route)
+	for _, name := range slices.Sorted(maps.Keys(services)) {
+		svc := services[name]
+		if svc == nil || svc.IsTCPRouter() {
+			continue
+		}
+		domains := svc.Domains
+		if svc.Domain != "" {
+			domains = []string{svc.Domain}
+		}
+		path := strings.TrimSuffix(svc.Path, "/")
+		if path == "" {
+			path = "/"
+		}
+		entrypoints := svc.Entrypoints
+		if len(entrypoints) == 0 {
+			entrypoints = []string{"web", "websecure"}
+		}
+		for _, domain := range domains {
+			for _, other := range routes[domain] {
+				if other.service == name || !slices.ContainsFunc(other.entrypoints, func(ep string) bool {
+					return slices.Contains(entrypoints, ep)
+				}) {
+					continue
+				}
+				if other.path == path {
+					return fmt.Errorf("%q and %q both route %s%s", other.service, name, domain, strings.TrimSuffix(path, "/"))
+				}
+				if pathPrefixOverlaps(other.path, path) && other.priority == svc.RoutePriority {
+					return fmt.Errorf("path %s of %q overlaps path %s of %q on %s; set different route_priority values to choose which matches first", other.path, other.service, path, name, domain)
+				}
+			}
+			routes[domain] = append(routes[domain], route{service: name, path: path, priority: svc.RoutePriority, entrypoints: entrypoints})
+		}
+	}
+	return nil
+}
+
+// pathPrefixOverlaps reports whether one Traefik PathPrefix matches
+// requests meant for the other. "/" is excluded: it is the catch-all,
+// and longer prefixes are meant to take precedence over it.
+func pathPrefixOverlaps(a, b string) bool {
+	if a == "/" || b == "/" {
+		return false
+	}
+	return strings.HasPrefix(a, b) || strings.HasPrefix(b, a)
+}
+
+// resolveExtends applies `extends: <service>` inside the services mapping
+// of a parsed config document. The parent's fields (except name) are
+// deep-merged under the child's with the same rules as env overlays:
+// mappings recurse, scalars and sequences in the child replace the
+// parent's. Parents are resolved first, so chains work; cycles and
+// unknown parents are errors. Services whose key starts with "_" (e.g.
+// _defaults) are templates: they can be extended but are removed from
+// the result and never deployed.
+func resolveExtends(doc *yaml.Node) error {
+	root := doc
+	if root.Kind == yaml.DocumentNode {
+		if len(root.Content) != 1 {
+			return nil
+		}
+		root = root.Content[0]
+	}
+	if root.Kind != yaml.MappingNode {
+		return nil
+	}
+	idx := mappingIndex(root, "services")
+	if idx < 0 || root.Content[idx+1].Kind != yaml.MappingNode {
+		return nil
+	}
+	services := root.Content[idx+1]
+
+	nodes := make(map[string]*yaml.Node, len(services.Content)/2)
+	for i := 0; i+1 < len(services.Content); i += 2 {
+		nodes[services.Content[i].Value] = services.Content[i+1]
+	}
+
+	done := make(map[string]bool, len(nodes))
+	var resolve func(name string, chain []string) error
+	resolve = func(name string, chain []string) error {
+		if done[name] {
+			return nil
+		}
+		for _, seen := range chain {
+			if seen == name {
+				return fmt.Errorf("extends cycle: %s", strings.Join(append(chain, name), " -> "))
+			}
+		}
+		node := nodes[name]
+		if node.Kind != yaml.MappingNode {
+			done[name] = true
+			return nil
+		}
+		ext := mappingIndex(node, "extends")
+		if ext < 0 {
+			done[name] = true
+			return nil
+		}
+		parentName := node.Content[ext+1].Value
+		if node.Content[ext+1].Kind != yaml.ScalarNode || parentName == "" {
+			return fmt.Errorf("service %q: extends must be a service name", name)
+		}
+		parent, ok := nodes[parentName]
+		if !ok {
+			return fmt.Errorf("service %q extends unknown service %q", name, parentName)
+		}
+		if err := resolve(parentName, append(chain, name)); err != nil {
+			return err
+		}
+		parent = nodes[parentName]
+
+		child := &yaml.Node{Kind: yaml.MappingNode, Tag: node.Tag, Line: node.Line, Column: node.Column}
+		child.Content = append(append(child.Content, node.Content[:ext]...), node.Content[ext+2:]...)
+		merged := child
+		if parent.Kind == yaml.MappingNode {
+			base := copyNode(parent)
+			if n := mappingIndex(base, "name"); n >= 0 {
+				base.Content = append(base.Content[:n], base.Content[n+2:]...)
+			}
+			merged = mergeNodes(base, child)
+		}
+		nodes[name] = merged
+		done[name] = true
+		return nil
+	}
+
+	kept := make([]*yaml.Node, 0, len(services.Content))
+	for i := 0; i+1 < len(services.Content); i += 2 {
+		name := services.Content[i].Value
+		if err := resolve(name, nil); err != nil {
+			return err
+		}
+		if strings.HasPrefix(name, "_") {
+			continue
+		}
+		kept = append(kept, services.Content[i], nodes[name])
+	}
+	services.Content = kept
+	return nil
+}
+
+// copyNode returns a deep copy of n so merging into it leaves n intact.
+func copyNode(n *yaml.Node) *yaml.Node {
+	c := *n
+	if len(n.Content) > 0 {
+		c.Content = make([]*yaml.Node, len(n.Content))
+		for i, child := range n.Content {
+			c.Content[i] = copyNode(child)
+		}
+	}
+	return &c
+}
+
 // GetService returns the configuration for a specific service
 // serviceName is required when Services map exists
 func (r *RootConfig) GetService(serviceName string) (*Config, error) {
@@ -394,12 +888,30 @@ func (r *RootConfig) GetService(serviceName string) (*Config, error) {
 
 	// Inherit root-level values if not set on service
 	cfg := *svc
-	if cfg.Server == "" {
-		cfg.Server = r.Server
+	switch {
+	case cfg.Servers == nil:
+		if cfg.Server == "" {
+			cfg.Server = r.Server
+		}
+	case cfg.Server != "":
+		return nil, fmt.Errorf("cannot set both server and servers")
+	case len(cfg.Servers) > 0:
+		// Every command but deploy talks to the first server.
+		cfg.Server = cfg.Servers[0]
 	}
 	if cfg.Stack == "" {
 		cfg.Stack = r.Stack
 	}
+	cfg.Project = r.Project
+	cfg.ImageTemplate = r.ImageTemplate
+	cfg.StacksRoot = r.StacksRoot
+	cfg.ComposeFile = r.ComposeFile
+	cfg.SSHClient = r.SSHClient
+	cfg.ProjectDir = r.ProjectDir
+	cfg.Events = r.Events
+	cfg.HostKey = r.HostKey
+	cfg.HostKeyChecking = r.HostKeyChecking
+	cfg.ActiveProfiles = r.ActiveProfiles
 	if (cfg.Deploy == nil || cfg.Deploy.Strategy == "") && r.Deploy != nil && r.Deploy.Strategy != "" {
 		if cfg.Deploy == nil {
 			cfg.Deploy = &DeployConfig{Strategy: r.Deploy.Strategy}
@@ -445,6 +957,17 @@ func (r *RootConfig) IsSingleService() bool {
 	return len(r.Services) == 0
 }
 
+// OverrideStack replaces the root and every service's stack path with
+// stack (deploy --stack), e.g. to deploy a staging copy. GetService then
+// validates it like stack and derives the project and image names,
+// networks and lock keys from it.
+func (r *RootConfig) OverrideStack(stack string) {
+	r.Stack = stack
+	for _, svc := range r.Services {
+		svc.Stack = stack
+	}
+}
+
 // validateDomainConfig validates domain and domains fields
 func validateDomainConfig(cfg *Config) error {
 	hasDomain := cfg.Domain != ""
@@ -509,6 +1032,9 @@ func validateRedirectTo(redirectTo string, domains []string) error {
 
 // validateConfig validates all fields of a resolved config
 func validateConfig(cfg *Config) error {
+	if err := validateServers(cfg); err != nil {
+		return err
+	}
 	if err := ValidateServer(cfg.Server); err != nil {
 		return fmt.Errorf("invalid server: %w", err)
 	}
@@ -527,17 +1053,45 @@ func validateConfig(cfg *Config) error {
 		}
 	}
 
+	if err := validateRouter(cfg); err != nil {
+		return err
+	}
+	if cfg.RoutePriority < 0 {
+		return fmt.Errorf("route_priority must be positive, got %d", cfg.RoutePriority)
+	}
+	if cfg.RoutePriority > 0 && cfg.PrimaryDomain() == "" {
+		return fmt.Errorf("route_priority requires domain to be set")
+	}
+	if err := validateEntrypoints(cfg); err != nil {
+		return err
+	}
+
 	if err := validateDependsOn(cfg.DependsOn); err != nil {
 		return err
 	}
 
+	if cfg.Port < 1 || cfg.Port > 65535 {
+		return fmt.Errorf("invalid port %d: must be between 1 and 65535", cfg.Port)
+	}
+
 	for _, portMapping := range cfg.Ports {
 		if err := ValidatePortMapping(portMapping); err != nil {
 			return fmt.Errorf("invalid port mapping %q: %w", portMapping, err)
 		}
 	}
 
-	for volumeName := range cfg.Volumes {
+	for volumeName, mount := range cfg.Volumes {
+		if _, mode := SplitVolumeMount(mount); mode != "" {
+			if err := ValidateVolumeMode(mode); err != nil {
+				return fmt.Errorf("invalid mode for volume %q: %w", volumeName, err)
+			}
+		}
+		if IsBindMount(volumeName) {
+			if err := ValidateBindMountPath(volumeName); err != nil {
+				return fmt.Errorf("invalid bind mount %q: %w", volumeName, err)
+			}
+			continue
+		}
 		if err := ValidateVolumeName(volumeName); err != nil {
 			return fmt.Errorf("invalid volume name %q: %w", volumeName, err)
 		}
@@ -551,20 +1105,121 @@ func validateConfig(cfg *Config) error {
 		return fmt.Errorf("invalid env_file: %w", err)
 	}
 
+	if err := ValidateEnvFiles(cfg.EnvFiles, cfg.Name); err != nil {
+		return fmt.Errorf("invalid env_files: %w", err)
+	}
+
 	if err := ValidateHealthCheck(cfg.HealthCheck); err != nil {
 		return fmt.Errorf("invalid healthcheck: %w", err)
 	}
 
+	if cfg.Project != "" {
+		if err := ValidateProjectName(cfg.Project); err != nil {
+			return fmt.Errorf("invalid project: %w", err)
+		}
+	}
+
+	if cfg.ImageTemplate != "" {
+		if err := ValidateImageTemplate(cfg.ImageTemplate); err != nil {
+			return fmt.Errorf("invalid image_template: %w", err)
+		}
+	}
+
 	if cfg.Target != "" {
 		if err := ValidateTarget(cfg.Target); err != nil {
 			return fmt.Errorf("invalid target: %w", err)
 		}
 	}
 
+	if cfg.Platform != "" {
+		if err := ValidatePlatform(cfg.Platform); err != nil {
+			return fmt.Errorf("invalid platform: %w", err)
+		}
+	}
+
+	for key := range cfg.BuildArgs {
+		if err := ValidateBuildArgName(key); err != nil {
+			return fmt.Errorf("invalid build_args: %w", err)
+		}
+	}
+
+	if cfg.Build != nil {
+		if err := validateBuildMounts(cfg.Build); err != nil {
+			return err
+		}
+	}
+
+	if err := validateResources(cfg); err != nil {
+		return err
+	}
+
+	for _, name := range slices.Sorted(maps.Keys(cfg.Ulimits)) {
+		if err := ValidateUlimit(name, cfg.Ulimits[name]); err != nil {
+			return fmt.Errorf("invalid ulimits: %w", err)
+		}
+	}
+
+	for _, capability := range cfg.CapAdd {
+		if err := ValidateCapability(capability); err != nil {
+			return fmt.Errorf("invalid cap_add: %w", err)
+		}
+	}
+	for _, capability := range cfg.CapDrop {
+		if err := ValidateCapability(capability); err != nil {
+			return fmt.Errorf("invalid cap_drop: %w", err)
+		}
+	}
+
+	for _, host := range slices.Sorted(maps.Keys(cfg.ExtraHosts)) {
+		if err := ValidateExtraHost(host, cfg.ExtraHosts[host]); err != nil {
+			return fmt.Errorf("invalid extra_hosts: %w", err)
+		}
+	}
+
+	for _, key := range slices.Sorted(maps.Keys(cfg.Sysctls)) {
+		if err := ValidateSysctl(key, cfg.Sysctls[key]); err != nil {
+			return fmt.Errorf("invalid sysctls: %w", err)
+		}
+	}
+
+	if cfg.Schedule != "" {
+		if err := schedule.Validate(cfg.Schedule); err != nil {
+			return fmt.Errorf("invalid schedule: %w", err)
+		}
+	}
+
+	if err := ValidatePreStart(cfg.PreStart); err != nil {
+		return fmt.Errorf("invalid pre_start: %w", err)
+	}
+
+	if err := ValidateSmokeTest(cfg.SmokeTest); err != nil {
+		return fmt.Errorf("invalid smoke_test: %w", err)
+	}
+	if cfg.SmokeTest != nil && cfg.SmokeTest.URL == "" {
+		if _, err := cfg.PublicURL(); err != nil {
+			return fmt.Errorf("invalid smoke_test: path needs the service's URL: %w", err)
+		}
+	}
+
+	for _, profile := range cfg.Profiles {
+		if err := ValidateProfile(profile); err != nil {
+			return fmt.Errorf("invalid profile %q: %w", profile, err)
+		}
+	}
+
 	if err := validateDeployStrategy(cfg.Deploy); err != nil {
 		return err
 	}
 
+	if cfg.Image != "" {
+		if err := ValidateImage(cfg.Image); err != nil {
+			return fmt.Errorf("invalid image: %w", err)
+		}
+	}
+	if cfg.Deploy != nil && cfg.Deploy.PinDigest && cfg.Image == "" {
+		return fmt.Errorf("deploy.pin_digest requires a pre-built image")
+	}
+
 	if err := validateCleanup(cfg.Cleanup); err != nil {
 		return err
 	}
@@ -603,11 +1258,21 @@ func validateDeployStrategy(deploy *DeployConfig) error {
 	if deploy.Replicas != nil && *deploy.Replicas < 0 {
 		return fmt.Errorf("invalid replicas %d: must be >= 0", *deploy.Replicas)
 	}
+	if deploy.HealthTimeout != "" {
+		if err := validateDuration(deploy.HealthTimeout); err != nil {
+			return fmt.Errorf("invalid deploy health_timeout: %w", err)
+		}
+	}
+	if deploy.HealthGrace != "" {
+		if err := validateDuration(deploy.HealthGrace); err != nil {
+			return fmt.Errorf("invalid deploy health_grace: %w", err)
+		}
+	}
 	switch deploy.Strategy {
-	case "rollout", "recreate":
+	case "rollout", "recreate", "none":
 		return nil
 	default:
-		return fmt.Errorf("invalid deploy strategy %q: must be rollout or recreate", deploy.Strategy)
+		return fmt.Errorf("invalid deploy strategy %q: must be rollout, recreate or none", deploy.Strategy)
 	}
 }
 
@@ -627,44 +1292,179 @@ func validateDependsOn(deps Dependencies) error {
 	return nil
 }
 
-// applyDefaults fills in default values for a config and validates the stack path
-func applyDefaults(cfg *Config, serviceName string) (*Config, error) {
-	result := *cfg
+// DefaultComposeFilename is the compose file written to the stack
+// directory when compose_filename is not set.
+const DefaultComposeFilename = "compose.yaml"
 
-	// Default name: use service name or current directory name
-	if result.Name == "" {
-		if serviceName != "" {
-			result.Name = serviceName
-		} else {
-			if cwd, err := os.Getwd(); err == nil {
-				result.Name = filepath.Base(cwd)
-			}
-		}
+// ComposeFilename returns the compose filename in the stack directory:
+// compose_filename, or compose.yaml.
+func (c *Config) ComposeFilename() string {
+	if c.ComposeFile == "" {
+		return DefaultComposeFilename
 	}
+	return c.ComposeFile
+}
 
-	// Validate name before using it in stack path
-	if err := ValidateName(result.Name); err != nil {
-		return nil, fmt.Errorf("invalid service name: %w", err)
-	}
+var composeFilenamePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*\.ya?ml$`)
 
-	// Default stack: /stacks/{name}
-	// If stack is set, use it as the full path (don't append name)
-	if result.Stack == "" {
-		result.Stack = filepath.Join("/stacks", result.Name)
+// ValidateComposeFilename validates compose_filename: a plain file name
+// (no directory) ending in .yaml or .yml.
+func ValidateComposeFilename(name string) error {
+	if !composeFilenamePattern.MatchString(name) {
+		return fmt.Errorf("%q must be a file name ending in .yaml or .yml (e.g. docker-compose.yml)", name)
 	}
+	return nil
+}
 
-	// Validate stack path
-	if err := ValidateStackPath(result.Stack); err != nil {
-		return nil, fmt.Errorf("invalid stack path: %w", err)
-	}
+// SSH client implementations selectable with ssh_client.
+const (
+	SSHClientOpenSSH = "openssh"
+	SSHClientNative  = "native"
+)
 
-	// Default dockerfile: ./Dockerfile
-	if result.Dockerfile == "" {
-		result.Dockerfile = "./Dockerfile"
+// ValidateSSHClient validates ssh_client: empty (openssh), openssh or native.
+func ValidateSSHClient(client string) error {
+	switch client {
+	case "", SSHClientOpenSSH, SSHClientNative:
+		return nil
 	}
+	return fmt.Errorf("%q must be %s or %s", client, SSHClientOpenSSH, SSHClientNative)
+}
 
-	// Default context: .
-	if result.Context == "" {
+// strict_host_key_checking values, named as in OpenSSH.
+const (
+	HostKeyCheckingYes       = "yes"
+	HostKeyCheckingAcceptNew = "accept-new"
+	HostKeyCheckingNo        = "no"
+)
+
+// HostKeyCheckingMode returns strict_host_key_checking, defaulting to yes.
+// A pinned host_key is always checked strictly.
+func (c *Config) HostKeyCheckingMode() string {
+	if c.HostKeyChecking == "" || c.HostKey != "" {
+		return HostKeyCheckingYes
+	}
+	return c.HostKeyChecking
+}
+
+// ValidateHostKeyChecking validates strict_host_key_checking.
+func ValidateHostKeyChecking(mode string) error {
+	switch mode {
+	case "", HostKeyCheckingYes, HostKeyCheckingAcceptNew, HostKeyCheckingNo:
+		return nil
+	}
+	return fmt.Errorf("%q must be %s, %s or %s", mode, HostKeyCheckingYes, HostKeyCheckingAcceptNew, HostKeyCheckingNo)
+}
+
+var (
+	hostKeyPattern     = regexp.MustCompile(`^(ssh-ed25519|ssh-rsa|ecdsa-sha2-nistp(256|384|521)) ([A-Za-z0-9+/]+={0,2})( .*)?$`)
+	fingerprintPattern = regexp.MustCompile(`^SHA256:[A-Za-z0-9+/]{43}$`)
+)
+
+// IsHostKeyFingerprint reports whether host_key is a SHA256 fingerprint
+// rather than a public key.
+func IsHostKeyFingerprint(key string) bool {
+	return strings.HasPrefix(key, "SHA256:")
+}
+
+// ValidateHostKey validates host_key: "<type> <base64>" (an optional
+// trailing comment is allowed) or "SHA256:<43 base64 chars>".
+func ValidateHostKey(key string) error {
+	if IsHostKeyFingerprint(key) {
+		if !fingerprintPattern.MatchString(key) {
+			return fmt.Errorf("%q is not a SHA256 fingerprint (SHA256: followed by 43 base64 characters)", key)
+		}
+		return nil
+	}
+	m := hostKeyPattern.FindStringSubmatch(key)
+	if m == nil {
+		return fmt.Errorf("must be a public key like \"ssh-ed25519 AAAA...\" (see ssh-keyscan) or a SHA256:... fingerprint")
+	}
+	if _, err := base64.StdEncoding.DecodeString(m[3]); err != nil {
+		return fmt.Errorf("public key is not valid base64: %w", err)
+	}
+	return nil
+}
+
+// DefaultStacksRoot is the parent of default stack paths when stacks_root
+// is not set.
+const DefaultStacksRoot = "/stacks"
+
+// applyDefaults fills in default values for a config and validates the stack path
+func applyDefaults(cfg *Config, serviceName string) (*Config, error) {
+	result := *cfg
+
+	// Default name: use service name or current directory name
+	if result.Name == "" {
+		if serviceName != "" {
+			result.Name = serviceName
+		} else {
+			if cwd, err := os.Getwd(); err == nil {
+				result.Name = filepath.Base(cwd)
+			}
+		}
+	}
+
+	// Validate name before using it in stack path
+	if err := ValidateName(result.Name); err != nil {
+		return nil, fmt.Errorf("invalid service name: %w", err)
+	}
+
+	root := DefaultStacksRoot
+	if result.StacksRoot != "" {
+		if err := ValidateStackPath(result.StacksRoot); err != nil {
+			return nil, fmt.Errorf("invalid stacks_root: %w", err)
+		}
+		root = result.StacksRoot
+	}
+
+	// Default stack: {stacks_root}/{name}, stacks_root defaulting to /stacks
+	// If stack is set, use it as the full path (don't append name), or with
+	// stacks_root set, resolve a relative stack as {stacks_root}/{stack}
+	switch {
+	case result.Stack == "":
+		result.Stack = filepath.Join(root, result.Name)
+	case result.StacksRoot != "" && !strings.HasPrefix(result.Stack, "/"):
+		// Checked before joining: filepath.Join would clean "../etc" away
+		if strings.Contains(result.Stack, "..") {
+			return nil, fmt.Errorf("invalid stack path: stack path contains path traversal sequence (..)")
+		}
+		result.Stack = filepath.Join(root, result.Stack)
+	}
+
+	// Validate stack path
+	if err := ValidateStackPath(result.Stack); err != nil {
+		return nil, fmt.Errorf("invalid stack path: %w", err)
+	}
+
+	if result.ComposeFile != "" {
+		if err := ValidateComposeFilename(result.ComposeFile); err != nil {
+			return nil, fmt.Errorf("invalid compose_filename: %w", err)
+		}
+	}
+
+	if err := ValidateSSHClient(result.SSHClient); err != nil {
+		return nil, fmt.Errorf("invalid ssh_client: %w", err)
+	}
+	if err := ValidateHostKeyChecking(result.HostKeyChecking); err != nil {
+		return nil, fmt.Errorf("invalid strict_host_key_checking: %w", err)
+	}
+	if result.HostKey != "" {
+		if err := ValidateHostKey(result.HostKey); err != nil {
+			return nil, fmt.Errorf("invalid host_key: %w", err)
+		}
+		if IsHostKeyFingerprint(result.HostKey) && result.SSHClient != SSHClientNative {
+			return nil, fmt.Errorf("invalid host_key: a fingerprint can only be checked with ssh_client: native; give the public key (ssh-keyscan -t ed25519 <host>) instead")
+		}
+	}
+
+	// Default dockerfile: ./Dockerfile
+	if result.Dockerfile == "" {
+		result.Dockerfile = "./Dockerfile"
+	}
+
+	// Default context: .
+	if result.Context == "" {
 		result.Context = "."
 	}
 
@@ -689,13 +1489,55 @@ func (c *Config) StackPath() string {
 	return c.Stack
 }
 
+// ProjectName returns the project name used for image names, the internal
+// network, Traefik router names and the compose project. Defaults to the
+// stack directory basename; the root-level project field overrides it so
+// stacks sharing a leaf directory name (/a/web, /b/web) don't collide.
+func (c *Config) ProjectName() string {
+	if c.Project != "" {
+		return c.Project
+	}
+	return filepath.Base(c.Stack)
+}
+
 // ImageName returns the Docker image name (without tag)
 func (c *Config) ImageName() string {
 	if c.Image != "" {
 		return c.Image // pre-built image
 	}
-	project := filepath.Base(c.Stack)
-	return fmt.Sprintf("ssd-%s-%s", project, c.Name)
+	return c.BuiltImageName()
+}
+
+// BuiltImageName returns the ssd-managed image name (without tag) for this
+// service, ignoring any pre-built image: image_template rendered for its
+// project and name. Used to locate version tags in generated manifests.
+func (c *Config) BuiltImageName() string {
+	return RenderImageName(c.ImageTemplate, c.ProjectName(), c.Name)
+}
+
+// DefaultImageTemplate is the image name used when image_template is unset.
+const DefaultImageTemplate = "ssd-{project}-{service}"
+
+// RenderImageName returns the built image name (without tag) for a
+// service: template with {project} and {service} replaced, or
+// DefaultImageTemplate when template is empty.
+func RenderImageName(template, project, service string) string {
+	if template == "" {
+		template = DefaultImageTemplate
+	}
+	return strings.NewReplacer("{project}", project, "{service}", service).Replace(template)
+}
+
+// ProjectName returns the project name shared by a set of services: the
+// explicit project when any service carries one, otherwise the stack
+// directory basename.
+func ProjectName(services map[string]*Config, stack string) string {
+	for _, cfg := range services {
+		if cfg != nil && cfg.Project != "" {
+			return cfg.Project
+		}
+	}
+	return filepath.Base(stack)
 }
 
 // IsPrebuilt returns true if this config uses a pre-built image
@@ -703,7 +1545,9 @@ func (c *Config) IsPrebuilt() bool {
 	return c.Image != ""
 }
 
-// DeployStrategy returns the deploy strategy for this config
+// DeployStrategy returns the deploy strategy for this config. "none"
+// never uses docker rollout: the service is recreated in place, like
+// "recreate".
 func (c *Config) DeployStrategy() string {
 	if c.Deploy == nil {
 		return "rollout"
@@ -719,6 +1563,97 @@ func (c *Config) Replicas() int {
 	return *c.Deploy.Replicas
 }
 
+// HealthGateEnabled returns true if deploys should wait for the service to
+// become healthy and roll back when it doesn't.
+func (c *Config) HealthGateEnabled() bool {
+	return c.Deploy != nil && c.Deploy.HealthGate
+}
+
+// SkipUnchanged returns true if deploys track the build context's git tree
+// and skip when it is unchanged (unless deploy --force). Pre-built images
+// have no context and never skip.
+func (c *Config) SkipUnchanged() bool {
+	return c.Deploy != nil && c.Deploy.SkipUnchanged && !c.IsPrebuilt()
+}
+
+// PinDigest returns true if deploys should pin the pulled image by digest.
+// Images already given by digest are immutable and need no pinning.
+func (c *Config) PinDigest() bool {
+	return c.Deploy != nil && c.Deploy.PinDigest && c.IsPrebuilt() && !IsDigestImage(c.Image)
+}
+
+// Health gate window bounds. Without a healthcheck there is nothing to
+// derive the window from, so it is defaultHealthTimeout.
+const (
+	defaultHealthTimeout = 60 * time.Second
+	maxHealthTimeout     = 10 * time.Minute
+	healthTimeoutBuffer  = 30 * time.Second
+)
+
+// Docker's healthcheck defaults, used when the field is unset.
+const (
+	dockerHealthInterval = 30 * time.Second
+	dockerHealthTimeout  = 30 * time.Second
+	dockerHealthRetries  = 3
+)
+
+// HealthGateTimeout returns how long the health gate waits. An explicit
+// deploy.health_timeout wins. Otherwise, with a healthcheck, the window is
+// the longest Docker can take to declare the container unhealthy:
+//
+//	retries * (interval + timeout) + start_period + 30s
+//
+// using Docker's defaults for unset fields, capped at 10 minutes. Without
+// a healthcheck it is 60s.
+func (c *Config) HealthGateTimeout() time.Duration {
+	if c.Deploy != nil && c.Deploy.HealthTimeout != "" {
+		if d, err := time.ParseDuration(c.Deploy.HealthTimeout); err == nil {
+			return d
+		}
+	}
+	if c.HealthCheck == nil {
+		return defaultHealthTimeout
+	}
+
+	hc := c.HealthCheck
+	interval := parseDurationOr(hc.Interval, dockerHealthInterval)
+	timeout := parseDurationOr(hc.Timeout, dockerHealthTimeout)
+	retries := hc.Retries
+	if retries == 0 {
+		retries = dockerHealthRetries
+	}
+	window := time.Duration(retries)*(interval+timeout) + parseDurationOr(hc.StartPeriod, 0) + healthTimeoutBuffer
+	if window > maxHealthTimeout {
+		return maxHealthTimeout
+	}
+	return window
+}
+
+// parseDurationOr parses d, returning def when d is empty or invalid.
+func parseDurationOr(d string, def time.Duration) time.Duration {
+	if d == "" {
+		return def
+	}
+	parsed, err := time.ParseDuration(d)
+	if err != nil {
+		return def
+	}
+	return parsed
+}
+
+// HealthGrace returns how long a service without a healthcheck must stay
+// running to pass the health gate; 0 when unset.
+func (c *Config) HealthGrace() time.Duration {
+	if c.Deploy == nil || c.Deploy.HealthGrace == "" {
+		return 0
+	}
+	d, err := time.ParseDuration(c.Deploy.HealthGrace)
+	if err != nil {
+		return 0
+	}
+	return d
+}
+
 // RetainTags returns the number of image tags to keep on the server after
 // a successful deploy. Defaults to 2 (current + rollback target) when unset.
 // 0 disables auto cleanup on deploy.
@@ -738,6 +1673,12 @@ func (c *Config) UseHTTPS() bool {
 	return *c.HTTPS
 }
 
+// IsTCPRouter reports whether the service is routed by a Traefik TCP
+// router instead of an HTTP one.
+func (c *Config) IsTCPRouter() bool {
+	return c.Router == "tcp"
+}
+
 // PrimaryDomain returns the primary domain for this config
 // Returns redirect_to if set, otherwise Domain field, otherwise first domain from Domains array
 // Returns empty string if none are set
@@ -754,6 +1695,53 @@ func (c *Config) PrimaryDomain() string {
 	return ""
 }
 
+// PublicURL returns the URL the service is reachable at through Traefik:
+// https://<primary domain><path>, or http:// when https is false.
+// Returns an error when the service has no domain or uses a TCP router.
+func (c *Config) PublicURL() (string, error) {
+	domain := c.PrimaryDomain()
+	if domain == "" {
+		return "", fmt.Errorf("service %q has no domain; set domain in ssd.yaml to expose it", c.Name)
+	}
+	if c.IsTCPRouter() {
+		return "", fmt.Errorf("service %q uses a tcp router and has no HTTP URL", c.Name)
+	}
+	scheme := "https"
+	if !c.UseHTTPS() {
+		scheme = "http"
+	}
+	return scheme + "://" + domain + c.Path, nil
+}
+
+// defaultSmokeTimeout is how long a smoke test keeps trying when
+// smoke_test.timeout is unset.
+const defaultSmokeTimeout = 30 * time.Second
+
+// SmokeTestURL returns the URL the smoke test requests: smoke_test.url,
+// or smoke_test.path appended to PublicURL.
+func (c *Config) SmokeTestURL() (string, error) {
+	if c.SmokeTest == nil {
+		return "", fmt.Errorf("service %q has no smoke_test", c.Name)
+	}
+	if c.SmokeTest.URL != "" {
+		return c.SmokeTest.URL, nil
+	}
+	base, err := c.PublicURL()
+	if err != nil {
+		return "", err
+	}
+	return base + c.SmokeTest.Path, nil
+}
+
+// SmokeTestTimeout returns how long the smoke test keeps trying; 30s when
+// unset.
+func (c *Config) SmokeTestTimeout() time.Duration {
+	if c.SmokeTest == nil {
+		return defaultSmokeTimeout
+	}
+	return parseDurationOr(c.SmokeTest.Timeout, defaultSmokeTimeout)
+}
+
 // AliasDomains returns domains that should redirect to the primary domain
 // Returns nil if using single Domain field or if redirect_to is not set
 // When redirect_to is set, returns all domains except redirect_to
@@ -771,6 +1759,46 @@ func (c *Config) AliasDomains() []string {
 	return aliases
 }
 
+// validateServers validates the servers list: non-empty, valid hosts, no
+// host twice.
+func validateServers(cfg *Config) error {
+	if cfg.Servers == nil {
+		return nil
+	}
+	if len(cfg.Servers) == 0 {
+		return fmt.Errorf("servers cannot be empty")
+	}
+	seen := make(map[string]bool, len(cfg.Servers))
+	for i, server := range cfg.Servers {
+		if err := ValidateServer(server); err != nil {
+			return fmt.Errorf("invalid server at index %d: %w", i, err)
+		}
+		if seen[server] {
+			return fmt.Errorf("server %q is listed twice in servers", server)
+		}
+		seen[server] = true
+	}
+	return nil
+}
+
+// Hosts returns every server the service deploys to: Servers when set,
+// otherwise just Server.
+func (c *Config) Hosts() []string {
+	if len(c.Servers) > 0 {
+		return c.Servers
+	}
+	return []string{c.Server}
+}
+
+// OnServer returns a copy of the config bound to a single host, for
+// fanning a deploy out across Hosts.
+func (c *Config) OnServer(server string) *Config {
+	cp := *c
+	cp.Server = server
+	cp.Servers = nil
+	return &cp
+}
+
 // ValidateServer validates a server hostname/identifier
 // Returns an error if the server name contains shell metacharacters or is invalid
 func ValidateServer(server string) error {
@@ -839,6 +1867,60 @@ func ValidateName(name string) error {
 	return nil
 }
 
+// imageTemplatePlaceholder matches a {name} placeholder in image_template.
+var imageTemplatePlaceholder = regexp.MustCompile(`\{[^{}]*\}`)
+
+// imageRepoPattern matches an image name without tag: lowercase path
+// components separated by '/', the first optionally a registry host with
+// a port.
+var imageRepoPattern = regexp.MustCompile(`^[a-z0-9]+([._-][a-z0-9]+)*(:[0-9]+)?(/[a-z0-9]+([._-]+[a-z0-9]+)*)*$`)
+
+// ValidateImageTemplate validates image_template: only the {project} and
+// {service} placeholders, {service} required so services get distinct
+// images, and the rendered name a valid image name without a tag (the
+// version tag is appended by ssd).
+func ValidateImageTemplate(template string) error {
+	for _, p := range imageTemplatePlaceholder.FindAllString(template, -1) {
+		if p != "{project}" && p != "{service}" {
+			return fmt.Errorf("unknown placeholder %s: only {project} and {service} are supported", p)
+		}
+	}
+	if !strings.Contains(template, "{service}") {
+		return fmt.Errorf("%q must contain {service}", template)
+	}
+	rendered := RenderImageName(template, "project", "service")
+	if !imageRepoPattern.MatchString(rendered) {
+		return fmt.Errorf("%q does not render to a valid image name without tag (lowercase letters, digits, '.', '_', '-', '/' and an optional registry port)", template)
+	}
+	return nil
+}
+
+// ValidateProjectName validates a project name. Follows the Compose project
+// name rules: lowercase letters, digits, hyphens and underscores, starting
+// with a letter or digit.
+func ValidateProjectName(name string) error {
+	if name == "" {
+		return fmt.Errorf("project name cannot be empty")
+	}
+
+	if len(name) > 63 {
+		return fmt.Errorf("project name exceeds maximum length of 63 characters")
+	}
+
+	for i, r := range name {
+		isLower := r >= 'a' && r <= 'z'
+		isDigit := r >= '0' && r <= '9'
+		if i == 0 && !isLower && !isDigit {
+			return fmt.Errorf("project name must start with a lowercase letter or digit")
+		}
+		if !isLower && !isDigit && r != '-' && r != '_' {
+			return fmt.Errorf("project name contains invalid character: %c (only lowercase letters, digits, hyphens, and underscores allowed)", r)
+		}
+	}
+
+	return nil
+}
+
 // ValidateStackPath validates a stack path for security and correctness
 func ValidateStackPath(path string) error {
 	// Reject empty paths
@@ -925,6 +2007,122 @@ func ValidateDomain(domain string) error {
 	return nil
 }
 
+// HostGateway is the extra_hosts address Docker replaces with the host's
+// IP on the default bridge.
+const HostGateway = "host-gateway"
+
+var hostnamePattern = regexp.MustCompile(`^[A-Za-z0-9]([A-Za-z0-9-]*[A-Za-z0-9])?(\.[A-Za-z0-9]([A-Za-z0-9-]*[A-Za-z0-9])?)*$`)
+
+// ValidateExtraHost validates an extra_hosts entry: a hostname and the IP
+// address (v4 or v6, or host-gateway) it resolves to.
+func ValidateExtraHost(host, ip string) error {
+	if len(host) > 253 || !hostnamePattern.MatchString(host) {
+		return fmt.Errorf("invalid hostname %q", host)
+	}
+	if ip == HostGateway {
+		return nil
+	}
+	if net.ParseIP(ip) == nil {
+		return fmt.Errorf("%s: invalid IP address %q", host, ip)
+	}
+	return nil
+}
+
+// validateRouter validates the router type. A TCP router matches on the
+// TLS SNI of the connection, so it needs a domain and has no path or
+// redirects.
+func validateRouter(cfg *Config) error {
+	switch cfg.Router {
+	case "", "http":
+		return nil
+	case "tcp":
+	default:
+		return fmt.Errorf("invalid router %q: must be http or tcp", cfg.Router)
+	}
+	if cfg.PrimaryDomain() == "" {
+		return fmt.Errorf("router: tcp requires domain to be set")
+	}
+	if cfg.Path != "" {
+		return fmt.Errorf("router: tcp cannot be combined with path")
+	}
+	if cfg.RoutePriority != 0 {
+		return fmt.Errorf("router: tcp cannot be combined with route_priority")
+	}
+	if cfg.Entrypoints != nil {
+		return fmt.Errorf("router: tcp cannot be combined with entrypoints")
+	}
+	if cfg.RedirectTo != "" {
+		return fmt.Errorf("router: tcp cannot be combined with redirect_to")
+	}
+	return nil
+}
+
+// validateResources validates the CPU and memory limits and reservations.
+// A reservation cannot exceed the limit of the same resource.
+func validateResources(cfg *Config) error {
+	var cpuLimit, cpuReservation float64
+	var memLimit, memReservation int64
+	var err error
+	if cfg.CPULimit != "" {
+		if cpuLimit, err = ParseCPUs(cfg.CPULimit); err != nil {
+			return fmt.Errorf("invalid cpu_limit: %w", err)
+		}
+	}
+	if cfg.CPUReservation != "" {
+		if cpuReservation, err = ParseCPUs(cfg.CPUReservation); err != nil {
+			return fmt.Errorf("invalid cpu_reservation: %w", err)
+		}
+	}
+	if cfg.MemoryLimit != "" {
+		if memLimit, err = ParseMemory(cfg.MemoryLimit); err != nil {
+			return fmt.Errorf("invalid memory_limit: %w", err)
+		}
+	}
+	if cfg.MemoryReservation != "" {
+		if memReservation, err = ParseMemory(cfg.MemoryReservation); err != nil {
+			return fmt.Errorf("invalid memory_reservation: %w", err)
+		}
+	}
+
+	if cpuLimit > 0 && cpuReservation > cpuLimit {
+		return fmt.Errorf("cpu_reservation %s exceeds cpu_limit %s", cfg.CPUReservation, cfg.CPULimit)
+	}
+	if memLimit > 0 && memReservation > memLimit {
+		return fmt.Errorf("memory_reservation %s exceeds memory_limit %s", cfg.MemoryReservation, cfg.MemoryLimit)
+	}
+	return nil
+}
+
+// ParseCPUs parses a CPU count such as "0.5" or "2".
+func ParseCPUs(s string) (float64, error) {
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil || v <= 0 || math.IsInf(v, 0) {
+		return 0, fmt.Errorf("%q must be a positive number of CPUs (e.g. 0.5, 2)", s)
+	}
+	return v, nil
+}
+
+var memoryPattern = regexp.MustCompile(`^([0-9]+)([bkmg]?)$`)
+
+// ParseMemory parses a memory size in Docker's notation: a whole number
+// with an optional b, k, m or g suffix (e.g. "512m", "1g"), returned in
+// bytes.
+func ParseMemory(s string) (int64, error) {
+	m := memoryPattern.FindStringSubmatch(strings.ToLower(s))
+	if m == nil {
+		return 0, fmt.Errorf("%q must be a size like 512m or 1g (units b, k, m, g)", s)
+	}
+	n, err := strconv.ParseInt(m[1], 10, 64)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("%q must be a positive size", s)
+	}
+	shift := map[string]uint{"": 0, "b": 0, "k": 10, "m": 20, "g": 30}[m[2]]
+	if n > math.MaxInt64>>shift {
+		return 0, fmt.Errorf("%q is too large", s)
+	}
+	return n << shift, nil
+}
+
 // ValidatePath validates a URL path prefix for Traefik routing
 func ValidatePath(path string) error {
 	if path == "" {
@@ -1000,6 +2198,75 @@ func ValidateVolumeName(name string) error {
 	return nil
 }
 
+// IsBindMount reports whether a volumes key is an absolute host path
+// (bind mount) rather than a named volume.
+func IsBindMount(source string) bool {
+	return strings.HasPrefix(source, "/")
+}
+
+// ValidateBindMountPath validates a host path used as a bind-mount source.
+// It must be a clean absolute path below the root, without ':' (the compose
+// short-syntax separator) or shell metacharacters.
+func ValidateBindMountPath(path string) error {
+	if !filepath.IsAbs(path) {
+		return fmt.Errorf("host path must be absolute")
+	}
+	if len(path) > 4096 {
+		return fmt.Errorf("host path exceeds maximum length of 4096 characters")
+	}
+	if strings.Contains(path, "..") {
+		return fmt.Errorf("host path contains path traversal sequence (..)")
+	}
+	if filepath.Clean(path) != path {
+		return fmt.Errorf("host path must be clean (no trailing slash or repeated separators)")
+	}
+	if path == "/" {
+		return fmt.Errorf("cannot bind-mount the host root")
+	}
+	dangerousChars := ";|&$`(){}[]<>\\\"' *?:"
+	for _, r := range path {
+		if strings.ContainsRune(dangerousChars, r) {
+			return fmt.Errorf("host path contains invalid character: %c", r)
+		}
+	}
+	return nil
+}
+
+// volumeModes are the mount options accepted after the container path.
+var volumeModes = map[string]bool{
+	"ro": true, "rw": true,
+	"z": true, "Z": true,
+	"cached": true, "delegated": true, "consistent": true,
+	"nocopy": true,
+}
+
+// SplitVolumeMount splits a volumes value ("/path" or "/path:ro") into the
+// container path and the mode suffix (empty when absent).
+func SplitVolumeMount(value string) (mountPath, mode string) {
+	mountPath, mode, _ = strings.Cut(value, ":")
+	return mountPath, mode
+}
+
+// IsReadOnlyMode reports whether a volume mode contains the ro option.
+func IsReadOnlyMode(mode string) bool {
+	return slices.Contains(strings.Split(mode, ","), "ro")
+}
+
+// ValidateVolumeMode validates a comma-separated list of mount options
+// (e.g. "ro" or "ro,z"). ro and rw are mutually exclusive.
+func ValidateVolumeMode(mode string) error {
+	opts := strings.Split(mode, ",")
+	for _, opt := range opts {
+		if !volumeModes[opt] {
+			return fmt.Errorf("unknown mount option %q (allowed: ro, rw, z, Z, cached, delegated, consistent, nocopy)", opt)
+		}
+	}
+	if slices.Contains(opts, "ro") && slices.Contains(opts, "rw") {
+		return fmt.Errorf("mount options ro and rw are mutually exclusive")
+	}
+	return nil
+}
+
 // ValidateFiles validates the files mapping for security and correctness.
 // Keys are local relative paths, values are absolute container mount paths.
 func ValidateFiles(files map[string]string) error {
@@ -1086,6 +2353,59 @@ func ValidateEnvFile(path string) error {
 	return nil
 }
 
+// envFileNamePattern matches an env_files entry: a plain file name in the
+// stack directory ending in .env.
+var envFileNamePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*\.env$`)
+
+// ValidateEnvFiles validates a service's env_files: plain *.env file names
+// in the stack directory, each listed once and none the service's own
+// {service}.env, which is always loaded.
+func ValidateEnvFiles(files []string, service string) error {
+	seen := make(map[string]bool, len(files))
+	for _, f := range files {
+		if !envFileNamePattern.MatchString(f) {
+			return fmt.Errorf("%q must be a file name in the stack directory ending in .env (letters, digits, '.', '_', '-')", f)
+		}
+		if f == service+".env" {
+			return fmt.Errorf("%q is the service's own env file, which is always loaded", f)
+		}
+		if seen[f] {
+			return fmt.Errorf("%q is listed twice", f)
+		}
+		seen[f] = true
+	}
+	return nil
+}
+
+// envKeyPattern matches a variable name in an env file.
+var envKeyPattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// ValidateEnvContent checks the content of a {service}.env file: every
+// line is blank, a # comment, or KEY=VALUE with a valid variable name.
+// Keys may appear only once, since compose would silently keep the last.
+func ValidateEnvContent(content string) error {
+	seen := make(map[string]int)
+	for i, line := range strings.Split(content, "\n") {
+		n := i + 1
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+		key, _, ok := strings.Cut(line, "=")
+		if !ok {
+			return fmt.Errorf("line %d: expected KEY=VALUE, got %q", n, line)
+		}
+		if !envKeyPattern.MatchString(key) {
+			return fmt.Errorf("line %d: invalid variable name %q (letters, digits and underscores, not starting with a digit)", n, key)
+		}
+		if first, dup := seen[key]; dup {
+			return fmt.Errorf("line %d: %s is already set on line %d", n, key, first)
+		}
+		seen[key] = n
+	}
+	return nil
+}
+
 // ValidateHealthCheck validates a healthcheck configuration for security and correctness
 func ValidateHealthCheck(hc *HealthCheck) error {
 	if hc == nil {
@@ -1124,6 +2444,13 @@ func ValidateHealthCheck(hc *HealthCheck) error {
 		}
 	}
 
+	// Validate start_period format if set
+	if hc.StartPeriod != "" {
+		if err := validateDuration(hc.StartPeriod); err != nil {
+			return fmt.Errorf("invalid healthcheck start_period: %w", err)
+		}
+	}
+
 	// Validate retries range
 	if hc.Retries < 0 || hc.Retries > 100 {
 		return fmt.Errorf("healthcheck retries must be between 0 and 100")
@@ -1132,6 +2459,54 @@ func ValidateHealthCheck(hc *HealthCheck) error {
 	return nil
 }
 
+// ValidatePreStart validates a pre_start job. nil means no job.
+func ValidatePreStart(ps *PreStartConfig) error {
+	if ps == nil {
+		return nil
+	}
+	if strings.TrimSpace(ps.Command) == "" {
+		return fmt.Errorf("command is required")
+	}
+	if strings.ContainsAny(ps.Image, " \t\n'\"") {
+		return fmt.Errorf("image %q contains whitespace or quotes", ps.Image)
+	}
+	return nil
+}
+
+// ValidateSmokeTest validates a smoke_test. nil means no smoke test.
+func ValidateSmokeTest(st *SmokeTestConfig) error {
+	if st == nil {
+		return nil
+	}
+	switch {
+	case st.URL == "" && st.Path == "":
+		return fmt.Errorf("url or path is required")
+	case st.URL != "" && st.Path != "":
+		return fmt.Errorf("cannot set both url and path; pick one")
+	case st.URL != "":
+		u, err := url.Parse(st.URL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("url %q must be an absolute http or https URL", st.URL)
+		}
+		if strings.ContainsAny(st.URL, " \t\n'\"") {
+			return fmt.Errorf("url %q contains whitespace or quotes", st.URL)
+		}
+	default:
+		if err := ValidatePath(st.Path); err != nil {
+			return fmt.Errorf("invalid path: %w", err)
+		}
+	}
+	if st.Status != 0 && (st.Status < 100 || st.Status > 599) {
+		return fmt.Errorf("status %d must be between 100 and 599", st.Status)
+	}
+	if st.Timeout != "" {
+		if err := validateDuration(st.Timeout); err != nil {
+			return fmt.Errorf("invalid timeout: %w", err)
+		}
+	}
+	return nil
+}
+
 // ValidateTarget validates a Docker build target stage name
 func ValidateTarget(target string) error {
 	if target == "" {
@@ -1159,6 +2534,360 @@ func ValidateTarget(target string) error {
 	return nil
 }
 
+// buildMountIDPattern matches the id of a build.ssh or build.secrets
+// entry, as referenced by RUN --mount=type=ssh,id=... in the Dockerfile.
+var buildMountIDPattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]*$`)
+
+// validateBuildMounts validates build.ssh and build.secrets entries and
+// rejects ids used twice within either list.
+func validateBuildMounts(b *BuildConfig) error {
+	seen := make(map[string]bool)
+	for _, spec := range b.SSH {
+		if err := ValidateBuildSSH(spec); err != nil {
+			return fmt.Errorf("invalid build.ssh: %w", err)
+		}
+		id, _, _ := strings.Cut(spec, "=")
+		if seen[id] {
+			return fmt.Errorf("invalid build.ssh: id %q is used twice", id)
+		}
+		seen[id] = true
+	}
+	clear(seen)
+	for _, spec := range b.Secrets {
+		id, err := ValidateBuildSecret(spec)
+		if err != nil {
+			return fmt.Errorf("invalid build.secrets: %w", err)
+		}
+		if seen[id] {
+			return fmt.Errorf("invalid build.secrets: id %q is used twice", id)
+		}
+		seen[id] = true
+	}
+	return nil
+}
+
+// ValidateBuildSSH validates a build.ssh entry, passed to docker build
+// --ssh: an id, alone to use the server's SSH agent ($SSH_AUTH_SOCK) or
+// as id=path[,path...] naming agent sockets or keys on the server. Paths
+// must be absolute, since the build runs in a temporary directory.
+func ValidateBuildSSH(spec string) error {
+	id, paths, hasPaths := strings.Cut(spec, "=")
+	if !buildMountIDPattern.MatchString(id) {
+		return fmt.Errorf("%q: id must be letters, digits, '.', '_' or '-'", spec)
+	}
+	if !hasPaths {
+		return nil
+	}
+	for _, p := range strings.Split(paths, ",") {
+		if !path.IsAbs(p) {
+			return fmt.Errorf("%q: %q must be an absolute path on the server", spec, p)
+		}
+	}
+	return nil
+}
+
+// ValidateBuildSecret validates a build.secrets entry, passed to docker
+// build --secret, and returns its id. The secret comes from the server:
+// id=<id>,src=<absolute path> reads a file there and id=<id>,env=<VAR> a
+// variable in the build's environment. Nothing else is accepted, so a
+// secret value can't end up in ssd.yaml.
+func ValidateBuildSecret(spec string) (string, error) {
+	var id, src, env string
+	for _, field := range strings.Split(spec, ",") {
+		key, value, ok := strings.Cut(field, "=")
+		if !ok || value == "" {
+			return "", fmt.Errorf("%q: %q is not key=value", spec, field)
+		}
+		switch key {
+		case "id":
+			id = value
+		case "src", "source":
+			src = value
+		case "env":
+			env = value
+		default:
+			return "", fmt.Errorf("%q: unknown key %q (use id with src or env)", spec, key)
+		}
+	}
+	switch {
+	case !buildMountIDPattern.MatchString(id):
+		return "", fmt.Errorf("%q: id is required and must be letters, digits, '.', '_' or '-'", spec)
+	case (src == "") == (env == ""):
+		return "", fmt.Errorf("%q: set exactly one of src (a file on the server) or env (a variable on the server)", spec)
+	case src != "" && !path.IsAbs(src):
+		return "", fmt.Errorf("%q: src %q must be an absolute path on the server", spec, src)
+	case env != "" && !envKeyPattern.MatchString(env):
+		return "", fmt.Errorf("%q: env %q is not a variable name", spec, env)
+	}
+	return id, nil
+}
+
+// capabilities are the Linux capability names accepted in cap_add and
+// cap_drop, without the CAP_ prefix.
+var capabilities = []string{
+	"AUDIT_CONTROL", "AUDIT_READ", "AUDIT_WRITE", "BLOCK_SUSPEND", "BPF",
+	"CHECKPOINT_RESTORE", "CHOWN", "DAC_OVERRIDE", "DAC_READ_SEARCH", "FOWNER",
+	"FSETID", "IPC_LOCK", "IPC_OWNER", "KILL", "LEASE", "LINUX_IMMUTABLE",
+	"MAC_ADMIN", "MAC_OVERRIDE", "MKNOD", "NET_ADMIN", "NET_BIND_SERVICE",
+	"NET_BROADCAST", "NET_RAW", "PERFMON", "SETFCAP", "SETGID", "SETPCAP",
+	"SETUID", "SYS_ADMIN", "SYS_BOOT", "SYS_CHROOT", "SYS_MODULE", "SYS_NICE",
+	"SYS_PACCT", "SYS_PTRACE", "SYS_RAWIO", "SYS_RESOURCE", "SYS_TIME",
+	"SYS_TTY_CONFIG", "SYSLOG", "WAKE_ALARM",
+}
+
+// ValidateCapability validates a Linux capability name such as NET_ADMIN
+// (the CAP_ prefix is optional) or ALL.
+func ValidateCapability(capability string) error {
+	if capability == "ALL" || slices.Contains(capabilities, strings.TrimPrefix(capability, "CAP_")) {
+		return nil
+	}
+	return fmt.Errorf("unknown capability %q (e.g. NET_ADMIN, SYS_TIME, ALL)", capability)
+}
+
+// ulimitNames are the resource limits Docker accepts in ulimits.
+var ulimitNames = []string{
+	"core", "cpu", "data", "fsize", "locks", "memlock", "msgqueue", "nice",
+	"nofile", "nproc", "rss", "rtprio", "rttime", "sigpending", "stack",
+}
+
+// ValidateUlimit validates a ulimit name and its values. -1 means
+// unlimited; otherwise values are non-negative and soft cannot exceed hard.
+func ValidateUlimit(name string, u Ulimit) error {
+	if !slices.Contains(ulimitNames, name) {
+		return fmt.Errorf("unknown ulimit %q (valid: %s)", name, strings.Join(ulimitNames, ", "))
+	}
+	if u.Soft < -1 || u.Hard < -1 {
+		return fmt.Errorf("%s: values must be non-negative or -1 for unlimited", name)
+	}
+	if u.Hard != -1 && (u.Soft == -1 || u.Soft > u.Hard) {
+		return fmt.Errorf("%s: soft limit %d exceeds hard limit %d", name, u.Soft, u.Hard)
+	}
+	return nil
+}
+
+var sysctlKeyPattern = regexp.MustCompile(`^[a-z][a-z0-9_]*(\.[A-Za-z0-9_-]+)+$`)
+
+// ValidateSysctl validates a kernel parameter name (e.g. net.core.somaxconn)
+// and its value, which must be a single non-empty line.
+func ValidateSysctl(key, value string) error {
+	if !sysctlKeyPattern.MatchString(key) {
+		return fmt.Errorf("invalid sysctl name %q: must be dotted, like net.core.somaxconn", key)
+	}
+	if strings.TrimSpace(value) == "" {
+		return fmt.Errorf("sysctl %s: value cannot be empty", key)
+	}
+	if strings.ContainsAny(value, "\n\r\x00") {
+		return fmt.Errorf("sysctl %s: value must be a single line", key)
+	}
+	return nil
+}
+
+// validateEntrypoints checks the entrypoints list: it needs a domain,
+// cannot be empty once set, and holds valid, distinct names.
+func validateEntrypoints(cfg *Config) error {
+	if cfg.Entrypoints == nil {
+		return nil
+	}
+	if cfg.PrimaryDomain() == "" {
+		return fmt.Errorf("entrypoints requires domain to be set")
+	}
+	if len(cfg.Entrypoints) == 0 {
+		return fmt.Errorf("entrypoints cannot be empty; omit it for web/websecure")
+	}
+	seen := make(map[string]bool, len(cfg.Entrypoints))
+	for _, ep := range cfg.Entrypoints {
+		if err := ValidateEntrypoint(ep); err != nil {
+			return fmt.Errorf("invalid entrypoints: %w", err)
+		}
+		if seen[ep] {
+			return fmt.Errorf("invalid entrypoints: %q is listed twice", ep)
+		}
+		seen[ep] = true
+	}
+	return nil
+}
+
+var entrypointPattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_-]*$`)
+
+// ValidateEntrypoint validates a Traefik entrypoint name. The name ends up
+// in a comma-separated label value, so only letters, digits, hyphens and
+// underscores are allowed.
+func ValidateEntrypoint(name string) error {
+	if name == "" {
+		return fmt.Errorf("entrypoint cannot be empty")
+	}
+	if len(name) > 64 {
+		return fmt.Errorf("entrypoint exceeds maximum length of 64 characters")
+	}
+	if !entrypointPattern.MatchString(name) {
+		return fmt.Errorf("entrypoint %q must start with a letter or digit and contain only letters, digits, hyphens and underscores", name)
+	}
+	return nil
+}
+
+// ValidateProfile validates a compose profile name. Compose accepts
+// [a-zA-Z0-9][a-zA-Z0-9_.-]*.
+func ValidateProfile(profile string) error {
+	if profile == "" {
+		return fmt.Errorf("profile cannot be empty")
+	}
+
+	if len(profile) > 64 {
+		return fmt.Errorf("profile exceeds maximum length of 64 characters")
+	}
+
+	for i, r := range profile {
+		isLower := r >= 'a' && r <= 'z'
+		isUpper := r >= 'A' && r <= 'Z'
+		isDigit := r >= '0' && r <= '9'
+		if isLower || isUpper || isDigit {
+			continue
+		}
+		if i == 0 {
+			return fmt.Errorf("profile must start with a letter or digit")
+		}
+		if r != '-' && r != '_' && r != '.' {
+			return fmt.Errorf("profile contains invalid character: %c (only alphanumeric, hyphens, underscores, and dots allowed)", r)
+		}
+	}
+
+	return nil
+}
+
+// knownPlatforms lists the build platforms accepted by the platform field.
+// Kept deliberately small: these are the targets BuildKit ships emulators
+// for out of the box, and anything else is almost certainly a typo.
+var knownPlatforms = []string{
+	"linux/amd64",
+	"linux/arm64",
+	"linux/arm/v7",
+	"linux/arm/v6",
+	"linux/386",
+	"linux/ppc64le",
+	"linux/s390x",
+	"linux/riscv64",
+}
+
+// digestPattern matches the digests Docker accepts after '@' in an image
+// reference.
+var digestPattern = regexp.MustCompile(`^(sha256:[a-f0-9]{64}|sha512:[a-f0-9]{128})$`)
+
+// IsDigestImage reports whether image is pinned by digest (name@sha256:...).
+func IsDigestImage(image string) bool {
+	return strings.Contains(image, "@")
+}
+
+// ValidateImage validates a pre-built image reference. Tags are left to
+// the registry; a digest after '@' must be sha256 (64 hex) or sha512
+// (128 hex), lowercase.
+func ValidateImage(image string) error {
+	if strings.ContainsAny(image, " \t\n'\"`$;|&") {
+		return fmt.Errorf("image %q contains whitespace, quotes or shell metacharacters", image)
+	}
+	name, digest, found := strings.Cut(image, "@")
+	if !found {
+		return nil
+	}
+	if name == "" {
+		return fmt.Errorf("image %q has a digest but no name", image)
+	}
+	if !digestPattern.MatchString(digest) {
+		return fmt.Errorf("invalid digest %q: must be sha256:<64 hex chars> or sha512:<128 hex chars>", digest)
+	}
+	return nil
+}
+
+// ValidatePlatform validates a build platform against the known list
+func ValidatePlatform(platform string) error {
+	if platform == "" {
+		return fmt.Errorf("platform cannot be empty")
+	}
+	for _, p := range knownPlatforms {
+		if platform == p {
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown platform %q: must be one of %s", platform, strings.Join(knownPlatforms, ", "))
+}
+
+// buildArgNamePattern matches build arg names: the shell variable names
+// a Dockerfile ARG can declare.
+var buildArgNamePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// ValidateBuildArgName validates a build arg name
+func ValidateBuildArgName(name string) error {
+	if !buildArgNamePattern.MatchString(name) {
+		return fmt.Errorf("build arg name %q must be letters, digits and underscores, not starting with a digit", name)
+	}
+	return nil
+}
+
+// ParseBuildArg splits a KEY=VALUE build arg. The value may be empty
+// but the = is required.
+func ParseBuildArg(arg string) (string, string, error) {
+	key, value, ok := strings.Cut(arg, "=")
+	if !ok {
+		return "", "", fmt.Errorf("build arg %q must be KEY=VALUE", arg)
+	}
+	if err := ValidateBuildArgName(key); err != nil {
+		return "", "", err
+	}
+	return key, value, nil
+}
+
+// ResolvedBuildArgs returns build_args with extra (deploy --build-arg)
+// applied on top; extra wins on conflicting keys.
+func (c *Config) ResolvedBuildArgs(extra map[string]string) map[string]string {
+	if len(c.BuildArgs) == 0 && len(extra) == 0 {
+		return nil
+	}
+	args := make(map[string]string, len(c.BuildArgs)+len(extra))
+	maps.Copy(args, c.BuildArgs)
+	maps.Copy(args, extra)
+	return args
+}
+
+// ProfileActive reports whether the service runs with the given selected
+// profiles. Services without profiles always run; others need at least
+// one of their profiles selected.
+func (c *Config) ProfileActive(selected []string) bool {
+	if len(c.Profiles) == 0 {
+		return true
+	}
+	for _, p := range c.Profiles {
+		for _, s := range selected {
+			if p == s {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// ManualStart returns true if the service has restart: false: deploys
+// build or pull its image and write the manifest but never start it, and
+// dependents don't start it either.
+func (c *Config) ManualStart() bool {
+	return c.Restart != nil && !*c.Restart
+}
+
+// UseBuildKit returns true if the remote build should run with
+// DOCKER_BUILDKIT=1: build.buildkit, or build.ssh or build.secrets, which
+// the legacy builder doesn't support.
+func (c *Config) UseBuildKit() bool {
+	return c.Build != nil && (c.Build.BuildKit || len(c.Build.SSH) > 0 || len(c.Build.Secrets) > 0)
+}
+
+// PullBaseImages returns true if the build should refresh the Dockerfile's
+// base images: build.pull, or pull (deploy --pull). Pre-built images are
+// pulled anyway and have no build.
+func (c *Config) PullBaseImages(pull bool) bool {
+	if c.IsPrebuilt() {
+		return false
+	}
+	return pull || (c.Build != nil && c.Build.Pull)
+}
+
 // ValidatePortMapping validates a Docker port mapping string (e.g., "3000:3000", "8080:80")
 func ValidatePortMapping(mapping string) error {
 	if mapping == "" {
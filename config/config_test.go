@@ -3,7 +3,10 @@ package config
 import (
 	"os"
 	"path/filepath"
+	"sort"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -155,6 +158,183 @@ func TestResolve_MissingOverlayIsAnError(t *testing.T) {
 	assert.Contains(t, err.Error(), "ssd.staging.yaml")
 }
 
+// feedStdin makes content the stdin a StdinPath config is read from.
+func feedStdin(t *testing.T, content string) {
+	t.Helper()
+	origStdin, origData, origRead := stdin, stdinData, stdinRead
+	stdin, stdinData, stdinRead = strings.NewReader(content), nil, false
+	t.Cleanup(func() { stdin, stdinData, stdinRead = origStdin, origData, origRead })
+}
+
+func TestResolve_FromStdin(t *testing.T) {
+	writeGlobalConfig(t, "")
+	feedStdin(t, "server: piped\nservices:\n  web:\n    port: 3000\n  api: {}\n")
+	chdir(t, t.TempDir())
+
+	cfg, basePath, err := Resolve(StdinPath, "")
+	require.NoError(t, err)
+	assert.Equal(t, StdinPath, basePath)
+	assert.Equal(t, "piped", cfg.Server)
+	assert.ElementsMatch(t, []string{"web", "api"}, cfg.ListServices())
+
+	// Stdin is read once; a second load in the process reuses it.
+	again, _, err := Resolve(StdinPath, "")
+	require.NoError(t, err)
+	assert.Equal(t, 3000, again.Services["web"].Port)
+}
+
+func TestResolve_FromStdinWithEnvOverlay(t *testing.T) {
+	writeGlobalConfig(t, "server: shared\nruntime: k3s\n")
+	feedStdin(t, "server: piped\nservices:\n  web: {}\n")
+	tmpDir := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(tmpDir, ".ssd"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(tmpDir, ".ssd", "ssd.prod.yaml"),
+		[]byte("server: prod\n"), 0644))
+
+	chdir(t, tmpDir)
+	cfg, _, err := Resolve(StdinPath, "prod")
+	require.NoError(t, err)
+	assert.Equal(t, "prod", cfg.Server)
+	assert.Equal(t, "k3s", cfg.Runtime, "global config still applies")
+}
+
+// writeGlobalConfig points XDG_CONFIG_HOME at a temp dir and writes
+// content as the global config. Empty content leaves the file absent.
+func writeGlobalConfig(t *testing.T, content string) string {
+	t.Helper()
+	dir := t.TempDir()
+	t.Setenv("XDG_CONFIG_HOME", dir)
+	path := filepath.Join(dir, "ssd", "config.yaml")
+	if content != "" {
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+		require.NoError(t, os.WriteFile(path, []byte(content), 0644))
+	}
+	return path
+}
+
+func TestGlobalConfigPath(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", "/xdg")
+	assert.Equal(t, "/xdg/ssd/config.yaml", GlobalConfigPath())
+
+	t.Setenv("XDG_CONFIG_HOME", "")
+	t.Setenv("HOME", "/home/alice")
+	assert.Equal(t, "/home/alice/.config/ssd/config.yaml", GlobalConfigPath())
+}
+
+func TestResolve_GlobalConfigAbsent(t *testing.T) {
+	writeGlobalConfig(t, "")
+	tmpDir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(tmpDir, "ssd.yaml"),
+		[]byte("server: project\nservices:\n  web: {}\n"), 0644))
+
+	chdir(t, tmpDir)
+	cfg, _, err := Resolve("", "")
+	require.NoError(t, err)
+	assert.Equal(t, "project", cfg.Server)
+}
+
+func TestResolve_MergesGlobalDefaultsUnderProject(t *testing.T) {
+	writeGlobalConfig(t, "server: shared\nruntime: k3s\ndeploy:\n  strategy: recreate\n  health_gate: true\ncleanup:\n  retention: 5\n")
+	tmpDir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(tmpDir, "ssd.yaml"),
+		[]byte("runtime: compose\ndeploy:\n  strategy: rollout\nservices:\n  web: {}\n"), 0644))
+
+	chdir(t, tmpDir)
+	cfg, _, err := Resolve("", "")
+	require.NoError(t, err)
+	assert.Equal(t, "shared", cfg.Server, "global fills what the project leaves out")
+	assert.Equal(t, "compose", cfg.Runtime, "project wins over global")
+	assert.Equal(t, "rollout", cfg.Deploy.Strategy, "project wins inside nested maps")
+	assert.True(t, cfg.Deploy.HealthGate, "global keys in nested maps survive")
+	require.NotNil(t, cfg.Cleanup)
+	assert.Equal(t, 5, *cfg.Cleanup.Retention)
+
+	web, err := cfg.GetService("web")
+	require.NoError(t, err)
+	assert.Equal(t, "shared", web.Server)
+	assert.Equal(t, 5, web.RetainTags())
+}
+
+func TestResolve_GlobalStacksRoot(t *testing.T) {
+	writeGlobalConfig(t, "stacks_root: /opt/dockge/stacks\n")
+	tmpDir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(tmpDir, "ssd.yaml"),
+		[]byte("server: project\nservices:\n  web: {}\n"), 0644))
+
+	chdir(t, tmpDir)
+	cfg, _, err := Resolve("", "")
+	require.NoError(t, err)
+
+	web, err := cfg.GetService("web")
+	require.NoError(t, err)
+	assert.Equal(t, "/opt/dockge/stacks/web", web.Stack)
+}
+
+func TestResolve_GlobalSSHSettings(t *testing.T) {
+	writeGlobalConfig(t, "server: shared\nssh_client: native\nstrict_host_key_checking: accept-new\nhost_key: "+testHostKey+"\n")
+	tmpDir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(tmpDir, "ssd.yaml"),
+		[]byte("strict_host_key_checking: no\nservices:\n  web: {}\n"), 0644))
+
+	chdir(t, tmpDir)
+	cfg, _, err := Resolve("", "")
+	require.NoError(t, err)
+
+	web, err := cfg.GetService("web")
+	require.NoError(t, err)
+	assert.Equal(t, SSHClientNative, web.SSHClient)
+	assert.Equal(t, testHostKey, web.HostKey)
+	assert.Equal(t, HostKeyCheckingNo, web.HostKeyChecking, "project wins over global")
+}
+
+func TestResolve_GlobalConfigRejectsInvalidSSHSettings(t *testing.T) {
+	tests := map[string]string{
+		"ssh_client: putty\n":               "ssh_client",
+		"strict_host_key_checking: maybe\n": "strict_host_key_checking",
+		"host_key: not-a-key\n":             "host_key",
+	}
+	for content, key := range tests {
+		path := writeGlobalConfig(t, content)
+		tmpDir := t.TempDir()
+		require.NoError(t, os.WriteFile(filepath.Join(tmpDir, "ssd.yaml"),
+			[]byte("server: s\nservices:\n  web: {}\n"), 0644))
+
+		chdir(t, tmpDir)
+		_, _, err := Resolve("", "")
+		require.Error(t, err, content)
+		assert.Contains(t, err.Error(), path)
+		assert.Contains(t, err.Error(), key)
+	}
+}
+
+func TestResolve_EnvOverlayWinsOverProjectAndGlobal(t *testing.T) {
+	writeGlobalConfig(t, "server: shared\n")
+	tmpDir := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(tmpDir, ".ssd"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(tmpDir, ".ssd", "ssd.yaml"),
+		[]byte("server: project\nservices:\n  web: {}\n"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(tmpDir, ".ssd", "ssd.prod.yaml"),
+		[]byte("server: prod\n"), 0644))
+
+	chdir(t, tmpDir)
+	cfg, _, err := Resolve("", "prod")
+	require.NoError(t, err)
+	assert.Equal(t, "prod", cfg.Server)
+}
+
+func TestResolve_GlobalConfigRejectsProjectKeys(t *testing.T) {
+	path := writeGlobalConfig(t, "server: shared\nservices:\n  web: {}\n")
+	tmpDir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(tmpDir, "ssd.yaml"),
+		[]byte("services:\n  web: {}\n"), 0644))
+
+	chdir(t, tmpDir)
+	_, _, err := Resolve("", "")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), path)
+	assert.Contains(t, err.Error(), "services")
+}
+
 func TestEnvConfigPath(t *testing.T) {
 	tests := []struct {
 		base, env, want string
@@ -453,6 +633,103 @@ func TestApplyDefaults_WithServiceName(t *testing.T) {
 	assert.Equal(t, "/stacks/web", result.Stack)
 }
 
+func TestApplyDefaults_StacksRoot(t *testing.T) {
+	cfg := &Config{Server: "myserver", StacksRoot: "/opt/dockge/stacks"}
+	result, err := applyDefaults(cfg, "web")
+	require.NoError(t, err)
+
+	assert.Equal(t, "/opt/dockge/stacks/web", result.Stack)
+}
+
+func TestApplyDefaults_ExplicitStackIgnoresStacksRoot(t *testing.T) {
+	cfg := &Config{Server: "myserver", Stack: "/srv/app", StacksRoot: "/opt/dockge/stacks"}
+	result, err := applyDefaults(cfg, "web")
+	require.NoError(t, err)
+
+	assert.Equal(t, "/srv/app", result.Stack)
+}
+
+func TestApplyDefaults_InvalidStacksRoot(t *testing.T) {
+	for _, root := range []string{"~/stacks", "stacks", "/opt/../etc", "/opt/$HOME"} {
+		t.Run(root, func(t *testing.T) {
+			_, err := applyDefaults(&Config{Server: "myserver", StacksRoot: root}, "web")
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), "invalid stacks_root")
+		})
+	}
+}
+
+func TestApplyDefaults_RelativeStackUnderStacksRoot(t *testing.T) {
+	tests := []struct {
+		stack, want string
+	}{
+		{"shop", "/opt/dockge/stacks/shop"},
+		{"team/shop", "/opt/dockge/stacks/team/shop"},
+		{"./shop", "/opt/dockge/stacks/shop"},
+		{"/srv/shop", "/srv/shop"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.stack, func(t *testing.T) {
+			cfg := &Config{Server: "myserver", Stack: tt.stack, StacksRoot: "/opt/dockge/stacks"}
+			result, err := applyDefaults(cfg, "web")
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, result.Stack)
+		})
+	}
+}
+
+func TestApplyDefaults_RelativeStackRejectsTraversal(t *testing.T) {
+	for _, stack := range []string{"../etc", "shop/../../etc", "..", "shop/.."} {
+		t.Run(stack, func(t *testing.T) {
+			cfg := &Config{Server: "myserver", Stack: stack, StacksRoot: "/opt/dockge/stacks"}
+			_, err := applyDefaults(cfg, "web")
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), "path traversal")
+		})
+	}
+}
+
+func TestApplyDefaults_RelativeStackRejectsMetacharacters(t *testing.T) {
+	cfg := &Config{Server: "myserver", Stack: "shop;rm", StacksRoot: "/opt/dockge/stacks"}
+	_, err := applyDefaults(cfg, "web")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "shell metacharacter")
+}
+
+func TestLoadFromBytes_StacksRoot(t *testing.T) {
+	yaml := "server: srv\nstacks_root: /opt/stacks\nservices:\n  web: {}\n  api:\n    stack: /srv/api\n"
+	cfg, err := LoadFromBytes([]byte(yaml))
+	require.NoError(t, err)
+
+	web, err := cfg.GetService("web")
+	require.NoError(t, err)
+	assert.Equal(t, "/opt/stacks/web", web.Stack)
+
+	api, err := cfg.GetService("api")
+	require.NoError(t, err)
+	assert.Equal(t, "/srv/api", api.Stack)
+}
+
+func TestLoadFromBytes_RelativeStack(t *testing.T) {
+	yaml := "server: srv\nstacks_root: /opt/stacks\nservices:\n  web:\n    stack: shop\n"
+	cfg, err := LoadFromBytes([]byte(yaml))
+	require.NoError(t, err)
+
+	web, err := cfg.GetService("web")
+	require.NoError(t, err)
+	assert.Equal(t, "/opt/stacks/shop", web.Stack)
+}
+
+func TestLoadFromBytes_RootStackWinsOverStacksRoot(t *testing.T) {
+	yaml := "server: srv\nstack: /srv/shop\nstacks_root: /opt/stacks\nservices:\n  web: {}\n"
+	cfg, err := LoadFromBytes([]byte(yaml))
+	require.NoError(t, err)
+
+	web, err := cfg.GetService("web")
+	require.NoError(t, err)
+	assert.Equal(t, "/srv/shop", web.Stack)
+}
+
 func TestApplyDefaults_PreservesExistingValues(t *testing.T) {
 	cfg := &Config{
 		Name:       "custom-name",
@@ -1078,12 +1355,14 @@ services:
       cmd: "curl -f http://localhost:8080/health || exit 1"
       interval: "30s"
       timeout: "10s"
-      retries: 3`,
+      retries: 3
+      start_period: "40s"`,
 			expected: &HealthCheck{
-				Cmd:      "curl -f http://localhost:8080/health || exit 1",
-				Interval: "30s",
-				Timeout:  "10s",
-				Retries:  3,
+				Cmd:         "curl -f http://localhost:8080/health || exit 1",
+				Interval:    "30s",
+				Timeout:     "10s",
+				Retries:     3,
+				StartPeriod: "40s",
 			},
 		},
 		{
@@ -1339,6 +1618,71 @@ func TestValidateVolumeName(t *testing.T) {
 	}
 }
 
+func TestValidateBindMountPath(t *testing.T) {
+	tests := []struct {
+		path    string
+		wantErr bool
+	}{
+		{"/srv/uploads", false},
+		{"/var/lib/app-data_1.0", false},
+		{"srv/uploads", true},
+		{"/", true},
+		{"/srv/../etc", true},
+		{"/srv/uploads/", true},
+		{"/srv//uploads", true},
+		{"/srv/up loads", true},
+		{"/srv/uploads:ro", true},
+		{"/srv/$HOME", true},
+		{"/srv/a;rm", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			err := ValidateBindMountPath(tt.path)
+			if tt.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestLoadFromBytes_BindMountVolumes(t *testing.T) {
+	cfg, err := LoadFromBytes([]byte(`server: myserver
+services:
+  web:
+    volumes:
+      /srv/uploads: /app/uploads
+      cache: /app/cache`))
+	require.NoError(t, err)
+	svc, err := cfg.GetService("web")
+	require.NoError(t, err)
+	assert.Equal(t, "/app/uploads", svc.Volumes["/srv/uploads"])
+	assert.Equal(t, "/app/cache", svc.Volumes["cache"])
+}
+
+func TestValidateVolumeMode(t *testing.T) {
+	for _, mode := range []string{"ro", "rw", "z", "Z", "ro,z", "cached", "delegated", "consistent", "nocopy"} {
+		assert.NoError(t, ValidateVolumeMode(mode), mode)
+	}
+	for _, mode := range []string{"", "readonly", "ro,rw", "RO", "ro,", "ro;rm"} {
+		assert.Error(t, ValidateVolumeMode(mode), mode)
+	}
+}
+
+func TestSplitVolumeMount(t *testing.T) {
+	path, mode := SplitVolumeMount("/app/data:ro,z")
+	assert.Equal(t, "/app/data", path)
+	assert.Equal(t, "ro,z", mode)
+	assert.True(t, IsReadOnlyMode(mode))
+
+	path, mode = SplitVolumeMount("/app/data")
+	assert.Equal(t, "/app/data", path)
+	assert.Empty(t, mode)
+	assert.False(t, IsReadOnlyMode(mode))
+}
+
 func TestValidateHealthCheck(t *testing.T) {
 	tests := []struct {
 		name    string
@@ -1393,6 +1737,22 @@ func TestValidateHealthCheck(t *testing.T) {
 			},
 			wantErr: true,
 		},
+		{
+			name: "invalid start_period format",
+			hc: &HealthCheck{
+				Cmd:         "exit 0",
+				StartPeriod: "1 minute",
+			},
+			wantErr: true,
+		},
+		{
+			name: "valid start_period",
+			hc: &HealthCheck{
+				Cmd:         "exit 0",
+				StartPeriod: "2m",
+			},
+			wantErr: false,
+		},
 		{
 			name: "negative retries",
 			hc: &HealthCheck{
@@ -1825,6 +2185,54 @@ func TestRootConfig_GetService_ValidatesVolumes(t *testing.T) {
 			serviceName: "db",
 			expectError: "invalid volume name",
 		},
+		{
+			name: "bind mount with path traversal",
+			config: &RootConfig{
+				Server: "myserver",
+				Services: map[string]*Config{
+					"db": {
+						Name: "db",
+						Volumes: map[string]string{
+							"/srv/../etc": "/etc/host",
+						},
+					},
+				},
+			},
+			serviceName: "db",
+			expectError: "invalid bind mount",
+		},
+		{
+			name: "invalid volume mode",
+			config: &RootConfig{
+				Server: "myserver",
+				Services: map[string]*Config{
+					"db": {
+						Name: "db",
+						Volumes: map[string]string{
+							"db-data": "/var/lib/data:readonly",
+						},
+					},
+				},
+			},
+			serviceName: "db",
+			expectError: "invalid mode for volume",
+		},
+		{
+			name: "valid bind mount",
+			config: &RootConfig{
+				Server: "myserver",
+				Services: map[string]*Config{
+					"db": {
+						Name: "db",
+						Volumes: map[string]string{
+							"/srv/backups": "/backups",
+						},
+					},
+				},
+			},
+			serviceName: "db",
+			expectError: "",
+		},
 		{
 			name: "valid volumes",
 			config: &RootConfig{
@@ -2005,6 +2413,36 @@ func TestConfig_UseHTTPS(t *testing.T) {
 	}
 }
 
+func TestConfig_PublicURL(t *testing.T) {
+	tests := []struct {
+		name     string
+		cfg      *Config
+		expected string
+	}{
+		{name: "https default", cfg: &Config{Domain: "example.com"}, expected: "https://example.com"},
+		{name: "http", cfg: &Config{Domain: "example.com", HTTPS: boolPtr(false)}, expected: "http://example.com"},
+		{name: "https with path", cfg: &Config{Domain: "example.com", Path: "/api"}, expected: "https://example.com/api"},
+		{name: "http with path", cfg: &Config{Domain: "example.com", Path: "/api", HTTPS: boolPtr(false)}, expected: "http://example.com/api"},
+		{name: "redirect_to is primary", cfg: &Config{Domains: []string{"a.com", "b.com"}, RedirectTo: "b.com"}, expected: "https://b.com"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			url, err := tt.cfg.PublicURL()
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, url)
+		})
+	}
+}
+
+func TestConfig_PublicURL_Errors(t *testing.T) {
+	_, err := (&Config{Name: "db"}).PublicURL()
+	assert.ErrorContains(t, err, `service "db" has no domain`)
+
+	_, err = (&Config{Name: "pg", Domain: "db.example.com", Router: "tcp"}).PublicURL()
+	assert.ErrorContains(t, err, "tcp router")
+}
+
 func TestValidatePath(t *testing.T) {
 	tests := []struct {
 		name    string
@@ -2106,6 +2544,51 @@ func TestRootConfig_GetService_ValidatesPath(t *testing.T) {
 	}
 }
 
+func TestRootConfig_GetService_ValidatesRouter(t *testing.T) {
+	tests := []struct {
+		name        string
+		svc         *Config
+		expectError string
+	}{
+		{name: "default http", svc: &Config{Domain: "example.com"}, expectError: ""},
+		{name: "explicit http", svc: &Config{Domain: "example.com", Router: "http"}, expectError: ""},
+		{name: "tcp with domain", svc: &Config{Domain: "db.example.com", Router: "tcp", Port: 5432}, expectError: ""},
+		{name: "tcp with domains", svc: &Config{Domains: []string{"a.example.com", "b.example.com"}, Router: "tcp"}, expectError: ""},
+		{name: "unknown router", svc: &Config{Domain: "example.com", Router: "udp"}, expectError: "invalid router"},
+		{name: "tcp without domain", svc: &Config{Router: "tcp"}, expectError: "router: tcp requires domain"},
+		{name: "tcp with path", svc: &Config{Domain: "example.com", Path: "/db", Router: "tcp"}, expectError: "cannot be combined with path"},
+		{
+			name:        "tcp with redirect_to",
+			svc:         &Config{Domains: []string{"a.example.com", "b.example.com"}, RedirectTo: "a.example.com", Router: "tcp"},
+			expectError: "cannot be combined with redirect_to",
+		},
+		{name: "route_priority", svc: &Config{Domain: "example.com", RoutePriority: 10}, expectError: ""},
+		{name: "negative route_priority", svc: &Config{Domain: "example.com", RoutePriority: -1}, expectError: "route_priority must be positive"},
+		{name: "route_priority without domain", svc: &Config{RoutePriority: 10}, expectError: "route_priority requires domain"},
+		{name: "entrypoints", svc: &Config{Domain: "example.com", Entrypoints: []string{"internal", "websecure"}}, expectError: ""},
+		{name: "empty entrypoints", svc: &Config{Domain: "example.com", Entrypoints: []string{}}, expectError: "entrypoints cannot be empty"},
+		{name: "invalid entrypoint", svc: &Config{Domain: "example.com", Entrypoints: []string{"web,evil"}}, expectError: "invalid entrypoints"},
+		{name: "duplicate entrypoint", svc: &Config{Domain: "example.com", Entrypoints: []string{"internal", "internal"}}, expectError: "listed twice"},
+		{name: "entrypoints without domain", svc: &Config{Entrypoints: []string{"internal"}}, expectError: "entrypoints requires domain"},
+		{name: "tcp with entrypoints", svc: &Config{Domain: "example.com", Router: "tcp", Entrypoints: []string{"internal"}}, expectError: "cannot be combined with entrypoints"},
+		{name: "tcp with route_priority", svc: &Config{Domain: "example.com", Router: "tcp", RoutePriority: 10}, expectError: "cannot be combined with route_priority"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root := &RootConfig{Server: "myserver", Services: map[string]*Config{"db": tt.svc}}
+			cfg, err := root.GetService("db")
+			if tt.expectError != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tt.expectError)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.svc.Router == "tcp", cfg.IsTCPRouter())
+		})
+	}
+}
+
 func TestValidateTarget(t *testing.T) {
 	tests := []struct {
 		name    string
@@ -2235,15 +2718,198 @@ func TestRootConfig_GetService_ValidatesTarget(t *testing.T) {
 	}
 }
 
-func TestRootConfig_GetService_DeployStrategyDefault(t *testing.T) {
-	cfg := &RootConfig{
-		Server: "myserver",
-		Services: map[string]*Config{
-			"web": {},
-		},
+func TestValidatePlatform(t *testing.T) {
+	tests := []struct {
+		name     string
+		platform string
+		wantErr  bool
+	}{
+		{name: "amd64", platform: "linux/amd64", wantErr: false},
+		{name: "arm64", platform: "linux/arm64", wantErr: false},
+		{name: "arm v7", platform: "linux/arm/v7", wantErr: false},
+		{name: "empty string", platform: "", wantErr: true},
+		{name: "missing os", platform: "amd64", wantErr: true},
+		{name: "unknown arch", platform: "linux/sparc", wantErr: true},
+		{name: "windows", platform: "windows/amd64", wantErr: true},
+		{name: "shell injection", platform: "linux/amd64;rm -rf /", wantErr: true},
 	}
 
-	svc, err := cfg.GetService("web")
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidatePlatform(tt.platform)
+			if tt.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestValidateImage(t *testing.T) {
+	tests := []struct {
+		name    string
+		image   string
+		wantErr bool
+	}{
+		{name: "tag", image: "nginx:1.27", wantErr: false},
+		{name: "registry with port", image: "registry:5000/app:2", wantErr: false},
+		{name: "sha256 digest", image: "nginx@sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef", wantErr: false},
+		{name: "tag and digest", image: "nginx:1.27@sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef", wantErr: false},
+		{name: "sha512 digest", image: "nginx@sha512:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef", wantErr: false},
+		{name: "short digest", image: "nginx@sha256:abc", wantErr: true},
+		{name: "uppercase digest", image: "nginx@sha256:0123456789ABCDEF0123456789ABCDEF0123456789ABCDEF0123456789ABCDEF", wantErr: true},
+		{name: "unknown algorithm", image: "nginx@md5:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef", wantErr: true},
+		{name: "missing name", image: "@sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef", wantErr: true},
+		{name: "shell injection", image: "nginx;rm -rf /", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateImage(tt.image)
+			if tt.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestLoadFromBytes_PinDigest(t *testing.T) {
+	yaml := "server: srv\nservices:\n  web:\n    image: nginx:1.27\n    deploy:\n      pin_digest: true\n  db:\n    image: postgres@sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef\n    deploy:\n      pin_digest: true\n"
+	cfg, err := LoadFromBytes([]byte(yaml))
+	require.NoError(t, err)
+
+	web, err := cfg.GetService("web")
+	require.NoError(t, err)
+	assert.True(t, web.PinDigest())
+
+	db, err := cfg.GetService("db")
+	require.NoError(t, err)
+	assert.False(t, db.PinDigest(), "an image already pinned by digest needs no resolving")
+}
+
+func TestGetService_PinDigestRequiresImage(t *testing.T) {
+	yaml := "server: srv\nservices:\n  web:\n    deploy:\n      pin_digest: true\n"
+	cfg, err := LoadFromBytes([]byte(yaml))
+	require.NoError(t, err)
+
+	_, err = cfg.GetService("web")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "pin_digest requires a pre-built image")
+}
+
+func TestGetService_InvalidImageDigest(t *testing.T) {
+	yaml := "server: srv\nservices:\n  web:\n    image: nginx@sha256:abc\n"
+	cfg, err := LoadFromBytes([]byte(yaml))
+	require.NoError(t, err)
+
+	_, err = cfg.GetService("web")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "invalid image")
+}
+
+func TestLoadFromBytes_BuildOptions(t *testing.T) {
+	yaml := "server: srv\nservices:\n  web:\n    platform: linux/amd64\n    build:\n      buildkit: true\n"
+	cfg, err := LoadFromBytes([]byte(yaml))
+	require.NoError(t, err)
+	svc, err := cfg.GetService("web")
+	require.NoError(t, err)
+	assert.Equal(t, "linux/amd64", svc.Platform)
+	assert.True(t, svc.UseBuildKit())
+}
+
+func TestLoadFromBytes_BuildMounts(t *testing.T) {
+	yaml := "server: srv\nservices:\n  web:\n    build:\n      ssh: [default]\n      secrets: [\"id=npmrc,src=/etc/ssd/npmrc\"]\n"
+	cfg, err := LoadFromBytes([]byte(yaml))
+	require.NoError(t, err)
+	svc, err := cfg.GetService("web")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"default"}, svc.Build.SSH)
+	assert.Equal(t, []string{"id=npmrc,src=/etc/ssd/npmrc"}, svc.Build.Secrets)
+	assert.True(t, svc.UseBuildKit(), "ssh and secret mounts need BuildKit")
+
+	yaml = "server: srv\nservices:\n  web:\n    build:\n      secrets: [\"id=a,env=A\", \"id=a,env=B\"]\n"
+	cfg, err = LoadFromBytes([]byte(yaml))
+	require.NoError(t, err)
+	_, err = cfg.GetService("web")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), `invalid build.secrets: id "a" is used twice`)
+}
+
+func TestValidateBuildSSH(t *testing.T) {
+	for _, ok := range []string{"default", "github=/home/deploy/.ssh/id_ed25519", "default=/run/agent.sock,/root/.ssh/id_rsa"} {
+		assert.NoError(t, ValidateBuildSSH(ok), ok)
+	}
+	for _, bad := range []string{"", "=/run/agent.sock", "default=", "default=~/.ssh/id_rsa", "git hub", "default=/a,relative"} {
+		assert.Error(t, ValidateBuildSSH(bad), bad)
+	}
+}
+
+func TestValidateBuildSecret(t *testing.T) {
+	id, err := ValidateBuildSecret("id=npmrc,src=/etc/ssd/npmrc")
+	require.NoError(t, err)
+	assert.Equal(t, "npmrc", id)
+	id, err = ValidateBuildSecret("env=GITHUB_TOKEN,id=gh")
+	require.NoError(t, err)
+	assert.Equal(t, "gh", id)
+
+	for _, bad := range []string{
+		"",
+		"src=/etc/ssd/npmrc",          // no id
+		"id=npmrc",                    // no source
+		"id=npmrc,src=/a,env=B",       // two sources
+		"id=npmrc,src=./npmrc",        // relative path
+		"id=token,env=MY-TOKEN",       // not a variable name
+		"id=token,value=hunter2",      // inline value
+		"id=token,type=env,env=TOKEN", // unsupported key
+		"id=token,src",                // not key=value
+		"id=-x,src=/etc/ssd/npmrc",    // bad id
+	} {
+		_, err := ValidateBuildSecret(bad)
+		assert.Error(t, err, bad)
+	}
+}
+
+func TestConfig_UseBuildKitDefault(t *testing.T) {
+	assert.False(t, (&Config{}).UseBuildKit())
+	assert.False(t, (&Config{Build: &BuildConfig{}}).UseBuildKit())
+}
+
+func TestConfig_PullBaseImages(t *testing.T) {
+	yaml := "server: srv\nservices:\n  web:\n    build:\n      pull: true\n  api: {}\n"
+	cfg, err := LoadFromBytes([]byte(yaml))
+	require.NoError(t, err)
+	web, err := cfg.GetService("web")
+	require.NoError(t, err)
+	assert.True(t, web.PullBaseImages(false))
+	api, err := cfg.GetService("api")
+	require.NoError(t, err)
+	assert.False(t, api.PullBaseImages(false))
+	assert.True(t, api.PullBaseImages(true), "deploy --pull applies to every service")
+
+	assert.False(t, (&Config{Image: "nginx:1.27"}).PullBaseImages(true))
+}
+
+func TestRootConfig_GetService_ValidatesPlatform(t *testing.T) {
+	yaml := "server: srv\nservices:\n  web:\n    platform: linux/pdp11\n"
+	cfg, err := LoadFromBytes([]byte(yaml))
+	require.NoError(t, err)
+	_, err = cfg.GetService("web")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "invalid platform")
+}
+
+func TestRootConfig_GetService_DeployStrategyDefault(t *testing.T) {
+	cfg := &RootConfig{
+		Server: "myserver",
+		Services: map[string]*Config{
+			"web": {},
+		},
+	}
+
+	svc, err := cfg.GetService("web")
 	require.NoError(t, err)
 	assert.Equal(t, "rollout", svc.DeployStrategy())
 }
@@ -2293,6 +2959,34 @@ func TestRootConfig_GetService_DeployStrategyInvalid(t *testing.T) {
 	assert.Contains(t, err.Error(), "invalid deploy strategy")
 }
 
+func TestLoadFromBytes_DeployStrategy(t *testing.T) {
+	tests := []struct {
+		strategy string
+		wantErr  bool
+	}{
+		{"rollout", false},
+		{"recreate", false},
+		{"none", false},
+		{"canary", true},
+		{"Rollout", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.strategy, func(t *testing.T) {
+			root, err := LoadFromBytes([]byte("server: myserver\nservices:\n  web:\n    deploy:\n      strategy: " + tt.strategy + "\n"))
+			require.NoError(t, err)
+
+			svc, err := root.GetService("web")
+			if tt.wantErr {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), "must be rollout, recreate or none")
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.strategy, svc.DeployStrategy())
+		})
+	}
+}
+
 func TestValidatePortMapping(t *testing.T) {
 	tests := []struct {
 		name    string
@@ -2356,6 +3050,204 @@ func TestRootConfig_GetService_ValidatesPorts(t *testing.T) {
 	assert.Contains(t, err.Error(), "invalid port mapping")
 }
 
+func TestRootConfig_GetService_PortOutOfRange(t *testing.T) {
+	for _, port := range []int{-1, 65536, 100000} {
+		cfg := &RootConfig{
+			Server: "myserver",
+			Services: map[string]*Config{
+				"web": {Port: port},
+			},
+		}
+
+		_, err := cfg.GetService("web")
+		require.Error(t, err, "port %d", port)
+		assert.Contains(t, err.Error(), "must be between 1 and 65535")
+	}
+}
+
+func TestLoadFromBytes_HostPortConflict(t *testing.T) {
+	_, err := LoadFromBytes([]byte(`server: myserver
+services:
+  api:
+    ports:
+      - "8080:3000"
+  web:
+    ports:
+      - "9000:9000"
+      - "8080:80"
+`))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), `host port 8080 is published by both "api" and "web"`)
+}
+
+func TestLoadFromBytes_DistinctHostPorts(t *testing.T) {
+	_, err := LoadFromBytes([]byte(`server: myserver
+services:
+  api:
+    ports:
+      - "8080:80"
+  web:
+    ports:
+      - "8081:80"
+`))
+	require.NoError(t, err)
+}
+
+func TestLoadFromBytes_RouteConflicts(t *testing.T) {
+	tests := []struct {
+		name    string
+		yaml    string
+		wantErr string
+	}{
+		{
+			name: "duplicate path",
+			yaml: `server: myserver
+services:
+  api:
+    domain: app.example.com
+    path: /api
+  web:
+    domain: app.example.com
+    path: /api/
+`,
+			wantErr: `"api" and "web" both route app.example.com/api`,
+		},
+		{
+			name: "duplicate catch-all",
+			yaml: `server: myserver
+services:
+  api:
+    domain: app.example.com
+  web:
+    domains: [www.example.com, app.example.com]
+`,
+			wantErr: `"api" and "web" both route app.example.com`,
+		},
+		{
+			name: "prefix overlap",
+			yaml: `server: myserver
+services:
+  api:
+    domain: app.example.com
+    path: /api
+  apiv2:
+    domain: app.example.com
+    path: /api/v2
+`,
+			wantErr: `path /api of "api" overlaps path /api/v2 of "apiv2" on app.example.com`,
+		},
+		{
+			name: "PathPrefix matches across segments",
+			yaml: `server: myserver
+services:
+  api:
+    domain: app.example.com
+    path: /api
+  apix:
+    domain: app.example.com
+    path: /apix
+`,
+			wantErr: "overlaps",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := LoadFromBytes([]byte(tt.yaml))
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.wantErr)
+		})
+	}
+}
+
+func TestLoadFromBytes_OverlapWithRoutePriority(t *testing.T) {
+	_, err := LoadFromBytes([]byte(`server: myserver
+services:
+  api:
+    domain: app.example.com
+    path: /api
+  apiv2:
+    domain: app.example.com
+    path: /api/v2
+    route_priority: 100
+`))
+	require.NoError(t, err)
+
+	_, err = LoadFromBytes([]byte(`server: myserver
+services:
+  api:
+    domain: app.example.com
+    path: /api
+    route_priority: 100
+  apiv2:
+    domain: app.example.com
+    path: /api/v2
+    route_priority: 100
+`))
+	require.Error(t, err, "equal priorities leave the order ambiguous")
+}
+
+func TestValidateEnvContent(t *testing.T) {
+	valid := []string{
+		"",
+		"\n",
+		"DB_HOST=localhost\nDB_URL=postgres://u:p@h/db?sslmode=require\n",
+		"# comment\n\n  \nEMPTY=\n_PRIVATE=1",
+	}
+	for _, content := range valid {
+		assert.NoError(t, ValidateEnvContent(content), "%q", content)
+	}
+
+	tests := []struct {
+		content string
+		wantErr string
+	}{
+		{"A=1\nnot a variable\n", `line 2: expected KEY=VALUE, got "not a variable"`},
+		{"=value\n", `line 1: invalid variable name ""`},
+		{"1ST=x\n", `invalid variable name "1ST"`},
+		{"MY-VAR=x\n", `invalid variable name "MY-VAR"`},
+		{" A=1\n", `invalid variable name " A"`},
+		{"A=1\nB=2\nA=3\n", "line 3: A is already set on line 1"},
+	}
+	for _, tt := range tests {
+		err := ValidateEnvContent(tt.content)
+		require.Error(t, err, "%q", tt.content)
+		assert.Contains(t, err.Error(), tt.wantErr)
+	}
+}
+
+func TestValidateEntrypoint(t *testing.T) {
+	for _, name := range []string{"web", "websecure", "internal", "admin_2", "lan-only"} {
+		assert.NoError(t, ValidateEntrypoint(name), name)
+	}
+	for _, name := range []string{"", "-web", "web secure", "a,b", "web`", strings.Repeat("a", 65)} {
+		assert.Error(t, ValidateEntrypoint(name), name)
+	}
+}
+
+func TestLoadFromBytes_DistinctRoutes(t *testing.T) {
+	_, err := LoadFromBytes([]byte(`server: myserver
+services:
+  web:
+    domain: app.example.com
+  api:
+    domain: app.example.com
+    path: /api
+  admin:
+    domain: app.example.com
+    path: /admin
+  docs:
+    domain: docs.example.com
+    path: /api
+  db:
+    domain: app.example.com
+    router: tcp
+  admin-ui:
+    domain: app.example.com
+    entrypoints: [internal]
+`))
+	require.NoError(t, err)
+}
+
 func TestRootConfig_Runtime_DefaultsToCompose(t *testing.T) {
 	cfg, err := LoadFromBytes([]byte("server: myserver\nservices:\n  web: {}"))
 	require.NoError(t, err)
@@ -2561,3 +3453,939 @@ func TestConfig_RetainTagsServiceZeroOverridesRoot(t *testing.T) {
 	require.NoError(t, err)
 	assert.Equal(t, 0, svc.RetainTags())
 }
+
+// --- project ---
+
+func TestConfig_ProjectNameDefaultsToStackBase(t *testing.T) {
+	yaml := "server: srv\nstack: /stacks/myapp\nservices:\n  web: {}\n"
+	cfg, err := LoadFromBytes([]byte(yaml))
+	require.NoError(t, err)
+	svc, err := cfg.GetService("web")
+	require.NoError(t, err)
+	assert.Equal(t, "myapp", svc.ProjectName())
+	assert.Equal(t, "ssd-myapp-web", svc.ImageName())
+}
+
+func TestConfig_ProjectNameOverride(t *testing.T) {
+	yaml := "server: srv\nproject: shop\nstack: /a/web\nservices:\n  web: {}\n  api:\n    image: nginx:1\n"
+	cfg, err := LoadFromBytes([]byte(yaml))
+	require.NoError(t, err)
+
+	web, err := cfg.GetService("web")
+	require.NoError(t, err)
+	assert.Equal(t, "shop", web.ProjectName())
+	assert.Equal(t, "ssd-shop-web", web.ImageName())
+	assert.Equal(t, "ssd-shop-web", web.BuiltImageName())
+
+	api, err := cfg.GetService("api")
+	require.NoError(t, err)
+	assert.Equal(t, "nginx:1", api.ImageName())
+	assert.Equal(t, "ssd-shop-api", api.BuiltImageName())
+
+	assert.Equal(t, "shop", ProjectName(map[string]*Config{"web": web, "api": api}, "/a/web"))
+}
+
+func TestConfig_ProjectNameValidation(t *testing.T) {
+	yaml := "server: srv\nproject: My_Shop\nservices:\n  web: {}\n"
+	cfg, err := LoadFromBytes([]byte(yaml))
+	require.NoError(t, err)
+	_, err = cfg.GetService("web")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "invalid project")
+}
+
+func TestValidateProjectName(t *testing.T) {
+	tests := []struct {
+		name    string
+		project string
+		wantErr bool
+	}{
+		{name: "simple", project: "shop", wantErr: false},
+		{name: "hyphen and underscore", project: "my-shop_2", wantErr: false},
+		{name: "starts with digit", project: "2shop", wantErr: false},
+		{name: "empty", project: "", wantErr: true},
+		{name: "uppercase", project: "Shop", wantErr: true},
+		{name: "starts with hyphen", project: "-shop", wantErr: true},
+		{name: "contains slash", project: "a/shop", wantErr: true},
+		{name: "contains semicolon", project: "shop;rm", wantErr: true},
+		{name: "too long", project: strings.Repeat("a", 64), wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateProjectName(tt.project)
+			if tt.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestLoadFromBytes_HealthGate(t *testing.T) {
+	yaml := "server: srv\nservices:\n  web:\n    deploy:\n      health_gate: true\n      health_timeout: 2m\n      health_grace: 15s\n"
+	cfg, err := LoadFromBytes([]byte(yaml))
+	require.NoError(t, err)
+	svc, err := cfg.GetService("web")
+	require.NoError(t, err)
+	assert.True(t, svc.HealthGateEnabled())
+	assert.Equal(t, 2*time.Minute, svc.HealthGateTimeout())
+	assert.Equal(t, 15*time.Second, svc.HealthGrace())
+}
+
+func TestConfig_HealthGateDefaults(t *testing.T) {
+	cfg := &Config{}
+	assert.False(t, cfg.HealthGateEnabled())
+	assert.Equal(t, 60*time.Second, cfg.HealthGateTimeout())
+	assert.Equal(t, time.Duration(0), cfg.HealthGrace())
+}
+
+func TestConfig_HealthGateTimeout(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  *Config
+		want time.Duration
+	}{
+		{name: "no healthcheck", cfg: &Config{}, want: 60 * time.Second},
+		{name: "docker defaults", cfg: &Config{HealthCheck: &HealthCheck{Cmd: "exit 0"}}, want: 3*(30+30)*time.Second + 30*time.Second},
+		{name: "interval and retries", cfg: &Config{HealthCheck: &HealthCheck{Cmd: "exit 0", Interval: "5s", Timeout: "1s", Retries: 4}}, want: 4*6*time.Second + 30*time.Second},
+		{name: "large timeout widens window", cfg: &Config{HealthCheck: &HealthCheck{Cmd: "exit 0", Interval: "5s", Timeout: "40s", Retries: 4}}, want: 4*45*time.Second + 30*time.Second},
+		{name: "start period", cfg: &Config{HealthCheck: &HealthCheck{Cmd: "exit 0", Interval: "5s", Timeout: "1s", Retries: 4, StartPeriod: "90s"}}, want: 4*6*time.Second + 90*time.Second + 30*time.Second},
+		{name: "capped", cfg: &Config{HealthCheck: &HealthCheck{Cmd: "exit 0", Interval: "1m", Timeout: "1m", Retries: 10}}, want: 10 * time.Minute},
+		{name: "explicit health_timeout wins", cfg: &Config{HealthCheck: &HealthCheck{Cmd: "exit 0"}, Deploy: &DeployConfig{HealthTimeout: "45s"}}, want: 45 * time.Second},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, tt.cfg.HealthGateTimeout())
+		})
+	}
+}
+
+func TestRootConfig_GetService_ValidatesHealthGateDurations(t *testing.T) {
+	for _, field := range []string{"health_timeout", "health_grace"} {
+		yaml := "server: srv\nservices:\n  web:\n    deploy:\n      health_gate: true\n      " + field + ": soon\n"
+		cfg, err := LoadFromBytes([]byte(yaml))
+		require.NoError(t, err)
+		_, err = cfg.GetService("web")
+		require.Error(t, err, field)
+		assert.Contains(t, err.Error(), "invalid deploy "+field)
+	}
+}
+
+// --- profiles ---
+
+func TestConfig_Profiles(t *testing.T) {
+	yaml := "server: srv\nservices:\n  web: {}\n  seed:\n    image: shop/seed:1\n    profiles: [setup, debug]\n"
+	cfg, err := LoadFromBytes([]byte(yaml))
+	require.NoError(t, err)
+	cfg.ActiveProfiles = []string{"debug"}
+
+	seed, err := cfg.GetService("seed")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"setup", "debug"}, seed.Profiles)
+	assert.Equal(t, []string{"debug"}, seed.ActiveProfiles)
+	assert.True(t, seed.ProfileActive([]string{"debug"}))
+	assert.True(t, seed.ProfileActive([]string{"other", "setup"}))
+	assert.False(t, seed.ProfileActive(nil))
+
+	web, err := cfg.GetService("web")
+	require.NoError(t, err)
+	assert.True(t, web.ProfileActive(nil), "services without profiles always run")
+}
+
+func TestConfig_ProfilesValidation(t *testing.T) {
+	yaml := "server: srv\nservices:\n  web:\n    profiles: [\"bad;rm\"]\n"
+	cfg, err := LoadFromBytes([]byte(yaml))
+	require.NoError(t, err)
+	_, err = cfg.GetService("web")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "invalid profile")
+}
+
+func TestValidateProfile(t *testing.T) {
+	tests := []struct {
+		name    string
+		profile string
+		wantErr bool
+	}{
+		{name: "simple", profile: "debug", wantErr: false},
+		{name: "mixed", profile: "Setup_2.x-b", wantErr: false},
+		{name: "digit first", profile: "1st", wantErr: false},
+		{name: "empty", profile: "", wantErr: true},
+		{name: "too long", profile: strings.Repeat("a", 65), wantErr: true},
+		{name: "starts with hyphen", profile: "-debug", wantErr: true},
+		{name: "starts with dot", profile: ".debug", wantErr: true},
+		{name: "contains space", profile: "my profile", wantErr: true},
+		{name: "contains semicolon", profile: "a;b", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateProfile(tt.profile)
+			if tt.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+// --- pre_start ---
+
+func TestConfig_PreStart(t *testing.T) {
+	yaml := "server: srv\nservices:\n  web:\n    pre_start:\n      command: npm run migrate\n"
+	cfg, err := LoadFromBytes([]byte(yaml))
+	require.NoError(t, err)
+	web, err := cfg.GetService("web")
+	require.NoError(t, err)
+	require.NotNil(t, web.PreStart)
+	assert.Equal(t, "npm run migrate", web.PreStart.Command)
+	assert.Equal(t, "web-pre-start", PreStartJobName("web"))
+}
+
+func TestValidatePreStart(t *testing.T) {
+	assert.NoError(t, ValidatePreStart(nil))
+	assert.NoError(t, ValidatePreStart(&PreStartConfig{Command: "migrate up", Image: "migrate/migrate:v4"}))
+	assert.Error(t, ValidatePreStart(&PreStartConfig{}))
+	assert.Error(t, ValidatePreStart(&PreStartConfig{Command: "  "}))
+	assert.Error(t, ValidatePreStart(&PreStartConfig{Command: "x", Image: "bad image"}))
+}
+
+// --- smoke_test ---
+
+func TestConfig_SmokeTest(t *testing.T) {
+	yaml := "server: srv\nservices:\n  web:\n    domain: app.example.com\n    path: /api\n    smoke_test:\n      path: /healthz\n      status: 204\n      timeout: 1m\n"
+	cfg, err := LoadFromBytes([]byte(yaml))
+	require.NoError(t, err)
+	web, err := cfg.GetService("web")
+	require.NoError(t, err)
+	require.NotNil(t, web.SmokeTest)
+	url, err := web.SmokeTestURL()
+	require.NoError(t, err)
+	assert.Equal(t, "https://app.example.com/api/healthz", url)
+	assert.Equal(t, 204, web.SmokeTest.Status)
+	assert.Equal(t, time.Minute, web.SmokeTestTimeout())
+	assert.Equal(t, 30*time.Second, (&Config{SmokeTest: &SmokeTestConfig{URL: "http://x"}}).SmokeTestTimeout())
+}
+
+func TestConfig_SmokeTestPathNeedsDomain(t *testing.T) {
+	yaml := "server: srv\nservices:\n  worker:\n    smoke_test:\n      path: /healthz\n"
+	cfg, err := LoadFromBytes([]byte(yaml))
+	require.NoError(t, err)
+	_, err = cfg.GetService("worker")
+	assert.ErrorContains(t, err, "invalid smoke_test: path needs the service's URL")
+}
+
+func TestValidateSmokeTest(t *testing.T) {
+	assert.NoError(t, ValidateSmokeTest(nil))
+	assert.NoError(t, ValidateSmokeTest(&SmokeTestConfig{URL: "https://app.example.com/healthz", Status: 200, Timeout: "45s"}))
+	assert.NoError(t, ValidateSmokeTest(&SmokeTestConfig{Path: "/healthz", FromServer: true}))
+	assert.Error(t, ValidateSmokeTest(&SmokeTestConfig{}))
+	assert.Error(t, ValidateSmokeTest(&SmokeTestConfig{URL: "https://a.com", Path: "/b"}))
+	assert.Error(t, ValidateSmokeTest(&SmokeTestConfig{URL: "app.example.com/healthz"}))
+	assert.Error(t, ValidateSmokeTest(&SmokeTestConfig{URL: "ftp://app.example.com"}))
+	assert.Error(t, ValidateSmokeTest(&SmokeTestConfig{URL: "https://a.com/'; rm -rf /"}))
+	assert.Error(t, ValidateSmokeTest(&SmokeTestConfig{Path: "healthz"}))
+	assert.Error(t, ValidateSmokeTest(&SmokeTestConfig{Path: "/h", Status: 42}))
+	assert.Error(t, ValidateSmokeTest(&SmokeTestConfig{Path: "/h", Timeout: "soon"}))
+}
+
+// --- extends ---
+
+func TestLoadFromBytes_Extends(t *testing.T) {
+	yaml := `server: srv
+services:
+  api:
+    name: api-svc
+    context: ./go
+    dockerfile: ./go/Dockerfile
+    port: 8080
+    healthcheck:
+      cmd: "wget -qO- localhost:8080/health"
+      interval: 10s
+  worker:
+    extends: api
+    port: 9090
+    healthcheck:
+      interval: 30s
+`
+	cfg, err := LoadFromBytes([]byte(yaml))
+	require.NoError(t, err)
+
+	worker, err := cfg.GetService("worker")
+	require.NoError(t, err)
+	assert.Equal(t, "worker", worker.Name, "name is not inherited")
+	assert.Equal(t, "./go", worker.Context)
+	assert.Equal(t, "./go/Dockerfile", worker.Dockerfile)
+	assert.Equal(t, 9090, worker.Port, "child overrides parent")
+	require.NotNil(t, worker.HealthCheck)
+	assert.Equal(t, "wget -qO- localhost:8080/health", worker.HealthCheck.Cmd, "nested maps merge")
+	assert.Equal(t, "30s", worker.HealthCheck.Interval)
+
+	api, err := cfg.GetService("api")
+	require.NoError(t, err)
+	assert.Equal(t, 8080, api.Port, "parent is untouched")
+	assert.Equal(t, "10s", api.HealthCheck.Interval)
+}
+
+func TestLoadFromBytes_ExtendsDefaultsTemplate(t *testing.T) {
+	yaml := `server: srv
+services:
+  _defaults:
+    context: ./services
+    depends_on: [db]
+  db:
+    image: postgres:16
+  api:
+    extends: _defaults
+  web:
+    extends: api
+    depends_on: [db, api]
+`
+	cfg, err := LoadFromBytes([]byte(yaml))
+	require.NoError(t, err)
+
+	services := cfg.ListServices()
+	sort.Strings(services)
+	assert.Equal(t, []string{"api", "db", "web"}, services, "templates are not deployed")
+
+	web, err := cfg.GetService("web")
+	require.NoError(t, err)
+	assert.Equal(t, "./services", web.Context, "chained extends")
+	assert.Equal(t, []string{"db", "api"}, web.DependsOn.Names(), "sequences replace")
+}
+
+func TestLoadFromBytes_ExtendsCycle(t *testing.T) {
+	yaml := "services:\n  a:\n    extends: b\n  b:\n    extends: c\n  c:\n    extends: a\n"
+	_, err := LoadFromBytes([]byte(yaml))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "extends cycle")
+	assert.Contains(t, err.Error(), "a -> b -> c -> a")
+}
+
+func TestLoadFromBytes_ExtendsSelf(t *testing.T) {
+	_, err := LoadFromBytes([]byte("services:\n  a:\n    extends: a\n"))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "extends cycle")
+}
+
+func TestLoadFromBytes_ExtendsUnknown(t *testing.T) {
+	_, err := LoadFromBytes([]byte("services:\n  a:\n    extends: nope\n"))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), `service "a" extends unknown service "nope"`)
+}
+
+func TestLoadFromBytes_YAMLMergeKeys(t *testing.T) {
+	yaml := "server: srv\nx-base: &base\n  context: ./go\n  port: 8080\nservices:\n  api:\n    <<: *base\n  worker:\n    <<: *base\n    port: 9090\n"
+	cfg, err := LoadFromBytes([]byte(yaml))
+	require.NoError(t, err)
+
+	worker, err := cfg.GetService("worker")
+	require.NoError(t, err)
+	assert.Equal(t, "./go", worker.Context)
+	assert.Equal(t, 9090, worker.Port)
+}
+
+func TestGetService_BuildArgsPrecedence(t *testing.T) {
+	yaml := "server: srv\nservices:\n  web:\n    build_args:\n      NODE_ENV: production\n      BUILD_NUMBER: dev\n"
+	cfg, err := LoadFromBytes([]byte(yaml))
+	require.NoError(t, err)
+
+	web, err := cfg.GetService("web")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{
+		"NODE_ENV":     "production",
+		"BUILD_NUMBER": "42",
+		"COMMIT":       "abc",
+	}, web.ResolvedBuildArgs(map[string]string{"BUILD_NUMBER": "42", "COMMIT": "abc"}))
+	assert.Equal(t, "dev", web.BuildArgs["BUILD_NUMBER"], "CLI overrides must not mutate the config")
+}
+
+func TestConfig_ResolvedBuildArgsEmpty(t *testing.T) {
+	assert.Nil(t, (&Config{}).ResolvedBuildArgs(nil))
+	assert.Equal(t, map[string]string{"A": "1"}, (&Config{}).ResolvedBuildArgs(map[string]string{"A": "1"}))
+}
+
+func TestGetService_InvalidBuildArgName(t *testing.T) {
+	yaml := "server: srv\nservices:\n  web:\n    build_args:\n      \"1BAD\": x\n"
+	cfg, err := LoadFromBytes([]byte(yaml))
+	require.NoError(t, err)
+
+	_, err = cfg.GetService("web")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "invalid build_args")
+}
+
+func TestParseBuildArg(t *testing.T) {
+	tests := []struct {
+		arg      string
+		key, val string
+		wantErr  bool
+	}{
+		{arg: "BUILD_NUMBER=42", key: "BUILD_NUMBER", val: "42"},
+		{arg: "EMPTY=", key: "EMPTY", val: ""},
+		{arg: "URL=a=b", key: "URL", val: "a=b"},
+		{arg: "_x=1", key: "_x", val: "1"},
+		{arg: "NOVALUE", wantErr: true},
+		{arg: "=1", wantErr: true},
+		{arg: "1X=1", wantErr: true},
+		{arg: "A-B=1", wantErr: true},
+		{arg: "A B=1", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.arg, func(t *testing.T) {
+			key, val, err := ParseBuildArg(tt.arg)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.key, key)
+			assert.Equal(t, tt.val, val)
+		})
+	}
+}
+
+func TestConfig_ManualStart(t *testing.T) {
+	yaml := "server: srv\nservices:\n  backup:\n    restart: false\n  web:\n    restart: true\n  api: {}\n"
+	cfg, err := LoadFromBytes([]byte(yaml))
+	require.NoError(t, err)
+
+	backup, err := cfg.GetService("backup")
+	require.NoError(t, err)
+	assert.True(t, backup.ManualStart())
+
+	web, err := cfg.GetService("web")
+	require.NoError(t, err)
+	assert.False(t, web.ManualStart())
+
+	api, err := cfg.GetService("api")
+	require.NoError(t, err)
+	assert.False(t, api.ManualStart())
+}
+
+func TestGetService_Schedule(t *testing.T) {
+	cfg, err := LoadFromBytes([]byte("server: srv\nservices:\n  backup:\n    schedule: \"0 3 * * *\"\n"))
+	require.NoError(t, err)
+	backup, err := cfg.GetService("backup")
+	require.NoError(t, err)
+	assert.Equal(t, "0 3 * * *", backup.Schedule)
+
+	cfg, err = LoadFromBytes([]byte("server: srv\nservices:\n  backup:\n    schedule: \"61 3 * * *\"\n"))
+	require.NoError(t, err)
+	_, err = cfg.GetService("backup")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "invalid schedule")
+}
+
+func TestParseMemory(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    int64
+		wantErr bool
+	}{
+		{"512", 512, false},
+		{"100b", 100, false},
+		{"64k", 64 << 10, false},
+		{"512m", 512 << 20, false},
+		{"1G", 1 << 30, false},
+		{"0m", 0, true},
+		{"1.5g", 0, true},
+		{"512mb", 0, true},
+		{"-1m", 0, true},
+		{"lots", 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := ParseMemory(tt.in)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestParseCPUs(t *testing.T) {
+	for _, ok := range []string{"0.5", "1", "2.25"} {
+		_, err := ParseCPUs(ok)
+		assert.NoError(t, err, ok)
+	}
+	for _, bad := range []string{"0", "-1", "two", "Inf"} {
+		_, err := ParseCPUs(bad)
+		assert.Error(t, err, bad)
+	}
+}
+
+func TestGetService_Resources(t *testing.T) {
+	tests := []struct {
+		name    string
+		body    string
+		wantErr string
+	}{
+		{"limits and reservations", "cpu_limit: \"2\"\n    memory_limit: 1g\n    cpu_reservation: \"0.5\"\n    memory_reservation: 256m", ""},
+		{"reservations only", "cpu_reservation: \"0.5\"\n    memory_reservation: 256m", ""},
+		{"invalid cpu_reservation", "cpu_reservation: half", "invalid cpu_reservation"},
+		{"invalid memory_reservation", "memory_reservation: 1 gig", "invalid memory_reservation"},
+		{"invalid cpu_limit", "cpu_limit: \"0\"", "invalid cpu_limit"},
+		{"invalid memory_limit", "memory_limit: big", "invalid memory_limit"},
+		{"cpu reservation exceeds limit", "cpu_limit: \"1\"\n    cpu_reservation: \"2\"", "cpu_reservation 2 exceeds cpu_limit 1"},
+		{"memory reservation exceeds limit", "memory_limit: 512m\n    memory_reservation: 1g", "memory_reservation 1g exceeds memory_limit 512m"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, err := LoadFromBytes([]byte("server: srv\nservices:\n  web:\n    " + tt.body + "\n"))
+			require.NoError(t, err)
+			_, err = cfg.GetService("web")
+			if tt.wantErr != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+		})
+	}
+}
+
+func TestGetService_Ulimits(t *testing.T) {
+	yaml := "server: srv\nservices:\n  db:\n    ulimits:\n      nofile: 65536\n      nproc: {soft: 1024, hard: 4096}\n"
+	cfg, err := LoadFromBytes([]byte(yaml))
+	require.NoError(t, err)
+	db, err := cfg.GetService("db")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]Ulimit{
+		"nofile": {Soft: 65536, Hard: 65536},
+		"nproc":  {Soft: 1024, Hard: 4096},
+	}, db.Ulimits)
+}
+
+func TestLoad_InvalidUlimitValue(t *testing.T) {
+	for _, body := range []string{
+		"nofile: lots",
+		"nofile: {soft: 1024}",
+		"nofile: [1024]",
+	} {
+		_, err := LoadFromBytes([]byte("server: srv\nservices:\n  db:\n    ulimits:\n      " + body + "\n"))
+		assert.Error(t, err, body)
+	}
+}
+
+func TestValidateUlimit(t *testing.T) {
+	tests := []struct {
+		name    string
+		ulimit  string
+		value   Ulimit
+		wantErr string
+	}{
+		{"nofile", "nofile", Ulimit{Soft: 1024, Hard: 65536}, ""},
+		{"unlimited", "memlock", Ulimit{Soft: -1, Hard: -1}, ""},
+		{"soft below unlimited hard", "memlock", Ulimit{Soft: 1024, Hard: -1}, ""},
+		{"unknown name", "files", Ulimit{Soft: 1, Hard: 1}, "unknown ulimit"},
+		{"negative", "nofile", Ulimit{Soft: -2, Hard: 10}, "non-negative"},
+		{"soft above hard", "nofile", Ulimit{Soft: 4096, Hard: 1024}, "exceeds hard limit"},
+		{"unlimited soft with hard", "nofile", Ulimit{Soft: -1, Hard: 1024}, "exceeds hard limit"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateUlimit(tt.ulimit, tt.value)
+			if tt.wantErr == "" {
+				assert.NoError(t, err)
+				return
+			}
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.wantErr)
+		})
+	}
+}
+
+func TestValidateSysctl(t *testing.T) {
+	assert.NoError(t, ValidateSysctl("net.core.somaxconn", "1024"))
+	assert.NoError(t, ValidateSysctl("net.ipv4.conf.eth0.rp_filter", "1"))
+	assert.Error(t, ValidateSysctl("somaxconn", "1024"))
+	assert.Error(t, ValidateSysctl("net.core.somaxconn=1", "1024"))
+	assert.Error(t, ValidateSysctl("net.core.somaxconn", ""))
+	assert.Error(t, ValidateSysctl("net.core.somaxconn", "1\n2"))
+}
+
+func TestGetService_Sysctls(t *testing.T) {
+	cfg, err := LoadFromBytes([]byte("server: srv\nservices:\n  db:\n    sysctls:\n      net.core.somaxconn: 1024\n"))
+	require.NoError(t, err)
+	db, err := cfg.GetService("db")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"net.core.somaxconn": "1024"}, db.Sysctls)
+
+	cfg, err = LoadFromBytes([]byte("server: srv\nservices:\n  db:\n    ulimits:\n      openfiles: 10\n"))
+	require.NoError(t, err)
+	_, err = cfg.GetService("db")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "invalid ulimits")
+}
+
+func TestValidateExtraHost(t *testing.T) {
+	tests := []struct {
+		host, ip string
+		wantErr  bool
+	}{
+		{"ldap.internal", "10.0.0.5", false},
+		{"db", "fd00::7", false},
+		{"host.docker.internal", HostGateway, false},
+		{"bad_host", "10.0.0.5", true},
+		{"-leading.dash", "10.0.0.5", true},
+		{"ldap.internal", "10.0.0.256", true},
+		{"ldap.internal", "ldap.example.com", true},
+		{"ldap.internal", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.host+"="+tt.ip, func(t *testing.T) {
+			err := ValidateExtraHost(tt.host, tt.ip)
+			if tt.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestGetService_ExtraHosts(t *testing.T) {
+	cfg, err := LoadFromBytes([]byte("server: srv\nservices:\n  web:\n    extra_hosts:\n      ldap.internal: 10.0.0.5\n"))
+	require.NoError(t, err)
+	web, err := cfg.GetService("web")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"ldap.internal": "10.0.0.5"}, web.ExtraHosts)
+
+	cfg, err = LoadFromBytes([]byte("server: srv\nservices:\n  web:\n    extra_hosts:\n      ldap.internal: not-an-ip\n"))
+	require.NoError(t, err)
+	_, err = cfg.GetService("web")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "invalid extra_hosts")
+}
+
+func TestValidateCapability(t *testing.T) {
+	for _, ok := range []string{"NET_ADMIN", "CAP_NET_ADMIN", "SYS_TIME", "ALL"} {
+		assert.NoError(t, ValidateCapability(ok), ok)
+	}
+	for _, bad := range []string{"net_admin", "NET_ADMINS", "CAP_ALL", ""} {
+		assert.Error(t, ValidateCapability(bad), bad)
+	}
+}
+
+func TestGetService_Capabilities(t *testing.T) {
+	cfg, err := LoadFromBytes([]byte("server: srv\nservices:\n  vpn:\n    cap_add: [NET_ADMIN]\n    cap_drop: [ALL]\n    privileged: true\n"))
+	require.NoError(t, err)
+	vpn, err := cfg.GetService("vpn")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"NET_ADMIN"}, vpn.CapAdd)
+	assert.Equal(t, []string{"ALL"}, vpn.CapDrop)
+	assert.True(t, vpn.Privileged)
+
+	cfg, err = LoadFromBytes([]byte("server: srv\nservices:\n  vpn:\n    cap_add: [NET_ADMINISTRATOR]\n"))
+	require.NoError(t, err)
+	_, err = cfg.GetService("vpn")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "invalid cap_add")
+
+	cfg, err = LoadFromBytes([]byte("server: srv\nservices:\n  vpn:\n    cap_drop: [everything]\n"))
+	require.NoError(t, err)
+	_, err = cfg.GetService("vpn")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "invalid cap_drop")
+}
+
+func TestGetService_ComposeFilename(t *testing.T) {
+	cfg, err := LoadFromBytes([]byte("server: srv\nservices:\n  web: {}\n"))
+	require.NoError(t, err)
+	web, err := cfg.GetService("web")
+	require.NoError(t, err)
+	assert.Equal(t, "compose.yaml", web.ComposeFilename())
+
+	cfg, err = LoadFromBytes([]byte("server: srv\ncompose_filename: docker-compose.yml\nservices:\n  web: {}\n"))
+	require.NoError(t, err)
+	web, err = cfg.GetService("web")
+	require.NoError(t, err)
+	assert.Equal(t, "docker-compose.yml", web.ComposeFilename())
+}
+
+func TestValidateComposeFilename(t *testing.T) {
+	for _, ok := range []string{"compose.yaml", "docker-compose.yml", "compose.prod.yaml"} {
+		assert.NoError(t, ValidateComposeFilename(ok), ok)
+	}
+	for _, bad := range []string{"", "compose", "compose.json", "../compose.yaml", "dir/compose.yaml", ".yaml", "my compose.yaml"} {
+		assert.Error(t, ValidateComposeFilename(bad), bad)
+	}
+
+	cfg, err := LoadFromBytes([]byte("server: srv\ncompose_filename: ../compose.yaml\nservices:\n  web: {}\n"))
+	require.NoError(t, err)
+	_, err = cfg.GetService("web")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "invalid compose_filename")
+}
+
+func TestGetService_SSHClient(t *testing.T) {
+	cfg, err := LoadFromBytes([]byte("server: srv\nssh_client: native\nservices:\n  web: {}\n"))
+	require.NoError(t, err)
+	web, err := cfg.GetService("web")
+	require.NoError(t, err)
+	assert.Equal(t, SSHClientNative, web.SSHClient)
+
+	cfg, err = LoadFromBytes([]byte("server: srv\nssh_client: putty\nservices:\n  web: {}\n"))
+	require.NoError(t, err)
+	_, err = cfg.GetService("web")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "invalid ssh_client")
+}
+
+func TestGetService_ProjectDir(t *testing.T) {
+	cfg, err := LoadFromBytes([]byte("server: srv\nproject_dir: ../..\nservices:\n  web:\n    context: ./web\n"))
+	require.NoError(t, err)
+	web, err := cfg.GetService("web")
+	require.NoError(t, err)
+	assert.Equal(t, "../..", web.ProjectDir)
+}
+
+func TestGetService_Events(t *testing.T) {
+	cfg, err := LoadFromBytes([]byte("server: srv\nevents: /run/ssd-events.sock\nservices:\n  web: {}\n"))
+	require.NoError(t, err)
+	web, err := cfg.GetService("web")
+	require.NoError(t, err)
+	assert.Equal(t, "/run/ssd-events.sock", web.Events)
+}
+
+func TestRootConfig_OverrideStack(t *testing.T) {
+	cfg, err := LoadFromBytes([]byte("server: srv\nstack: /stacks/app\nservices:\n  web:\n    stack: /stacks/web\n  api: {}\n"))
+	require.NoError(t, err)
+	cfg.OverrideStack("/stacks/app-staging")
+
+	for _, name := range []string{"web", "api"} {
+		svc, err := cfg.GetService(name)
+		require.NoError(t, err)
+		assert.Equal(t, "/stacks/app-staging", svc.StackPath(), name)
+		assert.Equal(t, "app-staging", svc.ProjectName(), name)
+		assert.Equal(t, "ssd-app-staging-"+name, svc.ImageName(), name)
+	}
+
+	for _, bad := range []string{"stacks/app", "/stacks/../etc", "/stacks/app;rm"} {
+		cfg.OverrideStack(bad)
+		_, err := cfg.GetService("web")
+		require.Error(t, err, bad)
+		assert.Contains(t, err.Error(), "invalid stack path", bad)
+	}
+}
+
+func TestValidateSSHClient(t *testing.T) {
+	for _, ok := range []string{"", "openssh", "native"} {
+		assert.NoError(t, ValidateSSHClient(ok), ok)
+	}
+	for _, bad := range []string{"Native", "ssh", "libssh"} {
+		assert.Error(t, ValidateSSHClient(bad), bad)
+	}
+}
+
+const testHostKey = "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIKkWAfc2M6NK7HGqRttCFoSqDuWMW+12Al0J/Xu1zGAj"
+
+func TestGetService_HostKey(t *testing.T) {
+	cfg, err := LoadFromBytes([]byte("server: srv\nhost_key: " + testHostKey + " web1\nservices:\n  web: {}\n"))
+	require.NoError(t, err)
+	web, err := cfg.GetService("web")
+	require.NoError(t, err)
+	assert.Equal(t, testHostKey+" web1", web.HostKey)
+	assert.Equal(t, HostKeyCheckingYes, web.HostKeyCheckingMode())
+
+	cfg, err = LoadFromBytes([]byte("server: srv\nstrict_host_key_checking: accept-new\nservices:\n  web: {}\n"))
+	require.NoError(t, err)
+	web, err = cfg.GetService("web")
+	require.NoError(t, err)
+	assert.Equal(t, HostKeyCheckingAcceptNew, web.HostKeyCheckingMode())
+
+	cfg, err = LoadFromBytes([]byte("server: srv\nstrict_host_key_checking: maybe\nservices:\n  web: {}\n"))
+	require.NoError(t, err)
+	_, err = cfg.GetService("web")
+	assert.ErrorContains(t, err, "invalid strict_host_key_checking")
+}
+
+func TestGetService_HostKeyFingerprintRequiresNative(t *testing.T) {
+	const fp = "SHA256:aoPmDUzlrS8FKMFmEuCdDhhNIhdgOI2yKhEiIgIn1Zk"
+	cfg, err := LoadFromBytes([]byte("server: srv\nhost_key: " + fp + "\nservices:\n  web: {}\n"))
+	require.NoError(t, err)
+	_, err = cfg.GetService("web")
+	assert.ErrorContains(t, err, "ssh_client: native")
+
+	cfg, err = LoadFromBytes([]byte("server: srv\nssh_client: native\nhost_key: " + fp + "\nservices:\n  web: {}\n"))
+	require.NoError(t, err)
+	web, err := cfg.GetService("web")
+	require.NoError(t, err)
+	assert.Equal(t, fp, web.HostKey)
+}
+
+func TestHostKeyCheckingMode(t *testing.T) {
+	assert.Equal(t, HostKeyCheckingYes, (&Config{}).HostKeyCheckingMode())
+	assert.Equal(t, HostKeyCheckingNo, (&Config{HostKeyChecking: "no"}).HostKeyCheckingMode())
+	// A pinned key is always checked strictly.
+	assert.Equal(t, HostKeyCheckingYes, (&Config{HostKey: testHostKey, HostKeyChecking: "no"}).HostKeyCheckingMode())
+}
+
+func TestValidateHostKeyChecking(t *testing.T) {
+	for _, ok := range []string{"", "yes", "accept-new", "no"} {
+		assert.NoError(t, ValidateHostKeyChecking(ok), ok)
+	}
+	for _, bad := range []string{"Yes", "true", "ask"} {
+		assert.Error(t, ValidateHostKeyChecking(bad), bad)
+	}
+}
+
+func TestValidateHostKey(t *testing.T) {
+	for _, ok := range []string{
+		testHostKey,
+		testHostKey + " root@web1",
+		"SHA256:aoPmDUzlrS8FKMFmEuCdDhhNIhdgOI2yKhEiIgIn1Zk",
+	} {
+		assert.NoError(t, ValidateHostKey(ok), ok)
+	}
+	for _, bad := range []string{
+		"AAAAC3NzaC1lZDI1NTE5AAAAIKkWAfc2M6NK7HGqRttCFoSqDuWMW+12Al0J/Xu1zGAj",
+		"ssh-dss AAAAB3NzaC1kc3M=",
+		"ssh-ed25519 AAAA=B",
+		"SHA256:short",
+		"203.0.113.5 " + testHostKey,
+	} {
+		assert.Error(t, ValidateHostKey(bad), bad)
+	}
+}
+
+func TestGetService_Servers(t *testing.T) {
+	cfg, err := LoadFromBytes([]byte("server: srv\nservices:\n  web:\n    servers: [web1, web2]\n  db: {}\n"))
+	require.NoError(t, err)
+	web, err := cfg.GetService("web")
+	require.NoError(t, err)
+	assert.Equal(t, "web1", web.Server, "other commands use the first server")
+	assert.Equal(t, []string{"web1", "web2"}, web.Hosts())
+	db, err := cfg.GetService("db")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"srv"}, db.Hosts())
+
+	on := web.OnServer("web2")
+	assert.Equal(t, "web2", on.Server)
+	assert.Equal(t, []string{"web2"}, on.Hosts())
+	assert.Equal(t, "web1", web.Server, "OnServer copies")
+
+	for yaml, want := range map[string]string{
+		"services:\n  web:\n    server: a\n    servers: [b]\n": "cannot set both server and servers",
+		"services:\n  web:\n    servers: []\n":               "servers cannot be empty",
+		"services:\n  web:\n    servers: [a, 'b;c']\n":        "invalid server at index 1",
+		"services:\n  web:\n    servers: [a, b, a]\n":         `server "a" is listed twice`,
+	} {
+		cfg, err := LoadFromBytes([]byte(yaml))
+		require.NoError(t, err)
+		_, err = cfg.GetService("web")
+		require.Error(t, err, yaml)
+		assert.Contains(t, err.Error(), want, yaml)
+	}
+}
+
+// --- image_template ---
+
+func TestRenderImageName(t *testing.T) {
+	assert.Equal(t, "ssd-shop-web", RenderImageName("", "shop", "web"))
+	assert.Equal(t, "registry.example.com/shop/web", RenderImageName("registry.example.com/{project}/{service}", "shop", "web"))
+	assert.Equal(t, "localhost:5000/web-web", RenderImageName("localhost:5000/{service}-{service}", "shop", "web"))
+}
+
+func TestConfig_ImageTemplate(t *testing.T) {
+	yaml := "server: srv\nproject: shop\nimage_template: registry.example.com/{project}/{service}\nservices:\n  web: {}\n  db:\n    image: postgres:16\n"
+	cfg, err := LoadFromBytes([]byte(yaml))
+	require.NoError(t, err)
+
+	web, err := cfg.GetService("web")
+	require.NoError(t, err)
+	assert.Equal(t, "registry.example.com/shop/web", web.ImageName())
+	assert.Equal(t, "registry.example.com/shop/web", web.BuiltImageName())
+
+	db, err := cfg.GetService("db")
+	require.NoError(t, err)
+	assert.Equal(t, "postgres:16", db.ImageName(), "pre-built images ignore the template")
+}
+
+func TestConfig_ImageTemplateValidation(t *testing.T) {
+	yaml := "server: srv\nimage_template: registry.example.com/{team}/{service}\nservices:\n  web: {}\n"
+	cfg, err := LoadFromBytes([]byte(yaml))
+	require.NoError(t, err)
+	_, err = cfg.GetService("web")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "invalid image_template")
+	assert.Contains(t, err.Error(), "unknown placeholder {team}")
+}
+
+func TestValidateImageTemplate(t *testing.T) {
+	tests := []struct {
+		name     string
+		template string
+		wantErr  string
+	}{
+		{name: "default", template: DefaultImageTemplate},
+		{name: "registry path", template: "registry.example.com/{project}/{service}"},
+		{name: "registry port", template: "localhost:5000/apps/{service}"},
+		{name: "service only", template: "{service}"},
+		{name: "unknown placeholder", template: "{registry}/{service}", wantErr: "unknown placeholder {registry}"},
+		{name: "missing service", template: "registry.example.com/{project}", wantErr: "must contain {service}"},
+		{name: "tag", template: "registry.example.com/{service}:latest", wantErr: "valid image name"},
+		{name: "uppercase", template: "Registry/{service}", wantErr: "valid image name"},
+		{name: "unclosed brace", template: "{service}/{project", wantErr: "valid image name"},
+		{name: "shell metacharacter", template: "{service};rm", wantErr: "valid image name"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateImageTemplate(tt.template)
+			if tt.wantErr == "" {
+				assert.NoError(t, err)
+				return
+			}
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.wantErr)
+		})
+	}
+}
+
+// --- env_files ---
+
+func TestValidateEnvFiles(t *testing.T) {
+	tests := []struct {
+		name    string
+		files   []string
+		wantErr string
+	}{
+		{name: "none"},
+		{name: "shared files", files: []string{"common.env", "db-creds.env"}},
+		{name: "other service's file", files: []string{"api.env"}},
+		{name: "path", files: []string{"../common.env"}, wantErr: "file name in the stack directory"},
+		{name: "subdirectory", files: []string{"env/common.env"}, wantErr: "file name in the stack directory"},
+		{name: "no .env suffix", files: []string{"common"}, wantErr: "ending in .env"},
+		{name: "hidden", files: []string{".env"}, wantErr: "file name in the stack directory"},
+		{name: "own file", files: []string{"web.env"}, wantErr: "always loaded"},
+		{name: "duplicate", files: []string{"common.env", "common.env"}, wantErr: "listed twice"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateEnvFiles(tt.files, "web")
+			if tt.wantErr == "" {
+				assert.NoError(t, err)
+				return
+			}
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.wantErr)
+		})
+	}
+}
+
+func TestConfig_EnvFilesValidatedOnLoad(t *testing.T) {
+	yaml := "server: srv\nservices:\n  web:\n    env_files: [common.env, ../x.env]\n"
+	cfg, err := LoadFromBytes([]byte(yaml))
+	require.NoError(t, err)
+	_, err = cfg.GetService("web")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "invalid env_files")
+}
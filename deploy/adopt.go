@@ -0,0 +1,134 @@
+package deploy
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/byteink/ssd/compose"
+	"github.com/byteink/ssd/config"
+)
+
+// Adoption describes an existing compose stack brought under ssd
+// management by AdoptWithClient.
+type Adoption struct {
+	// AlreadyManaged is set when the compose file already carried the
+	// x-ssd block; nothing was written.
+	AlreadyManaged bool
+	// Versions holds, for each ssd.yaml service running its ssd-built
+	// image, the deployed version. Its next deploy builds the one after.
+	Versions map[string]int
+	// External maps ssd.yaml services running some other image to that
+	// image. Deploys of other services keep it until the service itself
+	// is deployed.
+	External map[string]string
+	// Missing lists ssd.yaml services the compose file doesn't have yet;
+	// their next deploy adds them.
+	Missing []string
+	// Unknown maps compose services that aren't in ssd.yaml to the config
+	// ssd.yaml would need for them. The next deploy drops them from the
+	// compose file.
+	Unknown map[string]*config.Config
+}
+
+// AdoptWithClient takes over the compose file already in cfg's stack:
+// it maps the file's services onto opts.AllServices and marks the file
+// with the x-ssd block, so later deploys regenerate it instead of
+// refusing to. The running services are not touched.
+func AdoptWithClient(cfg *config.Config, client Deployer, opts *Options) (Adoption, error) {
+	ctx := context.Background()
+	var adoption Adoption
+
+	output := io.Discard
+	rt := ""
+	services := map[string]*config.Config{cfg.Name: cfg}
+	if opts != nil {
+		if opts.Output != nil {
+			output = opts.Output
+		}
+		rt = opts.Runtime
+		if len(opts.AllServices) > 0 {
+			services = opts.AllServices
+		}
+	}
+	if rt == "k3s" {
+		return adoption, fmt.Errorf("adopt supports the compose runtime only")
+	}
+
+	// Acquire local and remote deployment locks
+	unlock, err := lockStack(ctx, cfg, client, opts)
+	if err != nil {
+		return adoption, err
+	}
+	defer unlock()
+
+	manifest := manifestName(rt, cfg)
+	content, err := client.ReadManifest(ctx)
+	if err != nil {
+		return adoption, fmt.Errorf("failed to read %s: %w", manifest, err)
+	}
+	if strings.TrimSpace(content) == "" {
+		return adoption, fmt.Errorf("no %s in %s to adopt; deploy creates one", manifest, cfg.StackPath())
+	}
+
+	adoption, err = mapCompose(content, cfg.StackPath(), services)
+	if err != nil {
+		return adoption, err
+	}
+	if adoption.AlreadyManaged {
+		logf(output, "%s in %s is already managed by ssd\n", manifest, cfg.StackPath())
+		return adoption, nil
+	}
+
+	logf(output, "==> Marking %s in %s as managed by ssd...\n", manifest, cfg.StackPath())
+	if err := client.CreateStack(ctx, compose.MarkManaged(content)); err != nil {
+		return adoption, fmt.Errorf("failed to update %s: %w", manifest, err)
+	}
+	return adoption, nil
+}
+
+// mapCompose matches the services of a compose file against the ssd.yaml
+// services.
+func mapCompose(content, stack string, services map[string]*config.Config) (Adoption, error) {
+	adoption := Adoption{
+		AlreadyManaged: compose.HasMetadata(content),
+		Versions:       make(map[string]int),
+		External:       make(map[string]string),
+		Unknown:        make(map[string]*config.Config),
+	}
+	parsed, err := compose.ParseServices(content)
+	if err != nil {
+		return adoption, err
+	}
+
+	project := config.ProjectName(services, stack)
+	for name, svc := range services {
+		found, ok := parsed[name]
+		if !ok {
+			adoption.Missing = append(adoption.Missing, name)
+			continue
+		}
+		if svc.IsPrebuilt() || found.Image == "" {
+			continue
+		}
+		prefix := config.RenderImageName(svc.ImageTemplate, project, name) + ":"
+		if tag, ok := strings.CutPrefix(found.Image, prefix); ok {
+			if v, err := strconv.Atoi(tag); err == nil {
+				adoption.Versions[name] = v
+				continue
+			}
+		}
+		adoption.External[name] = found.Image
+	}
+	sort.Strings(adoption.Missing)
+
+	for name, found := range parsed {
+		if _, ok := services[name]; !ok {
+			adoption.Unknown[name] = found.Config(name)
+		}
+	}
+	return adoption, nil
+}
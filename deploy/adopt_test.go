@@ -0,0 +1,126 @@
+package deploy
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/byteink/ssd/compose"
+	"github.com/byteink/ssd/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+const adoptedCompose = `services:
+  web:
+    image: ssd-shop-web:7
+  api:
+    image: ghcr.io/org/api:ci-3
+  db:
+    image: postgres:16
+  worker:
+    build: ./worker
+    depends_on: [db]
+`
+
+func adoptServices() map[string]*config.Config {
+	return map[string]*config.Config{
+		"web":    {Name: "web", Server: "testserver", Stack: "/stacks/shop", Context: ".", Dockerfile: "Dockerfile"},
+		"api":    {Name: "api", Server: "testserver", Stack: "/stacks/shop", Context: ".", Dockerfile: "Dockerfile"},
+		"db":     {Name: "db", Server: "testserver", Stack: "/stacks/shop", Image: "postgres:16"},
+		"static": {Name: "static", Server: "testserver", Stack: "/stacks/shop", Context: ".", Dockerfile: "Dockerfile"},
+	}
+}
+
+func TestMapCompose(t *testing.T) {
+	adoption, err := mapCompose(adoptedCompose, "/stacks/shop", adoptServices())
+
+	require.NoError(t, err)
+	assert.False(t, adoption.AlreadyManaged)
+	assert.Equal(t, map[string]int{"web": 7}, adoption.Versions)
+	assert.Equal(t, map[string]string{"api": "ghcr.io/org/api:ci-3"}, adoption.External)
+	assert.Equal(t, []string{"static"}, adoption.Missing)
+	require.Contains(t, adoption.Unknown, "worker")
+	worker := adoption.Unknown["worker"]
+	assert.Equal(t, "./worker", worker.Context)
+	assert.Equal(t, []string{"db"}, worker.DependsOn.Names())
+	assert.Len(t, adoption.Unknown, 1)
+}
+
+func TestMapCompose_VersionsDriveNextDeploy(t *testing.T) {
+	services := adoptServices()
+	adoption, err := mapCompose(adoptedCompose, "/stacks/shop", services)
+	require.NoError(t, err)
+
+	// The version map agrees with what a deploy reads from the file
+	versions := parseServiceVersions(compose.MarkManaged(adoptedCompose), "/stacks/shop", services)
+	assert.Equal(t, adoption.Versions["web"], versions["web"])
+}
+
+func TestAdoptWithClient_MarksCompose(t *testing.T) {
+	mockClient := new(MockDeployer)
+	services := adoptServices()
+	var buf bytes.Buffer
+
+	mockClient.On("ReadManifest").Return(adoptedCompose, nil)
+	mockClient.On("CreateStack", "x-ssd:\n  managed-by: ssd\n"+adoptedCompose).Return(nil)
+
+	adoption, err := AdoptWithClient(services["web"], mockClient, &Options{Output: &buf, AllServices: services})
+
+	require.NoError(t, err)
+	mockClient.AssertExpectations(t)
+	assert.Equal(t, 7, adoption.Versions["web"])
+	assert.Contains(t, buf.String(), "Marking compose.yaml in /stacks/shop as managed by ssd")
+	assert.True(t, manifestManaged("compose", "x-ssd:\n  managed-by: ssd\n"+adoptedCompose, "/stacks/shop", services))
+}
+
+func TestAdoptWithClient_AlreadyManaged(t *testing.T) {
+	mockClient := new(MockDeployer)
+	services := adoptServices()
+
+	mockClient.On("ReadManifest").Return("x-ssd:\n  managed-by: ssd\n"+adoptedCompose, nil)
+
+	adoption, err := AdoptWithClient(services["web"], mockClient, &Options{AllServices: services})
+
+	require.NoError(t, err)
+	assert.True(t, adoption.AlreadyManaged)
+	mockClient.AssertNotCalled(t, "CreateStack", mock.Anything)
+}
+
+func TestAdoptWithClient_NoCompose(t *testing.T) {
+	mockClient := new(MockDeployer)
+	services := adoptServices()
+
+	mockClient.On("ReadManifest").Return("\n", nil)
+
+	_, err := AdoptWithClient(services["web"], mockClient, &Options{AllServices: services})
+
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "no compose.yaml in /stacks/shop to adopt")
+	mockClient.AssertNotCalled(t, "CreateStack", mock.Anything)
+}
+
+func TestAdoptWithClient_WriteError(t *testing.T) {
+	mockClient := new(MockDeployer)
+	services := adoptServices()
+
+	mockClient.On("ReadManifest").Return(adoptedCompose, nil)
+	mockClient.On("CreateStack", mock.Anything).Return(errors.New("validation failed"))
+
+	_, err := AdoptWithClient(services["web"], mockClient, &Options{AllServices: services})
+
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "failed to update compose.yaml")
+}
+
+func TestAdoptWithClient_K3s(t *testing.T) {
+	mockClient := new(MockDeployer)
+	services := adoptServices()
+
+	_, err := AdoptWithClient(services["web"], mockClient, &Options{AllServices: services, Runtime: "k3s"})
+
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "compose runtime only")
+	mockClient.AssertNotCalled(t, "ReadManifest")
+}
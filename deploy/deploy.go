@@ -2,34 +2,45 @@ package deploy
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"io"
 	"log"
+	"maps"
 	"path/filepath"
+	"regexp"
 	"sort"
+	"strings"
+	"time"
 
 	"github.com/byteink/ssd/compose"
 	"github.com/byteink/ssd/config"
+	"github.com/byteink/ssd/images"
+	"github.com/byteink/ssd/internal/clock"
+	"github.com/byteink/ssd/internal/color"
 	"github.com/byteink/ssd/k8s"
 	"github.com/byteink/ssd/remote"
+	"gopkg.in/yaml.v3"
 )
 
-// logf writes formatted output, logging errors to stderr if write fails
+// logf writes formatted output, colored on a terminal (see color.Line),
+// logging errors to stderr if write fails
 func logf(w io.Writer, format string, args ...interface{}) {
-	if _, err := fmt.Fprintf(w, format, args...); err != nil {
+	if _, err := fmt.Fprint(w, color.Line(w, fmt.Sprintf(format, args...))); err != nil {
 		log.Printf("failed to write output: %v", err)
 	}
 }
 
-// logln writes a line to output, logging errors to stderr if write fails
+// logln writes a line to output like logf
 func logln(w io.Writer, msg string) {
-	if _, err := fmt.Fprintln(w, msg); err != nil {
+	if _, err := fmt.Fprintln(w, color.Line(w, msg)); err != nil {
 		log.Printf("failed to write output: %v", err)
 	}
 }
 
-// sortedKeys returns the keys of a config map in sorted order for deterministic behavior.
-func sortedKeys(m map[string]*config.Config) []string {
+// sortedKeys returns the keys of a map in sorted order for deterministic behavior.
+func sortedKeys[V any](m map[string]V) []string {
 	keys := make([]string, 0, len(m))
 	for k := range m {
 		keys = append(keys, k)
@@ -38,6 +49,23 @@ func sortedKeys(m map[string]*config.Config) []string {
 	return keys
 }
 
+// envFileNames returns the names CreateEnvFiles makes {name}.env for: every
+// service, plus the files their env_files share (common.env -> common),
+// sorted and without duplicates.
+func envFileNames(services map[string]*config.Config) []string {
+	names := make(map[string]bool, len(services))
+	for name, svc := range services {
+		names[name] = true
+		if svc == nil {
+			continue
+		}
+		for _, f := range svc.EnvFiles {
+			names[strings.TrimSuffix(f, ".env")] = true
+		}
+	}
+	return sortedKeys(names)
+}
+
 // Deployer defines the interface for deployment operations
 type Deployer interface {
 	GetCurrentVersion(ctx context.Context) (int, error)
@@ -47,6 +75,7 @@ type Deployer interface {
 	BuildImage(ctx context.Context, buildDir string, version int) error
 	UpdateManifest(ctx context.Context, version int) error
 	RestartStack(ctx context.Context) error
+	Down(ctx context.Context, removeVolumes bool) error
 	Cleanup(ctx context.Context, path string) error
 	StackExists(ctx context.Context) (bool, error)
 	CreateStack(ctx context.Context, content string) error
@@ -55,26 +84,230 @@ type Deployer interface {
 	UploadEnvFile(ctx context.Context, serviceName, localPath string) error
 	IsServiceRunning(ctx context.Context, serviceName string) (bool, error)
 	PullImage(ctx context.Context, image string) error
+	ImageDigest(ctx context.Context, image string) (string, error)
 	StartService(ctx context.Context, serviceName string) error
+	StopService(ctx context.Context, serviceName string) error
+	RunJob(ctx context.Context, serviceName string) error
+	WaitForHealthy(ctx context.Context, serviceName string, timeout, grace time.Duration) error
 	RolloutService(ctx context.Context, serviceName string) error
 	CopyFiles(ctx context.Context, files map[string]string) error
 }
 
+// ImageInspector is implemented by clients that can inspect an image on
+// the server. Deploys through clients without it (e.g. test doubles)
+// don't report image size.
+type ImageInspector interface {
+	ImageInfo(ctx context.Context, image string) (images.Info, error)
+}
+
+var _ ImageInspector = (*remote.Client)(nil)
+
+// DockerfileChecker is implemented by clients that can check a built
+// service's Dockerfile exists locally, resolved as the build will
+// resolve it. Deploys through clients without it skip the check.
+type DockerfileChecker interface {
+	CheckDockerfile() error
+}
+
+var _ DockerfileChecker = (*remote.Client)(nil)
+
+// reportImage records and prints the size of the image just built as
+// res.NewVersion. When it is the very image previousVersion has, every
+// layer came from the build cache and that is reported too. Inspect
+// failures only print a warning.
+func reportImage(ctx context.Context, inspector ImageInspector, cfg *config.Config, previousVersion int, res *Result, output io.Writer) {
+	tag := fmt.Sprintf("%s:%d", cfg.ImageName(), res.NewVersion)
+	info, err := inspector.ImageInfo(ctx, tag)
+	if err != nil {
+		logf(output, "    Warning: %v\n", err)
+		return
+	}
+	res.ImageSize = info.Size
+	if previousVersion > 0 && info.ID != "" {
+		prev, err := inspector.ImageInfo(ctx, fmt.Sprintf("%s:%d", cfg.ImageName(), previousVersion))
+		res.ImageCached = err == nil && prev.ID == info.ID
+	}
+	if res.ImageCached {
+		logf(output, "    Image %s: %s (fully cached, same image as version %d)\n", tag, images.FormatSize(info.Size), previousVersion)
+		return
+	}
+	logf(output, "    Image %s: %s\n", tag, images.FormatSize(info.Size))
+}
+
+// checkManaged refuses to go on when the stack's existing manifest was not
+// written by ssd, unless opts.Adopt is set. A manifest that can't be read
+// is left for the regeneration step to report.
+func checkManaged(ctx context.Context, rt string, client Deployer, cfg *config.Config, opts *Options, output io.Writer) error {
+	content, err := client.ReadManifest(ctx)
+	if err != nil || manifestManaged(rt, content, cfg.StackPath(), opts.AllServices) {
+		return nil
+	}
+	manifest := manifestName(rt, cfg)
+	if !opts.Adopt {
+		return fmt.Errorf("%s in %s was not written by ssd; refusing to overwrite it (run ssd adopt to take it over, or re-run with --adopt to replace it)", manifest, cfg.StackPath())
+	}
+	logf(output, "==> WARNING: %s in %s was not written by ssd; replacing it (--adopt)\n", manifest, cfg.StackPath())
+	return nil
+}
+
+// manifestManaged reports whether content is a manifest ssd generated:
+// compose files carry the x-ssd block, k3s manifests the managed-by
+// label. Compose files written before the marker existed are recognised
+// by an ssd-built image of one of services or the project's internal
+// network. Empty content has nothing to lose and counts as managed.
+func manifestManaged(rt, content, stack string, services map[string]*config.Config) bool {
+	if strings.TrimSpace(content) == "" {
+		return true
+	}
+	if rt == "k3s" {
+		return strings.Contains(content, "managed-by: ssd")
+	}
+	if compose.HasMetadata(content) {
+		return true
+	}
+
+	project := config.ProjectName(services, stack)
+	images := manifestImages(rt, content)
+	for name, svc := range services {
+		if strings.HasPrefix(images[name], config.RenderImageName(svc.ImageTemplate, project, name)+":") {
+			return true
+		}
+	}
+	var file struct {
+		Networks map[string]any `yaml:"networks"`
+	}
+	if err := yaml.Unmarshal([]byte(content), &file); err != nil {
+		return false
+	}
+	_, ok := file.Networks[project+"_internal"]
+	return ok
+}
+
 // parseServiceVersions extracts current version numbers from manifest content
 func parseServiceVersions(content, stack string, services map[string]*config.Config) map[string]int {
 	versions := make(map[string]int, len(services))
-	project := filepath.Base(stack)
+	project := config.ProjectName(services, stack)
 	for name, svc := range services {
 		if svc.IsPrebuilt() {
 			continue
 		}
-		imageName := fmt.Sprintf("ssd-%s-%s", project, name)
+		imageName := config.RenderImageName(svc.ImageTemplate, project, name)
 		v, _ := remote.ParseVersionFromContent(content, imageName)
 		versions[name] = v
 	}
 	return versions
 }
 
+// parsePinnedImages finds, for every service with deploy.pin_digest, the
+// digest reference its image is pinned to in the existing manifest, so
+// regenerating the manifest for another service keeps the pin.
+func parsePinnedImages(content string, services map[string]*config.Config) map[string]string {
+	pins := make(map[string]string)
+	for name, svc := range services {
+		if !svc.PinDigest() {
+			continue
+		}
+		re := regexp.MustCompile(`image:\s*["']?(` + regexp.QuoteMeta(imageRepo(svc.Image)) + `@sha(?:256|512):[a-f0-9]+)`)
+		if m := re.FindStringSubmatch(content); m != nil {
+			pins[name] = m[1]
+		}
+	}
+	return pins
+}
+
+// parseExternalImages finds built services whose manifest entry runs an
+// image other than their ssd-built tag (left by deploy --image), so
+// regenerating the manifest for another service keeps that image.
+func parseExternalImages(rt, content, stack string, services map[string]*config.Config) map[string]string {
+	external := make(map[string]string)
+	project := config.ProjectName(services, stack)
+	images := manifestImages(rt, content)
+	for name, svc := range services {
+		if svc.IsPrebuilt() {
+			continue
+		}
+		image := images[name]
+		if image != "" && !strings.HasPrefix(image, config.RenderImageName(svc.ImageTemplate, project, name)+":") {
+			external[name] = image
+		}
+	}
+	return external
+}
+
+// manifestImages returns the image each service runs in a generated
+// manifest: services.<name>.image for compose, the first container of
+// each Deployment for k3s. Unparseable content yields no images.
+func manifestImages(rt, content string) map[string]string {
+	images := make(map[string]string)
+	if rt != "k3s" {
+		var file struct {
+			Services map[string]struct {
+				Image string `yaml:"image"`
+			} `yaml:"services"`
+		}
+		if err := yaml.Unmarshal([]byte(content), &file); err != nil {
+			return images
+		}
+		for name, svc := range file.Services {
+			images[name] = svc.Image
+		}
+		return images
+	}
+
+	dec := yaml.NewDecoder(strings.NewReader(content))
+	for {
+		var doc struct {
+			Kind     string `yaml:"kind"`
+			Metadata struct {
+				Name string `yaml:"name"`
+			} `yaml:"metadata"`
+			Spec struct {
+				Template struct {
+					Spec struct {
+						Containers []struct {
+							Image string `yaml:"image"`
+						} `yaml:"containers"`
+					} `yaml:"spec"`
+				} `yaml:"template"`
+			} `yaml:"spec"`
+		}
+		if err := dec.Decode(&doc); err != nil {
+			return images
+		}
+		if doc.Kind == "Deployment" && len(doc.Spec.Template.Spec.Containers) > 0 {
+			images[doc.Metadata.Name] = doc.Spec.Template.Spec.Containers[0].Image
+		}
+	}
+}
+
+// imageRepo strips the tag from an image reference: "nginx:1.27" and
+// "registry:5000/app:2" become "nginx" and "registry:5000/app".
+func imageRepo(image string) string {
+	slash := strings.LastIndex(image, "/")
+	if colon := strings.LastIndex(image, ":"); colon > slash {
+		return image[:colon]
+	}
+	return image
+}
+
+// withImages returns services with the given services' images replaced,
+// copying the changed configs so the caller's map is left untouched.
+func withImages(services map[string]*config.Config, images map[string]string) map[string]*config.Config {
+	if len(images) == 0 {
+		return services
+	}
+	out := make(map[string]*config.Config, len(services))
+	for name, svc := range services {
+		if image, ok := images[name]; ok {
+			c := *svc
+			c.Image = image
+			svc = &c
+		}
+		out[name] = svc
+	}
+	return out
+}
+
 // TagCleaner is the narrow surface DeployWithClient needs for post-deploy
 // image tag cleanup. The full cleanup.ImageCleaner interface is wider; we
 // only consume the orchestration entry point here to keep test seams small.
@@ -82,6 +315,26 @@ type TagCleaner interface {
 	PruneOldTags(ctx context.Context, image string, retention, running int) error
 }
 
+// Scheduler installs or removes the service's scheduled runs on the server.
+type Scheduler interface {
+	SyncSchedule(ctx context.Context) error
+}
+
+// HistoryRecorder records successful deploys in the server-side audit log.
+type HistoryRecorder interface {
+	AppendHistory(ctx context.Context, serviceName string, version int) error
+}
+
+// SourceTracker reads the build context's git state and the tree
+// recorded on the server by the last deploy, so unchanged deploys can be
+// skipped and uncommitted changes reported.
+type SourceTracker interface {
+	SourceTree(ctx context.Context) (string, error)
+	UncommittedChanges(ctx context.Context) ([]string, error)
+	DeployedSourceTree(ctx context.Context, serviceName string) (string, error)
+	RecordSourceTree(ctx context.Context, serviceName, tree string) error
+}
+
 // Options holds configuration for the deployment
 type Options struct {
 	// Output is where to write progress messages (defaults to os.Stdout)
@@ -93,6 +346,20 @@ type Options struct {
 	// BuildOnly builds/pulls the image and updates the manifest but does not start the service.
 	// Used by deploy-all: build everything first, then start all services at once.
 	BuildOnly bool
+	// NoDeps skips the dependency check and auto-start, as BuildOnly does,
+	// for dependencies managed out-of-band.
+	NoDeps bool
+	// RecreateDeps starts every dependency, pulling pre-built images
+	// first, even when it is already running, so a changed env or image
+	// takes effect. By default running dependencies are left alone.
+	RecreateDeps bool
+	// SSDVersion is the version of ssd doing the deploy, recorded in the
+	// compose file's x-ssd block.
+	SSDVersion string
+	// SkipBuild restarts the service on its current version: nothing is
+	// synced, built or pulled, but the manifest is still regenerated from
+	// config. The service must have been deployed before.
+	SkipBuild bool
 	// Runtime is the deployment runtime ("compose" or "k3s")
 	Runtime string
 	// TagCleaner, if set, is invoked after a successful rollout to prune
@@ -100,22 +367,106 @@ type Options struct {
 	// never fails because cleanup failed. Pre-built images and BuildOnly
 	// mode skip the hook entirely.
 	TagCleaner TagCleaner
+	// History, if set, is told about every successful (non-BuildOnly)
+	// deploy. Failures are warn-only.
+	History HistoryRecorder
+	// Scheduler, if set, brings the service's timer in line with its
+	// schedule after it is started (or, for restart: false, written).
+	Scheduler Scheduler
+	// LockTimeout bounds how long to wait for the local and remote
+	// deployment locks. Zero means the default of 5 minutes.
+	LockTimeout time.Duration
+	// SeedEnvFiles maps service names to local dotenv files that become
+	// their {service}.env when this deploy creates the stack. Ignored
+	// once the stack exists, so values managed on the server survive.
+	SeedEnvFiles map[string]string
+	// Sources, if set, lets services with deploy.skip_unchanged skip the
+	// build and start when their source tree matches the last deploy's.
+	// The tree is recorded after every successful (non-BuildOnly) deploy.
+	// Built services are also checked for uncommitted changes first.
+	Sources SourceTracker
+	// HealthWait chooses whether the deploy waits for the started service
+	// to become healthy (see AwaitHealthy). The zero value runs the
+	// configured health gate.
+	HealthWait HealthWait
+	// KeepBuildDir leaves the server-side build directory in place after
+	// the deploy, successful or not, and prints its path for debugging.
+	KeepBuildDir bool
+	// ForceVersion, when positive, is deployed instead of current+1: the
+	// image is built and the manifest updated with this tag, overwriting
+	// any existing image with it. Also bypasses deploy.skip_unchanged.
+	ForceVersion int
+	// Adopt lets the deploy replace an existing manifest that ssd did not
+	// write (see manifestManaged). Without it such a deploy is refused.
+	Adopt bool
+	// Force (deploy --force) builds and starts the service even when
+	// deploy.skip_unchanged finds its source unchanged.
+	Force bool
+	// Strict (deploy --strict) fails the deploy on uncommitted changes in
+	// the build context instead of warning about them.
+	Strict bool
+	// ImageOverride (deploy --image) means cfg.Image was supplied for this
+	// run only, so the deploy neither builds nor bumps the version.
+	ImageOverride bool
+	// Run holds the remaining deploy flags of this run (--pull,
+	// --quiet-build, --build-arg, --force-recreate). They are handed to a
+	// client implementing remote.RunConfigurer before the deploy starts.
+	Run remote.RunOptions
 }
 
+// HealthWait controls what a deploy does once the service is started.
+type HealthWait int
+
+const (
+	// WaitDefault runs the health gate when deploy.health_gate enables it.
+	WaitDefault HealthWait = iota
+	// WaitHealthy (--wait) always waits for the service to become healthy
+	// and fails the deploy if it doesn't.
+	WaitHealthy
+	// WaitNone (--detach) returns as soon as the service is started.
+	WaitNone
+)
+
 // generateManifest calls the appropriate manifest generator based on runtime.
-func generateManifest(runtime string, services map[string]*config.Config, stack string, versions map[string]int) (string, error) {
+// meta fills compose's x-ssd block; k3s manifests don't carry one.
+func generateManifest(runtime string, services map[string]*config.Config, stack string, versions map[string]int, meta compose.Metadata) (string, error) {
 	if runtime == "k3s" {
 		return k8s.GenerateManifests(services, stack, versions)
 	}
-	return compose.GenerateCompose(services, stack, versions)
+	return compose.GenerateComposeWithMetadata(services, stack, versions, meta)
 }
 
-// manifestName returns the filename for the current runtime.
-func manifestName(runtime string) string {
+// manifestClock stamps generated-at in the x-ssd block. Tests swap in a
+// clock.Fake.
+var manifestClock clock.Clock = clock.Real{}
+
+// deployMetadata returns the x-ssd block a deploy writes: stamped with the
+// time and opts.SSDVersion, and keeping the source SHAs recorded in the
+// existing manifest for built services still in services.
+func deployMetadata(existing string, services map[string]*config.Config, opts *Options) compose.Metadata {
+	meta := compose.Metadata{GeneratedAt: manifestClock.Now().UTC().Format(time.RFC3339)}
+	if opts != nil {
+		meta.SSDVersion = opts.SSDVersion
+	}
+	prev, _ := compose.ParseMetadata(existing)
+	for name, tree := range prev.Sources {
+		if svc, ok := services[name]; ok && !svc.IsPrebuilt() {
+			if meta.Sources == nil {
+				meta.Sources = make(map[string]string)
+			}
+			meta.Sources[name] = tree
+		}
+	}
+	return meta
+}
+
+// manifestName returns the manifest filename for the current runtime:
+// manifests.yaml for k3s, otherwise the stack's compose filename.
+func manifestName(runtime string, cfg *config.Config) string {
 	if runtime == "k3s" {
 		return "manifests.yaml"
 	}
-	return "compose.yaml"
+	return cfg.ComposeFilename()
 }
 
 // uploadEnvFiles pushes any service's env_file to {stack}/{service}.env on
@@ -135,9 +486,34 @@ func uploadEnvFiles(ctx context.Context, client Deployer, services map[string]*c
 	return nil
 }
 
+// seedEnvFiles uploads each seed file as its service's env file, in
+// service name order.
+func seedEnvFiles(ctx context.Context, client Deployer, seeds map[string]string) error {
+	for _, name := range sortedKeys(seeds) {
+		if err := client.UploadEnvFile(ctx, name, seeds[name]); err != nil {
+			return fmt.Errorf("failed to seed env file for %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
 // DeployWithClient performs a deployment with a custom client
 func DeployWithClient(cfg *config.Config, client Deployer, opts *Options) error {
+	_, err := DeployWithResult(cfg, client, opts)
+	return err
+}
+
+// DeployWithResult performs a deployment like DeployWithClient and also
+// reports the version transition, strategy and elapsed time. The Result
+// is populated as far as the deploy got, even when it fails.
+func DeployWithResult(cfg *config.Config, client Deployer, opts *Options) (res Result, err error) {
 	ctx := context.Background()
+	start := time.Now()
+	res = Result{Service: cfg.Name, Strategy: cfg.DeployStrategy()}
+	defer func() {
+		res.Duration = time.Since(start)
+		res.Err = err
+	}()
 
 	// Default output to discarding if nil (for cleaner test output)
 	output := io.Discard
@@ -145,22 +521,63 @@ func DeployWithClient(cfg *config.Config, client Deployer, opts *Options) error
 		output = opts.Output
 	}
 
+	// Report the deploy's phases to the events sink, if configured
+	events := newEventSink(cfg, output)
+	events.emit(EventStart, 0, nil)
+	defer func() {
+		if err != nil {
+			events.emit(EventFailed, res.NewVersion, err)
+		}
+	}()
+
 	rt := "compose"
 	if opts != nil && opts.Runtime != "" {
 		rt = opts.Runtime
 	}
 
-	// Acquire deployment lock
-	unlock, err := acquireLock(cfg.StackPath())
+	// With SkipBuild nothing is synced or built, so the source and
+	// Dockerfile don't matter
+	skipBuild := opts != nil && opts.SkipBuild
+	if opts != nil && opts.Sources != nil && !cfg.IsPrebuilt() && !skipBuild {
+		if err := checkUncommitted(ctx, opts.Sources, cfg, opts.Strict, output); err != nil {
+			return res, err
+		}
+	}
+
+	// Hand this run's build and start flags to the client
+	if rc, ok := client.(remote.RunConfigurer); ok && opts != nil {
+		rc.SetRunOptions(opts.Run)
+	}
+
+	// Catch a mistyped dockerfile now rather than when the remote build
+	// fails after the sync
+	if checker, ok := client.(DockerfileChecker); ok && !cfg.IsPrebuilt() && !skipBuild {
+		if err := checker.CheckDockerfile(); err != nil {
+			return res, err
+		}
+	}
+
+	// Acquire local and remote deployment locks: the service's for the
+	// whole deploy, the stack's only while shared stack state changes, so
+	// other services in the stack can build in the meantime
+	unlockService, err := lockService(ctx, cfg, client, opts)
 	if err != nil {
-		return fmt.Errorf("failed to acquire deployment lock: %w", err)
+		return res, err
 	}
-	defer unlock()
+	defer unlockService()
+	unlockStack, err := lockStack(ctx, cfg, client, opts)
+	if err != nil {
+		return res, err
+	}
+	defer unlockStack()
 
 	// Check if stack exists, create if needed
 	stackExists, err := client.StackExists(ctx)
 	if err != nil {
-		return fmt.Errorf("failed to check stack existence: %w", err)
+		return res, fmt.Errorf("failed to check stack existence: %w", err)
+	}
+	if !stackExists && skipBuild {
+		return res, errNotDeployed(cfg)
 	}
 
 	if !stackExists {
@@ -175,25 +592,31 @@ func DeployWithClient(cfg *config.Config, client Deployer, opts *Options) error
 			services = opts.AllServices
 		}
 
-		manifest := manifestName(rt)
+		manifest := manifestName(rt, cfg)
 		logf(output, "    Generating %s...\n", manifest)
 		versions := make(map[string]int, len(services))
-		manifestContent, err := generateManifest(rt, services, cfg.StackPath(), versions)
+		manifestContent, err := generateManifest(rt, services, cfg.StackPath(), versions, deployMetadata("", services, opts))
 		if err != nil {
-			return fmt.Errorf("failed to generate %s: %w", manifest, err)
+			return res, fmt.Errorf("failed to generate %s: %w", manifest, err)
 		}
 
 		// Create env files BEFORE CreateStack — compose validates env_file
 		// paths exist; K3s needs them for ConfigMap population
-		envNames := sortedKeys(services)
+		envNames := envFileNames(services)
 		logln(output, "    Creating env files...")
 		if err := client.CreateEnvFiles(ctx, envNames); err != nil {
-			return fmt.Errorf("failed to create env files: %w", err)
+			return res, fmt.Errorf("failed to create env files: %w", err)
+		}
+		if opts != nil && len(opts.SeedEnvFiles) > 0 {
+			logln(output, "    Seeding env files...")
+			if err := seedEnvFiles(ctx, client, opts.SeedEnvFiles); err != nil {
+				return res, err
+			}
 		}
 
 		logf(output, "    Validating %s...\n", manifest)
 		if err := client.CreateStack(ctx, manifestContent); err != nil {
-			return fmt.Errorf("failed to create stack: %w", err)
+			return res, fmt.Errorf("failed to create stack: %w", err)
 		}
 
 		// Networks are compose-only; K3s uses K8s Services for networking
@@ -209,63 +632,126 @@ func DeployWithClient(cfg *config.Config, client Deployer, opts *Options) error
 			}
 			if needsTraefik {
 				if err := client.EnsureNetwork(ctx, "traefik_web"); err != nil {
-					return fmt.Errorf("failed to ensure network traefik_web: %w", err)
+					return res, fmt.Errorf("failed to ensure network traefik_web: %w", err)
 				}
 			}
 
-			project := filepath.Base(cfg.StackPath())
+			project := config.ProjectName(services, cfg.StackPath())
 			internalNetwork := project + "_internal"
 			if err := client.EnsureNetwork(ctx, internalNetwork); err != nil {
-				return fmt.Errorf("failed to ensure network %s: %w", internalNetwork, err)
+				return res, fmt.Errorf("failed to ensure network %s: %w", internalNetwork, err)
 			}
 		}
 
 		logln(output, "    Stack created successfully")
+	} else if opts != nil && len(opts.AllServices) > 0 {
+		// The manifest is about to be regenerated; don't clobber one
+		// someone wrote by hand
+		if err := checkManaged(ctx, rt, client, cfg, opts, output); err != nil {
+			return res, err
+		}
 	}
 
 	// Copy config files to the stack directory (every deploy, not just first)
 	if len(cfg.Files) > 0 {
 		logln(output, "==> Copying config files...")
 		if err := client.CopyFiles(ctx, cfg.Files); err != nil {
-			return fmt.Errorf("failed to copy config files: %w", err)
+			return res, fmt.Errorf("failed to copy config files: %w", err)
 		}
 	}
+	unlockStack()
 
 	// Get current version
 	currentVersion, err := client.GetCurrentVersion(ctx)
 	if err != nil {
-		return fmt.Errorf("failed to get current version: %w", err)
+		return res, fmt.Errorf("failed to get current version: %w", err)
+	}
+	if skipBuild && currentVersion == 0 && !cfg.IsPrebuilt() {
+		return res, errNotDeployed(cfg)
+	}
+
+	forceVersion := 0
+	if opts != nil {
+		forceVersion = opts.ForceVersion
+	}
+	if forceVersion < 0 {
+		return res, fmt.Errorf("invalid forced version %d: must be at least 1", forceVersion)
+	}
+
+	// Skip the deploy entirely when the source is unchanged since the last one
+	if opts != nil && opts.Sources != nil && cfg.SkipUnchanged() && !skipBuild {
+		res.ConfigDigest = ConfigDigest(cfg, opts.Run.BuildArgs)
+		tree, unchanged := checkSource(ctx, opts.Sources, cfg, res.ConfigDigest, output)
+		res.SourceTree = tree
+		if unchanged && currentVersion > 0 && !opts.Force && forceVersion == 0 && len(opts.Run.BuildArgs) == 0 {
+			res.OldVersion, res.NewVersion = currentVersion, currentVersion
+			res.Unchanged = true
+			logf(output, "==> %s unchanged since version %d, skipping (use --force to deploy anyway)\n", cfg.Name, currentVersion)
+			return res, nil
+		}
 	}
 
 	newVersion := currentVersion + 1
-	logf(output, "==> Version: %d -> %d\n", currentVersion, newVersion)
+	switch {
+	case forceVersion > 0:
+		newVersion = forceVersion
+		logf(output, "==> WARNING: forcing version %d (current %d)\n", newVersion, currentVersion)
+		if !cfg.IsPrebuilt() {
+			logf(output, "==> WARNING: an existing %s:%d image is overwritten\n", cfg.ImageName(), newVersion)
+		}
+		if newVersion <= currentVersion {
+			logf(output, "==> WARNING: version %d is not newer than %d; the next deploy continues from %d\n", newVersion, currentVersion, newVersion+1)
+		}
+	case skipBuild:
+		newVersion = currentVersion
+		logf(output, "==> Version: %d (--skip-build keeps the current image)\n", currentVersion)
+	case opts != nil && opts.ImageOverride:
+		newVersion = currentVersion
+		logf(output, "==> Image: %s (version stays %d)\n", cfg.Image, currentVersion)
+	default:
+		logf(output, "==> Version: %d -> %d\n", currentVersion, newVersion)
+	}
+	res.OldVersion, res.NewVersion = currentVersion, newVersion
 
-	// Check and start dependencies if needed (skip in BuildOnly mode)
+	// Check and start dependencies if needed (skip in BuildOnly mode and
+	// with NoDeps)
 	buildOnly := opts != nil && opts.BuildOnly
+	noDeps := opts != nil && opts.NoDeps
+	recreateDeps := opts != nil && opts.RecreateDeps
 	depNames := cfg.DependsOn.Names()
-	if !buildOnly && len(depNames) > 0 {
+	if !buildOnly && !noDeps && len(depNames) > 0 {
 		logln(output, "==> Checking dependencies...")
 		for _, dep := range depNames {
-			running, err := client.IsServiceRunning(ctx, dep)
-			if err != nil {
-				return fmt.Errorf("failed to check if dependency %s is running: %w", dep, err)
+			if depCfg := dependencyConfig(opts, dep); depCfg != nil && depCfg.ManualStart() {
+				logf(output, "    %s: restart: false, not started\n", dep)
+				continue
+			}
+			running := false
+			if !recreateDeps {
+				if running, err = client.IsServiceRunning(ctx, dep); err != nil {
+					return res, fmt.Errorf("failed to check if dependency %s is running: %w", dep, err)
+				}
 			}
 
 			if !running {
-				logf(output, "    Starting %s...\n", dep)
+				if recreateDeps {
+					logf(output, "    Recreating %s (--recreate-deps)...\n", dep)
+				} else {
+					logf(output, "    Starting %s...\n", dep)
+				}
 
 				// Check if dependency is pre-built and needs image pull
 				if opts != nil && opts.Dependencies != nil {
 					if depCfg, exists := opts.Dependencies[dep]; exists && depCfg.IsPrebuilt() {
 						logf(output, "    Pulling image %s...\n", depCfg.Image)
 						if err := client.PullImage(ctx, depCfg.Image); err != nil {
-							return fmt.Errorf("failed to pull image for dependency %s: %w", dep, err)
+							return res, fmt.Errorf("failed to pull image for dependency %s: %w", dep, err)
 						}
 					}
 				}
 
 				if err := client.StartService(ctx, dep); err != nil {
-					return fmt.Errorf("failed to start dependency %s: %w", dep, err)
+					return res, fmt.Errorf("failed to start dependency %s: %w", dep, err)
 				}
 			} else {
 				logf(output, "    %s: running\n", dep)
@@ -273,65 +759,109 @@ func DeployWithClient(cfg *config.Config, client Deployer, opts *Options) error
 		}
 	}
 
-	// Create temp directory on server
-	tempDir, err := client.MakeTempDir(ctx)
-	if err != nil {
-		return fmt.Errorf("failed to create temp directory: %w", err)
-	}
-	defer func() {
-		if cleanupErr := client.Cleanup(ctx, tempDir); cleanupErr != nil {
-			log.Printf("failed to cleanup temp directory: %v", cleanupErr)
-		}
-	}()
-
-	// Check if this is a pre-built image
-	if cfg.IsPrebuilt() {
-		logf(output, "==> Pulling image %s...\n", cfg.Image)
-		if err := client.PullImage(ctx, cfg.Image); err != nil {
-			return fmt.Errorf("failed to pull image: %w", err)
-		}
+	pinned := ""
+	if skipBuild {
+		logln(output, "==> Skipping build (--skip-build)")
 	} else {
-		logf(output, "==> Syncing code to %s...\n", cfg.Server)
-		localContext, err := filepath.Abs(cfg.Context)
+		// Create temp directory on server
+		tempDir, err := client.MakeTempDir(ctx)
 		if err != nil {
-			return fmt.Errorf("failed to resolve context path: %w", err)
-		}
-		if err := client.Rsync(ctx, localContext, tempDir); err != nil {
-			return fmt.Errorf("failed to sync code: %w", err)
+			return res, fmt.Errorf("failed to create temp directory: %w", err)
 		}
+		defer func() {
+			if opts != nil && opts.KeepBuildDir {
+				logf(output, "    Kept build directory %s:%s (--keep-build-dir)\n", cfg.Server, tempDir)
+				return
+			}
+			if cleanupErr := client.Cleanup(ctx, tempDir); cleanupErr != nil {
+				log.Printf("failed to cleanup temp directory: %v", cleanupErr)
+			}
+		}()
+
+		// Check if this is a pre-built image
+		if cfg.IsPrebuilt() {
+			logf(output, "==> Pulling image %s...\n", cfg.Image)
+			if err := client.PullImage(ctx, cfg.Image); err != nil {
+				return res, fmt.Errorf("failed to pull image: %w", err)
+			}
+			if cfg.PinDigest() {
+				if pinned, err = client.ImageDigest(ctx, cfg.Image); err != nil {
+					return res, fmt.Errorf("failed to resolve digest: %w", err)
+				}
+				logf(output, "    Pinned %s\n", pinned)
+			}
+		} else {
+			logf(output, "==> Syncing code to %s...\n", cfg.Server)
+			localContext, err := filepath.Abs(cfg.Context)
+			if err != nil {
+				return res, fmt.Errorf("failed to resolve context path: %w", err)
+			}
+			if err := client.Rsync(ctx, localContext, tempDir); err != nil {
+				return res, fmt.Errorf("failed to sync code: %w", err)
+			}
 
-		logf(output, "==> Building image %s:%d...\n", cfg.ImageName(), newVersion)
-		if err := client.BuildImage(ctx, tempDir, newVersion); err != nil {
-			return fmt.Errorf("failed to build image: %w", err)
+			logf(output, "==> Building image %s:%d...\n", cfg.ImageName(), newVersion)
+			if err := client.BuildImage(ctx, tempDir, newVersion); err != nil {
+				return res, fmt.Errorf("failed to build image: %w", err)
+			}
+			if inspector, ok := client.(ImageInspector); ok {
+				reportImage(ctx, inspector, cfg, currentVersion, &res, output)
+			}
 		}
 	}
+	events.emit(EventBuildDone, newVersion, nil)
+
+	// From here on the manifest is written and services started
+	relockStack, err := lockStack(ctx, cfg, client, opts)
+	if err != nil {
+		return res, err
+	}
+	defer relockStack()
 
 	// Update manifest: regenerate from config when all services are known,
 	// otherwise fall back to regex replacement for the deployed service only
-	manifest := manifestName(rt)
+	manifest := manifestName(rt, cfg)
 	if opts != nil && len(opts.AllServices) > 0 {
 		logf(output, "==> Updating %s...\n", manifest)
 		existingManifest, _ := client.ReadManifest(ctx)
 		currentVersions := parseServiceVersions(existingManifest, cfg.StackPath(), opts.AllServices)
 		currentVersions[cfg.Name] = newVersion
 
-		newManifest, err := generateManifest(rt, opts.AllServices, cfg.StackPath(), currentVersions)
+		pins := parseExternalImages(rt, existingManifest, cfg.StackPath(), opts.AllServices)
+		delete(pins, cfg.Name)
+		maps.Copy(pins, parsePinnedImages(existingManifest, opts.AllServices))
+		if pinned != "" {
+			pins[cfg.Name] = pinned
+		}
+		// Record the source tree the new image was built from; a skipped
+		// build keeps the recorded one
+		meta := deployMetadata(existingManifest, opts.AllServices, opts)
+		if !skipBuild {
+			delete(meta.Sources, cfg.Name)
+			if tree := builtSourceTree(ctx, rt, cfg, opts, res.SourceTree); tree != "" {
+				if meta.Sources == nil {
+					meta.Sources = make(map[string]string)
+				}
+				meta.Sources[cfg.Name] = tree
+			}
+		}
+		newManifest, err := generateManifest(rt, withImages(opts.AllServices, pins), cfg.StackPath(), currentVersions, meta)
 		if err != nil {
-			return fmt.Errorf("failed to generate %s: %w", manifest, err)
+			return res, fmt.Errorf("failed to generate %s: %w", manifest, err)
 		}
 
-		envNames := sortedKeys(opts.AllServices)
+		envNames := envFileNames(opts.AllServices)
 		if err := client.CreateEnvFiles(ctx, envNames); err != nil {
-			return fmt.Errorf("failed to create env files: %w", err)
+			return res, fmt.Errorf("failed to create env files: %w", err)
 		}
 
 		if err := client.CreateStack(ctx, newManifest); err != nil {
-			return fmt.Errorf("failed to update %s: %w", manifest, err)
+			return res, fmt.Errorf("failed to update %s: %w", manifest, err)
 		}
 	} else if !cfg.IsPrebuilt() {
 		logf(output, "==> Updating %s...\n", manifest)
 		if err := client.UpdateManifest(ctx, newVersion); err != nil {
-			return fmt.Errorf("failed to update %s: %w", manifest, err)
+			return res, fmt.Errorf("failed to update %s: %w", manifest, err)
 		}
 	}
 
@@ -342,24 +872,47 @@ func DeployWithClient(cfg *config.Config, client Deployer, opts *Options) error
 		services = opts.AllServices
 	}
 	if err := uploadEnvFiles(ctx, client, services); err != nil {
-		return err
+		return res, err
 	}
 
 	// In BuildOnly mode, skip starting — caller will start all services at once
 	if buildOnly {
+		res.Strategy = "build-only"
 		logf(output, "    Built %s version %d\n", cfg.Name, newVersion)
-		return nil
+		return res, nil
 	}
 
-	logf(output, "==> Starting service %s (strategy: %s)...\n", cfg.Name, cfg.DeployStrategy())
-	switch cfg.DeployStrategy() {
-	case "rollout":
-		if err := client.RolloutService(ctx, cfg.Name); err != nil {
-			return fmt.Errorf("failed to rollout service: %w", err)
+	if cfg.ManualStart() {
+		res.Strategy = "manual"
+		logf(output, "==> Not starting %s (restart: false)\n", cfg.Name)
+	} else {
+		if err := PreStart(ctx, client, cfg, currentVersion, output); err != nil {
+			return res, err
 		}
-	default:
-		if err := client.StartService(ctx, cfg.Name); err != nil {
-			return fmt.Errorf("failed to start service: %w", err)
+
+		logf(output, "==> Starting service %s (strategy: %s)...\n", cfg.Name, cfg.DeployStrategy())
+		if err := Start(ctx, client, cfg); err != nil {
+			return res, err
+		}
+
+		healthWait := WaitDefault
+		if opts != nil {
+			healthWait = opts.HealthWait
+		}
+		if err := AwaitHealthy(ctx, client, cfg, currentVersion, healthWait, output); err != nil {
+			return res, err
+		}
+		if err := SmokeTest(ctx, client, cfg, currentVersion, healthWait, output); err != nil {
+			return res, err
+		}
+	}
+
+	if opts != nil && opts.Scheduler != nil {
+		if cfg.Schedule != "" {
+			logf(output, "==> Scheduling %s (%s)...\n", cfg.Name, cfg.Schedule)
+		}
+		if err := opts.Scheduler.SyncSchedule(ctx); err != nil {
+			return res, fmt.Errorf("failed to sync schedule: %w", err)
 		}
 	}
 
@@ -372,10 +925,241 @@ func DeployWithClient(cfg *config.Config, client Deployer, opts *Options) error
 		}
 	}
 
+	if opts != nil && opts.History != nil {
+		if err := opts.History.AppendHistory(ctx, cfg.Name, newVersion); err != nil {
+			logf(output, "Warning: failed to record deploy history: %v\n", err)
+		}
+	}
+
+	if opts != nil && opts.Sources != nil && res.SourceTree != "" {
+		if err := opts.Sources.RecordSourceTree(ctx, cfg.Name, res.SourceRecord()); err != nil {
+			logf(output, "Warning: failed to record source tree: %v\n", err)
+		}
+	}
+
+	events.emit(EventPromoted, newVersion, nil)
 	logf(output, "\nDeployed %s version %d successfully!\n", cfg.Name, newVersion)
+	res.Duration = time.Since(start)
+	logf(output, "    %s\n", res.Summary())
+	return res, nil
+}
+
+// builtSourceTree returns the git tree a built compose service's image was
+// made from: known, as read by the skip_unchanged check, or asked of
+// opts.Sources. It is "" for pre-built images, k3s (whose manifests carry
+// no x-ssd block) and when the tree can't be read.
+func builtSourceTree(ctx context.Context, rt string, cfg *config.Config, opts *Options, known string) string {
+	if rt == "k3s" || cfg.IsPrebuilt() {
+		return ""
+	}
+	if known != "" || opts == nil || opts.Sources == nil {
+		return known
+	}
+	tree, err := opts.Sources.SourceTree(ctx)
+	if err != nil {
+		return ""
+	}
+	return tree
+}
+
+// errNotDeployed is returned by a SkipBuild deploy of a service that has
+// no image on the server to restart yet.
+func errNotDeployed(cfg *config.Config) error {
+	return fmt.Errorf("%s has not been deployed yet, so there is no image to restart; deploy it once without --skip-build", cfg.Name)
+}
+
+// dependencyConfig looks up a dependency's config in opts.Dependencies,
+// then opts.AllServices. Returns nil when neither has it.
+func dependencyConfig(opts *Options, name string) *config.Config {
+	if opts == nil {
+		return nil
+	}
+	if dep, ok := opts.Dependencies[name]; ok {
+		return dep
+	}
+	return opts.AllServices[name]
+}
+
+// checkSource returns the build context's git tree and whether it, and
+// the config digest, match what the last deploy recorded (see
+// Result.SourceRecord). A change to either means a build.
+func checkSource(ctx context.Context, sources SourceTracker, cfg *config.Config, digest string, output io.Writer) (string, bool) {
+	tree, err := sources.SourceTree(ctx)
+	if err != nil {
+		logf(output, "Warning: cannot read source tree, deploying anyway: %v\n", err)
+		return "", false
+	}
+	deployed, err := sources.DeployedSourceTree(ctx, cfg.Name)
+	if err != nil {
+		logf(output, "Warning: cannot read deployed source tree, deploying anyway: %v\n", err)
+		return tree, false
+	}
+	recorded := Result{SourceTree: tree, ConfigDigest: digest}.SourceRecord()
+	return tree, deployed != "" && deployed == recorded
+}
+
+// ConfigDigest returns a SHA-256 of cfg as resolved from ssd.yaml
+// (dockerfile, target, platform, build, ports, volumes and the rest) with
+// extra (deploy --build-arg) merged into its build args. Unlike the
+// source tree it changes when the config does, so a skip_unchanged
+// deploy still rebuilds and restarts for a config-only edit.
+func ConfigDigest(cfg *config.Config, extra map[string]string) string {
+	data, err := yaml.Marshal(struct {
+		Service   *config.Config    `yaml:"service"`
+		BuildArgs map[string]string `yaml:"build_args"`
+	}{cfg, cfg.ResolvedBuildArgs(extra)})
+	if err != nil {
+		// Never matches a recorded digest, so the deploy goes ahead
+		return ""
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// maxListedChanges caps how many uncommitted changes the warning lists.
+const maxListedChanges = 10
+
+// checkUncommitted warns that uncommitted and untracked changes in the
+// build context won't be deployed, since only HEAD is shipped. With
+// strict they fail the deploy instead. A failure to read the git state is
+// warn-only; Rsync reports a missing repository anyway.
+func checkUncommitted(ctx context.Context, sources SourceTracker, cfg *config.Config, strict bool, output io.Writer) error {
+	changes, err := sources.UncommittedChanges(ctx)
+	if err != nil {
+		logf(output, "Warning: cannot check for uncommitted changes: %v\n", err)
+		return nil
+	}
+	if len(changes) == 0 {
+		return nil
+	}
+	if strict {
+		return fmt.Errorf("%d uncommitted change(s) in %s would not be deployed; commit them or drop --strict", len(changes), cfg.Context)
+	}
+	logf(output, "Warning: %d uncommitted change(s) in %s won't be deployed (only committed files are shipped):\n", len(changes), cfg.Context)
+	for i, c := range changes {
+		if i == maxListedChanges {
+			logf(output, "    ... and %d more\n", len(changes)-maxListedChanges)
+			break
+		}
+		logf(output, "    %s\n", c)
+	}
 	return nil
 }
 
+// Start starts the service's new version using its deploy strategy:
+// "rollout" goes through RolloutService (docker rollout / RollingUpdate),
+// anything else recreates it with StartService. Both single-service
+// deploys and deploy-all start services through here.
+func Start(ctx context.Context, client Deployer, cfg *config.Config) error {
+	if cfg.DeployStrategy() == "rollout" {
+		if err := client.RolloutService(ctx, cfg.Name); err != nil {
+			return fmt.Errorf("failed to rollout service: %w", err)
+		}
+		return nil
+	}
+	if err := client.StartService(ctx, cfg.Name); err != nil {
+		return fmt.Errorf("failed to start service: %w", err)
+	}
+	return nil
+}
+
+// PreStart runs the service's pre_start job, if it has one, and waits for
+// it to finish. When the job fails the manifest is pointed back at
+// previousVersion so a later restart doesn't pick up the new image, and
+// the error is returned; the running service is left untouched.
+func PreStart(ctx context.Context, client Deployer, cfg *config.Config, previousVersion int, output io.Writer) error {
+	if cfg.PreStart == nil {
+		return nil
+	}
+
+	logf(output, "==> Running pre_start job for %s...\n", cfg.Name)
+	jobErr := client.RunJob(ctx, cfg.Name)
+	if jobErr == nil {
+		return nil
+	}
+
+	if cfg.IsPrebuilt() || previousVersion < 1 {
+		return jobErr
+	}
+	if err := client.UpdateManifest(ctx, previousVersion); err != nil {
+		return fmt.Errorf("%w; failed to restore manifest to version %d: %v", jobErr, previousVersion, err)
+	}
+	return fmt.Errorf("%w; manifest restored to version %d, service not restarted", jobErr, previousVersion)
+}
+
+// HealthGate waits for a freshly started service to become healthy when
+// deploy.health_gate is enabled. If it doesn't, the manifest is pointed
+// back at previousVersion, the service is restarted, and an error
+// describing the automatic rollback is returned.
+//
+// The gate is skipped when it is disabled, or when the service has
+// neither a healthcheck nor deploy.health_grace (nothing to wait for).
+func HealthGate(ctx context.Context, client Deployer, cfg *config.Config, previousVersion int, output io.Writer) error {
+	if !cfg.HealthGateEnabled() {
+		return nil
+	}
+	if cfg.HealthCheck == nil && cfg.HealthGrace() == 0 {
+		logf(output, "    Skipping health gate for %s: no healthcheck or deploy.health_grace configured\n", cfg.Name)
+		return nil
+	}
+
+	logf(output, "==> Waiting up to %v for %s to become healthy...\n", cfg.HealthGateTimeout(), cfg.Name)
+	healthErr := client.WaitForHealthy(ctx, cfg.Name, cfg.HealthGateTimeout(), cfg.HealthGrace())
+	if healthErr == nil {
+		logf(output, "    %s is healthy\n", cfg.Name)
+		return nil
+	}
+
+	return rollBack(ctx, client, cfg, previousVersion, "health check", healthErr, output)
+}
+
+// rollBack points the manifest back at previousVersion and restarts the
+// service after it failed check with cause. The returned error describes
+// the failure and the rollback; pre-built images and first deploys have
+// nothing to roll back to.
+func rollBack(ctx context.Context, client Deployer, cfg *config.Config, previousVersion int, check string, cause error, output io.Writer) error {
+	if cfg.IsPrebuilt() || previousVersion < 1 {
+		return fmt.Errorf("%s failed %s: %w (no previous version to roll back to)", cfg.Name, check, cause)
+	}
+
+	logf(output, "==> %s failed %s, rolling back to version %d...\n", cfg.Name, check, previousVersion)
+	if err := client.UpdateManifest(ctx, previousVersion); err != nil {
+		return fmt.Errorf("%s failed %s: %w; automatic rollback failed to update manifest: %v", cfg.Name, check, cause, err)
+	}
+	if err := client.StartService(ctx, cfg.Name); err != nil {
+		return fmt.Errorf("%s failed %s: %w; automatic rollback failed to restart: %v", cfg.Name, check, cause, err)
+	}
+	return fmt.Errorf("%s failed %s: %w; automatically rolled back to version %d", cfg.Name, check, cause, previousVersion)
+}
+
+// AwaitHealthy applies mode after the service's new version is started.
+// WaitNone returns at once. WaitDefault runs HealthGate. WaitHealthy runs
+// HealthGate when the gate would wait (keeping its automatic rollback);
+// otherwise it waits with WaitForHealthy anyway and returns an error,
+// without rolling back, if the service doesn't become healthy.
+func AwaitHealthy(ctx context.Context, client Deployer, cfg *config.Config, previousVersion int, mode HealthWait, output io.Writer) error {
+	switch {
+	case mode == WaitNone:
+		logf(output, "    Not waiting for %s to become healthy (--detach)\n", cfg.Name)
+		return nil
+	case mode == WaitDefault || healthGateWaits(cfg):
+		return HealthGate(ctx, client, cfg, previousVersion, output)
+	}
+
+	logf(output, "==> Waiting up to %v for %s to become healthy...\n", cfg.HealthGateTimeout(), cfg.Name)
+	if err := client.WaitForHealthy(ctx, cfg.Name, cfg.HealthGateTimeout(), cfg.HealthGrace()); err != nil {
+		return fmt.Errorf("%s did not become healthy: %w", cfg.Name, err)
+	}
+	logf(output, "    %s is healthy\n", cfg.Name)
+	return nil
+}
+
+// healthGateWaits reports whether HealthGate would wait for cfg rather
+// than skip.
+func healthGateWaits(cfg *config.Config) bool {
+	return cfg.HealthGateEnabled() && (cfg.HealthCheck != nil || cfg.HealthGrace() > 0)
+}
+
 // RestartWithClient restarts a service without building a new image
 func RestartWithClient(cfg *config.Config, client Deployer, opts *Options) error {
 	ctx := context.Background()
@@ -385,10 +1169,10 @@ func RestartWithClient(cfg *config.Config, client Deployer, opts *Options) error
 		output = opts.Output
 	}
 
-	// Acquire deployment lock
-	unlock, err := acquireLock(cfg.StackPath())
+	// Acquire local and remote deployment locks
+	unlock, err := lockStack(ctx, cfg, client, opts)
 	if err != nil {
-		return fmt.Errorf("failed to acquire deployment lock: %w", err)
+		return err
 	}
 	defer unlock()
 
@@ -402,6 +1186,161 @@ func RestartWithClient(cfg *config.Config, client Deployer, opts *Options) error
 	return nil
 }
 
+// RestartStackWithClient brings up every service in cfg's stack at once
+// (docker compose up -d, or applying every k3s manifest) under the stack's
+// deployment locks. Deploy-all --whole-stack starts services this way.
+func RestartStackWithClient(cfg *config.Config, client Deployer, opts *Options) error {
+	ctx := context.Background()
+
+	unlock, err := lockStack(ctx, cfg, client, opts)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	return client.RestartStack(ctx)
+}
+
+// RollingRestartWithClient restarts services one at a time, in order:
+// each is recreated (StartService) and must become healthy
+// (WaitForHealthy, bounded by its health gate timeout) before the next
+// one is touched, so the stack is never down as a whole. The first
+// service that fails to restart or become healthy stops the restart; the
+// services after it are left running as they were. Services with
+// restart: false are skipped. clientFor returns the client for a service.
+func RollingRestartWithClient(services []*config.Config, clientFor func(*config.Config) Deployer, opts *Options) error {
+	ctx := context.Background()
+
+	output := io.Discard
+	if opts != nil && opts.Output != nil {
+		output = opts.Output
+	}
+
+	restarted := 0
+	for i, cfg := range services {
+		if cfg.ManualStart() {
+			logf(output, "==> Skipping %s (restart: false)\n", cfg.Name)
+			continue
+		}
+		if err := restartAndWait(ctx, cfg, clientFor(cfg), opts, output); err != nil {
+			var rest []string
+			for _, c := range services[i+1:] {
+				rest = append(rest, c.Name)
+			}
+			if len(rest) > 0 {
+				return fmt.Errorf("%w; not restarted: %s", err, strings.Join(rest, ", "))
+			}
+			return err
+		}
+		restarted++
+	}
+
+	logf(output, "\nRestarted %d service(s) one at a time successfully!\n", restarted)
+	return nil
+}
+
+// restartAndWait recreates one service under the stack lock and waits
+// for it to become healthy.
+func restartAndWait(ctx context.Context, cfg *config.Config, client Deployer, opts *Options, output io.Writer) error {
+	unlock, err := lockStack(ctx, cfg, client, opts)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	logf(output, "==> Restarting %s...\n", cfg.Name)
+	if err := client.StartService(ctx, cfg.Name); err != nil {
+		return fmt.Errorf("failed to restart %s: %w", cfg.Name, err)
+	}
+	logf(output, "    Waiting up to %v for %s to become healthy...\n", cfg.HealthGateTimeout(), cfg.Name)
+	if err := client.WaitForHealthy(ctx, cfg.Name, cfg.HealthGateTimeout(), cfg.HealthGrace()); err != nil {
+		return fmt.Errorf("%s did not become healthy after restart: %w", cfg.Name, err)
+	}
+	logf(output, "    %s is healthy\n", cfg.Name)
+	return nil
+}
+
+// StopWithClient stops a single service, keeping its containers so it can
+// be started again without a rebuild
+func StopWithClient(cfg *config.Config, client Deployer, opts *Options) error {
+	ctx := context.Background()
+
+	output := io.Discard
+	if opts != nil && opts.Output != nil {
+		output = opts.Output
+	}
+
+	// Acquire local and remote deployment locks
+	unlock, err := lockStack(ctx, cfg, client, opts)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	logf(output, "Stopping service %s...\n", cfg.Name)
+	if err := client.StopService(ctx, cfg.Name); err != nil {
+		return fmt.Errorf("failed to stop service: %w", err)
+	}
+
+	logf(output, "\nStopped %s.\n", cfg.Name)
+	return nil
+}
+
+// StartWithClient starts a single service at its currently deployed version
+func StartWithClient(cfg *config.Config, client Deployer, opts *Options) error {
+	ctx := context.Background()
+
+	output := io.Discard
+	if opts != nil && opts.Output != nil {
+		output = opts.Output
+	}
+
+	// Acquire local and remote deployment locks
+	unlock, err := lockStack(ctx, cfg, client, opts)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	logf(output, "Starting service %s...\n", cfg.Name)
+	if err := client.StartService(ctx, cfg.Name); err != nil {
+		return fmt.Errorf("failed to start service: %w", err)
+	}
+
+	logf(output, "\nStarted %s.\n", cfg.Name)
+	return nil
+}
+
+// DownWithClient tears down the whole stack cfg belongs to. Named volumes
+// survive unless removeVolumes is set.
+func DownWithClient(cfg *config.Config, client Deployer, removeVolumes bool, opts *Options) error {
+	ctx := context.Background()
+
+	output := io.Discard
+	if opts != nil && opts.Output != nil {
+		output = opts.Output
+	}
+
+	// Acquire local and remote deployment locks
+	unlock, err := lockStack(ctx, cfg, client, opts)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	logf(output, "Bringing down stack %s...\n", cfg.StackPath())
+	if err := client.Down(ctx, removeVolumes); err != nil {
+		return fmt.Errorf("failed to bring down stack: %w", err)
+	}
+
+	if removeVolumes {
+		logln(output, "\nStack is down and its volumes were removed.")
+	} else {
+		logln(output, "\nStack is down. Volumes were kept.")
+	}
+	return nil
+}
+
 // RollbackWithClient rolls back to the previous version
 func RollbackWithClient(cfg *config.Config, client Deployer, opts *Options) error {
 	ctx := context.Background()
@@ -411,10 +1350,10 @@ func RollbackWithClient(cfg *config.Config, client Deployer, opts *Options) erro
 		output = opts.Output
 	}
 
-	// Acquire deployment lock
-	unlock, err := acquireLock(cfg.StackPath())
+	// Acquire local and remote deployment locks
+	unlock, err := lockStack(ctx, cfg, client, opts)
 	if err != nil {
-		return fmt.Errorf("failed to acquire deployment lock: %w", err)
+		return err
 	}
 	defer unlock()
 
@@ -443,7 +1382,7 @@ func RollbackWithClient(cfg *config.Config, client Deployer, opts *Options) erro
 	previousVersion := currentVersion - 1
 	logf(output, "Current version: %d, rolling back to: %d\n", currentVersion, previousVersion)
 
-	manifest := manifestName(rt)
+	manifest := manifestName(rt, cfg)
 	logf(output, "Updating %s...\n", manifest)
 	if err := client.UpdateManifest(ctx, previousVersion); err != nil {
 		return fmt.Errorf("failed to update %s: %w", manifest, err)
@@ -221,6 +221,78 @@ func TestConcurrent_DifferentStacksParallel(t *testing.T) {
 	mockClient2.AssertExpectations(t)
 }
 
+// TestConcurrent_SameStackServicesBuildInParallel verifies that two
+// services sharing a stack build at the same time, while their manifest
+// writes and starts still take turns
+func TestConcurrent_SameStackServicesBuildInParallel(t *testing.T) {
+	stackPath := "/stacks/concurrent-services"
+	var building sync.WaitGroup
+	building.Add(2)
+	bothBuilding := make(chan struct{})
+	go func() {
+		building.Wait()
+		close(bothBuilding)
+	}()
+
+	var writing atomic.Int32
+	var mu sync.Mutex
+	writes := []string{}
+
+	newClient := func(name string) *MockDeployer {
+		m := new(MockDeployer)
+		m.On("StackExists").Return(true, nil)
+		m.On("GetCurrentVersion").Return(1, nil)
+		m.On("MakeTempDir").Return("/tmp/build-"+name, nil)
+		m.On("Rsync", mock.Anything, "/tmp/build-"+name).Return(nil)
+		m.On("BuildImage", "/tmp/build-"+name, 2).Run(func(args mock.Arguments) {
+			building.Done()
+			select {
+			case <-bothBuilding:
+			case <-time.After(2 * time.Second):
+				t.Errorf("%s: the other service never built alongside", name)
+			}
+		}).Return(nil)
+		m.On("UpdateManifest", 2).Run(func(args mock.Arguments) {
+			assert.Equal(t, int32(1), writing.Add(1), "manifest writes overlapped")
+			mu.Lock()
+			writes = append(writes, name)
+			mu.Unlock()
+			time.Sleep(50 * time.Millisecond)
+		}).Return(nil)
+		m.On("RolloutService", name).Run(func(args mock.Arguments) {
+			writing.Add(-1)
+		}).Return(nil)
+		m.On("Cleanup", "/tmp/build-"+name).Return(nil)
+		return m
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 2)
+	for _, name := range []string{"web", "api"} {
+		cfg := &config.Config{
+			Name:       name,
+			Server:     "testserver",
+			Stack:      stackPath,
+			Dockerfile: "./Dockerfile",
+			Context:    ".",
+			Deploy:     &config.DeployConfig{Strategy: "rollout"},
+		}
+		client := newClient(name)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs <- DeployWithClient(cfg, client, nil)
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		require.NoError(t, err)
+	}
+	assert.ElementsMatch(t, []string{"web", "api"}, writes)
+}
+
 // TestConcurrent_LockTimeout verifies that a deployment waiting for a lock
 // will timeout after the configured duration
 func TestConcurrent_LockTimeout(t *testing.T) {
@@ -265,8 +337,9 @@ func TestConcurrent_LockTimeout(t *testing.T) {
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
-		// Manually test lock timeout with custom duration
-		unlock, err := acquireLockWithTimeout(stackPath, 200*time.Millisecond)
+		// Manually test lock timeout with custom duration. The first
+		// deploy holds the service lock while it reads the version.
+		unlock, err := acquireLockWithTimeout(serviceLockKey(cfg), 200*time.Millisecond)
 		if err == nil {
 			unlock()
 		}
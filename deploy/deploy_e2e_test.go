@@ -233,7 +233,7 @@ func TestE2E_VerifyContainerRunning(t *testing.T) {
 	time.Sleep(2 * time.Second)
 
 	// Verify container is running
-	status, err := client.GetContainerStatus(ctx)
+	status, err := client.GetContainerStatus(ctx, cfg.Name)
 	require.NoError(t, err)
 	assert.NotEmpty(t, status, "container status should not be empty")
 
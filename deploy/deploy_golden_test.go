@@ -0,0 +1,61 @@
+package deploy
+
+import (
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/byteink/ssd/config"
+	"github.com/byteink/ssd/internal/testhelpers"
+	"github.com/byteink/ssd/remote"
+	"github.com/stretchr/testify/require"
+)
+
+// lockHolderPattern matches the quoted remote lock holder, which embeds
+// the user, host, pid and time of the run.
+var lockHolderPattern = regexp.MustCompile(`'[^']*@[^']* pid \d+ since [^']*'`)
+
+// repoRoot returns the git checkout the tests run in; Rsync archives from
+// it, so its path shows up in the transcript.
+func repoRoot(t *testing.T) string {
+	t.Helper()
+	out, err := exec.Command("git", "rev-parse", "--show-toplevel").Output()
+	if err != nil {
+		t.Skipf("golden deploy test needs a git checkout: %v", err)
+	}
+	return strings.TrimSpace(string(out))
+}
+
+// TestGolden_DeployWithClient documents every command a compose deploy of
+// a built service issues, in order. Run with SSD_UPDATE_GOLDEN=1 after an
+// intended change and review the diff of the golden file.
+func TestGolden_DeployWithClient(t *testing.T) {
+	root := repoRoot(t)
+	cfg := &config.Config{
+		Name:       "web",
+		Server:     "prod",
+		Stack:      "/stacks/golden",
+		Dockerfile: "./testdata/golden/Dockerfile",
+		Context:    filepath.Join("..", "deploy"),
+		Domain:     "golden.example.com",
+		Port:       3000,
+	}
+	current := "services:\n  web:\n    image: ssd-golden-web:3\n"
+	rec := testhelpers.NewRecordingExecutor().
+		Respond("echo acquired", "acquired\n").
+		Respond("echo yes || echo no", "yes\n").
+		Respond("cat /stacks/golden/compose.yaml", current).
+		Respond("mktemp -d", "/tmp/ssd-build-golden\n").
+		Respond("docker image inspect ssd-golden-web:4", `[{"Id":"sha256:new","Size":1000}]`).
+		Respond("docker image inspect ssd-golden-web:3", `[{"Id":"sha256:old","Size":1000}]`)
+	client := remote.NewClientWithExecutor(cfg, rec)
+
+	err := DeployWithClient(cfg, client, &Options{Runtime: "compose"})
+	require.NoError(t, err)
+
+	got := strings.ReplaceAll(rec.Transcript(), root, "$REPO")
+	got = lockHolderPattern.ReplaceAllString(got, "'<holder>'")
+	testhelpers.AssertGolden(t, filepath.Join("testdata", "golden", "deploy-compose-build.txt"), got)
+}
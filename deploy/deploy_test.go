@@ -1,6 +1,7 @@
 package deploy
 
 import (
+	"bytes"
 	"context"
 	"errors"
 	"fmt"
@@ -12,7 +13,10 @@ import (
 	"testing"
 	"time"
 
+	"github.com/byteink/ssd/compose"
 	"github.com/byteink/ssd/config"
+	"github.com/byteink/ssd/internal/clock"
+	"github.com/byteink/ssd/remote"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 	"github.com/stretchr/testify/require"
@@ -59,6 +63,11 @@ func (m *MockDeployer) RestartStack(ctx context.Context) error {
 	return args.Error(0)
 }
 
+func (m *MockDeployer) Down(ctx context.Context, removeVolumes bool) error {
+	args := m.Called(removeVolumes)
+	return args.Error(0)
+}
+
 func (m *MockDeployer) Cleanup(ctx context.Context, path string) error {
 	args := m.Called(path)
 	return args.Error(0)
@@ -99,11 +108,31 @@ func (m *MockDeployer) PullImage(ctx context.Context, image string) error {
 	return args.Error(0)
 }
 
+func (m *MockDeployer) ImageDigest(ctx context.Context, image string) (string, error) {
+	args := m.Called(image)
+	return args.String(0), args.Error(1)
+}
+
 func (m *MockDeployer) StartService(ctx context.Context, serviceName string) error {
 	args := m.Called(serviceName)
 	return args.Error(0)
 }
 
+func (m *MockDeployer) StopService(ctx context.Context, serviceName string) error {
+	args := m.Called(serviceName)
+	return args.Error(0)
+}
+
+func (m *MockDeployer) RunJob(ctx context.Context, serviceName string) error {
+	args := m.Called(serviceName)
+	return args.Error(0)
+}
+
+func (m *MockDeployer) WaitForHealthy(ctx context.Context, serviceName string, timeout, grace time.Duration) error {
+	args := m.Called(serviceName, timeout, grace)
+	return args.Error(0)
+}
+
 func (m *MockDeployer) RolloutService(ctx context.Context, serviceName string) error {
 	args := m.Called(serviceName)
 	return args.Error(0)
@@ -228,6 +257,267 @@ func TestDeploy_BuildError(t *testing.T) {
 	mockClient.AssertCalled(t, "Cleanup", "/tmp/build")
 }
 
+func TestDeploy_KeepBuildDir(t *testing.T) {
+	mockClient := new(MockDeployer)
+	cfg := newTestConfig()
+
+	mockClient.On("StackExists").Return(true, nil)
+	mockClient.On("GetCurrentVersion").Return(1, nil)
+	mockClient.On("MakeTempDir").Return("/tmp/build", nil)
+	mockClient.On("Rsync", mock.Anything, "/tmp/build").Return(nil)
+	mockClient.On("BuildImage", "/tmp/build", 2).Return(errors.New("docker build failed"))
+
+	var out bytes.Buffer
+	err := DeployWithClient(cfg, mockClient, &Options{Output: &out, KeepBuildDir: true})
+
+	require.Error(t, err)
+	mockClient.AssertNotCalled(t, "Cleanup", mock.Anything)
+	assert.Contains(t, out.String(), "Kept build directory testserver:/tmp/build")
+}
+
+func TestDeploy_ForceVersion(t *testing.T) {
+	mockClient := new(MockDeployer)
+	cfg := newTestConfig()
+
+	mockClient.On("StackExists").Return(true, nil)
+	mockClient.On("GetCurrentVersion").Return(7, nil)
+	mockClient.On("MakeTempDir").Return("/tmp/build", nil)
+	mockClient.On("Rsync", mock.Anything, "/tmp/build").Return(nil)
+	mockClient.On("BuildImage", "/tmp/build", 5).Return(nil)
+	mockClient.On("UpdateManifest", 5).Return(nil)
+	mockClient.On("RolloutService", "myapp").Return(nil)
+	mockClient.On("Cleanup", "/tmp/build").Return(nil)
+
+	var out bytes.Buffer
+	res, err := DeployWithResult(cfg, mockClient, &Options{Output: &out, ForceVersion: 5})
+
+	require.NoError(t, err)
+	mockClient.AssertExpectations(t)
+	assert.Equal(t, 7, res.OldVersion)
+	assert.Equal(t, 5, res.NewVersion)
+	assert.Contains(t, out.String(), "WARNING: forcing version 5 (current 7)")
+	assert.Contains(t, out.String(), "an existing ssd-myapp-myapp:5 image is overwritten")
+	assert.Contains(t, out.String(), "the next deploy continues from 6")
+}
+
+func TestDeploy_ForceVersionRegeneratesCompose(t *testing.T) {
+	mockClient := new(MockDeployer)
+	web := &config.Config{Name: "web", Server: "testserver", Stack: "/stacks/shop", Context: ".", Dockerfile: "Dockerfile",
+		Deploy: &config.DeployConfig{Strategy: "rollout", SkipUnchanged: true}}
+	opts := &Options{
+		AllServices:  map[string]*config.Config{"web": web},
+		Sources:      &fakeSources{tree: "abc", deployed: sourceRecord(web, "abc")},
+		ForceVersion: 12,
+	}
+
+	mockClient.On("StackExists").Return(true, nil)
+	mockClient.On("GetCurrentVersion").Return(4, nil)
+	mockClient.On("MakeTempDir").Return("/tmp/build", nil)
+	mockClient.On("Rsync", mock.Anything, "/tmp/build").Return(nil)
+	mockClient.On("BuildImage", "/tmp/build", 12).Return(nil)
+	mockClient.On("ReadManifest").Return("services:\n  web:\n    image: ssd-shop-web:4\n", nil)
+	mockClient.On("CreateEnvFiles", mock.Anything).Return(nil)
+	mockClient.On("CreateStack", mock.MatchedBy(func(content string) bool {
+		return strings.Contains(content, "image: ssd-shop-web:12")
+	})).Return(nil)
+	mockClient.On("RolloutService", "web").Return(nil)
+	mockClient.On("Cleanup", "/tmp/build").Return(nil)
+
+	res, err := DeployWithResult(web, mockClient, opts)
+
+	require.NoError(t, err)
+	mockClient.AssertExpectations(t)
+	assert.False(t, res.Unchanged, "a forced version bypasses skip_unchanged")
+	assert.Equal(t, 12, res.NewVersion)
+}
+
+func TestDeploy_ForceVersionInvalid(t *testing.T) {
+	mockClient := new(MockDeployer)
+	mockClient.On("StackExists").Return(true, nil)
+	mockClient.On("GetCurrentVersion").Return(3, nil)
+
+	err := DeployWithClient(newTestConfig(), mockClient, &Options{ForceVersion: -1})
+
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "must be at least 1")
+	mockClient.AssertNotCalled(t, "BuildImage", mock.Anything, mock.Anything)
+}
+
+func TestDeploy_SkipBuild_RegeneratesComposeAndRestarts(t *testing.T) {
+	mockClient := new(MockDeployer)
+	web := &config.Config{Name: "web", Server: "testserver", Stack: "/stacks/shop", Context: ".", Dockerfile: "Dockerfile",
+		Domain: "shop.example.com", Deploy: &config.DeployConfig{Strategy: "rollout", SkipUnchanged: true}}
+	opts := &Options{
+		AllServices: map[string]*config.Config{"web": web},
+		Sources:     &fakeSources{tree: "abc", deployed: sourceRecord(web, "abc")},
+		SkipBuild:   true,
+	}
+
+	mockClient.On("StackExists").Return(true, nil)
+	mockClient.On("GetCurrentVersion").Return(4, nil)
+	mockClient.On("ReadManifest").Return("services:\n  web:\n    image: ssd-shop-web:4\n", nil)
+	mockClient.On("CreateEnvFiles", mock.Anything).Return(nil)
+	mockClient.On("CreateStack", mock.MatchedBy(func(content string) bool {
+		return strings.Contains(content, "image: ssd-shop-web:4") && strings.Contains(content, "Host(`shop.example.com`)")
+	})).Return(nil)
+	mockClient.On("RolloutService", "web").Return(nil)
+
+	var out bytes.Buffer
+	opts.Output = &out
+	res, err := DeployWithResult(web, mockClient, opts)
+
+	require.NoError(t, err)
+	mockClient.AssertExpectations(t)
+	mockClient.AssertNotCalled(t, "MakeTempDir")
+	mockClient.AssertNotCalled(t, "Rsync", mock.Anything, mock.Anything)
+	mockClient.AssertNotCalled(t, "BuildImage", mock.Anything, mock.Anything)
+	mockClient.AssertNotCalled(t, "PullImage", mock.Anything)
+	assert.False(t, res.Unchanged, "--skip-build restarts even when skip_unchanged would skip")
+	assert.Equal(t, 4, res.OldVersion)
+	assert.Equal(t, 4, res.NewVersion)
+	assert.Contains(t, out.String(), "Skipping build (--skip-build)")
+}
+
+func TestDeploy_WritesMetadata(t *testing.T) {
+	orig := manifestClock
+	manifestClock = clock.NewFake(time.Date(2026, 10, 16, 8, 0, 0, 0, time.UTC))
+	t.Cleanup(func() { manifestClock = orig })
+
+	mockClient := new(MockDeployer)
+	web := &config.Config{Name: "web", Server: "testserver", Stack: "/stacks/shop", Context: ".", Dockerfile: "Dockerfile"}
+	api := &config.Config{Name: "api", Server: "testserver", Stack: "/stacks/shop", Context: ".", Dockerfile: "Dockerfile"}
+	opts := &Options{
+		AllServices: map[string]*config.Config{"web": web, "api": api},
+		Sources:     &fakeSources{tree: "new-tree"},
+		SSDVersion:  "1.4.0",
+	}
+	existing, err := compose.GenerateComposeWithMetadata(opts.AllServices, "/stacks/shop", map[string]int{"web": 4, "api": 2},
+		compose.Metadata{Sources: map[string]string{"web": "old-tree", "api": "api-tree", "gone": "x"}})
+	require.NoError(t, err)
+
+	var written string
+	mockClient.On("StackExists").Return(true, nil)
+	mockClient.On("GetCurrentVersion").Return(4, nil)
+	mockClient.On("MakeTempDir").Return("/tmp/build", nil)
+	mockClient.On("Rsync", mock.Anything, "/tmp/build").Return(nil)
+	mockClient.On("BuildImage", "/tmp/build", 5).Return(nil)
+	mockClient.On("ReadManifest").Return(existing, nil)
+	mockClient.On("CreateEnvFiles", mock.Anything).Return(nil)
+	mockClient.On("CreateStack", mock.Anything).Run(func(args mock.Arguments) {
+		written = args.String(0)
+	}).Return(nil)
+	mockClient.On("RolloutService", "web").Return(nil)
+	mockClient.On("Cleanup", "/tmp/build").Return(nil)
+
+	require.NoError(t, DeployWithClient(web, mockClient, opts))
+
+	meta, ok := compose.ParseMetadata(written)
+	require.True(t, ok, "no x-ssd block in:\n%s", written)
+	assert.Equal(t, compose.Metadata{
+		ManagedBy:   "ssd",
+		Project:     "shop",
+		GeneratedAt: "2026-10-16T08:00:00Z",
+		SSDVersion:  "1.4.0",
+		Sources:     map[string]string{"web": "new-tree", "api": "api-tree"},
+	}, meta)
+}
+
+func TestDeploy_SkipBuild_PrebuiltIsNotPulled(t *testing.T) {
+	mockClient := new(MockDeployer)
+	cfg := newTestConfig()
+	cfg.Image = "nginx:1.27"
+
+	mockClient.On("StackExists").Return(true, nil)
+	mockClient.On("GetCurrentVersion").Return(0, nil)
+	mockClient.On("RolloutService", "myapp").Return(nil)
+
+	err := DeployWithClient(cfg, mockClient, &Options{SkipBuild: true})
+
+	require.NoError(t, err)
+	mockClient.AssertExpectations(t)
+	mockClient.AssertNotCalled(t, "PullImage", mock.Anything)
+	mockClient.AssertNotCalled(t, "MakeTempDir")
+}
+
+func TestDeploy_SkipBuild_RequiresPreviousDeploy(t *testing.T) {
+	t.Run("no stack", func(t *testing.T) {
+		mockClient := new(MockDeployer)
+		mockClient.On("StackExists").Return(false, nil)
+
+		err := DeployWithClient(newTestConfig(), mockClient, &Options{SkipBuild: true})
+
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "myapp has not been deployed yet")
+		mockClient.AssertNotCalled(t, "CreateStack", mock.Anything)
+	})
+
+	t.Run("no image", func(t *testing.T) {
+		mockClient := new(MockDeployer)
+		mockClient.On("StackExists").Return(true, nil)
+		mockClient.On("GetCurrentVersion").Return(0, nil)
+
+		err := DeployWithClient(newTestConfig(), mockClient, &Options{SkipBuild: true})
+
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "deploy it once without --skip-build")
+		mockClient.AssertNotCalled(t, "UpdateManifest", mock.Anything)
+		mockClient.AssertNotCalled(t, "StartService", mock.Anything)
+	})
+}
+
+// dockerfileDeployer is a MockDeployer that also implements
+// DockerfileChecker, reporting err.
+type dockerfileDeployer struct {
+	MockDeployer
+	err error
+}
+
+func (d *dockerfileDeployer) CheckDockerfile() error {
+	return d.err
+}
+
+func TestDeploy_MissingDockerfileFailsBeforeSync(t *testing.T) {
+	client := &dockerfileDeployer{err: errors.New(`dockerfile "./Dockerfil" not found: no file at /src/Dockerfil`)}
+	client.On("StackExists").Return(true, nil)
+
+	err := DeployWithClient(newTestConfig(), client, nil)
+
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), `dockerfile "./Dockerfil" not found`)
+	client.AssertNotCalled(t, "MakeTempDir")
+	client.AssertNotCalled(t, "Rsync", mock.Anything, mock.Anything)
+	client.AssertNotCalled(t, "BuildImage", mock.Anything, mock.Anything)
+}
+
+func TestDeploy_PresentDockerfileProceeds(t *testing.T) {
+	client := &dockerfileDeployer{}
+	client.On("StackExists").Return(true, nil)
+	client.On("GetCurrentVersion").Return(1, nil)
+	client.On("MakeTempDir").Return("/tmp/build", nil)
+	client.On("Rsync", mock.Anything, "/tmp/build").Return(nil)
+	client.On("BuildImage", "/tmp/build", 2).Return(nil)
+	client.On("UpdateManifest", 2).Return(nil)
+	client.On("RolloutService", "myapp").Return(nil)
+	client.On("Cleanup", "/tmp/build").Return(nil)
+
+	require.NoError(t, DeployWithClient(newTestConfig(), client, nil))
+	client.AssertExpectations(t)
+}
+
+func TestDeploy_PrebuiltSkipsDockerfileCheck(t *testing.T) {
+	cfg := newTestConfig()
+	cfg.Image = "nginx:1"
+	client := &dockerfileDeployer{err: errors.New("must not be checked")}
+	client.On("StackExists").Return(true, nil)
+	client.On("GetCurrentVersion").Return(1, nil)
+	client.On("MakeTempDir").Return("/tmp/build", nil)
+	client.On("PullImage", "nginx:1").Return(nil)
+	client.On("RolloutService", "myapp").Return(nil)
+	client.On("Cleanup", "/tmp/build").Return(nil)
+
+	require.NoError(t, DeployWithClient(cfg, client, nil))
+}
+
 func TestDeploy_UpdateManifestError(t *testing.T) {
 	mockClient := new(MockDeployer)
 	cfg := newTestConfig()
@@ -479,25 +769,20 @@ func TestAcquireLock_Success(t *testing.T) {
 }
 
 func TestAcquireLock_SamePathTwice(t *testing.T) {
+	clk := fakeLockClock(t)
 	stackPath := "/stacks/test-concurrent"
 
 	unlock1, err := acquireLockWithTimeout(stackPath, 2*time.Second)
 	require.NoError(t, err)
 	defer unlock1()
+	assert.Empty(t, clk.Waits(), "a free lock is taken without waiting")
 
-	done := make(chan error, 1)
-	go func() {
-		_, err := acquireLockWithTimeout(stackPath, 500*time.Millisecond)
-		done <- err
-	}()
-
-	select {
-	case err := <-done:
-		require.Error(t, err)
-		assert.Contains(t, err.Error(), "timeout waiting for deployment lock")
-	case <-time.After(2 * time.Second):
-		t.Fatal("expected timeout error but goroutine did not complete")
-	}
+	_, err = acquireLockWithTimeout(stackPath, 500*time.Millisecond)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "timeout waiting for deployment lock")
+	assert.Contains(t, err.Error(), "after 500ms")
+	// Retries every 100ms from 0 to 600ms; the try past the deadline gives up.
+	assert.Len(t, clk.Waits(), 6)
 }
 
 func TestAcquireLock_ConcurrentDeploys(t *testing.T) {
@@ -697,124 +982,320 @@ func TestRestart_LockReleasedOnError(t *testing.T) {
 	unlock()
 }
 
-// Rollback tests
-
-func TestRollback_Success(t *testing.T) {
+func TestRestartStack_WaitsForStackLock(t *testing.T) {
+	fakeLockClock(t)
 	mockClient := new(MockDeployer)
 	cfg := newTestConfig()
+	cfg.Stack = "/stacks/restart-stack-lock"
 
-	// Current version is 5, rollback to 4
-	mockClient.On("GetCurrentVersion").Return(5, nil)
-	mockClient.On("UpdateManifest", 4).Return(nil)
-	mockClient.On("StartService", "myapp").Return(nil)
+	held, err := acquireLock(cfg.StackPath())
+	require.NoError(t, err)
+	defer held()
 
-	err := RollbackWithClient(cfg, mockClient, nil)
+	err = RestartStackWithClient(cfg, mockClient, &Options{LockTimeout: 150 * time.Millisecond})
 
-	require.NoError(t, err)
-	mockClient.AssertExpectations(t)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "deployment lock")
+	mockClient.AssertNotCalled(t, "RestartStack")
 }
 
-func TestRollback_GetVersionError(t *testing.T) {
+func TestRestartStack_LockReleasedOnError(t *testing.T) {
 	mockClient := new(MockDeployer)
 	cfg := newTestConfig()
 
-	mockClient.On("GetCurrentVersion").Return(0, errors.New("SSH failed"))
-
-	err := RollbackWithClient(cfg, mockClient, nil)
+	mockClient.On("RestartStack").Return(errors.New("compose up failed"))
 
+	err := RestartStackWithClient(cfg, mockClient, nil)
 	require.Error(t, err)
-	assert.Contains(t, err.Error(), "failed to get current version")
+
+	unlock, err := acquireLock(cfg.StackPath())
+	require.NoError(t, err, "lock should be released after restart error")
+	unlock()
 }
 
-func TestRollback_CannotRollbackVersion0(t *testing.T) {
+// rollingServices returns the services api, db and web, in one stack.
+func rollingServices() []*config.Config {
+	var services []*config.Config
+	for _, name := range []string{"api", "db", "web"} {
+		cfg := newTestConfig()
+		cfg.Name = name
+		services = append(services, cfg)
+	}
+	return services
+}
+
+// recordCalls makes mockClient append "start <name>" and "wait <name>" to
+// calls for every StartService and WaitForHealthy.
+func recordCalls(mockClient *MockDeployer, calls *[]string) {
+	record := func(verb string) func(mock.Arguments) {
+		return func(args mock.Arguments) { *calls = append(*calls, verb+" "+args.String(0)) }
+	}
+	mockClient.On("StartService", mock.Anything).Run(record("start")).Return(nil)
+	mockClient.On("WaitForHealthy", mock.Anything, mock.Anything, mock.Anything).Run(record("wait")).Return(nil)
+}
+
+func TestRollingRestart_OneAtATime(t *testing.T) {
 	mockClient := new(MockDeployer)
-	cfg := newTestConfig()
+	var calls []string
+	recordCalls(mockClient, &calls)
+	var out bytes.Buffer
 
-	mockClient.On("GetCurrentVersion").Return(0, nil)
+	err := RollingRestartWithClient(rollingServices(), func(*config.Config) Deployer { return mockClient }, &Options{Output: &out})
 
-	err := RollbackWithClient(cfg, mockClient, nil)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"start api", "wait api", "start db", "wait db", "start web", "wait web"}, calls)
+	assert.Contains(t, out.String(), "Restarted 3 service(s) one at a time successfully!")
+}
+
+func TestRollingRestart_StopsAtUnhealthy(t *testing.T) {
+	mockClient := new(MockDeployer)
+	var calls []string
+	mockClient.On("WaitForHealthy", "db", mock.Anything, mock.Anything).Run(func(mock.Arguments) {
+		calls = append(calls, "wait db")
+	}).Return(errors.New("container unhealthy"))
+	recordCalls(mockClient, &calls)
+
+	err := RollingRestartWithClient(rollingServices(), func(*config.Config) Deployer { return mockClient }, nil)
 
 	require.Error(t, err)
-	assert.Contains(t, err.Error(), "cannot rollback: no previous version")
+	assert.Contains(t, err.Error(), "db did not become healthy after restart: container unhealthy")
+	assert.Contains(t, err.Error(), "not restarted: web")
+	assert.Equal(t, []string{"start api", "wait api", "start db", "wait db"}, calls)
+	mockClient.AssertNotCalled(t, "StartService", "web")
+
+	// The stack lock is not left held
+	unlock, err := acquireLock(rollingServices()[0].StackPath())
+	require.NoError(t, err)
+	unlock()
 }
 
-func TestRollback_CannotRollbackVersion1(t *testing.T) {
+func TestRollingRestart_SkipsManualStart(t *testing.T) {
+	mockClient := new(MockDeployer)
+	var calls []string
+	recordCalls(mockClient, &calls)
+	services := rollingServices()
+	manual := false
+	services[1].Restart = &manual
+
+	err := RollingRestartWithClient(services, func(*config.Config) Deployer { return mockClient }, nil)
+
+	require.NoError(t, err)
+	assert.Equal(t, []string{"start api", "wait api", "start web", "wait web"}, calls)
+}
+
+// Stop/Start tests
+
+func TestStop_Success(t *testing.T) {
 	mockClient := new(MockDeployer)
 	cfg := newTestConfig()
 
-	mockClient.On("GetCurrentVersion").Return(1, nil)
+	mockClient.On("StopService", "myapp").Return(nil)
 
-	err := RollbackWithClient(cfg, mockClient, nil)
+	err := StopWithClient(cfg, mockClient, nil)
+
+	require.NoError(t, err)
+	mockClient.AssertExpectations(t)
+	mockClient.AssertNotCalled(t, "Down", mock.Anything)
+}
+
+func TestStop_LockReleasedOnError(t *testing.T) {
+	mockClient := new(MockDeployer)
+	cfg := newTestConfig()
+
+	mockClient.On("StopService", "myapp").Return(errors.New("compose stop failed"))
 
+	err := StopWithClient(cfg, mockClient, nil)
 	require.Error(t, err)
-	assert.Contains(t, err.Error(), "cannot rollback: no previous version")
+	assert.Contains(t, err.Error(), "failed to stop service")
+
+	unlock, err := acquireLock(cfg.StackPath())
+	require.NoError(t, err, "lock should be released after stop error")
+	unlock()
 }
 
-func TestRollback_UpdateManifestError(t *testing.T) {
+func TestStart_Success(t *testing.T) {
 	mockClient := new(MockDeployer)
 	cfg := newTestConfig()
 
-	mockClient.On("GetCurrentVersion").Return(5, nil)
-	mockClient.On("UpdateManifest", 4).Return(errors.New("permission denied"))
+	mockClient.On("StartService", "myapp").Return(nil)
 
-	err := RollbackWithClient(cfg, mockClient, nil)
+	err := StartWithClient(cfg, mockClient, nil)
 
-	require.Error(t, err)
-	assert.Contains(t, err.Error(), "failed to update compose.yaml")
+	require.NoError(t, err)
+	mockClient.AssertExpectations(t)
 }
 
-func TestRollback_RestartError(t *testing.T) {
+func TestStart_Error(t *testing.T) {
 	mockClient := new(MockDeployer)
 	cfg := newTestConfig()
 
-	mockClient.On("GetCurrentVersion").Return(5, nil)
-	mockClient.On("UpdateManifest", 4).Return(nil)
 	mockClient.On("StartService", "myapp").Return(errors.New("compose up failed"))
 
-	err := RollbackWithClient(cfg, mockClient, nil)
+	err := StartWithClient(cfg, mockClient, nil)
 
 	require.Error(t, err)
 	assert.Contains(t, err.Error(), "failed to start service")
 }
 
-func TestRollback_VersionDecrement(t *testing.T) {
-	tests := []struct {
-		name            string
-		currentVersion  int
-		expectedVersion int
-	}{
-		{"version 2 to 1", 2, 1},
-		{"version 10 to 9", 10, 9},
-		{"version 100 to 99", 100, 99},
-	}
+// Down tests
 
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			mockClient := new(MockDeployer)
-			cfg := newTestConfig()
+func TestDown_KeepsVolumesByDefault(t *testing.T) {
+	mockClient := new(MockDeployer)
+	cfg := newTestConfig()
 
-			mockClient.On("GetCurrentVersion").Return(tt.currentVersion, nil)
-			mockClient.On("UpdateManifest", tt.expectedVersion).Return(nil)
-			mockClient.On("StartService", "myapp").Return(nil)
+	mockClient.On("Down", false).Return(nil)
 
-			err := RollbackWithClient(cfg, mockClient, nil)
+	err := DownWithClient(cfg, mockClient, false, nil)
 
-			require.NoError(t, err)
-			mockClient.AssertCalled(t, "UpdateManifest", tt.expectedVersion)
-		})
-	}
+	require.NoError(t, err)
+	mockClient.AssertExpectations(t)
 }
 
-func TestRollback_LockReleasedOnError(t *testing.T) {
+func TestDown_RemoveVolumes(t *testing.T) {
 	mockClient := new(MockDeployer)
 	cfg := newTestConfig()
 
-	mockClient.On("GetCurrentVersion").Return(0, errors.New("connection failed"))
+	mockClient.On("Down", true).Return(nil)
 
-	err := RollbackWithClient(cfg, mockClient, nil)
-	require.Error(t, err)
+	err := DownWithClient(cfg, mockClient, true, nil)
 
-	unlock, err := acquireLock(cfg.StackPath())
+	require.NoError(t, err)
+	mockClient.AssertExpectations(t)
+}
+
+func TestDown_LockReleasedOnError(t *testing.T) {
+	mockClient := new(MockDeployer)
+	cfg := newTestConfig()
+
+	mockClient.On("Down", false).Return(errors.New("compose down failed"))
+
+	err := DownWithClient(cfg, mockClient, false, nil)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "failed to bring down stack")
+
+	unlock, err := acquireLock(cfg.StackPath())
+	require.NoError(t, err, "lock should be released after down error")
+	unlock()
+}
+
+// Rollback tests
+
+func TestRollback_Success(t *testing.T) {
+	mockClient := new(MockDeployer)
+	cfg := newTestConfig()
+
+	// Current version is 5, rollback to 4
+	mockClient.On("GetCurrentVersion").Return(5, nil)
+	mockClient.On("UpdateManifest", 4).Return(nil)
+	mockClient.On("StartService", "myapp").Return(nil)
+
+	err := RollbackWithClient(cfg, mockClient, nil)
+
+	require.NoError(t, err)
+	mockClient.AssertExpectations(t)
+}
+
+func TestRollback_GetVersionError(t *testing.T) {
+	mockClient := new(MockDeployer)
+	cfg := newTestConfig()
+
+	mockClient.On("GetCurrentVersion").Return(0, errors.New("SSH failed"))
+
+	err := RollbackWithClient(cfg, mockClient, nil)
+
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "failed to get current version")
+}
+
+func TestRollback_CannotRollbackVersion0(t *testing.T) {
+	mockClient := new(MockDeployer)
+	cfg := newTestConfig()
+
+	mockClient.On("GetCurrentVersion").Return(0, nil)
+
+	err := RollbackWithClient(cfg, mockClient, nil)
+
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "cannot rollback: no previous version")
+}
+
+func TestRollback_CannotRollbackVersion1(t *testing.T) {
+	mockClient := new(MockDeployer)
+	cfg := newTestConfig()
+
+	mockClient.On("GetCurrentVersion").Return(1, nil)
+
+	err := RollbackWithClient(cfg, mockClient, nil)
+
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "cannot rollback: no previous version")
+}
+
+func TestRollback_UpdateManifestError(t *testing.T) {
+	mockClient := new(MockDeployer)
+	cfg := newTestConfig()
+
+	mockClient.On("GetCurrentVersion").Return(5, nil)
+	mockClient.On("UpdateManifest", 4).Return(errors.New("permission denied"))
+
+	err := RollbackWithClient(cfg, mockClient, nil)
+
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "failed to update compose.yaml")
+}
+
+func TestRollback_RestartError(t *testing.T) {
+	mockClient := new(MockDeployer)
+	cfg := newTestConfig()
+
+	mockClient.On("GetCurrentVersion").Return(5, nil)
+	mockClient.On("UpdateManifest", 4).Return(nil)
+	mockClient.On("StartService", "myapp").Return(errors.New("compose up failed"))
+
+	err := RollbackWithClient(cfg, mockClient, nil)
+
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "failed to start service")
+}
+
+func TestRollback_VersionDecrement(t *testing.T) {
+	tests := []struct {
+		name            string
+		currentVersion  int
+		expectedVersion int
+	}{
+		{"version 2 to 1", 2, 1},
+		{"version 10 to 9", 10, 9},
+		{"version 100 to 99", 100, 99},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockClient := new(MockDeployer)
+			cfg := newTestConfig()
+
+			mockClient.On("GetCurrentVersion").Return(tt.currentVersion, nil)
+			mockClient.On("UpdateManifest", tt.expectedVersion).Return(nil)
+			mockClient.On("StartService", "myapp").Return(nil)
+
+			err := RollbackWithClient(cfg, mockClient, nil)
+
+			require.NoError(t, err)
+			mockClient.AssertCalled(t, "UpdateManifest", tt.expectedVersion)
+		})
+	}
+}
+
+func TestRollback_LockReleasedOnError(t *testing.T) {
+	mockClient := new(MockDeployer)
+	cfg := newTestConfig()
+
+	mockClient.On("GetCurrentVersion").Return(0, errors.New("connection failed"))
+
+	err := RollbackWithClient(cfg, mockClient, nil)
+	require.Error(t, err)
+
+	unlock, err := acquireLock(cfg.StackPath())
 	require.NoError(t, err, "lock should be released after rollback error")
 	unlock()
 }
@@ -896,6 +1377,105 @@ func TestDeploy_AutoCreateStack_SecondDeploySkipsCreation(t *testing.T) {
 	mockClient.AssertNotCalled(t, "CreateEnvFiles")
 }
 
+func TestDeploy_AutoCreateStack_SeedsEnvFilesBeforeCreateStack(t *testing.T) {
+	var callOrder []string
+	record := func(name string) func(mock.Arguments) {
+		return func(mock.Arguments) { callOrder = append(callOrder, name) }
+	}
+
+	mockClient := new(MockDeployer)
+	cfg := newTestConfig()
+	opts := &Options{SeedEnvFiles: map[string]string{"myapp": "./.env.prod"}}
+
+	mockClient.On("StackExists").Return(false, nil)
+	mockClient.On("CreateEnvFiles", []string{"myapp"}).Return(nil).Run(record("CreateEnvFiles"))
+	mockClient.On("UploadEnvFile", "myapp", "./.env.prod").Return(nil).Run(record("UploadEnvFile"))
+	mockClient.On("CreateStack", mock.AnythingOfType("string")).Return(nil).Run(record("CreateStack"))
+	mockClient.On("EnsureNetwork", "myapp_internal").Return(nil)
+	mockClient.On("GetCurrentVersion").Return(0, nil)
+	mockClient.On("MakeTempDir").Return("/tmp/build", nil)
+	mockClient.On("Rsync", mock.Anything, "/tmp/build").Return(nil)
+	mockClient.On("BuildImage", "/tmp/build", 1).Return(nil)
+	mockClient.On("UpdateManifest", 1).Return(nil)
+	mockClient.On("RolloutService", "myapp").Return(nil).Run(record("RolloutService"))
+	mockClient.On("Cleanup", "/tmp/build").Return(nil)
+
+	err := DeployWithClient(cfg, mockClient, opts)
+
+	require.NoError(t, err)
+	mockClient.AssertExpectations(t)
+	assert.Equal(t, []string{"CreateEnvFiles", "UploadEnvFile", "CreateStack", "RolloutService"}, callOrder)
+}
+
+func TestDeploy_AutoCreateStack_CreatesSharedEnvFilesBeforeCreateStack(t *testing.T) {
+	var callOrder []string
+	record := func(name string) func(mock.Arguments) {
+		return func(mock.Arguments) { callOrder = append(callOrder, name) }
+	}
+
+	mockClient := new(MockDeployer)
+	cfg := newTestConfig()
+	cfg.EnvFiles = []string{"common.env"}
+	worker := &config.Config{Name: "worker", Stack: "/stacks/myapp", Image: "busybox", EnvFiles: []string{"common.env", "queue.env"}}
+	opts := &Options{AllServices: map[string]*config.Config{"myapp": cfg, "worker": worker}}
+
+	mockClient.On("StackExists").Return(false, nil)
+	mockClient.On("CreateEnvFiles", []string{"common", "myapp", "queue", "worker"}).Return(nil).Run(record("CreateEnvFiles"))
+	mockClient.On("CreateStack", mock.MatchedBy(func(content string) bool {
+		return strings.Contains(content, "- ./common.env") && strings.Contains(content, "- ./queue.env")
+	})).Return(nil).Run(record("CreateStack"))
+	mockClient.On("EnsureNetwork", "myapp_internal").Return(nil)
+	mockClient.On("GetCurrentVersion").Return(0, nil)
+	mockClient.On("MakeTempDir").Return("/tmp/build", nil)
+	mockClient.On("Rsync", mock.Anything, "/tmp/build").Return(nil)
+	mockClient.On("BuildImage", "/tmp/build", 1).Return(nil)
+	mockClient.On("ReadManifest").Return("", nil)
+	mockClient.On("RolloutService", "myapp").Return(nil)
+	mockClient.On("Cleanup", "/tmp/build").Return(nil)
+
+	err := DeployWithClient(cfg, mockClient, opts)
+
+	require.NoError(t, err)
+	mockClient.AssertExpectations(t)
+	assert.Equal(t, []string{"CreateEnvFiles", "CreateStack", "CreateEnvFiles", "CreateStack"}, callOrder)
+}
+
+func TestDeploy_AutoCreateStack_SeedEnvFileError(t *testing.T) {
+	mockClient := new(MockDeployer)
+	cfg := newTestConfig()
+	opts := &Options{SeedEnvFiles: map[string]string{"myapp": "./.env.prod"}}
+
+	mockClient.On("StackExists").Return(false, nil)
+	mockClient.On("CreateEnvFiles", []string{"myapp"}).Return(nil)
+	mockClient.On("UploadEnvFile", "myapp", "./.env.prod").Return(errors.New("permission denied"))
+
+	err := DeployWithClient(cfg, mockClient, opts)
+
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "failed to seed env file for myapp")
+	mockClient.AssertNotCalled(t, "CreateStack", mock.Anything)
+}
+
+func TestDeploy_SeedEnvFilesIgnoredWhenStackExists(t *testing.T) {
+	mockClient := new(MockDeployer)
+	cfg := newTestConfig()
+	opts := &Options{SeedEnvFiles: map[string]string{"myapp": "./.env.prod"}}
+
+	mockClient.On("StackExists").Return(true, nil)
+	mockClient.On("GetCurrentVersion").Return(1, nil)
+	mockClient.On("MakeTempDir").Return("/tmp/build", nil)
+	mockClient.On("Rsync", mock.Anything, "/tmp/build").Return(nil)
+	mockClient.On("BuildImage", "/tmp/build", 2).Return(nil)
+	mockClient.On("UpdateManifest", 2).Return(nil)
+	mockClient.On("RolloutService", "myapp").Return(nil)
+	mockClient.On("Cleanup", "/tmp/build").Return(nil)
+
+	err := DeployWithClient(cfg, mockClient, opts)
+
+	require.NoError(t, err)
+	mockClient.AssertNotCalled(t, "UploadEnvFile", mock.Anything, mock.Anything)
+}
+
 func TestDeploy_AutoCreateStack_StackExistsCheckError(t *testing.T) {
 	mockClient := new(MockDeployer)
 	cfg := newTestConfig()
@@ -1055,55 +1635,319 @@ func TestDeploy_PrebuiltService_PullError(t *testing.T) {
 	mockClient.AssertNotCalled(t, "UpdateManifest")
 }
 
-func TestDeploy_BuiltService_BuildsImage(t *testing.T) {
-	mockClient := new(MockDeployer)
-	cfg := &config.Config{
-		Name:       "myapp",
-		Server:     "testserver",
-		Stack:      "/stacks/myapp",
-		Dockerfile: "./Dockerfile",
-		Context:    ".",
-		// No Image field - custom build
+const testDigest = "sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+
+func newPinnedServices() (*config.Config, *config.Config, *Options) {
+	nginx := &config.Config{
+		Name:   "nginx",
+		Server: "testserver",
+		Stack:  "/stacks/site",
+		Image:  "nginx:1.27",
+		Deploy: &config.DeployConfig{Strategy: "rollout", PinDigest: true},
+	}
+	redis := &config.Config{
+		Name:   "redis",
+		Server: "testserver",
+		Stack:  "/stacks/site",
+		Image:  "redis:7",
+		Deploy: &config.DeployConfig{PinDigest: true},
 	}
+	opts := &Options{AllServices: map[string]*config.Config{"nginx": nginx, "redis": redis}}
+	return nginx, redis, opts
+}
+
+func TestDeploy_PinDigest_WritesDigestToManifest(t *testing.T) {
+	mockClient := new(MockDeployer)
+	nginx, redis, opts := newPinnedServices()
+	pinnedRedis := "redis@sha256:" + strings.Repeat("f", 64)
 
 	mockClient.On("StackExists").Return(true, nil)
 	mockClient.On("GetCurrentVersion").Return(0, nil)
 	mockClient.On("MakeTempDir").Return("/tmp/build", nil)
-	mockClient.On("Rsync", mock.Anything, "/tmp/build").Return(nil)
-	mockClient.On("BuildImage", "/tmp/build", 1).Return(nil)
-	mockClient.On("UpdateManifest", 1).Return(nil)
-	mockClient.On("RolloutService", "myapp").Return(nil)
+	mockClient.On("PullImage", "nginx:1.27").Return(nil)
+	mockClient.On("ImageDigest", "nginx:1.27").Return("nginx@"+testDigest, nil)
+	mockClient.On("ReadManifest").Return("x-ssd:\n  managed-by: ssd\nservices:\n  redis:\n    image: "+pinnedRedis+"\n", nil)
+	mockClient.On("CreateEnvFiles", mock.Anything).Return(nil)
+	mockClient.On("CreateStack", mock.MatchedBy(func(content string) bool {
+		return strings.Contains(content, "nginx@"+testDigest) &&
+			strings.Contains(content, pinnedRedis) &&
+			!strings.Contains(content, "nginx:1.27")
+	})).Return(nil)
+	mockClient.On("RolloutService", "nginx").Return(nil)
 	mockClient.On("Cleanup", "/tmp/build").Return(nil)
 
-	err := DeployWithClient(cfg, mockClient, nil)
+	err := DeployWithClient(nginx, mockClient, opts)
 
 	require.NoError(t, err)
-	mockClient.AssertCalled(t, "Rsync", mock.Anything, "/tmp/build")
-	mockClient.AssertCalled(t, "BuildImage", "/tmp/build", 1)
-	mockClient.AssertCalled(t, "UpdateManifest", 1)
-	mockClient.AssertNotCalled(t, "PullImage")
+	mockClient.AssertExpectations(t)
+	assert.Equal(t, "nginx:1.27", nginx.Image, "caller's config must not be modified")
+	assert.Equal(t, "redis:7", redis.Image, "caller's config must not be modified")
 }
 
-// Dependency tests
-
-func TestDeploy_DependencyNotRunning_Started(t *testing.T) {
+func TestDeploy_PinDigest_ResolveError(t *testing.T) {
 	mockClient := new(MockDeployer)
-	cfg := &config.Config{
-		Name:       "web",
-		Server:     "testserver",
-		Stack:      "/stacks/myapp",
-		Dockerfile: "./Dockerfile",
-		Context:    ".",
-		DependsOn:  config.Dependencies{{Name: "db"}},
-	}
+	nginx, _, opts := newPinnedServices()
 
 	mockClient.On("StackExists").Return(true, nil)
 	mockClient.On("GetCurrentVersion").Return(0, nil)
-
-	// Dependency check: db not running
-	mockClient.On("IsServiceRunning", "db").Return(false, nil)
-	mockClient.On("StartService", "db").Return(nil)
-
+	mockClient.On("MakeTempDir").Return("/tmp/build", nil)
+	mockClient.On("PullImage", "nginx:1.27").Return(nil)
+	mockClient.On("ImageDigest", "nginx:1.27").Return("", errors.New("no repo digest"))
+	mockClient.On("ReadManifest").Return("", nil)
+	mockClient.On("Cleanup", "/tmp/build").Return(nil)
+
+	err := DeployWithClient(nginx, mockClient, opts)
+
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "failed to resolve digest")
+	mockClient.AssertNotCalled(t, "CreateStack", mock.Anything)
+	mockClient.AssertNotCalled(t, "RolloutService", mock.Anything)
+}
+
+func TestDeploy_DigestImage_NotResolvedAgain(t *testing.T) {
+	mockClient := new(MockDeployer)
+	image := "nginx@" + testDigest
+	cfg := &config.Config{
+		Name:   "nginx",
+		Server: "testserver",
+		Stack:  "/stacks/nginx",
+		Image:  image,
+		Deploy: &config.DeployConfig{Strategy: "rollout", PinDigest: true},
+	}
+
+	mockClient.On("StackExists").Return(true, nil)
+	mockClient.On("GetCurrentVersion").Return(0, nil)
+	mockClient.On("MakeTempDir").Return("/tmp/build", nil)
+	mockClient.On("PullImage", image).Return(nil)
+	mockClient.On("RolloutService", "nginx").Return(nil)
+	mockClient.On("Cleanup", "/tmp/build").Return(nil)
+
+	err := DeployWithClient(cfg, mockClient, nil)
+
+	require.NoError(t, err)
+	mockClient.AssertNotCalled(t, "ImageDigest", mock.Anything)
+}
+
+func TestDeploy_ImageOverride_SkipsBuildAndKeepsVersion(t *testing.T) {
+	mockClient := new(MockDeployer)
+	web := &config.Config{
+		Name:       "web",
+		Server:     "testserver",
+		Stack:      "/stacks/shop",
+		Context:    ".",
+		Dockerfile: "Dockerfile",
+		Image:      "ghcr.io/org/web:ci-7",
+	}
+	opts := &Options{AllServices: map[string]*config.Config{"web": web}, ImageOverride: true}
+
+	mockClient.On("StackExists").Return(true, nil)
+	mockClient.On("GetCurrentVersion").Return(4, nil)
+	mockClient.On("MakeTempDir").Return("/tmp/build", nil)
+	mockClient.On("PullImage", "ghcr.io/org/web:ci-7").Return(nil)
+	mockClient.On("ReadManifest").Return("services:\n  web:\n    image: ssd-shop-web:4\n", nil)
+	mockClient.On("CreateEnvFiles", mock.Anything).Return(nil)
+	mockClient.On("CreateStack", mock.MatchedBy(func(content string) bool {
+		return strings.Contains(content, "image: ghcr.io/org/web:ci-7") &&
+			!strings.Contains(content, "ssd-shop-web")
+	})).Return(nil)
+	mockClient.On("RolloutService", "web").Return(nil)
+	mockClient.On("Cleanup", "/tmp/build").Return(nil)
+
+	res, err := DeployWithResult(web, mockClient, opts)
+
+	require.NoError(t, err)
+	mockClient.AssertExpectations(t)
+	mockClient.AssertNotCalled(t, "Rsync", mock.Anything, mock.Anything)
+	mockClient.AssertNotCalled(t, "BuildImage", mock.Anything, mock.Anything)
+	assert.Equal(t, 4, res.OldVersion)
+	assert.Equal(t, 4, res.NewVersion, "--image must not bump the version")
+}
+
+func TestDeploy_KeepsExternalImageOfOtherService(t *testing.T) {
+	mockClient := new(MockDeployer)
+	web := &config.Config{Name: "web", Server: "testserver", Stack: "/stacks/shop", Context: ".", Dockerfile: "Dockerfile"}
+	api := &config.Config{Name: "api", Server: "testserver", Stack: "/stacks/shop", Context: ".", Dockerfile: "Dockerfile"}
+	opts := &Options{AllServices: map[string]*config.Config{"web": web, "api": api}}
+	existing := "services:\n  api:\n    image: ssd-shop-api:2\n  web:\n    image: ghcr.io/org/web:ci-7\n"
+
+	mockClient.On("StackExists").Return(true, nil)
+	mockClient.On("GetCurrentVersion").Return(2, nil)
+	mockClient.On("MakeTempDir").Return("/tmp/build", nil)
+	mockClient.On("Rsync", mock.Anything, "/tmp/build").Return(nil)
+	mockClient.On("BuildImage", "/tmp/build", 3).Return(nil)
+	mockClient.On("ReadManifest").Return(existing, nil)
+	mockClient.On("CreateEnvFiles", mock.Anything).Return(nil)
+	mockClient.On("CreateStack", mock.MatchedBy(func(content string) bool {
+		return strings.Contains(content, "image: ssd-shop-api:3") &&
+			strings.Contains(content, "image: ghcr.io/org/web:ci-7")
+	})).Return(nil)
+	mockClient.On("RolloutService", "api").Return(nil)
+	mockClient.On("Cleanup", "/tmp/build").Return(nil)
+
+	err := DeployWithClient(api, mockClient, opts)
+
+	require.NoError(t, err)
+	mockClient.AssertExpectations(t)
+}
+
+func TestDeploy_BuildReplacesExternalImage(t *testing.T) {
+	mockClient := new(MockDeployer)
+	web := &config.Config{Name: "web", Server: "testserver", Stack: "/stacks/shop", Context: ".", Dockerfile: "Dockerfile"}
+	opts := &Options{AllServices: map[string]*config.Config{"web": web}}
+
+	mockClient.On("StackExists").Return(true, nil)
+	mockClient.On("GetCurrentVersion").Return(0, nil)
+	mockClient.On("MakeTempDir").Return("/tmp/build", nil)
+	mockClient.On("Rsync", mock.Anything, "/tmp/build").Return(nil)
+	mockClient.On("BuildImage", "/tmp/build", 1).Return(nil)
+	mockClient.On("ReadManifest").Return("x-ssd:\n  managed-by: ssd\nservices:\n  web:\n    image: ghcr.io/org/web:ci-7\n", nil)
+	mockClient.On("CreateEnvFiles", mock.Anything).Return(nil)
+	mockClient.On("CreateStack", mock.MatchedBy(func(content string) bool {
+		return strings.Contains(content, "image: ssd-shop-web:1") &&
+			!strings.Contains(content, "ci-7")
+	})).Return(nil)
+	mockClient.On("RolloutService", "web").Return(nil)
+	mockClient.On("Cleanup", "/tmp/build").Return(nil)
+
+	err := DeployWithClient(web, mockClient, opts)
+
+	require.NoError(t, err)
+	mockClient.AssertExpectations(t)
+}
+
+func TestManifestImages(t *testing.T) {
+	composeYAML := "services:\n  web:\n    image: ghcr.io/org/web:1\n  db:\n    image: postgres:16\n"
+	assert.Equal(t, map[string]string{"web": "ghcr.io/org/web:1", "db": "postgres:16"}, manifestImages("compose", composeYAML))
+
+	k3sYAML := "apiVersion: v1\nkind: Namespace\nmetadata:\n  name: shop\n---\n" +
+		"apiVersion: apps/v1\nkind: Deployment\nmetadata:\n  name: web\nspec:\n  template:\n    spec:\n      containers:\n        - name: web\n          image: ghcr.io/org/web:1\n"
+	assert.Equal(t, map[string]string{"web": "ghcr.io/org/web:1"}, manifestImages("k3s", k3sYAML))
+
+	assert.Empty(t, manifestImages("compose", ""))
+	assert.Empty(t, manifestImages("compose", "not: [valid"))
+}
+
+func TestDeploy_ManualStart_BuildsButDoesNotStart(t *testing.T) {
+	mockClient := new(MockDeployer)
+	manual := false
+	cfg := &config.Config{
+		Name:       "backup",
+		Server:     "testserver",
+		Stack:      "/stacks/shop",
+		Context:    ".",
+		Dockerfile: "Dockerfile",
+		Restart:    &manual,
+	}
+
+	mockClient.On("StackExists").Return(true, nil)
+	mockClient.On("GetCurrentVersion").Return(1, nil)
+	mockClient.On("MakeTempDir").Return("/tmp/build", nil)
+	mockClient.On("Rsync", mock.Anything, "/tmp/build").Return(nil)
+	mockClient.On("BuildImage", "/tmp/build", 2).Return(nil)
+	mockClient.On("UpdateManifest", 2).Return(nil)
+	mockClient.On("Cleanup", "/tmp/build").Return(nil)
+
+	res, err := DeployWithResult(cfg, mockClient, nil)
+
+	require.NoError(t, err)
+	mockClient.AssertExpectations(t)
+	mockClient.AssertNotCalled(t, "StartService", mock.Anything)
+	mockClient.AssertNotCalled(t, "RolloutService", mock.Anything)
+	assert.Equal(t, "manual", res.Strategy)
+	assert.Equal(t, 2, res.NewVersion)
+}
+
+func TestDeploy_ManualStartDependencyNotStarted(t *testing.T) {
+	mockClient := new(MockDeployer)
+	manual := false
+	backup := &config.Config{Name: "backup", Server: "testserver", Stack: "/stacks/shop", Image: "restic/restic:0.17", Restart: &manual}
+	web := &config.Config{
+		Name:      "web",
+		Server:    "testserver",
+		Stack:     "/stacks/shop",
+		Image:     "nginx:1.27",
+		DependsOn: config.Dependencies{{Name: "backup"}},
+	}
+	opts := &Options{Dependencies: map[string]*config.Config{"backup": backup}}
+
+	mockClient.On("StackExists").Return(true, nil)
+	mockClient.On("GetCurrentVersion").Return(0, nil)
+	mockClient.On("MakeTempDir").Return("/tmp/build", nil)
+	mockClient.On("PullImage", "nginx:1.27").Return(nil)
+	mockClient.On("RolloutService", "web").Return(nil)
+	mockClient.On("Cleanup", "/tmp/build").Return(nil)
+
+	err := DeployWithClient(web, mockClient, opts)
+
+	require.NoError(t, err)
+	mockClient.AssertExpectations(t)
+	mockClient.AssertNotCalled(t, "IsServiceRunning", "backup")
+	mockClient.AssertNotCalled(t, "StartService", "backup")
+	mockClient.AssertNotCalled(t, "PullImage", "restic/restic:0.17")
+}
+
+func TestImageRepo(t *testing.T) {
+	tests := map[string]string{
+		"nginx":                  "nginx",
+		"nginx:1.27":             "nginx",
+		"registry:5000/app":      "registry:5000/app",
+		"registry:5000/app:2":    "registry:5000/app",
+		"ghcr.io/org/app:latest": "ghcr.io/org/app",
+	}
+	for image, want := range tests {
+		assert.Equal(t, want, imageRepo(image), image)
+	}
+}
+
+func TestDeploy_BuiltService_BuildsImage(t *testing.T) {
+	mockClient := new(MockDeployer)
+	cfg := &config.Config{
+		Name:       "myapp",
+		Server:     "testserver",
+		Stack:      "/stacks/myapp",
+		Dockerfile: "./Dockerfile",
+		Context:    ".",
+		// No Image field - custom build
+	}
+
+	mockClient.On("StackExists").Return(true, nil)
+	mockClient.On("GetCurrentVersion").Return(0, nil)
+	mockClient.On("MakeTempDir").Return("/tmp/build", nil)
+	mockClient.On("Rsync", mock.Anything, "/tmp/build").Return(nil)
+	mockClient.On("BuildImage", "/tmp/build", 1).Return(nil)
+	mockClient.On("UpdateManifest", 1).Return(nil)
+	mockClient.On("RolloutService", "myapp").Return(nil)
+	mockClient.On("Cleanup", "/tmp/build").Return(nil)
+
+	err := DeployWithClient(cfg, mockClient, nil)
+
+	require.NoError(t, err)
+	mockClient.AssertCalled(t, "Rsync", mock.Anything, "/tmp/build")
+	mockClient.AssertCalled(t, "BuildImage", "/tmp/build", 1)
+	mockClient.AssertCalled(t, "UpdateManifest", 1)
+	mockClient.AssertNotCalled(t, "PullImage")
+}
+
+// Dependency tests
+
+func TestDeploy_DependencyNotRunning_Started(t *testing.T) {
+	mockClient := new(MockDeployer)
+	cfg := &config.Config{
+		Name:       "web",
+		Server:     "testserver",
+		Stack:      "/stacks/myapp",
+		Dockerfile: "./Dockerfile",
+		Context:    ".",
+		DependsOn:  config.Dependencies{{Name: "db"}},
+	}
+
+	mockClient.On("StackExists").Return(true, nil)
+	mockClient.On("GetCurrentVersion").Return(0, nil)
+
+	// Dependency check: db not running
+	mockClient.On("IsServiceRunning", "db").Return(false, nil)
+	mockClient.On("StartService", "db").Return(nil)
+
 	mockClient.On("MakeTempDir").Return("/tmp/build", nil)
 	mockClient.On("Rsync", mock.Anything, "/tmp/build").Return(nil)
 	mockClient.On("BuildImage", "/tmp/build", 1).Return(nil)
@@ -1268,6 +2112,80 @@ func TestDeploy_NoDependencies_SkipsDependencyChecks(t *testing.T) {
 	mockClient.AssertCalled(t, "RolloutService", "web") // Main service is rolled out
 }
 
+func TestDeploy_NoDeps_SkipsDependencyHandling(t *testing.T) {
+	mockClient := new(MockDeployer)
+	cfg := &config.Config{
+		Name:       "web",
+		Server:     "testserver",
+		Stack:      "/stacks/myapp",
+		Dockerfile: "./Dockerfile",
+		Context:    ".",
+		DependsOn:  config.Dependencies{{Name: "postgres"}, {Name: "redis"}},
+	}
+	opts := &Options{
+		NoDeps: true,
+		Dependencies: map[string]*config.Config{
+			"postgres": {Name: "postgres", Image: "postgres:16"},
+		},
+	}
+
+	mockClient.On("StackExists").Return(true, nil)
+	mockClient.On("GetCurrentVersion").Return(0, nil)
+	mockClient.On("MakeTempDir").Return("/tmp/build", nil)
+	mockClient.On("Rsync", mock.Anything, "/tmp/build").Return(nil)
+	mockClient.On("BuildImage", "/tmp/build", 1).Return(nil)
+	mockClient.On("UpdateManifest", 1).Return(nil)
+	mockClient.On("RolloutService", "web").Return(nil)
+	mockClient.On("Cleanup", "/tmp/build").Return(nil)
+
+	err := DeployWithClient(cfg, mockClient, opts)
+
+	require.NoError(t, err)
+	mockClient.AssertNotCalled(t, "IsServiceRunning", mock.Anything)
+	mockClient.AssertNotCalled(t, "PullImage", mock.Anything)
+	mockClient.AssertNotCalled(t, "StartService", mock.Anything)
+	mockClient.AssertCalled(t, "RolloutService", "web")
+}
+
+func TestDeploy_RecreateDeps_StartsRunningDependencies(t *testing.T) {
+	mockClient := new(MockDeployer)
+	cfg := &config.Config{
+		Name:       "web",
+		Server:     "testserver",
+		Stack:      "/stacks/myapp",
+		Dockerfile: "./Dockerfile",
+		Context:    ".",
+		DependsOn:  config.Dependencies{{Name: "postgres"}, {Name: "redis"}},
+	}
+	opts := &Options{
+		RecreateDeps: true,
+		Dependencies: map[string]*config.Config{
+			"postgres": {Name: "postgres", Image: "postgres:16"},
+		},
+	}
+
+	mockClient.On("StackExists").Return(true, nil)
+	mockClient.On("GetCurrentVersion").Return(0, nil)
+	mockClient.On("IsServiceRunning", mock.Anything).Return(true, nil)
+	mockClient.On("PullImage", "postgres:16").Return(nil)
+	mockClient.On("StartService", "postgres").Return(nil)
+	mockClient.On("StartService", "redis").Return(nil)
+	mockClient.On("MakeTempDir").Return("/tmp/build", nil)
+	mockClient.On("Rsync", mock.Anything, "/tmp/build").Return(nil)
+	mockClient.On("BuildImage", "/tmp/build", 1).Return(nil)
+	mockClient.On("UpdateManifest", 1).Return(nil)
+	mockClient.On("RolloutService", "web").Return(nil)
+	mockClient.On("Cleanup", "/tmp/build").Return(nil)
+
+	err := DeployWithClient(cfg, mockClient, opts)
+
+	require.NoError(t, err)
+	mockClient.AssertCalled(t, "StartService", "postgres")
+	mockClient.AssertCalled(t, "StartService", "redis")
+	mockClient.AssertCalled(t, "PullImage", "postgres:16")
+	mockClient.AssertCalled(t, "RolloutService", "web")
+}
+
 func TestDeploy_PrebuiltDependency_PullsImage(t *testing.T) {
 	mockClient := new(MockDeployer)
 	cfg := &config.Config{
@@ -2166,7 +3084,7 @@ func TestDeploy_K3s_RegeneratesManifests_PreservesVersions(t *testing.T) {
 	mockClient.On("BuildImage", "/tmp/build", 6).Return(nil)
 
 	// Existing manifests have web at version 10
-	mockClient.On("ReadManifest").Return("image: ssd-myproject-api:5\nimage: ssd-myproject-web:10\n", nil)
+	mockClient.On("ReadManifest").Return("managed-by: ssd\nimage: ssd-myproject-api:5\nimage: ssd-myproject-web:10\n", nil)
 	mockClient.On("CreateEnvFiles", mock.Anything).Return(nil)
 	mockClient.On("CreateStack", mock.MatchedBy(func(content string) bool {
 		// api bumped to 6, web stays at 10; must be K8s manifests
@@ -2300,25 +3218,112 @@ func TestDeploy_K3s_RecreateStrategy(t *testing.T) {
 	mockClient.AssertNotCalled(t, "RolloutService")
 }
 
-// K3s rollback tests
-
-func TestRollback_K3s_Success(t *testing.T) {
+func TestDeploy_NoneStrategyStartsWithoutRollout(t *testing.T) {
 	mockClient := new(MockDeployer)
-	cfg := newK3sTestConfig()
+	cfg := newTestConfig()
+	cfg.Deploy = &config.DeployConfig{Strategy: "none"}
+	opts := &Options{AllServices: map[string]*config.Config{"myapp": cfg}}
 
-	mockClient.On("GetCurrentVersion").Return(5, nil)
-	mockClient.On("UpdateManifest", 4).Return(nil)
-	mockClient.On("StartService", "web").Return(nil)
+	mockClient.On("StackExists").Return(true, nil)
+	mockClient.On("GetCurrentVersion").Return(1, nil)
+	mockClient.On("MakeTempDir").Return("/tmp/build", nil)
+	mockClient.On("Rsync", mock.Anything, "/tmp/build").Return(nil)
+	mockClient.On("BuildImage", "/tmp/build", 2).Return(nil)
+	mockClient.On("ReadManifest").Return("", nil)
+	mockClient.On("CreateEnvFiles", mock.Anything).Return(nil)
+	mockClient.On("CreateStack", mock.AnythingOfType("string")).Return(nil)
+	mockClient.On("StartService", "myapp").Return(nil)
+	mockClient.On("Cleanup", "/tmp/build").Return(nil)
 
-	err := RollbackWithClient(cfg, mockClient, &Options{Runtime: "k3s"})
+	res, err := DeployWithResult(cfg, mockClient, opts)
 
 	require.NoError(t, err)
-	mockClient.AssertExpectations(t)
+	assert.Equal(t, "none", res.Strategy)
+	mockClient.AssertCalled(t, "StartService", "myapp")
+	mockClient.AssertNotCalled(t, "RolloutService", mock.Anything)
 }
 
-func TestRollback_K3s_CannotRollbackVersion1(t *testing.T) {
+func TestDeploy_RolloutStrategyWithAllServices(t *testing.T) {
 	mockClient := new(MockDeployer)
-	cfg := newK3sTestConfig()
+	cfg := newTestConfig()
+	cfg.Deploy = &config.DeployConfig{Strategy: "rollout"}
+	opts := &Options{AllServices: map[string]*config.Config{"myapp": cfg}}
+
+	mockClient.On("StackExists").Return(true, nil)
+	mockClient.On("GetCurrentVersion").Return(1, nil)
+	mockClient.On("MakeTempDir").Return("/tmp/build", nil)
+	mockClient.On("Rsync", mock.Anything, "/tmp/build").Return(nil)
+	mockClient.On("BuildImage", "/tmp/build", 2).Return(nil)
+	mockClient.On("ReadManifest").Return("", nil)
+	mockClient.On("CreateEnvFiles", mock.Anything).Return(nil)
+	mockClient.On("CreateStack", mock.AnythingOfType("string")).Return(nil)
+	mockClient.On("RolloutService", "myapp").Return(nil)
+	mockClient.On("Cleanup", "/tmp/build").Return(nil)
+
+	err := DeployWithClient(cfg, mockClient, opts)
+
+	require.NoError(t, err)
+	mockClient.AssertCalled(t, "RolloutService", "myapp")
+	mockClient.AssertNotCalled(t, "StartService", mock.Anything)
+}
+
+func TestStart_UsesDeployStrategy(t *testing.T) {
+	tests := []struct {
+		strategy string
+		call     string
+	}{
+		{"", "RolloutService"},
+		{"rollout", "RolloutService"},
+		{"recreate", "StartService"},
+		{"none", "StartService"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.strategy, func(t *testing.T) {
+			mockClient := new(MockDeployer)
+			cfg := newTestConfig()
+			if tt.strategy != "" {
+				cfg.Deploy = &config.DeployConfig{Strategy: tt.strategy}
+			}
+			mockClient.On(tt.call, "myapp").Return(nil)
+
+			err := Start(context.Background(), mockClient, cfg)
+
+			require.NoError(t, err)
+			mockClient.AssertExpectations(t)
+		})
+	}
+}
+
+func TestStart_WrapsError(t *testing.T) {
+	mockClient := new(MockDeployer)
+	cfg := newTestConfig()
+	mockClient.On("RolloutService", "myapp").Return(errors.New("rollout plugin missing"))
+
+	err := Start(context.Background(), mockClient, cfg)
+
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "failed to rollout service: rollout plugin missing")
+}
+
+// K3s rollback tests
+
+func TestRollback_K3s_Success(t *testing.T) {
+	mockClient := new(MockDeployer)
+	cfg := newK3sTestConfig()
+
+	mockClient.On("GetCurrentVersion").Return(5, nil)
+	mockClient.On("UpdateManifest", 4).Return(nil)
+	mockClient.On("StartService", "web").Return(nil)
+
+	err := RollbackWithClient(cfg, mockClient, &Options{Runtime: "k3s"})
+
+	require.NoError(t, err)
+	mockClient.AssertExpectations(t)
+}
+
+func TestRollback_K3s_CannotRollbackVersion1(t *testing.T) {
+	mockClient := new(MockDeployer)
+	cfg := newK3sTestConfig()
 
 	mockClient.On("GetCurrentVersion").Return(1, nil)
 
@@ -2488,3 +3493,784 @@ func TestDeploy_EnvFile_NotSet_NoUpload(t *testing.T) {
 	require.NoError(t, DeployWithClient(cfg, mockClient, nil))
 	mockClient.AssertNotCalled(t, "UploadEnvFile", mock.Anything, mock.Anything)
 }
+
+func TestDeploy_CustomProject_FirstDeployUsesProjectNetwork(t *testing.T) {
+	mockClient := new(MockDeployer)
+	cfg := newTestConfig()
+	cfg.Stack = "/a/web"
+	cfg.Project = "shop"
+
+	mockClient.On("StackExists").Return(false, nil)
+	mockClient.On("CreateEnvFiles", mock.Anything).Return(nil)
+	mockClient.On("CreateStack", mock.MatchedBy(func(content string) bool {
+		return strings.Contains(content, "name: shop") &&
+			strings.Contains(content, "shop_internal") &&
+			strings.Contains(content, "ssd-shop-myapp:0")
+	})).Return(nil)
+	mockClient.On("EnsureNetwork", "shop_internal").Return(nil)
+	mockClient.On("GetCurrentVersion").Return(0, nil)
+	mockClient.On("MakeTempDir").Return("/tmp/build", nil)
+	mockClient.On("Rsync", mock.Anything, "/tmp/build").Return(nil)
+	mockClient.On("BuildImage", "/tmp/build", 1).Return(nil)
+	mockClient.On("UpdateManifest", 1).Return(nil)
+	mockClient.On("RolloutService", "myapp").Return(nil)
+	mockClient.On("Cleanup", "/tmp/build").Return(nil)
+
+	err := DeployWithClient(cfg, mockClient, nil)
+
+	require.NoError(t, err)
+	mockClient.AssertExpectations(t)
+}
+
+func TestParseServiceVersions_CustomProject(t *testing.T) {
+	services := map[string]*config.Config{
+		"web": {Name: "web", Stack: "/a/web", Project: "shop"},
+		"api": {Name: "api", Stack: "/a/web", Project: "shop"},
+	}
+	content := "services:\n  web:\n    image: ssd-shop-web:3\n  api:\n    image: ssd-web-api:8\n"
+
+	versions := parseServiceVersions(content, "/a/web", services)
+
+	assert.Equal(t, 3, versions["web"])
+	assert.Equal(t, 0, versions["api"], "stack-derived tags must not match when project is set")
+}
+
+func TestParseServiceVersions_ImageTemplate(t *testing.T) {
+	services := map[string]*config.Config{
+		"web": {Name: "web", Stack: "/stacks/shop", ImageTemplate: "registry.example.com/{project}/{service}"},
+		"api": {Name: "api", Stack: "/stacks/shop", ImageTemplate: "registry.example.com/{project}/{service}"},
+	}
+	content, err := compose.GenerateCompose(services, "/stacks/shop", map[string]int{"web": 3, "api": 8})
+	require.NoError(t, err)
+	assert.Contains(t, content, "image: registry.example.com/shop/web:3")
+
+	versions := parseServiceVersions(content, "/stacks/shop", services)
+
+	assert.Equal(t, map[string]int{"web": 3, "api": 8}, versions)
+	assert.Empty(t, parseExternalImages("compose", content, "/stacks/shop", services))
+}
+
+type recordingHistory struct {
+	service string
+	version int
+	err     error
+}
+
+func (r *recordingHistory) AppendHistory(ctx context.Context, serviceName string, version int) error {
+	r.service, r.version = serviceName, version
+	return r.err
+}
+
+func TestDeploy_RecordsHistory(t *testing.T) {
+	mockClient := new(MockDeployer)
+	cfg := newTestConfig()
+
+	mockClient.On("StackExists").Return(true, nil)
+	mockClient.On("GetCurrentVersion").Return(2, nil)
+	mockClient.On("MakeTempDir").Return("/tmp/build", nil)
+	mockClient.On("Rsync", mock.Anything, "/tmp/build").Return(nil)
+	mockClient.On("BuildImage", "/tmp/build", 3).Return(nil)
+	mockClient.On("UpdateManifest", 3).Return(nil)
+	mockClient.On("RolloutService", "myapp").Return(nil)
+	mockClient.On("Cleanup", "/tmp/build").Return(nil)
+
+	rec := &recordingHistory{err: errors.New("disk full")}
+	err := DeployWithClient(cfg, mockClient, &Options{History: rec})
+
+	require.NoError(t, err, "history failures must not fail the deploy")
+	assert.Equal(t, "myapp", rec.service)
+	assert.Equal(t, 3, rec.version)
+}
+
+type recordingScheduler struct {
+	calls int
+	err   error
+}
+
+func (r *recordingScheduler) SyncSchedule(ctx context.Context) error {
+	r.calls++
+	return r.err
+}
+
+func TestDeploy_SyncsScheduleForManualService(t *testing.T) {
+	mockClient := new(MockDeployer)
+	cfg := newTestConfig()
+	manual := false
+	cfg.Restart = &manual
+	cfg.Schedule = "0 3 * * *"
+
+	mockClient.On("StackExists").Return(true, nil)
+	mockClient.On("GetCurrentVersion").Return(2, nil)
+	mockClient.On("MakeTempDir").Return("/tmp/build", nil)
+	mockClient.On("Rsync", mock.Anything, "/tmp/build").Return(nil)
+	mockClient.On("BuildImage", "/tmp/build", 3).Return(nil)
+	mockClient.On("UpdateManifest", 3).Return(nil)
+	mockClient.On("Cleanup", "/tmp/build").Return(nil)
+
+	sched := &recordingScheduler{}
+	var buf bytes.Buffer
+	err := DeployWithClient(cfg, mockClient, &Options{Scheduler: sched, Output: &buf})
+
+	require.NoError(t, err)
+	assert.Equal(t, 1, sched.calls)
+	assert.Contains(t, buf.String(), "Scheduling myapp (0 3 * * *)")
+	mockClient.AssertNotCalled(t, "RolloutService", mock.Anything)
+}
+
+func TestDeploy_ScheduleSyncErrorFailsDeploy(t *testing.T) {
+	mockClient := new(MockDeployer)
+	cfg := newTestConfig()
+
+	mockClient.On("StackExists").Return(true, nil)
+	mockClient.On("GetCurrentVersion").Return(2, nil)
+	mockClient.On("MakeTempDir").Return("/tmp/build", nil)
+	mockClient.On("Rsync", mock.Anything, "/tmp/build").Return(nil)
+	mockClient.On("BuildImage", "/tmp/build", 3).Return(nil)
+	mockClient.On("UpdateManifest", 3).Return(nil)
+	mockClient.On("RolloutService", "myapp").Return(nil)
+	mockClient.On("Cleanup", "/tmp/build").Return(nil)
+
+	sched := &recordingScheduler{err: errors.New("sudo: a password is required")}
+	rec := &recordingHistory{}
+	err := DeployWithClient(cfg, mockClient, &Options{Scheduler: sched, History: rec})
+
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "failed to sync schedule")
+	assert.Empty(t, rec.service, "a failed deploy is not recorded")
+}
+
+type fakeSources struct {
+	tree       string
+	deployed   string
+	recorded   string
+	changes    []string
+	changesErr error
+}
+
+// sourceRecord is what a deploy of cfg built from tree records, without
+// --build-arg.
+func sourceRecord(cfg *config.Config, tree string) string {
+	return Result{SourceTree: tree, ConfigDigest: ConfigDigest(cfg, nil)}.SourceRecord()
+}
+
+func (f *fakeSources) SourceTree(ctx context.Context) (string, error) {
+	return f.tree, nil
+}
+
+func (f *fakeSources) UncommittedChanges(ctx context.Context) ([]string, error) {
+	return f.changes, f.changesErr
+}
+
+func (f *fakeSources) DeployedSourceTree(ctx context.Context, serviceName string) (string, error) {
+	return f.deployed, nil
+}
+
+func (f *fakeSources) RecordSourceTree(ctx context.Context, serviceName, tree string) error {
+	f.recorded = tree
+	return nil
+}
+
+func TestDeploy_UncommittedChanges_Warns(t *testing.T) {
+	mockClient := new(MockDeployer)
+	cfg := newTestConfig()
+	sources := &fakeSources{changes: []string{" M main.go", "?? notes.txt"}}
+
+	mockClient.On("StackExists").Return(true, nil)
+	mockClient.On("GetCurrentVersion").Return(2, nil)
+	mockClient.On("MakeTempDir").Return("/tmp/build", nil)
+	mockClient.On("Rsync", mock.Anything, "/tmp/build").Return(nil)
+	mockClient.On("BuildImage", "/tmp/build", 3).Return(nil)
+	mockClient.On("UpdateManifest", 3).Return(nil)
+	mockClient.On("RolloutService", "myapp").Return(nil)
+	mockClient.On("Cleanup", "/tmp/build").Return(nil)
+
+	var out bytes.Buffer
+	err := DeployWithClient(cfg, mockClient, &Options{Output: &out, Sources: sources})
+
+	require.NoError(t, err)
+	assert.Contains(t, out.String(), "Warning: 2 uncommitted change(s) in . won't be deployed")
+	assert.Contains(t, out.String(), "    ?? notes.txt\n")
+	mockClient.AssertCalled(t, "Rsync", mock.Anything, "/tmp/build")
+}
+
+func TestDeploy_UncommittedChanges_CleanTreeIsQuiet(t *testing.T) {
+	mockClient := new(MockDeployer)
+	cfg := newTestConfig()
+
+	mockClient.On("StackExists").Return(true, nil)
+	mockClient.On("GetCurrentVersion").Return(2, nil)
+	mockClient.On("MakeTempDir").Return("/tmp/build", nil)
+	mockClient.On("Rsync", mock.Anything, "/tmp/build").Return(nil)
+	mockClient.On("BuildImage", "/tmp/build", 3).Return(nil)
+	mockClient.On("UpdateManifest", 3).Return(nil)
+	mockClient.On("RolloutService", "myapp").Return(nil)
+	mockClient.On("Cleanup", "/tmp/build").Return(nil)
+
+	var out bytes.Buffer
+	err := DeployWithClient(cfg, mockClient, &Options{Output: &out, Sources: &fakeSources{}})
+
+	require.NoError(t, err)
+	assert.NotContains(t, out.String(), "uncommitted")
+}
+
+func TestDeploy_UncommittedChanges_StrictFails(t *testing.T) {
+	mockClient := new(MockDeployer)
+	cfg := newTestConfig()
+	sources := &fakeSources{changes: []string{" M main.go"}}
+
+	err := DeployWithClient(cfg, mockClient, &Options{Sources: sources, Strict: true})
+
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "1 uncommitted change(s) in . would not be deployed")
+	mockClient.AssertNotCalled(t, "StackExists")
+	mockClient.AssertNotCalled(t, "Rsync", mock.Anything, mock.Anything)
+}
+
+func TestDeploy_UncommittedChanges_ListIsCapped(t *testing.T) {
+	var out bytes.Buffer
+	changes := make([]string, maxListedChanges+3)
+	for i := range changes {
+		changes[i] = fmt.Sprintf("?? file%d", i)
+	}
+
+	err := checkUncommitted(context.Background(), &fakeSources{changes: changes}, newTestConfig(), false, &out)
+
+	require.NoError(t, err)
+	assert.Contains(t, out.String(), "... and 3 more")
+	assert.NotContains(t, out.String(), fmt.Sprintf("file%d", maxListedChanges))
+}
+
+func TestDeploy_UncommittedChanges_ReadErrorIsWarnOnly(t *testing.T) {
+	var out bytes.Buffer
+	err := checkUncommitted(context.Background(), &fakeSources{changesErr: errors.New("not a git repository")}, newTestConfig(), true, &out)
+
+	require.NoError(t, err)
+	assert.Contains(t, out.String(), "Warning: cannot check for uncommitted changes")
+}
+
+func TestDeploy_UncommittedChanges_SkippedForPrebuilt(t *testing.T) {
+	var out bytes.Buffer
+	mockClient := new(MockDeployer)
+	cfg := &config.Config{Name: "nginx", Server: "testserver", Stack: "/stacks/nginx", Image: "nginx:latest"}
+
+	mockClient.On("StackExists").Return(true, nil)
+	mockClient.On("GetCurrentVersion").Return(0, nil)
+	mockClient.On("MakeTempDir").Return("/tmp/build", nil)
+	mockClient.On("PullImage", "nginx:latest").Return(nil)
+	mockClient.On("RolloutService", "nginx").Return(nil)
+	mockClient.On("Cleanup", "/tmp/build").Return(nil)
+
+	err := DeployWithClient(cfg, mockClient, &Options{Output: &out, Sources: &fakeSources{changes: []string{" M x"}}, Strict: true})
+
+	require.NoError(t, err)
+	assert.NotContains(t, out.String(), "uncommitted")
+}
+
+func newSkipUnchangedConfig() *config.Config {
+	cfg := newTestConfig()
+	cfg.Deploy = &config.DeployConfig{Strategy: "rollout", SkipUnchanged: true}
+	return cfg
+}
+
+func TestDeploy_SkipUnchanged_SameTreeSkips(t *testing.T) {
+	mockClient := new(MockDeployer)
+	cfg := newSkipUnchangedConfig()
+	sources := &fakeSources{tree: "abc", deployed: sourceRecord(cfg, "abc")}
+
+	mockClient.On("StackExists").Return(true, nil)
+	mockClient.On("GetCurrentVersion").Return(3, nil)
+
+	res, err := DeployWithResult(cfg, mockClient, &Options{Sources: sources})
+
+	require.NoError(t, err)
+	assert.True(t, res.Unchanged)
+	assert.Equal(t, 3, res.OldVersion)
+	assert.Equal(t, 3, res.NewVersion)
+	assert.Contains(t, res.Summary(), "3 (unchanged)")
+	mockClient.AssertNotCalled(t, "MakeTempDir")
+	mockClient.AssertNotCalled(t, "BuildImage", mock.Anything, mock.Anything)
+	mockClient.AssertNotCalled(t, "RolloutService", mock.Anything)
+	assert.Empty(t, sources.recorded)
+}
+
+func TestDeploy_SkipUnchanged_ChangedTreeDeploysAndRecords(t *testing.T) {
+	mockClient := new(MockDeployer)
+	cfg := newSkipUnchangedConfig()
+	sources := &fakeSources{tree: "def", deployed: "abc"}
+
+	mockClient.On("StackExists").Return(true, nil)
+	mockClient.On("GetCurrentVersion").Return(3, nil)
+	mockClient.On("MakeTempDir").Return("/tmp/build", nil)
+	mockClient.On("Rsync", mock.Anything, "/tmp/build").Return(nil)
+	mockClient.On("BuildImage", "/tmp/build", 4).Return(nil)
+	mockClient.On("UpdateManifest", 4).Return(nil)
+	mockClient.On("RolloutService", "myapp").Return(nil)
+	mockClient.On("Cleanup", "/tmp/build").Return(nil)
+
+	res, err := DeployWithResult(cfg, mockClient, &Options{Sources: sources})
+
+	require.NoError(t, err)
+	assert.False(t, res.Unchanged)
+	assert.Equal(t, 4, res.NewVersion)
+	assert.Equal(t, sourceRecord(cfg, "def"), sources.recorded)
+	mockClient.AssertExpectations(t)
+}
+
+func TestDeploy_SkipUnchanged_ForceDeploys(t *testing.T) {
+	mockClient := new(MockDeployer)
+	cfg := newSkipUnchangedConfig()
+	sources := &fakeSources{tree: "abc", deployed: sourceRecord(cfg, "abc")}
+
+	mockClient.On("StackExists").Return(true, nil)
+	mockClient.On("GetCurrentVersion").Return(3, nil)
+	mockClient.On("MakeTempDir").Return("/tmp/build", nil)
+	mockClient.On("Rsync", mock.Anything, "/tmp/build").Return(nil)
+	mockClient.On("BuildImage", "/tmp/build", 4).Return(nil)
+	mockClient.On("UpdateManifest", 4).Return(nil)
+	mockClient.On("RolloutService", "myapp").Return(nil)
+	mockClient.On("Cleanup", "/tmp/build").Return(nil)
+
+	res, err := DeployWithResult(cfg, mockClient, &Options{Sources: sources, Force: true})
+
+	require.NoError(t, err)
+	assert.False(t, res.Unchanged)
+	assert.Equal(t, sourceRecord(cfg, "abc"), sources.recorded)
+	mockClient.AssertExpectations(t)
+}
+
+func TestDeploy_SkipUnchanged_BuildArgDeploys(t *testing.T) {
+	mockClient := new(MockDeployer)
+	cfg := newSkipUnchangedConfig()
+	args := map[string]string{"BUILD_NUMBER": "42"}
+	// Recorded by an earlier deploy with the same --build-arg
+	deployed := Result{SourceTree: "abc", ConfigDigest: ConfigDigest(cfg, args)}.SourceRecord()
+	sources := &fakeSources{tree: "abc", deployed: deployed}
+
+	mockClient.On("StackExists").Return(true, nil)
+	mockClient.On("GetCurrentVersion").Return(3, nil)
+	mockClient.On("MakeTempDir").Return("/tmp/build", nil)
+	mockClient.On("Rsync", mock.Anything, "/tmp/build").Return(nil)
+	mockClient.On("BuildImage", "/tmp/build", 4).Return(nil)
+	mockClient.On("UpdateManifest", 4).Return(nil)
+	mockClient.On("RolloutService", "myapp").Return(nil)
+	mockClient.On("Cleanup", "/tmp/build").Return(nil)
+
+	opts := &Options{Sources: sources, Run: remote.RunOptions{BuildArgs: args}}
+	res, err := DeployWithResult(cfg, mockClient, opts)
+
+	require.NoError(t, err)
+	assert.False(t, res.Unchanged, "--build-arg must bypass skip_unchanged")
+	assert.Equal(t, deployed, sources.recorded)
+	mockClient.AssertExpectations(t)
+}
+
+func TestDeploy_SkipUnchanged_ChangedConfigBuilds(t *testing.T) {
+	mockClient := new(MockDeployer)
+	cfg := newSkipUnchangedConfig()
+	sources := &fakeSources{tree: "abc", deployed: sourceRecord(cfg, "abc")}
+	cfg.BuildArgs = map[string]string{"NODE_ENV": "staging"}
+
+	mockClient.On("StackExists").Return(true, nil)
+	mockClient.On("GetCurrentVersion").Return(3, nil)
+	mockClient.On("MakeTempDir").Return("/tmp/build", nil)
+	mockClient.On("Rsync", mock.Anything, "/tmp/build").Return(nil)
+	mockClient.On("BuildImage", "/tmp/build", 4).Return(nil)
+	mockClient.On("UpdateManifest", 4).Return(nil)
+	mockClient.On("RolloutService", "myapp").Return(nil)
+	mockClient.On("Cleanup", "/tmp/build").Return(nil)
+
+	res, err := DeployWithResult(cfg, mockClient, &Options{Sources: sources})
+
+	require.NoError(t, err)
+	assert.False(t, res.Unchanged, "a build_args change must rebuild even with the same tree")
+	assert.Equal(t, sourceRecord(cfg, "abc"), sources.recorded)
+	mockClient.AssertExpectations(t)
+}
+
+func TestConfigDigest(t *testing.T) {
+	cfg := newSkipUnchangedConfig()
+	base := ConfigDigest(cfg, nil)
+	assert.Equal(t, base, ConfigDigest(newSkipUnchangedConfig(), nil))
+	assert.NotEqual(t, base, ConfigDigest(cfg, map[string]string{"A": "1"}), "--build-arg values count")
+	for name, change := range map[string]func(*config.Config){
+		"target":     func(c *config.Config) { c.Target = "prod" },
+		"platform":   func(c *config.Config) { c.Platform = "linux/arm64" },
+		"dockerfile": func(c *config.Config) { c.Dockerfile = "Dockerfile.prod" },
+		"secrets":    func(c *config.Config) { c.Build = &config.BuildConfig{Secrets: []string{"id=npm"}} },
+		"ports":      func(c *config.Config) { c.Ports = []string{"8080:80"} },
+	} {
+		changed := newSkipUnchangedConfig()
+		change(changed)
+		assert.NotEqual(t, base, ConfigDigest(changed, nil), name)
+	}
+}
+
+// runConfiguringDeployer is a MockDeployer that records the RunOptions it
+// is handed, in the order of the calls it sees.
+type runConfiguringDeployer struct {
+	MockDeployer
+	calls []string
+	run   remote.RunOptions
+}
+
+func (d *runConfiguringDeployer) SetRunOptions(opts remote.RunOptions) {
+	d.calls = append(d.calls, "SetRunOptions")
+	d.run = opts
+}
+
+func TestDeploy_HandsRunOptionsToClient(t *testing.T) {
+	client := new(runConfiguringDeployer)
+	cfg := newTestConfig()
+	run := remote.RunOptions{NoForceRecreate: true, PullBase: true, QuietBuild: true, BuildArgs: map[string]string{"A": "1"}}
+
+	client.On("StackExists").Return(true, nil)
+	client.On("GetCurrentVersion").Return(3, nil)
+	client.On("MakeTempDir").Return("/tmp/build", nil)
+	client.On("Rsync", mock.Anything, "/tmp/build").Return(nil)
+	client.On("BuildImage", "/tmp/build", 4).Run(func(mock.Arguments) {
+		client.calls = append(client.calls, "BuildImage")
+	}).Return(nil)
+	client.On("UpdateManifest", 4).Return(nil)
+	client.On("RolloutService", "myapp").Return(nil)
+	client.On("Cleanup", "/tmp/build").Return(nil)
+
+	err := DeployWithClient(cfg, client, &Options{Run: run})
+
+	require.NoError(t, err)
+	assert.Equal(t, run, client.run)
+	assert.Equal(t, []string{"SetRunOptions", "BuildImage"}, client.calls)
+}
+
+func TestDeploy_SkipUnchanged_DisabledIgnoresSources(t *testing.T) {
+	mockClient := new(MockDeployer)
+	cfg := newTestConfig()
+	sources := &fakeSources{tree: "abc", deployed: "abc"}
+
+	mockClient.On("StackExists").Return(true, nil)
+	mockClient.On("GetCurrentVersion").Return(3, nil)
+	mockClient.On("MakeTempDir").Return("/tmp/build", nil)
+	mockClient.On("Rsync", mock.Anything, "/tmp/build").Return(nil)
+	mockClient.On("BuildImage", "/tmp/build", 4).Return(nil)
+	mockClient.On("UpdateManifest", 4).Return(nil)
+	mockClient.On("RolloutService", "myapp").Return(nil)
+	mockClient.On("Cleanup", "/tmp/build").Return(nil)
+
+	res, err := DeployWithResult(cfg, mockClient, &Options{Sources: sources})
+
+	require.NoError(t, err)
+	assert.False(t, res.Unchanged)
+	assert.Empty(t, sources.recorded)
+}
+
+// Health gate tests
+
+func newHealthGateConfig() *config.Config {
+	cfg := newTestConfig()
+	cfg.Deploy = &config.DeployConfig{Strategy: "recreate", HealthGate: true, HealthTimeout: "30s"}
+	cfg.HealthCheck = &config.HealthCheck{Cmd: "curl -f localhost/health"}
+	return cfg
+}
+
+func expectBuildAndStart(m *MockDeployer, current int) {
+	m.On("StackExists").Return(true, nil)
+	m.On("GetCurrentVersion").Return(current, nil)
+	m.On("MakeTempDir").Return("/tmp/build", nil)
+	m.On("Rsync", mock.Anything, "/tmp/build").Return(nil)
+	m.On("BuildImage", "/tmp/build", current+1).Return(nil)
+	m.On("UpdateManifest", current+1).Return(nil).Once()
+	m.On("StartService", "myapp").Return(nil)
+	m.On("Cleanup", "/tmp/build").Return(nil)
+}
+
+func TestDeploy_HealthGate_Healthy(t *testing.T) {
+	mockClient := new(MockDeployer)
+	cfg := newHealthGateConfig()
+
+	expectBuildAndStart(mockClient, 4)
+	mockClient.On("WaitForHealthy", "myapp", 30*time.Second, time.Duration(0)).Return(nil)
+
+	err := DeployWithClient(cfg, mockClient, nil)
+
+	require.NoError(t, err)
+	mockClient.AssertExpectations(t)
+	mockClient.AssertNotCalled(t, "UpdateManifest", 4)
+	mockClient.AssertNumberOfCalls(t, "StartService", 1)
+}
+
+func TestDeploy_HealthGate_UnhealthyRollsBack(t *testing.T) {
+	mockClient := new(MockDeployer)
+	cfg := newHealthGateConfig()
+
+	expectBuildAndStart(mockClient, 4)
+	mockClient.On("WaitForHealthy", "myapp", 30*time.Second, time.Duration(0)).Return(errors.New("container is unhealthy"))
+	mockClient.On("UpdateManifest", 4).Return(nil).Once()
+
+	err := DeployWithClient(cfg, mockClient, nil)
+
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "container is unhealthy")
+	assert.Contains(t, err.Error(), "automatically rolled back to version 4")
+	mockClient.AssertCalled(t, "UpdateManifest", 4)
+	mockClient.AssertNumberOfCalls(t, "StartService", 2)
+}
+
+func TestDeploy_HealthGate_FirstDeployCannotRollBack(t *testing.T) {
+	mockClient := new(MockDeployer)
+	cfg := newHealthGateConfig()
+
+	expectBuildAndStart(mockClient, 0)
+	mockClient.On("WaitForHealthy", "myapp", 30*time.Second, time.Duration(0)).Return(errors.New("timed out"))
+
+	err := DeployWithClient(cfg, mockClient, nil)
+
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "no previous version to roll back to")
+	mockClient.AssertNumberOfCalls(t, "StartService", 1)
+}
+
+func TestDeploy_HealthGate_SkippedWithoutHealthcheckOrGrace(t *testing.T) {
+	mockClient := new(MockDeployer)
+	cfg := newHealthGateConfig()
+	cfg.HealthCheck = nil
+
+	expectBuildAndStart(mockClient, 4)
+
+	err := DeployWithClient(cfg, mockClient, nil)
+
+	require.NoError(t, err)
+	mockClient.AssertNotCalled(t, "WaitForHealthy", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestDeploy_HealthGate_GraceWithoutHealthcheck(t *testing.T) {
+	mockClient := new(MockDeployer)
+	cfg := newHealthGateConfig()
+	cfg.HealthCheck = nil
+	cfg.Deploy.HealthGrace = "10s"
+
+	expectBuildAndStart(mockClient, 4)
+	mockClient.On("WaitForHealthy", "myapp", 30*time.Second, 10*time.Second).Return(nil)
+
+	err := DeployWithClient(cfg, mockClient, nil)
+
+	require.NoError(t, err)
+	mockClient.AssertExpectations(t)
+}
+
+func TestDeploy_HealthGate_DisabledByDefault(t *testing.T) {
+	mockClient := new(MockDeployer)
+	cfg := newHealthGateConfig()
+	cfg.Deploy.HealthGate = false
+
+	expectBuildAndStart(mockClient, 4)
+
+	err := DeployWithClient(cfg, mockClient, nil)
+
+	require.NoError(t, err)
+	mockClient.AssertNotCalled(t, "WaitForHealthy", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestDeploy_Wait_WithoutHealthGate(t *testing.T) {
+	mockClient := new(MockDeployer)
+	cfg := newHealthGateConfig()
+	cfg.Deploy.HealthGate = false
+
+	expectBuildAndStart(mockClient, 4)
+	mockClient.On("WaitForHealthy", "myapp", 30*time.Second, time.Duration(0)).Return(nil)
+
+	err := DeployWithClient(cfg, mockClient, &Options{HealthWait: WaitHealthy})
+
+	require.NoError(t, err)
+	mockClient.AssertExpectations(t)
+}
+
+func TestDeploy_Wait_UnhealthyFailsWithoutRollback(t *testing.T) {
+	mockClient := new(MockDeployer)
+	cfg := newHealthGateConfig()
+	cfg.Deploy.HealthGate = false
+	cfg.HealthCheck = nil
+
+	expectBuildAndStart(mockClient, 4)
+	mockClient.On("WaitForHealthy", "myapp", 30*time.Second, time.Duration(0)).Return(errors.New("container exited"))
+
+	err := DeployWithClient(cfg, mockClient, &Options{HealthWait: WaitHealthy})
+
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "myapp did not become healthy: container exited")
+	mockClient.AssertNotCalled(t, "UpdateManifest", 4)
+	mockClient.AssertNumberOfCalls(t, "StartService", 1)
+}
+
+func TestDeploy_Wait_KeepsHealthGateRollback(t *testing.T) {
+	mockClient := new(MockDeployer)
+	cfg := newHealthGateConfig()
+
+	expectBuildAndStart(mockClient, 4)
+	mockClient.On("WaitForHealthy", "myapp", 30*time.Second, time.Duration(0)).Return(errors.New("container is unhealthy"))
+	mockClient.On("UpdateManifest", 4).Return(nil).Once()
+
+	err := DeployWithClient(cfg, mockClient, &Options{HealthWait: WaitHealthy})
+
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "automatically rolled back to version 4")
+	mockClient.AssertNumberOfCalls(t, "WaitForHealthy", 1)
+}
+
+func TestDeploy_Detach_SkipsHealthGate(t *testing.T) {
+	mockClient := new(MockDeployer)
+	cfg := newHealthGateConfig()
+	var out bytes.Buffer
+
+	expectBuildAndStart(mockClient, 4)
+
+	err := DeployWithClient(cfg, mockClient, &Options{HealthWait: WaitNone, Output: &out})
+
+	require.NoError(t, err)
+	mockClient.AssertNotCalled(t, "WaitForHealthy", mock.Anything, mock.Anything, mock.Anything)
+	assert.Contains(t, out.String(), "Not waiting for myapp to become healthy (--detach)")
+}
+
+func newPreStartConfig() *config.Config {
+	cfg := newTestConfig()
+	cfg.Deploy = &config.DeployConfig{Strategy: "recreate"}
+	cfg.PreStart = &config.PreStartConfig{Command: "npm run migrate"}
+	return cfg
+}
+
+func TestDeploy_PreStart_RunsBeforeService(t *testing.T) {
+	mockClient := new(MockDeployer)
+	cfg := newPreStartConfig()
+
+	expectBuildAndStart(mockClient, 4)
+	mockClient.On("RunJob", "myapp").Return(nil)
+
+	err := DeployWithClient(cfg, mockClient, nil)
+
+	require.NoError(t, err)
+	mockClient.AssertExpectations(t)
+	jobAt, startAt := -1, -1
+	for i, call := range mockClient.Calls {
+		switch call.Method {
+		case "RunJob":
+			jobAt = i
+		case "StartService":
+			startAt = i
+		}
+	}
+	assert.Less(t, jobAt, startAt, "pre_start job must finish before the service starts")
+}
+
+func TestDeploy_PreStart_FailureAbortsDeploy(t *testing.T) {
+	mockClient := new(MockDeployer)
+	cfg := newPreStartConfig()
+
+	expectBuildAndStart(mockClient, 4)
+	mockClient.On("RunJob", "myapp").Return(errors.New("pre_start job for myapp failed: exit status 1"))
+	mockClient.On("UpdateManifest", 4).Return(nil).Once()
+
+	err := DeployWithClient(cfg, mockClient, nil)
+
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "exit status 1")
+	assert.Contains(t, err.Error(), "manifest restored to version 4")
+	mockClient.AssertNotCalled(t, "StartService", mock.Anything)
+	mockClient.AssertCalled(t, "UpdateManifest", 4)
+}
+
+func TestDeploy_PreStart_FirstDeployFailure(t *testing.T) {
+	mockClient := new(MockDeployer)
+	cfg := newPreStartConfig()
+
+	expectBuildAndStart(mockClient, 0)
+	mockClient.On("RunJob", "myapp").Return(errors.New("pre_start job for myapp failed: exit status 2"))
+
+	err := DeployWithClient(cfg, mockClient, nil)
+
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "exit status 2")
+	mockClient.AssertNotCalled(t, "StartService", mock.Anything)
+	mockClient.AssertNumberOfCalls(t, "UpdateManifest", 1)
+}
+
+func TestDeploy_PreStart_NotRunWithoutJob(t *testing.T) {
+	mockClient := new(MockDeployer)
+	cfg := newPreStartConfig()
+	cfg.PreStart = nil
+
+	expectBuildAndStart(mockClient, 4)
+
+	err := DeployWithClient(cfg, mockClient, nil)
+
+	require.NoError(t, err)
+	mockClient.AssertNotCalled(t, "RunJob", mock.Anything)
+}
+
+func TestManifestManaged(t *testing.T) {
+	web := &config.Config{Name: "web", Stack: "/stacks/shop"}
+	db := &config.Config{Name: "db", Stack: "/stacks/shop", Image: "postgres:16"}
+	services := map[string]*config.Config{"web": web, "db": db}
+
+	tests := []struct {
+		name    string
+		rt      string
+		content string
+		want    bool
+	}{
+		{"empty", "compose", "", true},
+		{"marker", "compose", "x-ssd:\n  managed-by: ssd\nservices:\n  web:\n    image: nginx\n", true},
+		{"legacy ssd image", "compose", "services:\n  web:\n    image: ssd-shop-web:3\n", true},
+		{"legacy internal network", "compose", "services:\n  db:\n    image: postgres:16\nnetworks:\n  shop_internal: {}\n", true},
+		{"hand-written", "compose", "services:\n  web:\n    image: nginx\n  db:\n    image: postgres:16\n", false},
+		{"other project's image", "compose", "services:\n  web:\n    image: ssd-blog-web:3\n", false},
+		{"k3s label", "k3s", "metadata:\n  labels:\n    managed-by: ssd\n", true},
+		{"k3s foreign", "k3s", "kind: Deployment\nmetadata:\n  name: web\n", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, manifestManaged(tt.rt, tt.content, "/stacks/shop", services))
+		})
+	}
+}
+
+func TestDeploy_RefusesUnmanagedCompose(t *testing.T) {
+	mockClient := new(MockDeployer)
+	web := &config.Config{Name: "web", Server: "testserver", Stack: "/stacks/shop", Context: ".", Dockerfile: "Dockerfile"}
+	opts := &Options{AllServices: map[string]*config.Config{"web": web}}
+
+	mockClient.On("StackExists").Return(true, nil)
+	mockClient.On("ReadManifest").Return("services:\n  web:\n    image: nginx\n", nil)
+
+	err := DeployWithClient(web, mockClient, opts)
+
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "compose.yaml in /stacks/shop was not written by ssd")
+	assert.Contains(t, err.Error(), "--adopt")
+	mockClient.AssertNotCalled(t, "Rsync", mock.Anything, mock.Anything)
+	mockClient.AssertNotCalled(t, "CreateStack", mock.Anything)
+}
+
+func TestDeploy_AdoptReplacesUnmanagedCompose(t *testing.T) {
+	mockClient := new(MockDeployer)
+	web := &config.Config{Name: "web", Server: "testserver", Stack: "/stacks/shop", Context: ".", Dockerfile: "Dockerfile"}
+	var buf bytes.Buffer
+	opts := &Options{AllServices: map[string]*config.Config{"web": web}, Adopt: true, Output: &buf}
+
+	mockClient.On("StackExists").Return(true, nil)
+	mockClient.On("GetCurrentVersion").Return(0, nil)
+	mockClient.On("MakeTempDir").Return("/tmp/build", nil)
+	mockClient.On("Rsync", mock.Anything, "/tmp/build").Return(nil)
+	mockClient.On("BuildImage", "/tmp/build", 1).Return(nil)
+	mockClient.On("ReadManifest").Return("services:\n  web:\n    image: nginx\n", nil)
+	mockClient.On("CreateEnvFiles", mock.Anything).Return(nil)
+	mockClient.On("CreateStack", mock.MatchedBy(func(content string) bool {
+		return strings.Contains(content, "x-ssd:") &&
+			strings.Contains(content, "image: ssd-shop-web:1")
+	})).Return(nil)
+	mockClient.On("RolloutService", "web").Return(nil)
+	mockClient.On("Cleanup", "/tmp/build").Return(nil)
+
+	err := DeployWithClient(web, mockClient, opts)
+
+	require.NoError(t, err)
+	mockClient.AssertExpectations(t)
+	assert.Contains(t, buf.String(), "WARNING: compose.yaml in /stacks/shop was not written by ssd; replacing it (--adopt)")
+}
@@ -0,0 +1,170 @@
+package deploy
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/byteink/ssd/compose"
+	"github.com/byteink/ssd/config"
+	"github.com/pmezard/go-difflib/difflib"
+)
+
+// diffContext is the number of unchanged lines shown around each change.
+const diffContext = 3
+
+// EnvReader reads a service's env file from the server. Only needed to
+// diff env files; the runtime clients implement it.
+type EnvReader interface {
+	GetEnvFile(ctx context.Context, serviceName string) (string, error)
+}
+
+// DiffWithClient returns a unified diff between the manifest on the server
+// and the one a deploy of the named services would write. Built services
+// in deploying get their current version + 1; everything else keeps the
+// version found on the server. Nothing on the server is changed and no
+// lock is taken. An empty string means no changes.
+func DiffWithClient(ctx context.Context, client Deployer, rt string, allServices map[string]*config.Config, stack string, deploying []string) (string, error) {
+	current, err := client.ReadManifest(ctx)
+	if err != nil {
+		return "", fmt.Errorf("failed to read %s: %w", stackManifestName(rt, allServices), err)
+	}
+
+	versions := parseServiceVersions(current, stack, allServices)
+	for _, name := range deploying {
+		if svc, ok := allServices[name]; ok && !svc.IsPrebuilt() {
+			versions[name]++
+		}
+	}
+
+	// Keep the server's x-ssd block so only service changes show
+	meta, _ := compose.ParseMetadata(current)
+	next, err := generateManifest(rt, allServices, stack, versions, meta)
+	if err != nil {
+		return "", fmt.Errorf("failed to generate %s: %w", stackManifestName(rt, allServices), err)
+	}
+
+	remotePath := filepath.Join(stack, stackManifestName(rt, allServices))
+	return unifiedDiff(current, next, "server:"+remotePath, "local:"+remotePath)
+}
+
+// ManifestReader reads the manifest currently on the server.
+type ManifestReader interface {
+	ReadManifest(ctx context.Context) (string, error)
+}
+
+// RenderManifest returns the manifest ssd would write for allServices
+// without deploying anything. With a nil client nothing is read from the
+// server and built services are rendered at version 1. Otherwise each
+// built service keeps the version in the server's manifest (1 if it was
+// never deployed), and pinned digests and deploy --image references are
+// kept too.
+func RenderManifest(ctx context.Context, client ManifestReader, rt string, allServices map[string]*config.Config, stack string) (string, error) {
+	current := ""
+	if client != nil {
+		var err error
+		if current, err = client.ReadManifest(ctx); err != nil {
+			return "", fmt.Errorf("failed to read %s: %w", stackManifestName(rt, allServices), err)
+		}
+	}
+
+	versions := parseServiceVersions(current, stack, allServices)
+	for name, v := range versions {
+		if v == 0 {
+			versions[name] = 1
+		}
+	}
+
+	images := parseExternalImages(rt, current, stack, allServices)
+	maps.Copy(images, parsePinnedImages(current, allServices))
+	services := withImages(allServices, images)
+	meta, _ := compose.ParseMetadata(current)
+	manifest, err := generateManifest(rt, services, stack, versions, meta)
+	if err != nil {
+		return "", fmt.Errorf("failed to generate %s: %w", stackManifestName(rt, allServices), err)
+	}
+	return manifest, nil
+}
+
+// DiffEnvFiles diffs each deploying service's local env_file against the
+// {service}.env on the server, i.e. what the deploy would upload. Values
+// are replaced by a short hash so secrets never reach the terminal; a
+// changed hash means a changed value. Services without env_file are
+// skipped since deploys leave their server env untouched.
+func DiffEnvFiles(ctx context.Context, client EnvReader, allServices map[string]*config.Config, deploying []string) (string, error) {
+	var b strings.Builder
+	for _, name := range deploying {
+		svc, ok := allServices[name]
+		if !ok || svc.EnvFile == "" {
+			continue
+		}
+		local, err := os.ReadFile(svc.EnvFile)
+		if err != nil {
+			return "", fmt.Errorf("failed to read env_file for %s: %w", name, err)
+		}
+		remote, err := client.GetEnvFile(ctx, name)
+		if err != nil {
+			return "", fmt.Errorf("failed to read %s.env on server: %w", name, err)
+		}
+		d, err := unifiedDiff(maskEnv(remote), maskEnv(string(local)), "server:"+name+".env", "local:"+svc.EnvFile)
+		if err != nil {
+			return "", err
+		}
+		b.WriteString(d)
+	}
+	return b.String(), nil
+}
+
+// maskEnv replaces every value in env file content with the first 8 hex
+// characters of its SHA-256. Comments and blank lines are dropped.
+func maskEnv(content string) string {
+	var b strings.Builder
+	scanner := bufio.NewScanner(strings.NewReader(content))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		key, value, found := strings.Cut(line, "=")
+		b.WriteString(key)
+		if found {
+			sum := sha256.Sum256([]byte(value))
+			b.WriteString(fmt.Sprintf("=<%x>", sum[:4]))
+		}
+		b.WriteString("\n")
+	}
+	return b.String()
+}
+
+// unifiedDiff renders a unified diff of a and b, or "" when they match.
+func unifiedDiff(a, b, fromFile, toFile string) (string, error) {
+	if a == b {
+		return "", nil
+	}
+	out, err := difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+		A:        difflib.SplitLines(a),
+		B:        difflib.SplitLines(b),
+		FromFile: fromFile,
+		ToFile:   toFile,
+		Context:  diffContext,
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to render diff: %w", err)
+	}
+	return out, nil
+}
+
+// stackManifestName returns the manifest filename of the stack the
+// services share. compose_filename is root-level, so any service will do.
+func stackManifestName(rt string, allServices map[string]*config.Config) string {
+	cfg := &config.Config{}
+	if names := sortedKeys(allServices); len(names) > 0 {
+		cfg = allServices[names[0]]
+	}
+	return manifestName(rt, cfg)
+}
@@ -0,0 +1,192 @@
+package deploy
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/byteink/ssd/compose"
+	"github.com/byteink/ssd/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func diffServices() map[string]*config.Config {
+	return map[string]*config.Config{
+		"web": {Name: "web", Stack: "/stacks/myapp", Port: 3000, Domain: "example.com"},
+		"api": {Name: "api", Stack: "/stacks/myapp", Port: 8080},
+		"db":  {Name: "db", Stack: "/stacks/myapp", Image: "postgres:16"},
+	}
+}
+
+type fakeEnvReader map[string]string
+
+func (f fakeEnvReader) GetEnvFile(_ context.Context, serviceName string) (string, error) {
+	return f[serviceName], nil
+}
+
+func TestDiffWithClient_BumpsDeployedService(t *testing.T) {
+	services := diffServices()
+	current, err := compose.GenerateCompose(services, "/stacks/myapp", map[string]int{"web": 3, "api": 7})
+	require.NoError(t, err)
+
+	client := new(MockDeployer)
+	client.On("ReadManifest").Return(current, nil)
+
+	diff, err := DiffWithClient(context.Background(), client, "compose", services, "/stacks/myapp", []string{"web"})
+
+	require.NoError(t, err)
+	assert.Contains(t, diff, "--- server:/stacks/myapp/compose.yaml")
+	assert.Contains(t, diff, "+++ local:/stacks/myapp/compose.yaml")
+	assert.Contains(t, diff, "-        image: ssd-myapp-web:3\n")
+	assert.Contains(t, diff, "+        image: ssd-myapp-web:4\n")
+	assert.NotContains(t, diff, "ssd-myapp-api:8", "services not being deployed keep their version")
+	assert.Equal(t, 1, strings.Count(diff, "@@ -"), "one hunk:\n%s", diff)
+}
+
+func TestDiffWithClient_ComposeFilename(t *testing.T) {
+	services := diffServices()
+	for _, svc := range services {
+		svc.ComposeFile = "docker-compose.yml"
+	}
+	client := new(MockDeployer)
+	client.On("ReadManifest").Return("", nil)
+
+	diff, err := DiffWithClient(context.Background(), client, "compose", services, "/stacks/myapp", []string{"web"})
+
+	require.NoError(t, err)
+	assert.Contains(t, diff, "--- server:/stacks/myapp/docker-compose.yml")
+	assert.Contains(t, diff, "+++ local:/stacks/myapp/docker-compose.yml")
+}
+
+func TestDiffWithClient_ShowsConfigChanges(t *testing.T) {
+	services := diffServices()
+	current, err := compose.GenerateCompose(services, "/stacks/myapp", map[string]int{"web": 3, "api": 7})
+	require.NoError(t, err)
+
+	services["api"].Ports = []string{"8080:8080"}
+	client := new(MockDeployer)
+	client.On("ReadManifest").Return(current, nil)
+
+	diff, err := DiffWithClient(context.Background(), client, "compose", services, "/stacks/myapp", []string{"db"})
+
+	require.NoError(t, err)
+	assert.Contains(t, diff, "+            - 8080:8080\n")
+	assert.NotContains(t, diff, "-        image:", "pre-built db has no version to bump")
+	assert.NotContains(t, diff, "+        image:")
+}
+
+func TestDiffWithClient_NoChanges(t *testing.T) {
+	services := diffServices()
+	current, err := compose.GenerateCompose(services, "/stacks/myapp", map[string]int{"web": 3, "api": 7})
+	require.NoError(t, err)
+
+	client := new(MockDeployer)
+	client.On("ReadManifest").Return(current, nil)
+
+	diff, err := DiffWithClient(context.Background(), client, "compose", services, "/stacks/myapp", []string{"db"})
+
+	require.NoError(t, err)
+	assert.Empty(t, diff)
+}
+
+func TestDiffWithClient_KeepsServerMetadata(t *testing.T) {
+	services := diffServices()
+	current, err := compose.GenerateComposeWithMetadata(services, "/stacks/myapp", map[string]int{"web": 3, "api": 7},
+		compose.Metadata{GeneratedAt: "2026-10-01T12:00:00Z", SSDVersion: "1.3.0", Sources: map[string]string{"web": "abc"}})
+	require.NoError(t, err)
+
+	client := new(MockDeployer)
+	client.On("ReadManifest").Return(current, nil)
+
+	diff, err := DiffWithClient(context.Background(), client, "compose", services, "/stacks/myapp", []string{"db"})
+
+	require.NoError(t, err)
+	assert.Empty(t, diff, "the x-ssd block is not a change")
+}
+
+func TestDiffWithClient_FirstDeploy(t *testing.T) {
+	client := new(MockDeployer)
+	client.On("ReadManifest").Return("", nil)
+
+	diff, err := DiffWithClient(context.Background(), client, "compose", diffServices(), "/stacks/myapp", []string{"web", "api", "db"})
+
+	require.NoError(t, err)
+	assert.Contains(t, diff, "+        image: ssd-myapp-web:1\n")
+	assert.Contains(t, diff, "+        image: ssd-myapp-api:1\n")
+}
+
+func TestDiffWithClient_ReadError(t *testing.T) {
+	client := new(MockDeployer)
+	client.On("ReadManifest").Return("", errors.New("ssh: connection refused"))
+
+	_, err := DiffWithClient(context.Background(), client, "k3s", diffServices(), "/stacks/myapp", nil)
+
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "failed to read manifests.yaml")
+}
+
+func TestRenderManifest_Offline(t *testing.T) {
+	out, err := RenderManifest(context.Background(), nil, "compose", diffServices(), "/stacks/myapp")
+
+	require.NoError(t, err)
+	assert.Contains(t, out, "image: ssd-myapp-web:1\n")
+	assert.Contains(t, out, "image: ssd-myapp-api:1\n")
+	assert.Contains(t, out, "image: postgres:16\n")
+}
+
+func TestRenderManifest_KeepsServerVersions(t *testing.T) {
+	services := diffServices()
+	current, err := compose.GenerateCompose(services, "/stacks/myapp", map[string]int{"web": 3})
+	require.NoError(t, err)
+
+	client := new(MockDeployer)
+	client.On("ReadManifest").Return(current, nil)
+
+	out, err := RenderManifest(context.Background(), client, "compose", services, "/stacks/myapp")
+
+	require.NoError(t, err)
+	assert.Equal(t, strings.Replace(current, "ssd-myapp-api:0", "ssd-myapp-api:1", 1), out,
+		"deployed versions are kept; never-deployed services render at 1")
+}
+
+func TestRenderManifest_ReadError(t *testing.T) {
+	client := new(MockDeployer)
+	client.On("ReadManifest").Return("", errors.New("ssh: connection refused"))
+
+	_, err := RenderManifest(context.Background(), client, "compose", diffServices(), "/stacks/myapp")
+
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "failed to read compose.yaml")
+}
+
+func TestDiffEnvFiles_MasksValues(t *testing.T) {
+	dir := t.TempDir()
+	envPath := filepath.Join(dir, "web.env")
+	require.NoError(t, os.WriteFile(envPath, []byte("# local\nDATABASE_URL=postgres://new\nPORT=3000\nNEW_KEY=secret\n"), 0600))
+	services := diffServices()
+	services["web"].EnvFile = envPath
+
+	remote := fakeEnvReader{"web": "DATABASE_URL=postgres://old\nPORT=3000\n"}
+	diff, err := DiffEnvFiles(context.Background(), remote, services, []string{"web", "api"})
+
+	require.NoError(t, err)
+	assert.Contains(t, diff, "--- server:web.env")
+	assert.Contains(t, diff, "-DATABASE_URL=<")
+	assert.Contains(t, diff, "+DATABASE_URL=<")
+	assert.Contains(t, diff, "+NEW_KEY=<")
+	assert.Contains(t, diff, " PORT=<", "unchanged keys appear as context")
+	for _, secret := range []string{"postgres://new", "postgres://old", "secret"} {
+		assert.NotContains(t, diff, secret)
+	}
+}
+
+func TestDiffEnvFiles_SkipsServicesWithoutEnvFile(t *testing.T) {
+	diff, err := DiffEnvFiles(context.Background(), fakeEnvReader{}, diffServices(), []string{"web", "api", "db"})
+
+	require.NoError(t, err)
+	assert.Empty(t, diff)
+}
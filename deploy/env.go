@@ -0,0 +1,92 @@
+package deploy
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/byteink/ssd/config"
+)
+
+// EnvEditor is a Deployer that can edit a service's {service}.env on the
+// server.
+type EnvEditor interface {
+	Deployer
+	SetEnvVar(ctx context.Context, serviceName, key, value string) error
+	RemoveEnvVar(ctx context.Context, serviceName, key string) error
+}
+
+// SetEnvWithClient sets key=value in serviceName's env file under the
+// stack's deployment lock. Edits read the file, change it and write it
+// back; the lock keeps two edits, or an edit and a deploy's env_file
+// upload, from losing one another's changes.
+func SetEnvWithClient(cfg *config.Config, client EnvEditor, serviceName, key, value string, opts *Options) error {
+	ctx := context.Background()
+	unlock, err := lockStack(ctx, cfg, client, opts)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if err := client.SetEnvVar(ctx, serviceName, key, value); err != nil {
+		return fmt.Errorf("failed to set %s: %w", key, err)
+	}
+	return nil
+}
+
+// RemoveEnvWithClient removes key from serviceName's env file under the
+// stack's deployment lock; see SetEnvWithClient.
+func RemoveEnvWithClient(cfg *config.Config, client EnvEditor, serviceName, key string, opts *Options) error {
+	ctx := context.Background()
+	unlock, err := lockStack(ctx, cfg, client, opts)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if err := client.RemoveEnvVar(ctx, serviceName, key); err != nil {
+		return fmt.Errorf("failed to remove %s: %w", key, err)
+	}
+	return nil
+}
+
+// EnvFileEditor is a Deployer that can read and replace a service's
+// {service}.env on the server.
+type EnvFileEditor interface {
+	Deployer
+	GetEnvFile(ctx context.Context, serviceName string) (string, error)
+	WriteEnvFile(ctx context.Context, serviceName, content string) error
+}
+
+// EditEnvWithClient replaces serviceName's env file, read earlier as
+// original, with edited, and reports whether it was written. Nothing is
+// written when edited is unchanged; otherwise it must pass
+// config.ValidateEnvContent. The edit happens outside the deployment lock
+// (it may take a person minutes), so under the lock the file is read
+// again and the write refused if it no longer matches original.
+func EditEnvWithClient(cfg *config.Config, client EnvFileEditor, serviceName, original, edited string, opts *Options) (bool, error) {
+	if edited == original {
+		return false, nil
+	}
+	if err := config.ValidateEnvContent(edited); err != nil {
+		return false, fmt.Errorf("invalid %s.env: %w", serviceName, err)
+	}
+
+	ctx := context.Background()
+	unlock, err := lockStack(ctx, cfg, client, opts)
+	if err != nil {
+		return false, err
+	}
+	defer unlock()
+
+	current, err := client.GetEnvFile(ctx, serviceName)
+	if err != nil {
+		return false, fmt.Errorf("failed to read %s.env: %w", serviceName, err)
+	}
+	if current != original {
+		return false, fmt.Errorf("%s.env changed on the server while it was being edited; run the edit again", serviceName)
+	}
+	if err := client.WriteEnvFile(ctx, serviceName, edited); err != nil {
+		return false, fmt.Errorf("failed to write %s.env: %w", serviceName, err)
+	}
+	return true, nil
+}
@@ -0,0 +1,206 @@
+package deploy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/byteink/ssd/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// envServer is one env file on a fake server, edited the way the real
+// clients do it: read, change, write back, with a pause in between that
+// lets an unserialized edit slip in.
+type envServer struct {
+	mu    sync.Mutex
+	lines []string
+}
+
+func (s *envServer) read() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.lines...)
+}
+
+func (s *envServer) write(lines []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lines = lines
+}
+
+// envClient is a Deployer editing envServer's file.
+type envClient struct {
+	MockDeployer
+	server *envServer
+	err    error
+}
+
+func (c *envClient) SetEnvVar(ctx context.Context, serviceName, key, value string) error {
+	if c.err != nil {
+		return c.err
+	}
+	lines := c.server.read()
+	time.Sleep(5 * time.Millisecond)
+	c.server.write(append(lines, key+"="+value))
+	return nil
+}
+
+func (c *envClient) RemoveEnvVar(ctx context.Context, serviceName, key string) error {
+	lines := c.server.read()
+	time.Sleep(5 * time.Millisecond)
+	kept := lines[:0]
+	for _, l := range lines {
+		if !strings.HasPrefix(l, key+"=") {
+			kept = append(kept, l)
+		}
+	}
+	c.server.write(kept)
+	return nil
+}
+
+func newEnvConfig(t *testing.T) *config.Config {
+	cfg := newTestConfig()
+	cfg.Stack = "/stacks/env-" + strings.ReplaceAll(t.Name(), "/", "-")
+	return cfg
+}
+
+func TestSetEnvWithClient_ConcurrentSetsKeepEveryUpdate(t *testing.T) {
+	cfg := newEnvConfig(t)
+	server := &envServer{}
+
+	const n = 8
+	var wg sync.WaitGroup
+	errs := make(chan error, n)
+	for i := range n {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			client := &envClient{server: server}
+			errs <- SetEnvWithClient(cfg, client, "myapp", fmt.Sprintf("KEY%d", i), "v", nil)
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		require.NoError(t, err)
+	}
+
+	lines := server.read()
+	assert.Len(t, lines, n, "an edit was lost: %v", lines)
+	for i := range n {
+		assert.Contains(t, lines, fmt.Sprintf("KEY%d=v", i))
+	}
+}
+
+func TestRemoveEnvWithClient_SerializedWithSet(t *testing.T) {
+	cfg := newEnvConfig(t)
+	server := &envServer{lines: []string{"OLD=1"}}
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		assert.NoError(t, RemoveEnvWithClient(cfg, &envClient{server: server}, "myapp", "OLD", nil))
+	}()
+	go func() {
+		defer wg.Done()
+		assert.NoError(t, SetEnvWithClient(cfg, &envClient{server: server}, "myapp", "NEW", "2", nil))
+	}()
+	wg.Wait()
+
+	assert.Equal(t, []string{"NEW=2"}, server.read())
+}
+
+func TestSetEnvWithClient_WaitsForDeployLock(t *testing.T) {
+	cfg := newEnvConfig(t)
+	unlock, err := lockStack(context.Background(), cfg, new(MockDeployer), nil)
+	require.NoError(t, err)
+
+	fakeLockClock(t)
+	err = SetEnvWithClient(cfg, &envClient{server: &envServer{}}, "myapp", "K", "v", &Options{LockTimeout: time.Second})
+	unlock()
+
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "failed to acquire deployment lock")
+}
+
+func TestSetEnvWithClient_Error(t *testing.T) {
+	cfg := newEnvConfig(t)
+	client := &envClient{server: &envServer{}, err: errors.New("permission denied")}
+
+	err := SetEnvWithClient(cfg, client, "myapp", "K", "v", nil)
+
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "failed to set K: permission denied")
+}
+
+// envFileClient is a Deployer holding one env file and recording writes.
+type envFileClient struct {
+	MockDeployer
+	content string
+	writes  []string
+}
+
+func (c *envFileClient) GetEnvFile(ctx context.Context, serviceName string) (string, error) {
+	return c.content, nil
+}
+
+func (c *envFileClient) WriteEnvFile(ctx context.Context, serviceName, content string) error {
+	c.writes = append(c.writes, content)
+	c.content = content
+	return nil
+}
+
+func TestEditEnvWithClient_WritesValidatedContent(t *testing.T) {
+	cfg := newEnvConfig(t)
+	client := &envFileClient{content: "A=1\n"}
+
+	changed, err := EditEnvWithClient(cfg, client, "myapp", "A=1\n", "# edited\nA=2\nB=x=y\n", nil)
+
+	require.NoError(t, err)
+	assert.True(t, changed)
+	assert.Equal(t, []string{"# edited\nA=2\nB=x=y\n"}, client.writes)
+}
+
+func TestEditEnvWithClient_UnchangedSkipsWrite(t *testing.T) {
+	cfg := newEnvConfig(t)
+	client := &envFileClient{content: "A=1\n"}
+
+	changed, err := EditEnvWithClient(cfg, client, "myapp", "A=1\n", "A=1\n", nil)
+
+	require.NoError(t, err)
+	assert.False(t, changed)
+	assert.Empty(t, client.writes)
+}
+
+func TestEditEnvWithClient_RejectsMalformedLines(t *testing.T) {
+	cfg := newEnvConfig(t)
+	client := &envFileClient{content: "A=1\n"}
+
+	for _, edited := range []string{"A=1\nB 2\n", "A=1\nA=2\n", "BAD-KEY=1\n"} {
+		changed, err := EditEnvWithClient(cfg, client, "myapp", "A=1\n", edited, nil)
+
+		require.Error(t, err, "%q", edited)
+		assert.Contains(t, err.Error(), "invalid myapp.env: line")
+		assert.False(t, changed)
+	}
+	assert.Empty(t, client.writes)
+}
+
+func TestEditEnvWithClient_RefusesConcurrentChange(t *testing.T) {
+	cfg := newEnvConfig(t)
+	client := &envFileClient{content: "A=1\nB=2\n"}
+
+	changed, err := EditEnvWithClient(cfg, client, "myapp", "A=1\n", "A=5\n", nil)
+
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "changed on the server while it was being edited")
+	assert.False(t, changed)
+	assert.Empty(t, client.writes)
+}
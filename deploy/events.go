@@ -0,0 +1,111 @@
+package deploy
+
+import (
+	"encoding/json"
+	"io"
+	"net"
+	"os"
+	"time"
+
+	"github.com/byteink/ssd/config"
+)
+
+// Event types, in the order a deploy emits them. build-done follows the
+// image build or pull (or, with SkipBuild, keeping the current image). A
+// deploy ends with promoted or failed; a BuildOnly deploy, whose service
+// deploy-all starts later, ends with build-done, and one skipped by
+// skip_unchanged with start alone.
+const (
+	EventStart     = "start"
+	EventBuildDone = "build-done"
+	EventPromoted  = "promoted"
+	EventFailed    = "failed"
+)
+
+// Event is one deploy phase, written as a line of JSON to the events
+// sink (root-level events in ssd.yaml) for dashboards and other tooling.
+type Event struct {
+	Time    time.Time `json:"time"`
+	Type    string    `json:"type"`
+	Service string    `json:"service"`
+	Stack   string    `json:"stack"`
+	Version int       `json:"version,omitempty"`
+	Error   string    `json:"error,omitempty"`
+}
+
+// eventTimeout bounds connecting and writing to a socket sink, so a
+// stalled listener can't hold up the deploy.
+const eventTimeout = time.Second
+
+// eventSink writes one deploy's events to cfg.Events: appended to a file,
+// or sent over a fresh connection when the path is a unix socket. Write
+// failures never fail the deploy; the first one is reported as a warning
+// and the rest are dropped quietly.
+type eventSink struct {
+	cfg    *config.Config
+	output io.Writer
+	warned bool
+}
+
+// newEventSink returns the sink for cfg's deploys, or nil when no events
+// path is configured. A nil sink discards events.
+func newEventSink(cfg *config.Config, output io.Writer) *eventSink {
+	if cfg.Events == "" {
+		return nil
+	}
+	return &eventSink{cfg: cfg, output: output}
+}
+
+// emit writes an event of the given type for version; err, if set, is
+// recorded as the event's error.
+func (s *eventSink) emit(typ string, version int, err error) {
+	if s == nil {
+		return
+	}
+	e := Event{
+		Time:    time.Now().UTC(),
+		Type:    typ,
+		Service: s.cfg.Name,
+		Stack:   s.cfg.StackPath(),
+		Version: version,
+	}
+	if err != nil {
+		e.Error = err.Error()
+	}
+	if werr := writeEvent(s.cfg.Events, e); werr != nil && !s.warned {
+		s.warned = true
+		logf(s.output, "Warning: cannot write deploy events to %s: %v\n", s.cfg.Events, werr)
+	}
+}
+
+// writeEvent writes e as a single JSON line to path.
+func writeEvent(path string, e Event) error {
+	line, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	if fi, err := os.Stat(path); err == nil && fi.Mode()&os.ModeSocket != 0 {
+		conn, err := net.DialTimeout("unix", path, eventTimeout)
+		if err != nil {
+			return err
+		}
+		defer func() { _ = conn.Close() }()
+		if err := conn.SetWriteDeadline(time.Now().Add(eventTimeout)); err != nil {
+			return err
+		}
+		_, err = conn.Write(line)
+		return err
+	}
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(line); err != nil {
+		_ = f.Close()
+		return err
+	}
+	return f.Close()
+}
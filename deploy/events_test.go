@@ -0,0 +1,145 @@
+package deploy
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/byteink/ssd/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+// newEventsConfig returns a recreate-strategy service whose deploy
+// events go to path.
+func newEventsConfig(path string) *config.Config {
+	cfg := newTestConfig()
+	cfg.Deploy = &config.DeployConfig{Strategy: "recreate"}
+	cfg.Events = path
+	return cfg
+}
+
+// readEvents parses the JSON lines in the events file at path.
+func readEvents(t *testing.T, path string) []Event {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	var events []Event
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		var e Event
+		require.NoError(t, json.Unmarshal([]byte(line), &e), line)
+		events = append(events, e)
+	}
+	return events
+}
+
+// eventTypes returns the types of events, in order.
+func eventTypes(events []Event) []string {
+	types := make([]string, len(events))
+	for i, e := range events {
+		types[i] = e.Type
+	}
+	return types
+}
+
+func TestDeploy_WritesEvents(t *testing.T) {
+	cfg := newEventsConfig(filepath.Join(t.TempDir(), "events.jsonl"))
+	mockClient := new(MockDeployer)
+	expectBuildAndStart(mockClient, 4)
+
+	require.NoError(t, DeployWithClient(cfg, mockClient, nil))
+
+	events := readEvents(t, cfg.Events)
+	assert.Equal(t, []string{EventStart, EventBuildDone, EventPromoted}, eventTypes(events))
+	for _, e := range events {
+		assert.Equal(t, "myapp", e.Service)
+		assert.Equal(t, "/stacks/myapp", e.Stack)
+		assert.False(t, e.Time.IsZero())
+		assert.Empty(t, e.Error)
+	}
+	assert.Equal(t, 0, events[0].Version)
+	assert.Equal(t, 5, events[1].Version)
+	assert.Equal(t, 5, events[2].Version)
+}
+
+func TestDeploy_WritesFailedEvent(t *testing.T) {
+	cfg := newEventsConfig(filepath.Join(t.TempDir(), "events.jsonl"))
+	mockClient := new(MockDeployer)
+	mockClient.On("StackExists").Return(true, nil)
+	mockClient.On("GetCurrentVersion").Return(4, nil)
+	mockClient.On("MakeTempDir").Return("/tmp/build", nil)
+	mockClient.On("Rsync", mock.Anything, "/tmp/build").Return(nil)
+	mockClient.On("BuildImage", "/tmp/build", 5).Return(errors.New("no space left on device"))
+	mockClient.On("Cleanup", "/tmp/build").Return(nil)
+
+	require.Error(t, DeployWithClient(cfg, mockClient, nil))
+
+	events := readEvents(t, cfg.Events)
+	assert.Equal(t, []string{EventStart, EventFailed}, eventTypes(events))
+	assert.Equal(t, 5, events[1].Version)
+	assert.Contains(t, events[1].Error, "failed to build image: no space left on device")
+}
+
+func TestDeploy_EventSinkFailureIsIgnored(t *testing.T) {
+	cfg := newEventsConfig(filepath.Join(t.TempDir(), "missing", "events.jsonl"))
+	mockClient := new(MockDeployer)
+	expectBuildAndStart(mockClient, 4)
+	var out strings.Builder
+
+	err := DeployWithClient(cfg, mockClient, &Options{Output: &out})
+
+	require.NoError(t, err)
+	assert.Equal(t, 1, strings.Count(out.String(), "Warning: cannot write deploy events to"), "warn once per deploy")
+	assert.Contains(t, out.String(), "Deployed myapp version 5 successfully!")
+}
+
+func TestDeploy_WritesEventsToSocket(t *testing.T) {
+	dir, err := os.MkdirTemp("", "ssd-events")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = os.RemoveAll(dir) })
+	sock := filepath.Join(dir, "events.sock")
+	ln, err := net.Listen("unix", sock)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = ln.Close() })
+
+	lines := make(chan string, 10)
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			scanner := bufio.NewScanner(conn)
+			for scanner.Scan() {
+				lines <- scanner.Text()
+			}
+			_ = conn.Close()
+		}
+	}()
+
+	cfg := newEventsConfig(sock)
+	mockClient := new(MockDeployer)
+	expectBuildAndStart(mockClient, 4)
+
+	require.NoError(t, DeployWithClient(cfg, mockClient, nil))
+
+	var types []string
+	for range 3 {
+		select {
+		case line := <-lines:
+			var e Event
+			require.NoError(t, json.Unmarshal([]byte(line), &e))
+			types = append(types, e.Type)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("got events %v, want 3", types)
+		}
+	}
+	assert.Equal(t, []string{EventStart, EventBuildDone, EventPromoted}, types)
+}
@@ -0,0 +1,185 @@
+package deploy
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"os/user"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/byteink/ssd/config"
+	"github.com/byteink/ssd/internal/clock"
+	"github.com/byteink/ssd/remote"
+)
+
+// RemoteLocker is implemented by clients that can hold a deploy lock on
+// the server, serializing deploys from different machines. Clients
+// without it (e.g. test doubles) only get the local lock. RefreshLock
+// keeps a held lock from going stale.
+type RemoteLocker interface {
+	TryLock(ctx context.Context, lock, holder string, staleAfter time.Duration) (bool, string, error)
+	RefreshLock(ctx context.Context, lock, holder string) error
+	Unlock(ctx context.Context, lock, holder string) error
+}
+
+var _ RemoteLocker = (*remote.Client)(nil)
+
+// remoteLockStaleAfter is how old a remote lock must be before another
+// deploy may take it over. Long enough for slow builds, short enough that
+// a crashed deploy doesn't block the stack for the day.
+const remoteLockStaleAfter = 30 * time.Minute
+
+// remoteLockHeartbeat is how often a held remote lock is refreshed. Well
+// under remoteLockStaleAfter, which a slow build (its timeout is also 30
+// minutes) or a long health gate can otherwise outlast. A variable so
+// tests can shorten it.
+var remoteLockHeartbeat = 5 * time.Minute
+
+// remoteLockPollInterval is how often a blocked deploy retries the remote
+// lock.
+const remoteLockPollInterval = 2 * time.Second
+
+// localLockPollInterval is how often a blocked deploy retries the lock
+// file on this machine.
+const localLockPollInterval = 100 * time.Millisecond
+
+// lockClock times lock waits. Tests swap in a clock.Fake so timeouts
+// expire without sleeping.
+var lockClock clock.Clock = clock.Real{}
+
+// defaultLockTimeout is how long lock acquisition waits when
+// Options.LockTimeout is unset.
+const defaultLockTimeout = 5 * time.Minute
+
+// lockStack takes the local lock for the stack and, when client supports
+// it, the remote lock in the stack directory. Each waits up to
+// opts.LockTimeout (default 5 minutes). The returned func releases both;
+// calling it again does nothing. Held while the stack's shared state
+// (the manifest, running services) changes.
+func lockStack(ctx context.Context, cfg *config.Config, client Deployer, opts *Options) (func(), error) {
+	return takeLocks(ctx, cfg.StackPath(), remote.LockDir, "deployment lock", cfg, client, opts)
+}
+
+// lockService is lockStack for one service: it keeps two deploys of the
+// same service apart for their whole run, versions and all, while deploys
+// of other services in the stack build alongside.
+func lockService(ctx context.Context, cfg *config.Config, client Deployer, opts *Options) (func(), error) {
+	return takeLocks(ctx, serviceLockKey(cfg), remote.ServiceLockDir(cfg.Name),
+		"deployment lock for "+cfg.Name, cfg, client, opts)
+}
+
+// serviceLockKey keys the local lock of cfg's service.
+func serviceLockKey(cfg *config.Config) string {
+	return cfg.StackPath() + "#" + cfg.Name
+}
+
+// takeLocks takes the local lock keyed on key, then the remote lock
+// directory lockDir in cfg's stack when client supports it.
+func takeLocks(ctx context.Context, key, lockDir, what string, cfg *config.Config, client Deployer, opts *Options) (func(), error) {
+	timeout := defaultLockTimeout
+	if opts != nil && opts.LockTimeout > 0 {
+		timeout = opts.LockTimeout
+	}
+
+	unlock, err := acquireLockWithTimeout(key, timeout)
+	if err != nil {
+		return nil, fmt.Errorf("failed to acquire %s: %w", what, err)
+	}
+
+	locker, ok := client.(RemoteLocker)
+	if !ok {
+		var once sync.Once
+		return func() { once.Do(unlock) }, nil
+	}
+	holder := lockHolder()
+	if err := acquireRemoteLock(ctx, locker, cfg.StackPath(), lockDir, holder, timeout); err != nil {
+		unlock()
+		return nil, fmt.Errorf("failed to acquire remote %s: %w", what, err)
+	}
+	stopHeartbeat := startLockHeartbeat(ctx, locker, lockDir, holder)
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			stopHeartbeat()
+			if err := locker.Unlock(ctx, lockDir, holder); err != nil {
+				log.Printf("failed to release remote lock: %v", err)
+			}
+			unlock()
+		})
+	}, nil
+}
+
+// acquireRemoteLock retries TryLock until it succeeds or timeout passes.
+// On timeout the error names the lock path and who holds it.
+func acquireRemoteLock(ctx context.Context, locker RemoteLocker, stackPath, lockDir, holder string, timeout time.Duration) error {
+	deadline := lockClock.Now().Add(timeout)
+	for {
+		ok, current, err := locker.TryLock(ctx, lockDir, holder, remoteLockStaleAfter)
+		if err != nil {
+			return err
+		}
+		if ok {
+			return nil
+		}
+		if lockClock.Now().After(deadline) {
+			if current == "" {
+				current = "unknown"
+			}
+			return fmt.Errorf("timeout waiting for remote lock %s after %v (held by %s)",
+				filepath.Join(stackPath, lockDir), timeout, current)
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-lockClock.After(remoteLockPollInterval):
+		}
+	}
+}
+
+// startLockHeartbeat refreshes the remote lock lockDir every
+// remoteLockHeartbeat until the returned func is called, so the lock's
+// age tracks the holder's last sign of life rather than when it was
+// taken. A failed refresh is logged; the next tick tries again.
+func startLockHeartbeat(ctx context.Context, locker RemoteLocker, lockDir, holder string) func() {
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		ticker := time.NewTicker(remoteLockHeartbeat)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := locker.RefreshLock(ctx, lockDir, holder); err != nil {
+					log.Printf("failed to refresh remote lock: %v", err)
+				}
+			}
+		}
+	}()
+	return func() {
+		close(done)
+		wg.Wait()
+	}
+}
+
+// lockHolder describes this process for the remote lock file, so a
+// blocked deploy can tell who it is waiting on.
+func lockHolder() string {
+	name := os.Getenv("USER")
+	if u, err := user.Current(); err == nil && u.Username != "" {
+		name = u.Username
+	}
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown"
+	}
+	return fmt.Sprintf("%s@%s pid %d since %s", name, host, os.Getpid(), time.Now().UTC().Format(time.RFC3339))
+}
@@ -0,0 +1,264 @@
+package deploy
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/byteink/ssd/config"
+	"github.com/byteink/ssd/internal/clock"
+	"github.com/byteink/ssd/remote"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// lockingDeployer is a MockDeployer that also implements RemoteLocker,
+// simulating a lock on the server shared by several callers. server is
+// the stack lock; service locks get fakes of their own.
+type lockingDeployer struct {
+	MockDeployer
+	server   *fakeRemoteLock
+	mu       sync.Mutex
+	services map[string]*fakeRemoteLock
+}
+
+func (d *lockingDeployer) lock(name string) *fakeRemoteLock {
+	if name == remote.LockDir {
+		return d.server
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.services == nil {
+		d.services = make(map[string]*fakeRemoteLock)
+	}
+	if d.services[name] == nil {
+		d.services[name] = &fakeRemoteLock{}
+	}
+	return d.services[name]
+}
+
+func (d *lockingDeployer) TryLock(ctx context.Context, lock, holder string, staleAfter time.Duration) (bool, string, error) {
+	return d.lock(lock).tryLock(holder, staleAfter)
+}
+
+func (d *lockingDeployer) RefreshLock(ctx context.Context, lock, holder string) error {
+	d.lock(lock).refresh(holder)
+	return nil
+}
+
+func (d *lockingDeployer) Unlock(ctx context.Context, lock, holder string) error {
+	d.lock(lock).unlock(holder)
+	return nil
+}
+
+// fakeRemoteLock mimics the mkdir-based lock in the stack directory.
+type fakeRemoteLock struct {
+	mu       sync.Mutex
+	holder   string
+	takenAt  time.Time
+	attempts int
+	// refreshes counts RefreshLock calls by the holder.
+	refreshes int
+	err       error
+	// releaseAt, when set, makes the current holder let go just before
+	// that attempt.
+	releaseAt int
+}
+
+func (f *fakeRemoteLock) tryLock(holder string, staleAfter time.Duration) (bool, string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.attempts++
+	if f.err != nil {
+		return false, "", f.err
+	}
+	if f.attempts == f.releaseAt {
+		f.holder = ""
+	}
+	if f.holder == "" || time.Since(f.takenAt) > staleAfter {
+		f.holder, f.takenAt = holder, time.Now()
+		return true, "", nil
+	}
+	return false, f.holder, nil
+}
+
+func (f *fakeRemoteLock) refresh(holder string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.holder == holder {
+		f.takenAt = time.Now()
+		f.refreshes++
+	}
+}
+
+func (f *fakeRemoteLock) refreshCount() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.refreshes
+}
+
+func (f *fakeRemoteLock) unlock(holder string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.holder == holder {
+		f.holder = ""
+	}
+}
+
+// fakeLockClock makes lock waits use a fake clock for the rest of the test.
+func fakeLockClock(t *testing.T) *clock.Fake {
+	t.Helper()
+	orig := lockClock
+	clk := clock.NewFake(time.Now())
+	lockClock = clk
+	t.Cleanup(func() { lockClock = orig })
+	return clk
+}
+
+func TestLockStack_AcquiresAndReleasesRemoteLock(t *testing.T) {
+	server := &fakeRemoteLock{}
+	client := &lockingDeployer{server: server}
+	cfg := newTestConfig()
+
+	unlock, err := lockStack(context.Background(), cfg, client, nil)
+	require.NoError(t, err)
+	assert.NotEmpty(t, server.holder, "remote lock should be held")
+
+	unlock()
+	assert.Empty(t, server.holder, "remote lock should be released")
+}
+
+func TestLockStack_HeartbeatKeepsRemoteLockFresh(t *testing.T) {
+	orig := remoteLockHeartbeat
+	remoteLockHeartbeat = time.Millisecond
+	t.Cleanup(func() { remoteLockHeartbeat = orig })
+	server := &fakeRemoteLock{}
+	client := &lockingDeployer{server: server}
+
+	unlock, err := lockStack(context.Background(), newTestConfig(), client, nil)
+	require.NoError(t, err)
+	holder := server.holder
+	// Age the lock as a long build would; the heartbeat must renew it.
+	server.mu.Lock()
+	server.takenAt = time.Now().Add(-2 * remoteLockStaleAfter)
+	before := server.refreshes
+	server.mu.Unlock()
+	require.Eventually(t, func() bool { return server.refreshCount() > before }, 5*time.Second, time.Millisecond)
+
+	ok, current, err := server.tryLock("bob@laptop pid 7", remoteLockStaleAfter)
+	require.NoError(t, err)
+	assert.False(t, ok, "a refreshed lock must not be taken over")
+	assert.Equal(t, holder, current)
+
+	unlock()
+	after := server.refreshCount()
+	assert.Empty(t, server.holder)
+	assert.Equal(t, after, server.refreshCount(), "the heartbeat stops with the lock")
+}
+
+func TestLockStack_KeyedOnStackOverride(t *testing.T) {
+	rootCfg, err := config.LoadFromBytes([]byte("server: srv\nstack: /stacks/lock-override\nservices:\n  web: {}\n"))
+	require.NoError(t, err)
+	rootCfg.OverrideStack("/stacks/lock-override-staging")
+	cfg, err := rootCfg.GetService("web")
+	require.NoError(t, err)
+
+	assert.Equal(t, "/stacks/lock-override-staging#web", serviceLockKey(cfg))
+
+	// A deploy holding the configured stack doesn't hold up the copy.
+	unlock, err := acquireLockWithTimeout("/stacks/lock-override", time.Second)
+	require.NoError(t, err)
+	defer unlock()
+	unlockCopy, err := lockStack(context.Background(), cfg, new(MockDeployer), &Options{LockTimeout: time.Second})
+	require.NoError(t, err)
+	unlockCopy()
+}
+
+func TestLockStack_ClientWithoutRemoteLock(t *testing.T) {
+	unlock, err := lockStack(context.Background(), newTestConfig(), new(MockDeployer), nil)
+	require.NoError(t, err)
+	unlock()
+}
+
+func TestAcquireRemoteLock_WaitsForHolder(t *testing.T) {
+	clk := fakeLockClock(t)
+	server := &fakeRemoteLock{holder: "bob@laptop pid 1", takenAt: time.Now(), releaseAt: 3}
+
+	err := acquireRemoteLock(context.Background(), &lockingDeployer{server: server}, "/stacks/myapp", remote.LockDir, "alice@desk pid 2", time.Minute)
+	require.NoError(t, err)
+	assert.Equal(t, "alice@desk pid 2", server.holder)
+	assert.Equal(t, 3, server.attempts, "second caller should have been blocked")
+	assert.Equal(t, []time.Duration{remoteLockPollInterval, remoteLockPollInterval}, clk.Waits())
+}
+
+func TestAcquireRemoteLock_ContentionTimesOut(t *testing.T) {
+	clk := fakeLockClock(t)
+	server := &fakeRemoteLock{holder: "bob@laptop pid 1", takenAt: time.Now()}
+
+	err := acquireRemoteLock(context.Background(), &lockingDeployer{server: server}, "/stacks/myapp", remote.LockDir, "alice@desk pid 2", 10*time.Second)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "timeout waiting for remote lock")
+	assert.Contains(t, err.Error(), "bob@laptop pid 1")
+	assert.Equal(t, "bob@laptop pid 1", server.holder)
+	// Tries every 2s from 0 to 12s; the attempt past the deadline gives up.
+	assert.Equal(t, 7, server.attempts)
+	assert.Len(t, clk.Waits(), 6)
+}
+
+func TestAcquireRemoteLock_TakesOverStaleLock(t *testing.T) {
+	server := &fakeRemoteLock{holder: "crashed@ci pid 9", takenAt: time.Now().Add(-2 * remoteLockStaleAfter)}
+
+	err := acquireRemoteLock(context.Background(), &lockingDeployer{server: server}, "/stacks/myapp", remote.LockDir, "alice@desk pid 2", time.Second)
+	require.NoError(t, err)
+	assert.Equal(t, "alice@desk pid 2", server.holder)
+}
+
+func TestDeploy_RemoteLockErrorReleasesLocalLock(t *testing.T) {
+	server := &fakeRemoteLock{err: errors.New("ssh: connection refused")}
+	client := &lockingDeployer{server: server}
+	cfg := newTestConfig()
+
+	err := DeployWithClient(cfg, client, nil)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "failed to acquire remote deployment lock")
+
+	unlock, err := acquireLock(cfg.StackPath())
+	require.NoError(t, err, "local lock should be released when the remote lock fails")
+	unlock()
+}
+
+func TestLockStack_CustomTimeoutRespected(t *testing.T) {
+	clk := fakeLockClock(t)
+	cfg := newTestConfig()
+	cfg.Stack = "/stacks/lock-timeout-test"
+
+	held, err := acquireLock(cfg.StackPath())
+	require.NoError(t, err)
+	defer held()
+
+	start := clk.Now()
+	_, err = lockStack(context.Background(), cfg, new(MockDeployer), &Options{LockTimeout: 150 * time.Millisecond})
+	elapsed := clk.Now().Sub(start)
+
+	require.Error(t, err)
+	assert.Less(t, elapsed, 2*time.Second, "custom timeout should replace the 5 minute default")
+	assert.GreaterOrEqual(t, elapsed, 150*time.Millisecond)
+	assert.Contains(t, err.Error(), "after 150ms")
+	assert.Contains(t, err.Error(), "ssd-lock-", "error should name the local lock file")
+}
+
+func TestDeploy_LockTimeoutReportsRemoteHolder(t *testing.T) {
+	fakeLockClock(t)
+	server := &fakeRemoteLock{holder: "bob@laptop pid 42 since 2026-01-01T00:00:00Z", takenAt: time.Now()}
+	client := &lockingDeployer{server: server}
+	cfg := newTestConfig()
+
+	err := DeployWithClient(cfg, client, &Options{LockTimeout: 20 * time.Millisecond})
+
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "/stacks/myapp/.ssd-lock")
+	assert.Contains(t, err.Error(), "held by bob@laptop pid 42 since 2026-01-01T00:00:00Z")
+	assert.Contains(t, err.Error(), "after 20ms")
+}
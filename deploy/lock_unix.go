@@ -30,9 +30,7 @@ func acquireLockWithTimeout(stackPath string, timeout time.Duration) (func(), er
 		return nil, fmt.Errorf("failed to create lock file: %w", err)
 	}
 
-	deadline := time.Now().Add(timeout)
-	ticker := time.NewTicker(100 * time.Millisecond)
-	defer ticker.Stop()
+	deadline := lockClock.Now().Add(timeout)
 
 	for {
 		err = unix.Flock(int(lockFile.Fd()), unix.LOCK_EX|unix.LOCK_NB)
@@ -47,14 +45,14 @@ func acquireLockWithTimeout(stackPath string, timeout time.Duration) (func(), er
 			return nil, fmt.Errorf("failed to acquire lock: %w", err)
 		}
 
-		if time.Now().After(deadline) {
+		if lockClock.Now().After(deadline) {
 			if closeErr := lockFile.Close(); closeErr != nil {
 				log.Printf("failed to close lock file: %v", closeErr)
 			}
-			return nil, fmt.Errorf("timeout waiting for deployment lock after %v", timeout)
+			return nil, fmt.Errorf("timeout waiting for deployment lock %s after %v (held by another ssd process on this machine)", lockPath, timeout)
 		}
 
-		<-ticker.C
+		<-lockClock.After(localLockPollInterval)
 	}
 
 	return func() {
@@ -0,0 +1,230 @@
+package deploy
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+
+	"github.com/byteink/ssd/config"
+)
+
+// Plan step actions, in the order DeployWithResult performs them.
+const (
+	StepCreateEnvFiles  = "create-env-files"
+	StepSeedEnvFiles    = "seed-env-files"
+	StepCreateStack     = "create-stack"
+	StepEnsureNetwork   = "ensure-network"
+	StepCopyFiles       = "copy-files"
+	StepSkipUnchanged   = "skip-unchanged"
+	StepStartDependency = "start-dependency"
+	StepPull            = "pull"
+	StepPinDigest       = "pin-digest"
+	StepSync            = "sync"
+	StepBuild           = "build"
+	StepUpdateManifest  = "update-manifest"
+	StepUploadEnvFile   = "upload-env-file"
+	StepPreStart        = "pre-start"
+	StepStart           = "start"
+	StepHealthGate      = "health-gate"
+	StepWaitHealthy     = "wait-healthy"
+	StepSmokeTest       = "smoke-test"
+	StepSchedule        = "schedule"
+	StepPruneTags       = "prune-tags"
+	StepRecordHistory   = "record-history"
+)
+
+// Step is one action of a Plan. Target names what it acts on (a service,
+// network, image or file) and Detail adds the parameters that matter.
+type Step struct {
+	Action string `json:"action"`
+	Target string `json:"target,omitempty"`
+	Detail string `json:"detail,omitempty"`
+}
+
+// Plan is what a deploy of one service would do, computed from config and
+// the current state on the server.
+type Plan struct {
+	Service     string `json:"service"`
+	Server      string `json:"server"`
+	Stack       string `json:"stack"`
+	Runtime     string `json:"runtime"`
+	FirstDeploy bool   `json:"first_deploy"`
+	OldVersion  int    `json:"old_version"`
+	NewVersion  int    `json:"new_version"`
+	Strategy    string `json:"strategy"`
+	Steps       []Step `json:"steps"`
+}
+
+func (p *Plan) add(action, target, detail string) {
+	p.Steps = append(p.Steps, Step{Action: action, Target: target, Detail: detail})
+}
+
+// PlanDeploy returns the steps DeployWithResult would take for cfg with
+// opts, in order. It only reads from the server (stack existence, current
+// version, running dependencies and, with deploy.skip_unchanged, the
+// recorded source tree); nothing is changed and no lock is taken.
+func PlanDeploy(ctx context.Context, cfg *config.Config, client Deployer, opts *Options) (Plan, error) {
+	if opts == nil {
+		opts = &Options{}
+	}
+	rt := opts.Runtime
+	if rt == "" {
+		rt = "compose"
+	}
+	plan := Plan{
+		Service:  cfg.Name,
+		Server:   cfg.Server,
+		Stack:    cfg.StackPath(),
+		Runtime:  rt,
+		Strategy: cfg.DeployStrategy(),
+		Steps:    []Step{},
+	}
+
+	stackExists, err := client.StackExists(ctx)
+	if err != nil {
+		return plan, fmt.Errorf("failed to check stack existence: %w", err)
+	}
+	if !stackExists && opts.SkipBuild {
+		return plan, errNotDeployed(cfg)
+	}
+	services := map[string]*config.Config{cfg.Name: cfg}
+	if len(opts.AllServices) > 0 {
+		services = opts.AllServices
+	}
+	manifest := manifestName(rt, cfg)
+
+	if !stackExists {
+		plan.FirstDeploy = true
+		for _, name := range envFileNames(services) {
+			plan.add(StepCreateEnvFiles, name, "")
+		}
+		for _, name := range sortedKeys(opts.SeedEnvFiles) {
+			plan.add(StepSeedEnvFiles, name, opts.SeedEnvFiles[name])
+		}
+		plan.add(StepCreateStack, manifest, "")
+		if rt != "k3s" {
+			for _, svc := range services {
+				if svc.PrimaryDomain() != "" {
+					plan.add(StepEnsureNetwork, "traefik_web", "")
+					break
+				}
+			}
+			plan.add(StepEnsureNetwork, config.ProjectName(services, cfg.StackPath())+"_internal", "")
+		}
+	}
+
+	for _, local := range sortedKeys(cfg.Files) {
+		plan.add(StepCopyFiles, local, cfg.Files[local])
+	}
+
+	currentVersion, err := client.GetCurrentVersion(ctx)
+	if err != nil {
+		return plan, fmt.Errorf("failed to get current version: %w", err)
+	}
+	plan.OldVersion = currentVersion
+	if opts.SkipBuild && currentVersion == 0 && !cfg.IsPrebuilt() {
+		return plan, errNotDeployed(cfg)
+	}
+
+	if opts.Sources != nil && cfg.SkipUnchanged() && opts.ForceVersion == 0 && len(opts.Run.BuildArgs) == 0 && !opts.SkipBuild {
+		if _, unchanged := checkSource(ctx, opts.Sources, cfg, ConfigDigest(cfg, opts.Run.BuildArgs), io.Discard); unchanged && currentVersion > 0 && !opts.Force {
+			plan.NewVersion = currentVersion
+			plan.add(StepSkipUnchanged, cfg.Name, "source unchanged since version "+strconv.Itoa(currentVersion))
+			return plan, nil
+		}
+	}
+
+	newVersion := currentVersion + 1
+	switch {
+	case opts.ForceVersion > 0:
+		newVersion = opts.ForceVersion
+	case opts.SkipBuild, opts.ImageOverride:
+		newVersion = currentVersion
+	}
+	plan.NewVersion = newVersion
+
+	if !opts.BuildOnly && !opts.NoDeps {
+		for _, dep := range cfg.DependsOn.Names() {
+			if depCfg := dependencyConfig(opts, dep); depCfg != nil && depCfg.ManualStart() {
+				continue
+			}
+			if !opts.RecreateDeps {
+				running, err := client.IsServiceRunning(ctx, dep)
+				if err != nil {
+					return plan, fmt.Errorf("failed to check if dependency %s is running: %w", dep, err)
+				}
+				if running {
+					continue
+				}
+			}
+			if depCfg, ok := opts.Dependencies[dep]; ok && depCfg.IsPrebuilt() {
+				plan.add(StepPull, depCfg.Image, "dependency "+dep)
+			}
+			plan.add(StepStartDependency, dep, "")
+		}
+	}
+
+	switch {
+	case opts.SkipBuild:
+	case cfg.IsPrebuilt():
+		plan.add(StepPull, cfg.Image, "")
+		if cfg.PinDigest() {
+			plan.add(StepPinDigest, cfg.Image, "")
+		}
+	default:
+		plan.add(StepSync, cfg.Context, cfg.Server)
+		plan.add(StepBuild, fmt.Sprintf("%s:%d", cfg.ImageName(), newVersion), cfg.Dockerfile)
+	}
+
+	if len(opts.AllServices) > 0 || !cfg.IsPrebuilt() {
+		plan.add(StepUpdateManifest, manifest, fmt.Sprintf("%s version %d", cfg.Name, newVersion))
+	}
+	for _, name := range sortedKeys(services) {
+		if svc := services[name]; svc != nil && svc.EnvFile != "" {
+			plan.add(StepUploadEnvFile, name, svc.EnvFile)
+		}
+	}
+
+	if opts.BuildOnly {
+		plan.Strategy = "build-only"
+		return plan, nil
+	}
+
+	if cfg.ManualStart() {
+		plan.Strategy = "manual"
+	} else {
+		if cfg.PreStart != nil {
+			plan.add(StepPreStart, cfg.Name, cfg.PreStart.Command)
+		}
+		plan.add(StepStart, cfg.Name, cfg.DeployStrategy())
+		switch {
+		case opts.HealthWait == WaitNone:
+		case opts.HealthWait == WaitDefault || healthGateWaits(cfg):
+			if healthGateWaits(cfg) {
+				plan.add(StepHealthGate, cfg.Name, "timeout "+cfg.HealthGateTimeout().String()+", rolls back on failure")
+			}
+		default:
+			plan.add(StepWaitHealthy, cfg.Name, "timeout "+cfg.HealthGateTimeout().String())
+		}
+		if cfg.SmokeTest != nil && opts.HealthWait != WaitNone {
+			url, _ := cfg.SmokeTestURL()
+			detail := "GET " + url + ", timeout " + cfg.SmokeTestTimeout().String()
+			if cfg.HealthGateEnabled() {
+				detail += ", rolls back on failure"
+			}
+			plan.add(StepSmokeTest, cfg.Name, detail)
+		}
+	}
+
+	if opts.Scheduler != nil && cfg.Schedule != "" {
+		plan.add(StepSchedule, cfg.Name, cfg.Schedule)
+	}
+	if opts.TagCleaner != nil && !cfg.IsPrebuilt() && cfg.RetainTags() > 0 {
+		plan.add(StepPruneTags, cfg.ImageName(), "keep "+strconv.Itoa(cfg.RetainTags()))
+	}
+	if opts.History != nil {
+		plan.add(StepRecordHistory, cfg.Name, "version "+strconv.Itoa(newVersion))
+	}
+	return plan, nil
+}
@@ -0,0 +1,235 @@
+package deploy
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+
+	"github.com/byteink/ssd/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// planFixture is a built web service behind a domain with a health gate,
+// depending on a pre-built db.
+func planFixture() (*config.Config, *Options) {
+	web := &config.Config{
+		Name: "web", Server: "srv", Stack: "/stacks/shop", Context: ".", Dockerfile: "./Dockerfile",
+		Domain:      "shop.example.com",
+		HealthCheck: &config.HealthCheck{Cmd: "curl -f localhost", Interval: "5s", Timeout: "2s", Retries: 3},
+		Deploy:      &config.DeployConfig{Strategy: "rollout", HealthGate: true, HealthTimeout: "90s"},
+		DependsOn:   config.Dependencies{{Name: "db"}},
+	}
+	db := &config.Config{Name: "db", Server: "srv", Stack: "/stacks/shop", Image: "postgres:16"}
+	all := map[string]*config.Config{"web": web, "db": db}
+	return web, &Options{
+		Dependencies: map[string]*config.Config{"db": db},
+		AllServices:  all,
+		Runtime:      "compose",
+		TagCleaner:   &fakeTagCleaner{},
+		History:      &recordingHistory{},
+	}
+}
+
+func planActions(p Plan) []string {
+	actions := make([]string, len(p.Steps))
+	for i, s := range p.Steps {
+		actions[i] = s.Action
+	}
+	return actions
+}
+
+func TestPlanDeploy_FirstDeploy(t *testing.T) {
+	cfg, opts := planFixture()
+	client := new(MockDeployer)
+	client.On("StackExists").Return(false, nil)
+	client.On("GetCurrentVersion").Return(0, nil)
+	client.On("IsServiceRunning", "db").Return(false, nil)
+
+	plan, err := PlanDeploy(context.Background(), cfg, client, opts)
+
+	require.NoError(t, err)
+	assert.True(t, plan.FirstDeploy)
+	assert.Equal(t, 0, plan.OldVersion)
+	assert.Equal(t, 1, plan.NewVersion)
+	assert.Equal(t, "rollout", plan.Strategy)
+	assert.Equal(t, []Step{
+		{Action: StepCreateEnvFiles, Target: "db"},
+		{Action: StepCreateEnvFiles, Target: "web"},
+		{Action: StepCreateStack, Target: "compose.yaml"},
+		{Action: StepEnsureNetwork, Target: "traefik_web"},
+		{Action: StepEnsureNetwork, Target: "shop_internal"},
+		{Action: StepPull, Target: "postgres:16", Detail: "dependency db"},
+		{Action: StepStartDependency, Target: "db"},
+		{Action: StepSync, Target: ".", Detail: "srv"},
+		{Action: StepBuild, Target: "ssd-shop-web:1", Detail: "./Dockerfile"},
+		{Action: StepUpdateManifest, Target: "compose.yaml", Detail: "web version 1"},
+		{Action: StepStart, Target: "web", Detail: "rollout"},
+		{Action: StepHealthGate, Target: "web", Detail: "timeout 1m30s, rolls back on failure"},
+		{Action: StepPruneTags, Target: "ssd-shop-web", Detail: "keep 2"},
+		{Action: StepRecordHistory, Target: "web", Detail: "version 1"},
+	}, plan.Steps)
+	client.AssertExpectations(t)
+}
+
+func TestPlanDeploy_SubsequentDeploy(t *testing.T) {
+	cfg, opts := planFixture()
+	client := new(MockDeployer)
+	client.On("StackExists").Return(true, nil)
+	client.On("GetCurrentVersion").Return(7, nil)
+	client.On("IsServiceRunning", "db").Return(true, nil)
+
+	plan, err := PlanDeploy(context.Background(), cfg, client, opts)
+
+	require.NoError(t, err)
+	assert.False(t, plan.FirstDeploy)
+	assert.Equal(t, 7, plan.OldVersion)
+	assert.Equal(t, 8, plan.NewVersion)
+	assert.Equal(t, []string{
+		StepSync, StepBuild, StepUpdateManifest, StepStart, StepHealthGate, StepPruneTags, StepRecordHistory,
+	}, planActions(plan))
+	assert.Equal(t, "ssd-shop-web:8", plan.Steps[1].Target)
+}
+
+func TestPlanDeploy_Variants(t *testing.T) {
+	existing := func() *MockDeployer {
+		client := new(MockDeployer)
+		client.On("StackExists").Return(true, nil)
+		client.On("GetCurrentVersion").Return(3, nil)
+		client.On("IsServiceRunning", "db").Return(true, nil)
+		return client
+	}
+
+	t.Run("detach skips the health gate", func(t *testing.T) {
+		cfg, opts := planFixture()
+		opts.HealthWait = WaitNone
+		plan, err := PlanDeploy(context.Background(), cfg, existing(), opts)
+		require.NoError(t, err)
+		assert.NotContains(t, planActions(plan), StepHealthGate)
+	})
+
+	t.Run("no-deps leaves dependencies alone", func(t *testing.T) {
+		cfg, opts := planFixture()
+		opts.NoDeps = true
+		client := new(MockDeployer)
+		client.On("StackExists").Return(true, nil)
+		client.On("GetCurrentVersion").Return(3, nil)
+		plan, err := PlanDeploy(context.Background(), cfg, client, opts)
+		require.NoError(t, err)
+		assert.NotContains(t, planActions(plan), StepStartDependency)
+		client.AssertNotCalled(t, "IsServiceRunning", "db")
+	})
+
+	t.Run("recreate-deps starts running dependencies", func(t *testing.T) {
+		cfg, opts := planFixture()
+		opts.RecreateDeps = true
+		plan, err := PlanDeploy(context.Background(), cfg, existing(), opts)
+		require.NoError(t, err)
+		assert.Contains(t, plan.Steps, Step{Action: StepStartDependency, Target: "db"})
+		assert.Contains(t, plan.Steps, Step{Action: StepPull, Target: "postgres:16", Detail: "dependency db"})
+	})
+
+	t.Run("skip-build restarts the current version", func(t *testing.T) {
+		cfg, opts := planFixture()
+		opts.SkipBuild = true
+		plan, err := PlanDeploy(context.Background(), cfg, existing(), opts)
+		require.NoError(t, err)
+		assert.Equal(t, 3, plan.NewVersion)
+		assert.Equal(t, []string{
+			StepUpdateManifest, StepStart, StepHealthGate, StepPruneTags, StepRecordHistory,
+		}, planActions(plan))
+	})
+
+	t.Run("skip-build needs a previous deploy", func(t *testing.T) {
+		cfg, opts := planFixture()
+		opts.SkipBuild = true
+		client := new(MockDeployer)
+		client.On("StackExists").Return(true, nil)
+		client.On("GetCurrentVersion").Return(0, nil)
+		_, err := PlanDeploy(context.Background(), cfg, client, opts)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "web has not been deployed yet")
+	})
+
+	t.Run("smoke test follows the health gate", func(t *testing.T) {
+		cfg, opts := planFixture()
+		cfg.SmokeTest = &config.SmokeTestConfig{URL: "https://app.example.com/healthz"}
+		plan, err := PlanDeploy(context.Background(), cfg, existing(), opts)
+		require.NoError(t, err)
+		actions := planActions(plan)
+		require.Contains(t, actions, StepSmokeTest)
+		assert.Equal(t, slices.Index(actions, StepHealthGate)+1, slices.Index(actions, StepSmokeTest))
+		assert.Contains(t, plan.Steps[slices.Index(actions, StepSmokeTest)].Detail, "GET https://app.example.com/healthz, timeout 30s, rolls back on failure")
+	})
+
+	t.Run("build only stops before starting", func(t *testing.T) {
+		cfg, opts := planFixture()
+		opts.BuildOnly = true
+		plan, err := PlanDeploy(context.Background(), cfg, existing(), opts)
+		require.NoError(t, err)
+		assert.Equal(t, "build-only", plan.Strategy)
+		assert.Equal(t, []string{StepSync, StepBuild, StepUpdateManifest}, planActions(plan))
+	})
+
+	t.Run("unchanged source skips", func(t *testing.T) {
+		cfg, opts := planFixture()
+		cfg.Deploy.SkipUnchanged = true
+		opts.Sources = &fakeSources{tree: "abc", deployed: sourceRecord(cfg, "abc")}
+		plan, err := PlanDeploy(context.Background(), cfg, existing(), opts)
+		require.NoError(t, err)
+		assert.Equal(t, 3, plan.NewVersion)
+		assert.Equal(t, []string{StepSkipUnchanged}, planActions(plan))
+	})
+
+	t.Run("forced version builds despite unchanged source", func(t *testing.T) {
+		cfg, opts := planFixture()
+		cfg.Deploy.SkipUnchanged = true
+		opts.Sources = &fakeSources{tree: "abc", deployed: sourceRecord(cfg, "abc")}
+		opts.ForceVersion = 2
+		plan, err := PlanDeploy(context.Background(), cfg, existing(), opts)
+		require.NoError(t, err)
+		assert.Equal(t, 2, plan.NewVersion)
+		assert.Equal(t, "ssd-shop-web:2", plan.Steps[1].Target)
+	})
+
+	t.Run("build arg builds despite unchanged source", func(t *testing.T) {
+		cfg, opts := planFixture()
+		cfg.Deploy.SkipUnchanged = true
+		opts.Run.BuildArgs = map[string]string{"BUILD_NUMBER": "42"}
+		deployed := Result{SourceTree: "abc", ConfigDigest: ConfigDigest(cfg, opts.Run.BuildArgs)}.SourceRecord()
+		opts.Sources = &fakeSources{tree: "abc", deployed: deployed}
+		plan, err := PlanDeploy(context.Background(), cfg, existing(), opts)
+		require.NoError(t, err)
+		assert.Equal(t, 4, plan.NewVersion)
+		assert.NotContains(t, planActions(plan), StepSkipUnchanged)
+	})
+
+	t.Run("pre-built with pre_start", func(t *testing.T) {
+		cfg, opts := planFixture()
+		cfg.Image = "shop/web:2"
+		cfg.PreStart = &config.PreStartConfig{Command: "migrate"}
+		cfg.Deploy.HealthGate = false
+		cfg.Deploy.Strategy = "recreate"
+		plan, err := PlanDeploy(context.Background(), cfg, existing(), opts)
+		require.NoError(t, err)
+		assert.Equal(t, []Step{
+			{Action: StepPull, Target: "shop/web:2"},
+			{Action: StepUpdateManifest, Target: "compose.yaml", Detail: "web version 4"},
+			{Action: StepPreStart, Target: "web", Detail: "migrate"},
+			{Action: StepStart, Target: "web", Detail: "recreate"},
+			{Action: StepRecordHistory, Target: "web", Detail: "version 4"},
+		}, plan.Steps)
+	})
+}
+
+func TestPlanDeploy_ReadError(t *testing.T) {
+	cfg, opts := planFixture()
+	client := new(MockDeployer)
+	client.On("StackExists").Return(false, errors.New("ssh: connection refused"))
+
+	_, err := PlanDeploy(context.Background(), cfg, client, opts)
+
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "failed to check stack existence")
+}
@@ -0,0 +1,123 @@
+package deploy
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/byteink/ssd/config"
+	"github.com/byteink/ssd/internal/clock"
+	"github.com/byteink/ssd/remote"
+)
+
+// ServerProber is implemented by clients that can make an HTTP request
+// from the server, for smoke_test.from_server.
+type ServerProber interface {
+	ProbeURL(ctx context.Context, url string, timeout time.Duration) (int, error)
+}
+
+var _ ServerProber = (*remote.Client)(nil)
+
+// smokeClock times smoke test retries. Tests swap in a clock.Fake.
+var smokeClock clock.Clock = clock.Real{}
+
+const (
+	// smokePollInterval is the pause between smoke test attempts.
+	smokePollInterval = 2 * time.Second
+	// smokeAttemptTimeout caps a single smoke test request.
+	smokeAttemptTimeout = 10 * time.Second
+)
+
+// probeFunc requests url and returns the response's status code.
+type probeFunc func(ctx context.Context, url string, timeout time.Duration) (int, error)
+
+// SmokeTest requests cfg's smoke_test URL, from here or with
+// smoke_test.from_server from the server, until it answers with the
+// expected status or smoke_test.timeout passes. It runs after
+// AwaitHealthy and is skipped with WaitNone (--detach).
+//
+// When the smoke test fails and deploy.health_gate is enabled, the
+// service is rolled back to previousVersion as for a failed health check.
+func SmokeTest(ctx context.Context, client Deployer, cfg *config.Config, previousVersion int, mode HealthWait, output io.Writer) error {
+	if cfg.SmokeTest == nil || mode == WaitNone {
+		return nil
+	}
+	url, err := cfg.SmokeTestURL()
+	if err != nil {
+		return fmt.Errorf("%s smoke test: %w", cfg.Name, err)
+	}
+
+	probe, from := probeFunc(probeLocal), ""
+	if cfg.SmokeTest.FromServer {
+		prober, ok := client.(ServerProber)
+		if !ok {
+			return fmt.Errorf("%s smoke test: this client cannot make requests from the server", cfg.Name)
+		}
+		probe, from = prober.ProbeURL, " from the server"
+	}
+
+	logf(output, "==> Smoke testing %s: GET %s%s (up to %v)...\n", cfg.Name, url, from, cfg.SmokeTestTimeout())
+	smokeErr := awaitSmoke(ctx, probe, url, cfg.SmokeTest.Status, cfg.SmokeTestTimeout())
+	if smokeErr == nil {
+		logf(output, "    %s passed the smoke test\n", cfg.Name)
+		return nil
+	}
+	if !cfg.HealthGateEnabled() {
+		return fmt.Errorf("%s failed smoke test: %w", cfg.Name, smokeErr)
+	}
+	return rollBack(ctx, client, cfg, previousVersion, "smoke test", smokeErr, output)
+}
+
+// awaitSmoke probes url every smokePollInterval until the status passes
+// checkSmokeStatus or timeout passes, and returns the last failure.
+func awaitSmoke(ctx context.Context, probe probeFunc, url string, want int, timeout time.Duration) error {
+	deadline := smokeClock.Now().Add(timeout)
+	for {
+		status, err := probe(ctx, url, min(timeout, smokeAttemptTimeout))
+		if err == nil {
+			if err = checkSmokeStatus(status, want); err == nil {
+				return nil
+			}
+		}
+		if !smokeClock.Now().Add(smokePollInterval).Before(deadline) {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-smokeClock.After(smokePollInterval):
+		}
+	}
+}
+
+// checkSmokeStatus passes status when it is want, or any 2xx when want
+// is 0.
+func checkSmokeStatus(status, want int) error {
+	if want == 0 {
+		if status < 200 || status > 299 {
+			return fmt.Errorf("got HTTP %d, want 2xx", status)
+		}
+		return nil
+	}
+	if status != want {
+		return fmt.Errorf("got HTTP %d, want %d", status, want)
+	}
+	return nil
+}
+
+// probeLocal requests url from this machine, following redirects.
+func probeLocal(ctx context.Context, url string, timeout time.Duration) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return 0, err
+	}
+	resp, err := (&http.Client{Timeout: timeout}).Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 1<<20))
+	return resp.StatusCode, nil
+}
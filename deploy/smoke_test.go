@@ -0,0 +1,191 @@
+package deploy
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/byteink/ssd/config"
+	"github.com/byteink/ssd/internal/clock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeSmokeClock makes smoke test retries use a fake clock for the rest
+// of the test.
+func fakeSmokeClock(t *testing.T) {
+	t.Helper()
+	orig := smokeClock
+	smokeClock = clock.NewFake(time.Now())
+	t.Cleanup(func() { smokeClock = orig })
+}
+
+// smokeServer answers with the statuses in order, repeating the last one,
+// and counts the requests.
+func smokeServer(t *testing.T, statuses ...int) (*httptest.Server, *atomic.Int32) {
+	t.Helper()
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := int(calls.Add(1))
+		w.WriteHeader(statuses[min(n, len(statuses))-1])
+	}))
+	t.Cleanup(srv.Close)
+	return srv, &calls
+}
+
+func newSmokeConfig(url string) *config.Config {
+	cfg := newTestConfig()
+	cfg.SmokeTest = &config.SmokeTestConfig{URL: url, Timeout: "10s"}
+	return cfg
+}
+
+func TestCheckSmokeStatus(t *testing.T) {
+	tests := []struct {
+		status, want int
+		pass         bool
+	}{
+		{200, 0, true},
+		{204, 0, true},
+		{299, 0, true},
+		{301, 0, false},
+		{404, 0, false},
+		{500, 0, false},
+		{401, 401, true},
+		{200, 401, false},
+	}
+	for _, tt := range tests {
+		err := checkSmokeStatus(tt.status, tt.want)
+		assert.Equal(t, tt.pass, err == nil, "status %d, want %d: %v", tt.status, tt.want, err)
+	}
+}
+
+func TestSmokeTest_Passes(t *testing.T) {
+	fakeSmokeClock(t)
+	srv, calls := smokeServer(t, http.StatusOK)
+	mockClient := new(MockDeployer)
+
+	err := SmokeTest(context.Background(), mockClient, newSmokeConfig(srv.URL), 4, WaitDefault, io.Discard)
+
+	require.NoError(t, err)
+	assert.Equal(t, int32(1), calls.Load())
+	mockClient.AssertNotCalled(t, "UpdateManifest", 4)
+}
+
+func TestSmokeTest_RetriesUntilPass(t *testing.T) {
+	fakeSmokeClock(t)
+	srv, calls := smokeServer(t, http.StatusBadGateway, http.StatusBadGateway, http.StatusOK)
+
+	err := SmokeTest(context.Background(), new(MockDeployer), newSmokeConfig(srv.URL), 4, WaitDefault, io.Discard)
+
+	require.NoError(t, err)
+	assert.Equal(t, int32(3), calls.Load())
+}
+
+func TestSmokeTest_FailsWithoutHealthGate(t *testing.T) {
+	fakeSmokeClock(t)
+	srv, calls := smokeServer(t, http.StatusInternalServerError)
+	mockClient := new(MockDeployer)
+
+	err := SmokeTest(context.Background(), mockClient, newSmokeConfig(srv.URL), 4, WaitDefault, io.Discard)
+
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "myapp failed smoke test: got HTTP 500, want 2xx")
+	assert.Greater(t, calls.Load(), int32(1), "should retry until the timeout")
+	mockClient.AssertNotCalled(t, "UpdateManifest", 4)
+}
+
+func TestSmokeTest_ExpectedStatus(t *testing.T) {
+	fakeSmokeClock(t)
+	srv, _ := smokeServer(t, http.StatusOK)
+	cfg := newSmokeConfig(srv.URL)
+	cfg.SmokeTest.Status = http.StatusUnauthorized
+
+	err := SmokeTest(context.Background(), new(MockDeployer), cfg, 4, WaitDefault, io.Discard)
+
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "got HTTP 200, want 401")
+}
+
+func TestSmokeTest_FailureRollsBackWithHealthGate(t *testing.T) {
+	fakeSmokeClock(t)
+	srv, _ := smokeServer(t, http.StatusServiceUnavailable)
+	cfg := newSmokeConfig(srv.URL)
+	cfg.Deploy = &config.DeployConfig{Strategy: "recreate", HealthGate: true}
+	mockClient := new(MockDeployer)
+	mockClient.On("UpdateManifest", 4).Return(nil).Once()
+	mockClient.On("StartService", "myapp").Return(nil).Once()
+
+	err := SmokeTest(context.Background(), mockClient, cfg, 4, WaitDefault, io.Discard)
+
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "myapp failed smoke test")
+	assert.Contains(t, err.Error(), "automatically rolled back to version 4")
+	mockClient.AssertExpectations(t)
+}
+
+func TestSmokeTest_SkippedWithDetach(t *testing.T) {
+	srv, calls := smokeServer(t, http.StatusInternalServerError)
+
+	err := SmokeTest(context.Background(), new(MockDeployer), newSmokeConfig(srv.URL), 4, WaitNone, io.Discard)
+
+	require.NoError(t, err)
+	assert.Equal(t, int32(0), calls.Load())
+}
+
+// probingDeployer makes smoke test requests "from the server".
+type probingDeployer struct {
+	MockDeployer
+	status int
+	err    error
+	urls   []string
+}
+
+func (p *probingDeployer) ProbeURL(ctx context.Context, url string, timeout time.Duration) (int, error) {
+	p.urls = append(p.urls, url)
+	return p.status, p.err
+}
+
+func TestSmokeTest_FromServer(t *testing.T) {
+	fakeSmokeClock(t)
+	cfg := newTestConfig()
+	cfg.Domain = "app.example.com"
+	cfg.SmokeTest = &config.SmokeTestConfig{Path: "/healthz", FromServer: true}
+	client := &probingDeployer{status: http.StatusOK}
+
+	err := SmokeTest(context.Background(), client, cfg, 4, WaitDefault, io.Discard)
+
+	require.NoError(t, err)
+	assert.Equal(t, []string{"https://app.example.com/healthz"}, client.urls)
+}
+
+func TestSmokeTest_FromServerRequestFails(t *testing.T) {
+	fakeSmokeClock(t)
+	cfg := newSmokeConfig("https://app.example.com/")
+	cfg.SmokeTest.FromServer = true
+	client := &probingDeployer{err: errors.New("curl: (6) Could not resolve host")}
+
+	err := SmokeTest(context.Background(), client, cfg, 4, WaitDefault, io.Discard)
+
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "Could not resolve host")
+}
+
+func TestDeploy_SmokeTestFailureFailsDeploy(t *testing.T) {
+	fakeSmokeClock(t)
+	srv, _ := smokeServer(t, http.StatusNotFound)
+	mockClient := new(MockDeployer)
+	cfg := newSmokeConfig(srv.URL)
+	cfg.Deploy = &config.DeployConfig{Strategy: "recreate"}
+	expectBuildAndStart(mockClient, 4)
+
+	err := DeployWithClient(cfg, mockClient, nil)
+
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "got HTTP 404")
+	mockClient.AssertNumberOfCalls(t, "StartService", 1)
+}
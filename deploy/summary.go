@@ -0,0 +1,120 @@
+package deploy
+
+import (
+	"fmt"
+	"io"
+	"text/tabwriter"
+	"time"
+
+	"github.com/byteink/ssd/images"
+)
+
+// Result describes the outcome of a single service deploy.
+type Result struct {
+	Service    string
+	OldVersion int
+	NewVersion int
+	Strategy   string
+	Duration   time.Duration
+	// Err is the error that stopped the deploy, nil on success.
+	Err error
+	// Skipped is set when the deploy was not attempted; Err holds the reason.
+	Skipped bool
+	// Unchanged is set when deploy.skip_unchanged found the source tree
+	// unchanged and nothing was built or started.
+	Unchanged bool
+	// SourceTree is the build context's git tree, when it was read.
+	SourceTree string
+	// ConfigDigest digests the service's resolved config and build args
+	// (see ConfigDigest). It is recorded next to SourceTree.
+	ConfigDigest string
+	// ImageSize is the built image's size in bytes, 0 when not built or
+	// not inspected.
+	ImageSize int64
+	// ImageCached is set when the build produced the previous version's
+	// image unchanged, i.e. every layer was a cache hit.
+	ImageCached bool
+}
+
+// SourceRecord is what {stack}/.ssd-sha-{service} holds after the
+// deploy: SourceTree and ConfigDigest on separate lines. It is "" when the
+// tree was not read.
+func (r Result) SourceRecord() string {
+	if r.SourceTree == "" {
+		return ""
+	}
+	return r.SourceTree + "\n" + r.ConfigDigest
+}
+
+// Summary returns a one-line description of the deploy, e.g.
+// "web: 3 -> 4, strategy rollout, 12.3s, image 142.6MB".
+func (r Result) Summary() string {
+	s := fmt.Sprintf("%s: %s, strategy %s, %s", r.Service, r.versionTransition(), r.Strategy, formatDuration(r.Duration))
+	if r.ImageSize > 0 {
+		s += ", image " + r.image()
+	}
+	return s
+}
+
+// image renders the image size, marked when fully cached, or "-" when
+// unknown.
+func (r Result) image() string {
+	if r.ImageSize == 0 {
+		return "-"
+	}
+	if r.ImageCached {
+		return images.FormatSize(r.ImageSize) + " (cached)"
+	}
+	return images.FormatSize(r.ImageSize)
+}
+
+// versionTransition renders "old -> new", or "-" when the deploy failed
+// before a version was assigned.
+func (r Result) versionTransition() string {
+	if r.NewVersion == 0 {
+		return "-"
+	}
+	if r.Unchanged {
+		return fmt.Sprintf("%d (unchanged)", r.NewVersion)
+	}
+	return fmt.Sprintf("%d -> %d", r.OldVersion, r.NewVersion)
+}
+
+func (r Result) status() string {
+	if r.Skipped {
+		return "skipped: " + r.Err.Error()
+	}
+	if r.Err != nil {
+		return "failed: " + r.Err.Error()
+	}
+	return "ok"
+}
+
+// WriteSummary prints a table of per-service results, one row per service
+// in the order given. Failed services are included with their error.
+func WriteSummary(w io.Writer, results []Result) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	if _, err := fmt.Fprintln(tw, "SERVICE\tVERSION\tSTRATEGY\tDURATION\tIMAGE\tSTATUS"); err != nil {
+		return err
+	}
+	for _, r := range results {
+		if _, err := fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n",
+			r.Service, r.versionTransition(), r.Strategy, formatDuration(r.Duration), r.image(), r.status()); err != nil {
+			return err
+		}
+	}
+	return tw.Flush()
+}
+
+// formatDuration rounds d to a readable precision: tenths of a second
+// above one second, milliseconds above one millisecond, unrounded below.
+func formatDuration(d time.Duration) string {
+	switch {
+	case d >= time.Second:
+		return d.Round(100 * time.Millisecond).String()
+	case d >= time.Millisecond:
+		return d.Round(time.Millisecond).String()
+	default:
+		return d.String()
+	}
+}
@@ -0,0 +1,161 @@
+package deploy
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/byteink/ssd/images"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDeployWithResult_SummaryIncludesVersionAndDuration(t *testing.T) {
+	mockClient := new(MockDeployer)
+	cfg := newTestConfig()
+
+	mockClient.On("StackExists").Return(true, nil)
+	mockClient.On("GetCurrentVersion").Return(4, nil)
+	mockClient.On("MakeTempDir").Return("/tmp/build", nil)
+	mockClient.On("Rsync", mock.Anything, "/tmp/build").Return(nil)
+	mockClient.On("BuildImage", "/tmp/build", 5).Return(nil)
+	mockClient.On("UpdateManifest", 5).Return(nil)
+	mockClient.On("RolloutService", "myapp").Return(nil)
+	mockClient.On("Cleanup", "/tmp/build").Return(nil)
+
+	var out bytes.Buffer
+	res, err := DeployWithResult(cfg, mockClient, &Options{Output: &out})
+
+	require.NoError(t, err)
+	assert.Equal(t, "myapp", res.Service)
+	assert.Equal(t, 4, res.OldVersion)
+	assert.Equal(t, 5, res.NewVersion)
+	assert.Equal(t, "rollout", res.Strategy)
+	assert.Greater(t, res.Duration, time.Duration(0))
+	assert.NoError(t, res.Err)
+
+	assert.Contains(t, res.Summary(), "4 -> 5")
+	assert.Contains(t, res.Summary(), "strategy rollout")
+	assert.Contains(t, out.String(), "myapp: 4 -> 5, strategy rollout, ")
+}
+
+func TestDeployWithResult_FailureRecordsError(t *testing.T) {
+	mockClient := new(MockDeployer)
+	cfg := newTestConfig()
+
+	mockClient.On("StackExists").Return(true, nil)
+	mockClient.On("GetCurrentVersion").Return(2, nil)
+	mockClient.On("MakeTempDir").Return("", errors.New("disk full"))
+
+	res, err := DeployWithResult(cfg, mockClient, nil)
+
+	require.Error(t, err)
+	assert.Equal(t, err, res.Err)
+	assert.Equal(t, 3, res.NewVersion)
+	assert.Greater(t, res.Duration, time.Duration(0))
+}
+
+func TestWriteSummary(t *testing.T) {
+	results := []Result{
+		{Service: "api", OldVersion: 1, NewVersion: 2, Strategy: "rollout", Duration: 1500 * time.Millisecond},
+		{Service: "web", Strategy: "recreate", Duration: 20 * time.Millisecond, Err: errors.New("build failed")},
+	}
+
+	var out bytes.Buffer
+	require.NoError(t, WriteSummary(&out, results))
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	require.Len(t, lines, 3)
+	assert.Equal(t, []string{"SERVICE", "VERSION", "STRATEGY", "DURATION", "IMAGE", "STATUS"}, strings.Fields(lines[0]))
+	assert.Equal(t, []string{"api", "1", "->", "2", "rollout", "1.5s", "-", "ok"}, strings.Fields(lines[1]))
+	assert.Equal(t, []string{"web", "-", "recreate", "20ms", "-", "failed:", "build", "failed"}, strings.Fields(lines[2]))
+}
+
+func TestWriteSummary_ImageSize(t *testing.T) {
+	results := []Result{
+		{Service: "api", OldVersion: 1, NewVersion: 2, Strategy: "rollout", ImageSize: 142612480},
+		{Service: "web", OldVersion: 4, NewVersion: 5, Strategy: "rollout", ImageSize: 87300, ImageCached: true},
+	}
+
+	var out bytes.Buffer
+	require.NoError(t, WriteSummary(&out, results))
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	require.Len(t, lines, 3)
+	assert.Equal(t, []string{"api", "1", "->", "2", "rollout", "0s", "142.6MB", "ok"}, strings.Fields(lines[1]))
+	assert.Equal(t, []string{"web", "4", "->", "5", "rollout", "0s", "87.3kB", "(cached)", "ok"}, strings.Fields(lines[2]))
+	assert.Equal(t, "api: 1 -> 2, strategy rollout, 0s, image 142.6MB", results[0].Summary())
+}
+
+// inspectingDeployer is a MockDeployer that can also inspect images.
+type inspectingDeployer struct {
+	MockDeployer
+	images map[string]images.Info
+}
+
+func (d *inspectingDeployer) ImageInfo(ctx context.Context, image string) (images.Info, error) {
+	info, ok := d.images[image]
+	if !ok {
+		return images.Info{}, fmt.Errorf("failed to inspect image %s: no such image", image)
+	}
+	return info, nil
+}
+
+func expectBuild(m *MockDeployer, current int) {
+	m.On("StackExists").Return(true, nil)
+	m.On("GetCurrentVersion").Return(current, nil)
+	m.On("MakeTempDir").Return("/tmp/build", nil)
+	m.On("Rsync", mock.Anything, "/tmp/build").Return(nil)
+	m.On("BuildImage", "/tmp/build", current+1).Return(nil)
+	m.On("UpdateManifest", current+1).Return(nil)
+	m.On("RolloutService", "myapp").Return(nil)
+	m.On("Cleanup", "/tmp/build").Return(nil)
+}
+
+func TestDeployWithResult_ReportsImageSize(t *testing.T) {
+	client := &inspectingDeployer{images: map[string]images.Info{
+		"ssd-myapp-myapp:4": {ID: "sha256:old", Size: 100000000},
+		"ssd-myapp-myapp:5": {ID: "sha256:new", Size: 142612480},
+	}}
+	expectBuild(&client.MockDeployer, 4)
+
+	var out bytes.Buffer
+	res, err := DeployWithResult(newTestConfig(), client, &Options{Output: &out})
+
+	require.NoError(t, err)
+	assert.Equal(t, int64(142612480), res.ImageSize)
+	assert.False(t, res.ImageCached)
+	assert.Contains(t, out.String(), "Image ssd-myapp-myapp:5: 142.6MB\n")
+	assert.Contains(t, res.Summary(), "image 142.6MB")
+}
+
+func TestDeployWithResult_ReportsFullyCachedImage(t *testing.T) {
+	same := images.Info{ID: "sha256:same", Size: 142612480}
+	client := &inspectingDeployer{images: map[string]images.Info{"ssd-myapp-myapp:4": same, "ssd-myapp-myapp:5": same}}
+	expectBuild(&client.MockDeployer, 4)
+
+	var out bytes.Buffer
+	res, err := DeployWithResult(newTestConfig(), client, &Options{Output: &out})
+
+	require.NoError(t, err)
+	assert.True(t, res.ImageCached)
+	assert.Contains(t, out.String(), "fully cached, same image as version 4")
+	assert.Contains(t, res.Summary(), "image 142.6MB (cached)")
+}
+
+func TestDeployWithResult_ImageInspectFailureOnlyWarns(t *testing.T) {
+	client := &inspectingDeployer{}
+	expectBuild(&client.MockDeployer, 4)
+
+	var out bytes.Buffer
+	res, err := DeployWithResult(newTestConfig(), client, &Options{Output: &out})
+
+	require.NoError(t, err)
+	assert.Zero(t, res.ImageSize)
+	assert.Contains(t, out.String(), "Warning: failed to inspect image ssd-myapp-myapp:5")
+}
@@ -0,0 +1,240 @@
+// Package doctor runs the checks behind `ssd doctor`: the local tools and
+// project, then each server over SSH. Checks never change anything; each
+// failure carries a hint on how to fix it.
+package doctor
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/byteink/ssd/config"
+	"github.com/byteink/ssd/images"
+	"github.com/byteink/ssd/provision"
+	"github.com/byteink/ssd/remote"
+)
+
+// Result is the outcome of one check.
+type Result struct {
+	// Section groups results in the report, e.g. "Local" or the server.
+	Section string
+	Name    string
+	Status  provision.CheckStatus
+	Message string
+	// Hint says how to fix a warning or failure.
+	Hint string
+}
+
+// Report is every check's result, in the order they ran.
+type Report []Result
+
+// Counts returns how many checks passed, warned and failed.
+func (r Report) Counts() (ok, warn, fail int) {
+	for _, res := range r {
+		switch res.Status {
+		case provision.StatusOK:
+			ok++
+		case provision.StatusWarn:
+			warn++
+		default:
+			fail++
+		}
+	}
+	return ok, warn, fail
+}
+
+// Failed reports whether any check failed. Warnings don't count.
+func (r Report) Failed() bool {
+	_, _, fail := r.Counts()
+	return fail > 0
+}
+
+// Write prints the report grouped by section, with hints under the checks
+// that need them, and a closing count.
+func Write(w io.Writer, r Report) error {
+	var sb strings.Builder
+	section := ""
+	for i, res := range r {
+		if i == 0 || res.Section != section {
+			if i > 0 {
+				sb.WriteString("\n")
+			}
+			section = res.Section
+			fmt.Fprintf(&sb, "%s\n", section)
+		}
+		fmt.Fprintf(&sb, "  %-24s %-4s  %s\n", res.Name, statusLabel(res.Status), res.Message)
+		if res.Hint != "" && res.Status != provision.StatusOK {
+			fmt.Fprintf(&sb, "  %-24s       -> %s\n", "", res.Hint)
+		}
+	}
+	ok, warn, fail := r.Counts()
+	fmt.Fprintf(&sb, "\n%d passed, %d warning(s), %d failed\n", ok, warn, fail)
+	_, err := io.WriteString(w, sb.String())
+	return err
+}
+
+func statusLabel(s provision.CheckStatus) string {
+	switch s {
+	case provision.StatusOK:
+		return "OK"
+	case provision.StatusWarn:
+		return "WARN"
+	default:
+		return "FAIL"
+	}
+}
+
+// CheckTools checks that the local programs ssd runs are installed.
+func CheckTools(lookPath func(string) (string, error)) Report {
+	var r Report
+	for _, tool := range []string{"ssh", "git"} {
+		res := Result{Section: "Local", Name: tool}
+		if path, err := lookPath(tool); err != nil {
+			res.Status, res.Message, res.Hint = provision.StatusFail, "not found in PATH", "install "+tool
+		} else {
+			res.Status, res.Message = provision.StatusOK, path
+		}
+		r = append(r, res)
+	}
+	return r
+}
+
+// SourceChecker is what CheckService needs from a service's client.
+type SourceChecker interface {
+	UncommittedChanges(ctx context.Context) ([]string, error)
+	CheckDockerfile() error
+}
+
+var _ SourceChecker = (*remote.Client)(nil)
+
+// CheckService checks what a deploy of a built service needs locally: its
+// context in a git repository, its Dockerfile, and no uncommitted changes
+// (a warning; they would not be shipped). Pre-built services need none.
+func CheckService(ctx context.Context, cfg *config.Config, client SourceChecker, gitRoot func(string) (string, error)) Report {
+	if cfg.IsPrebuilt() {
+		return nil
+	}
+	section := "Service " + cfg.Name
+	root, err := gitRoot(cfg.Context)
+	if err != nil {
+		return Report{{Section: section, Name: "git repository", Status: provision.StatusFail,
+			Message: fmt.Sprintf("%s is not in a git repository", cfg.Context),
+			Hint:    "ssd ships committed files only: git init and commit the service"}}
+	}
+	r := Report{{Section: section, Name: "git repository", Status: provision.StatusOK, Message: root}}
+
+	if err := client.CheckDockerfile(); err != nil {
+		r = append(r, Result{Section: section, Name: "Dockerfile", Status: provision.StatusFail,
+			Message: err.Error(), Hint: "fix dockerfile (or context) in ssd.yaml"})
+	} else {
+		r = append(r, Result{Section: section, Name: "Dockerfile", Status: provision.StatusOK, Message: "found"})
+	}
+
+	changes, err := client.UncommittedChanges(ctx)
+	switch {
+	case err != nil:
+		r = append(r, Result{Section: section, Name: "working tree", Status: provision.StatusWarn,
+			Message: err.Error()})
+	case len(changes) > 0:
+		r = append(r, Result{Section: section, Name: "working tree", Status: provision.StatusWarn,
+			Message: fmt.Sprintf("%d uncommitted change(s)", len(changes)),
+			Hint:    "commit them; only committed files are deployed"})
+	default:
+		r = append(r, Result{Section: section, Name: "working tree", Status: provision.StatusOK, Message: "clean"})
+	}
+	return r
+}
+
+// ServerClient is what CheckServer needs from a client bound to a stack.
+type ServerClient interface {
+	provision.RemoteClient
+	StackExists(ctx context.Context) (bool, error)
+}
+
+var _ ServerClient = (*remote.Client)(nil)
+
+// minFreeDisk is the free space on the server's docker disk below which
+// doctor warns: builds start failing not far below it.
+const minFreeDisk = 2_000_000_000
+
+// CheckServer checks the server behind client: that SSH reaches it, the
+// readiness checks of `ssd provision check` for rt, free disk space and
+// the stack directory. When SSH fails the other checks are skipped.
+func CheckServer(ctx context.Context, client ServerClient, server, rt string, cfg *config.Config) Report {
+	section := fmt.Sprintf("Server %s (%s)", server, rt)
+	if _, err := client.SSH(ctx, "true"); err != nil {
+		return Report{{Section: section, Name: "SSH", Status: provision.StatusFail, Message: err.Error(),
+			Hint: fmt.Sprintf("check the Host %s entry in ~/.ssh/config and that 'ssh %s' works", server, server)}}
+	}
+	r := Report{{Section: section, Name: "SSH", Status: provision.StatusOK, Message: "reachable"}}
+
+	provisionHint := "run 'ssd provision'"
+	if rt == "k3s" {
+		provisionHint = "run 'ssd provision --runtime k3s'"
+	}
+	for _, c := range provision.CheckWithClient(ctx, client, rt) {
+		res := Result{Section: section, Name: c.Name, Status: c.Status, Message: c.Message}
+		if c.Status != provision.StatusOK {
+			res.Hint = provisionHint
+		}
+		r = append(r, res)
+	}
+
+	r = append(r, checkDisk(ctx, client, section, rt))
+
+	exists, err := client.StackExists(ctx)
+	switch {
+	case err != nil:
+		r = append(r, Result{Section: section, Name: "stack", Status: provision.StatusWarn, Message: err.Error()})
+	case exists:
+		r = append(r, Result{Section: section, Name: "stack", Status: provision.StatusOK, Message: cfg.StackPath()})
+	default:
+		r = append(r, Result{Section: section, Name: "stack", Status: provision.StatusOK,
+			Message: cfg.StackPath() + " not created yet (the first deploy creates it)"})
+	}
+	return r
+}
+
+// checkDisk warns when the disk holding images has less than minFreeDisk
+// available.
+func checkDisk(ctx context.Context, client provision.RemoteClient, section, rt string) Result {
+	dir := "/var/lib/docker"
+	if rt == "k3s" {
+		dir = "/var/lib/rancher"
+	}
+	res := Result{Section: section, Name: "disk space"}
+	out, err := client.SSH(ctx, fmt.Sprintf("df -Pk %s 2>/dev/null || df -Pk /", dir))
+	if err != nil {
+		res.Status, res.Message = provision.StatusWarn, "cannot read free space: "+err.Error()
+		return res
+	}
+	free, err := parseDfAvailable(out)
+	if err != nil {
+		res.Status, res.Message = provision.StatusWarn, err.Error()
+		return res
+	}
+	res.Message = images.FormatSize(free) + " free"
+	if free < minFreeDisk {
+		res.Status, res.Hint = provision.StatusWarn, "free space, e.g. with 'ssd prune'"
+	}
+	return res
+}
+
+// parseDfAvailable returns the available bytes from `df -Pk` output.
+func parseDfAvailable(out string) (int64, error) {
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	if len(lines) < 2 {
+		return 0, fmt.Errorf("unexpected df output: %q", out)
+	}
+	fields := strings.Fields(lines[len(lines)-1])
+	if len(fields) < 4 {
+		return 0, fmt.Errorf("unexpected df output: %q", out)
+	}
+	kb, err := strconv.ParseInt(fields[3], 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("unexpected df output: %q", out)
+	}
+	return kb * 1024, nil
+}
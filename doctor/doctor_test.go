@@ -0,0 +1,221 @@
+package doctor
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/byteink/ssd/config"
+	"github.com/byteink/ssd/provision"
+)
+
+// fakeServer answers SSH commands by prefix; anything else succeeds with
+// no output.
+type fakeServer struct {
+	outputs map[string]string
+	errs    map[string]error
+	exists  bool
+}
+
+func (f *fakeServer) SSH(ctx context.Context, command string) (string, error) {
+	for prefix, err := range f.errs {
+		if strings.HasPrefix(command, prefix) {
+			return "", err
+		}
+	}
+	for prefix, out := range f.outputs {
+		if strings.HasPrefix(command, prefix) {
+			return out, nil
+		}
+	}
+	return "", nil
+}
+
+func (f *fakeServer) SSHInteractive(ctx context.Context, command string) error {
+	return nil
+}
+
+func (f *fakeServer) StackExists(ctx context.Context) (bool, error) {
+	return f.exists, nil
+}
+
+type fakeSource struct {
+	dockerfileErr error
+	changes       []string
+}
+
+func (f *fakeSource) UncommittedChanges(ctx context.Context) ([]string, error) {
+	return f.changes, nil
+}
+
+func (f *fakeSource) CheckDockerfile() error {
+	return f.dockerfileErr
+}
+
+func mixedReport() Report {
+	return Report{
+		{Section: "Local", Name: "ssh", Status: provision.StatusOK, Message: "/usr/bin/ssh"},
+		{Section: "Local", Name: "git", Status: provision.StatusFail, Message: "not found in PATH", Hint: "install git"},
+		{Section: "Server prod (compose)", Name: "SSH", Status: provision.StatusOK, Message: "reachable"},
+		{Section: "Server prod (compose)", Name: "Traefik", Status: provision.StatusWarn, Message: "not running", Hint: "run 'ssd provision'"},
+	}
+}
+
+func TestReport_Counts(t *testing.T) {
+	ok, warn, fail := mixedReport().Counts()
+	if ok != 2 || warn != 1 || fail != 1 {
+		t.Errorf("Counts() = %d, %d, %d; want 2, 1, 1", ok, warn, fail)
+	}
+	if !mixedReport().Failed() {
+		t.Error("Failed() = false with a failed check")
+	}
+}
+
+func TestReport_WarningsDontFail(t *testing.T) {
+	r := Report{
+		{Name: "a", Status: provision.StatusOK},
+		{Name: "b", Status: provision.StatusWarn},
+	}
+	if r.Failed() {
+		t.Error("Failed() = true with only warnings")
+	}
+	if (Report{}).Failed() {
+		t.Error("Failed() = true for an empty report")
+	}
+}
+
+func TestWrite(t *testing.T) {
+	var buf bytes.Buffer
+	if err := Write(&buf, mixedReport()); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	want := `Local
+  ssh                      OK    /usr/bin/ssh
+  git                      FAIL  not found in PATH
+                                 -> install git
+
+Server prod (compose)
+  SSH                      OK    reachable
+  Traefik                  WARN  not running
+                                 -> run 'ssd provision'
+
+2 passed, 1 warning(s), 1 failed
+`
+	if buf.String() != want {
+		t.Errorf("got:\n%s\nwant:\n%s", buf.String(), want)
+	}
+}
+
+func TestCheckTools(t *testing.T) {
+	r := CheckTools(func(name string) (string, error) {
+		if name == "git" {
+			return "", errors.New("not found")
+		}
+		return "/usr/bin/" + name, nil
+	})
+	if len(r) != 2 || r[0].Status != provision.StatusOK || r[1].Status != provision.StatusFail {
+		t.Fatalf("got %+v", r)
+	}
+	if r[1].Hint != "install git" {
+		t.Errorf("hint = %q", r[1].Hint)
+	}
+}
+
+func TestCheckService(t *testing.T) {
+	cfg := &config.Config{Name: "web", Context: "./web"}
+	gitRoot := func(string) (string, error) { return "/src/app", nil }
+
+	r := CheckService(context.Background(), cfg, &fakeSource{
+		dockerfileErr: errors.New(`dockerfile "Dockerfile" not found`),
+		changes:       []string{" M main.go"},
+	}, gitRoot)
+
+	if len(r) != 3 {
+		t.Fatalf("got %d results: %+v", len(r), r)
+	}
+	if r[0].Status != provision.StatusOK || r[1].Status != provision.StatusFail || r[2].Status != provision.StatusWarn {
+		t.Errorf("statuses = %v, %v, %v", r[0].Status, r[1].Status, r[2].Status)
+	}
+	if r[2].Message != "1 uncommitted change(s)" {
+		t.Errorf("working tree message = %q", r[2].Message)
+	}
+}
+
+func TestCheckService_NotInGit(t *testing.T) {
+	cfg := &config.Config{Name: "web", Context: "./web"}
+	r := CheckService(context.Background(), cfg, &fakeSource{}, func(string) (string, error) {
+		return "", errors.New("not a git repository")
+	})
+	if len(r) != 1 || r[0].Status != provision.StatusFail || r[0].Hint == "" {
+		t.Errorf("got %+v", r)
+	}
+}
+
+func TestCheckService_Prebuilt(t *testing.T) {
+	cfg := &config.Config{Name: "db", Image: "postgres:16"}
+	if r := CheckService(context.Background(), cfg, &fakeSource{}, nil); len(r) != 0 {
+		t.Errorf("pre-built service should need no local checks, got %+v", r)
+	}
+}
+
+func TestCheckServer_Unreachable(t *testing.T) {
+	cfg := &config.Config{Name: "web", Server: "prod", Stack: "/stacks/app"}
+	client := &fakeServer{errs: map[string]error{"true": errors.New("ssh: Could not resolve hostname prod")}}
+
+	r := CheckServer(context.Background(), client, "prod", "compose", cfg)
+
+	if len(r) != 1 || r[0].Name != "SSH" || r[0].Status != provision.StatusFail {
+		t.Fatalf("got %+v", r)
+	}
+	if !strings.Contains(r[0].Hint, "Host prod") {
+		t.Errorf("hint = %q", r[0].Hint)
+	}
+}
+
+func TestCheckServer_MixedResults(t *testing.T) {
+	cfg := &config.Config{Name: "web", Server: "prod", Stack: "/stacks/app"}
+	client := &fakeServer{
+		outputs: map[string]string{
+			"which docker":           "/usr/bin/docker",
+			"docker compose version": "Docker Compose version v2.29.0",
+			"cd /stacks/traefik":     "running",
+			"df -Pk":                 "Filesystem 1024-blocks Used Available Capacity Mounted on\n/dev/sda1 100000000 99000000 1000000 99% /\n",
+		},
+		errs: map[string]error{"test -f ~/.docker/cli-plugins/docker-rollout": errors.New("exit status 1")},
+	}
+
+	r := CheckServer(context.Background(), client, "prod", "compose", cfg)
+
+	byName := make(map[string]Result)
+	for _, res := range r {
+		byName[res.Name] = res
+	}
+	if byName["docker-rollout"].Status != provision.StatusFail || byName["docker-rollout"].Hint != "run 'ssd provision'" {
+		t.Errorf("docker-rollout = %+v", byName["docker-rollout"])
+	}
+	if byName["Docker"].Status != provision.StatusOK {
+		t.Errorf("Docker = %+v", byName["Docker"])
+	}
+	if disk := byName["disk space"]; disk.Status != provision.StatusWarn || disk.Message != "1.0GB free" {
+		t.Errorf("disk space = %+v", disk)
+	}
+	if stack := byName["stack"]; stack.Status != provision.StatusOK || !strings.Contains(stack.Message, "not created yet") {
+		t.Errorf("stack = %+v", stack)
+	}
+	ok, warn, fail := r.Counts()
+	if fail != 1 || warn == 0 || ok == 0 {
+		t.Errorf("Counts() = %d, %d, %d", ok, warn, fail)
+	}
+}
+
+func TestParseDfAvailable(t *testing.T) {
+	free, err := parseDfAvailable("Filesystem 1024-blocks Used Available Capacity Mounted on\n/dev/sda1 100 40 60 40% /\n")
+	if err != nil || free != 60*1024 {
+		t.Errorf("got %d, %v", free, err)
+	}
+	if _, err := parseDfAvailable("df: /x: No such file"); err == nil {
+		t.Error("expected error for unexpected output")
+	}
+}
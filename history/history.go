@@ -0,0 +1,105 @@
+// Package history formats and parses the deploy audit log ssd keeps in
+// each stack directory on the server.
+package history
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// File is the deploy audit log kept in the stack directory.
+const File = ".ssd-history"
+
+// MaxLines bounds the audit log. Older lines are dropped once the
+// file grows past this many entries.
+const MaxLines = 1000
+
+// Entry is one line of the deploy audit log.
+type Entry struct {
+	Time    time.Time
+	Service string
+	Version int
+	User    string
+	GitSHA  string
+}
+
+// String renders the entry as a history line:
+// "timestamp,service,version,user,git-sha" with an RFC 3339 UTC timestamp.
+// Commas and newlines in free-form fields are replaced so the line always
+// parses back. Missing user or SHA are written as "-".
+func (e Entry) String() string {
+	return strings.Join([]string{
+		e.Time.UTC().Format(time.RFC3339),
+		e.Service,
+		strconv.Itoa(e.Version),
+		field(e.User),
+		field(e.GitSHA),
+	}, ",")
+}
+
+func field(s string) string {
+	s = strings.NewReplacer(",", "_", "\n", " ", "\r", " ").Replace(strings.TrimSpace(s))
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+// ParseLine parses a single line written by Entry.String.
+func ParseLine(line string) (Entry, error) {
+	parts := strings.Split(line, ",")
+	if len(parts) != 5 {
+		return Entry{}, fmt.Errorf("expected 5 fields, got %d", len(parts))
+	}
+	ts, err := time.Parse(time.RFC3339, parts[0])
+	if err != nil {
+		return Entry{}, fmt.Errorf("invalid timestamp %q: %w", parts[0], err)
+	}
+	version, err := strconv.Atoi(parts[2])
+	if err != nil {
+		return Entry{}, fmt.Errorf("invalid version %q: %w", parts[2], err)
+	}
+	return Entry{
+		Time:    ts,
+		Service: parts[1],
+		Version: version,
+		User:    parts[3],
+		GitSHA:  parts[4],
+	}, nil
+}
+
+// Parse parses the contents of a history file. Blank lines are
+// ignored; any malformed line is reported with its line number.
+func Parse(content string) ([]Entry, error) {
+	var entries []Entry
+	for i, line := range strings.Split(content, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		entry, err := ParseLine(line)
+		if err != nil {
+			return nil, fmt.Errorf("%s line %d: %w", File, i+1, err)
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// VersionTime returns when version of service was last deployed, i.e. the
+// time of the newest entry for it. A version deployed again later (say by
+// a rollback) went live at that later time.
+func VersionTime(entries []Entry, service string, version int) (time.Time, error) {
+	var found time.Time
+	for _, e := range entries {
+		if e.Service == service && e.Version == version && e.Time.After(found) {
+			found = e.Time
+		}
+	}
+	if found.IsZero() {
+		return time.Time{}, fmt.Errorf("version %d of %s is not in the deploy history", version, service)
+	}
+	return found, nil
+}
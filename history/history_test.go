@@ -0,0 +1,112 @@
+package history
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEntry_String(t *testing.T) {
+	e := Entry{
+		Time:    time.Date(2026, 3, 4, 5, 6, 7, 0, time.UTC),
+		Service: "web",
+		Version: 12,
+		User:    "alice",
+		GitSHA:  "0123456789abcdef0123456789abcdef01234567",
+	}
+	assert.Equal(t, "2026-03-04T05:06:07Z,web,12,alice,0123456789abcdef0123456789abcdef01234567", e.String())
+}
+
+func TestEntry_String_ConvertsToUTC(t *testing.T) {
+	loc := time.FixedZone("CET", 3600)
+	e := Entry{Time: time.Date(2026, 3, 4, 6, 6, 7, 0, loc), Service: "web", Version: 1, User: "bob", GitSHA: "abc"}
+	assert.Equal(t, "2026-03-04T05:06:07Z,web,1,bob,abc", e.String())
+}
+
+func TestEntry_String_SanitizesFields(t *testing.T) {
+	e := Entry{Time: time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC), Service: "web", Version: 2, User: "doe, john\n", GitSHA: ""}
+	line := e.String()
+	assert.Equal(t, "2026-01-01T00:00:00Z,web,2,doe_ john,-", line)
+
+	parsed, err := ParseLine(line)
+	require.NoError(t, err)
+	assert.Equal(t, "doe_ john", parsed.User)
+	assert.Equal(t, "-", parsed.GitSHA)
+}
+
+func TestParseLine_RoundTrip(t *testing.T) {
+	e := Entry{Time: time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC), Service: "api", Version: 7, User: "ci", GitSHA: "deadbeef"}
+	got, err := ParseLine(e.String())
+	require.NoError(t, err)
+	assert.True(t, e.Time.Equal(got.Time))
+	assert.Equal(t, e.Service, got.Service)
+	assert.Equal(t, e.Version, got.Version)
+	assert.Equal(t, e.User, got.User)
+	assert.Equal(t, e.GitSHA, got.GitSHA)
+}
+
+func TestParseLine_Errors(t *testing.T) {
+	tests := []struct {
+		name string
+		line string
+		want string
+	}{
+		{"too few fields", "2026-01-01T00:00:00Z,web,1,alice", "expected 5 fields"},
+		{"bad timestamp", "yesterday,web,1,alice,abc", "invalid timestamp"},
+		{"bad version", "2026-01-01T00:00:00Z,web,v1,alice,abc", "invalid version"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseLine(tt.line)
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.want)
+		})
+	}
+}
+
+func TestParse(t *testing.T) {
+	content := "2026-01-01T00:00:00Z,web,1,alice,aaa\n\n2026-01-02T00:00:00Z,api,3,bob,bbb\n"
+	entries, err := Parse(content)
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+	assert.Equal(t, "web", entries[0].Service)
+	assert.Equal(t, 3, entries[1].Version)
+	assert.Equal(t, "bob", entries[1].User)
+}
+
+func TestParse_Empty(t *testing.T) {
+	entries, err := Parse("")
+	require.NoError(t, err)
+	assert.Empty(t, entries)
+}
+
+func TestParse_ReportsLineNumber(t *testing.T) {
+	_, err := Parse("2026-01-01T00:00:00Z,web,1,alice,aaa\ngarbage\n")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), ".ssd-history line 2")
+}
+
+func TestVersionTime(t *testing.T) {
+	at := func(h int) time.Time { return time.Date(2026, 3, 1, h, 0, 0, 0, time.UTC) }
+	entries := []Entry{
+		{Time: at(9), Service: "web", Version: 4},
+		{Time: at(10), Service: "api", Version: 5},
+		{Time: at(11), Service: "web", Version: 5},
+		{Time: at(12), Service: "web", Version: 4}, // rollback to 4
+	}
+
+	got, err := VersionTime(entries, "web", 5)
+	require.NoError(t, err)
+	assert.Equal(t, at(11), got)
+
+	got, err = VersionTime(entries, "web", 4)
+	require.NoError(t, err)
+	assert.Equal(t, at(12), got, "a redeployed version went live at its latest deploy")
+
+	_, err = VersionTime(entries, "web", 7)
+	assert.EqualError(t, err, "version 7 of web is not in the deploy history")
+	_, err = VersionTime(nil, "api", 5)
+	assert.Error(t, err)
+}
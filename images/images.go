@@ -0,0 +1,58 @@
+// Package images describes a built image as reported by `docker image
+// inspect` (or nerdctl's docker-compatible output on k3s), for the deploy
+// output and summary.
+package images
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// Info is the part of an image inspect that deploy reports.
+type Info struct {
+	ID      string
+	Size    int64 // bytes
+	Created time.Time
+}
+
+// ParseInspect reads the first image in image inspect JSON output.
+func ParseInspect(out string) (Info, error) {
+	var list []struct {
+		ID      string `json:"Id"`
+		Size    int64
+		Created string
+	}
+	if err := json.Unmarshal([]byte(out), &list); err != nil {
+		return Info{}, fmt.Errorf("failed to parse image inspect output: %w", err)
+	}
+	if len(list) == 0 {
+		return Info{}, fmt.Errorf("image inspect returned no images")
+	}
+	info := Info{ID: list[0].ID, Size: list[0].Size}
+	if list[0].Created != "" {
+		created, err := time.Parse(time.RFC3339Nano, list[0].Created)
+		if err != nil {
+			return Info{}, fmt.Errorf("failed to parse image creation time %q: %w", list[0].Created, err)
+		}
+		info.Created = created
+	}
+	return info, nil
+}
+
+// FormatSize renders a size in bytes with decimal units, as docker does:
+// "512B", "87.3kB", "142.6MB", "1.2GB".
+func FormatSize(bytes int64) string {
+	const unit = 1000
+	if bytes < unit {
+		return fmt.Sprintf("%dB", bytes)
+	}
+	value := float64(bytes)
+	for _, suffix := range []string{"kB", "MB", "GB"} {
+		value /= unit
+		if value < unit || suffix == "GB" {
+			return fmt.Sprintf("%.1f%s", value, suffix)
+		}
+	}
+	return ""
+}
@@ -0,0 +1,59 @@
+package images
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// dockerInspect is trimmed `docker image inspect myapp-web:5` output.
+const dockerInspect = `[
+    {
+        "Id": "sha256:4f1c0a7e9d2b3c4d5e6f708192a3b4c5d6e7f8091a2b3c4d5e6f708192a3b4c5",
+        "RepoTags": ["myapp-web:5"],
+        "RepoDigests": [],
+        "Parent": "",
+        "Created": "2026-03-14T09:26:53.589793238Z",
+        "DockerVersion": "",
+        "Architecture": "amd64",
+        "Os": "linux",
+        "Size": 142612480,
+        "RootFS": {"Type": "layers", "Layers": ["sha256:aa", "sha256:bb"]}
+    }
+]
+`
+
+// nerdctlInspect is nerdctl's dockercompat output, with a local offset.
+const nerdctlInspect = `[{"Id":"sha256:9a8b","RepoTags":["myapp-web:5"],"Created":"2026-03-14T10:26:53+01:00","Size":5242880}]`
+
+func TestParseInspect_Docker(t *testing.T) {
+	info, err := ParseInspect(dockerInspect)
+	require.NoError(t, err)
+	assert.Equal(t, "sha256:4f1c0a7e9d2b3c4d5e6f708192a3b4c5d6e7f8091a2b3c4d5e6f708192a3b4c5", info.ID)
+	assert.Equal(t, int64(142612480), info.Size)
+	assert.Equal(t, time.Date(2026, 3, 14, 9, 26, 53, 589793238, time.UTC), info.Created.UTC())
+}
+
+func TestParseInspect_Nerdctl(t *testing.T) {
+	info, err := ParseInspect(nerdctlInspect)
+	require.NoError(t, err)
+	assert.Equal(t, int64(5242880), info.Size)
+	assert.True(t, info.Created.Equal(time.Date(2026, 3, 14, 9, 26, 53, 0, time.UTC)))
+}
+
+func TestParseInspect_Errors(t *testing.T) {
+	for _, out := range []string{"", "[]", "Error: No such image: myapp-web:9", `[{"Created":"yesterday"}]`} {
+		_, err := ParseInspect(out)
+		assert.Error(t, err, out)
+	}
+}
+
+func TestFormatSize(t *testing.T) {
+	assert.Equal(t, "512B", FormatSize(512))
+	assert.Equal(t, "87.3kB", FormatSize(87300))
+	assert.Equal(t, "142.6MB", FormatSize(142612480))
+	assert.Equal(t, "1.2GB", FormatSize(1200000000))
+	assert.Equal(t, "3400.0GB", FormatSize(3400000000000))
+}
@@ -0,0 +1,79 @@
+// Package clock abstracts the time source behind lock and health waits, so
+// their timeouts can be tested without sleeping.
+package clock
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock is the part of package time that polling loops need.
+type Clock interface {
+	Now() time.Time
+	After(d time.Duration) <-chan time.Time
+	Sleep(d time.Duration)
+}
+
+// Real is the system clock.
+type Real struct{}
+
+func (Real) Now() time.Time                         { return time.Now() }
+func (Real) After(d time.Duration) <-chan time.Time { return time.After(d) }
+func (Real) Sleep(d time.Duration)                  { time.Sleep(d) }
+
+// Fake is a Clock for tests. Its time only moves when something waits on
+// it: After and Sleep advance it by the full duration and return at once,
+// so a polling loop runs to its deadline in a handful of iterations
+// without sleeping.
+type Fake struct {
+	mu    sync.Mutex
+	now   time.Time
+	waits []time.Duration
+}
+
+// NewFake returns a Fake clock reading now.
+func NewFake(now time.Time) *Fake {
+	return &Fake{now: now}
+}
+
+// Now returns the fake time.
+func (f *Fake) Now() time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.now
+}
+
+// After advances the clock by d and returns a channel that already holds
+// the new time.
+func (f *Fake) After(d time.Duration) <-chan time.Time {
+	ch := make(chan time.Time, 1)
+	ch <- f.wait(d)
+	return ch
+}
+
+// Sleep advances the clock by d.
+func (f *Fake) Sleep(d time.Duration) {
+	f.wait(d)
+}
+
+// Advance moves the clock forward by d without recording a wait.
+func (f *Fake) Advance(d time.Duration) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.now = f.now.Add(d)
+}
+
+// Waits returns the durations passed to After and Sleep, in call order.
+func (f *Fake) Waits() []time.Duration {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]time.Duration(nil), f.waits...)
+}
+
+func (f *Fake) wait(d time.Duration) time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.waits = append(f.waits, d)
+	f.now = f.now.Add(d)
+	return f.now
+}
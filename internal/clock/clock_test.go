@@ -0,0 +1,29 @@
+package clock
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFake(t *testing.T) {
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	f := NewFake(start)
+	assert.Equal(t, start, f.Now())
+
+	assert.Equal(t, start.Add(time.Second), <-f.After(time.Second))
+	f.Sleep(2 * time.Second)
+	f.Advance(time.Minute)
+
+	assert.Equal(t, start.Add(time.Minute+3*time.Second), f.Now())
+	assert.Equal(t, []time.Duration{time.Second, 2 * time.Second}, f.Waits())
+}
+
+func TestReal(t *testing.T) {
+	var c Clock = Real{}
+	before := time.Now()
+	c.Sleep(time.Millisecond)
+	<-c.After(time.Millisecond)
+	assert.GreaterOrEqual(t, c.Now().Sub(before), 2*time.Millisecond)
+}
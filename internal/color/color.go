@@ -0,0 +1,77 @@
+// Package color highlights progress output on a terminal: section
+// headers, warnings, errors and success lines. Output to anything but a
+// terminal, with NO_COLOR set, or after Disable (--no-color) stays plain.
+package color
+
+import (
+	"io"
+	"os"
+	"strings"
+	"sync/atomic"
+)
+
+// ANSI escape codes.
+const (
+	Reset  = "\033[0m"
+	Bold   = "\033[1m"
+	Red    = "\033[31m"
+	Green  = "\033[32m"
+	Yellow = "\033[33m"
+	Cyan   = "\033[36m"
+)
+
+var disabled atomic.Bool
+
+// Disable turns color off for the rest of the process (--no-color).
+func Disable() {
+	disabled.Store(true)
+}
+
+// Enabled reports whether output written to w is colored: w is a
+// terminal (a character device, as reported by its Stat method), NO_COLOR
+// is unset or empty, and Disable was not called.
+func Enabled(w io.Writer) bool {
+	if disabled.Load() || os.Getenv("NO_COLOR") != "" {
+		return false
+	}
+	f, ok := w.(interface{ Stat() (os.FileInfo, error) })
+	if !ok {
+		return false
+	}
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
+// Paint wraps s in code when output to w is colored.
+func Paint(w io.Writer, code, s string) string {
+	if !Enabled(w) {
+		return s
+	}
+	return code + s + Reset
+}
+
+// Line colors a progress message for w by how it starts: warnings yellow,
+// "==> " headers bold cyan, errors red and lines ending in
+// "successfully!" green. Leading and trailing newlines stay outside the
+// color. Any other message is returned unchanged.
+func Line(w io.Writer, msg string) string {
+	body := strings.TrimLeft(msg, "\n")
+	text := strings.TrimRight(body, "\n")
+	var code string
+	switch {
+	case strings.HasPrefix(text, "==> WARNING"), strings.HasPrefix(text, "WARNING"), strings.HasPrefix(text, "Warning:"):
+		code = Yellow
+	case strings.HasPrefix(text, "==> "):
+		code = Bold + Cyan
+	case strings.HasPrefix(text, "Error"):
+		code = Red
+	case strings.HasSuffix(text, "successfully!"):
+		code = Green
+	default:
+		return msg
+	}
+	if !Enabled(w) {
+		return msg
+	}
+	return msg[:len(msg)-len(body)] + code + text + Reset + body[len(text):]
+}
@@ -0,0 +1,77 @@
+package color
+
+import (
+	"bytes"
+	"io/fs"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// terminal is a writer that reports itself as a character device, like a
+// tty on os.Stdout.
+type terminal struct{ bytes.Buffer }
+
+func (t *terminal) Stat() (os.FileInfo, error) { return ttyInfo{}, nil }
+
+type ttyInfo struct{}
+
+func (ttyInfo) Name() string       { return "tty" }
+func (ttyInfo) Size() int64        { return 0 }
+func (ttyInfo) Mode() fs.FileMode  { return fs.ModeDevice | fs.ModeCharDevice | 0620 }
+func (ttyInfo) ModTime() time.Time { return time.Time{} }
+func (ttyInfo) IsDir() bool        { return false }
+func (ttyInfo) Sys() any           { return nil }
+
+func TestLine_Terminal(t *testing.T) {
+	t.Setenv("NO_COLOR", "")
+	w := new(terminal)
+
+	tests := []struct{ in, want string }{
+		{"==> Building image web:4...\n", Bold + Cyan + "==> Building image web:4..." + Reset + "\n"},
+		{"Warning: image cleanup failed\n", Yellow + "Warning: image cleanup failed" + Reset + "\n"},
+		{"==> WARNING: forcing version 5\n", Yellow + "==> WARNING: forcing version 5" + Reset + "\n"},
+		{"Error: boom\n", Red + "Error: boom" + Reset + "\n"},
+		{"\nDeployed web version 4 successfully!\n", "\n" + Green + "Deployed web version 4 successfully!" + Reset + "\n"},
+		{"    Pinned nginx@sha256:abc\n", "    Pinned nginx@sha256:abc\n"},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, Line(w, tt.in), "%q", tt.in)
+	}
+	assert.Equal(t, Green+"ok"+Reset, Paint(w, Green, "ok"))
+}
+
+func TestLine_PlainOutput(t *testing.T) {
+	t.Setenv("NO_COLOR", "")
+	var buf bytes.Buffer
+	assert.Equal(t, "==> Building...\n", Line(&buf, "==> Building...\n"), "not a terminal")
+
+	t.Setenv("NO_COLOR", "1")
+	assert.Equal(t, "==> Building...\n", Line(new(terminal), "==> Building...\n"), "NO_COLOR set")
+	assert.Equal(t, "ok", Paint(new(terminal), Green, "ok"))
+}
+
+func TestDisable(t *testing.T) {
+	t.Setenv("NO_COLOR", "")
+	t.Cleanup(func() { disabled.Store(false) })
+	w := new(terminal)
+	assert.True(t, Enabled(w))
+
+	Disable()
+
+	assert.False(t, Enabled(w))
+	assert.Equal(t, "Error: boom", Line(w, "Error: boom"))
+}
+
+func TestEnabled_Pipe(t *testing.T) {
+	t.Setenv("NO_COLOR", "")
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	defer w.Close()
+	assert.False(t, Enabled(w), "a pipe is not a terminal")
+}
@@ -2,7 +2,12 @@ package testhelpers
 
 import (
 	"context"
+	"time"
 
+	"github.com/byteink/ssd/history"
+	"github.com/byteink/ssd/images"
+	"github.com/byteink/ssd/logs"
+	"github.com/byteink/ssd/stacks"
 	"github.com/stretchr/testify/mock"
 )
 
@@ -70,15 +75,21 @@ func (m *MockRemoteClient) RestartStack(ctx context.Context) error {
 	return args.Error(0)
 }
 
+// Down mocks tearing down the stack
+func (m *MockRemoteClient) Down(ctx context.Context, removeVolumes bool) error {
+	args := m.Called(removeVolumes)
+	return args.Error(0)
+}
+
 // GetContainerStatus mocks container status retrieval
-func (m *MockRemoteClient) GetContainerStatus(ctx context.Context) (string, error) {
-	args := m.Called()
+func (m *MockRemoteClient) GetContainerStatus(ctx context.Context, service string) (string, error) {
+	args := m.Called(service)
 	return args.String(0), args.Error(1)
 }
 
 // GetLogs mocks log retrieval
-func (m *MockRemoteClient) GetLogs(ctx context.Context, follow bool, tail int) error {
-	args := m.Called(follow, tail)
+func (m *MockRemoteClient) GetLogs(ctx context.Context, opts logs.Options) error {
+	args := m.Called(opts)
 	return args.Error(0)
 }
 
@@ -148,6 +159,12 @@ func (m *MockRemoteClient) RemoveEnvVar(ctx context.Context, serviceName, key st
 	return args.Error(0)
 }
 
+// WriteEnvFile mocks replacing an env file
+func (m *MockRemoteClient) WriteEnvFile(ctx context.Context, serviceName, content string) error {
+	args := m.Called(serviceName, content)
+	return args.Error(0)
+}
+
 // CreateStack mocks stack creation
 func (m *MockRemoteClient) CreateStack(ctx context.Context, composeContent string) error {
 	args := m.Called(composeContent)
@@ -166,6 +183,24 @@ func (m *MockRemoteClient) StartService(ctx context.Context, serviceName string)
 	return args.Error(0)
 }
 
+// StopService mocks stopping a single service
+func (m *MockRemoteClient) StopService(ctx context.Context, serviceName string) error {
+	args := m.Called(serviceName)
+	return args.Error(0)
+}
+
+// RunJob mocks running a pre_start job
+func (m *MockRemoteClient) RunJob(ctx context.Context, serviceName string) error {
+	args := m.Called(serviceName)
+	return args.Error(0)
+}
+
+// WaitForHealthy mocks waiting for a service to become healthy
+func (m *MockRemoteClient) WaitForHealthy(ctx context.Context, serviceName string, timeout, grace time.Duration) error {
+	args := m.Called(serviceName, timeout, grace)
+	return args.Error(0)
+}
+
 // ReadManifest mocks reading compose file content
 func (m *MockRemoteClient) ReadManifest(ctx context.Context) (string, error) {
 	args := m.Called()
@@ -183,3 +218,67 @@ func (m *MockRemoteClient) CopyFiles(ctx context.Context, files map[string]strin
 	args := m.Called(files)
 	return args.Error(0)
 }
+
+// AppendHistory mocks recording a deploy in the history file
+func (m *MockRemoteClient) AppendHistory(ctx context.Context, serviceName string, version int) error {
+	args := m.Called(serviceName, version)
+	return args.Error(0)
+}
+
+// ReadHistory mocks reading the deploy history file
+func (m *MockRemoteClient) ReadHistory(ctx context.Context) ([]history.Entry, error) {
+	args := m.Called()
+	entries, _ := args.Get(0).([]history.Entry)
+	return entries, args.Error(1)
+}
+
+// SourceTree mocks reading the build context's git tree
+func (m *MockRemoteClient) SourceTree(ctx context.Context) (string, error) {
+	args := m.Called()
+	return args.String(0), args.Error(1)
+}
+
+// UncommittedChanges mocks listing uncommitted changes in the build context
+func (m *MockRemoteClient) UncommittedChanges(ctx context.Context) ([]string, error) {
+	args := m.Called()
+	changes, _ := args.Get(0).([]string)
+	return changes, args.Error(1)
+}
+
+// DeployedSourceTree mocks reading the recorded source tree
+func (m *MockRemoteClient) DeployedSourceTree(ctx context.Context, serviceName string) (string, error) {
+	args := m.Called(serviceName)
+	return args.String(0), args.Error(1)
+}
+
+// ImageDigest mocks resolving an image's repo digest
+func (m *MockRemoteClient) ImageDigest(ctx context.Context, image string) (string, error) {
+	args := m.Called(image)
+	return args.String(0), args.Error(1)
+}
+
+// ImageInfo mocks inspecting an image
+func (m *MockRemoteClient) ImageInfo(ctx context.Context, image string) (images.Info, error) {
+	args := m.Called(image)
+	info, _ := args.Get(0).(images.Info)
+	return info, args.Error(1)
+}
+
+// ListStacks mocks listing the ssd-managed stacks on the server
+func (m *MockRemoteClient) ListStacks(ctx context.Context) ([]stacks.Stack, error) {
+	args := m.Called()
+	list, _ := args.Get(0).([]stacks.Stack)
+	return list, args.Error(1)
+}
+
+// SyncSchedule mocks installing or removing the service's systemd timer
+func (m *MockRemoteClient) SyncSchedule(ctx context.Context) error {
+	args := m.Called()
+	return args.Error(0)
+}
+
+// RecordSourceTree mocks recording the deployed source tree
+func (m *MockRemoteClient) RecordSourceTree(ctx context.Context, serviceName, tree string) error {
+	args := m.Called(serviceName, tree)
+	return args.Error(0)
+}
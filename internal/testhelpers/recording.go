@@ -0,0 +1,156 @@
+package testhelpers
+
+import (
+	"context"
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// RecordedCommand is one invocation seen by a RecordingExecutor.
+type RecordedCommand struct {
+	Name        string
+	Args        []string
+	Interactive bool
+}
+
+// String renders the command as one transcript entry, e.g.
+// "run ssh srv cat /stacks/app/compose.yaml". Interactive commands start
+// with "interactive" instead of "run".
+func (c RecordedCommand) String() string {
+	kind := "run"
+	if c.Interactive {
+		kind = "interactive"
+	}
+	return kind + " " + c.Name + " " + strings.Join(c.Args, " ")
+}
+
+// RecordingExecutor is a CommandExecutor that records every command and
+// answers from canned responses, so tests can compare the exact command
+// sequence against a golden file instead of matching fragments.
+type RecordingExecutor struct {
+	mu        sync.Mutex
+	commands  []RecordedCommand
+	responses []cannedResponse
+}
+
+type cannedResponse struct {
+	contains string
+	output   string
+	err      error
+}
+
+// NewRecordingExecutor returns an executor that succeeds with empty
+// output until told otherwise via Respond or Fail.
+func NewRecordingExecutor() *RecordingExecutor {
+	return &RecordingExecutor{}
+}
+
+// Respond makes commands whose joined arguments contain substr return
+// output. The first matching response wins.
+func (r *RecordingExecutor) Respond(substr, output string) *RecordingExecutor {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.responses = append(r.responses, cannedResponse{contains: substr, output: output})
+	return r
+}
+
+// Fail makes commands whose joined arguments contain substr return err.
+func (r *RecordingExecutor) Fail(substr string, err error) *RecordingExecutor {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.responses = append(r.responses, cannedResponse{contains: substr, err: err})
+	return r
+}
+
+// Run records the command and returns the first matching response.
+func (r *RecordingExecutor) Run(_ context.Context, name string, args ...string) (string, error) {
+	resp := r.record(RecordedCommand{Name: name, Args: args})
+	return resp.output, resp.err
+}
+
+// RunInteractive records the command and returns the first matching
+// response's error.
+func (r *RecordingExecutor) RunInteractive(_ context.Context, name string, args ...string) error {
+	return r.record(RecordedCommand{Name: name, Args: args, Interactive: true}).err
+}
+
+func (r *RecordingExecutor) record(cmd RecordedCommand) cannedResponse {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	cmd.Args = append([]string(nil), cmd.Args...)
+	r.commands = append(r.commands, cmd)
+	joined := strings.Join(cmd.Args, " ")
+	for _, resp := range r.responses {
+		if strings.Contains(joined, resp.contains) {
+			return resp
+		}
+	}
+	return cannedResponse{}
+}
+
+// Commands returns a copy of everything recorded so far, in order.
+func (r *RecordingExecutor) Commands() []RecordedCommand {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]RecordedCommand(nil), r.commands...)
+}
+
+// Transcript renders the recorded commands one per entry, separated by
+// blank lines so multi-line scripts stay readable in a golden file.
+func (r *RecordingExecutor) Transcript() string {
+	var b strings.Builder
+	for i, cmd := range r.Commands() {
+		if i > 0 {
+			b.WriteString("\n")
+		}
+		b.WriteString(cmd.String())
+		b.WriteString("\n")
+	}
+	return b.String()
+}
+
+// AssertGolden compares got with the golden file at path. Run the tests
+// with SSD_UPDATE_GOLDEN=1 to rewrite the file from got instead.
+func AssertGolden(t *testing.T, path, got string) {
+	t.Helper()
+	if os.Getenv("SSD_UPDATE_GOLDEN") == "1" {
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatalf("failed to create golden dir: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(got), 0o644); err != nil {
+			t.Fatalf("failed to write golden file: %v", err)
+		}
+		return
+	}
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed to read golden file %s (run with SSD_UPDATE_GOLDEN=1 to create it): %v", path, err)
+	}
+	if string(want) != got {
+		t.Errorf("output does not match %s (run with SSD_UPDATE_GOLDEN=1 to update)\n--- want\n%s\n+++ got\n%s", path, want, got)
+	}
+}
+
+// writeChunkPattern matches one base64 chunk of a remote.Client.WriteFile
+// command, quoted or not.
+var writeChunkPattern = regexp.MustCompile(`printf %s '?([A-Za-z0-9+/=]+)'? \| base64 -d`)
+
+// WrittenContent decodes the file content carried by WriteFile commands,
+// concatenating chunks in order. Pass one command or several joined
+// together. Returns "" when a chunk isn't valid base64.
+func WrittenContent(cmds string) string {
+	var b strings.Builder
+	for _, m := range writeChunkPattern.FindAllStringSubmatch(cmds, -1) {
+		data, err := base64.StdEncoding.DecodeString(m[1])
+		if err != nil {
+			return ""
+		}
+		b.Write(data)
+	}
+	return b.String()
+}
@@ -2,7 +2,9 @@ package k8s
 
 import (
 	"fmt"
+	"maps"
 	"path/filepath"
+	"slices"
 	"strconv"
 	"strings"
 
@@ -20,7 +22,7 @@ func GenerateManifests(services map[string]*config.Config, stack string, version
 	}
 
 	namespace := filepath.Base(stack)
-	project := namespace
+	project := config.ProjectName(services, stack)
 
 	var docs []string
 
@@ -35,6 +37,18 @@ func GenerateManifests(services map[string]*config.Config, stack string, version
 	// tests use findDoc which handles any order. Iterate map directly.
 	for name, cfg := range services {
 		version := versions[name]
+		if cfg.IsTCPRouter() {
+			return "", fmt.Errorf("service %q: router: tcp is not supported by the k3s runtime", name)
+		}
+		if cfg.Schedule != "" {
+			return "", fmt.Errorf("service %q: schedule is not supported by the k3s runtime", name)
+		}
+		if len(cfg.Ulimits) > 0 {
+			return "", fmt.Errorf("service %q: ulimits is not supported by the k3s runtime", name)
+		}
+		if slices.Contains(slices.Collect(maps.Values(cfg.ExtraHosts)), config.HostGateway) {
+			return "", fmt.Errorf("service %q: extra_hosts %s is not supported by the k3s runtime", name, config.HostGateway)
+		}
 
 		// NOTE: the {service}-env ConfigMap is intentionally NOT emitted here.
 		// runtime/k3s/client.go applyEnvConfigMap manages it directly via
@@ -61,8 +75,11 @@ func GenerateManifests(services map[string]*config.Config, stack string, version
 		}
 		docs = append(docs, svcDoc)
 
-		// PVCs for volumes
+		// PVCs for named volumes (bind mounts use hostPath instead)
 		for volName := range cfg.Volumes {
+			if config.IsBindMount(volName) {
+				continue
+			}
 			pvcDoc, err := marshalResource(pvcResource(volName, namespace))
 			if err != nil {
 				return "", err
@@ -121,7 +138,7 @@ func deploymentResource(name, namespace, project string, cfg *config.Config, ver
 		image = cfg.Image
 		pullPolicy = "Always"
 	} else {
-		image = fmt.Sprintf("ssd-%s-%s:%d", project, name, version)
+		image = fmt.Sprintf("%s:%d", config.RenderImageName(cfg.ImageTemplate, project, name), version)
 		pullPolicy = "Never"
 	}
 
@@ -174,13 +191,52 @@ func deploymentResource(name, namespace, project string, cfg *config.Config, ver
 		container["readinessProbe"] = probe
 	}
 
+	// Capabilities and privileged mode
+	securityContext := map[string]interface{}{}
+	if len(cfg.CapAdd) > 0 || len(cfg.CapDrop) > 0 {
+		capabilities := map[string]interface{}{}
+		if len(cfg.CapAdd) > 0 {
+			capabilities["add"] = k8sCapabilities(cfg.CapAdd)
+		}
+		if len(cfg.CapDrop) > 0 {
+			capabilities["drop"] = k8sCapabilities(cfg.CapDrop)
+		}
+		securityContext["capabilities"] = capabilities
+	}
+	if cfg.Privileged {
+		securityContext["privileged"] = true
+	}
+	if len(securityContext) > 0 {
+		container["securityContext"] = securityContext
+	}
+
+	// Resource limits and reservations (requests)
+	resources := map[string]interface{}{}
+	if limits := resourceQuantities(cfg.CPULimit, cfg.MemoryLimit); limits != nil {
+		resources["limits"] = limits
+	}
+	if requests := resourceQuantities(cfg.CPUReservation, cfg.MemoryReservation); requests != nil {
+		resources["requests"] = requests
+	}
+	if len(resources) > 0 {
+		container["resources"] = resources
+	}
+
 	// Volume mounts
 	var volumeMounts []map[string]interface{}
-	for volName, mountPath := range cfg.Volumes {
-		volumeMounts = append(volumeMounts, map[string]interface{}{
+	for volName, mount := range cfg.Volumes {
+		if config.IsBindMount(volName) {
+			volName = bindVolumeName(volName)
+		}
+		mountPath, mode := config.SplitVolumeMount(mount)
+		volumeMount := map[string]interface{}{
 			"name":      volName,
 			"mountPath": mountPath,
-		})
+		}
+		if config.IsReadOnlyMode(mode) {
+			volumeMount["readOnly"] = true
+		}
+		volumeMounts = append(volumeMounts, volumeMount)
 	}
 	for localPath, containerPath := range cfg.Files {
 		base := filepath.Base(localPath)
@@ -198,6 +254,16 @@ func deploymentResource(name, namespace, project string, cfg *config.Config, ver
 	// Pod volumes
 	var podVolumes []map[string]interface{}
 	for volName := range cfg.Volumes {
+		if config.IsBindMount(volName) {
+			podVolumes = append(podVolumes, map[string]interface{}{
+				"name": bindVolumeName(volName),
+				"hostPath": map[string]interface{}{
+					"path": volName,
+					"type": "DirectoryOrCreate",
+				},
+			})
+			continue
+		}
 		podVolumes = append(podVolumes, map[string]interface{}{
 			"name": volName,
 			"persistentVolumeClaim": map[string]interface{}{
@@ -224,9 +290,38 @@ func deploymentResource(name, namespace, project string, cfg *config.Config, ver
 		podSpec["volumes"] = podVolumes
 	}
 
+	// extra_hosts become hostAliases, one per IP
+	if len(cfg.ExtraHosts) > 0 {
+		byIP := map[string][]string{}
+		for _, host := range slices.Sorted(maps.Keys(cfg.ExtraHosts)) {
+			ip := cfg.ExtraHosts[host]
+			byIP[ip] = append(byIP[ip], host)
+		}
+		var aliases []map[string]interface{}
+		for _, ip := range slices.Sorted(maps.Keys(byIP)) {
+			aliases = append(aliases, map[string]interface{}{"ip": ip, "hostnames": byIP[ip]})
+		}
+		podSpec["hostAliases"] = aliases
+	}
+
+	// Sysctls go on the pod; non-safe ones must be allowed by the kubelet
+	if len(cfg.Sysctls) > 0 {
+		var sysctls []map[string]interface{}
+		for _, key := range slices.Sorted(maps.Keys(cfg.Sysctls)) {
+			sysctls = append(sysctls, map[string]interface{}{"name": key, "value": cfg.Sysctls[key]})
+		}
+		podSpec["securityContext"] = map[string]interface{}{"sysctls": sysctls}
+	}
+
+	// Manual-start services are applied scaled to zero
+	replicas := cfg.Replicas()
+	if cfg.ManualStart() {
+		replicas = 0
+	}
+
 	// Strategy
 	strategyType := "RollingUpdate"
-	if cfg.DeployStrategy() == "recreate" {
+	if s := cfg.DeployStrategy(); s == "recreate" || s == "none" {
 		strategyType = "Recreate"
 	}
 
@@ -242,7 +337,7 @@ func deploymentResource(name, namespace, project string, cfg *config.Config, ver
 			},
 		},
 		"spec": map[string]interface{}{
-			"replicas": cfg.Replicas(),
+			"replicas": replicas,
 			"strategy": map[string]interface{}{
 				"type": strategyType,
 			},
@@ -322,6 +417,9 @@ func ingressResource(name, namespace string, cfg *config.Config) map[string]inte
 	} else {
 		annotations["traefik.ingress.kubernetes.io/router.entrypoints"] = "web"
 	}
+	if len(cfg.Entrypoints) > 0 {
+		annotations["traefik.ingress.kubernetes.io/router.entrypoints"] = strings.Join(cfg.Entrypoints, ",")
+	}
 
 	// Redirect middleware for redirect_to.
 	// Traefik kubernetescrd format: <namespace>-<middleware-name>@kubernetescrd.
@@ -330,6 +428,9 @@ func ingressResource(name, namespace string, cfg *config.Config) map[string]inte
 	if cfg.RedirectTo != "" {
 		annotations["traefik.ingress.kubernetes.io/router.middlewares"] = namespace + "-" + name + "-redirect@kubernetescrd"
 	}
+	if cfg.RoutePriority > 0 {
+		annotations["traefik.ingress.kubernetes.io/router.priority"] = strconv.Itoa(cfg.RoutePriority)
+	}
 
 	// Build rules
 	domains := allDomains(cfg)
@@ -467,10 +568,56 @@ func buildProbe(hc *config.HealthCheck) (map[string]interface{}, error) {
 		probe["failureThreshold"] = hc.Retries
 	}
 
+	if hc.StartPeriod != "" {
+		seconds, err := parseDurationSeconds(hc.StartPeriod)
+		if err != nil {
+			return nil, fmt.Errorf("invalid start_period: %w", err)
+		}
+		probe["initialDelaySeconds"] = seconds
+	}
+
 	return probe, nil
 }
 
 // parseDurationSeconds converts a duration string like "30s", "5m", "1h" to integer seconds.
+// k8sCapabilities strips the optional CAP_ prefix: Kubernetes expects
+// bare names such as NET_ADMIN.
+func k8sCapabilities(caps []string) []string {
+	out := make([]string, len(caps))
+	for i, c := range caps {
+		out[i] = strings.TrimPrefix(c, "CAP_")
+	}
+	return out
+}
+
+// resourceQuantities converts a CPU count and a Docker memory size into
+// Kubernetes quantities, or returns nil when both are empty. Memory units
+// are binary in Docker, so 512m becomes 512Mi.
+func resourceQuantities(cpus, memory string) map[string]interface{} {
+	q := map[string]interface{}{}
+	if cpus != "" {
+		q["cpu"] = cpus
+	}
+	if memory != "" {
+		memory = strings.ToLower(memory)
+		switch memory[len(memory)-1] {
+		case 'k':
+			memory = strings.TrimSuffix(memory, "k") + "Ki"
+		case 'm':
+			memory = strings.TrimSuffix(memory, "m") + "Mi"
+		case 'g':
+			memory = strings.TrimSuffix(memory, "g") + "Gi"
+		case 'b':
+			memory = strings.TrimSuffix(memory, "b")
+		}
+		q["memory"] = memory
+	}
+	if len(q) == 0 {
+		return nil
+	}
+	return q
+}
+
 func parseDurationSeconds(d string) (int, error) {
 	if len(d) < 2 {
 		return 0, fmt.Errorf("invalid duration: %q", d)
@@ -503,3 +650,9 @@ func sanitizeVolumeName(name string) string {
 	name = strings.ReplaceAll(name, "_", "-")
 	return name
 }
+
+// bindVolumeName derives a pod volume name from a bind-mount host path,
+// e.g. /srv/uploads -> host-srv-uploads.
+func bindVolumeName(hostPath string) string {
+	return "host" + strings.ToLower(sanitizeVolumeName(strings.ReplaceAll(hostPath, "/", "-")))
+}
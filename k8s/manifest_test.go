@@ -159,6 +159,61 @@ func TestGenerateManifests_SingleService(t *testing.T) {
 	}
 }
 
+func TestGenerateManifests_RoutePriority(t *testing.T) {
+	services := map[string]*config.Config{
+		"web": {
+			Name:          "web",
+			Server:        "myserver",
+			Stack:         "/stacks/myapp",
+			Domain:        "example.com",
+			Path:          "/api",
+			Port:          3000,
+			RoutePriority: 50,
+		},
+	}
+
+	result, err := GenerateManifests(services, "/stacks/myapp", map[string]int{"web": 1})
+	if err != nil {
+		t.Fatalf("GenerateManifests failed: %v", err)
+	}
+
+	ingress := findDoc(parseMultiDoc(t, result), "Ingress", "web")
+	if ingress == nil {
+		t.Fatal("Ingress resource missing when domain is set")
+	}
+	annotations := ingress["metadata"].(map[string]interface{})["annotations"].(map[string]interface{})
+	if annotations["traefik.ingress.kubernetes.io/router.priority"] != "50" {
+		t.Errorf("priority annotation = %v, want 50", annotations["traefik.ingress.kubernetes.io/router.priority"])
+	}
+}
+
+func TestGenerateManifests_Entrypoints(t *testing.T) {
+	services := map[string]*config.Config{
+		"web": {
+			Name:        "web",
+			Server:      "myserver",
+			Stack:       "/stacks/myapp",
+			Domain:      "example.com",
+			Port:        3000,
+			Entrypoints: []string{"internal", "websecure"},
+		},
+	}
+
+	result, err := GenerateManifests(services, "/stacks/myapp", map[string]int{"web": 1})
+	if err != nil {
+		t.Fatalf("GenerateManifests failed: %v", err)
+	}
+
+	ingress := findDoc(parseMultiDoc(t, result), "Ingress", "web")
+	if ingress == nil {
+		t.Fatal("Ingress resource missing when domain is set")
+	}
+	annotations := ingress["metadata"].(map[string]interface{})["annotations"].(map[string]interface{})
+	if annotations["traefik.ingress.kubernetes.io/router.entrypoints"] != "internal,websecure" {
+		t.Errorf("entrypoints annotation = %v, want internal,websecure", annotations["traefik.ingress.kubernetes.io/router.entrypoints"])
+	}
+}
+
 func TestGenerateManifests_WithDomain(t *testing.T) {
 	services := map[string]*config.Config{
 		"web": {
@@ -241,6 +296,23 @@ func TestGenerateManifests_WithDomain(t *testing.T) {
 	}
 }
 
+func TestGenerateManifests_TCPRouterUnsupported(t *testing.T) {
+	services := map[string]*config.Config{
+		"db": {
+			Name:   "db",
+			Stack:  "/stacks/myapp",
+			Domain: "db.example.com",
+			Router: "tcp",
+			Port:   5432,
+		},
+	}
+
+	_, err := GenerateManifests(services, "/stacks/myapp", map[string]int{"db": 1})
+	if err == nil || !strings.Contains(err.Error(), "router: tcp is not supported") {
+		t.Fatalf("expected unsupported tcp router error, got %v", err)
+	}
+}
+
 func TestGenerateManifests_WithoutDomain(t *testing.T) {
 	services := map[string]*config.Config{
 		"worker": {
@@ -282,10 +354,11 @@ func TestGenerateManifests_WithHealthcheck(t *testing.T) {
 			Stack:  "/stacks/myapp",
 			Port:   3000,
 			HealthCheck: &config.HealthCheck{
-				Cmd:      "curl -f http://localhost:3000/health || exit 1",
-				Interval: "30s",
-				Timeout:  "10s",
-				Retries:  3,
+				Cmd:         "curl -f http://localhost:3000/health || exit 1",
+				Interval:    "30s",
+				Timeout:     "10s",
+				Retries:     3,
+				StartPeriod: "1m",
 			},
 		},
 	}
@@ -326,6 +399,9 @@ func TestGenerateManifests_WithHealthcheck(t *testing.T) {
 	if liveness["failureThreshold"] != 3 {
 		t.Errorf("failureThreshold = %v, want 3", liveness["failureThreshold"])
 	}
+	if liveness["initialDelaySeconds"] != 60 {
+		t.Errorf("initialDelaySeconds = %v, want 60 (start_period)", liveness["initialDelaySeconds"])
+	}
 
 	// Check readinessProbe (same as liveness)
 	readiness := container["readinessProbe"].(map[string]interface{})
@@ -338,6 +414,76 @@ func TestGenerateManifests_WithHealthcheck(t *testing.T) {
 	}
 }
 
+func TestGenerateManifests_BindMountUsesHostPath(t *testing.T) {
+	services := map[string]*config.Config{
+		"web": {
+			Name:    "web",
+			Server:  "myserver",
+			Stack:   "/stacks/myapp",
+			Image:   "nginx:latest",
+			Port:    80,
+			Volumes: map[string]string{"/srv/uploads": "/app/uploads"},
+		},
+	}
+
+	result, err := GenerateManifests(services, "/stacks/myapp", map[string]int{"web": 1})
+	if err != nil {
+		t.Fatalf("GenerateManifests failed: %v", err)
+	}
+
+	docs := parseMultiDoc(t, result)
+	for _, d := range docs {
+		if d["kind"] == "PersistentVolumeClaim" {
+			t.Errorf("bind mount should not create a PVC: %v", d["metadata"])
+		}
+	}
+
+	dep := findDoc(docs, "Deployment", "web")
+	if dep == nil {
+		t.Fatal("Deployment missing")
+	}
+	podSpec := dep["spec"].(map[string]interface{})["template"].(map[string]interface{})["spec"].(map[string]interface{})
+	container := podSpec["containers"].([]interface{})[0].(map[string]interface{})
+	mount := container["volumeMounts"].([]interface{})[0].(map[string]interface{})
+	if mount["name"] != "host-srv-uploads" || mount["mountPath"] != "/app/uploads" {
+		t.Errorf("volumeMount = %v", mount)
+	}
+	vol := podSpec["volumes"].([]interface{})[0].(map[string]interface{})
+	hostPath, ok := vol["hostPath"].(map[string]interface{})
+	if !ok || vol["name"] != "host-srv-uploads" || hostPath["path"] != "/srv/uploads" || hostPath["type"] != "DirectoryOrCreate" {
+		t.Errorf("pod volume = %v", vol)
+	}
+}
+
+func TestGenerateManifests_ReadOnlyVolume(t *testing.T) {
+	services := map[string]*config.Config{
+		"web": {
+			Name:    "web",
+			Server:  "myserver",
+			Stack:   "/stacks/myapp",
+			Image:   "nginx:latest",
+			Port:    80,
+			Volumes: map[string]string{"assets": "/app/assets:ro"},
+		},
+	}
+
+	result, err := GenerateManifests(services, "/stacks/myapp", map[string]int{"web": 1})
+	if err != nil {
+		t.Fatalf("GenerateManifests failed: %v", err)
+	}
+
+	dep := findDoc(parseMultiDoc(t, result), "Deployment", "web")
+	if dep == nil {
+		t.Fatal("Deployment missing")
+	}
+	podSpec := dep["spec"].(map[string]interface{})["template"].(map[string]interface{})["spec"].(map[string]interface{})
+	container := podSpec["containers"].([]interface{})[0].(map[string]interface{})
+	mount := container["volumeMounts"].([]interface{})[0].(map[string]interface{})
+	if mount["mountPath"] != "/app/assets" || mount["readOnly"] != true {
+		t.Errorf("volumeMount = %v, want mountPath /app/assets readOnly true", mount)
+	}
+}
+
 func TestGenerateManifests_WithVolumes(t *testing.T) {
 	services := map[string]*config.Config{
 		"postgres": {
@@ -519,6 +665,7 @@ func TestGenerateManifests_DeployStrategy(t *testing.T) {
 	}{
 		{"rollout", "rollout", "RollingUpdate"},
 		{"recreate", "recreate", "Recreate"},
+		{"none", "none", "Recreate"},
 	}
 
 	for _, tt := range tests {
@@ -932,6 +1079,187 @@ func TestGenerateManifests_Replicas(t *testing.T) {
 	}
 }
 
+func TestGenerateManifests_ManualStartScaledToZero(t *testing.T) {
+	manual := false
+	services := map[string]*config.Config{
+		"backup": {Name: "backup", Stack: "/stacks/myapp", Port: 80, Restart: &manual},
+	}
+	result, err := GenerateManifests(services, "/stacks/myapp", map[string]int{"backup": 1})
+	if err != nil {
+		t.Fatalf("GenerateManifests failed: %v", err)
+	}
+	dep := findDoc(parseMultiDoc(t, result), "Deployment", "backup")
+	spec := dep["spec"].(map[string]interface{})
+	if spec["replicas"] != 0 {
+		t.Errorf("replicas = %v, want 0", spec["replicas"])
+	}
+}
+
+func TestGenerateManifests_Resources(t *testing.T) {
+	services := map[string]*config.Config{
+		"web": {
+			Name:              "web",
+			Stack:             "/stacks/myapp",
+			Port:              80,
+			CPULimit:          "1.5",
+			MemoryLimit:       "1g",
+			CPUReservation:    "0.25",
+			MemoryReservation: "256m",
+		},
+		"api": {Name: "api", Stack: "/stacks/myapp", Port: 80, MemoryReservation: "64k"},
+		"db":  {Name: "db", Stack: "/stacks/myapp", Port: 80},
+	}
+	result, err := GenerateManifests(services, "/stacks/myapp", map[string]int{"web": 1, "api": 1, "db": 1})
+	if err != nil {
+		t.Fatalf("GenerateManifests failed: %v", err)
+	}
+	docs := parseMultiDoc(t, result)
+	container := func(name string) map[string]interface{} {
+		dep := findDoc(docs, "Deployment", name)
+		spec := dep["spec"].(map[string]interface{})
+		podSpec := spec["template"].(map[string]interface{})["spec"].(map[string]interface{})
+		return podSpec["containers"].([]interface{})[0].(map[string]interface{})
+	}
+
+	web := container("web")["resources"].(map[string]interface{})
+	limits := web["limits"].(map[string]interface{})
+	requests := web["requests"].(map[string]interface{})
+	if limits["cpu"] != "1.5" || limits["memory"] != "1Gi" {
+		t.Errorf("limits = %v, want cpu 1.5 memory 1Gi", limits)
+	}
+	if requests["cpu"] != "0.25" || requests["memory"] != "256Mi" {
+		t.Errorf("requests = %v, want cpu 0.25 memory 256Mi", requests)
+	}
+
+	api := container("api")["resources"].(map[string]interface{})
+	if _, ok := api["limits"]; ok {
+		t.Errorf("api has limits, want requests only: %v", api)
+	}
+	if mem := api["requests"].(map[string]interface{})["memory"]; mem != "64Ki" {
+		t.Errorf("api memory request = %v, want 64Ki", mem)
+	}
+
+	if _, ok := container("db")["resources"]; ok {
+		t.Error("db has resources, want none when unset")
+	}
+}
+
+func TestGenerateManifests_Sysctls(t *testing.T) {
+	services := map[string]*config.Config{
+		"db": {
+			Name:    "db",
+			Stack:   "/stacks/myapp",
+			Port:    5432,
+			Sysctls: map[string]string{"net.ipv4.tcp_keepalive_time": "600", "net.core.somaxconn": "1024"},
+		},
+	}
+	result, err := GenerateManifests(services, "/stacks/myapp", map[string]int{"db": 1})
+	if err != nil {
+		t.Fatalf("GenerateManifests failed: %v", err)
+	}
+	dep := findDoc(parseMultiDoc(t, result), "Deployment", "db")
+	podSpec := dep["spec"].(map[string]interface{})["template"].(map[string]interface{})["spec"].(map[string]interface{})
+	sysctls := podSpec["securityContext"].(map[string]interface{})["sysctls"].([]interface{})
+	if len(sysctls) != 2 {
+		t.Fatalf("sysctls = %v, want 2 entries", sysctls)
+	}
+	first := sysctls[0].(map[string]interface{})
+	if first["name"] != "net.core.somaxconn" || first["value"] != "1024" {
+		t.Errorf("first sysctl = %v, want net.core.somaxconn=1024 (sorted)", first)
+	}
+}
+
+func TestGenerateManifests_UlimitsUnsupported(t *testing.T) {
+	services := map[string]*config.Config{
+		"db": {Name: "db", Stack: "/stacks/myapp", Port: 80, Ulimits: map[string]config.Ulimit{"nofile": {Soft: 1024, Hard: 1024}}},
+	}
+	_, err := GenerateManifests(services, "/stacks/myapp", map[string]int{"db": 1})
+	if err == nil || !strings.Contains(err.Error(), "ulimits is not supported") {
+		t.Errorf("err = %v, want ulimits unsupported", err)
+	}
+}
+
+func TestGenerateManifests_ExtraHosts(t *testing.T) {
+	services := map[string]*config.Config{
+		"web": {
+			Name:       "web",
+			Stack:      "/stacks/myapp",
+			Port:       80,
+			ExtraHosts: map[string]string{"ldap.internal": "10.0.0.5", "auth.internal": "10.0.0.5", "db.internal": "10.0.0.7"},
+		},
+	}
+	result, err := GenerateManifests(services, "/stacks/myapp", map[string]int{"web": 1})
+	if err != nil {
+		t.Fatalf("GenerateManifests failed: %v", err)
+	}
+	dep := findDoc(parseMultiDoc(t, result), "Deployment", "web")
+	podSpec := dep["spec"].(map[string]interface{})["template"].(map[string]interface{})["spec"].(map[string]interface{})
+	aliases := podSpec["hostAliases"].([]interface{})
+	if len(aliases) != 2 {
+		t.Fatalf("hostAliases = %v, want one per IP", aliases)
+	}
+	first := aliases[0].(map[string]interface{})
+	hostnames := first["hostnames"].([]interface{})
+	if first["ip"] != "10.0.0.5" || len(hostnames) != 2 || hostnames[0] != "auth.internal" || hostnames[1] != "ldap.internal" {
+		t.Errorf("first alias = %v, want 10.0.0.5 [auth.internal ldap.internal]", first)
+	}
+
+	services["web"].ExtraHosts = map[string]string{"host.internal": config.HostGateway}
+	if _, err := GenerateManifests(services, "/stacks/myapp", map[string]int{"web": 1}); err == nil || !strings.Contains(err.Error(), "host-gateway is not supported") {
+		t.Errorf("err = %v, want host-gateway unsupported", err)
+	}
+}
+
+func TestGenerateManifests_SecurityContext(t *testing.T) {
+	services := map[string]*config.Config{
+		"vpn": {
+			Name:       "vpn",
+			Stack:      "/stacks/myapp",
+			Port:       80,
+			CapAdd:     []string{"CAP_NET_ADMIN"},
+			CapDrop:    []string{"ALL"},
+			Privileged: true,
+		},
+		"web": {Name: "web", Stack: "/stacks/myapp", Port: 80},
+	}
+	result, err := GenerateManifests(services, "/stacks/myapp", map[string]int{"vpn": 1, "web": 1})
+	if err != nil {
+		t.Fatalf("GenerateManifests failed: %v", err)
+	}
+	docs := parseMultiDoc(t, result)
+	container := func(name string) map[string]interface{} {
+		dep := findDoc(docs, "Deployment", name)
+		podSpec := dep["spec"].(map[string]interface{})["template"].(map[string]interface{})["spec"].(map[string]interface{})
+		return podSpec["containers"].([]interface{})[0].(map[string]interface{})
+	}
+
+	sc := container("vpn")["securityContext"].(map[string]interface{})
+	if sc["privileged"] != true {
+		t.Errorf("privileged = %v, want true", sc["privileged"])
+	}
+	caps := sc["capabilities"].(map[string]interface{})
+	if add := caps["add"].([]interface{}); len(add) != 1 || add[0] != "NET_ADMIN" {
+		t.Errorf("capabilities.add = %v, want [NET_ADMIN] without the CAP_ prefix", add)
+	}
+	if drop := caps["drop"].([]interface{}); len(drop) != 1 || drop[0] != "ALL" {
+		t.Errorf("capabilities.drop = %v, want [ALL]", drop)
+	}
+
+	if _, ok := container("web")["securityContext"]; ok {
+		t.Error("web has a securityContext, want none when unset")
+	}
+}
+
+func TestGenerateManifests_ScheduleUnsupported(t *testing.T) {
+	services := map[string]*config.Config{
+		"backup": {Name: "backup", Stack: "/stacks/myapp", Port: 80, Schedule: "0 3 * * *"},
+	}
+	_, err := GenerateManifests(services, "/stacks/myapp", map[string]int{"backup": 1})
+	if err == nil || !strings.Contains(err.Error(), "schedule is not supported") {
+		t.Errorf("err = %v, want schedule unsupported", err)
+	}
+}
+
 func TestGenerateManifests_ReplicasDefaultsToOne(t *testing.T) {
 	services := map[string]*config.Config{
 		"web": {Name: "web", Stack: "/stacks/myapp", Port: 80},
@@ -0,0 +1,19 @@
+// Package logs holds the options for viewing service logs, shared by the
+// compose and k3s runtime clients.
+package logs
+
+import "time"
+
+// TailAll makes a client print the whole log instead of the last N lines.
+const TailAll = -1
+
+// Options controls what GetLogs prints.
+type Options struct {
+	Follow bool // keep streaming new lines
+	Tail   int  // lines from the end of the log; TailAll for everything
+	// Since, when set, leaves out lines logged before it.
+	Since time.Time
+	// Grep, when set, keeps only lines matching this basic regular
+	// expression, filtered on the server (see remote.GrepLines).
+	Grep string
+}
@@ -3,80 +3,746 @@ package main
 import (
 	"bufio"
 	"context"
+	"encoding/json"
+	"flag"
 	"fmt"
 	"io"
+	"maps"
 	"os"
+	"os/exec"
+	"path"
 	"path/filepath"
+	goruntime "runtime"
+	"slices"
 	"sort"
 	"strconv"
 	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
 
 	"al.essio.dev/pkg/shellescape"
 
 	"github.com/byteink/ssd/cleanup"
+	"github.com/byteink/ssd/completion"
+	"github.com/byteink/ssd/compose"
 	"github.com/byteink/ssd/config"
 	"github.com/byteink/ssd/deploy"
+	"github.com/byteink/ssd/doctor"
+	"github.com/byteink/ssd/history"
+	"github.com/byteink/ssd/internal/color"
+	"github.com/byteink/ssd/logs"
 	"github.com/byteink/ssd/provision"
 	"github.com/byteink/ssd/remote"
 	"github.com/byteink/ssd/runtime"
 	"github.com/byteink/ssd/runtime/k3s"
 	"github.com/byteink/ssd/scaffold"
+	"github.com/byteink/ssd/stacks"
 )
 
-// deployServiceBuildOnly builds/pulls the image for a service without starting it.
+// deployServiceBuildOnly builds/pulls the image for a service without starting it,
+// writing its progress to out.
 // Used by deploy-all: build everything first, then docker compose up -d once.
-func deployServiceBuildOnly(rootCfg *config.RootConfig, serviceName string, allServices map[string]*config.Config) error {
-	cfg, err := rootCfg.GetService(serviceName)
-	if err != nil {
-		return err
-	}
+func deployServiceBuildOnly(cfg *config.Config, client remote.RemoteClient, allServices map[string]*config.Config, o deployAllOptions, out io.Writer) (deploy.Result, error) {
+	fmt.Fprintf(out, "Building %s...\n", cfg.Name)
 
-	fmt.Printf("Building %s...\n", cfg.Name)
-
-	client := runtime.New(rootCfg.Runtime, cfg)
 	opts := &deploy.Options{
-		Output:      os.Stdout,
-		AllServices: allServices,
-		BuildOnly:   true,
-		Runtime:     rootCfg.Runtime,
+		Output:       out,
+		AllServices:  allServices,
+		BuildOnly:    true,
+		Runtime:      o.runtime,
+		LockTimeout:  o.lockTimeout,
+		SeedEnvFiles: o.seedEnvFiles,
+		Sources:      client,
+		KeepBuildDir: o.keepBuildDir,
+		Adopt:        o.adopt,
+		SkipBuild:    o.skipBuild,
+		Force:        o.force,
+		Strict:       o.strict,
+		Run:          o.run,
+		SSDVersion:   version,
 	}
 	// BuildOnly deploys don't start services, so no tag cleanup here —
 	// the full-deploy pass that follows will handle cleanup per service.
 
-	return deploy.DeployWithClient(cfg, client, opts)
+	return deploy.DeployWithResult(cfg, client, opts)
+}
+
+// Policies for --on-missing-dep: what a single-service deploy does about
+// depends_on names it can't deploy itself.
+const (
+	// missingDepStartOnly starts every dependency by name and warns about
+	// one with no service in ssd.yaml, as deploys always have.
+	missingDepStartOnly = "start-only"
+	// missingDepFail refuses the deploy up front when a dependency has no
+	// service in ssd.yaml.
+	missingDepFail = "fail"
+	// missingDepBuild is missingDepFail, and also deploys (builds) each
+	// dependency from ssd.yaml that isn't running before the service.
+	missingDepBuild = "build"
+)
+
+// resolveContextOverride checks a --context-override path is a directory
+// in a git repository, since deploys ship the committed tree with git
+// archive, and returns it absolute.
+func resolveContextOverride(path string) (string, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return "", fmt.Errorf("invalid --context-override %q: %w", path, err)
+	}
+	info, err := os.Stat(abs)
+	if err != nil {
+		return "", fmt.Errorf("invalid --context-override: %w", err)
+	}
+	if !info.IsDir() {
+		return "", fmt.Errorf("invalid --context-override: %s is not a directory", abs)
+	}
+	if _, err := remote.GitRoot(abs); err != nil {
+		return "", fmt.Errorf("invalid --context-override: %s is not in a git repository; deploys ship its committed files (git archive HEAD): %w", abs, err)
+	}
+	return abs, nil
+}
+
+// serviceFilter holds deploy-all's --only and --exclude service lists.
+type serviceFilter struct {
+	only    []string
+	exclude []string
+}
+
+// empty reports whether neither --only nor --exclude was given.
+func (f serviceFilter) empty() bool {
+	return len(f.only) == 0 && len(f.exclude) == 0
+}
+
+// apply narrows services (sorted deploy-all names) to the --only services
+// plus everything they depend on, transitively, then drops --exclude'd
+// ones; an explicit exclude wins over a pulled-in dependency. Names not
+// in allServices are an error.
+func (f serviceFilter) apply(services []string, allServices map[string]*config.Config) ([]string, error) {
+	for _, name := range slices.Concat(f.only, f.exclude) {
+		if _, ok := allServices[name]; !ok {
+			return nil, fmt.Errorf("unknown service %q (available: %s)", name, strings.Join(slices.Sorted(maps.Keys(allServices)), ", "))
+		}
+	}
+
+	keep := make(map[string]bool, len(services))
+	if len(f.only) == 0 {
+		for _, name := range services {
+			keep[name] = true
+		}
+	}
+	var include func(name string)
+	include = func(name string) {
+		if keep[name] {
+			return
+		}
+		keep[name] = true
+		if cfg, ok := allServices[name]; ok {
+			for _, dep := range cfg.DependsOn.Names() {
+				include(dep)
+			}
+		}
+	}
+	for _, name := range f.only {
+		include(name)
+	}
+	for _, name := range f.exclude {
+		delete(keep, name)
+	}
+
+	var out []string
+	for _, name := range services {
+		if keep[name] {
+			out = append(out, name)
+		}
+	}
+	return out, nil
+}
+
+// changedSince narrows services (sorted deploy-all names) to those whose
+// build context or Dockerfile has files changed in ref...HEAD, plus the
+// services that depend on them. Pre-built image services have no context
+// and only come in as dependents. Changes to ssd.yaml itself are not
+// seen: only the files a build ships count.
+func changedSince(ref string, services []string, allServices map[string]*config.Config) ([]string, error) {
+	// Context and Dockerfile paths relative to their git root, grouped by root
+	paths := make(map[string]map[string][]string)
+	for _, name := range services {
+		cfg := allServices[name]
+		if cfg.IsPrebuilt() {
+			continue
+		}
+		dir, err := filepath.Abs(cfg.Context)
+		if err != nil {
+			return nil, fmt.Errorf("failed to resolve context of %s: %w", name, err)
+		}
+		root, err := remote.GitRootFor(cfg)(dir)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		rel, err := filepath.Rel(root, dir)
+		if err != nil {
+			return nil, fmt.Errorf("failed to compute relative path: %w", err)
+		}
+		if paths[root] == nil {
+			paths[root] = make(map[string][]string)
+		}
+		paths[root][name] = []string{filepath.ToSlash(rel)}
+		// A Dockerfile outside the context is shipped with it
+		if dockerfile := remote.DockerfilePath(cfg); dockerfile != "" {
+			if rel, err := filepath.Rel(root, dockerfile); err == nil && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+				paths[root][name] = append(paths[root][name], filepath.ToSlash(rel))
+			}
+		}
+	}
+
+	var direct []string
+	for _, root := range slices.Sorted(maps.Keys(paths)) {
+		files, err := remote.ChangedFiles(root, ref)
+		if err != nil {
+			return nil, err
+		}
+		direct = append(direct, changedServices(files, paths[root])...)
+	}
+	return withDependents(direct, services, allServices), nil
+}
+
+// changedServices returns the services, sorted, with at least one changed
+// file among their paths (the context, and the Dockerfile when it lives
+// outside it). A path matches itself and the files below it; all are
+// slash-separated and relative to the repository root, and "." contains
+// every file.
+func changedServices(changed []string, paths map[string][]string) []string {
+	var names []string
+	for name, ps := range paths {
+		if slices.ContainsFunc(ps, func(p string) bool { return touches(changed, path.Clean(p)) }) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// touches reports whether one of the changed files is p or below it.
+func touches(changed []string, p string) bool {
+	for _, f := range changed {
+		if p == "." || f == p || strings.HasPrefix(f, p+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// withDependents returns the services in services that are named, or
+// depend on a named one, transitively, keeping services' order.
+func withDependents(names, services []string, allServices map[string]*config.Config) []string {
+	keep := make(map[string]bool, len(services))
+	for _, name := range names {
+		keep[name] = true
+	}
+	for grew := true; grew; {
+		grew = false
+		for _, name := range services {
+			if keep[name] {
+				continue
+			}
+			for _, dep := range allServices[name].DependsOn.Names() {
+				if keep[dep] {
+					keep[name], grew = true, true
+					break
+				}
+			}
+		}
+	}
+	var out []string
+	for _, name := range services {
+		if keep[name] {
+			out = append(out, name)
+		}
+	}
+	return out
+}
+
+// activeServices splits services into those that run with the selected
+// profiles and those whose profiles were not selected.
+func activeServices(services []string, allServices map[string]*config.Config, selected []string) (active, inactive []string) {
+	for _, name := range services {
+		if allServices[name].ProfileActive(selected) {
+			active = append(active, name)
+		} else {
+			inactive = append(inactive, name)
+		}
+	}
+	return active, inactive
+}
+
+// defaultLogTail is how many log lines `ssd logs` shows without --tail.
+const defaultLogTail = 100
+
+// parseTail parses a --tail value: a non-negative line count, or "all"
+// for logs.TailAll.
+func parseTail(value string) (int, error) {
+	if value == "all" {
+		return logs.TailAll, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("must be a non-negative number or all")
+	}
+	return n, nil
+}
+
+// deployAllOptions carries the collaborators for deployAll so tests can
+// substitute mock clients.
+type deployAllOptions struct {
+	runtime         string
+	continueOnError bool
+	// wholeStack starts every service with a single RestartStack call
+	// instead of per-service StartService/RolloutService.
+	wholeStack  bool
+	lockTimeout time.Duration
+	// prefixOutput tags each service's streamed command output with
+	// "[name] " so builds and rollouts stay readable.
+	prefixOutput bool
+	// seedEnvFiles seeds env files when the first build creates the stack.
+	seedEnvFiles map[string]string
+	// healthWait is --wait/--detach, applied to every started service.
+	healthWait deploy.HealthWait
+	// keepBuildDir is --keep-build-dir: build directories are left on the
+	// server.
+	keepBuildDir bool
+	// adopt is --adopt: an existing compose.yaml ssd did not write is
+	// replaced instead of refused.
+	adopt bool
+	// skipBuild is --skip-build: every service is restarted on its current
+	// version without building or pulling.
+	skipBuild bool
+	// force is --force: services are deployed even when their source is
+	// unchanged.
+	force bool
+	// strict is --strict: uncommitted changes fail the build.
+	strict bool
+	// run holds the build and start flags handed to every client.
+	run remote.RunOptions
+	// newClient returns a client bound to cfg. The client for the first
+	// service is also used for the whole-stack restart.
+	newClient  func(cfg *config.Config) remote.RemoteClient
+	tagCleaner deploy.TagCleaner
+}
+
+// clientFor returns a client for cfg with this run's options, prefixing
+// its streamed output with the service name when prefixOutput is set.
+func (o deployAllOptions) clientFor(cfg *config.Config) remote.RemoteClient {
+	client := o.newClient(cfg)
+	if rc, ok := client.(remote.RunConfigurer); ok {
+		rc.SetRunOptions(o.run)
+	}
+	if p, ok := client.(remote.OutputPrefixer); ok && o.prefixOutput {
+		p.SetOutputPrefix("[" + cfg.Name + "] ")
+	}
+	return client
+}
+
+// deployAllBuilds caps how many services deploy-all builds at once, so a
+// large stack doesn't run every docker build on the server together.
+const deployAllBuilds = 4
+
+// deployAll builds every service, several at a time, then starts each one
+// with its configured strategy. It returns one Result per attempted
+// service, in order, and whether all of them succeeded.
+//
+// By default the first failure stops the run once builds already running
+// have finished. With continueOnError the
+// failure is recorded and the remaining services are still deployed,
+// except those that (transitively) depend on a failed service: they are
+// reported as skipped without being attempted.
+//
+// With wholeStack, once every image is built the stack is brought up with a
+// single RestartStack call (docker compose up -d) and each service then
+// goes through its health gate, cleanup and history as usual.
+func deployAll(services []string, allServices map[string]*config.Config, o deployAllOptions) ([]deploy.Result, bool) {
+	ctx := context.Background()
+	results := make(map[string]*deploy.Result, len(services))
+	failed := make(map[string]bool)
+	summary := func() []deploy.Result {
+		out := make([]deploy.Result, 0, len(services))
+		for _, name := range services {
+			if r, ok := results[name]; ok {
+				out = append(out, *r)
+			}
+		}
+		return out
+	}
+	skip := func(name string) bool {
+		dep := failedDependency(name, allServices, failed, map[string]bool{})
+		if dep == "" {
+			return false
+		}
+		fmt.Printf("    Skipping %s: dependency %s failed\n", name, dep)
+		res := results[name]
+		if res == nil {
+			res = &deploy.Result{Service: name, Strategy: allServices[name].DeployStrategy()}
+			results[name] = res
+		}
+		res.Skipped = true
+		res.Err = fmt.Errorf("dependency %s failed", dep)
+		failed[name] = true
+		return true
+	}
+
+	// Build/pull all images first (BuildOnly mode), up to
+	// deployAllBuilds at a time. A build holds only its service's lock,
+	// and the stack lock just while shared stack state changes, so
+	// services in one stack build side by side. After a failure, unless
+	// continueOnError, no further build begins; those running finish.
+	var (
+		mu      sync.Mutex
+		wg      sync.WaitGroup
+		stopped bool
+	)
+	slots := make(chan struct{}, deployAllBuilds)
+	for _, name := range services {
+		slots <- struct{}{}
+		mu.Lock()
+		if stopped || skip(name) {
+			mu.Unlock()
+			<-slots
+			continue
+		}
+		mu.Unlock()
+		cfg := allServices[name]
+		client := o.clientFor(cfg)
+		var out io.Writer = os.Stdout
+		if o.prefixOutput {
+			out = remote.NewPrefixWriter(os.Stdout, "["+name+"] ")
+		}
+		wg.Add(1)
+		go func() {
+			defer func() {
+				<-slots
+				wg.Done()
+			}()
+			res, err := deployServiceBuildOnly(cfg, client, allServices, o, out)
+			if pw, ok := out.(*remote.PrefixWriter); ok {
+				_ = pw.Flush()
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			results[name] = &res
+			if err != nil {
+				fmt.Printf("\nError building %s: %v\n", name, err)
+				failed[name] = true
+				stopped = stopped || !o.continueOnError
+			}
+		}()
+	}
+	wg.Wait()
+	if stopped {
+		return summary(), false
+	}
+
+	// Deploy each service using its configured strategy
+	fmt.Println("\n==> Starting all services...")
+	var stackErr error
+	var stackDuration time.Duration
+	preStart := func(name string) bool {
+		cfg := allServices[name]
+		if err := deploy.PreStart(ctx, o.clientFor(cfg), cfg, results[name].OldVersion, os.Stdout); err != nil {
+			fmt.Printf("\nError: %v\n", err)
+			results[name].Err = err
+			failed[name] = true
+			return false
+		}
+		return true
+	}
+	if o.wholeStack {
+		for _, name := range services {
+			if failed[name] || skip(name) || results[name].Unchanged || allServices[name].ManualStart() {
+				continue
+			}
+			if !preStart(name) && !o.continueOnError {
+				return summary(), false
+			}
+		}
+		fmt.Println("    whole stack (docker compose up -d)...")
+		start := time.Now()
+		cfg := allServices[services[0]]
+		stackErr = deploy.RestartStackWithClient(cfg, o.newClient(cfg), &deploy.Options{LockTimeout: o.lockTimeout})
+		if stackErr != nil {
+			fmt.Printf("\nError starting stack: %v\n", stackErr)
+		}
+		stackDuration = time.Since(start)
+	}
+	for _, name := range services {
+		if failed[name] || skip(name) || results[name].Unchanged {
+			continue
+		}
+		cfg := allServices[name]
+		manual := cfg.ManualStart()
+		strategy := cfg.DeployStrategy()
+		switch {
+		case manual:
+			strategy = "manual"
+		case o.wholeStack:
+			strategy = "whole-stack"
+		}
+		res := results[name]
+		res.Strategy = strategy
+		res.Duration += stackDuration
+		start := time.Now()
+		switch {
+		case manual:
+			fmt.Printf("    %s: restart: false, not started\n", name)
+		case !o.wholeStack:
+			fmt.Printf("    %s (strategy: %s)...\n", name, strategy)
+		}
+		if !manual && !o.wholeStack && !preStart(name) {
+			res.Duration += time.Since(start)
+			if !o.continueOnError {
+				return summary(), false
+			}
+			continue
+		}
+		var err error
+		switch {
+		case manual:
+		case o.wholeStack:
+			err = stackErr
+		default:
+			if err = deploy.Start(ctx, o.clientFor(cfg), cfg); err != nil {
+				fmt.Printf("\nError starting %s: %v\n", name, err)
+			}
+		}
+		if err == nil && !manual {
+			if err = deploy.AwaitHealthy(ctx, o.clientFor(cfg), cfg, res.OldVersion, o.healthWait, os.Stdout); err != nil {
+				fmt.Printf("\nError: %v\n", err)
+			} else if err = deploy.SmokeTest(ctx, o.clientFor(cfg), cfg, res.OldVersion, o.healthWait, os.Stdout); err != nil {
+				fmt.Printf("\nError: %v\n", err)
+			}
+		}
+		if err != nil {
+			res.Err = err
+			res.Duration += time.Since(start)
+			failed[name] = true
+			if !o.continueOnError {
+				return summary(), false
+			}
+			continue
+		}
+
+		// Post-deploy image cleanup per service (warn-only).
+		// Use a per-service client so GetCurrentVersion parses the
+		// correct image tag from the manifest.
+		if o.tagCleaner != nil && !cfg.IsPrebuilt() && cfg.RetainTags() > 0 {
+			version, _ := o.newClient(cfg).GetCurrentVersion(ctx)
+			if err := o.tagCleaner.PruneOldTags(ctx, cfg.ImageName(), cfg.RetainTags(), version); err != nil {
+				fmt.Printf("    Warning: image cleanup failed for %s: %v\n", name, err)
+			}
+		}
+		if err := o.newClient(cfg).SyncSchedule(ctx); err != nil {
+			fmt.Printf("\nError: failed to sync schedule for %s: %v\n", name, err)
+			res.Err = err
+			res.Duration += time.Since(start)
+			failed[name] = true
+			if !o.continueOnError {
+				return summary(), false
+			}
+			continue
+		}
+		if err := o.newClient(cfg).AppendHistory(ctx, name, res.NewVersion); err != nil {
+			fmt.Printf("    Warning: failed to record deploy history for %s: %v\n", name, err)
+		}
+		if res.SourceTree != "" {
+			if err := o.newClient(cfg).RecordSourceTree(ctx, name, res.SourceRecord()); err != nil {
+				fmt.Printf("    Warning: failed to record source tree for %s: %v\n", name, err)
+			}
+		}
+		res.Duration += time.Since(start)
+	}
+
+	return summary(), len(failed) == 0
+}
+
+// failedDependency returns the name of a failed service that name depends
+// on, directly or through other services, or "" if none failed.
+func failedDependency(name string, allServices map[string]*config.Config, failed, seen map[string]bool) string {
+	if seen[name] {
+		return ""
+	}
+	seen[name] = true
+	cfg, ok := allServices[name]
+	if !ok {
+		return ""
+	}
+	for _, dep := range cfg.DependsOn.Names() {
+		if failed[dep] {
+			return dep
+		}
+		if d := failedDependency(dep, allServices, failed, seen); d != "" {
+			return d
+		}
+	}
+	return ""
+}
+
+// printDeploySummary prints the per-service result table for deploy-all.
+func printDeploySummary(results []deploy.Result) {
+	fmt.Println(color.Line(os.Stdout, "\n==> Summary"))
+	if err := deploy.WriteSummary(os.Stdout, results); err != nil {
+		fmt.Printf(errorFmt, err)
+	}
+}
+
+// failedServices returns the names of services in results that failed or
+// were skipped, in result order.
+func failedServices(results []deploy.Result) []string {
+	var names []string
+	for _, r := range results {
+		if r.Err != nil {
+			names = append(names, r.Service)
+		}
+	}
+	return names
 }
 
 // tagCleanerFor returns a deploy.TagCleaner backed by the real runtime
 // cleanup implementation. Returns nil when the client doesn't expose SSH
 // (shouldn't happen for compose/k3s clients, but keeps the contract safe).
 func tagCleanerFor(rt string, client remote.RemoteClient) deploy.TagCleaner {
-	return &deployTagCleaner{cleaner: cleanup.NewCleaner(rt, client)}
+	return &deployTagCleaner{rt: rt, client: client}
 }
 
 type deployTagCleaner struct {
-	cleaner cleanup.ImageCleaner
+	rt     string
+	client remote.RemoteClient
 }
 
+// PruneOldTags allows the cleaner to remove tags of image, which the
+// deploy built and may be named by image_template.
 func (d *deployTagCleaner) PruneOldTags(ctx context.Context, image string, retention, running int) error {
-	_, err := cleanup.PruneOldTags(ctx, d.cleaner, image, retention, running)
+	_, err := cleanup.PruneOldTags(ctx, cleanup.NewCleaner(d.rt, d.client, image), image, retention, running)
 	return err
 }
 
 var version = "dev"
 
 // errorFmt is the standard fmt.Printf format for printing an error to
-// stdout before os.Exit(1). Centralised so the wording is consistent.
+// stdout. Fatal errors go through fail/failf, which use it in text mode.
 const errorFmt = "Error: %v\n"
 
+// exit terminates the process. A variable so tests can observe fatal paths
+// without killing the test binary.
+var exit = os.Exit
+
+// Output modes for the global --output flag.
+const (
+	outputText = "text"
+	outputJSON = "json"
+)
+
+// outputMode is set by --output. In json mode the command's outcome is
+// written to jsonOut as a single commandResult and human-readable text is
+// redirected to stderr, so stdout carries nothing but the JSON line.
+var (
+	outputMode           = outputText
+	jsonOut    io.Writer = os.Stdout
+)
+
+// commandResult is the JSON document emitted in --output json mode.
+// Stage names the phase that failed: "args" (flags and usage), "config"
+// (loading ssd.yaml or selecting a service), "confirm" (interactive
+// prompts) or "run" (the command's remote work).
+type commandResult struct {
+	Command string `json:"command"`
+	Service string `json:"service,omitempty"`
+	OK      bool   `json:"ok"`
+	Error   string `json:"error,omitempty"`
+	Stage   string `json:"stage,omitempty"`
+}
+
+// current tracks the command and service being run so fatal paths can
+// report them without threading both through every function.
+var current commandResult
+
+// writeResult encodes r as a single JSON line to w. HTML escaping is off
+// so usage strings like "<service>" stay readable.
+func writeResult(w io.Writer, r commandResult) error {
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	return enc.Encode(r)
+}
+
+// reportFailure prints err for the user: "Error: ..." in text mode, or a
+// commandResult with ok=false in json mode. It does not exit.
+func reportFailure(stage string, err error) {
+	if outputMode != outputJSON {
+		fmt.Print(color.Line(os.Stdout, fmt.Sprintf(errorFmt, err)))
+		return
+	}
+	r := current
+	r.OK = false
+	r.Error = err.Error()
+	r.Stage = stage
+	if werr := writeResult(jsonOut, r); werr != nil {
+		fmt.Fprintf(os.Stderr, errorFmt, werr)
+	}
+}
+
+// reportSuccess emits the ok=true commandResult in json mode. No-op in
+// text mode, where each command prints its own confirmation.
+func reportSuccess() {
+	if outputMode != outputJSON {
+		return
+	}
+	r := current
+	r.OK = true
+	if err := writeResult(jsonOut, r); err != nil {
+		fmt.Fprintf(os.Stderr, errorFmt, err)
+	}
+}
+
+// fail reports err at the given stage and exits with status 1.
+func fail(stage string, err error) {
+	reportFailure(stage, err)
+	exit(1)
+}
+
+// failf is fail with a formatted error.
+func failf(stage, format string, a ...any) {
+	fail(stage, fmt.Errorf(format, a...))
+}
+
+// failUsage reports a usage error and exits with status 1. Text mode
+// prints the familiar "Usage: ..." line.
+func failUsage(usage string) {
+	if outputMode != outputJSON {
+		fmt.Println("Usage: " + usage)
+	} else {
+		reportFailure("args", fmt.Errorf("usage: %s", usage))
+	}
+	exit(1)
+}
+
 // Global flags: --config and --env/-e are accepted on every command and
 // stripped from args before the command-specific parser sees them. They
 // only apply to commands that load ssd.yaml; runtime-only commands (init,
-// skill, version, help) ignore them.
+// skill, version, help) ignore them. --output is handled alongside them
+// and sets outputMode; --log-file names a file that gets a copy of the
+// streamed command output (see openLogFile); --project-dir overrides
+// project_dir (see applyProjectDir); --no-color turns off colored output
+// (see internal/color).
 var (
 	globalConfigPath string
 	globalEnvName    string
+	globalLogFile    string
+	globalProjectDir string
+	globalNoColor    bool
 )
 
+// configEnvVar names the environment variable that supplies the config
+// path when --config is not given.
+const configEnvVar = "SSD_CONFIG"
+
 func main() {
 	if len(os.Args) < 2 {
 		printUsage()
@@ -85,15 +751,29 @@ func main() {
 
 	command := os.Args[1]
 	args := os.Args[2:]
+	current.Command = command
 
 	// Strip global flags from args so existing per-command parsers stay
 	// untouched. Errors are reported to the user and abort the run.
-	cleaned, err := extractGlobalFlags(args)
+	cleaned, err := extractGlobalFlags(command, args)
 	if err != nil {
-		fmt.Printf(errorFmt, err)
-		os.Exit(1)
+		fail("args", err)
 	}
 	args = cleaned
+	if globalNoColor {
+		color.Disable()
+	}
+	applyConfigEnv()
+	if err := openLogFile(); err != nil {
+		fail("args", err)
+	}
+
+	// In json mode stdout is reserved for the result line; everything the
+	// commands print for humans goes to stderr instead.
+	if outputMode == outputJSON {
+		jsonOut = os.Stdout
+		os.Stdout = os.Stderr
+	}
 
 	switch command {
 	case "version", "-v", "--version":
@@ -104,14 +784,34 @@ func main() {
 		runDown(args)
 	case "rm":
 		runRm(args)
+	case "stop":
+		runStop(args)
+	case "start":
+		runStart(args)
 	case "restart":
 		runRestart(args)
 	case "rollback":
 		runRollback(args)
+	case "adopt":
+		runAdopt(args)
+	case "doctor":
+		runDoctor(args)
+	case "history":
+		runHistory(args)
+	case "diff":
+		runDiff(args)
+	case "plan":
+		runPlan(args)
+	case "compose":
+		runCompose(args)
 	case "status":
 		runStatus(args)
+	case "ps":
+		runPs(args)
 	case "logs":
 		runLogs(args)
+	case "open":
+		runOpen(args)
 	case "config":
 		runConfig(args)
 	case "env":
@@ -130,20 +830,30 @@ func main() {
 		runSkill(args)
 	case "provision":
 		runProvision(args)
+	case "completion":
+		runCompletion(args)
+	case completion.ServicesCommand:
+		runCompleteServices()
+		return
 	case "help", "-h", "--help":
 		printUsage()
 	default:
-		fmt.Printf("Unknown command: %s\n\n", command)
+		reportFailure("args", fmt.Errorf("unknown command: %s", command))
+		fmt.Println()
 		printUsage()
-		os.Exit(1)
+		exit(1)
 	}
+	reportSuccess()
 }
 
 // extractGlobalFlags peels --config <path>, --config=<path>, --env <name>,
-// --env=<name>, and -e <name> out of args. Recognised on every command;
+// --env=<name>, -e <name>, --log-file <path>, --project-dir <path>,
+// --no-color and --output <mode> out of args. Recognised on every command;
 // commands that don't load ssd.yaml simply ignore the resolved values.
+// --output is left alone for `ssd compose`, whose own --output names a file.
 // Stops at "--" to leave pass-through args alone (e.g. logs follow flags).
-func extractGlobalFlags(args []string) ([]string, error) {
+func extractGlobalFlags(command string, args []string) ([]string, error) {
+	globalOutput := command != "compose"
 	out := make([]string, 0, len(args))
 	for i := 0; i < len(args); i++ {
 		a := args[i]
@@ -168,6 +878,36 @@ func extractGlobalFlags(args []string) ([]string, error) {
 			i++
 		case strings.HasPrefix(a, "--env="):
 			globalEnvName = strings.TrimPrefix(a, "--env=")
+		case a == "--log-file":
+			if i+1 >= len(args) {
+				return nil, fmt.Errorf("flag --log-file requires a value")
+			}
+			globalLogFile = args[i+1]
+			i++
+		case strings.HasPrefix(a, "--log-file="):
+			globalLogFile = strings.TrimPrefix(a, "--log-file=")
+		case a == "--project-dir":
+			if i+1 >= len(args) {
+				return nil, fmt.Errorf("flag --project-dir requires a value")
+			}
+			globalProjectDir = args[i+1]
+			i++
+		case strings.HasPrefix(a, "--project-dir="):
+			globalProjectDir = strings.TrimPrefix(a, "--project-dir=")
+		case a == "--no-color":
+			globalNoColor = true
+		case a == "--output" && globalOutput:
+			if i+1 >= len(args) {
+				return nil, fmt.Errorf("flag --output requires a value")
+			}
+			if err := setOutputMode(args[i+1]); err != nil {
+				return nil, err
+			}
+			i++
+		case strings.HasPrefix(a, "--output=") && globalOutput:
+			if err := setOutputMode(strings.TrimPrefix(a, "--output=")); err != nil {
+				return nil, err
+			}
 		default:
 			out = append(out, a)
 		}
@@ -175,28 +915,117 @@ func extractGlobalFlags(args []string) ([]string, error) {
 	return out, nil
 }
 
-// loadRootConfig resolves and loads the ssd config using the global
-// --config and --env flags. Exits on error.
-//
-// As a side effect, prints layout-related warnings to stderr:
-//   - both .ssd/ssd.yaml and ./ssd.yaml exist (delete the legacy one)
-//   - only ./ssd.yaml exists (suggest `ssd migrate`)
-// The warning is only emitted when --config was not given, since an
-// explicit path means the user is being deliberate about which file.
-func loadRootConfig() *config.RootConfig {
-	rootCfg, _, err := config.Resolve(globalConfigPath, globalEnvName)
+// applyConfigEnv sets globalConfigPath from $SSD_CONFIG when --config
+// was not given, so the flag wins over the environment.
+func applyConfigEnv() {
+	if globalConfigPath == "" {
+		globalConfigPath = os.Getenv(configEnvVar)
+	}
+}
+
+// applyProjectDir makes --project-dir, when given, the git repository
+// root builds are archived from, over project_dir in ssd.yaml.
+func applyProjectDir(rootCfg *config.RootConfig) {
+	if globalProjectDir != "" {
+		rootCfg.ProjectDir = globalProjectDir
+	}
+}
+
+// openLogFile creates the --log-file, truncating an existing one, and tees
+// all streamed command output (builds, rsync, rollouts) into it. The file
+// stays open until the process exits; writes are unbuffered, so nothing
+// is lost when a command exits early.
+func openLogFile() error {
+	if globalLogFile == "" {
+		return nil
+	}
+	f, err := os.Create(globalLogFile)
 	if err != nil {
-		fmt.Printf(errorFmt, err)
-		os.Exit(1)
+		return fmt.Errorf("failed to open --log-file: %w", err)
 	}
-	if globalConfigPath == "" {
-		if werr := warnLayout(os.Stderr, config.DetectLayout()); werr != nil {
-			// stderr is broken; fall back to stdout so the user at
-			// least sees the nudge before continuing.
-			fmt.Println(werr)
-		}
+	remote.TeeOutput(f)
+	return nil
+}
+
+// setOutputMode validates and applies a --output value.
+func setOutputMode(mode string) error {
+	switch mode {
+	case outputText, outputJSON:
+		outputMode = mode
+		return nil
 	}
-	return rootCfg
+	return fmt.Errorf("invalid --output %q: expected text or json", mode)
+}
+
+// newFlagSet returns the flag.FlagSet for an ssd subcommand. Parse errors
+// are returned rather than printed so they go through fail like any other
+// error; -h/--help never reaches it because wantsHelp runs first.
+func newFlagSet(command string) *flag.FlagSet {
+	fs := flag.NewFlagSet("ssd "+command, flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	return fs
+}
+
+// parseFlags parses args with fs and returns the positional arguments.
+// Unlike fs.Parse, which stops at the first positional, flags may appear
+// before, between or after positionals (`ssd logs -f web` and
+// `ssd logs web -f` are the same). "--" ends flag parsing; everything
+// after it is positional.
+func parseFlags(fs *flag.FlagSet, args []string) ([]string, error) {
+	var positional []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, err
+		}
+		rest := fs.Args()
+		if len(rest) == 0 {
+			return positional, nil
+		}
+		if consumed := len(args) - len(rest); consumed > 0 && args[consumed-1] == "--" {
+			return append(positional, rest...), nil
+		}
+		positional = append(positional, rest[0])
+		args = rest[1:]
+	}
+}
+
+// parsePositional parses a command with no flags of its own beyond those
+// registered on fs, returning at most max positional arguments.
+func parsePositional(fs *flag.FlagSet, args []string, max int) ([]string, error) {
+	positional, err := parseFlags(fs, args)
+	if err != nil {
+		return nil, err
+	}
+	if len(positional) > max {
+		return nil, fmt.Errorf("unexpected argument: %s", positional[max])
+	}
+	return positional, nil
+}
+
+// loadRootConfig resolves and loads the ssd config using the global
+// --config and --env flags. Exits on error.
+//
+// As a side effect, prints layout-related warnings to stderr:
+//   - both .ssd/ssd.yaml and ./ssd.yaml exist (delete the legacy one)
+//   - only ./ssd.yaml exists (suggest `ssd migrate`)
+//
+// The warning is only emitted when neither --config nor $SSD_CONFIG was
+// given, since an explicit path means the user is being deliberate about
+// which file.
+func loadRootConfig() *config.RootConfig {
+	rootCfg, _, err := config.Resolve(globalConfigPath, globalEnvName)
+	if err != nil {
+		fail("config", err)
+	}
+	applyProjectDir(rootCfg)
+	if globalConfigPath == "" {
+		if werr := warnLayout(os.Stderr, config.DetectLayout()); werr != nil {
+			// stderr is broken; fall back to stdout so the user at
+			// least sees the nudge before continuing.
+			fmt.Println(werr)
+		}
+	}
+	return rootCfg
 }
 
 // warnLayout writes a single nudge line to w when the project layout
@@ -221,17 +1050,90 @@ func warnLayout(w io.Writer, layout config.Layout) error {
 	return err
 }
 
+// confirmPrompt describes a destructive operation for confirm.
+type confirmPrompt struct {
+	service string
+	server  string
+	action  string
+}
+
+// confirm echoes what is about to happen and asks the user to agree.
+// The summary is always printed; the question is skipped (and confirm
+// returns true) when yes is set or when interactive is false, so scripts
+// and CI keep working. Only "y" or "yes" proceed; anything else,
+// including empty input or EOF, aborts.
+func confirm(in io.Reader, out io.Writer, interactive, yes bool, p confirmPrompt) (bool, error) {
+	summary := fmt.Sprintf("\nService: %s\nServer:  %s\nAction:  %s\n", p.service, p.server, p.action)
+	if _, err := fmt.Fprint(out, summary); err != nil {
+		return false, err
+	}
+	if yes || !interactive {
+		return true, nil
+	}
+	if _, err := fmt.Fprint(out, "Continue? [y/N] "); err != nil {
+		return false, err
+	}
+	input, err := bufio.NewReader(in).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return false, err
+	}
+	switch strings.ToLower(strings.TrimSpace(input)) {
+	case "y", "yes":
+		return true, nil
+	}
+	return false, nil
+}
+
+// confirmOrAbort wires confirm to the real terminal. Prints "Aborted."
+// and returns false when the user declines; exits on I/O errors.
+func confirmOrAbort(yes bool, p confirmPrompt) bool {
+	if !yes && stdoutIsTerminal() {
+		if err := stdinPromptError("a confirmation", "pass --yes to skip it"); err != nil {
+			fail("args", err)
+		}
+	}
+	ok, err := confirm(os.Stdin, os.Stdout, stdoutIsTerminal(), yes, p)
+	if err != nil {
+		fail("confirm", err)
+	}
+	if !ok {
+		fmt.Println("Aborted.")
+	}
+	return ok
+}
+
+// stdinPromptError reports why a command can't prompt for what on stdin:
+// with --config - stdin carried the config, so a prompt would only see
+// its end. hint says how to avoid the prompt. Nil when stdin is free.
+func stdinPromptError(what, hint string) error {
+	if globalConfigPath != config.StdinPath {
+		return nil
+	}
+	return fmt.Errorf("cannot ask for %s: the config was read from stdin (--config -); %s", what, hint)
+}
+
+// stdoutIsTerminal reports whether stdout is attached to a terminal.
+func stdoutIsTerminal() bool {
+	fi, err := os.Stdout.Stat()
+	if err != nil {
+		return false
+	}
+	return fi.Mode()&os.ModeCharDevice != 0
+}
+
 func loadConfig(serviceName string) (*config.RootConfig, *config.Config) {
 	rootCfg := loadRootConfig()
 
 	cfg, err := rootCfg.GetService(serviceName)
 	if err != nil {
-		fmt.Printf(errorFmt, err)
+		current.Service = serviceName
+		reportFailure("config", err)
 		if !rootCfg.IsSingleService() {
 			fmt.Printf("Available services: %s\n", strings.Join(rootCfg.ListServices(), ", "))
 		}
-		os.Exit(1)
+		exit(1)
 	}
+	current.Service = cfg.Name
 
 	return rootCfg, cfg
 }
@@ -242,145 +1144,439 @@ func runDeploy(args []string) {
 		return
 	}
 
+	f, err := parseDeployFlags(args)
+	if err != nil {
+		fail("args", err)
+	}
+	if f.contextOverride != "" {
+		if f.contextOverride, err = resolveContextOverride(f.contextOverride); err != nil {
+			fail("args", err)
+		}
+	}
 	rootCfg := loadRootConfig()
+	rootCfg.ActiveProfiles = f.profiles
+	run := remote.RunOptions{
+		NoForceRecreate: !f.forceRecreate,
+		PullBase:        f.pull,
+		QuietBuild:      f.quietBuild,
+		BuildArgs:       f.buildArgs,
+	}
+	if f.stack != "" {
+		rootCfg.OverrideStack(f.stack)
+		fmt.Printf("Deploying to stack %s (--stack) instead of the one in ssd.yaml\n", f.stack)
+	}
+	if f.image != "" && f.service == "" {
+		if !rootCfg.IsSingleService() {
+			failf("args", "--image deploys one service; name it (ssd deploy <service> --image <ref>)")
+		}
+		f.service = rootCfg.ListServices()[0]
+	}
+	if f.forceVersion > 0 && f.service == "" {
+		if !rootCfg.IsSingleService() {
+			failf("args", "--force-version deploys one service; name it (ssd deploy <service> --force-version %d)", f.forceVersion)
+		}
+		f.service = rootCfg.ListServices()[0]
+	}
+	if f.contextOverride != "" && f.service == "" {
+		if !rootCfg.IsSingleService() {
+			failf("args", "--context-override builds one service; name it (ssd deploy <service> --context-override <path>)")
+		}
+		f.service = rootCfg.ListServices()[0]
+	}
+	for _, name := range slices.Sorted(maps.Keys(f.seedEnv)) {
+		if _, ok := rootCfg.Services[name]; !ok {
+			failf("args", "--service-env-file names unknown service %q", name)
+		}
+	}
 
 	// No args: deploy all services
-	if len(args) == 0 {
+	if f.service == "" {
 		services := rootCfg.ListServices()
 		if len(services) == 0 {
-			fmt.Println("Error: no services defined in ssd.yaml")
-			os.Exit(1)
+			failf("config", "no services defined in ssd.yaml")
 		}
 		sort.Strings(services)
 
-		fmt.Printf("Deploying all services: %s\n\n", strings.Join(services, ", "))
-
 		// Precompute all service configs once
 		allServices := make(map[string]*config.Config, len(services))
 		for _, name := range services {
 			svcCfg, err := rootCfg.GetService(name)
 			if err != nil {
-				fmt.Printf("\nError loading service %s: %v\n", name, err)
-				os.Exit(1)
+				failf("config", "loading service %s: %w", name, err)
 			}
 			allServices[name] = svcCfg
 		}
 
-		// Build/pull all images first (BuildOnly mode)
-		for _, name := range services {
-			if err := deployServiceBuildOnly(rootCfg, name, allServices); err != nil {
-				fmt.Printf("\nError building %s: %v\n", name, err)
-				os.Exit(1)
+		if !f.filter.empty() {
+			if services, err = f.filter.apply(services, allServices); err != nil {
+				fail("args", err)
+			}
+			if len(services) == 0 {
+				failf("args", "--only/--exclude left no services to deploy")
 			}
 		}
-
-		// Deploy each service using its configured strategy
-		fmt.Println("\n==> Starting all services...")
-		client := runtime.New(rootCfg.Runtime, allServices[services[0]])
-		tagCleaner := tagCleanerFor(rootCfg.Runtime, client)
-		for _, name := range services {
-			cfg := allServices[name]
-			strategy := cfg.DeployStrategy()
-			fmt.Printf("    %s (strategy: %s)...\n", name, strategy)
-			switch strategy {
-			case "rollout":
-				if err := client.RolloutService(context.Background(), name); err != nil {
-					fmt.Printf("\nError rolling out %s: %v\n", name, err)
-					os.Exit(1)
-				}
-			default:
-				if err := client.StartService(context.Background(), name); err != nil {
-					fmt.Printf("\nError starting %s: %v\n", name, err)
-					os.Exit(1)
-				}
+		if f.since != "" {
+			if services, err = changedSince(f.since, services, allServices); err != nil {
+				fail("run", err)
 			}
+			if len(services) == 0 {
+				fmt.Printf("No services changed since %s; nothing to deploy.\n", f.since)
+				return
+			}
+		}
 
-			// Post-deploy image cleanup per service (warn-only).
-			// Use a per-service client so GetCurrentVersion parses the
-			// correct image tag from the manifest.
-			if !cfg.IsPrebuilt() && cfg.RetainTags() > 0 {
-				svcClient := runtime.New(rootCfg.Runtime, cfg)
-				version, _ := svcClient.GetCurrentVersion(context.Background())
-				if err := tagCleaner.PruneOldTags(context.Background(), cfg.ImageName(), cfg.RetainTags(), version); err != nil {
-					fmt.Printf("    Warning: image cleanup failed for %s: %v\n", name, err)
-				}
+		// Services behind an unselected profile stay in compose.yaml
+		// but are neither built nor started.
+		services, inactive := activeServices(services, allServices, f.profiles)
+		if len(services) == 0 {
+			failf("args", "no services to deploy; every service needs a --profile that was not selected")
+		}
+		for _, name := range services {
+			if len(allServices[name].Hosts()) > 1 {
+				failf("config", "%s deploys to several servers, which deploy-all does not support; deploy it by name (ssd deploy %s)", name, name)
 			}
 		}
+		fmt.Printf("Deploying all services: %s\n", strings.Join(services, ", "))
+		if len(inactive) > 0 {
+			fmt.Printf("Skipping (profile not selected): %s\n", strings.Join(inactive, ", "))
+		}
+		fmt.Println()
 
-		fmt.Println("\nAll services deployed successfully!")
+		client := runtime.New(rootCfg.Runtime, allServices[services[0]])
+		results, ok := deployAll(services, allServices, deployAllOptions{
+			runtime:         rootCfg.Runtime,
+			continueOnError: f.continueOnError,
+			wholeStack:      f.wholeStack,
+			lockTimeout:     f.lockTimeout,
+			prefixOutput:    f.prefixOutput,
+			seedEnvFiles:    f.seedEnv,
+			healthWait:      f.healthWait,
+			keepBuildDir:    f.keepBuildDir,
+			adopt:           f.adopt,
+			skipBuild:       f.skipBuild,
+			force:           f.force,
+			strict:          f.strict,
+			run:             run,
+			newClient: func(cfg *config.Config) remote.RemoteClient {
+				return runtime.New(rootCfg.Runtime, cfg)
+			},
+			tagCleaner: tagCleanerFor(rootCfg.Runtime, client),
+		})
+		printDeploySummary(results)
+		if !ok {
+			fmt.Println()
+			fail("run", fmt.Errorf("some services failed to deploy: %s", strings.Join(failedServices(results), ", ")))
+		}
+		fmt.Println(color.Line(os.Stdout, "\nAll services deployed successfully!"))
 
 		// Detect orphaned services on the server
 		detectOrphans(rootCfg, allServices, client)
 		return
 	}
 
-	serviceName := args[0]
-	if err := deployService(rootCfg, serviceName); err != nil {
-		fmt.Printf("\nError: %v\n", err)
-		os.Exit(1)
+	serviceName := f.service
+	current.Service = serviceName
+	if err := deployService(rootCfg, serviceName, deployServiceOptions{
+		image:           f.image,
+		forceVersion:    f.forceVersion,
+		contextOverride: f.contextOverride,
+		lockTimeout:     f.lockTimeout,
+		seedEnvFiles:    f.seedEnv,
+		healthWait:      f.healthWait,
+		continueOnError: f.continueOnError,
+		keepBuildDir:    f.keepBuildDir,
+		adopt:           f.adopt,
+		noDeps:          f.noDeps,
+		recreateDeps:    f.recreateDeps,
+		onMissingDep:    f.onMissingDep,
+		skipBuild:       f.skipBuild,
+		force:           f.force,
+		strict:          f.strict,
+		run:             run,
+	}); err != nil {
+		fail("run", err)
 	}
 }
 
-func runDown(args []string) {
-	if wantsHelp(args) {
-		printDownHelp()
-		return
+// deployFlags captures the parsed state of `ssd deploy` options.
+type deployFlags struct {
+	service         string
+	continueOnError bool
+	wholeStack      bool
+	prefixOutput    bool
+	lockTimeout     time.Duration
+	profiles        []string
+	seedEnv         map[string]string
+	forceRecreate   bool
+	force           bool
+	strict          bool
+	pull            bool
+	quietBuild      bool
+	keepBuildDir    bool
+	adopt           bool
+	noDeps          bool
+	recreateDeps    bool
+	skipBuild       bool
+	buildArgs       map[string]string
+	healthWait      deploy.HealthWait
+	image           string
+	forceVersion    int
+	contextOverride string
+	filter          serviceFilter
+	since           string
+	onMissingDep    string
+	stack           string
+}
+
+// parseDeployFlags parses the argument list for `ssd deploy`: an optional
+// service name and the deploy options. Combinations that are wrong
+// whatever the config says are rejected here; --context-override is only
+// checked to be non-empty, since resolving it touches the filesystem.
+func parseDeployFlags(args []string) (deployFlags, error) {
+	f := deployFlags{healthWait: deploy.WaitDefault}
+	var wait, detach bool
+	fs := newFlagSet("deploy")
+	fs.BoolVar(&f.continueOnError, "continue-on-error", false, "keep deploying after a service fails")
+	fs.BoolVar(&f.wholeStack, "whole-stack", false, "start the stack at once after building every image")
+	fs.BoolVar(&f.prefixOutput, "prefix-output", false, "tag build and rollout output with the service name")
+	lockTimeoutFlag(fs, &f.lockTimeout)
+	profileFlag(fs, &f.profiles)
+	fs.BoolVar(&f.forceRecreate, "force-recreate", true, "recreate containers whose image and config are unchanged")
+	fs.BoolVar(&f.force, "force", false, "deploy even when the source is unchanged")
+	fs.BoolVar(&f.strict, "strict", false, "fail when the build context has uncommitted changes")
+	fs.BoolVar(&f.pull, "pull", false, "pull the Dockerfile's base images before building")
+	fs.BoolVar(&f.quietBuild, "quiet-build", false, "only show build output when the build fails")
+	fs.BoolVar(&f.keepBuildDir, "keep-build-dir", false, "leave the remote build directory in place")
+	fs.BoolVar(&f.adopt, "adopt", false, "replace a compose.yaml that ssd did not write")
+	fs.BoolVar(&f.noDeps, "no-deps", false, "do not check or start depends_on services")
+	fs.BoolVar(&f.recreateDeps, "recreate-deps", false, "start depends_on services even when they are running")
+	fs.BoolVar(&f.skipBuild, "skip-build", false, "restart the current version without building")
+	fs.BoolVar(&wait, "wait", false, "wait for services to become healthy")
+	fs.BoolVar(&detach, "detach", false, "do not wait for services to become healthy")
+	fs.Func("service-env-file", "<service>=<path> env file to seed (repeatable)", func(v string) error {
+		service, path, ok := strings.Cut(v, "=")
+		if !ok || service == "" || path == "" {
+			return fmt.Errorf("must be <service>=<path>")
+		}
+		if err := config.ValidateEnvFile(path); err != nil {
+			return fmt.Errorf("for %s: %w", service, err)
+		}
+		if f.seedEnv == nil {
+			f.seedEnv = make(map[string]string)
+		}
+		f.seedEnv[service] = path
+		return nil
+	})
+	fs.Func("build-arg", "KEY=VALUE build argument (repeatable)", func(v string) error {
+		key, val, err := config.ParseBuildArg(v)
+		if err != nil {
+			return err
+		}
+		if f.buildArgs == nil {
+			f.buildArgs = make(map[string]string)
+		}
+		f.buildArgs[key] = val
+		return nil
+	})
+	fs.Func("image", "deploy this image instead of building", func(v string) error {
+		if err := config.ValidateImage(v); err != nil {
+			return err
+		}
+		f.image = v
+		return nil
+	})
+	fs.Func("force-version", "version number to deploy as", func(v string) error {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			return fmt.Errorf("must be a version number of at least 1")
+		}
+		f.forceVersion = n
+		return nil
+	})
+	fs.Func("context-override", "build from this directory instead", func(v string) error {
+		if v == "" {
+			return fmt.Errorf("must not be empty")
+		}
+		f.contextOverride = v
+		return nil
+	})
+	fs.Func("only", "deploy only these services (comma-separated, repeatable)", serviceListFlag(&f.filter.only))
+	fs.Func("exclude", "skip these services (comma-separated, repeatable)", serviceListFlag(&f.filter.exclude))
+	fs.Func("since", "only deploy services changed since this git ref", func(v string) error {
+		if v == "" || strings.HasPrefix(v, "-") {
+			return fmt.Errorf("%q is not a git ref", v)
+		}
+		f.since = v
+		return nil
+	})
+	fs.Func("on-missing-dep", "fail, start-only or build", func(v string) error {
+		switch v {
+		case missingDepStartOnly, missingDepFail, missingDepBuild:
+		default:
+			return fmt.Errorf("must be fail, start-only or build")
+		}
+		f.onMissingDep = v
+		return nil
+	})
+	fs.Func("stack", "absolute stack directory to deploy to", func(v string) error {
+		if !filepath.IsAbs(v) {
+			return fmt.Errorf("must be an absolute path")
+		}
+		f.stack = v
+		return nil
+	})
+	positional, err := parsePositional(fs, args, 1)
+	if err != nil {
+		return deployFlags{}, err
+	}
+	if len(positional) == 1 {
+		f.service = positional[0]
+	}
+	switch {
+	case wait && detach:
+		return deployFlags{}, fmt.Errorf("--wait and --detach cannot be combined")
+	case wait:
+		f.healthWait = deploy.WaitHealthy
+	case detach:
+		f.healthWait = deploy.WaitNone
 	}
 
-	rootCfg := loadRootConfig()
+	named := f.service != ""
+	switch {
+	case f.contextOverride != "" && f.image != "":
+		return deployFlags{}, fmt.Errorf("--context-override sets what is built; it cannot be combined with --image")
+	case f.since != "" && (named || f.image != "" || f.forceVersion > 0 || f.contextOverride != ""):
+		return deployFlags{}, fmt.Errorf("--since selects services for deploy-all; it cannot be combined with a service name, --image, --force-version or --context-override")
+	case !f.filter.empty() && named:
+		return deployFlags{}, fmt.Errorf("--only and --exclude filter deploy-all; they cannot be combined with a service name")
+	case !f.filter.empty() && f.wholeStack:
+		return deployFlags{}, fmt.Errorf("--whole-stack starts every service; it cannot be combined with --only or --exclude")
+	case f.wholeStack && named:
+		return deployFlags{}, fmt.Errorf("--whole-stack deploys every service; it cannot be combined with a service name")
+	case f.prefixOutput && named:
+		return deployFlags{}, fmt.Errorf("--prefix-output only applies when deploying every service")
+	case f.noDeps && !named:
+		return deployFlags{}, fmt.Errorf("--no-deps applies when deploying one service; deploy-all never auto-starts dependencies")
+	case f.recreateDeps && !named:
+		return deployFlags{}, fmt.Errorf("--recreate-deps applies when deploying one service; deploy-all never auto-starts dependencies")
+	case f.noDeps && f.recreateDeps:
+		return deployFlags{}, fmt.Errorf("--no-deps and --recreate-deps cannot be combined")
+	case f.onMissingDep != "" && !named:
+		return deployFlags{}, fmt.Errorf("--on-missing-dep applies when deploying one service; deploy-all never auto-starts dependencies")
+	case f.onMissingDep != "" && f.noDeps:
+		return deployFlags{}, fmt.Errorf("--no-deps leaves dependencies alone; it cannot be combined with --on-missing-dep")
+	case f.skipBuild && f.image != "":
+		return deployFlags{}, fmt.Errorf("--skip-build restarts the current image; it cannot be combined with --image")
+	case f.skipBuild && f.forceVersion > 0:
+		return deployFlags{}, fmt.Errorf("--skip-build keeps the current version; it cannot be combined with --force-version")
+	case f.skipBuild && f.contextOverride != "":
+		return deployFlags{}, fmt.Errorf("--skip-build builds nothing; it cannot be combined with --context-override")
+	}
+	return f, nil
+}
 
-	var services []string
-	if len(args) == 0 {
-		services = rootCfg.ListServices()
-		sort.Strings(services)
-	} else {
-		services = []string{args[0]}
+// serviceListFlag returns the fs.Func callback for --only and --exclude,
+// which take comma-separated service names and may be repeated.
+func serviceListFlag(list *[]string) func(string) error {
+	return func(v string) error {
+		for _, name := range strings.Split(v, ",") {
+			if name = strings.TrimSpace(name); name == "" {
+				return fmt.Errorf("empty service name in %q", v)
+			}
+			*list = append(*list, name)
+		}
+		return nil
 	}
+}
 
-	// Use first service to create client
-	cfg, err := rootCfg.GetService(services[0])
+// lockTimeoutFlag registers --lock-timeout on fs. d stays 0, which keeps
+// the default lock timeout, when the flag is absent.
+func lockTimeoutFlag(fs *flag.FlagSet, d *time.Duration) {
+	fs.Func("lock-timeout", "how long to wait for the deploy lock", func(v string) error {
+		timeout, err := time.ParseDuration(v)
+		if err != nil || timeout <= 0 {
+			return fmt.Errorf("must be a positive duration like 30s or 10m")
+		}
+		*d = timeout
+		return nil
+	})
+}
+
+// yesFlag registers --yes and its -y shorthand on fs.
+func yesFlag(fs *flag.FlagSet, yes *bool) {
+	fs.BoolVar(yes, "yes", false, "skip the confirmation prompt")
+	fs.BoolVar(yes, "y", false, "shorthand for --yes")
+}
+
+// profileFlag registers the repeatable --profile on fs, appending each
+// selected compose profile to profiles in order.
+func profileFlag(fs *flag.FlagSet, profiles *[]string) {
+	fs.Func("profile", "compose profile to activate (repeatable)", func(v string) error {
+		if err := config.ValidateProfile(v); err != nil {
+			return err
+		}
+		*profiles = append(*profiles, v)
+		return nil
+	})
+}
+
+// downFlags captures the parsed state of `ssd down` options.
+type downFlags struct {
+	service string
+	volumes bool
+	yes     bool
+}
+
+// parseDownFlags parses the argument list for `ssd down`. At most one
+// positional service name is accepted; it only selects which config
+// (server, stack) to use since the whole stack is brought down.
+func parseDownFlags(args []string) (downFlags, error) {
+	var f downFlags
+	fs := newFlagSet("down")
+	fs.BoolVar(&f.volumes, "volumes", false, "also remove named volumes")
+	fs.BoolVar(&f.volumes, "v", false, "shorthand for --volumes")
+	yesFlag(fs, &f.yes)
+	positional, err := parsePositional(fs, args, 1)
 	if err != nil {
-		fmt.Printf(errorFmt, err)
-		os.Exit(1)
+		return downFlags{}, err
 	}
+	if len(positional) == 1 {
+		f.service = positional[0]
+	}
+	return f, nil
+}
 
-	client := runtime.New(rootCfg.Runtime, cfg)
-	ctx := context.Background()
+func runDown(args []string) {
+	if wantsHelp(args) {
+		printDownHelp()
+		return
+	}
 
-	for _, name := range services {
-		svcCfg, err := rootCfg.GetService(name)
-		if err != nil {
-			fmt.Printf(errorFmt, err)
-			os.Exit(1)
-		}
+	flags, err := parseDownFlags(args)
+	if err != nil {
+		fail("args", err)
+	}
 
-		fmt.Printf("Stopping %s...\n", svcCfg.Name)
+	rootCfg, cfg := loadConfig(flags.service)
 
-		switch rootCfg.Runtime {
-		case "k3s":
-			namespace := filepath.Base(svcCfg.Stack)
-			cmd := fmt.Sprintf("k3s kubectl scale deployment/%s -n %s --replicas=0",
-				shellescape.Quote(svcCfg.Name),
-				shellescape.Quote(namespace))
-			if _, err := client.SSH(ctx, cmd); err != nil {
-				fmt.Printf("Error stopping %s: %v\n", svcCfg.Name, err)
-				os.Exit(1)
-			}
-		default: // compose
-			cmd := fmt.Sprintf("cd %s && docker compose stop %s",
-				shellescape.Quote(svcCfg.StackPath()),
-				shellescape.Quote(svcCfg.Name))
-			if _, err := client.SSH(ctx, cmd); err != nil {
-				fmt.Printf("Error stopping %s: %v\n", svcCfg.Name, err)
-				os.Exit(1)
-			}
-		}
+	action := "docker compose down (stop and remove all containers in " + cfg.StackPath() + ")"
+	if rootCfg.Runtime == "k3s" {
+		action = "delete all workloads in namespace " + filepath.Base(cfg.Stack)
+	}
+	service := "entire stack"
+	if flags.service != "" {
+		service = "entire stack (selected via " + flags.service + ")"
+	}
+	if flags.volumes {
+		action += ", including volumes (data cannot be recovered)"
+	}
+	if !confirmOrAbort(flags.yes, confirmPrompt{service: service, server: cfg.Server, action: action}) {
+		return
 	}
 
-	if len(services) == 1 {
-		fmt.Printf("%s stopped.\n", services[0])
-	} else {
-		fmt.Println("All services stopped.")
+	client := runtime.New(rootCfg.Runtime, cfg)
+	if err := deploy.DownWithClient(cfg, client, flags.volumes, &deploy.Options{Output: os.Stdout, Runtime: rootCfg.Runtime}); err != nil {
+		fail("run", err)
 	}
 }
 
@@ -389,6 +1585,13 @@ func runRm(args []string) {
 		printRmHelp()
 		return
 	}
+	args, err := parsePositional(newFlagSet("rm"), args, 1)
+	if err != nil {
+		fail("args", err)
+	}
+	if err := stdinPromptError("a confirmation", "rm always asks, so pass the config as a file"); err != nil {
+		fail("args", err)
+	}
 
 	rootCfg := loadRootConfig()
 
@@ -403,8 +1606,7 @@ func runRm(args []string) {
 	// Use first service for server info and client
 	cfg, err := rootCfg.GetService(services[0])
 	if err != nil {
-		fmt.Printf(errorFmt, err)
-		os.Exit(1)
+		fail("config", err)
 	}
 
 	client := runtime.New(rootCfg.Runtime, cfg)
@@ -421,16 +1623,16 @@ func runRm(args []string) {
 	if len(running) > 0 {
 		stackName := filepath.Base(cfg.Stack)
 		if len(running) == 1 {
-			fmt.Printf("Error: service '%s' is still running.\n", running[0])
-			fmt.Printf("Run 'ssd down %s' first.\n", running[0])
+			reportFailure("run", fmt.Errorf("service '%s' is still running", running[0]))
+			fmt.Printf("Run 'ssd stop %s' first.\n", running[0])
 		} else {
-			fmt.Printf("Error: %d services are still running in stack '%s':\n", len(running), stackName)
+			reportFailure("run", fmt.Errorf("%d services are still running in stack '%s'", len(running), stackName))
 			for _, name := range running {
 				fmt.Printf("  - %s\n", name)
 			}
 			fmt.Printf("Run 'ssd down' to stop all services first.\n")
 		}
-		os.Exit(1)
+		exit(1)
 	}
 
 	// Warning
@@ -449,8 +1651,7 @@ func runRm(args []string) {
 	reader := bufio.NewReader(os.Stdin)
 	input, err := reader.ReadString('\n')
 	if err != nil {
-		fmt.Printf(errorFmt, err)
-		os.Exit(1)
+		fail("confirm", err)
 	}
 	if strings.ToLower(strings.TrimSpace(input)) != "y" {
 		fmt.Println("Aborted.")
@@ -460,8 +1661,7 @@ func runRm(args []string) {
 	for _, name := range services {
 		svcCfg, err := rootCfg.GetService(name)
 		if err != nil {
-			fmt.Printf(errorFmt, err)
-			os.Exit(1)
+			fail("config", err)
 		}
 		rmService(rootCfg, svcCfg, client, ctx)
 	}
@@ -497,8 +1697,9 @@ func rmService(rootCfg *config.RootConfig, cfg *config.Config, client remote.Rem
 		_, _ = client.SSH(ctx, cmd)
 
 	default: // compose
-		cmd := fmt.Sprintf("cd %s && docker compose rm -sf %s",
+		cmd := fmt.Sprintf("cd %s && %s rm -sf %s",
 			shellescape.Quote(cfg.StackPath()),
+			remote.ComposeCommand(cfg),
 			shellescape.Quote(cfg.Name))
 		if _, err := client.SSH(ctx, cmd); err != nil {
 			fmt.Printf("  Warning: failed to remove container: %v\n", err)
@@ -514,7 +1715,47 @@ func rmService(rootCfg *config.RootConfig, cfg *config.Config, client remote.Rem
 	_, _ = client.SSH(ctx, rmCmd)
 }
 
-func deployService(rootCfg *config.RootConfig, serviceName string) error {
+// deployServiceOptions are the flags of a single-service deploy.
+type deployServiceOptions struct {
+	// image is --image: deploy this image instead of building.
+	image string
+	// forceVersion is --force-version; 0 deploys current+1.
+	forceVersion int
+	// contextOverride is --context-override, an absolute path built
+	// instead of the configured context.
+	contextOverride string
+	lockTimeout     time.Duration
+	seedEnvFiles    map[string]string
+	healthWait      deploy.HealthWait
+	// continueOnError keeps deploying to the remaining servers after one
+	// fails.
+	continueOnError bool
+	keepBuildDir    bool
+	adopt           bool
+	// noDeps is --no-deps: dependencies are neither checked nor started.
+	noDeps bool
+	// recreateDeps is --recreate-deps: dependencies are started even when
+	// running.
+	recreateDeps bool
+	// onMissingDep is --on-missing-dep; "" means start-only.
+	onMissingDep string
+	// dependents are the services whose deploy, under
+	// --on-missing-dep=build, is waiting on this one. They are not
+	// deployed again, so a depends_on cycle can't recurse forever.
+	dependents []string
+	// skipBuild is --skip-build: the current version is restarted without
+	// building or pulling.
+	skipBuild bool
+	// force is --force: the service is deployed even when its source is
+	// unchanged.
+	force bool
+	// strict is --strict: uncommitted changes fail the deploy.
+	strict bool
+	// run holds the build and start flags handed to the client.
+	run remote.RunOptions
+}
+
+func deployService(rootCfg *config.RootConfig, serviceName string, o deployServiceOptions) error {
 	cfg, err := rootCfg.GetService(serviceName)
 	if err != nil {
 		if !rootCfg.IsSingleService() {
@@ -522,20 +1763,21 @@ func deployService(rootCfg *config.RootConfig, serviceName string) error {
 		}
 		return err
 	}
-
-	// Load dependency configs if any
-	var depConfigs map[string]*config.Config
-	depNames := cfg.DependsOn.Names()
-	if len(depNames) > 0 {
-		depConfigs = make(map[string]*config.Config)
-		for _, dep := range depNames {
-			depCfg, err := rootCfg.GetService(dep)
-			if err != nil {
-				fmt.Printf("Warning: Could not load dependency %s config: %v\n", dep, err)
-				continue
-			}
-			depConfigs[dep] = depCfg
+	image := o.image
+	if image != "" {
+		cfg.Image = image
+	}
+	if o.contextOverride != "" {
+		if cfg.IsPrebuilt() {
+			return fmt.Errorf("--context-override: %s uses the pre-built image %s and is not built", cfg.Name, cfg.Image)
 		}
+		fmt.Printf("Building %s from %s (--context-override) instead of %s\n", cfg.Name, o.contextOverride, cfg.Context)
+		cfg.Context = o.contextOverride
+	}
+
+	depConfigs, err := dependencyConfigs(rootCfg, cfg, o.onMissingDep)
+	if err != nil {
+		return err
 	}
 
 	// Load all service configs for initial stack creation
@@ -547,40 +1789,289 @@ func deployService(rootCfg *config.RootConfig, serviceName string) error {
 		}
 		allServices[name] = svcCfg
 	}
+	if image != "" || o.contextOverride != "" {
+		allServices[serviceName] = cfg
+	}
+
+	newClient := func(cfg *config.Config) remote.RemoteClient {
+		return runtime.New(rootCfg.Runtime, cfg)
+	}
+	newOpts := func(client remote.RemoteClient) *deploy.Options {
+		return &deploy.Options{
+			Output:        os.Stdout,
+			Dependencies:  depConfigs,
+			AllServices:   allServices,
+			Runtime:       rootCfg.Runtime,
+			TagCleaner:    tagCleanerFor(rootCfg.Runtime, client),
+			History:       client,
+			Scheduler:     client,
+			LockTimeout:   o.lockTimeout,
+			SeedEnvFiles:  o.seedEnvFiles,
+			Sources:       client,
+			HealthWait:    o.healthWait,
+			KeepBuildDir:  o.keepBuildDir,
+			ForceVersion:  o.forceVersion,
+			Adopt:         o.adopt,
+			NoDeps:        o.noDeps,
+			RecreateDeps:  o.recreateDeps,
+			SkipBuild:     o.skipBuild,
+			Force:         o.force,
+			Strict:        o.strict,
+			ImageOverride: image != "",
+			Run:           o.run,
+			SSDVersion:    version,
+		}
+	}
+
+	if o.onMissingDep == missingDepBuild {
+		depOpts := deployServiceOptions{
+			lockTimeout:     o.lockTimeout,
+			seedEnvFiles:    o.seedEnvFiles,
+			healthWait:      o.healthWait,
+			continueOnError: o.continueOnError,
+			keepBuildDir:    o.keepBuildDir,
+			adopt:           o.adopt,
+			onMissingDep:    o.onMissingDep,
+			dependents:      append(slices.Clone(o.dependents), cfg.Name),
+			force:           o.force,
+			strict:          o.strict,
+			run:             o.run,
+		}
+		isRunning := func(depCfg *config.Config) (bool, error) {
+			return newClient(depCfg).IsServiceRunning(context.Background(), depCfg.Name)
+		}
+		deployDep := func(name string) error {
+			fmt.Printf("Deploying dependency %s of %s first (--on-missing-dep=build)...\n\n", name, cfg.Name)
+			return deployService(rootCfg, name, depOpts)
+		}
+		if err := deployStoppedDependencies(cfg, depConfigs, o.dependents, isRunning, deployDep); err != nil {
+			return err
+		}
+	}
+
+	if hosts := cfg.Hosts(); len(hosts) > 1 {
+		fmt.Printf("Deploying %s to %s...\n", cfg.Name, strings.Join(hosts, ", "))
+		results, ok := deployToServers(cfg, newClient, newOpts, o.continueOnError)
+		printDeploySummary(results)
+		if !ok {
+			fmt.Println()
+			return fmt.Errorf("deploy failed on: %s", strings.Join(failedServices(results), ", "))
+		}
+		return nil
+	}
 
 	fmt.Printf("Deploying %s to %s...\n\n", cfg.Name, cfg.Server)
 
-	client := runtime.New(rootCfg.Runtime, cfg)
-	opts := &deploy.Options{
-		Output:       os.Stdout,
-		Dependencies: depConfigs,
-		AllServices:  allServices,
-		Runtime:      rootCfg.Runtime,
-		TagCleaner:   tagCleanerFor(rootCfg.Runtime, client),
+	client := newClient(cfg)
+	return deploy.DeployWithClient(cfg, client, newOpts(client))
+}
+
+// dependencyConfigs loads the configs of cfg's depends_on services. A
+// dependency that is not a service in ssd.yaml can only be started by
+// name: under the start-only policy ("" included) that is a warning,
+// under fail and build an error naming every such dependency, before
+// anything is deployed.
+func dependencyConfigs(rootCfg *config.RootConfig, cfg *config.Config, policy string) (map[string]*config.Config, error) {
+	depNames := cfg.DependsOn.Names()
+	if len(depNames) == 0 {
+		return nil, nil
+	}
+	depConfigs := make(map[string]*config.Config)
+	var missing []string
+	for _, dep := range depNames {
+		if _, ok := rootCfg.Services[dep]; !ok && policy != "" && policy != missingDepStartOnly {
+			missing = append(missing, dep)
+			continue
+		}
+		depCfg, err := rootCfg.GetService(dep)
+		if err != nil {
+			fmt.Printf("Warning: Could not load dependency %s config: %v\n", dep, err)
+			continue
+		}
+		depConfigs[dep] = depCfg
 	}
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("%s depends on %s, which ssd.yaml does not define (--on-missing-dep=%s); add them as services or use --on-missing-dep=start-only to start them by name",
+			cfg.Name, strings.Join(missing, ", "), policy)
+	}
+	return depConfigs, nil
+}
 
-	return deploy.DeployWithClient(cfg, client, opts)
+// deployStoppedDependencies deploys, in depends_on order, each of cfg's
+// dependencies that is built from ssd.yaml and not running, so the
+// service's own deploy finds it there to start. Pre-built, restart: false
+// and already running dependencies are left to the deploy, as are the
+// dependents whose deploy is waiting on cfg's.
+func deployStoppedDependencies(cfg *config.Config, depConfigs map[string]*config.Config, dependents []string, isRunning func(*config.Config) (bool, error), deployDep func(name string) error) error {
+	for _, dep := range cfg.DependsOn.Names() {
+		depCfg, ok := depConfigs[dep]
+		if !ok || depCfg.IsPrebuilt() || depCfg.ManualStart() || slices.Contains(dependents, dep) {
+			continue
+		}
+		running, err := isRunning(depCfg)
+		if err != nil {
+			return fmt.Errorf("failed to check if dependency %s is running: %w", dep, err)
+		}
+		if running {
+			continue
+		}
+		if err := deployDep(dep); err != nil {
+			return fmt.Errorf("failed to deploy dependency %s: %w", dep, err)
+		}
+	}
+	return nil
 }
 
-func runRestart(args []string) {
+// deployToServers deploys cfg to each of its hosts in turn, each with its
+// own client, and returns one Result per host, labelled service@host, and
+// whether every host succeeded. Each host syncs and builds the source
+// itself. The first failure stops the run unless continueOnError is set;
+// hosts left untried are reported as skipped.
+func deployToServers(cfg *config.Config, newClient func(*config.Config) remote.RemoteClient, newOpts func(remote.RemoteClient) *deploy.Options, continueOnError bool) ([]deploy.Result, bool) {
+	hosts := cfg.Hosts()
+	results := make([]deploy.Result, 0, len(hosts))
+	ok := true
+	for i, host := range hosts {
+		label := cfg.Name + "@" + host
+		if !ok && !continueOnError {
+			for _, rest := range hosts[i:] {
+				results = append(results, deploy.Result{
+					Service:  cfg.Name + "@" + rest,
+					Strategy: cfg.DeployStrategy(),
+					Skipped:  true,
+					Err:      fmt.Errorf("an earlier server failed"),
+				})
+			}
+			break
+		}
+		fmt.Printf("\n==> %s\n", host)
+		hostCfg := cfg.OnServer(host)
+		client := newClient(hostCfg)
+		res, err := deploy.DeployWithResult(hostCfg, client, newOpts(client))
+		res.Service = label
+		if err != nil {
+			fmt.Printf("\nError deploying to %s: %v\n", host, err)
+			ok = false
+		}
+		results = append(results, res)
+	}
+	return results, ok
+}
+
+func runStop(args []string) {
 	if wantsHelp(args) {
-		printRestartHelp()
+		printStopHelp()
 		return
 	}
-
-	serviceName := ""
-	if len(args) > 0 {
-		serviceName = args[0]
+	args, err := parsePositional(newFlagSet("stop"), args, 1)
+	if err != nil {
+		fail("args", err)
+	}
+	if len(args) == 0 {
+		failUsage("ssd stop <service>")
 	}
 
-	rootCfg, cfg := loadConfig(serviceName)
+	rootCfg, cfg := loadConfig(args[0])
 
-	fmt.Printf("Restarting %s on %s...\n\n", cfg.Name, cfg.Server)
+	fmt.Printf("Stopping %s on %s...\n\n", cfg.Name, cfg.Server)
 
 	client := runtime.New(rootCfg.Runtime, cfg)
-	if err := deploy.RestartWithClient(cfg, client, &deploy.Options{Output: os.Stdout, Runtime: rootCfg.Runtime}); err != nil {
-		fmt.Printf("\nError: %v\n", err)
-		os.Exit(1)
+	if err := deploy.StopWithClient(cfg, client, &deploy.Options{Output: os.Stdout, Runtime: rootCfg.Runtime}); err != nil {
+		fail("run", err)
+	}
+}
+
+func runStart(args []string) {
+	if wantsHelp(args) {
+		printStartHelp()
+		return
+	}
+	var profiles []string
+	fs := newFlagSet("start")
+	profileFlag(fs, &profiles)
+	args, err := parsePositional(fs, args, 1)
+	if err != nil {
+		fail("args", err)
+	}
+	if len(args) == 0 {
+		failUsage("ssd start <service>")
+	}
+
+	rootCfg, cfg := loadConfig(args[0])
+	cfg.ActiveProfiles = profiles
+
+	fmt.Printf("Starting %s on %s...\n\n", cfg.Name, cfg.Server)
+
+	client := runtime.New(rootCfg.Runtime, cfg)
+	if err := deploy.StartWithClient(cfg, client, &deploy.Options{Output: os.Stdout, Runtime: rootCfg.Runtime}); err != nil {
+		fail("run", err)
+	}
+}
+
+func runRestart(args []string) {
+	if wantsHelp(args) {
+		printRestartHelp()
+		return
+	}
+
+	var lockTimeout time.Duration
+	fs := newFlagSet("restart")
+	lockTimeoutFlag(fs, &lockTimeout)
+	rolling := fs.Bool("rolling", false, "restart services one at a time, waiting for each to become healthy")
+	args, err := parsePositional(fs, args, 1)
+	if err != nil {
+		fail("args", err)
+	}
+	serviceName := ""
+	if len(args) > 0 {
+		serviceName = args[0]
+	}
+
+	if *rolling {
+		runRollingRestart(serviceName, lockTimeout)
+		return
+	}
+
+	rootCfg, cfg := loadConfig(serviceName)
+
+	fmt.Printf("Restarting %s on %s...\n\n", cfg.Name, cfg.Server)
+
+	client := runtime.New(rootCfg.Runtime, cfg)
+	if err := deploy.RestartWithClient(cfg, client, &deploy.Options{Output: os.Stdout, Runtime: rootCfg.Runtime, LockTimeout: lockTimeout}); err != nil {
+		fail("run", err)
+	}
+}
+
+// runRollingRestart restarts serviceName, or every service in name order,
+// one at a time with a health wait after each (restart --rolling).
+func runRollingRestart(serviceName string, lockTimeout time.Duration) {
+	rootCfg := loadRootConfig()
+	names := []string{serviceName}
+	if serviceName == "" {
+		names = rootCfg.ListServices()
+		sort.Strings(names)
+	}
+	if len(names) == 0 {
+		failf("config", "no services defined in ssd.yaml")
+	}
+	services := make([]*config.Config, 0, len(names))
+	for _, name := range names {
+		cfg, err := rootCfg.GetService(name)
+		if err != nil {
+			current.Service = name
+			fail("config", err)
+		}
+		services = append(services, cfg)
+	}
+	current.Service = serviceName
+
+	fmt.Printf("Restarting %s on %s one at a time...\n\n", strings.Join(names, ", "), services[0].Server)
+
+	clientFor := func(cfg *config.Config) deploy.Deployer {
+		return runtime.New(rootCfg.Runtime, cfg)
+	}
+	if err := deploy.RollingRestartWithClient(services, clientFor, &deploy.Options{Output: os.Stdout, Runtime: rootCfg.Runtime, LockTimeout: lockTimeout}); err != nil {
+		fail("run", err)
 	}
 }
 
@@ -590,6 +2081,17 @@ func runRollback(args []string) {
 		return
 	}
 
+	var (
+		yes         bool
+		lockTimeout time.Duration
+	)
+	fs := newFlagSet("rollback")
+	yesFlag(fs, &yes)
+	lockTimeoutFlag(fs, &lockTimeout)
+	args, err := parsePositional(fs, args, 1)
+	if err != nil {
+		fail("args", err)
+	}
 	serviceName := ""
 	if len(args) > 0 {
 		serviceName = args[0]
@@ -597,69 +2099,754 @@ func runRollback(args []string) {
 
 	rootCfg, cfg := loadConfig(serviceName)
 
+	if !confirmOrAbort(yes, confirmPrompt{service: cfg.Name, server: cfg.Server, action: "roll back to the previous image version and restart"}) {
+		return
+	}
+
 	fmt.Printf("Rolling back %s on %s...\n\n", cfg.Name, cfg.Server)
 
 	client := runtime.New(rootCfg.Runtime, cfg)
-	if err := deploy.RollbackWithClient(cfg, client, &deploy.Options{Output: os.Stdout, Runtime: rootCfg.Runtime}); err != nil {
-		fmt.Printf("\nError: %v\n", err)
-		os.Exit(1)
+	if err := deploy.RollbackWithClient(cfg, client, &deploy.Options{Output: os.Stdout, Runtime: rootCfg.Runtime, LockTimeout: lockTimeout}); err != nil {
+		fail("run", err)
+	}
+}
+
+func runAdopt(args []string) {
+	if wantsHelp(args) {
+		printAdoptHelp()
+		return
+	}
+	var lockTimeout time.Duration
+	fs := newFlagSet("adopt")
+	lockTimeoutFlag(fs, &lockTimeout)
+	args, err := parsePositional(fs, args, 1)
+	if err != nil {
+		fail("args", err)
+	}
+
+	rootCfg := loadRootConfig()
+	if rootCfg.Runtime == "k3s" {
+		failf("config", "adopt supports the compose runtime only")
+	}
+	services := rootCfg.ListServices()
+	if len(services) == 0 {
+		failf("config", "no services defined in ssd.yaml")
+	}
+	sort.Strings(services)
+
+	allServices := make(map[string]*config.Config, len(services))
+	for _, name := range services {
+		svcCfg, err := rootCfg.GetService(name)
+		if err != nil {
+			failf("config", "loading service %s: %w", name, err)
+		}
+		allServices[name] = svcCfg
+	}
+
+	// The compose file is per stack; any service's config reaches it.
+	selected := services[0]
+	if len(args) > 0 {
+		if _, ok := allServices[args[0]]; !ok {
+			reportFailure("config", fmt.Errorf("service %q not found", args[0]))
+			fmt.Printf("Available services: %s\n", strings.Join(services, ", "))
+			exit(1)
+		}
+		selected = args[0]
+	}
+	cfg := allServices[selected]
+
+	fmt.Printf("Adopting %s in %s on %s...\n\n", cfg.ComposeFilename(), cfg.StackPath(), cfg.Server)
+
+	client := runtime.New(rootCfg.Runtime, cfg)
+	adoption, err := deploy.AdoptWithClient(cfg, client, &deploy.Options{
+		Output:      os.Stdout,
+		AllServices: allServices,
+		Runtime:     rootCfg.Runtime,
+		LockTimeout: lockTimeout,
+	})
+	if err != nil {
+		fail("run", err)
+	}
+	if err := writeAdoption(os.Stdout, adoption, cfg.ComposeFilename()); err != nil {
+		fail("run", err)
+	}
+}
+
+// writeAdoption reports how the services of an adopted compose file map
+// onto ssd.yaml, with an ssd.yaml entry for each service it doesn't have.
+func writeAdoption(w io.Writer, a deploy.Adoption, manifest string) error {
+	var sb strings.Builder
+	for _, name := range slices.Sorted(maps.Keys(a.Versions)) {
+		fmt.Fprintf(&sb, "  %s: version %d\n", name, a.Versions[name])
+	}
+	for _, name := range slices.Sorted(maps.Keys(a.External)) {
+		fmt.Fprintf(&sb, "  %s: runs %s until it is next deployed\n", name, a.External[name])
+	}
+	for _, name := range a.Missing {
+		fmt.Fprintf(&sb, "  %s: not in %s yet; its next deploy adds it\n", name, manifest)
+	}
+	if len(a.Unknown) > 0 {
+		fmt.Fprintf(&sb, "\nNot in ssd.yaml; the next deploy drops them from %s. To keep them, add:\n\n", manifest)
+		for _, name := range slices.Sorted(maps.Keys(a.Unknown)) {
+			sb.WriteString(adoptedServiceYAML(a.Unknown[name]))
+		}
+	}
+	if !a.AlreadyManaged {
+		fmt.Fprintf(&sb, "\nDeploys now regenerate %s from ssd.yaml.\n", manifest)
+	}
+	_, err := io.WriteString(w, sb.String())
+	return err
+}
+
+// adoptedServiceYAML renders cfg as an entry under ssd.yaml's services.
+func adoptedServiceYAML(cfg *config.Config) string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "  %s:\n", cfg.Name)
+	if cfg.Image != "" {
+		fmt.Fprintf(&sb, "    image: %s\n", cfg.Image)
+	}
+	if cfg.Context != "" {
+		fmt.Fprintf(&sb, "    context: %s\n", cfg.Context)
+	}
+	if cfg.Dockerfile != "" {
+		fmt.Fprintf(&sb, "    dockerfile: %s\n", cfg.Dockerfile)
+	}
+	if cfg.Port > 0 {
+		fmt.Fprintf(&sb, "    port: %d\n", cfg.Port)
+	}
+	if deps := cfg.DependsOn.Names(); len(deps) > 0 {
+		fmt.Fprintf(&sb, "    depends_on: [%s]\n", strings.Join(deps, ", "))
+	}
+	return sb.String()
+}
+
+func runDoctor(args []string) {
+	if wantsHelp(args) {
+		printDoctorHelp()
+		return
+	}
+	args, err := parsePositional(newFlagSet("doctor"), args, 1)
+	if err != nil {
+		fail("args", err)
+	}
+
+	report := doctor.CheckTools(exec.LookPath)
+	rootCfg, path, err := config.Resolve(globalConfigPath, globalEnvName)
+	if err != nil {
+		report = append(report, doctor.Result{Section: "Local", Name: "ssd.yaml", Status: provision.StatusFail,
+			Message: err.Error(), Hint: "run 'ssd init' to create one"})
+		finishDoctor(report)
+		return
+	}
+	report = append(report, doctor.Result{Section: "Local", Name: "ssd.yaml", Status: provision.StatusOK, Message: path})
+	applyProjectDir(rootCfg)
+
+	services := rootCfg.ListServices()
+	sort.Strings(services)
+	if len(args) > 0 {
+		if !slices.Contains(services, args[0]) {
+			reportFailure("config", fmt.Errorf("service %q not found", args[0]))
+			fmt.Printf("Available services: %s\n", strings.Join(services, ", "))
+			exit(1)
+		}
+		services = args[:1]
+	}
+
+	ctx := context.Background()
+	var servers []*config.Config
+	seen := make(map[string]bool)
+	for _, name := range services {
+		cfg, err := rootCfg.GetService(name)
+		if err != nil {
+			report = append(report, doctor.Result{Section: "Service " + name, Name: "config", Status: provision.StatusFail,
+				Message: err.Error(), Hint: "fix the service in ssd.yaml"})
+			continue
+		}
+		if checker, ok := runtime.New(rootCfg.Runtime, cfg).(doctor.SourceChecker); ok {
+			report = append(report, doctor.CheckService(ctx, cfg, checker, remote.GitRootFor(cfg))...)
+		}
+		for _, host := range cfg.Hosts() {
+			if !seen[host] {
+				seen[host] = true
+				servers = append(servers, cfg.OnServer(host))
+			}
+		}
+	}
+
+	fmt.Printf("Checking %d server(s)...\n\n", len(servers))
+	for _, cfg := range servers {
+		client, ok := runtime.New(rootCfg.Runtime, cfg).(doctor.ServerClient)
+		if !ok {
+			continue
+		}
+		rt := rootCfg.Runtime
+		if rt == "" {
+			rt = "compose"
+		}
+		report = append(report, doctor.CheckServer(ctx, client, cfg.Server, rt, cfg)...)
+	}
+	finishDoctor(report)
+}
+
+// finishDoctor prints the doctor report and fails when a check failed.
+func finishDoctor(report doctor.Report) {
+	if err := doctor.Write(os.Stdout, report); err != nil {
+		fail("run", err)
+	}
+	if report.Failed() {
+		_, _, failed := report.Counts()
+		failf("run", "%d check(s) failed", failed)
+	}
+}
+
+func runHistory(args []string) {
+	if wantsHelp(args) {
+		printHistoryHelp()
+		return
+	}
+	args, err := parsePositional(newFlagSet("history"), args, 1)
+	if err != nil {
+		fail("args", err)
+	}
+
+	rootCfg := loadRootConfig()
+
+	serviceName := ""
+	if len(args) > 0 {
+		serviceName = args[0]
+	}
+
+	// The history file is per stack; any service's config reaches it.
+	lookup := serviceName
+	if lookup == "" {
+		services := rootCfg.ListServices()
+		if len(services) == 0 {
+			failf("config", "no services defined in ssd.yaml")
+		}
+		sort.Strings(services)
+		lookup = services[0]
+	}
+	_, cfg := loadConfig(lookup)
+	client := runtime.New(rootCfg.Runtime, cfg)
+
+	entries, err := client.ReadHistory(context.Background())
+	if err != nil {
+		fail("run", err)
+	}
+	entries = filterHistory(entries, serviceName)
+	if len(entries) == 0 {
+		fmt.Println("No deploy history found")
+		return
+	}
+	if err := writeHistory(os.Stdout, entries); err != nil {
+		fail("run", err)
+	}
+}
+
+func runDiff(args []string) {
+	if wantsHelp(args) {
+		printDiffHelp()
+		return
+	}
+	args, err := parsePositional(newFlagSet("diff"), args, 1)
+	if err != nil {
+		fail("args", err)
+	}
+
+	rootCfg := loadRootConfig()
+	services := rootCfg.ListServices()
+	if len(services) == 0 {
+		failf("config", "no services defined in ssd.yaml")
+	}
+	sort.Strings(services)
+
+	allServices := make(map[string]*config.Config, len(services))
+	for _, name := range services {
+		svcCfg, err := rootCfg.GetService(name)
+		if err != nil {
+			failf("config", "loading service %s: %w", name, err)
+		}
+		allServices[name] = svcCfg
+	}
+
+	deploying := services
+	if len(args) > 0 {
+		if _, ok := allServices[args[0]]; !ok {
+			reportFailure("config", fmt.Errorf("service %q not found", args[0]))
+			fmt.Printf("Available services: %s\n", strings.Join(services, ", "))
+			exit(1)
+		}
+		deploying = []string{args[0]}
+	}
+
+	cfg := allServices[deploying[0]]
+	client := runtime.New(rootCfg.Runtime, cfg)
+	if err := writeDiff(context.Background(), os.Stdout, client, rootCfg.Runtime, allServices, cfg.StackPath(), deploying); err != nil {
+		fail("run", err)
+	}
+}
+
+func runPlan(args []string) {
+	if wantsHelp(args) {
+		printPlanHelp()
+		return
+	}
+	args, err := parsePositional(newFlagSet("plan"), args, 1)
+	if err != nil {
+		fail("args", err)
+	}
+
+	rootCfg := loadRootConfig()
+	services := rootCfg.ListServices()
+	if len(services) == 0 {
+		failf("config", "no services defined in ssd.yaml")
+	}
+	sort.Strings(services)
+
+	allServices := make(map[string]*config.Config, len(services))
+	for _, name := range services {
+		svcCfg, err := rootCfg.GetService(name)
+		if err != nil {
+			failf("config", "loading service %s: %w", name, err)
+		}
+		allServices[name] = svcCfg
+	}
+
+	planning := services
+	if len(args) > 0 {
+		if _, ok := allServices[args[0]]; !ok {
+			reportFailure("config", fmt.Errorf("service %q not found", args[0]))
+			fmt.Printf("Available services: %s\n", strings.Join(services, ", "))
+			exit(1)
+		}
+		planning = []string{args[0]}
+	}
+
+	newClient := func(cfg *config.Config) remote.RemoteClient {
+		return runtime.New(rootCfg.Runtime, cfg)
+	}
+	if err := writePlans(context.Background(), os.Stdout, rootCfg.Runtime, allServices, planning, newClient); err != nil {
+		fail("run", err)
+	}
+}
+
+// writePlans writes, as an indented JSON array, the deploy.Plan of each
+// named service as `ssd deploy <service>` would run it.
+func writePlans(ctx context.Context, w io.Writer, rt string, allServices map[string]*config.Config, planning []string, newClient func(*config.Config) remote.RemoteClient) error {
+	plans := make([]deploy.Plan, 0, len(planning))
+	for _, name := range planning {
+		cfg := allServices[name]
+		deps := make(map[string]*config.Config)
+		for _, dep := range cfg.DependsOn.Names() {
+			if depCfg, ok := allServices[dep]; ok {
+				deps[dep] = depCfg
+			}
+		}
+		client := newClient(cfg)
+		plan, err := deploy.PlanDeploy(ctx, cfg, client, &deploy.Options{
+			Dependencies: deps,
+			AllServices:  allServices,
+			Runtime:      rt,
+			TagCleaner:   tagCleanerFor(rt, client),
+			History:      client,
+			Scheduler:    client,
+			Sources:      client,
+		})
+		if err != nil {
+			return fmt.Errorf("planning %s: %w", name, err)
+		}
+		plans = append(plans, plan)
+	}
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	return enc.Encode(plans)
+}
+
+// writeDiff prints the manifest and env file diffs for deploying the
+// given services, or a note when nothing would change.
+func writeDiff(ctx context.Context, w io.Writer, client remote.RemoteClient, rt string, allServices map[string]*config.Config, stack string, deploying []string) error {
+	manifestDiff, err := deploy.DiffWithClient(ctx, client, rt, allServices, stack, deploying)
+	if err != nil {
+		return err
+	}
+	envDiff, err := deploy.DiffEnvFiles(ctx, client, allServices, deploying)
+	if err != nil {
+		return err
+	}
+	if manifestDiff == "" && envDiff == "" {
+		_, err = fmt.Fprintln(w, "No changes.")
+		return err
+	}
+	_, err = fmt.Fprint(w, manifestDiff+envDiff)
+	return err
+}
+
+// composeFlags captures the parsed state of `ssd compose` options.
+type composeFlags struct {
+	service string
+	output  string
+	remote  bool
+}
+
+// parseComposeFlags parses the argument list for `ssd compose`. At most
+// one positional service name is accepted; it selects which config
+// (server, stack) to render since the whole stack is rendered.
+func parseComposeFlags(args []string) (composeFlags, error) {
+	var f composeFlags
+	fs := newFlagSet("compose")
+	fs.StringVar(&f.output, "output", "", "write to this file instead of stdout")
+	fs.StringVar(&f.output, "o", "", "shorthand for --output")
+	fs.BoolVar(&f.remote, "remote", false, "keep the versions deployed on the server")
+	positional, err := parsePositional(fs, args, 1)
+	if err != nil {
+		return composeFlags{}, err
+	}
+	if len(positional) == 1 {
+		f.service = positional[0]
+	}
+	if strings.HasPrefix(f.output, "-") {
+		return composeFlags{}, fmt.Errorf("invalid --output %q", f.output)
+	}
+	return f, nil
+}
+
+func runCompose(args []string) {
+	if wantsHelp(args) {
+		printComposeHelp()
+		return
+	}
+
+	flags, err := parseComposeFlags(args)
+	if err != nil {
+		fail("args", err)
+	}
+
+	rootCfg := loadRootConfig()
+	services := rootCfg.ListServices()
+	if len(services) == 0 {
+		failf("config", "no services defined in ssd.yaml")
+	}
+	sort.Strings(services)
+
+	allServices := make(map[string]*config.Config, len(services))
+	for _, name := range services {
+		svcCfg, err := rootCfg.GetService(name)
+		if err != nil {
+			failf("config", "loading service %s: %w", name, err)
+		}
+		allServices[name] = svcCfg
+	}
+
+	selected := services[0]
+	if flags.service != "" {
+		if _, ok := allServices[flags.service]; !ok {
+			reportFailure("config", fmt.Errorf("service %q not found", flags.service))
+			fmt.Printf("Available services: %s\n", strings.Join(services, ", "))
+			exit(1)
+		}
+		selected = flags.service
+	}
+	cfg := allServices[selected]
+
+	// Only --remote needs the server; a nil reader renders offline.
+	var reader deploy.ManifestReader
+	if flags.remote {
+		reader = runtime.New(rootCfg.Runtime, cfg)
+	}
+	content, err := deploy.RenderManifest(context.Background(), reader, rootCfg.Runtime, allServices, cfg.StackPath())
+	if err != nil {
+		fail("run", err)
+	}
+	if err := writeCompose(os.Stdout, content, flags.output); err != nil {
+		fail("run", err)
+	}
+}
+
+// writeCompose prints the rendered manifest, or writes it atomically to
+// output when set.
+func writeCompose(w io.Writer, content, output string) error {
+	if output == "" {
+		_, err := fmt.Fprint(w, content)
+		return err
+	}
+	if err := compose.AtomicWrite(content, output); err != nil {
+		return fmt.Errorf("failed to write %s: %w", output, err)
+	}
+	_, err := fmt.Fprintf(w, "Wrote %s\n", output)
+	return err
+}
+
+// filterHistory keeps the entries for service, or all entries when
+// service is empty.
+func filterHistory(entries []history.Entry, service string) []history.Entry {
+	if service == "" {
+		return entries
+	}
+	var out []history.Entry
+	for _, e := range entries {
+		if e.Service == service {
+			out = append(out, e)
+		}
+	}
+	return out
+}
+
+// writeHistory prints history entries as a table, oldest first.
+func writeHistory(w io.Writer, entries []history.Entry) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	if _, err := fmt.Fprintln(tw, "TIME\tSERVICE\tVERSION\tUSER\tGIT SHA"); err != nil {
+		return err
+	}
+	for _, e := range entries {
+		if _, err := fmt.Fprintf(tw, "%s\t%s\t%d\t%s\t%s\n",
+			e.Time.Local().Format("2006-01-02 15:04:05"), e.Service, e.Version, e.User, e.GitSHA); err != nil {
+			return err
+		}
+	}
+	return tw.Flush()
+}
+
+func runPs(args []string) {
+	if wantsHelp(args) {
+		printPsHelp()
+		return
+	}
+	if _, err := parsePositional(newFlagSet("ps"), args, 0); err != nil {
+		fail("args", err)
+	}
+
+	rootCfg := loadRootConfig()
+	services := rootCfg.ListServices()
+	if len(services) == 0 {
+		failf("config", "no services defined in ssd.yaml")
+	}
+	// Any service's config reaches the server
+	sort.Strings(services)
+	_, cfg := loadConfig(services[0])
+	client := runtime.New(rootCfg.Runtime, cfg)
+
+	list, err := client.ListStacks(context.Background())
+	if err != nil {
+		fail("run", err)
+	}
+	if len(list) == 0 {
+		fmt.Printf("No ssd stacks found on %s\n", cfg.Server)
+		return
+	}
+	if err := stacks.Write(os.Stdout, list); err != nil {
+		fail("run", err)
+	}
+}
+
+func runStatus(args []string) {
+	if wantsHelp(args) {
+		printStatusHelp()
+		return
+	}
+	args, err := parsePositional(newFlagSet("status"), args, 1)
+	if err != nil {
+		fail("args", err)
+	}
+
+	var (
+		rootCfg *config.RootConfig
+		cfg     *config.Config
+		service string
+	)
+	if len(args) > 0 {
+		service = args[0]
+		rootCfg, cfg = loadConfig(service)
+		fmt.Printf("Status for %s on %s:\n\n", cfg.Name, cfg.Server)
+	} else {
+		// No service: the whole stack, reached through any service's config
+		rootCfg = loadRootConfig()
+		services := rootCfg.ListServices()
+		if len(services) == 0 {
+			failf("config", "no services defined in ssd.yaml")
+		}
+		sort.Strings(services)
+		_, cfg = loadConfig(services[0])
+		fmt.Printf("Status for stack %s on %s:\n\n", cfg.StackPath(), cfg.Server)
+	}
+	client := runtime.New(rootCfg.Runtime, cfg)
+
+	status, err := client.GetContainerStatus(context.Background(), service)
+	if err != nil {
+		fail("run", err)
+	}
+
+	if status == "" {
+		fmt.Println("No containers found")
+	} else {
+		fmt.Println(status)
+	}
+}
+
+// logsFlags captures the parsed state of `ssd logs` options.
+type logsFlags struct {
+	service string
+	opts    logs.Options
+	// sinceVersion is --since-version: resolved to opts.Since from the
+	// deploy history once the client is known.
+	sinceVersion int
+}
+
+// parseLogsFlags parses the argument list for `ssd logs`: an optional
+// service name, -f/--follow, --tail N|all (default defaultLogTail, or all
+// when the start is bounded by --since or --since-version), --since and
+// --since-version, and --grep.
+func parseLogsFlags(args []string) (logsFlags, error) {
+	f := logsFlags{opts: logs.Options{Tail: defaultLogTail}}
+	tailSet := false
+	fs := newFlagSet("logs")
+	fs.BoolVar(&f.opts.Follow, "follow", false, "stream new log lines")
+	fs.BoolVar(&f.opts.Follow, "f", false, "shorthand for --follow")
+	fs.Func("tail", "lines to show, or all", func(v string) error {
+		n, err := parseTail(v)
+		if err != nil {
+			return err
+		}
+		f.opts.Tail, tailSet = n, true
+		return nil
+	})
+	fs.Func("since", "duration or RFC 3339 timestamp", func(v string) error {
+		since, err := parseSince(v, time.Now())
+		if err != nil {
+			return err
+		}
+		f.opts.Since = since
+		return nil
+	})
+	fs.Func("grep", "only show lines matching this pattern", func(v string) error {
+		if v == "" {
+			return fmt.Errorf("must not be empty")
+		}
+		f.opts.Grep = v
+		return nil
+	})
+	fs.Func("since-version", "deployed version to start from", func(v string) error {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			return fmt.Errorf("must be a version number")
+		}
+		f.sinceVersion = n
+		return nil
+	})
+	positional, err := parsePositional(fs, args, 1)
+	if err != nil {
+		return logsFlags{}, err
+	}
+	if !f.opts.Since.IsZero() && f.sinceVersion > 0 {
+		return logsFlags{}, fmt.Errorf("--since and --since-version cannot be combined")
+	}
+	if !tailSet && (!f.opts.Since.IsZero() || f.sinceVersion > 0) {
+		f.opts.Tail = logs.TailAll
+	}
+	if len(positional) == 1 {
+		f.service = positional[0]
+	}
+	return f, nil
+}
+
+// parseSince parses a --since value: a duration back from now (30m, 2h)
+// or an RFC 3339 timestamp.
+func parseSince(value string, now time.Time) (time.Time, error) {
+	if d, err := time.ParseDuration(value); err == nil {
+		if d <= 0 {
+			return time.Time{}, fmt.Errorf("must be a positive duration or an RFC 3339 timestamp")
+		}
+		return now.Add(-d), nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("must be a duration like 30m or an RFC 3339 timestamp like 2026-03-01T09:00:00Z")
+	}
+	return t, nil
+}
+
+// sinceVersion sets opts.Since to when version of service went live,
+// according to the deploy history on the server.
+func sinceVersion(ctx context.Context, client remote.RemoteClient, service string, version int, opts logs.Options) (logs.Options, error) {
+	entries, err := client.ReadHistory(ctx)
+	if err != nil {
+		return opts, fmt.Errorf("failed to read deploy history: %w", err)
+	}
+	since, err := history.VersionTime(entries, service, version)
+	if err != nil {
+		return opts, err
 	}
+	opts.Since = since
+	return opts, nil
 }
 
-func runStatus(args []string) {
+func runLogs(args []string) {
 	if wantsHelp(args) {
-		printStatusHelp()
+		printLogsHelp()
 		return
 	}
 
-	serviceName := ""
-	if len(args) > 0 {
-		serviceName = args[0]
+	f, err := parseLogsFlags(args)
+	if err != nil {
+		fail("args", err)
 	}
 
-	rootCfg, cfg := loadConfig(serviceName)
+	rootCfg, cfg := loadConfig(f.service)
 	client := runtime.New(rootCfg.Runtime, cfg)
+	ctx := context.Background()
 
-	fmt.Printf("Status for %s on %s:\n\n", cfg.Name, cfg.Server)
-
-	status, err := client.GetContainerStatus(context.Background())
-	if err != nil {
-		fmt.Printf(errorFmt, err)
-		os.Exit(1)
+	opts := f.opts
+	if f.sinceVersion > 0 {
+		if opts, err = sinceVersion(ctx, client, cfg.Name, f.sinceVersion, opts); err != nil {
+			fail("run", err)
+		}
 	}
-
-	if status == "" {
-		fmt.Println("No containers found")
-	} else {
-		fmt.Println(status)
+	if err := client.GetLogs(ctx, opts); err != nil {
+		fail("run", err)
 	}
 }
 
-func runLogs(args []string) {
+func runOpen(args []string) {
 	if wantsHelp(args) {
-		printLogsHelp()
+		printOpenHelp()
 		return
 	}
-
+	args, err := parsePositional(newFlagSet("open"), args, 1)
+	if err != nil {
+		fail("args", err)
+	}
 	serviceName := ""
-	follow := false
-	tail := 100
+	if len(args) > 0 {
+		serviceName = args[0]
+	}
 
-	for _, arg := range args {
-		if arg == "-f" || arg == "--follow" {
-			follow = true
-		} else if !strings.HasPrefix(arg, "-") {
-			serviceName = arg
-		}
+	_, cfg := loadConfig(serviceName)
+	url, err := cfg.PublicURL()
+	if err != nil {
+		fail("config", err)
 	}
 
-	rootCfg, cfg := loadConfig(serviceName)
-	client := runtime.New(rootCfg.Runtime, cfg)
+	// Always print the URL: on a headless machine it is the only output
+	// that helps, and opening a browser is best effort.
+	fmt.Println(url)
+	if err := openBrowser(url); err != nil {
+		fmt.Printf("Could not open a browser (%v); open the URL above manually.\n", err)
+	}
+}
 
-	if err := client.GetLogs(context.Background(), follow, tail); err != nil {
-		fmt.Printf(errorFmt, err)
-		os.Exit(1)
+// openBrowser launches the platform's default browser on url without
+// waiting for it to exit.
+func openBrowser(url string) error {
+	var cmd *exec.Cmd
+	switch goruntime.GOOS {
+	case "darwin":
+		cmd = exec.Command("open", url)
+	case "windows":
+		cmd = exec.Command("rundll32", "url.dll,FileProtocolHandler", url)
+	default:
+		cmd = exec.Command("xdg-open", url)
 	}
+	return cmd.Start()
 }
 
 func runConfig(args []string) {
@@ -667,6 +2854,10 @@ func runConfig(args []string) {
 		printConfigHelp()
 		return
 	}
+	args, err := parsePositional(newFlagSet("config"), args, 1)
+	if err != nil {
+		fail("args", err)
+	}
 
 	serviceName := ""
 	if len(args) > 0 {
@@ -688,8 +2879,7 @@ func runConfig(args []string) {
 
 	cfg, err := rootCfg.GetService(serviceName)
 	if err != nil {
-		fmt.Printf(errorFmt, err)
-		os.Exit(1)
+		fail("config", err)
 	}
 
 	fmt.Println("Configuration:")
@@ -700,7 +2890,8 @@ func runEnv(args []string) {
 	if wantsHelp(args) || len(args) < 2 {
 		printEnvHelp()
 		if !wantsHelp(args) && len(args) < 2 {
-			os.Exit(1)
+			reportFailure("args", fmt.Errorf("usage: ssd env <service> <set|list|rm|edit> [...]"))
+			exit(1)
 		}
 		return
 	}
@@ -714,40 +2905,38 @@ func runEnv(args []string) {
 		runEnvList(service, args[2:])
 	case "rm":
 		runEnvRm(service, args[2:])
+	case "edit":
+		runEnvEdit(service, args[2:])
 	default:
-		fmt.Printf("Unknown action: %s\n", action)
-		fmt.Println("Usage: ssd env <service> <set|list|rm> [...]")
-		os.Exit(1)
+		reportFailure("args", fmt.Errorf("unknown action: %s", action))
+		fmt.Println("Usage: ssd env <service> <set|list|rm|edit> [...]")
+		exit(1)
 	}
 }
 
 func runEnvSet(service string, args []string) {
 	if len(args) == 0 {
-		fmt.Println("Usage: ssd env <service> set KEY=VALUE")
-		os.Exit(1)
+		failUsage("ssd env <service> set KEY=VALUE")
 	}
 
 	arg := args[0]
 	parts := strings.SplitN(arg, "=", 2)
 	if len(parts) != 2 {
-		fmt.Printf("Error: Invalid format. Expected KEY=VALUE, got: %s\n", arg)
-		os.Exit(1)
+		failf("args", "invalid format, expected KEY=VALUE, got: %s", arg)
 	}
 
 	key := parts[0]
 	value := parts[1]
 
 	if key == "" {
-		fmt.Println("Error: KEY cannot be empty")
-		os.Exit(1)
+		failf("args", "KEY cannot be empty")
 	}
 
 	rootCfg, cfg := loadConfig(service)
 	client := runtime.New(rootCfg.Runtime, cfg)
 
-	if err := client.SetEnvVar(context.Background(), service, key, value); err != nil {
-		fmt.Printf(errorFmt, err)
-		os.Exit(1)
+	if err := deploy.SetEnvWithClient(cfg, client, service, key, value, &deploy.Options{Runtime: rootCfg.Runtime}); err != nil {
+		fail("run", err)
 	}
 
 	fmt.Printf("Set %s=%s for service %s\n", key, value, service)
@@ -759,8 +2948,7 @@ func runEnvList(service string, args []string) {
 
 	content, err := client.GetEnvFile(context.Background(), service)
 	if err != nil {
-		fmt.Printf(errorFmt, err)
-		os.Exit(1)
+		fail("run", err)
 	}
 
 	if content == "" || strings.TrimSpace(content) == "" {
@@ -773,8 +2961,7 @@ func runEnvList(service string, args []string) {
 
 func runEnvRm(service string, args []string) {
 	if len(args) == 0 {
-		fmt.Println("Usage: ssd env <service> rm KEY")
-		os.Exit(1)
+		failUsage("ssd env <service> rm KEY")
 	}
 
 	key := args[0]
@@ -782,14 +2969,88 @@ func runEnvRm(service string, args []string) {
 	rootCfg, cfg := loadConfig(service)
 	client := runtime.New(rootCfg.Runtime, cfg)
 
-	if err := client.RemoveEnvVar(context.Background(), service, key); err != nil {
-		fmt.Printf(errorFmt, err)
-		os.Exit(1)
+	if err := deploy.RemoveEnvWithClient(cfg, client, service, key, &deploy.Options{Runtime: rootCfg.Runtime}); err != nil {
+		fail("run", err)
 	}
 
 	fmt.Printf("Removed %s from service %s\n", key, service)
 }
 
+func runEnvEdit(service string, args []string) {
+	if len(args) > 0 {
+		failUsage("ssd env <service> edit")
+	}
+	if err := stdinPromptError("edits in an editor", "use ssd env <service> set or rm instead"); err != nil {
+		fail("args", err)
+	}
+
+	rootCfg, cfg := loadConfig(service)
+	client := runtime.New(rootCfg.Runtime, cfg)
+
+	original, err := client.GetEnvFile(context.Background(), service)
+	if err != nil {
+		fail("run", err)
+	}
+	edited, path, err := editInEditor(service+"-*.env", original)
+	if err != nil {
+		fail("run", err)
+	}
+
+	changed, err := deploy.EditEnvWithClient(cfg, client, service, original, edited, &deploy.Options{Runtime: rootCfg.Runtime})
+	if err != nil {
+		fail("run", fmt.Errorf("%w\nYour edits are kept in %s", err, path))
+	}
+	os.Remove(path)
+	if !changed {
+		fmt.Printf("No changes to %s.env\n", service)
+		return
+	}
+	fmt.Printf("Updated %s.env for service %s\n", service, service)
+}
+
+// editInEditor writes content to a private temp file named after pattern,
+// opens it in $VISUAL or $EDITOR (vi when neither is set) and returns
+// what was saved, along with the file's path so the caller can remove it
+// or point the user at it. The editor command may carry arguments
+// ("code --wait").
+func editInEditor(pattern, content string) (string, string, error) {
+	editor := os.Getenv("VISUAL")
+	if editor == "" {
+		editor = os.Getenv("EDITOR")
+	}
+	if editor == "" {
+		editor = "vi"
+	}
+
+	f, err := os.CreateTemp("", "ssd-"+pattern)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to create temp file: %w", err)
+	}
+	path := f.Name()
+	_, err = f.WriteString(content)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(path)
+		return "", "", fmt.Errorf("failed to write temp file: %w", err)
+	}
+
+	fields := strings.Fields(editor)
+	cmd := exec.Command(fields[0], append(fields[1:], path)...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	if err := cmd.Run(); err != nil {
+		os.Remove(path)
+		return "", "", fmt.Errorf("editor %s failed: %w", editor, err)
+	}
+
+	edited, err := os.ReadFile(path)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to read edited file: %w", err)
+	}
+	return string(edited), path, nil
+}
+
 func detectOrphans(rootCfg *config.RootConfig, allServices map[string]*config.Config, client remote.RemoteClient) {
 	configServices := make(map[string]bool, len(allServices))
 	for name := range allServices {
@@ -868,15 +3129,17 @@ func runScale(args []string) {
 		printScaleHelp()
 		return
 	}
+	args, err := parsePositional(newFlagSet("scale"), args, 2)
+	if err != nil {
+		fail("args", err)
+	}
 	if len(args) < 2 {
-		fmt.Println("Usage: ssd scale <service> <count>")
-		os.Exit(1)
+		failUsage("ssd scale <service> <count>")
 	}
 	serviceName := args[0]
 	count, err := strconv.Atoi(args[1])
 	if err != nil || count < 0 {
-		fmt.Printf("Error: invalid replica count %q (must be a non-negative integer)\n", args[1])
-		os.Exit(1)
+		failf("args", "invalid replica count %q (must be a non-negative integer)", args[1])
 	}
 
 	rootCfg, cfg := loadConfig(serviceName)
@@ -886,8 +3149,7 @@ func runScale(args []string) {
 	cmd := scaleCommand(rootCfg.Runtime, cfg, count)
 
 	if _, err := client.SSH(ctx, cmd); err != nil {
-		fmt.Printf(errorFmt, err)
-		os.Exit(1)
+		fail("run", err)
 	}
 	fmt.Printf("Scaled %s to %d replica(s)\n", cfg.Name, count)
 }
@@ -900,6 +3162,7 @@ type pruneFlags struct {
 	buildCache bool
 	dangling   bool
 	dryRun     bool
+	yes        bool
 	keep       *int // override per-service retention when set
 }
 
@@ -909,47 +3172,53 @@ type pruneFlags struct {
 // --keep requires a non-negative integer.
 func parsePruneFlags(args []string) (pruneFlags, error) {
 	var f pruneFlags
-	anySelector := false
-	for i := 0; i < len(args); i++ {
-		switch args[i] {
-		case "--dry-run":
-			f.dryRun = true
-		case "--images":
-			f.images = true
-			anySelector = true
-		case "--build-cache":
-			f.buildCache = true
-			anySelector = true
-		case "--dangling":
-			f.dangling = true
-			anySelector = true
-		case "--all":
-			f.orphans = true
-			f.images = true
-			f.buildCache = true
-			f.dangling = true
-			anySelector = true
-		case "--keep":
-			if i+1 >= len(args) {
-				return pruneFlags{}, fmt.Errorf("--keep requires a value")
-			}
-			n, err := strconv.Atoi(args[i+1])
-			if err != nil || n < 0 {
-				return pruneFlags{}, fmt.Errorf("--keep must be a non-negative integer, got %q", args[i+1])
-			}
-			f.keep = &n
-			i++
-		default:
-			return pruneFlags{}, fmt.Errorf("unknown flag: %s", args[i])
+	var all bool
+	fs := newFlagSet("prune")
+	fs.BoolVar(&f.dryRun, "dry-run", false, "preview without removing anything")
+	yesFlag(fs, &f.yes)
+	fs.BoolVar(&f.images, "images", false, "remove old image tags")
+	fs.BoolVar(&f.buildCache, "build-cache", false, "prune old build cache")
+	fs.BoolVar(&f.dangling, "dangling", false, "remove dangling images")
+	fs.BoolVar(&all, "all", false, "orphans, images, build cache and dangling")
+	fs.Func("keep", "override per-service retention", func(v string) error {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return fmt.Errorf("--keep must be a non-negative integer, got %q", v)
 		}
+		f.keep = &n
+		return nil
+	})
+	if _, err := parsePositional(fs, args, 0); err != nil {
+		return pruneFlags{}, err
+	}
+	if all {
+		f.orphans, f.images, f.buildCache, f.dangling = true, true, true, true
 	}
 	// No selector flags means "default": orphan services only.
-	if !anySelector {
+	if !all && !f.images && !f.buildCache && !f.dangling {
 		f.orphans = true
 	}
 	return f, nil
 }
 
+// pruneAction describes the selected prune steps for the confirmation prompt.
+func pruneAction(f pruneFlags) string {
+	var steps []string
+	if f.orphans {
+		steps = append(steps, "remove services not in ssd.yaml")
+	}
+	if f.images {
+		steps = append(steps, "remove old image tags")
+	}
+	if f.buildCache {
+		steps = append(steps, "prune build cache")
+	}
+	if f.dangling {
+		steps = append(steps, "remove dangling images")
+	}
+	return strings.Join(steps, ", ")
+}
+
 func runPrune(args []string) {
 	if wantsHelp(args) {
 		printPruneHelp()
@@ -958,23 +3227,24 @@ func runPrune(args []string) {
 
 	flags, err := parsePruneFlags(args)
 	if err != nil {
-		fmt.Printf(errorFmt, err)
-		os.Exit(1)
+		fail("args", err)
 	}
 
 	rootCfg := loadRootConfig()
 
 	services := rootCfg.ListServices()
 	if len(services) == 0 {
-		fmt.Println("Error: no services defined in ssd.yaml")
-		os.Exit(1)
+		failf("config", "no services defined in ssd.yaml")
 	}
 
 	// Get first service config for server connection
 	cfg, err := rootCfg.GetService(services[0])
 	if err != nil {
-		fmt.Printf(errorFmt, err)
-		os.Exit(1)
+		fail("config", err)
+	}
+
+	if !flags.dryRun && !confirmOrAbort(flags.yes, confirmPrompt{service: "all services", server: cfg.Server, action: pruneAction(flags)}) {
+		return
 	}
 
 	client := runtime.New(rootCfg.Runtime, cfg)
@@ -1101,7 +3371,7 @@ func pruneImages(ctx context.Context, rootCfg *config.RootConfig, services []str
 		}
 
 		svcClient := runtime.New(rootCfg.Runtime, cfg)
-		cleaner := cleanup.NewCleaner(rootCfg.Runtime, svcClient)
+		cleaner := cleanup.NewCleaner(rootCfg.Runtime, svcClient, cfg.BuiltImageName())
 		tags, err := cleaner.ListTags(ctx, cfg.ImageName())
 		if err != nil {
 			fmt.Printf("  Warning: %s: list tags failed: %v\n", name, err)
@@ -1166,7 +3436,8 @@ func runSecret(args []string) {
 	if wantsHelp(args) || len(args) < 2 {
 		printSecretHelp()
 		if !wantsHelp(args) && len(args) < 2 {
-			os.Exit(1)
+			reportFailure("args", fmt.Errorf("usage: ssd secret <service> <set|list|rm> [...]"))
+			exit(1)
 		}
 		return
 	}
@@ -1177,14 +3448,12 @@ func runSecret(args []string) {
 	rootCfg := loadRootConfig()
 
 	if rootCfg.Runtime != "k3s" {
-		fmt.Println("Error: secrets require runtime: k3s. Use \"ssd env\" for compose runtime.")
-		os.Exit(1)
+		failf("config", "secrets require runtime: k3s. Use \"ssd env\" for compose runtime.")
 	}
 
 	cfg, err := rootCfg.GetService(service)
 	if err != nil {
-		fmt.Printf(errorFmt, err)
-		os.Exit(1)
+		fail("config", err)
 	}
 
 	client := k3s.NewClient(cfg)
@@ -1192,24 +3461,20 @@ func runSecret(args []string) {
 	switch action {
 	case "set":
 		if len(args) < 3 {
-			fmt.Println("Usage: ssd secret <service> set KEY=VALUE")
-			os.Exit(1)
+			failUsage("ssd secret <service> set KEY=VALUE")
 		}
 		parts := strings.SplitN(args[2], "=", 2)
 		if len(parts) != 2 || parts[0] == "" {
-			fmt.Printf("Error: Invalid format. Expected KEY=VALUE, got: %s\n", args[2])
-			os.Exit(1)
+			failf("args", "invalid format, expected KEY=VALUE, got: %s", args[2])
 		}
 		if err := client.SetSecret(context.Background(), service, parts[0], parts[1]); err != nil {
-			fmt.Printf(errorFmt, err)
-			os.Exit(1)
+			fail("run", err)
 		}
 		fmt.Printf("Set secret %s for service %s\n", parts[0], service)
 	case "list":
 		output, err := client.ListSecrets(context.Background(), service)
 		if err != nil {
-			fmt.Printf(errorFmt, err)
-			os.Exit(1)
+			fail("run", err)
 		}
 		if output == "" || strings.TrimSpace(output) == "" {
 			fmt.Println("No secrets set")
@@ -1218,18 +3483,16 @@ func runSecret(args []string) {
 		fmt.Print(output)
 	case "rm":
 		if len(args) < 3 {
-			fmt.Println("Usage: ssd secret <service> rm KEY")
-			os.Exit(1)
+			failUsage("ssd secret <service> rm KEY")
 		}
 		if err := client.RemoveSecret(context.Background(), service, args[2]); err != nil {
-			fmt.Printf(errorFmt, err)
-			os.Exit(1)
+			fail("run", err)
 		}
 		fmt.Printf("Removed secret %s from service %s\n", args[2], service)
 	default:
-		fmt.Printf("Unknown action: %s\n", action)
+		reportFailure("args", fmt.Errorf("unknown action: %s", action))
 		fmt.Println("Usage: ssd secret <service> <set|list|rm> [...]")
-		os.Exit(1)
+		exit(1)
 	}
 }
 
@@ -1253,29 +3516,26 @@ func runProvision(args []string) {
 		switch args[i] {
 		case "--server":
 			if i+1 >= len(args) {
-				fmt.Println("Error: --server requires a value")
-				os.Exit(1)
+				failf("args", "--server requires a value")
 			}
 			server = args[i+1]
 			i += 2
 		case "--email":
 			if i+1 >= len(args) {
-				fmt.Println("Error: --email requires a value")
-				os.Exit(1)
+				failf("args", "--email requires a value")
 			}
 			email = args[i+1]
 			i += 2
 		case "--runtime":
 			if i+1 >= len(args) {
-				fmt.Println("Error: --runtime requires a value")
-				os.Exit(1)
+				failf("args", "--runtime requires a value")
 			}
 			rt = args[i+1]
 			i += 2
 		default:
-			fmt.Printf("Error: Unknown flag: %s\n", args[i])
+			reportFailure("args", fmt.Errorf("unknown flag: %s", args[i]))
 			fmt.Println("Usage: ssd provision [--server SERVER] [--email EMAIL] [--runtime RUNTIME]")
-			os.Exit(1)
+			exit(1)
 		}
 	}
 
@@ -1296,24 +3556,25 @@ func runProvision(args []string) {
 	}
 
 	if server == "" {
-		fmt.Println("Error: server not specified and not found in config")
+		reportFailure("config", fmt.Errorf("server not specified and not found in config"))
 		fmt.Println("Usage: ssd provision --server SERVER [--email EMAIL]")
-		os.Exit(1)
+		exit(1)
 	}
 
 	// If no email flag, prompt user
 	if email == "" {
+		if err := stdinPromptError("the Let's Encrypt email", "pass --email"); err != nil {
+			fail("args", err)
+		}
 		fmt.Print("Enter email for Let's Encrypt: ")
 		reader := bufio.NewReader(os.Stdin)
 		input, err := reader.ReadString('\n')
 		if err != nil {
-			fmt.Printf("Error reading email: %v\n", err)
-			os.Exit(1)
+			failf("args", "reading email: %w", err)
 		}
 		email = strings.TrimSpace(input)
 		if email == "" {
-			fmt.Println("Error: email cannot be empty")
-			os.Exit(1)
+			failf("args", "email cannot be empty")
 		}
 	}
 
@@ -1327,11 +3588,10 @@ func runProvision(args []string) {
 		provErr = provision.Provision(server, email)
 	}
 	if provErr != nil {
-		fmt.Printf("\nError: %v\n", provErr)
-		os.Exit(1)
+		fail("run", provErr)
 	}
 
-	fmt.Println("\nProvisioning completed successfully!")
+	fmt.Println(color.Line(os.Stdout, "\nProvisioning completed successfully!"))
 }
 
 func runProvisionCheck(args []string) {
@@ -1347,22 +3607,20 @@ func runProvisionCheck(args []string) {
 		switch args[i] {
 		case "--server":
 			if i+1 >= len(args) {
-				fmt.Println("Error: --server requires a value")
-				os.Exit(1)
+				failf("args", "--server requires a value")
 			}
 			server = args[i+1]
 			i += 2
 		case "--runtime":
 			if i+1 >= len(args) {
-				fmt.Println("Error: --runtime requires a value")
-				os.Exit(1)
+				failf("args", "--runtime requires a value")
 			}
 			rt = args[i+1]
 			i += 2
 		default:
-			fmt.Printf("Error: Unknown flag: %s\n", args[i])
+			reportFailure("args", fmt.Errorf("unknown flag: %s", args[i]))
 			fmt.Println("Usage: ssd provision check [--server SERVER] [--runtime RUNTIME]")
-			os.Exit(1)
+			exit(1)
 		}
 	}
 
@@ -1382,9 +3640,9 @@ func runProvisionCheck(args []string) {
 	}
 
 	if server == "" {
-		fmt.Println("Error: server not specified and not found in config")
+		reportFailure("config", fmt.Errorf("server not specified and not found in config"))
 		fmt.Println("Usage: ssd provision check [--server SERVER]")
-		os.Exit(1)
+		exit(1)
 	}
 
 	fmt.Printf("Checking server %s (runtime: %s)...\n\n", server, rt)
@@ -1398,8 +3656,7 @@ func runProvisionCheck(args []string) {
 		results, err = provision.Check(server)
 	}
 	if err != nil {
-		fmt.Printf(errorFmt, err)
-		os.Exit(1)
+		fail("run", err)
 	}
 
 	hasFail := false
@@ -1421,8 +3678,7 @@ func runProvisionCheck(args []string) {
 
 	fmt.Println()
 	if hasFail {
-		fmt.Println("Server is not ready. Run 'ssd provision' to set up missing components.")
-		os.Exit(1)
+		fail("run", fmt.Errorf("server is not ready; run 'ssd provision' to set up missing components"))
 	}
 	if hasWarn {
 		fmt.Println("Server is ready for ssd deployments.")
@@ -1435,13 +3691,11 @@ func runProvisionCheck(args []string) {
 func skillDir() string {
 	exe, err := os.Executable()
 	if err != nil {
-		fmt.Printf("Error: cannot resolve executable path: %v\n", err)
-		os.Exit(1)
+		failf("run", "cannot resolve executable path: %w", err)
 	}
 	exe, err = filepath.EvalSymlinks(exe)
 	if err != nil {
-		fmt.Printf("Error: cannot resolve symlinks: %v\n", err)
-		os.Exit(1)
+		failf("run", "cannot resolve symlinks: %w", err)
 	}
 	return filepath.Join(filepath.Dir(exe), "..", "share", "ssd", "skill")
 }
@@ -1459,14 +3713,12 @@ func runSkill(args []string) {
 		switch args[i] {
 		case "--path":
 			if i+1 >= len(args) {
-				fmt.Println("Error: --path requires a value")
-				os.Exit(1)
+				failf("args", "--path requires a value")
 			}
 			targetDir = args[i+1]
 			i += 2
 		default:
-			fmt.Printf("Error: unknown flag: %s\n", args[i])
-			os.Exit(1)
+			failf("args", "unknown flag: %s", args[i])
 		}
 	}
 
@@ -1474,9 +3726,9 @@ func runSkill(args []string) {
 
 	// Verify skill dir exists
 	if _, err := os.Stat(filepath.Join(src, "SKILL.md")); err != nil {
-		fmt.Printf("Error: skill directory not found at %s\n", src)
+		reportFailure("run", fmt.Errorf("skill directory not found at %s", src))
 		fmt.Println("This may happen if ssd was not installed via brew.")
-		os.Exit(1)
+		exit(1)
 	}
 
 	if targetDir == "" {
@@ -1494,8 +3746,7 @@ func runSkill(args []string) {
 		case "", "1":
 			home, err := os.UserHomeDir()
 			if err != nil {
-				fmt.Printf(errorFmt, err)
-				os.Exit(1)
+				fail("run", err)
 			}
 			targetDir = filepath.Join(home, ".claude", "skills", "ssd")
 		case "2":
@@ -1503,12 +3754,10 @@ func runSkill(args []string) {
 			input, _ = reader.ReadString('\n')
 			targetDir = strings.TrimSpace(input)
 			if targetDir == "" {
-				fmt.Println("Error: path cannot be empty")
-				os.Exit(1)
+				failf("args", "path cannot be empty")
 			}
 		default:
-			fmt.Println("Error: invalid choice")
-			os.Exit(1)
+			failf("args", "invalid choice")
 		}
 	}
 
@@ -1516,24 +3765,20 @@ func runSkill(args []string) {
 	if info, err := os.Lstat(targetDir); err == nil {
 		if info.Mode()&os.ModeSymlink != 0 {
 			if err := os.Remove(targetDir); err != nil {
-				fmt.Printf("Error: failed to remove existing symlink: %v\n", err)
-				os.Exit(1)
+				failf("run", "failed to remove existing symlink: %w", err)
 			}
 		} else {
-			fmt.Printf("Error: %s already exists and is not a symlink\n", targetDir)
-			os.Exit(1)
+			failf("run", "%s already exists and is not a symlink", targetDir)
 		}
 	}
 
 	// Ensure parent directory exists
 	if err := os.MkdirAll(filepath.Dir(targetDir), 0755); err != nil {
-		fmt.Printf(errorFmt, err)
-		os.Exit(1)
+		fail("run", err)
 	}
 
 	if err := os.Symlink(src, targetDir); err != nil {
-		fmt.Printf(errorFmt, err)
-		os.Exit(1)
+		fail("run", err)
 	}
 
 	fmt.Printf("Linked %s -> %s\n", targetDir, src)
@@ -1553,55 +3798,47 @@ func runInit(args []string) {
 		switch args[i] {
 		case "-s", "--server":
 			if i+1 >= len(args) {
-				fmt.Println("Error: --server requires a value")
-				os.Exit(1)
+				failf("args", "--server requires a value")
 			}
 			opts.Server = args[i+1]
 			i += 2
 		case "--stack":
 			if i+1 >= len(args) {
-				fmt.Println("Error: --stack requires a value")
-				os.Exit(1)
+				failf("args", "--stack requires a value")
 			}
 			opts.Stack = args[i+1]
 			i += 2
 		case "--service":
 			if i+1 >= len(args) {
-				fmt.Println("Error: --service requires a value")
-				os.Exit(1)
+				failf("args", "--service requires a value")
 			}
 			opts.Service = args[i+1]
 			i += 2
 		case "-d", "--domain":
 			if i+1 >= len(args) {
-				fmt.Println("Error: --domain requires a value")
-				os.Exit(1)
+				failf("args", "--domain requires a value")
 			}
 			opts.Domain = args[i+1]
 			i += 2
 		case "--path":
 			if i+1 >= len(args) {
-				fmt.Println("Error: --path requires a value")
-				os.Exit(1)
+				failf("args", "--path requires a value")
 			}
 			opts.Path = args[i+1]
 			i += 2
 		case "-p", "--port":
 			if i+1 >= len(args) {
-				fmt.Println("Error: --port requires a value")
-				os.Exit(1)
+				failf("args", "--port requires a value")
 			}
 			port, err := strconv.Atoi(args[i+1])
 			if err != nil {
-				fmt.Printf("Error: invalid port: %s\n", args[i+1])
-				os.Exit(1)
+				failf("args", "invalid port: %s", args[i+1])
 			}
 			opts.Port = port
 			i += 2
 		case "-r", "--runtime":
 			if i+1 >= len(args) {
-				fmt.Println("Error: --runtime requires a value")
-				os.Exit(1)
+				failf("args", "--runtime requires a value")
 			}
 			opts.Runtime = args[i+1]
 			i += 2
@@ -1609,9 +3846,9 @@ func runInit(args []string) {
 			opts.Force = true
 			i++
 		default:
-			fmt.Printf("Error: Unknown flag: %s\n", args[i])
+			reportFailure("args", fmt.Errorf("unknown flag: %s", args[i]))
 			printInitHelp()
-			os.Exit(1)
+			exit(1)
 		}
 	}
 
@@ -1651,8 +3888,7 @@ func runInit(args []string) {
 		if portStr != "" {
 			port, err := strconv.Atoi(portStr)
 			if err != nil {
-				fmt.Printf("Error: invalid port: %s\n", portStr)
-				os.Exit(1)
+				failf("args", "invalid port: %s", portStr)
 			}
 			opts.Port = port
 		}
@@ -1660,22 +3896,19 @@ func runInit(args []string) {
 
 	// Validate
 	if err := scaffold.Validate(opts); err != nil {
-		fmt.Printf(errorFmt, err)
-		os.Exit(1)
+		fail("args", err)
 	}
 
 	// Get current directory
 	dir, err := os.Getwd()
 	if err != nil {
-		fmt.Printf(errorFmt, err)
-		os.Exit(1)
+		fail("run", err)
 	}
 
 	// Write file
 	target := scaffold.TargetPath(dir)
 	if err := scaffold.WriteFile(dir, opts); err != nil {
-		fmt.Printf(errorFmt, err)
-		os.Exit(1)
+		fail("run", err)
 	}
 
 	rel, err := filepath.Rel(dir, target)
@@ -1718,21 +3951,19 @@ func runMigrate(args []string) {
 		return
 	}
 	if len(args) > 0 {
-		fmt.Printf("Error: unexpected argument: %s\n", args[0])
+		reportFailure("args", fmt.Errorf("unexpected argument: %s", args[0]))
 		printMigrateHelp()
-		os.Exit(1)
+		exit(1)
 	}
 
 	dir, err := os.Getwd()
 	if err != nil {
-		fmt.Printf(errorFmt, err)
-		os.Exit(1)
+		fail("run", err)
 	}
 
 	target, err := scaffold.MigrateLegacy(dir)
 	if err != nil {
-		fmt.Printf(errorFmt, err)
-		os.Exit(1)
+		fail("run", err)
 	}
 
 	rel, err := filepath.Rel(dir, target)
@@ -1807,6 +4038,7 @@ func printConfig(cfg *config.Config, indent string) {
 	fmt.Printf("%sserver: %s\n", indent, cfg.Server)
 	fmt.Printf("%sstack: %s\n", indent, cfg.Stack)
 	fmt.Printf("%sstack_path: %s\n", indent, cfg.StackPath())
+	fmt.Printf("%sproject: %s\n", indent, cfg.ProjectName())
 	if cfg.Domain != "" {
 		fmt.Printf("%sdomain: %s\n", indent, cfg.Domain)
 	}
@@ -1825,6 +4057,18 @@ func printConfig(cfg *config.Config, indent string) {
 	}
 	fmt.Printf("%sdockerfile: %s\n", indent, cfg.Dockerfile)
 	fmt.Printf("%scontext: %s\n", indent, cfg.Context)
+	if cfg.Platform != "" {
+		fmt.Printf("%splatform: %s\n", indent, cfg.Platform)
+	}
+	if cfg.UseBuildKit() {
+		fmt.Printf("%sbuildkit: true\n", indent)
+	}
+	if len(cfg.Profiles) > 0 {
+		fmt.Printf("%sprofiles: %s\n", indent, strings.Join(cfg.Profiles, ", "))
+	}
+	if cfg.PreStart != nil {
+		fmt.Printf("%spre_start: %s\n", indent, cfg.PreStart.Command)
+	}
 	if cfg.Image == "" {
 		fmt.Printf("%simage: %s\n", indent, cfg.ImageName())
 	}
@@ -1837,6 +4081,59 @@ func printConfig(cfg *config.Config, indent string) {
 }
 
 // wantsHelp returns true if args contain -h, --help, or help.
+// completionSpec lists the commands shell completion offers. Keep in sync
+// with the dispatch switch in main.
+var completionSpec = completion.Spec{
+	Commands: []string{
+		"init", "migrate", "deploy", "up", "down", "rm", "stop", "start",
+		"restart", "rollback", "adopt", "doctor", "history", "diff", "plan", "compose", "status", "ps",
+		"logs", "open", "config", "env", "secret", "prune", "scale", "provision",
+		"skill", "completion", "version", "help",
+	},
+	ServiceCommands: []string{
+		"deploy", "up", "down", "rm", "stop", "start", "restart", "rollback",
+		"adopt", "doctor", "history", "diff", "plan", "compose", "status", "logs", "open", "config", "env",
+		"secret", "scale",
+	},
+}
+
+func runCompletion(args []string) {
+	if wantsHelp(args) {
+		printCompletionHelp()
+		return
+	}
+	args, err := parsePositional(newFlagSet("completion"), args, 1)
+	if err != nil {
+		fail("args", err)
+	}
+	if len(args) == 0 {
+		failUsage("ssd completion <" + strings.Join(completion.Shells, "|") + ">")
+	}
+	if err := completion.Write(os.Stdout, args[0], completionSpec); err != nil {
+		fail("args", err)
+	}
+}
+
+// runCompleteServices prints the service names from ssd.yaml, one per
+// line, for the completion scripts. Errors print nothing: a shell
+// completing outside an ssd project should simply offer no services.
+func runCompleteServices() {
+	rootCfg, _, err := config.Resolve(globalConfigPath, globalEnvName)
+	if err != nil {
+		return
+	}
+	fmt.Print(serviceNames(rootCfg))
+}
+
+// serviceNames returns rootCfg's service names sorted, one per line.
+func serviceNames(rootCfg *config.RootConfig) string {
+	var b strings.Builder
+	for _, name := range slices.Sorted(slices.Values(rootCfg.ListServices())) {
+		b.WriteString(name + "\n")
+	}
+	return b.String()
+}
+
 func wantsHelp(args []string) bool {
 	for _, a := range args {
 		if a == "-h" || a == "--help" || a == "help" {
@@ -1860,28 +4157,52 @@ Usage:
 
 Global flags (accepted on every command):
       --config PATH               Path to ssd config file (default: .ssd/ssd.yaml,
-                                  falls back to ./ssd.yaml for legacy projects)
+                                  falls back to ./ssd.yaml for legacy projects;
+                                  $SSD_CONFIG is used when the flag is absent;
+                                  "-" reads the YAML from stdin)
+      --log-file PATH             Also write streamed build/deploy output
+                                  (docker build, rsync, rollouts) to PATH
+      --project-dir PATH          Git repository root to archive builds from,
+                                  instead of the one found from each context
+                                  (overrides project_dir; must contain .git)
+      --no-color                  Plain output even on a terminal (color is
+                                  also off when piped or NO_COLOR is set)
   -e, --env NAME                  Apply env overlay .ssd/ssd.<NAME>.yaml on top
                                   of the base config (deep-merge)
+      --output text|json          json: print one result object on stdout
+                                  ({command, service, ok, error, stage});
+                                  human-readable output moves to stderr
+                                  (not for compose, whose --output is a file)
 
 Commands:
   init                            Create ssd.yaml configuration file
   migrate                         Move legacy ./ssd.yaml into .ssd/ssd.yaml
   deploy|up [service]             Build and deploy a service (or all services)
-  down [service]                  Stop services (or all if omitted)
+  down [service]                  Tear down the stack (--volumes, --yes)
   rm [service]                    Permanently remove services (or entire stack)
-  restart [service]               Restart without rebuilding
+  stop <service>                  Stop a service, keeping its container
+  start <service>                 Start a stopped service
+  restart [service]               Restart without rebuilding (--rolling: one at a time)
   rollback [service]              Rollback to the previous version
+  adopt [service]                 Bring an existing compose.yaml under ssd management
+  doctor [service]                Check the local setup and the server, with fixes
+  history [service]               Show deploy history (who, what, when)
+  diff [service]                  Show what a deploy would change on the server
+  plan [service]                  Print the steps a deploy would take, as JSON
+  compose [service] [-o FILE]     Print or save the compose.yaml ssd would generate
   status [service]                Show container status
+  ps                              List every ssd stack on the server
   logs [service] [-f]             View service logs
+  open [service]                  Open the service's URL in the browser
   config [service]                Show resolved configuration
-  env <service> <set|list|rm>     Manage environment variables on the server
+  env <service> <action>          Manage env vars on the server (set|list|rm|edit)
   secret <service> <set|list|rm>  Manage K8s secrets (k3s runtime only)
   prune [flags]                   Reclaim disk: orphans, images, build cache, dangling
   scale <service> <count>         Live-scale a service (does not edit ssd.yaml)
   provision                       Provision server with Docker and Traefik
   provision check                 Verify server readiness for ssd
   skill                           Install ssd skill for your coding agent
+  completion <bash|zsh|fish>      Print a shell completion script
   version                         Show ssd version
   help                            Show this help
 
@@ -1900,26 +4221,174 @@ Usage:
   ssd deploy                      Deploy all services defined in ssd.yaml
   ssd deploy <service>            Deploy a single service
 
+Flags:
+  --only <a,b>           Deploy-all only: deploy just these services, plus
+                         the services they depend on (repeatable).
+  --exclude <a,b>        Deploy-all only: skip these services, even when an
+                         --only service depends on them (repeatable).
+  --continue-on-error    Deploy-all: keep going after a service fails.
+                         Services depending on a failed one are skipped.
+                         With 'servers:', keep deploying to the remaining
+                         servers after one fails.
+                         Exits non-zero if anything failed.
+  --whole-stack          Deploy-all only: after building every image, start
+                         the stack with one 'docker compose up -d' (K3s:
+                         apply all manifests) instead of per-service
+                         starts. Faster and more atomic; deploy strategies
+                         are not applied.
+  --prefix-output        Deploy-all only: tag every line of build and
+                         rollout output with the service name ("[web] ").
+  --force-recreate=false Compose: start services with plain 'docker compose
+                         up -d', so containers whose image and config are
+                         unchanged keep running. Default true (always
+                         recreate). Not used by the rollout strategy.
+  --force                Deploy even when deploy.skip_unchanged finds the
+                         source unchanged since the last deploy.
+  --strict               Fail instead of warning when the build context has
+                         uncommitted or untracked changes (only committed
+                         files are deployed).
+  --pull                 Pull the Dockerfile's base images before building
+                         (build --pull), so a moved tag like node:22 is
+                         refreshed. Same as build.pull for this run.
+                         Pre-built images are always pulled.
+  --quiet-build          Build with --quiet and capture the build output
+                         instead of streaming it; it is shown only when
+                         the build fails.
+  --keep-build-dir       Leave the build directory on the server after the
+                         deploy (even a failed one) and print its path,
+                         to inspect what was synced. Remove it by hand.
+  --adopt                Replace an existing compose.yaml that ssd did not
+                         write (no x-ssd block). Without it such a deploy
+                         is refused, so a hand-written file isn't lost.
+  --no-deps              Don't check or start the service's depends_on
+                         services, for dependencies managed out-of-band.
+                         Names one service.
+  --recreate-deps        Start the service's depends_on services even when
+                         they are running (pre-built ones are pulled
+                         first), so a changed env or image takes effect.
+                         Names one service.
+  --on-missing-dep <policy>
+                         What to do about depends_on services: start-only
+                         (default) starts them by name, warning about one
+                         ssd.yaml does not define; fail refuses the deploy
+                         up front when one is undefined; build does the
+                         same and also deploys (builds) each defined one
+                         that isn't running first. Names one service.
+  --since <ref>          Deploy-all only: deploy just the services whose
+                         context or Dockerfile has files changed in 'git
+                         diff --name-only <ref>...HEAD', plus the services
+                         that depend on them. Nothing changed: nothing
+                         deployed. Edits to ssd.yaml alone are not seen,
+                         and pre-built image: services only deploy as
+                         dependents; list those by name or drop --since.
+  --skip-build           Restart the current version without syncing,
+                         building or pulling; compose.yaml is still
+                         regenerated from ssd.yaml, so config changes
+                         (env, labels, ports) take effect. Fails for a
+                         service that has never been deployed.
+  --build-arg KEY=VALUE  Pass a build arg to this deploy's image builds
+                         (repeatable). Overrides the same key in
+                         build_args; always rebuilds, even with
+                         deploy.skip_unchanged.
+  --image <ref>          Deploy an externally built image for this run:
+                         no sync, no build, no version bump. compose.yaml
+                         points the service at <ref> and it restarts. The
+                         next deploy without --image builds again.
+  --stack <path>         Deploy into this absolute stack directory instead
+                         of the configured one (e.g. a staging copy). The
+                         project, image and network names derived from
+                         the stack follow it, unless project is set.
+  --context-override <path>
+                         Build this directory instead of the service's
+                         context for this run (e.g. a generated artifacts
+                         directory). Names one built service. Must be in a
+                         git repository: only committed files are shipped.
+  --force-version <n>    Deploy version <n> instead of current+1, e.g. to
+                         rebuild a version whose image was pruned. Names
+                         one service. Overwrites an existing image with
+                         that tag; later deploys continue from <n>+1.
+  --service-env-file <service>=<path>
+                         Seed a service's .env from a local dotenv file
+                         when this deploy creates the stack (repeatable).
+                         Ignored once the stack exists.
+  --profile <name>       Enable a compose profile (repeatable). Services
+                         with 'profiles:' are only deployed when one of
+                         their profiles is selected; services without
+                         profiles always are.
+  --lock-timeout <d>     How long to wait for another deploy's lock
+                         (default 5m). On timeout the error names the
+                         lock path and its holder.
+  --wait                 After starting, wait for each service to become
+                         healthy and fail the deploy if it doesn't, even
+                         when deploy.health_gate is off (no automatic
+                         rollback then).
+  --detach               Return as soon as each service is started, with
+                         no health gate.
+
 Workflow:
   1. Reads ssd.yaml from the current directory
   2. SSHs into the configured server
-  3. Rsyncs source code to a temp directory on the server (skipped for pre-built images)
+  3. Ships the committed source (git archive HEAD) to a temp directory on the
+     server (skipped for pre-built images); uncommitted changes in the
+     context are listed as a warning since they are not included
   4. Builds the Docker image on the server (or pulls if 'image' is set)
   5. Generates compose.yaml in the stack directory
-  6. Starts the service using the configured deploy strategy
-  7. Cleans up the temp directory
+  6. Runs the service's pre_start job, if any, and waits for it to exit 0
+  7. Starts the service using the configured deploy strategy (skipped for
+     'restart: false' services)
+  8. Installs the systemd timer for 'schedule', or removes a stale one
+  9. Cleans up the temp directory
+  10. Prints a summary: version transition, strategy, elapsed time
+      (deploy-all prints a table covering every service, failed ones included)
 
 Deploy strategies (set via deploy.strategy in ssd.yaml):
   rollout   (default) Zero-downtime. Scales up new container, health-checks, removes old.
   recreate  In-place replacement via docker compose up --force-recreate. Brief downtime.
+  none      Same as recreate; never uses docker rollout.
+
+Health gate (deploy.health_gate: true):
+  Waits up to deploy.health_timeout for the service to become healthy. If it
+  doesn't, rolls back to the previous version and fails. The default is
+  retries * (interval + timeout) + start_period + 30s from the healthcheck
+  (capped at 10m), or 60s without one.
+
+Multiple servers (servers: [web1, web2] on a service):
+  'ssd deploy <service>' deploys to each server in turn, syncing and
+  building on each, and prints a summary row per server. The first failure
+  stops the run unless --continue-on-error is given. Other commands (logs,
+  status, restart, ...) use the first server. Deploy-all does not fan out:
+  deploy such services by name.
+
+Skip unchanged (deploy.skip_unchanged: true):
+  Compares the git tree of the build context at HEAD with the tree recorded
+  on the server (.ssd-sha-<service>) by the last deploy. If they match,
+  nothing is built, bumped or restarted. --force deploys anyway.
 
 Examples:
   # Deploy a single service
   ssd deploy web
 
-  # Deploy all services (builds all images first, then starts)
+  # Deploy all services (builds all images first, four at a time, then starts)
   ssd deploy
 
+  # Deploy all services, not stopping at the first failure
+  ssd deploy --continue-on-error
+
+  # Build everything, then bring the stack up in one step
+  ssd deploy --whole-stack
+
+  # Tag build output with the service it belongs to
+  ssd deploy --prefix-output
+
+  # Only recreate containers whose image or config changed
+  ssd deploy --force-recreate=false
+
+  # First deploy: start web with secrets from a local file
+  ssd deploy --service-env-file web=.env.production
+
+  # Also deploy services in the 'debug' profile
+  ssd deploy --profile debug
+
   # ssd.yaml for building from source
   server: myserver
   services:
@@ -1953,20 +4422,29 @@ Examples:
 }
 
 func printDownHelp() {
-	fmt.Print(`ssd down - Stop running services
+	fmt.Print(`ssd down - Tear down the stack
 
 Usage:
-  ssd down                        Stop all services
-  ssd down <service>              Stop a single service
+  ssd down [service] [flags]
 
-Compose: runs 'docker compose stop'.
-K3s: scales deployments to 0 replicas.
+Stops and removes every container in the stack, like 'docker compose down'.
+For multi-service configs the whole stack goes down; the service name only
+selects which server and stack to use.
 
-The services can be started again with 'ssd up'.
+Compose: runs 'docker compose down' in the stack directory.
+K3s: deletes the stack's deployments, services, ingresses and configmaps.
+
+Named volumes (K3s: PersistentVolumeClaims) are kept unless --volumes is given.
+Bring the stack back with 'ssd deploy'.
+
+Flags:
+  -v, --volumes    Also remove volumes (destroys data)
+  -y, --yes        Skip the confirmation prompt
 
 Examples:
-  ssd down web
   ssd down
+  ssd down web --yes
+  ssd down --volumes
 `)
 }
 
@@ -1992,6 +4470,43 @@ Examples:
 `)
 }
 
+func printStopHelp() {
+	fmt.Print(`ssd stop - Stop a single service
+
+Usage:
+  ssd stop <service>
+
+Compose: runs 'docker compose stop <service>'. The container is kept.
+K3s: scales the deployment to 0 replicas.
+
+Other services in the stack keep running. Use 'ssd start' to bring the
+service back, or 'ssd down' to tear down the whole stack.
+
+Examples:
+  ssd stop worker
+`)
+}
+
+func printStartHelp() {
+	fmt.Print(`ssd start - Start a stopped service
+
+Usage:
+  ssd start <service> [flags]
+
+Starts the service at its currently deployed version. Does not rebuild.
+
+Compose: runs 'docker compose up -d --force-recreate <service>'.
+K3s: re-applies the service's manifests and restarts the deployment.
+
+Flags:
+  --profile <name>    Enable a compose profile (repeatable)
+
+Examples:
+  ssd start worker
+  ssd start seed --profile setup
+`)
+}
+
 func printRestartHelp() {
 	fmt.Print(`ssd restart - Restart services without rebuilding
 
@@ -2002,9 +4517,18 @@ Usage:
 Runs 'docker compose restart' on the server. Does not rebuild images
 or update configuration. Use 'ssd deploy' to apply changes.
 
+Flags:
+  --lock-timeout <d>    How long to wait for the stack lock (default 5m)
+  --rolling             Restart services one at a time in name order:
+                        recreate each and wait for it to become healthy
+                        (up to its health_gate timeout) before the next.
+                        Stops at the first service that doesn't come
+                        back healthy. Skips restart: false services.
+
 Examples:
   ssd restart web
   ssd restart
+  ssd restart --rolling
 `)
 }
 
@@ -2015,11 +4539,142 @@ Usage:
   ssd rollback <service>          Rollback a service to its previous image version
 
 Reads the current image tag from compose.yaml on the server, decrements the
-version number, updates compose.yaml, and restarts the service.
+version number, updates compose.yaml, and restarts the service. Asks for
+confirmation when run in a terminal.
+
+Flags:
+  -y, --yes             Skip the confirmation prompt
+  --lock-timeout <d>    How long to wait for the stack lock (default 5m)
 
 Examples:
   ssd rollback web
-  ssd rollback api
+  ssd rollback api --yes
+`)
+}
+
+func printAdoptHelp() {
+	fmt.Print(`ssd adopt - Bring an existing compose.yaml under ssd management
+
+Usage:
+  ssd adopt                       Adopt the compose file in the stack directory
+  ssd adopt <service>             Reach the stack through this service's config
+
+Deploys refuse to overwrite a compose file ssd did not write. adopt reads
+the one on the server, matches its services against ssd.yaml and adds
+the x-ssd block that marks it as ssd's. Running containers are left
+alone; the next deploy regenerates the file from ssd.yaml.
+
+It reports, per service:
+  - the version of services already running an ssd-built image; the
+    next deploy builds the one after
+  - services running another image, kept until they are next deployed
+  - ssd.yaml services the file doesn't have yet
+  - services missing from ssd.yaml, with an entry to add for each
+    (the next deploy drops them from the compose file otherwise)
+
+Compose runtime only.
+
+Flags:
+  --lock-timeout <d>    How long to wait for the stack lock (default 5m)
+
+Examples:
+  ssd adopt
+  ssd adopt web
+`)
+}
+
+func printDoctorHelp() {
+	fmt.Print(`ssd doctor - Check the local setup and the server
+
+Usage:
+  ssd doctor                      Check every service and its server
+  ssd doctor <service>            Check a single service and its server
+
+Runs read-only checks and prints OK, WARN or FAIL for each, with a hint
+on how to fix what isn't OK:
+
+  Local      ssh and git installed, ssd.yaml loads
+  Service    each built service's context is in a git repository, its
+             Dockerfile exists, uncommitted changes (WARN: not deployed)
+  Server     SSH reaches it, the 'ssd provision check' checks for the
+             runtime, free disk space (WARN under 2GB), the stack directory
+
+Exits non-zero when any check fails. Warnings don't fail.
+
+Examples:
+  ssd doctor
+  ssd doctor web
+`)
+}
+
+func printHistoryHelp() {
+	fmt.Print(`ssd history - Show who deployed what and when
+
+Usage:
+  ssd history                     Show deploys of every service in the stack
+  ssd history <service>           Show deploys of a single service
+
+Every successful deploy appends a line to .ssd-history in the stack
+directory on the server:
+
+  timestamp,service,version,local-user,git-sha
+
+The file keeps the newest 1000 entries.
+
+Examples:
+  ssd history
+  ssd history web
+`)
+}
+
+func printDiffHelp() {
+	fmt.Print(`ssd diff - Show what a deploy would change on the server
+
+Usage:
+  ssd diff                        Diff as if deploying every service
+  ssd diff <service>              Diff as if deploying a single service
+
+Prints a unified diff between compose.yaml (K3s: manifests.yaml) on the
+server and the one ssd would generate. Services being deployed get their
+next version; the rest keep what the server has.
+
+Services with env_file also get their env file diffed against
+{service}.env on the server. Values are shown as short hashes, so a
+changed hash means a changed value without printing secrets.
+
+Read-only: takes no lock and changes nothing.
+
+Examples:
+  ssd diff
+  ssd diff web
+`)
+}
+
+func printPlanHelp() {
+	fmt.Print(`ssd plan - Print the steps a deploy would take
+
+Usage:
+  ssd plan                        Plan a deploy of each service
+  ssd plan <service>              Plan a deploy of a single service
+
+Prints a JSON array with one plan per service: the server, stack, version
+transition and strategy, and the ordered steps 'ssd deploy <service>'
+would run (creating the stack and networks on a first deploy, starting
+stopped dependencies, pulling or syncing and building, updating the
+manifest, starting, the health gate, scheduling, tag cleanup, history).
+
+Read-only: only reads the stack, current version and dependency state
+from the server. Takes no lock and changes nothing.
+
+Each step has an "action", and optionally a "target" and "detail":
+  create-env-files, seed-env-files, create-stack, ensure-network,
+  copy-files, skip-unchanged, start-dependency, pull, pin-digest, sync,
+  build, update-manifest, upload-env-file, pre-start, start, health-gate,
+  wait-healthy, schedule, prune-tags, record-history
+
+Examples:
+  ssd plan web
+  ssd plan | jq '.[].steps[].action'
 `)
 }
 
@@ -2030,8 +4685,9 @@ Usage:
   ssd status                      Show status for all containers in the stack
   ssd status <service>            Show status for a specific service
 
-Runs 'docker compose ps' on the server and displays container state,
-health, ports, and uptime.
+Runs 'docker compose ps' on the server (K3s: kubectl get pods) and
+displays container state and uptime. With a service, only that service's
+containers are listed.
 
 Examples:
   ssd status web
@@ -2039,20 +4695,116 @@ Examples:
 `)
 }
 
+func printComposeHelp() {
+	fmt.Print(`ssd compose - Print the compose.yaml ssd would generate
+
+Usage:
+  ssd compose [service] [flags]
+
+Renders compose.yaml (K3s: manifests.yaml) for every service in ssd.yaml
+without deploying. A service name only selects the server and stack when
+services live in different stacks.
+
+By default nothing is read from the server and built services are
+rendered at version 1. With --remote, built services keep the versions
+(and pinned digests) currently deployed.
+
+Flags:
+  -o, --output FILE               Write to FILE (atomically) instead of stdout
+      --remote                    Read current versions from the server
+
+Examples:
+  ssd compose
+  ssd compose -o compose.yaml
+  ssd compose web --remote
+`)
+}
+
+func printCompletionHelp() {
+	fmt.Print(`ssd completion - Print a shell completion script
+
+Usage:
+  ssd completion <bash|zsh|fish>
+
+Completes subcommands, and service names from ssd.yaml in the current
+directory for commands that take one (deploy, logs, restart, ...).
+Service names are looked up each time you press tab, so new services
+show up without regenerating the script.
+
+Examples:
+  source <(ssd completion bash)         # add to ~/.bashrc
+  source <(ssd completion zsh)          # add to ~/.zshrc
+  ssd completion fish | source          # add to ~/.config/fish/config.fish
+  ssd completion fish > ~/.config/fish/completions/ssd.fish
+`)
+}
+
+func printOpenHelp() {
+	fmt.Print(`ssd open - Open a service's public URL in the browser
+
+Usage:
+  ssd open [service]
+
+Builds https://<domain><path> from ssd.yaml (http:// when https: false;
+redirect_to wins for multi-domain services), prints it and opens it in
+the default browser. The URL is printed even when no browser can be
+launched, e.g. over SSH. Fails if the service has no domain or uses
+router: tcp.
+
+Examples:
+  ssd open
+  ssd open api
+`)
+}
+
+func printPsHelp() {
+	fmt.Print(`ssd ps - List every ssd stack on the server
+
+Usage:
+  ssd ps
+
+Connects to the server from ssd.yaml and lists all ssd-managed stacks,
+not just this project's, with their status and each service's state.
+
+Compose: runs 'docker compose ls --all' and keeps projects with an
+ssd-built image (ssd-*) or a .ssd-history file in their directory.
+K3s: lists Deployments labelled managed-by=ssd, one stack per namespace.
+
+Examples:
+  ssd ps
+`)
+}
+
 func printLogsHelp() {
 	fmt.Print(`ssd logs - View service logs
 
 Usage:
-  ssd logs [service] [-f]
+  ssd logs [service] [-f] [--tail N|all] [--since D|TIME | --since-version N]
+           [--grep PATTERN]
 
 Flags:
   -f, --follow                    Stream logs in real time (like tail -f)
+  --tail N                        Show the last N lines (default 100, or all
+                                  with --since/--since-version); "all" shows everything
+  --since D|TIME                  Only lines from the last D (30m, 2h) or since
+                                  an RFC 3339 time (2026-03-01T09:00:00Z)
+  --since-version N               Only lines since version N was last deployed,
+                                  looked up in the deploy history
+  --grep PATTERN                  Only lines matching PATTERN (a grep regular
+                                  expression), filtered on the server;
+                                  --tail counts lines before filtering
 
 Shows the last 100 lines of logs by default. Use -f to follow.
 
 Examples:
   ssd logs web                    Show recent logs for web
   ssd logs web -f                 Follow logs for web in real time
+  ssd logs web --tail 500         Show the last 500 lines for web
+  ssd logs web --tail 0 -f        Only stream new lines
+  ssd logs web --tail all         Show the whole log
+  ssd logs web --since 30m        Show the last 30 minutes
+  ssd logs web --since-version 42 Show everything since version 42 went live
+  ssd logs web -f --grep ERROR    Follow only the lines containing ERROR
   ssd logs                        Show recent logs for all services
 `)
 }
@@ -2080,6 +4832,7 @@ Usage:
   ssd env <service> set KEY=VALUE Set or update an environment variable
   ssd env <service> list          List all environment variables
   ssd env <service> rm KEY        Remove an environment variable
+  ssd env <service> edit          Edit the whole env file in $EDITOR
 
 Environment variables are stored in {service}.env files on the server
 inside the stack directory (e.g., /stacks/myapp/web.env). These files
@@ -2104,6 +4857,12 @@ Examples:
   # Remove a variable
   ssd env api rm OLD_SECRET
 
+  # Edit every variable at once ($VISUAL, then $EDITOR, then vi). The
+  # result must be KEY=VALUE lines, comments or blank lines, each key
+  # once; nothing is written when the file is unchanged, or when it
+  # changed on the server in the meantime.
+  ssd env api edit
+
   # Variables are available inside containers via env_file in compose.yaml
   # No restart needed after set/rm - run 'ssd restart <service>' to apply
 
@@ -2147,9 +4906,11 @@ Usage:
   ssd prune --all                 All of the above (orphans + images + build-cache + dangling)
   ssd prune --keep N              Override per-service retention for --images/--all
   ssd prune --dry-run             Preview candidates without removing
+  ssd prune --yes                 Skip the confirmation prompt
   ssd prune --images --dry-run    Combine flags freely
 
 With no flags, prunes orphans only (preserves historical behavior).
+Asks for confirmation when run in a terminal (not for --dry-run).
 
 Retention (for --images):
   Default is 2 (current + rollback target) per service.
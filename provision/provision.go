@@ -347,7 +347,19 @@ func checkK3sWithClient(ctx context.Context, client RemoteClient, server string)
 	if client == nil {
 		client = remote.NewSSHClient(server)
 	}
+	return k3sChecks(ctx, client), nil
+}
 
+// CheckWithClient runs the readiness checks for runtime ("compose" or
+// "k3s") through an existing client.
+func CheckWithClient(ctx context.Context, client RemoteClient, runtime string) []CheckResult {
+	if runtime == "k3s" {
+		return k3sChecks(ctx, client)
+	}
+	return composeChecks(ctx, client)
+}
+
+func k3sChecks(ctx context.Context, client RemoteClient) []CheckResult {
 	results := make([]CheckResult, 0, 6)
 
 	results = append(results, checkK3sRunning(ctx, client))
@@ -357,7 +369,7 @@ func checkK3sWithClient(ctx context.Context, client RemoteClient, server string)
 	results = append(results, checkTraefikIngress(ctx, client))
 	results = append(results, checkTraefikACMEConfig(ctx, client))
 
-	return results, nil
+	return results
 }
 
 func checkK3sRunning(ctx context.Context, client RemoteClient) CheckResult {
@@ -443,7 +455,10 @@ func checkWithClient(ctx context.Context, client RemoteClient, server string) ([
 	if client == nil {
 		client = remote.NewSSHClient(server)
 	}
+	return composeChecks(ctx, client), nil
+}
 
+func composeChecks(ctx context.Context, client RemoteClient) []CheckResult {
 	results := make([]CheckResult, 0, 5)
 
 	results = append(results, checkDocker(ctx, client))
@@ -452,7 +467,7 @@ func checkWithClient(ctx context.Context, client RemoteClient, server string) ([
 	results = append(results, checkTraefikNetwork(ctx, client))
 	results = append(results, checkTraefikRunning(ctx, client))
 
-	return results, nil
+	return results
 }
 
 func checkDocker(ctx context.Context, client RemoteClient) CheckResult {
@@ -17,8 +17,20 @@ type CommandExecutor interface {
 	RunInteractive(ctx context.Context, name string, args ...string) error
 }
 
+// CapturedRunner is implemented by executors that can capture the output
+// of a command allowed to run as long as RunInteractive (30 minutes),
+// such as a quiet build. Clients fall back to Run for executors without
+// it.
+type CapturedRunner interface {
+	RunCaptured(ctx context.Context, name string, args ...string) (string, error)
+}
+
 // RealExecutor implements CommandExecutor using real exec.Command
-type RealExecutor struct{}
+type RealExecutor struct {
+	// Prefix, when set, is put in front of every line RunInteractive
+	// streams to the terminal.
+	Prefix string
+}
 
 // NewRealExecutor creates a new RealExecutor
 func NewRealExecutor() *RealExecutor {
@@ -27,7 +39,17 @@ func NewRealExecutor() *RealExecutor {
 
 // Run executes a command with a 5 minute timeout and returns the output
 func (e *RealExecutor) Run(ctx context.Context, name string, args ...string) (string, error) {
-	ctx, cancel := context.WithTimeout(ctx, 5*time.Minute)
+	return e.run(ctx, 5*time.Minute, name, args...)
+}
+
+// RunCaptured executes a command like Run, with a 30 minute timeout
+func (e *RealExecutor) RunCaptured(ctx context.Context, name string, args ...string) (string, error) {
+	return e.run(ctx, 30*time.Minute, name, args...)
+}
+
+// run executes a command with the given timeout and returns its stdout
+func (e *RealExecutor) run(ctx context.Context, timeout time.Duration, name string, args ...string) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
 	defer cancel()
 
 	cmd := exec.CommandContext(ctx, name, args...)
@@ -47,7 +69,12 @@ func (e *RealExecutor) RunInteractive(ctx context.Context, name string, args ...
 
 	cmd := exec.CommandContext(ctx, name, args...)
 	cmd.Stdin = os.Stdin
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	return cmd.Run()
+	stdout, stderr, flush := interactiveStreams(e.Prefix)
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	err := cmd.Run()
+	if flushErr := flush(); err == nil {
+		err = flushErr
+	}
+	return err
 }
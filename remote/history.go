@@ -0,0 +1,83 @@
+package remote
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"al.essio.dev/pkg/shellescape"
+	"github.com/byteink/ssd/history"
+)
+
+// localUser returns the name of the user running ssd.
+func localUser() string {
+	if u, err := user.Current(); err == nil && u.Username != "" {
+		return u.Username
+	}
+	return os.Getenv("USER")
+}
+
+// gitSHA returns the HEAD commit of the repository containing the build
+// context, located the same way Rsync finds what to archive.
+func (c *Client) gitSHA(ctx context.Context) (string, error) {
+	localContext, err := filepath.Abs(c.cfg.Context)
+	if err != nil {
+		return "", err
+	}
+	gitRoot, err := c.findGitRoot(localContext)
+	if err != nil {
+		return "", err
+	}
+	out, err := c.executor.Run(ctx, "git", "-C", gitRoot, "rev-parse", "HEAD")
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(out), nil
+}
+
+// AppendHistory records a successful deploy in the stack's history file.
+// The file is append-only except for rotation: once it exceeds
+// history.MaxLines only the newest history.MaxLines lines are kept.
+// A missing git repository is not an error; the SHA is recorded as "-".
+func (c *Client) AppendHistory(ctx context.Context, serviceName string, version int) error {
+	sha, err := c.gitSHA(ctx)
+	if err != nil {
+		sha = ""
+	}
+	entry := history.Entry{
+		Time:    time.Now(),
+		Service: serviceName,
+		Version: version,
+		User:    localUser(),
+		GitSHA:  sha,
+	}
+	return c.appendHistoryEntry(ctx, entry)
+}
+
+func (c *Client) appendHistoryEntry(ctx context.Context, entry history.Entry) error {
+	path := shellescape.Quote(filepath.Join(c.cfg.StackPath(), history.File))
+	tmp := shellescape.Quote(filepath.Join(c.cfg.StackPath(), history.File+".tmp"))
+	cmd := fmt.Sprintf("printf '%%s\\n' %s >> %s && if [ \"$(wc -l < %s)\" -gt %d ]; then tail -n %d %s > %s && mv %s %s; fi",
+		shellescape.Quote(entry.String()), path,
+		path, history.MaxLines,
+		history.MaxLines, path, tmp, tmp, path)
+	if _, err := c.SSH(ctx, cmd); err != nil {
+		return fmt.Errorf("failed to append deploy history: %w", err)
+	}
+	return nil
+}
+
+// ReadHistory returns every entry in the stack's history file, oldest
+// first. A missing file yields no entries.
+func (c *Client) ReadHistory(ctx context.Context) ([]history.Entry, error) {
+	path := filepath.Join(c.cfg.StackPath(), history.File)
+	out, err := c.SSH(ctx, fmt.Sprintf("cat %s 2>/dev/null || true", shellescape.Quote(path)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read deploy history: %w", err)
+	}
+	return history.Parse(out)
+}
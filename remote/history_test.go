@@ -0,0 +1,95 @@
+package remote
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/byteink/ssd/history"
+	"github.com/byteink/ssd/internal/testhelpers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClient_AppendHistoryEntry_Command(t *testing.T) {
+	cfg := newTestConfig()
+	mockExec := new(testhelpers.MockExecutor)
+	client := NewClientWithExecutor(cfg, mockExec)
+
+	entry := history.Entry{
+		Time:    time.Date(2026, 3, 4, 5, 6, 7, 0, time.UTC),
+		Service: "myapp",
+		Version: 4,
+		User:    "alice",
+		GitSHA:  "abc123",
+	}
+	want := "printf '%s\\n' 2026-03-04T05:06:07Z,myapp,4,alice,abc123 >> /stacks/myapp/.ssd-history" +
+		" && if [ \"$(wc -l < /stacks/myapp/.ssd-history)\" -gt 1000 ];" +
+		" then tail -n 1000 /stacks/myapp/.ssd-history > /stacks/myapp/.ssd-history.tmp" +
+		" && mv /stacks/myapp/.ssd-history.tmp /stacks/myapp/.ssd-history; fi"
+	mockExec.On("Run", "ssh", []string{"testserver", want}).Return("", nil)
+
+	require.NoError(t, client.appendHistoryEntry(context.Background(), entry))
+	mockExec.AssertExpectations(t)
+}
+
+func TestClient_AppendHistory_UsesGitSHA(t *testing.T) {
+	cfg := newTestConfig()
+	mockExec := new(testhelpers.MockExecutor)
+	client := NewClientWithExecutor(cfg, mockExec)
+	client.findGitRoot = func(string) (string, error) { return "/repo", nil }
+
+	mockExec.On("Run", "git", []string{"-C", "/repo", "rev-parse", "HEAD"}).Return("deadbeef\n", nil)
+	mockExec.On("Run", "ssh", mock.MatchedBy(func(args []string) bool {
+		return strings.Contains(args[len(args)-1], ",myapp,7,") &&
+			strings.Contains(args[len(args)-1], ",deadbeef >> /stacks/myapp/.ssd-history")
+	})).Return("", nil)
+
+	require.NoError(t, client.AppendHistory(context.Background(), "myapp", 7))
+	mockExec.AssertExpectations(t)
+}
+
+func TestClient_AppendHistory_NoGitRepo(t *testing.T) {
+	cfg := newTestConfig()
+	mockExec := new(testhelpers.MockExecutor)
+	client := NewClientWithExecutor(cfg, mockExec)
+	client.findGitRoot = func(string) (string, error) { return "", errors.New("not a git repository") }
+
+	mockExec.On("Run", "ssh", mock.MatchedBy(func(args []string) bool {
+		return strings.Contains(args[len(args)-1], ",myapp,1,") &&
+			strings.Contains(args[len(args)-1], ",- >> ")
+	})).Return("", nil)
+
+	require.NoError(t, client.AppendHistory(context.Background(), "myapp", 1))
+	mockExec.AssertExpectations(t)
+}
+
+func TestClient_ReadHistory(t *testing.T) {
+	cfg := newTestConfig()
+	mockExec := new(testhelpers.MockExecutor)
+	client := NewClientWithExecutor(cfg, mockExec)
+
+	mockExec.On("Run", "ssh", []string{"testserver", "cat /stacks/myapp/.ssd-history 2>/dev/null || true"}).
+		Return("2026-01-01T00:00:00Z,web,1,alice,aaa\n2026-01-02T00:00:00Z,web,2,bob,bbb\n", nil)
+
+	entries, err := client.ReadHistory(context.Background())
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+	assert.Equal(t, 2, entries[1].Version)
+	assert.Equal(t, "bob", entries[1].User)
+}
+
+func TestClient_ReadHistory_Missing(t *testing.T) {
+	cfg := newTestConfig()
+	mockExec := new(testhelpers.MockExecutor)
+	client := NewClientWithExecutor(cfg, mockExec)
+
+	mockExec.On("Run", "ssh", mock.Anything).Return("", nil)
+
+	entries, err := client.ReadHistory(context.Background())
+	require.NoError(t, err)
+	assert.Empty(t, entries)
+}
@@ -0,0 +1,201 @@
+package remote
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/byteink/ssd/config"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// pinnedHostAlias is the name a pinned host_key is recorded under in its
+// known_hosts file. ssh is told to look the server up under this alias
+// (HostKeyAlias), so the pin holds whatever host name or port reaches it.
+const pinnedHostAlias = "ssd-pinned-host"
+
+// hostKeyArgs returns the ssh options that apply cfg's host key policy.
+// StrictHostKeyChecking is always set (yes unless strict_host_key_checking
+// says otherwise). With host_key, ssh checks only knownHostsFile, which
+// writePinnedKnownHosts fills with that one key.
+func hostKeyArgs(cfg *config.Config, knownHostsFile string) []string {
+	args := []string{"-o", "StrictHostKeyChecking=" + cfg.HostKeyCheckingMode()}
+	if cfg.HostKey != "" {
+		args = append(args,
+			"-o", "HostKeyAlias="+pinnedHostAlias,
+			"-o", "UserKnownHostsFile="+knownHostsFile,
+			"-o", "GlobalKnownHostsFile=/dev/null",
+		)
+	}
+	return args
+}
+
+// pinnedKnownHostsPath is where the known_hosts file for a pinned key
+// lives: the user's cache directory, never a shared temp directory where
+// another user could plant a file first.
+func pinnedKnownHostsPath(key string) (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", fmt.Errorf("host_key: no cache directory for the pinned known_hosts file: %w", err)
+	}
+	sum := sha256.Sum256([]byte(pinnedKnownHostsLine(key)))
+	return filepath.Join(dir, "ssd", "known_hosts", hex.EncodeToString(sum[:8])), nil
+}
+
+// pinnedKnownHostsLine is the known_hosts entry for a pinned public key,
+// without the key's trailing comment.
+func pinnedKnownHostsLine(key string) string {
+	return pinnedHostAlias + " " + strings.Join(strings.Fields(key)[:2], " ") + "\n"
+}
+
+// writePinnedKnownHosts writes the pinned key's known_hosts file. It is
+// replaced by rename so a concurrent ssh never reads a partial file.
+func writePinnedKnownHosts(path, key string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return fmt.Errorf("host_key: %w", err)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".known_hosts-*")
+	if err != nil {
+		return fmt.Errorf("host_key: %w", err)
+	}
+	_, err = tmp.WriteString(pinnedKnownHostsLine(key))
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("host_key: %w", err)
+	}
+	return nil
+}
+
+// hostKeyPolicy is the host key configuration of the native client.
+type hostKeyPolicy struct {
+	pinned string // host_key: public key or SHA256 fingerprint
+	mode   string // strict_host_key_checking
+}
+
+func newHostKeyPolicy(cfg *config.Config) hostKeyPolicy {
+	return hostKeyPolicy{pinned: cfg.HostKey, mode: cfg.HostKeyCheckingMode()}
+}
+
+// String identifies the policy in the connection pool key.
+func (p hostKeyPolicy) String() string {
+	return p.mode + "|" + p.pinned
+}
+
+// callback returns the HostKeyCallback enforcing p, and the host key
+// algorithms to ask the server for (nil for the library default).
+// knownHostsFiles are the user's known_hosts files, first one preferred
+// for recording new hosts under accept-new.
+func (p hostKeyPolicy) callback(knownHostsFiles []string, addr string) (ssh.HostKeyCallback, []string, error) {
+	if p.pinned != "" {
+		return pinnedHostKeyCallback(p.pinned)
+	}
+	switch p.mode {
+	case config.HostKeyCheckingNo:
+		return ssh.InsecureIgnoreHostKey(), nil, nil
+	case config.HostKeyCheckingAcceptNew:
+		check, err := acceptNewKnownHosts(knownHostsFiles)
+		if err != nil {
+			return nil, nil, err
+		}
+		// Probe with the plain check: acceptNewCallback would record
+		// the probe's placeholder key.
+		return acceptNewCallback(check, knownHostsFiles[0]), knownKeyAlgorithms(check, addr), nil
+	default:
+		cb, err := knownHostsCallback(knownHostsFiles)
+		if err != nil {
+			return nil, nil, err
+		}
+		return cb, knownKeyAlgorithms(cb, addr), nil
+	}
+}
+
+// pinnedHostKeyCallback accepts only the pinned key, given as a public key
+// or a SHA256 fingerprint.
+func pinnedHostKeyCallback(pinned string) (ssh.HostKeyCallback, []string, error) {
+	if config.IsHostKeyFingerprint(pinned) {
+		return func(hostname string, _ net.Addr, key ssh.PublicKey) error {
+			if got := ssh.FingerprintSHA256(key); got != pinned {
+				return fmt.Errorf("host key mismatch for %s: got %s, host_key pins %s", hostname, got, pinned)
+			}
+			return nil
+		}, nil, nil
+	}
+	want, _, _, _, err := ssh.ParseAuthorizedKey([]byte(pinned))
+	if err != nil {
+		return nil, nil, fmt.Errorf("host_key: %w", err)
+	}
+	algos := []string{want.Type()}
+	if want.Type() == ssh.KeyAlgoRSA {
+		algos = []string{ssh.KeyAlgoRSASHA512, ssh.KeyAlgoRSASHA256, ssh.KeyAlgoRSA}
+	}
+	return func(hostname string, _ net.Addr, key ssh.PublicKey) error {
+		if !bytes.Equal(key.Marshal(), want.Marshal()) {
+			return fmt.Errorf("host key mismatch for %s: got %s, host_key pins %s", hostname, ssh.FingerprintSHA256(key), ssh.FingerprintSHA256(want))
+		}
+		return nil
+	}, algos, nil
+}
+
+// acceptNewMu serializes appends to known_hosts under accept-new.
+var acceptNewMu sync.Mutex
+
+// acceptNewKnownHosts creates the first known_hosts file if needed, so a
+// first connection has somewhere to record the host, and returns the
+// plain known_hosts check over files.
+func acceptNewKnownHosts(files []string) (ssh.HostKeyCallback, error) {
+	if len(files) == 0 {
+		return nil, errors.New("no known_hosts file configured")
+	}
+	if err := os.MkdirAll(filepath.Dir(files[0]), 0o700); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(files[0], os.O_CREATE|os.O_RDONLY, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	if err := f.Close(); err != nil {
+		return nil, err
+	}
+	return knownHostsCallback(files)
+}
+
+// acceptNewCallback wraps check so that a host with no entry at all is
+// recorded in the record file and accepted, as OpenSSH's
+// StrictHostKeyChecking=accept-new does. Changed keys are still refused.
+func acceptNewCallback(check ssh.HostKeyCallback, record string) ssh.HostKeyCallback {
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		err := check(hostname, remote, key)
+		var keyErr *knownhosts.KeyError
+		if !errors.As(err, &keyErr) || len(keyErr.Want) > 0 {
+			return err
+		}
+		acceptNewMu.Lock()
+		defer acceptNewMu.Unlock()
+		f, err := os.OpenFile(record, os.O_APPEND|os.O_WRONLY, 0o600)
+		if err != nil {
+			return fmt.Errorf("record host key for %s: %w", hostname, err)
+		}
+		_, err = f.WriteString(knownhosts.Line([]string{knownhosts.Normalize(hostname)}, key) + "\n")
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return fmt.Errorf("record host key for %s: %w", hostname, err)
+		}
+		return nil
+	}
+}
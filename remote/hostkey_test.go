@@ -0,0 +1,157 @@
+package remote
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/byteink/ssd/config"
+	"github.com/byteink/ssd/internal/testhelpers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh"
+)
+
+func newTestHostKey(t *testing.T) ssh.PublicKey {
+	t.Helper()
+	pub, _, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	key, err := ssh.NewPublicKey(pub)
+	require.NoError(t, err)
+	return key
+}
+
+func authorizedKey(key ssh.PublicKey) string {
+	return strings.TrimSpace(string(ssh.MarshalAuthorizedKey(key)))
+}
+
+func TestHostKeyArgs(t *testing.T) {
+	cfg := newTestConfig()
+	assert.Equal(t, []string{"-o", "StrictHostKeyChecking=yes"}, hostKeyArgs(cfg, ""))
+
+	cfg.HostKeyChecking = config.HostKeyCheckingAcceptNew
+	assert.Equal(t, []string{"-o", "StrictHostKeyChecking=accept-new"}, hostKeyArgs(cfg, ""))
+
+	cfg.HostKeyChecking = config.HostKeyCheckingNo
+	assert.Equal(t, []string{"-o", "StrictHostKeyChecking=no"}, hostKeyArgs(cfg, ""))
+
+	cfg.HostKey = authorizedKey(newTestHostKey(t))
+	assert.Equal(t, []string{
+		"-o", "StrictHostKeyChecking=yes",
+		"-o", "HostKeyAlias=" + pinnedHostAlias,
+		"-o", "UserKnownHostsFile=/cache/known_hosts",
+		"-o", "GlobalKnownHostsFile=/dev/null",
+	}, hostKeyArgs(cfg, "/cache/known_hosts"))
+}
+
+func TestNewClient_HostKeyCheckingInSSHArgs(t *testing.T) {
+	cfg := newTestConfig()
+	cfg.HostKeyChecking = config.HostKeyCheckingAcceptNew
+	client := NewClient(cfg)
+	assert.Contains(t, strings.Join(client.sshArgs, " "), "-o StrictHostKeyChecking=accept-new")
+	assert.NotContains(t, strings.Join(client.sshArgs, " "), "UserKnownHostsFile")
+}
+
+func TestClient_PinnedHostKeyWritesKnownHosts(t *testing.T) {
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+	key := newTestHostKey(t)
+	cfg := newTestConfig()
+	cfg.HostKey = authorizedKey(key) + " root@web1"
+
+	client := NewClient(cfg)
+	rec := testhelpers.NewRecordingExecutor()
+	client.executor = rec
+	_, err := client.SSH(context.Background(), "true")
+	require.NoError(t, err)
+
+	args := strings.Join(rec.Commands()[0].Args, " ")
+	assert.Contains(t, args, "-o StrictHostKeyChecking=yes")
+	assert.Contains(t, args, "-o UserKnownHostsFile="+client.knownHostsFile)
+	assert.Contains(t, args, "-o HostKeyAlias="+pinnedHostAlias)
+
+	got, err := os.ReadFile(client.knownHostsFile)
+	require.NoError(t, err)
+	assert.Equal(t, pinnedHostAlias+" "+authorizedKey(key)+"\n", string(got))
+}
+
+func TestRsync_QuotesSSHArgs(t *testing.T) {
+	cfg := newTestConfig()
+	cfg.HostKeyChecking = config.HostKeyCheckingNo
+	client := NewClient(cfg)
+	client.knownHostsFile = "/path with space/known_hosts"
+	client.sshArgs = append(client.sshArgs, "-o", "UserKnownHostsFile="+client.knownHostsFile)
+	rec := testhelpers.NewRecordingExecutor()
+	client.executor = rec
+	dir := t.TempDir()
+	client.findGitRoot = func(string) (string, error) { return dir, nil }
+
+	require.NoError(t, client.Rsync(context.Background(), dir, "/tmp/build"))
+	var pipeline string
+	for _, c := range rec.Commands() {
+		if c.Name == "bash" {
+			pipeline = c.Args[len(c.Args)-1]
+		}
+	}
+	assert.Contains(t, pipeline, "ssh -o ControlMaster=auto")
+	assert.Contains(t, pipeline, "'UserKnownHostsFile=/path with space/known_hosts'")
+}
+
+func TestHostKeyPolicy_Pinned(t *testing.T) {
+	key := newTestHostKey(t)
+	other := newTestHostKey(t)
+	addr := &net.TCPAddr{IP: net.IPv4(203, 0, 113, 5), Port: 22}
+
+	for _, pinned := range []string{authorizedKey(key), ssh.FingerprintSHA256(key)} {
+		cb, _, err := hostKeyPolicy{pinned: pinned, mode: config.HostKeyCheckingYes}.callback(nil, addr.String())
+		require.NoError(t, err)
+		assert.NoError(t, cb("web1:22", addr, key), pinned)
+		assert.ErrorContains(t, cb("web1:22", addr, other), "host key mismatch", pinned)
+	}
+}
+
+func TestHostKeyPolicy_AcceptNew(t *testing.T) {
+	key := newTestHostKey(t)
+	changed := newTestHostKey(t)
+	addr := &net.TCPAddr{IP: net.IPv4(203, 0, 113, 5), Port: 22}
+	file := filepath.Join(t.TempDir(), "ssh", "known_hosts")
+	policy := hostKeyPolicy{mode: config.HostKeyCheckingAcceptNew}
+
+	cb, _, err := policy.callback([]string{file}, "web1:22")
+	require.NoError(t, err)
+	require.NoError(t, cb("web1:22", addr, key))
+	recorded, err := os.ReadFile(file)
+	require.NoError(t, err)
+	assert.Contains(t, string(recorded), "web1 "+authorizedKey(key))
+
+	cb, algos, err := policy.callback([]string{file}, "web1:22")
+	require.NoError(t, err)
+	assert.Equal(t, []string{ssh.KeyAlgoED25519}, algos)
+	assert.NoError(t, cb("web1:22", addr, key))
+	assert.Error(t, cb("web1:22", addr, changed))
+}
+
+func TestHostKeyPolicy_Modes(t *testing.T) {
+	key := newTestHostKey(t)
+	addr := &net.TCPAddr{IP: net.IPv4(203, 0, 113, 5), Port: 22}
+	file := filepath.Join(t.TempDir(), "known_hosts")
+	require.NoError(t, os.WriteFile(file, nil, 0o600))
+
+	cb, _, err := hostKeyPolicy{mode: config.HostKeyCheckingYes}.callback([]string{file}, "web1:22")
+	require.NoError(t, err)
+	assert.Error(t, cb("web1:22", addr, key))
+
+	cb, _, err = hostKeyPolicy{mode: config.HostKeyCheckingNo}.callback([]string{file}, "web1:22")
+	require.NoError(t, err)
+	assert.NoError(t, cb("web1:22", addr, key))
+}
+
+func TestNewNativeExecutor_HostKeyPolicy(t *testing.T) {
+	cfg := newTestConfig()
+	cfg.HostKeyChecking = config.HostKeyCheckingNo
+	assert.Equal(t, hostKeyPolicy{mode: config.HostKeyCheckingNo}, NewNativeExecutor(cfg).hostKeys)
+}
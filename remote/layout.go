@@ -0,0 +1,149 @@
+package remote
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/byteink/ssd/config"
+)
+
+// buildLayout says how the build context and Dockerfile are laid out in
+// the build directory on the server.
+type buildLayout struct {
+	// contextDir is the build context inside the build directory: "."
+	// when only the context is shipped, its git-root-relative path when
+	// the Dockerfile lives outside it.
+	contextDir string
+	// dockerfile is the -f argument, relative to the build directory.
+	dockerfile string
+	// archive lists the git-root-relative paths Rsync ships without
+	// stripping them; empty means the context alone is shipped.
+	archive []string
+}
+
+// layout resolves cfg.Dockerfile for the build context at localContext.
+// A Dockerfile found relative to the context keeps the historical
+// meaning. Otherwise it is resolved from the project directory (like
+// context): inside the context it becomes context-relative, outside it
+// the context and the Dockerfile are both shipped from the git root.
+// A Dockerfile that doesn't exist is passed through for docker to report.
+func (c *Client) layout(localContext string) (buildLayout, error) {
+	dockerfile := strings.TrimPrefix(c.cfg.Dockerfile, "./")
+	simple := buildLayout{contextDir: ".", dockerfile: dockerfile}
+	if dockerfile == "" {
+		return simple, nil
+	}
+	if filepath.IsAbs(dockerfile) {
+		return buildLayout{}, fmt.Errorf("dockerfile %q must be a relative path", c.cfg.Dockerfile)
+	}
+	if _, err := os.Stat(filepath.Join(localContext, dockerfile)); err == nil {
+		return simple, nil
+	}
+
+	abs, err := filepath.Abs(dockerfile)
+	if err != nil {
+		return buildLayout{}, fmt.Errorf("failed to resolve dockerfile path: %w", err)
+	}
+	if _, err := os.Stat(abs); err != nil {
+		return simple, nil
+	}
+	if rel, ok := within(localContext, abs); ok {
+		return buildLayout{contextDir: ".", dockerfile: rel}, nil
+	}
+
+	gitRoot, err := c.findGitRoot(localContext)
+	if err != nil {
+		return buildLayout{}, fmt.Errorf("failed to find git root: %w", err)
+	}
+	contextRel, ok := within(gitRoot, localContext)
+	if !ok {
+		return buildLayout{}, fmt.Errorf("context %s is outside the git repository", localContext)
+	}
+	dockerfileRel, ok := within(gitRoot, abs)
+	if !ok {
+		return buildLayout{}, fmt.Errorf("dockerfile %s is outside the git repository", abs)
+	}
+	return buildLayout{
+		contextDir: contextRel,
+		dockerfile: dockerfileRel,
+		archive:    []string{contextRel, dockerfileRel},
+	}, nil
+}
+
+// within returns path relative to dir when path is dir or below it.
+func within(dir, path string) (string, bool) {
+	rel, err := filepath.Rel(dir, path)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", false
+	}
+	return filepath.ToSlash(rel), true
+}
+
+// CheckDockerfile reports a Dockerfile missing on this machine before any
+// upload, resolved like layout: relative to the build context, then to the
+// project directory. An unset dockerfile means <context>/Dockerfile, as
+// for docker build.
+func (c *Client) CheckDockerfile() error {
+	localContext, err := filepath.Abs(c.cfg.Context)
+	if err != nil {
+		return fmt.Errorf("failed to resolve context path: %w", err)
+	}
+	dockerfile := strings.TrimPrefix(c.cfg.Dockerfile, "./")
+	if dockerfile == "" {
+		dockerfile = "Dockerfile"
+	}
+	if filepath.IsAbs(dockerfile) {
+		return fmt.Errorf("dockerfile %q must be a relative path", c.cfg.Dockerfile)
+	}
+	inContext := filepath.Join(localContext, dockerfile)
+	fromProject, err := filepath.Abs(dockerfile)
+	if err != nil {
+		return fmt.Errorf("failed to resolve dockerfile path: %w", err)
+	}
+	for _, path := range []string{inContext, fromProject} {
+		if info, err := os.Stat(path); err == nil && !info.IsDir() {
+			return nil
+		}
+	}
+	if inContext == fromProject {
+		return fmt.Errorf("dockerfile %q not found: no file at %s", c.cfg.Dockerfile, inContext)
+	}
+	return fmt.Errorf("dockerfile %q not found: no file at %s or %s", c.cfg.Dockerfile, inContext, fromProject)
+}
+
+// DockerfilePath returns the absolute path of the Dockerfile cfg builds
+// with, resolved like layout: relative to the build context, then to the
+// project directory. It is "" when neither holds the file.
+func DockerfilePath(cfg *config.Config) string {
+	dockerfile := strings.TrimPrefix(cfg.Dockerfile, "./")
+	if dockerfile == "" {
+		dockerfile = "Dockerfile"
+	}
+	if filepath.IsAbs(dockerfile) {
+		return ""
+	}
+	for _, path := range []string{filepath.Join(cfg.Context, dockerfile), dockerfile} {
+		if info, err := os.Stat(path); err == nil && !info.IsDir() {
+			if abs, err := filepath.Abs(path); err == nil {
+				return abs
+			}
+		}
+	}
+	return ""
+}
+
+// BuildPaths returns the build context and Dockerfile arguments for
+// building in the directory Rsync filled: `build -f <dockerfile> <context>`.
+func (c *Client) BuildPaths() (contextDir, dockerfile string, err error) {
+	localContext, err := filepath.Abs(c.cfg.Context)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to resolve context path: %w", err)
+	}
+	l, err := c.layout(localContext)
+	if err != nil {
+		return "", "", err
+	}
+	return l.contextDir, l.dockerfile, nil
+}
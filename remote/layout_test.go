@@ -0,0 +1,201 @@
+package remote
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/byteink/ssd/internal/testhelpers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+// newLayoutRepo creates a project with apps/web as a subdirectory context
+// and chdirs into it, so relative config paths resolve as in ssd.yaml.
+func newLayoutRepo(t *testing.T, files ...string) string {
+	t.Helper()
+	root, err := filepath.EvalSymlinks(t.TempDir())
+	require.NoError(t, err)
+	require.NoError(t, os.MkdirAll(filepath.Join(root, "apps", "web"), 0o755))
+	for _, f := range files {
+		path := filepath.Join(root, f)
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0o755))
+		require.NoError(t, os.WriteFile(path, []byte("FROM scratch\n"), 0o644))
+	}
+	t.Chdir(root)
+	return root
+}
+
+func newLayoutClient(root, contextDir, dockerfile string) (*Client, *testhelpers.MockExecutor) {
+	cfg := newTestConfig()
+	cfg.Context = contextDir
+	cfg.Dockerfile = dockerfile
+	mockExec := new(testhelpers.MockExecutor)
+	client := NewClientWithExecutor(cfg, mockExec)
+	client.findGitRoot = func(string) (string, error) { return root, nil }
+	return client, mockExec
+}
+
+func TestLayout_DockerfileInContext(t *testing.T) {
+	root := newLayoutRepo(t, "apps/web/Dockerfile")
+	client, mockExec := newLayoutClient(root, "./apps/web", "./apps/web/Dockerfile")
+
+	contextDir, dockerfile, err := client.BuildPaths()
+	require.NoError(t, err)
+	assert.Equal(t, ".", contextDir)
+	assert.Equal(t, "Dockerfile", dockerfile)
+
+	mockExec.On("RunInteractive", "bash", mock.MatchedBy(func(args []string) bool {
+		return strings.Contains(args[1], "-- apps/web") &&
+			strings.Contains(args[1], "--strip-components=2")
+	})).Return(nil)
+	require.NoError(t, client.Rsync(context.Background(), filepath.Join(root, "apps", "web"), "/remote/path"))
+	mockExec.AssertExpectations(t)
+}
+
+func TestLayout_ContextRelativeDockerfile(t *testing.T) {
+	root := newLayoutRepo(t, "apps/web/docker/Dockerfile.prod")
+	client, _ := newLayoutClient(root, "./apps/web", "docker/Dockerfile.prod")
+
+	contextDir, dockerfile, err := client.BuildPaths()
+	require.NoError(t, err)
+	assert.Equal(t, ".", contextDir)
+	assert.Equal(t, "docker/Dockerfile.prod", dockerfile)
+}
+
+func TestLayout_DockerfileAtRoot(t *testing.T) {
+	root := newLayoutRepo(t, "Dockerfile.web")
+	client, mockExec := newLayoutClient(root, "./apps/web", "./Dockerfile.web")
+
+	contextDir, dockerfile, err := client.BuildPaths()
+	require.NoError(t, err)
+	assert.Equal(t, "apps/web", contextDir)
+	assert.Equal(t, "Dockerfile.web", dockerfile)
+
+	mockExec.On("RunInteractive", "bash", mock.MatchedBy(func(args []string) bool {
+		return strings.Contains(args[1], "-- apps/web Dockerfile.web") &&
+			!strings.Contains(args[1], "--strip-components")
+	})).Return(nil)
+	require.NoError(t, client.Rsync(context.Background(), filepath.Join(root, "apps", "web"), "/remote/path"))
+
+	mockExec.On("RunInteractive", "ssh", mock.MatchedBy(func(args []string) bool {
+		return strings.HasSuffix(args[len(args)-1], "-f Dockerfile.web apps/web")
+	})).Return(nil)
+	require.NoError(t, client.BuildImage(context.Background(), "/tmp/build", 3))
+	mockExec.AssertExpectations(t)
+}
+
+func TestLayout_ContextIsRoot(t *testing.T) {
+	root := newLayoutRepo(t, "build/Dockerfile")
+	client, mockExec := newLayoutClient(root, ".", "./build/Dockerfile")
+
+	contextDir, dockerfile, err := client.BuildPaths()
+	require.NoError(t, err)
+	assert.Equal(t, ".", contextDir)
+	assert.Equal(t, "build/Dockerfile", dockerfile)
+
+	mockExec.On("RunInteractive", "bash", mock.MatchedBy(func(args []string) bool {
+		return !strings.Contains(args[1], " -- ") &&
+			!strings.Contains(args[1], "--strip-components")
+	})).Return(nil)
+	require.NoError(t, client.Rsync(context.Background(), root, "/remote/path"))
+	mockExec.AssertExpectations(t)
+}
+
+func TestLayout_DockerfileOutsideRepo(t *testing.T) {
+	root := newLayoutRepo(t, "Dockerfile.web")
+	client, _ := newLayoutClient(filepath.Join(root, "apps"), "./apps/web", "./Dockerfile.web")
+
+	_, _, err := client.BuildPaths()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "outside the git repository")
+}
+
+func TestLayout_AbsoluteDockerfile(t *testing.T) {
+	root := newLayoutRepo(t)
+	client, _ := newLayoutClient(root, ".", "/etc/Dockerfile")
+
+	_, _, err := client.BuildPaths()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "must be a relative path")
+}
+
+func TestCheckDockerfile(t *testing.T) {
+	t.Run("in context", func(t *testing.T) {
+		root := newLayoutRepo(t, "apps/web/Dockerfile")
+		client, _ := newLayoutClient(root, "./apps/web", "./Dockerfile")
+		assert.NoError(t, client.CheckDockerfile())
+	})
+
+	t.Run("from project directory", func(t *testing.T) {
+		root := newLayoutRepo(t, "Dockerfile.web")
+		client, _ := newLayoutClient(root, "./apps/web", "./Dockerfile.web")
+		assert.NoError(t, client.CheckDockerfile())
+	})
+
+	t.Run("default name", func(t *testing.T) {
+		root := newLayoutRepo(t, "apps/web/Dockerfile")
+		client, _ := newLayoutClient(root, "./apps/web", "")
+		assert.NoError(t, client.CheckDockerfile())
+	})
+
+	t.Run("missing", func(t *testing.T) {
+		root := newLayoutRepo(t, "apps/web/Dockerfile")
+		client, _ := newLayoutClient(root, "./apps/web", "./Dockerfil")
+		err := client.CheckDockerfile()
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), `dockerfile "./Dockerfil" not found`)
+		assert.Contains(t, err.Error(), filepath.Join(root, "apps", "web", "Dockerfil"))
+		assert.Contains(t, err.Error(), filepath.Join(root, "Dockerfil"))
+	})
+
+	t.Run("directory", func(t *testing.T) {
+		root := newLayoutRepo(t, "apps/web/docker/Dockerfile")
+		client, _ := newLayoutClient(root, "./apps/web", "docker")
+		require.Error(t, client.CheckDockerfile())
+	})
+}
+
+func TestDockerfilePath(t *testing.T) {
+	root := newLayoutRepo(t, "apps/web/Dockerfile", "docker/web.Dockerfile")
+	tests := []struct {
+		dockerfile string
+		want       string
+	}{
+		{"", filepath.Join(root, "apps", "web", "Dockerfile")},
+		{"./Dockerfile", filepath.Join(root, "apps", "web", "Dockerfile")},
+		{"docker/web.Dockerfile", filepath.Join(root, "docker", "web.Dockerfile")},
+		{"docker/missing.Dockerfile", ""},
+		{"docker", ""},
+		{"/abs/Dockerfile", ""},
+	}
+	for _, tt := range tests {
+		cfg := newTestConfig()
+		cfg.Context = "./apps/web"
+		cfg.Dockerfile = tt.dockerfile
+		assert.Equal(t, tt.want, DockerfilePath(cfg), tt.dockerfile)
+	}
+}
+
+// --context-override hands the client an absolute context outside the
+// configured one; the archive path and strip count follow it.
+func TestRsync_ContextOverride(t *testing.T) {
+	root := newLayoutRepo(t, "build/out/web/Dockerfile")
+	client, mockExec := newLayoutClient(root, filepath.Join(root, "build", "out", "web"), "./Dockerfile")
+
+	contextDir, dockerfile, err := client.BuildPaths()
+	require.NoError(t, err)
+	assert.Equal(t, ".", contextDir)
+	assert.Equal(t, "Dockerfile", dockerfile)
+	require.NoError(t, client.CheckDockerfile())
+
+	mockExec.On("RunInteractive", "bash", mock.MatchedBy(func(args []string) bool {
+		return strings.Contains(args[1], "-- build/out/web") &&
+			strings.Contains(args[1], "--strip-components=3")
+	})).Return(nil)
+	require.NoError(t, client.Rsync(context.Background(), filepath.Join(root, "build", "out", "web"), "/remote/path"))
+	mockExec.AssertExpectations(t)
+}
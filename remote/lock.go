@@ -0,0 +1,112 @@
+package remote
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"al.essio.dev/pkg/shellescape"
+)
+
+// LockDir is the stack's deploy lock, created in the stack directory.
+// mkdir is atomic on the server, so whoever creates it holds the lock.
+const LockDir = ".ssd-lock"
+
+// ServiceLockDir is the lock, next to LockDir, that keeps two deploys of
+// the same service apart.
+func ServiceLockDir(service string) string {
+	return LockDir + "-" + service
+}
+
+// TryLock makes one attempt at taking the remote lock directory lock
+// (LockDir or a ServiceLockDir) on behalf of holder. A lock whose mtime is
+// older than staleAfter is considered abandoned and taken over; holders
+// keep theirs fresh with RefreshLock. When the lock is held by someone
+// else it returns false and the current holder's description. Taking a
+// lock drops the cached compose file, which others may have rewritten
+// while this client waited.
+//
+// The attempt runs under lockGuard, so checking a lock for staleness and
+// replacing it can't interleave with another client doing the same: of
+// several clients that find a lock stale, one takes it over and the rest
+// see it held. A mkdir that fails after all also reports the lock held.
+func (c *Client) TryLock(ctx context.Context, lock, holder string, staleAfter time.Duration) (bool, string, error) {
+	stackPath := c.cfg.StackPath()
+	lockDir := shellescape.Quote(filepath.Join(stackPath, lock))
+	holderFile := shellescape.Quote(filepath.Join(stackPath, lock, "holder"))
+	h := shellescape.Quote(holder)
+
+	held := fmt.Sprintf("echo held; cat %s 2>/dev/null", holderFile)
+	cmd := fmt.Sprintf("mkdir -p %s && %s", shellescape.Quote(stackPath), lockGuard(stackPath, fmt.Sprintf(
+		"if mkdir %s 2>/dev/null; then echo %s > %s && echo acquired; "+
+			"elif [ %s -gt %d ]; then rm -rf %s; if mkdir %s 2>/dev/null; then echo %s > %s && echo acquired; else %s; fi; "+
+			"else %s; fi",
+		lockDir, h, holderFile,
+		lockAge(lockDir), int(staleAfter.Seconds()), lockDir, lockDir, h, holderFile, held,
+		held)))
+
+	output, err := c.SSH(ctx, cmd)
+	if err != nil {
+		return false, "", fmt.Errorf("failed to take remote lock: %w", err)
+	}
+	status, current, _ := strings.Cut(strings.TrimSpace(output), "\n")
+	switch status {
+	case "acquired":
+		c.composeCached = false
+		return true, "", nil
+	case "held":
+		return false, strings.TrimSpace(current), nil
+	default:
+		return false, "", fmt.Errorf("unexpected remote lock response: %q", output)
+	}
+}
+
+// lockGuard wraps script in a subshell holding flock on the stack
+// directory, which serializes lock changes by clients on any machine; the
+// kernel drops it if the session dies, so it can't go stale itself.
+// Servers without flock run the script unguarded.
+func lockGuard(stackPath, script string) string {
+	return fmt.Sprintf("( if command -v flock >/dev/null 2>&1; then flock -w 30 9 || exit 1; fi; %s ) 9<%s",
+		script, shellescape.Quote(stackPath))
+}
+
+// lockAge is the shell arithmetic for the age in seconds of the lock
+// directory dir (already quoted). A directory that vanished counts as
+// brand new, so it is never taken for stale.
+func lockAge(dir string) string {
+	return fmt.Sprintf("$(( $(date +%%s) - $(stat -c %%Y %s 2>/dev/null || date +%%s) ))", dir)
+}
+
+// RefreshLock bumps the remote lock's mtime if holder still owns it, so a
+// deploy that runs longer than the stale timeout keeps its lock.
+func (c *Client) RefreshLock(ctx context.Context, lock, holder string) error {
+	stackPath := c.cfg.StackPath()
+	lockDir := shellescape.Quote(filepath.Join(stackPath, lock))
+	holderFile := shellescape.Quote(filepath.Join(stackPath, lock, "holder"))
+
+	cmd := fmt.Sprintf("if [ \"$(cat %s 2>/dev/null)\" = %s ]; then touch %s; fi",
+		holderFile, shellescape.Quote(holder), lockDir)
+	if _, err := c.SSH(ctx, cmd); err != nil {
+		return fmt.Errorf("failed to refresh remote lock: %w", err)
+	}
+	return nil
+}
+
+// Unlock releases the remote lock if holder still owns it. A lock taken
+// over by someone else after going stale is left alone, as is a stack
+// directory that is already gone.
+func (c *Client) Unlock(ctx context.Context, lock, holder string) error {
+	stackPath := c.cfg.StackPath()
+	lockDir := shellescape.Quote(filepath.Join(stackPath, lock))
+	holderFile := shellescape.Quote(filepath.Join(stackPath, lock, "holder"))
+
+	cmd := fmt.Sprintf("if [ -d %s ]; then %s; fi", shellescape.Quote(stackPath),
+		lockGuard(stackPath, fmt.Sprintf("if [ \"$(cat %s 2>/dev/null)\" = %s ]; then rm -rf %s; fi",
+			holderFile, shellescape.Quote(holder), lockDir)))
+	if _, err := c.SSH(ctx, cmd); err != nil {
+		return fmt.Errorf("failed to release remote lock: %w", err)
+	}
+	return nil
+}
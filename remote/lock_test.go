@@ -0,0 +1,234 @@
+package remote
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/byteink/ssd/internal/testhelpers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClient_TryLock_Acquired(t *testing.T) {
+	cfg := newTestConfig()
+	mockExec := new(testhelpers.MockExecutor)
+	client := NewClientWithExecutor(cfg, mockExec)
+
+	mockExec.On("Run", "ssh", mock.MatchedBy(func(args []string) bool {
+		cmd := args[len(args)-1]
+		return strings.HasPrefix(cmd, "mkdir -p /stacks/myapp && ( if command -v flock >/dev/null 2>&1; then flock -w 30 9 || exit 1; fi; if mkdir /stacks/myapp/.ssd-lock 2>/dev/null;") &&
+			strings.Contains(cmd, "echo 'alice@desk pid 1' > /stacks/myapp/.ssd-lock/holder") &&
+			strings.Contains(cmd, "-gt 1800 ]; then rm -rf /stacks/myapp/.ssd-lock;") &&
+			strings.HasSuffix(cmd, " ) 9</stacks/myapp")
+	})).Return("acquired\n", nil)
+
+	ok, holder, err := client.TryLock(context.Background(), LockDir, "alice@desk pid 1", 30*time.Minute)
+
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Empty(t, holder)
+	mockExec.AssertExpectations(t)
+}
+
+func TestClient_TryLock_HeldReportsHolder(t *testing.T) {
+	cfg := newTestConfig()
+	mockExec := new(testhelpers.MockExecutor)
+	client := NewClientWithExecutor(cfg, mockExec)
+
+	mockExec.On("Run", "ssh", mock.Anything).Return("held\nbob@laptop pid 42 since 2026-01-01T00:00:00Z\n", nil)
+
+	ok, holder, err := client.TryLock(context.Background(), LockDir, "alice@desk pid 1", time.Minute)
+
+	require.NoError(t, err)
+	assert.False(t, ok)
+	assert.Equal(t, "bob@laptop pid 42 since 2026-01-01T00:00:00Z", holder)
+}
+
+func TestClient_TryLock_UnexpectedOutput(t *testing.T) {
+	cfg := newTestConfig()
+	mockExec := new(testhelpers.MockExecutor)
+	client := NewClientWithExecutor(cfg, mockExec)
+
+	mockExec.On("Run", "ssh", mock.Anything).Return("permission denied\n", nil)
+
+	_, _, err := client.TryLock(context.Background(), LockDir, "alice", time.Minute)
+
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "unexpected remote lock response")
+}
+
+func TestClient_Unlock_OnlyRemovesOwnLock(t *testing.T) {
+	cfg := newTestConfig()
+	mockExec := new(testhelpers.MockExecutor)
+	client := NewClientWithExecutor(cfg, mockExec)
+
+	want := "if [ -d /stacks/myapp ]; then ( if command -v flock >/dev/null 2>&1; then flock -w 30 9 || exit 1; fi; " +
+		"if [ \"$(cat /stacks/myapp/.ssd-lock/holder 2>/dev/null)\" = 'alice@desk pid 1' ]; then rm -rf /stacks/myapp/.ssd-lock; fi ) 9</stacks/myapp; fi"
+	mockExec.On("Run", "ssh", []string{"testserver", want}).Return("", nil)
+
+	require.NoError(t, client.Unlock(context.Background(), LockDir, "alice@desk pid 1"))
+	mockExec.AssertExpectations(t)
+}
+
+func TestClient_TryLock_ServiceLockDir(t *testing.T) {
+	cfg := newTestConfig()
+	mockExec := new(testhelpers.MockExecutor)
+	client := NewClientWithExecutor(cfg, mockExec)
+
+	mockExec.On("Run", "ssh", mock.MatchedBy(func(args []string) bool {
+		return strings.Contains(args[len(args)-1], "; if mkdir /stacks/myapp/.ssd-lock-web 2>/dev/null;")
+	})).Return("acquired\n", nil)
+
+	ok, _, err := client.TryLock(context.Background(), ServiceLockDir("web"), "alice", time.Minute)
+
+	require.NoError(t, err)
+	assert.True(t, ok)
+	mockExec.AssertExpectations(t)
+}
+
+func TestClient_TryLock_DropsComposeCache(t *testing.T) {
+	cfg := newTestConfig()
+	mockExec := new(testhelpers.MockExecutor)
+	client := NewClientWithExecutor(cfg, mockExec)
+
+	mockExec.On("Run", "ssh", []string{"testserver", "cat /stacks/myapp/compose.yaml 2>/dev/null || echo ''"}).Return("old\n", nil).Once()
+	mockExec.On("Run", "ssh", []string{"testserver", "cat /stacks/myapp/compose.yaml 2>/dev/null || echo ''"}).Return("new\n", nil).Once()
+	mockExec.On("Run", "ssh", mock.MatchedBy(func(args []string) bool {
+		return strings.Contains(args[len(args)-1], ".ssd-lock ")
+	})).Return("acquired\n", nil)
+
+	first, err := client.ReadManifest(context.Background())
+	require.NoError(t, err)
+	ok, _, err := client.TryLock(context.Background(), LockDir, "alice", time.Minute)
+	require.NoError(t, err)
+	require.True(t, ok)
+	second, err := client.ReadManifest(context.Background())
+	require.NoError(t, err)
+
+	assert.Equal(t, "old\n", first)
+	assert.Equal(t, "new\n", second, "compose file read before the lock must not be reused")
+}
+
+// shellExecutor runs the command of each ssh call with the local sh, so
+// the lock scripts can be exercised against a temporary stack directory.
+type shellExecutor struct{}
+
+func (shellExecutor) Run(ctx context.Context, name string, args ...string) (string, error) {
+	out, err := exec.CommandContext(ctx, "sh", "-c", args[len(args)-1]).CombinedOutput()
+	return string(out), err
+}
+
+func (shellExecutor) RunInteractive(ctx context.Context, name string, args ...string) error {
+	return exec.CommandContext(ctx, "sh", "-c", args[len(args)-1]).Run()
+}
+
+// newShellLockClient returns a client whose stack is a temporary
+// directory holding a lock taken by holder, with the given age.
+func newShellLockClient(t *testing.T, holder string, age time.Duration) (*Client, string) {
+	t.Helper()
+	cfg := newTestConfig()
+	cfg.Stack = t.TempDir()
+	lock := filepath.Join(cfg.Stack, LockDir)
+	require.NoError(t, os.Mkdir(lock, 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(lock, "holder"), []byte(holder+"\n"), 0o644))
+	then := time.Now().Add(-age)
+	require.NoError(t, os.Chtimes(lock, then, then))
+	return NewClientWithExecutor(cfg, shellExecutor{}), lock
+}
+
+func TestClient_TryLock_TakesOverStaleLock(t *testing.T) {
+	client, lock := newShellLockClient(t, "crashed@ci pid 9", time.Hour)
+
+	ok, _, err := client.TryLock(context.Background(), LockDir, "alice@desk pid 1", 30*time.Minute)
+
+	require.NoError(t, err)
+	assert.True(t, ok)
+	holder, err := os.ReadFile(filepath.Join(lock, "holder"))
+	require.NoError(t, err)
+	assert.Equal(t, "alice@desk pid 1\n", string(holder))
+}
+
+func TestClient_TryLock_FreshLockIsHeld(t *testing.T) {
+	client, lock := newShellLockClient(t, "bob@laptop pid 42", time.Minute)
+
+	ok, current, err := client.TryLock(context.Background(), LockDir, "alice@desk pid 1", 30*time.Minute)
+
+	require.NoError(t, err)
+	assert.False(t, ok)
+	assert.Equal(t, "bob@laptop pid 42", current)
+	holder, err := os.ReadFile(filepath.Join(lock, "holder"))
+	require.NoError(t, err)
+	assert.Equal(t, "bob@laptop pid 42\n", string(holder))
+}
+
+func TestClient_TryLock_ConcurrentTakeoverHasOneWinner(t *testing.T) {
+	client, _ := newShellLockClient(t, "crashed@ci pid 9", time.Hour)
+
+	const takers = 8
+	won := make(chan string, takers)
+	errs := make(chan error, takers)
+	for i := range takers {
+		go func() {
+			holder := "taker " + string(rune('a'+i))
+			ok, _, err := client.TryLock(context.Background(), LockDir, holder, 30*time.Minute)
+			if ok {
+				won <- holder
+			}
+			errs <- err
+		}()
+	}
+	for range takers {
+		require.NoError(t, <-errs)
+	}
+	close(won)
+	var winners []string
+	for w := range won {
+		winners = append(winners, w)
+	}
+	assert.Len(t, winners, 1, "exactly one client may take over a stale lock")
+}
+
+func TestClient_Unlock_StackGone(t *testing.T) {
+	cfg := newTestConfig()
+	cfg.Stack = filepath.Join(t.TempDir(), "removed")
+	client := NewClientWithExecutor(cfg, shellExecutor{})
+
+	require.NoError(t, client.Unlock(context.Background(), LockDir, "alice@desk pid 1"))
+}
+
+func TestClient_RefreshLock_OnlyTouchesOwnLock(t *testing.T) {
+	cfg := newTestConfig()
+	mockExec := new(testhelpers.MockExecutor)
+	client := NewClientWithExecutor(cfg, mockExec)
+
+	want := "if [ \"$(cat /stacks/myapp/.ssd-lock-web/holder 2>/dev/null)\" = 'alice@desk pid 1' ]; then touch /stacks/myapp/.ssd-lock-web; fi"
+	mockExec.On("Run", "ssh", []string{"testserver", want}).Return("", nil)
+
+	require.NoError(t, client.RefreshLock(context.Background(), ServiceLockDir("web"), "alice@desk pid 1"))
+	mockExec.AssertExpectations(t)
+}
+
+func TestClient_RefreshLock_KeepsLockFromGoingStale(t *testing.T) {
+	client, lock := newShellLockClient(t, "alice@desk pid 1", time.Hour)
+
+	require.NoError(t, client.RefreshLock(context.Background(), LockDir, "alice@desk pid 1"))
+	ok, current, err := client.TryLock(context.Background(), LockDir, "bob@laptop pid 42", 30*time.Minute)
+
+	require.NoError(t, err)
+	assert.False(t, ok, "a refreshed lock is not stale")
+	assert.Equal(t, "alice@desk pid 1", current)
+
+	// Someone else's refresh leaves the age alone.
+	then := time.Now().Add(-time.Hour)
+	require.NoError(t, os.Chtimes(lock, then, then))
+	require.NoError(t, client.RefreshLock(context.Background(), LockDir, "bob@laptop pid 42"))
+	info, err := os.Stat(lock)
+	require.NoError(t, err)
+	assert.WithinDuration(t, then, info.ModTime(), time.Second)
+}
@@ -0,0 +1,403 @@
+package remote
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"os/user"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/byteink/ssd/config"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// NativeExecutor implements CommandExecutor with golang.org/x/crypto/ssh.
+// "ssh" invocations run as sessions on one persistent connection per
+// server instead of spawning the ssh binary; anything else (git, the bash
+// pipeline behind Rsync) is delegated to a RealExecutor.
+//
+// The connection target is resolved once per server with `ssh -G`, so Host
+// aliases, User, Port, IdentityFile and UserKnownHostsFile from
+// ~/.ssh/config apply as they do for the openssh client. Host keys are
+// checked according to host_key and strict_host_key_checking (see
+// hostKeyPolicy); by default unknown hosts are refused.
+type NativeExecutor struct {
+	// Prefix, when set, is put in front of every line RunInteractive
+	// streams to the terminal.
+	Prefix   string
+	hostKeys hostKeyPolicy
+	pool     *sshPool
+	local    *RealExecutor
+}
+
+// NewNativeExecutor creates a NativeExecutor applying cfg's host key
+// settings. Executors share a process-wide connection pool, so every
+// client for the same server reuses one connection.
+func NewNativeExecutor(cfg *config.Config) *NativeExecutor {
+	return &NativeExecutor{hostKeys: newHostKeyPolicy(cfg), pool: defaultSSHPool, local: NewRealExecutor()}
+}
+
+// Run executes a command with a 5 minute timeout and returns stdout
+func (e *NativeExecutor) Run(ctx context.Context, name string, args ...string) (string, error) {
+	if name != "ssh" {
+		return e.local.Run(ctx, name, args...)
+	}
+	return e.runCaptured(ctx, 5*time.Minute, args)
+}
+
+// RunCaptured executes a command like Run, with a 30 minute timeout
+func (e *NativeExecutor) RunCaptured(ctx context.Context, name string, args ...string) (string, error) {
+	if name != "ssh" {
+		return e.local.RunCaptured(ctx, name, args...)
+	}
+	return e.runCaptured(ctx, 30*time.Minute, args)
+}
+
+// runCaptured runs the command in ssh args on its server with the given
+// timeout and returns its stdout.
+func (e *NativeExecutor) runCaptured(ctx context.Context, timeout time.Duration, args []string) (string, error) {
+	server, command, err := sshTargetArgs(args)
+	if err != nil {
+		return "", err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	var stdout, stderr strings.Builder
+	if err := e.run(ctx, server, command, &stdout, &stderr); err != nil {
+		return "", fmt.Errorf("command failed: %s\n%s", err, stderr.String())
+	}
+	return stdout.String(), nil
+}
+
+// RunInteractive executes a command with a 30 minute timeout and output
+// streamed to the terminal. Stdin is not forwarded.
+func (e *NativeExecutor) RunInteractive(ctx context.Context, name string, args ...string) error {
+	if name != "ssh" {
+		e.local.Prefix = e.Prefix
+		return e.local.RunInteractive(ctx, name, args...)
+	}
+	server, command, err := sshTargetArgs(args)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Minute)
+	defer cancel()
+
+	stdout, stderr, flush := interactiveStreams(e.Prefix)
+	err = e.run(ctx, server, command, stdout, stderr)
+	if flushErr := flush(); err == nil {
+		err = flushErr
+	}
+	return err
+}
+
+// sshTargetArgs picks the server and remote command out of the argument
+// list Client builds for the ssh binary: [options...] server command.
+func sshTargetArgs(args []string) (server, command string, err error) {
+	if len(args) < 2 {
+		return "", "", fmt.Errorf("ssh: expected server and command, got %q", args)
+	}
+	return args[len(args)-2], args[len(args)-1], nil
+}
+
+// run executes command in a new session on server's pooled connection.
+// Cancelling ctx closes the session, which ends the remote command.
+func (e *NativeExecutor) run(ctx context.Context, server, command string, stdout, stderr io.Writer) error {
+	sess, err := e.session(ctx, server)
+	if err != nil {
+		return err
+	}
+	defer sess.Close()
+	sess.Stdout = stdout
+	sess.Stderr = stderr
+
+	done := make(chan error, 1)
+	go func() { done <- sess.Run(command) }()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		sess.Close()
+		<-done
+		return ctx.Err()
+	}
+}
+
+// session opens a session on server's pooled connection. A pooled
+// connection may have died since its last use (server restart, idle
+// timeout), so a failure to open a session redials once.
+func (e *NativeExecutor) session(ctx context.Context, server string) (*ssh.Session, error) {
+	for attempt := 0; ; attempt++ {
+		client, err := e.pool.get(ctx, server, e.hostKeys)
+		if err != nil {
+			return nil, err
+		}
+		sess, err := client.NewSession()
+		if err == nil {
+			return sess, nil
+		}
+		e.pool.drop(client)
+		if attempt > 0 {
+			return nil, fmt.Errorf("ssh: open session on %s: %w", server, err)
+		}
+	}
+}
+
+// sshDialer opens a connection to a server named as in ssd.yaml.
+type sshDialer func(ctx context.Context, server string, hostKeys hostKeyPolicy) (*ssh.Client, error)
+
+// sshPool keeps one open connection per server and host key policy.
+type sshPool struct {
+	dial  sshDialer
+	mu    sync.Mutex
+	conns map[string]*ssh.Client
+}
+
+var defaultSSHPool = newSSHPool(dialOpenSSHConfig)
+
+func newSSHPool(dial sshDialer) *sshPool {
+	return &sshPool{dial: dial, conns: make(map[string]*ssh.Client)}
+}
+
+// get returns the pooled connection to server, dialing it on first use.
+// The dial happens outside the lock, so a slow or unreachable server never
+// holds up connections to the others; when two callers dial the same
+// server at once, the first to finish is kept and the other closed.
+func (p *sshPool) get(ctx context.Context, server string, hostKeys hostKeyPolicy) (*ssh.Client, error) {
+	key := server + "\x00" + hostKeys.String()
+	p.mu.Lock()
+	c, ok := p.conns[key]
+	p.mu.Unlock()
+	if ok {
+		return c, nil
+	}
+	c, err := p.dial(ctx, server, hostKeys)
+	if err != nil {
+		return nil, fmt.Errorf("ssh: connect to %s: %w", server, err)
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if pooled, ok := p.conns[key]; ok {
+		c.Close()
+		return pooled, nil
+	}
+	p.conns[key] = c
+	return c, nil
+}
+
+// drop closes c and removes it from the pool.
+func (p *sshPool) drop(c *ssh.Client) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for key, pooled := range p.conns {
+		if pooled == c {
+			delete(p.conns, key)
+		}
+	}
+	c.Close()
+}
+
+// sshTarget is how to reach a server: the result of resolving its name
+// through ~/.ssh/config.
+type sshTarget struct {
+	addr          string // host:port
+	user          string
+	identityFiles []string
+	knownHosts    []string
+}
+
+// dialOpenSSHConfig resolves server with `ssh -G` (falling back to the
+// OpenSSH defaults when the ssh binary is unavailable) and connects,
+// authenticating with the SSH agent and any unencrypted identity files.
+func dialOpenSSHConfig(ctx context.Context, server string, policy hostKeyPolicy) (*ssh.Client, error) {
+	target, err := resolveSSHTarget(ctx, server)
+	if err != nil {
+		return nil, err
+	}
+	hostKeys, algos, err := policy.callback(target.knownHosts, target.addr)
+	if err != nil {
+		return nil, err
+	}
+	cfg := &ssh.ClientConfig{
+		User:              target.user,
+		Auth:              authMethods(target.identityFiles),
+		HostKeyCallback:   hostKeys,
+		HostKeyAlgorithms: algos,
+		Timeout:           30 * time.Second,
+	}
+
+	d := net.Dialer{Timeout: cfg.Timeout}
+	conn, err := d.DialContext(ctx, "tcp", target.addr)
+	if err != nil {
+		return nil, err
+	}
+	c, chans, reqs, err := ssh.NewClientConn(conn, target.addr, cfg)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return ssh.NewClient(c, chans, reqs), nil
+}
+
+// resolveSSHTarget asks `ssh -G` how it would connect to server.
+func resolveSSHTarget(ctx context.Context, server string) (sshTarget, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return sshTarget{}, err
+	}
+	out, err := exec.CommandContext(ctx, "ssh", "-G", server).Output()
+	if err != nil {
+		if !errors.Is(err, exec.ErrNotFound) {
+			return sshTarget{}, fmt.Errorf("ssh -G %s: %w", server, err)
+		}
+		return defaultSSHTarget(server, home)
+	}
+	return parseSSHConfigDump(string(out), home)
+}
+
+// parseSSHConfigDump reads the "key value" lines printed by `ssh -G`.
+func parseSSHConfigDump(out, home string) (sshTarget, error) {
+	var t sshTarget
+	host, port := "", "22"
+	scanner := bufio.NewScanner(strings.NewReader(out))
+	for scanner.Scan() {
+		key, value, ok := strings.Cut(strings.TrimSpace(scanner.Text()), " ")
+		if !ok {
+			continue
+		}
+		switch strings.ToLower(key) {
+		case "hostname":
+			host = value
+		case "port":
+			port = value
+		case "user":
+			t.user = value
+		case "identityfile":
+			t.identityFiles = append(t.identityFiles, expandHome(value, home))
+		case "userknownhostsfile":
+			for _, f := range strings.Fields(value) {
+				t.knownHosts = append(t.knownHosts, expandHome(f, home))
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return sshTarget{}, err
+	}
+	if host == "" {
+		return sshTarget{}, fmt.Errorf("ssh -G printed no hostname")
+	}
+	t.addr = net.JoinHostPort(host, port)
+	return t, nil
+}
+
+// defaultSSHTarget is the target OpenSSH would use for server ([user@]host)
+// with no ~/.ssh/config.
+func defaultSSHTarget(server, home string) (sshTarget, error) {
+	name, host, ok := strings.Cut(server, "@")
+	if !ok {
+		u, err := user.Current()
+		if err != nil {
+			return sshTarget{}, err
+		}
+		name, host = u.Username, server
+	}
+	dir := filepath.Join(home, ".ssh")
+	return sshTarget{
+		addr: net.JoinHostPort(host, "22"),
+		user: name,
+		identityFiles: []string{
+			filepath.Join(dir, "id_ed25519"),
+			filepath.Join(dir, "id_ecdsa"),
+			filepath.Join(dir, "id_rsa"),
+		},
+		knownHosts: []string{filepath.Join(dir, "known_hosts")},
+	}, nil
+}
+
+func expandHome(path, home string) string {
+	if rest, ok := strings.CutPrefix(path, "~/"); ok {
+		return filepath.Join(home, rest)
+	}
+	return path
+}
+
+// knownHostsCallback verifies host keys against those of files that exist.
+func knownHostsCallback(files []string) (ssh.HostKeyCallback, error) {
+	var existing []string
+	for _, f := range files {
+		if _, err := os.Stat(f); err == nil {
+			existing = append(existing, f)
+		}
+	}
+	if len(existing) == 0 {
+		return nil, fmt.Errorf("no known_hosts file found (looked in %s); connect once with ssh to record the host key", strings.Join(files, ", "))
+	}
+	return knownhosts.New(existing...)
+}
+
+// knownKeyAlgorithms returns the host key algorithms known_hosts has keys
+// of for addr, so the handshake asks for one of those instead of failing
+// on a server that prefers a type with no entry. Nil (library default)
+// when the host is not recorded.
+func knownKeyAlgorithms(hostKeys ssh.HostKeyCallback, addr string) []string {
+	var keyErr *knownhosts.KeyError
+	if err := hostKeys(addr, &net.TCPAddr{IP: net.IPv4zero}, placeholderKey{}); !errors.As(err, &keyErr) {
+		return nil
+	}
+	var algos []string
+	for _, k := range keyErr.Want {
+		if k.Key.Type() == ssh.KeyAlgoRSA {
+			algos = append(algos, ssh.KeyAlgoRSASHA512, ssh.KeyAlgoRSASHA256)
+		}
+		algos = append(algos, k.Key.Type())
+	}
+	return algos
+}
+
+// placeholderKey matches no known_hosts entry; probing with it makes the
+// callback report which keys it expects.
+type placeholderKey struct{}
+
+func (placeholderKey) Type() string                        { return "ssd-placeholder" }
+func (placeholderKey) Marshal() []byte                     { return []byte("ssd-placeholder") }
+func (placeholderKey) Verify([]byte, *ssh.Signature) error { return errors.New("placeholder key") }
+
+// authMethods offers the SSH agent's keys, then the identity files that
+// can be read without a passphrase (encrypted keys must be in the agent).
+func authMethods(identityFiles []string) []ssh.AuthMethod {
+	var methods []ssh.AuthMethod
+	if sock := os.Getenv("SSH_AUTH_SOCK"); sock != "" {
+		if conn, err := net.Dial("unix", sock); err == nil {
+			methods = append(methods, ssh.PublicKeysCallback(agent.NewClient(conn).Signers))
+		}
+	}
+	var signers []ssh.Signer
+	for _, f := range identityFiles {
+		data, err := os.ReadFile(f)
+		if err != nil {
+			continue
+		}
+		signer, err := ssh.ParsePrivateKey(data)
+		if err != nil {
+			continue
+		}
+		signers = append(signers, signer)
+	}
+	if len(signers) > 0 {
+		methods = append(methods, ssh.PublicKeys(signers...))
+	}
+	return methods
+}
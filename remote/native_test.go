@@ -0,0 +1,336 @@
+package remote
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh"
+)
+
+// testSSHServer is an in-process SSH server that runs each exec request
+// locally with sh -c, standing in for a deploy target.
+type testSSHServer struct {
+	addr    string
+	hostKey ssh.PublicKey
+	mu      sync.Mutex
+	conns   []*ssh.ServerConn
+}
+
+func startTestSSHServer(t *testing.T) *testSSHServer {
+	t.Helper()
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	signer, err := ssh.NewSignerFromKey(priv)
+	require.NoError(t, err)
+	cfg := &ssh.ServerConfig{NoClientAuth: true}
+	cfg.AddHostKey(signer)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { ln.Close() })
+
+	s := &testSSHServer{addr: ln.Addr().String(), hostKey: signer.PublicKey()}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn, cfg)
+		}
+	}()
+	return s
+}
+
+func (s *testSSHServer) serve(conn net.Conn, cfg *ssh.ServerConfig) {
+	sc, chans, reqs, err := ssh.NewServerConn(conn, cfg)
+	if err != nil {
+		return
+	}
+	s.mu.Lock()
+	s.conns = append(s.conns, sc)
+	s.mu.Unlock()
+	go ssh.DiscardRequests(reqs)
+	for nc := range chans {
+		if nc.ChannelType() != "session" {
+			if err := nc.Reject(ssh.UnknownChannelType, "session only"); err != nil {
+				return
+			}
+			continue
+		}
+		ch, chReqs, err := nc.Accept()
+		if err != nil {
+			continue
+		}
+		go serveSession(ch, chReqs)
+	}
+}
+
+func serveSession(ch ssh.Channel, reqs <-chan *ssh.Request) {
+	defer ch.Close()
+	for req := range reqs {
+		if req.Type != "exec" || len(req.Payload) < 4 {
+			if err := req.Reply(false, nil); err != nil {
+				return
+			}
+			continue
+		}
+		command := string(req.Payload[4:])
+		if err := req.Reply(true, nil); err != nil {
+			return
+		}
+
+		cmd := exec.Command("sh", "-c", command)
+		cmd.Stdout = ch
+		cmd.Stderr = ch.Stderr()
+		status := uint32(0)
+		if err := cmd.Run(); err != nil {
+			status = 1
+			var exitErr *exec.ExitError
+			if errors.As(err, &exitErr) {
+				status = uint32(exitErr.ExitCode())
+			}
+		}
+		payload := make([]byte, 4)
+		binary.BigEndian.PutUint32(payload, status)
+		if _, err := ch.SendRequest("exit-status", false, payload); err != nil {
+			return
+		}
+		return
+	}
+}
+
+// dropConnections closes every connection the server has accepted, as a
+// server restart would.
+func (s *testSSHServer) dropConnections() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, c := range s.conns {
+		c.Close()
+	}
+	s.conns = nil
+}
+
+// newTestNativeExecutor returns a NativeExecutor with its own pool that
+// dials srv for any server name, and a count of dials made.
+func newTestNativeExecutor(srv *testSSHServer) (*NativeExecutor, *atomic.Int32) {
+	var dials atomic.Int32
+	pool := newSSHPool(func(ctx context.Context, server string, _ hostKeyPolicy) (*ssh.Client, error) {
+		dials.Add(1)
+		return ssh.Dial("tcp", srv.addr, &ssh.ClientConfig{
+			User:            "deploy",
+			HostKeyCallback: ssh.FixedHostKey(srv.hostKey),
+			Timeout:         5 * time.Second,
+		})
+	})
+	return &NativeExecutor{pool: pool, local: NewRealExecutor()}, &dials
+}
+
+func TestNativeExecutor_SSHReusesOneConnection(t *testing.T) {
+	srv := startTestSSHServer(t)
+	executor, dials := newTestNativeExecutor(srv)
+	client := NewClientWithExecutor(newTestConfig(), executor)
+	ctx := context.Background()
+
+	for range 3 {
+		out, err := client.SSH(ctx, "echo hello")
+		require.NoError(t, err)
+		assert.Equal(t, "hello\n", out)
+	}
+	assert.Equal(t, int32(1), dials.Load())
+}
+
+func TestSSHPool_SlowDialDoesNotBlockOtherServers(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	pool := newSSHPool(func(ctx context.Context, server string, _ hostKeyPolicy) (*ssh.Client, error) {
+		if server == "slow" {
+			<-release
+		}
+		return nil, errors.New("connection refused")
+	})
+	go func() { _, _ = pool.get(context.Background(), "slow", hostKeyPolicy{}) }()
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := pool.get(context.Background(), "fast", hostKeyPolicy{})
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		assert.ErrorContains(t, err, "ssh: connect to fast")
+	case <-time.After(5 * time.Second):
+		t.Fatal("dialing fast waited for slow")
+	}
+}
+
+func TestSSHPool_ConcurrentDialsKeepOneConnection(t *testing.T) {
+	srv := startTestSSHServer(t)
+	var started sync.WaitGroup
+	started.Add(2)
+	pool := newSSHPool(func(ctx context.Context, server string, _ hostKeyPolicy) (*ssh.Client, error) {
+		// Both callers dial before either is pooled
+		started.Done()
+		started.Wait()
+		return ssh.Dial("tcp", srv.addr, &ssh.ClientConfig{
+			User:            "deploy",
+			HostKeyCallback: ssh.FixedHostKey(srv.hostKey),
+			Timeout:         5 * time.Second,
+		})
+	})
+
+	clients := make([]*ssh.Client, 2)
+	var wg sync.WaitGroup
+	for i := range clients {
+		wg.Go(func() {
+			c, err := pool.get(context.Background(), "srv", hostKeyPolicy{})
+			assert.NoError(t, err)
+			clients[i] = c
+		})
+	}
+	wg.Wait()
+
+	require.NotNil(t, clients[0])
+	assert.Same(t, clients[0], clients[1])
+	assert.Len(t, pool.conns, 1)
+}
+
+func TestNativeExecutor_CommandFailure(t *testing.T) {
+	srv := startTestSSHServer(t)
+	executor, _ := newTestNativeExecutor(srv)
+	client := NewClientWithExecutor(newTestConfig(), executor)
+
+	_, err := client.SSH(context.Background(), "echo boom >&2; exit 3")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "ssh command failed")
+	assert.Contains(t, err.Error(), "status 3")
+	assert.Contains(t, err.Error(), "boom")
+}
+
+func TestNativeExecutor_WriteFileAndTempDir(t *testing.T) {
+	srv := startTestSSHServer(t)
+	executor, dials := newTestNativeExecutor(srv)
+	client := NewClientWithExecutor(newTestConfig(), executor)
+	ctx := context.Background()
+
+	path := filepath.Join(t.TempDir(), "sub", "app.env")
+	content := []byte("KEY=value with spaces\nOTHER='quoted'\n")
+	require.NoError(t, client.WriteFile(ctx, path, content, 0o600))
+	got, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, content, got)
+
+	dir, err := client.MakeTempDir(ctx)
+	require.NoError(t, err)
+	assert.DirExists(t, dir)
+	require.NoError(t, client.Cleanup(ctx, dir))
+	assert.NoDirExists(t, dir)
+
+	assert.Equal(t, int32(1), dials.Load())
+}
+
+func TestNativeExecutor_RedialsDroppedConnection(t *testing.T) {
+	srv := startTestSSHServer(t)
+	executor, dials := newTestNativeExecutor(srv)
+	client := NewClientWithExecutor(newTestConfig(), executor)
+	ctx := context.Background()
+
+	_, err := client.SSH(ctx, "true")
+	require.NoError(t, err)
+	srv.dropConnections()
+
+	require.Eventually(t, func() bool {
+		out, err := client.SSH(ctx, "echo again")
+		return err == nil && out == "again\n"
+	}, 5*time.Second, 50*time.Millisecond)
+	assert.Equal(t, int32(2), dials.Load())
+}
+
+func TestNativeExecutor_ContextCancel(t *testing.T) {
+	srv := startTestSSHServer(t)
+	executor, _ := newTestNativeExecutor(srv)
+	client := NewClientWithExecutor(newTestConfig(), executor)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err := client.SSH(ctx, "sleep 5")
+	require.Error(t, err)
+	assert.Less(t, time.Since(start), 4*time.Second)
+}
+
+func TestNativeExecutor_NonSSHRunsLocally(t *testing.T) {
+	executor := &NativeExecutor{pool: newSSHPool(func(context.Context, string, hostKeyPolicy) (*ssh.Client, error) {
+		return nil, errors.New("must not dial")
+	}), local: NewRealExecutor()}
+
+	out, err := executor.Run(context.Background(), "echo", "local")
+	require.NoError(t, err)
+	assert.Equal(t, "local\n", out)
+}
+
+func TestNewClient_SSHClientSelectsExecutor(t *testing.T) {
+	cfg := newTestConfig()
+	assert.IsType(t, &RealExecutor{}, NewClient(cfg).executor)
+
+	cfg.SSHClient = "native"
+	assert.IsType(t, &NativeExecutor{}, NewClient(cfg).executor)
+}
+
+func TestParseSSHConfigDump(t *testing.T) {
+	out := "host web\nhostname 203.0.113.5\nport 2222\nuser deploy\n" +
+		"identityfile ~/.ssh/id_ed25519\nidentityfile /keys/id_rsa\n" +
+		"userknownhostsfile ~/.ssh/known_hosts ~/.ssh/known_hosts2\n"
+	got, err := parseSSHConfigDump(out, "/home/me")
+	require.NoError(t, err)
+	assert.Equal(t, sshTarget{
+		addr:          "203.0.113.5:2222",
+		user:          "deploy",
+		identityFiles: []string{"/home/me/.ssh/id_ed25519", "/keys/id_rsa"},
+		knownHosts:    []string{"/home/me/.ssh/known_hosts", "/home/me/.ssh/known_hosts2"},
+	}, got)
+
+	_, err = parseSSHConfigDump("user deploy\n", "/home/me")
+	assert.Error(t, err)
+}
+
+func TestDefaultSSHTarget(t *testing.T) {
+	got, err := defaultSSHTarget("ops@example.com", "/home/me")
+	require.NoError(t, err)
+	assert.Equal(t, "example.com:22", got.addr)
+	assert.Equal(t, "ops", got.user)
+	assert.Equal(t, []string{"/home/me/.ssh/known_hosts"}, got.knownHosts)
+}
+
+func TestKnownHosts(t *testing.T) {
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	signer, err := ssh.NewSignerFromKey(priv)
+	require.NoError(t, err)
+
+	dir := t.TempDir()
+	_, err = knownHostsCallback([]string{filepath.Join(dir, "missing")})
+	require.ErrorContains(t, err, "no known_hosts file found")
+
+	file := filepath.Join(dir, "known_hosts")
+	line := "[example.com]:2222 " + string(ssh.MarshalAuthorizedKey(signer.PublicKey()))
+	require.NoError(t, os.WriteFile(file, []byte(line), 0o600))
+	cb, err := knownHostsCallback([]string{filepath.Join(dir, "missing"), file})
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{ssh.KeyAlgoED25519}, knownKeyAlgorithms(cb, "example.com:2222"))
+	assert.Nil(t, knownKeyAlgorithms(cb, "other.example.com:22"))
+}
@@ -0,0 +1,72 @@
+package remote
+
+import (
+	"bytes"
+	"io"
+	"sync"
+)
+
+// OutputPrefixer is implemented by clients that can tag every line of the
+// command output they stream to the terminal, e.g. with "[web] " so
+// deploy-all output shows which service it belongs to.
+type OutputPrefixer interface {
+	SetOutputPrefix(prefix string)
+}
+
+var _ OutputPrefixer = (*Client)(nil)
+
+// PrefixWriter writes each line it receives to the underlying writer with
+// a prefix. A trailing partial line is held until its newline arrives or
+// Flush is called, so a line split across writes is prefixed only once.
+type PrefixWriter struct {
+	mu      sync.Mutex
+	w       io.Writer
+	prefix  []byte
+	partial []byte
+}
+
+// NewPrefixWriter returns a PrefixWriter that tags lines written to w.
+func NewPrefixWriter(w io.Writer, prefix string) *PrefixWriter {
+	return &PrefixWriter{w: w, prefix: []byte(prefix)}
+}
+
+// Write implements io.Writer. It always consumes all of p unless the
+// underlying writer fails.
+func (p *PrefixWriter) Write(b []byte) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.partial = append(p.partial, b...)
+	for {
+		i := bytes.IndexByte(p.partial, '\n')
+		if i < 0 {
+			break
+		}
+		if err := p.writeLine(p.partial[:i+1]); err != nil {
+			return 0, err
+		}
+		p.partial = p.partial[i+1:]
+	}
+	return len(b), nil
+}
+
+// Flush writes a held partial line, terminated with a newline.
+func (p *PrefixWriter) Flush() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if len(p.partial) == 0 {
+		return nil
+	}
+	line := append(p.partial, '\n')
+	p.partial = nil
+	return p.writeLine(line)
+}
+
+func (p *PrefixWriter) writeLine(line []byte) error {
+	out := make([]byte, 0, len(p.prefix)+len(line))
+	out = append(out, p.prefix...)
+	out = append(out, line...)
+	_, err := p.w.Write(out)
+	return err
+}
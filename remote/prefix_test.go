@@ -0,0 +1,72 @@
+package remote
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPrefixWriter_TagsEachLine(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewPrefixWriter(&buf, "[web] ")
+
+	n, err := w.Write([]byte("Step 1/3\nStep 2/3\n"))
+
+	require.NoError(t, err)
+	assert.Equal(t, 18, n)
+	assert.Equal(t, "[web] Step 1/3\n[web] Step 2/3\n", buf.String())
+}
+
+func TestPrefixWriter_HoldsPartialLine(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewPrefixWriter(&buf, "[api] ")
+
+	_, err := w.Write([]byte("Sending build"))
+	require.NoError(t, err)
+	assert.Empty(t, buf.String(), "partial line is held until its newline")
+
+	_, err = w.Write([]byte(" context\nDone"))
+	require.NoError(t, err)
+	assert.Equal(t, "[api] Sending build context\n", buf.String())
+
+	require.NoError(t, w.Flush())
+	assert.Equal(t, "[api] Sending build context\n[api] Done\n", buf.String())
+
+	require.NoError(t, w.Flush())
+	assert.Equal(t, "[api] Sending build context\n[api] Done\n", buf.String(), "second flush writes nothing")
+}
+
+func TestPrefixWriter_EmptyLines(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewPrefixWriter(&buf, "[db] ")
+
+	_, err := w.Write([]byte("\n\n"))
+
+	require.NoError(t, err)
+	assert.Equal(t, "[db] \n[db] \n", buf.String())
+}
+
+type failingWriter struct{}
+
+func (failingWriter) Write([]byte) (int, error) { return 0, errors.New("broken pipe") }
+
+func TestPrefixWriter_PropagatesWriteError(t *testing.T) {
+	w := NewPrefixWriter(failingWriter{}, "[web] ")
+
+	_, err := w.Write([]byte("line\n"))
+
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "broken pipe")
+}
+
+func TestClient_SetOutputPrefix(t *testing.T) {
+	exec := NewRealExecutor()
+	client := &Client{executor: exec}
+
+	client.SetOutputPrefix("[web] ")
+
+	assert.Equal(t, "[web] ", exec.Prefix)
+}
@@ -3,17 +3,26 @@ package remote
 import (
 	"context"
 	"encoding/base64"
+	"errors"
 	"fmt"
+	"maps"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"regexp"
+	"slices"
 	"strconv"
 	"strings"
+	"time"
 	"unicode/utf8"
 
 	"al.essio.dev/pkg/shellescape"
 	"github.com/byteink/ssd/config"
+	"github.com/byteink/ssd/history"
+	"github.com/byteink/ssd/images"
+	"github.com/byteink/ssd/internal/clock"
+	"github.com/byteink/ssd/logs"
+	"github.com/byteink/ssd/stacks"
 )
 
 // RemoteClient defines the interface for remote operations
@@ -25,8 +34,9 @@ type RemoteClient interface {
 	BuildImage(ctx context.Context, buildDir string, version int) error
 	UpdateManifest(ctx context.Context, version int) error
 	RestartStack(ctx context.Context) error
-	GetContainerStatus(ctx context.Context) (string, error)
-	GetLogs(ctx context.Context, follow bool, tail int) error
+	Down(ctx context.Context, removeVolumes bool) error
+	GetContainerStatus(ctx context.Context, service string) (string, error)
+	GetLogs(ctx context.Context, opts logs.Options) error
 	Cleanup(ctx context.Context, path string) error
 	MakeTempDir(ctx context.Context) (string, error)
 	StackExists(ctx context.Context) (bool, error)
@@ -39,11 +49,25 @@ type RemoteClient interface {
 	UploadEnvFile(ctx context.Context, serviceName, localPath string) error
 	SetEnvVar(ctx context.Context, serviceName, key, value string) error
 	RemoveEnvVar(ctx context.Context, serviceName, key string) error
+	WriteEnvFile(ctx context.Context, serviceName, content string) error
 	CreateStack(ctx context.Context, composeContent string) error
 	PullImage(ctx context.Context, image string) error
+	ImageDigest(ctx context.Context, image string) (string, error)
+	ImageInfo(ctx context.Context, image string) (images.Info, error)
 	StartService(ctx context.Context, serviceName string) error
+	StopService(ctx context.Context, serviceName string) error
+	RunJob(ctx context.Context, serviceName string) error
+	WaitForHealthy(ctx context.Context, serviceName string, timeout, grace time.Duration) error
 	RolloutService(ctx context.Context, serviceName string) error
 	CopyFiles(ctx context.Context, files map[string]string) error
+	AppendHistory(ctx context.Context, serviceName string, version int) error
+	ReadHistory(ctx context.Context) ([]history.Entry, error)
+	SourceTree(ctx context.Context) (string, error)
+	UncommittedChanges(ctx context.Context) ([]string, error)
+	DeployedSourceTree(ctx context.Context, serviceName string) (string, error)
+	RecordSourceTree(ctx context.Context, serviceName, tree string) error
+	ListStacks(ctx context.Context) ([]stacks.Stack, error)
+	SyncSchedule(ctx context.Context) error
 }
 
 // Ensure Client implements RemoteClient
@@ -55,13 +79,45 @@ type Client struct {
 	cfg           *config.Config
 	executor      CommandExecutor
 	findGitRoot   func(string) (string, error)
-	sshArgs       []string // Extra SSH args (e.g., ControlMaster options)
+	clock         clock.Clock // times WaitForHealthy
+	sshArgs       []string    // Extra SSH args (e.g., ControlMaster options)
 	composeCache  string
 	composeCached bool
+	rolloutReady  bool // docker rollout plugin verified on the server
+	// pinnedHostKey is host_key (public key form) for the openssh client;
+	// knownHostsFile holds it, written by prepareHostKey.
+	pinnedHostKey   string
+	knownHostsFile  string
+	knownHostsErr   error
+	knownHostsReady bool
+	run             RunOptions // deploy flags for this run; see SetRunOptions
 }
 
-// defaultGitRoot finds the git repository root for the given directory
-func defaultGitRoot(dir string) (string, error) {
+// RunOptions are deploy settings chosen on the command line for a single
+// run rather than in ssd.yaml.
+type RunOptions struct {
+	// NoForceRecreate is --force-recreate=false: StartService lets compose
+	// recreate the container only when its image or config changed.
+	NoForceRecreate bool
+	// PullBase is --pull: build with --pull even when build.pull is off.
+	PullBase bool
+	// QuietBuild is --quiet-build: build with --quiet and capture the
+	// output, showing it only when the build fails.
+	QuietBuild bool
+	// BuildArgs are --build-arg values, applied over build_args.
+	BuildArgs map[string]string
+}
+
+// RunConfigurer is implemented by clients that take RunOptions. Deploys
+// hand the options over before the client builds or starts anything.
+type RunConfigurer interface {
+	SetRunOptions(opts RunOptions)
+}
+
+var _ RunConfigurer = (*Client)(nil)
+
+// GitRoot finds the git repository root for the given directory
+func GitRoot(dir string) (string, error) {
 	cmd := exec.Command("git", "-C", dir, "rev-parse", "--show-toplevel")
 	out, err := cmd.Output()
 	if err != nil {
@@ -70,19 +126,124 @@ func defaultGitRoot(dir string) (string, error) {
 	return strings.TrimSpace(string(out)), nil
 }
 
-// NewClient creates a new remote client with the default executor
+// ChangedFiles lists the files that changed between ref and HEAD in the
+// repository at gitRoot (`git diff --name-only ref...HEAD`, i.e. since
+// their merge base), as slash-separated paths relative to gitRoot.
+func ChangedFiles(gitRoot, ref string) ([]string, error) {
+	if ref == "" || strings.HasPrefix(ref, "-") {
+		return nil, fmt.Errorf("invalid git ref %q", ref)
+	}
+	cmd := exec.Command("git", "-C", gitRoot, "diff", "--name-only", ref+"...HEAD", "--")
+	out, err := cmd.Output()
+	if err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) && len(exitErr.Stderr) > 0 {
+			return nil, fmt.Errorf("git diff %s...HEAD failed: %s", ref, strings.TrimSpace(string(exitErr.Stderr)))
+		}
+		return nil, fmt.Errorf("git diff %s...HEAD failed: %w", ref, err)
+	}
+	var files []string
+	for _, line := range strings.Split(string(out), "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			files = append(files, line)
+		}
+	}
+	return files, nil
+}
+
+// GitRootFor returns how the repository a build context is archived from
+// is found for cfg: cfg.ProjectDir when set, otherwise GitRoot. The
+// override pins the repository boundary where GitRoot would pick a nested
+// repository or submodule.
+func GitRootFor(cfg *config.Config) func(string) (string, error) {
+	if cfg == nil || cfg.ProjectDir == "" {
+		return GitRoot
+	}
+	return func(dir string) (string, error) {
+		return ProjectRoot(cfg.ProjectDir, dir)
+	}
+}
+
+// ProjectRoot returns projectDir, made absolute, as the git repository
+// root for dir. projectDir must hold a .git (a directory, or a file for a
+// submodule or worktree) and contain dir.
+func ProjectRoot(projectDir, dir string) (string, error) {
+	root, err := filepath.Abs(projectDir)
+	if err != nil {
+		return "", fmt.Errorf("invalid project_dir %q: %w", projectDir, err)
+	}
+	if _, err := os.Stat(filepath.Join(root, ".git")); err != nil {
+		return "", fmt.Errorf("project_dir %s is not a git repository root (no .git)", root)
+	}
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve %s: %w", dir, err)
+	}
+	if _, ok := within(root, abs); !ok {
+		return "", fmt.Errorf("%s is outside project_dir %s", abs, root)
+	}
+	return root, nil
+}
+
+// SetRunOptions sets the deploy flags of this run.
+func (c *Client) SetRunOptions(opts RunOptions) {
+	c.run = opts
+}
+
+// SetOutputPrefix tags every line of streamed command output (builds,
+// rsync, docker rollout) with prefix. Only the real executors stream to
+// the terminal, so test executors are left alone.
+func (c *Client) SetOutputPrefix(prefix string) {
+	switch e := c.executor.(type) {
+	case *RealExecutor:
+		e.Prefix = prefix
+	case *NativeExecutor:
+		e.Prefix = prefix
+	}
+}
+
+// NewClient creates a new remote client. Commands shell out to the ssh
+// binary unless ssh_client is native, which runs them over one in-process
+// connection (see NativeExecutor). The ssh args still apply to the
+// git archive | ssh pipeline behind Rsync.
 func NewClient(cfg *config.Config) *Client {
-	return &Client{
+	var executor CommandExecutor = NewRealExecutor()
+	if cfg.SSHClient == config.SSHClientNative {
+		executor = NewNativeExecutor(cfg)
+	}
+	c := &Client{
 		server:      cfg.Server,
 		cfg:         cfg,
-		executor:    NewRealExecutor(),
-		findGitRoot: defaultGitRoot,
-		sshArgs: []string{
-			"-o", "ControlMaster=auto",
-			"-o", "ControlPath=/tmp/ssd-%C",
-			"-o", "ControlPersist=60s",
-		},
+		executor:    executor,
+		findGitRoot: GitRootFor(cfg),
+		clock:       clock.Real{},
+	}
+	if cfg.HostKey != "" && !config.IsHostKeyFingerprint(cfg.HostKey) {
+		c.pinnedHostKey = cfg.HostKey
+		c.knownHostsFile, c.knownHostsErr = pinnedKnownHostsPath(cfg.HostKey)
+	}
+	c.sshArgs = append([]string{
+		"-o", "ControlMaster=auto",
+		"-o", "ControlPath=/tmp/ssd-%C",
+		"-o", "ControlPersist=60s",
+	}, hostKeyArgs(cfg, c.knownHostsFile)...)
+	return c
+}
+
+// prepareHostKey writes the known_hosts file for a pinned host_key before
+// the first ssh invocation that relies on it.
+func (c *Client) prepareHostKey() error {
+	if c.pinnedHostKey == "" || c.knownHostsReady {
+		return nil
+	}
+	if c.knownHostsErr != nil {
+		return c.knownHostsErr
+	}
+	if err := writePinnedKnownHosts(c.knownHostsFile, c.pinnedHostKey); err != nil {
+		return err
 	}
+	c.knownHostsReady = true
+	return nil
 }
 
 // NewSSHClient creates a client for SSH-only operations (no config required).
@@ -91,6 +252,7 @@ func NewSSHClient(server string) *Client {
 	return &Client{
 		server:   server,
 		executor: NewRealExecutor(),
+		clock:    clock.Real{},
 		sshArgs: []string{
 			"-o", "ControlMaster=auto",
 			"-o", "ControlPath=/tmp/ssd-%C",
@@ -105,12 +267,16 @@ func NewClientWithExecutor(cfg *config.Config, executor CommandExecutor) *Client
 		server:      cfg.Server,
 		cfg:         cfg,
 		executor:    executor,
-		findGitRoot: defaultGitRoot,
+		findGitRoot: GitRootFor(cfg),
+		clock:       clock.Real{},
 	}
 }
 
 // SSH executes a command on the remote server
 func (c *Client) SSH(ctx context.Context, command string) (string, error) {
+	if err := c.prepareHostKey(); err != nil {
+		return "", err
+	}
 	args := append(c.sshArgs, c.server, command)
 	output, err := c.executor.Run(ctx, "ssh", args...)
 	if err != nil {
@@ -119,9 +285,31 @@ func (c *Client) SSH(ctx context.Context, command string) (string, error) {
 	return output, nil
 }
 
+// SSHCaptured runs an SSH command like SSH, with its output captured
+// rather than streamed, but allows it to run as long as SSHInteractive
+// when the executor is a CapturedRunner.
+func (c *Client) SSHCaptured(ctx context.Context, command string) (string, error) {
+	if err := c.prepareHostKey(); err != nil {
+		return "", err
+	}
+	args := append(c.sshArgs, c.server, command)
+	run := c.executor.Run
+	if runner, ok := c.executor.(CapturedRunner); ok {
+		run = runner.RunCaptured
+	}
+	output, err := run(ctx, "ssh", args...)
+	if err != nil {
+		return "", fmt.Errorf("ssh command failed: %w", err)
+	}
+	return output, nil
+}
+
 // SSHInteractive runs an SSH command with output streamed to terminal.
 // Output is streamed in real time via stdout/stderr passthrough.
 func (c *Client) SSHInteractive(ctx context.Context, command string) error {
+	if err := c.prepareHostKey(); err != nil {
+		return err
+	}
 	args := append(c.sshArgs, c.server, command)
 	return c.executor.RunInteractive(ctx, "ssh", args...)
 }
@@ -147,17 +335,31 @@ func (c *Client) Rsync(ctx context.Context, localPath, remotePath string) error
 	// Build tar extract command (runs on remote via SSH)
 	extractCmd := fmt.Sprintf("tar xf - -C %s", shellescape.Quote(remotePath))
 
-	// If context is a subdirectory, archive only that path and strip prefix
-	if relPath != "." {
+	layout, err := c.layout(localPath)
+	if err != nil {
+		return err
+	}
+
+	// A Dockerfile outside the context ships both paths unstripped;
+	// otherwise a subdirectory context is archived alone and its prefix stripped
+	if len(layout.archive) > 0 {
+		archiveCmd += " --"
+		for _, p := range layout.archive {
+			archiveCmd += " " + shellescape.Quote(p)
+		}
+	} else if relPath != "." {
 		archiveCmd += fmt.Sprintf(" -- %s", shellescape.Quote(relPath))
 		stripN := strings.Count(relPath, string(filepath.Separator)) + 1
 		extractCmd += fmt.Sprintf(" --strip-components=%d", stripN)
 	}
 
 	// Pipeline: git archive | ssh [opts] server 'tar extract'
+	if err := c.prepareHostKey(); err != nil {
+		return err
+	}
 	sshCmd := "ssh"
-	if len(c.sshArgs) > 0 {
-		sshCmd += " " + strings.Join(c.sshArgs, " ")
+	for _, arg := range c.sshArgs {
+		sshCmd += " " + shellescape.Quote(arg)
 	}
 	pipeline := fmt.Sprintf("%s | %s %s %s",
 		archiveCmd,
@@ -168,14 +370,14 @@ func (c *Client) Rsync(ctx context.Context, localPath, remotePath string) error
 	return c.executor.RunInteractive(ctx, "bash", "-c", pipeline)
 }
 
-// ReadManifest reads the current compose.yaml content from the remote server.
+// ReadManifest reads the current compose file content from the remote server.
 // Returns empty string (no error) if the file does not exist.
 // Results are cached per Client instance; writes via CreateStack/UpdateManifest invalidate the cache.
 func (c *Client) ReadManifest(ctx context.Context) (string, error) {
 	if c.composeCached {
 		return c.composeCache, nil
 	}
-	composePath := filepath.Join(c.cfg.StackPath(), "compose.yaml")
+	composePath := filepath.Join(c.cfg.StackPath(), c.cfg.ComposeFilename())
 	output, err := c.SSH(ctx, fmt.Sprintf("cat %s 2>/dev/null || echo ''", shellescape.Quote(composePath)))
 	if err != nil {
 		return "", nil
@@ -204,6 +406,13 @@ func ParseVersionFromContent(content, imageName string) (int, error) {
 	return 0, nil
 }
 
+// VersionedImagePattern returns the sed regular expression matching
+// imageName with any numeric version tag. Dots in registry hosts are
+// escaped so they only match themselves.
+func VersionedImagePattern(imageName string) string {
+	return strings.ReplaceAll(imageName, ".", `\.`) + ":[0-9][0-9]*"
+}
+
 // GetCurrentVersion reads the current image version from compose.yaml on the server.
 // Reuses cached compose content from ReadManifest when available.
 func (c *Client) GetCurrentVersion(ctx context.Context) (int, error) {
@@ -211,41 +420,88 @@ func (c *Client) GetCurrentVersion(ctx context.Context) (int, error) {
 	if err != nil {
 		return 0, nil
 	}
-	project := filepath.Base(c.cfg.Stack)
-	imageName := fmt.Sprintf("ssd-%s-%s", project, c.cfg.Name)
+	imageName := c.cfg.BuiltImageName()
 	return ParseVersionFromContent(content, imageName)
 }
 
+// BuildFlags returns the optional build flags shared by every runtime's
+// build command (--pull, --quiet, --target, --platform, --build-arg,
+// --ssh, --secret), each with a leading space. Build args are sorted so the
+// command is stable; ssh and secret specs keep their config order.
+// Returns "" when none apply.
+func BuildFlags(cfg *config.Config, run RunOptions) string {
+	flags := ""
+	if cfg.PullBaseImages(run.PullBase) {
+		flags += " --pull"
+	}
+	if run.QuietBuild {
+		flags += " --quiet"
+	}
+	if cfg.Target != "" {
+		flags += " --target " + shellescape.Quote(cfg.Target)
+	}
+	if cfg.Platform != "" {
+		flags += " --platform " + shellescape.Quote(cfg.Platform)
+	}
+	buildArgs := cfg.ResolvedBuildArgs(run.BuildArgs)
+	for _, key := range slices.Sorted(maps.Keys(buildArgs)) {
+		flags += " --build-arg " + shellescape.Quote(key+"="+buildArgs[key])
+	}
+	if cfg.Build != nil {
+		for _, spec := range cfg.Build.SSH {
+			flags += " --ssh " + shellescape.Quote(spec)
+		}
+		for _, spec := range cfg.Build.Secrets {
+			flags += " --secret " + shellescape.Quote(spec)
+		}
+	}
+	return flags
+}
+
 // BuildImage builds a Docker image on the remote server
 func (c *Client) BuildImage(ctx context.Context, buildDir string, version int) error {
 	imageTag := fmt.Sprintf("%s:%d", c.cfg.ImageName(), version)
 
-	// Build command with dockerfile path relative to build context
-	dockerfile := strings.TrimPrefix(c.cfg.Dockerfile, "./")
+	contextDir, dockerfile, err := c.BuildPaths()
+	if err != nil {
+		return err
+	}
 
-	targetFlag := ""
-	if c.cfg.Target != "" {
-		targetFlag = " --target " + shellescape.Quote(c.cfg.Target)
+	// BuildKit is opt-in via env on the remote side; older engines
+	// default to the legacy builder.
+	envPrefix := ""
+	if c.cfg.UseBuildKit() {
+		envPrefix = "DOCKER_BUILDKIT=1 "
 	}
 
-	cmd := fmt.Sprintf("cd %s && docker build -t %s -f %s%s .", shellescape.Quote(buildDir), shellescape.Quote(imageTag), shellescape.Quote(dockerfile), targetFlag)
-	return c.SSHInteractive(ctx, cmd)
+	cmd := fmt.Sprintf("cd %s && %sdocker build -t %s -f %s%s %s", shellescape.Quote(buildDir), envPrefix, shellescape.Quote(imageTag), shellescape.Quote(dockerfile), BuildFlags(c.cfg, c.run), shellescape.Quote(contextDir))
+	return c.RunBuild(ctx, cmd)
+}
+
+// RunBuild runs a build command on the server, streaming its output, or
+// with QuietBuild (deploy --quiet-build) capturing it so it only shows up
+// in the error of a failed build.
+func (c *Client) RunBuild(ctx context.Context, cmd string) error {
+	if !c.run.QuietBuild {
+		return c.SSHInteractive(ctx, cmd)
+	}
+	_, err := c.SSHCaptured(ctx, cmd)
+	return err
 }
 
-// UpdateManifest updates the image tag in compose.yaml via server-side sed.
+// UpdateManifest updates the image tag in the compose file via server-side sed.
 // Single SSH call instead of read-modify-write.
 func (c *Client) UpdateManifest(ctx context.Context, version int) error {
-	composePath := filepath.Join(c.cfg.StackPath(), "compose.yaml")
+	composePath := filepath.Join(c.cfg.StackPath(), c.cfg.ComposeFilename())
 	newImage := fmt.Sprintf("%s:%d", c.cfg.ImageName(), version)
-	project := filepath.Base(c.cfg.Stack)
 
 	// sed pattern: replace ssd-project-service:NNN with new image tag
 	// Uses | as delimiter to avoid conflicts with path separators
-	oldPattern := fmt.Sprintf("ssd-%s-%s:[0-9][0-9]*", project, c.cfg.Name)
+	oldPattern := VersionedImagePattern(c.cfg.BuiltImageName())
 	cmd := fmt.Sprintf("sed -i 's|%s|%s|g' %s", oldPattern, newImage, shellescape.Quote(composePath))
 
 	if _, err := c.SSH(ctx, cmd); err != nil {
-		return fmt.Errorf("failed to update compose.yaml: %w", err)
+		return fmt.Errorf("failed to update %s: %w", c.cfg.ComposeFilename(), err)
 	}
 
 	c.composeCached = false
@@ -255,34 +511,83 @@ func (c *Client) UpdateManifest(ctx context.Context, version int) error {
 // RestartStack runs docker compose up -d in the stack directory
 func (c *Client) RestartStack(ctx context.Context) error {
 	stackPath := c.cfg.StackPath()
-	cmd := fmt.Sprintf("cd %s && docker compose up -d", shellescape.Quote(stackPath))
+	cmd := fmt.Sprintf("cd %s && %s%s up -d", shellescape.Quote(stackPath), ComposeCommand(c.cfg), c.profileArgs())
 	return c.SSHInteractive(ctx, cmd)
 }
 
-// GetContainerStatus returns the status of the container
-func (c *Client) GetContainerStatus(ctx context.Context) (string, error) {
-	// Try to find container by compose project name
+// ComposeCommand returns the docker compose invocation for the service's
+// stack: plain "docker compose" for the default compose.yaml, otherwise
+// with -f naming compose_filename.
+func ComposeCommand(cfg *config.Config) string {
+	if cfg.ComposeFilename() == config.DefaultComposeFilename {
+		return "docker compose"
+	}
+	return "docker compose -f " + shellescape.Quote(cfg.ComposeFilename())
+}
+
+// profileArgs returns the --profile flags for the selected compose
+// profiles, with a leading space, or "" when none are selected.
+func (c *Client) profileArgs() string {
+	var b strings.Builder
+	for _, p := range c.cfg.ActiveProfiles {
+		b.WriteString(" --profile ")
+		b.WriteString(shellescape.Quote(p))
+	}
+	return b.String()
+}
+
+// Down stops and removes every container and network in the stack.
+// Named volumes are kept unless removeVolumes is set.
+func (c *Client) Down(ctx context.Context, removeVolumes bool) error {
 	stackPath := c.cfg.StackPath()
-	cmd := fmt.Sprintf("cd %s && docker compose ps --format '{{.Name}}\\t{{.Status}}'", shellescape.Quote(stackPath))
+	cmd := fmt.Sprintf("cd %s && %s down", shellescape.Quote(stackPath), ComposeCommand(c.cfg))
+	if removeVolumes {
+		cmd += " -v"
+	}
+	return c.SSHInteractive(ctx, cmd)
+}
+
+// GetContainerStatus returns the status of service's containers, or of
+// every container in the stack when service is empty.
+func (c *Client) GetContainerStatus(ctx context.Context, service string) (string, error) {
+	stackPath := c.cfg.StackPath()
+	cmd := fmt.Sprintf("cd %s && %s ps --format '{{.Name}}\\t{{.Status}}'", shellescape.Quote(stackPath), ComposeCommand(c.cfg))
+	if service != "" {
+		cmd += " " + shellescape.Quote(service)
+	}
 	return c.SSH(ctx, cmd)
 }
 
 // GetLogs returns logs from the container
-func (c *Client) GetLogs(ctx context.Context, follow bool, tail int) error {
+func (c *Client) GetLogs(ctx context.Context, opts logs.Options) error {
 	stackPath := c.cfg.StackPath()
 
 	tailArg := ""
-	if tail > 0 {
-		tailArg = fmt.Sprintf("--tail %d", tail)
+	if opts.Tail != logs.TailAll {
+		tailArg = fmt.Sprintf("--tail %d", opts.Tail)
 	}
 
 	followArg := ""
-	if follow {
+	if opts.Follow {
 		followArg = "-f"
 	}
 
-	cmd := fmt.Sprintf("cd %s && docker compose logs %s %s", shellescape.Quote(stackPath), followArg, tailArg)
-	return c.SSHInteractive(ctx, cmd)
+	logsCmd := fmt.Sprintf("%s logs %s %s", ComposeCommand(c.cfg), followArg, tailArg)
+	if !opts.Since.IsZero() {
+		logsCmd += " --since " + opts.Since.UTC().Format(time.RFC3339)
+	}
+	if opts.Grep != "" {
+		logsCmd = GrepLines(logsCmd, opts.Grep)
+	}
+	return c.SSHInteractive(ctx, fmt.Sprintf("cd %s && %s", shellescape.Quote(stackPath), logsCmd))
+}
+
+// GrepLines returns cmd with its standard output filtered through grep
+// for pattern; errors on stderr still show. grep is line-buffered so a
+// followed log streams as lines arrive. No matching line is not an error;
+// grep's own errors (status 2, e.g. a bad pattern) still fail the command.
+func GrepLines(cmd, pattern string) string {
+	return fmt.Sprintf("{ %s | grep --line-buffered -e %s || [ $? -eq 1 ]; }", cmd, shellescape.Quote(pattern))
 }
 
 // Cleanup removes a directory on the remote server
@@ -303,10 +608,10 @@ func (c *Client) MakeTempDir(ctx context.Context) (string, error) {
 	return strings.TrimSpace(output), nil
 }
 
-// StackExists checks if the stack directory and compose.yaml exist on the remote server
+// StackExists checks if the stack directory and its compose file exist on the remote server
 func (c *Client) StackExists(ctx context.Context) (bool, error) {
 	stackPath := c.cfg.StackPath()
-	composePath := filepath.Join(stackPath, "compose.yaml")
+	composePath := filepath.Join(stackPath, c.cfg.ComposeFilename())
 
 	cmd := fmt.Sprintf("test -d %s && test -f %s && echo yes || echo no",
 		shellescape.Quote(stackPath),
@@ -323,8 +628,9 @@ func (c *Client) StackExists(ctx context.Context) (bool, error) {
 // IsServiceRunning checks if a service is running in the stack
 func (c *Client) IsServiceRunning(ctx context.Context, serviceName string) (bool, error) {
 	stackPath := c.cfg.StackPath()
-	cmd := fmt.Sprintf("cd %s && docker compose ps --format json %s",
+	cmd := fmt.Sprintf("cd %s && %s ps --format json %s",
 		shellescape.Quote(stackPath),
+		ComposeCommand(c.cfg),
 		shellescape.Quote(serviceName))
 
 	output, err := c.SSH(ctx, cmd)
@@ -383,6 +689,55 @@ func (c *Client) CreateEnvFiles(ctx context.Context, serviceNames []string) erro
 	return err
 }
 
+// writeChunkSize is how many bytes of file content WriteFile sends per
+// SSH command. Base64 grows it to 64 KiB, well under Linux's 128 KiB limit
+// on a single argument (the remote shell receives the command as one).
+const writeChunkSize = 48 * 1024
+
+// WriteFile writes content to path on the server byte-for-byte, creating
+// its directory and giving it mode. Content travels base64-encoded, so
+// quotes, backslashes and $ never meet the shell, and in chunks of
+// writeChunkSize, so large files don't hit the argument size limit. It is
+// written to path.tmp and renamed into place, so path is never seen
+// truncated or half-written, even when a chunk fails.
+func (c *Client) WriteFile(ctx context.Context, path string, content []byte, mode os.FileMode) error {
+	return c.writeFile(ctx, path+".tmp", content, mode, path)
+}
+
+// WriteFileInPlace is WriteFile without the rename: content goes straight
+// to path. It is for files that are themselves candidates, like a compose
+// file written next to the real one, validated, then moved into place.
+func (c *Client) WriteFileInPlace(ctx context.Context, path string, content []byte, mode os.FileMode) error {
+	return c.writeFile(ctx, path, content, mode, "")
+}
+
+// writeFile writes content to path in chunks and, when renameTo is set,
+// moves path to renameTo in the same command as the last chunk.
+func (c *Client) writeFile(ctx context.Context, path string, content []byte, mode os.FileMode, renameTo string) error {
+	quoted := shellescape.Quote(path)
+	cmds := []string{fmt.Sprintf("mkdir -p %s && install -m %o /dev/null %s",
+		shellescape.Quote(filepath.Dir(path)), mode.Perm(), quoted)}
+	for start := 0; start < len(content); start += writeChunkSize {
+		end := min(start+writeChunkSize, len(content))
+		encoded := base64.StdEncoding.EncodeToString(content[start:end])
+		appendCmd := fmt.Sprintf("printf %%s %s | base64 -d >> %s", shellescape.Quote(encoded), quoted)
+		if start == 0 {
+			cmds[0] += " && " + appendCmd
+		} else {
+			cmds = append(cmds, appendCmd)
+		}
+	}
+	if renameTo != "" {
+		cmds[len(cmds)-1] += fmt.Sprintf(" && mv -f %s %s", quoted, shellescape.Quote(renameTo))
+	}
+	for _, cmd := range cmds {
+		if _, err := c.SSH(ctx, cmd); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // UploadEnvFile replaces {serviceName}.env on the server with the content of localPath.
 // Written with mode 600. Empty files are accepted. Caller must have validated localPath
 // (see config.ValidateEnvFile) before invoking.
@@ -391,15 +746,8 @@ func (c *Client) UploadEnvFile(ctx context.Context, serviceName, localPath strin
 	if err != nil {
 		return fmt.Errorf("failed to read env_file %s: %w", localPath, err)
 	}
-	stackDir := c.cfg.StackPath()
-	envPath := filepath.Join(stackDir, fmt.Sprintf("%s.env", serviceName))
-	encoded := base64.StdEncoding.EncodeToString(data)
-	cmd := fmt.Sprintf("mkdir -p %s && echo %s | base64 -d | install -m 600 /dev/stdin %s",
-		shellescape.Quote(stackDir),
-		shellescape.Quote(encoded),
-		shellescape.Quote(envPath))
-	_, err = c.SSH(ctx, cmd)
-	return err
+	envPath := filepath.Join(c.cfg.StackPath(), fmt.Sprintf("%s.env", serviceName))
+	return c.WriteFile(ctx, envPath, data, 0o600)
 }
 
 // CreateEnvFile creates an empty {serviceName}.env file with mode 600 in the stack directory.
@@ -452,12 +800,8 @@ func (c *Client) SetEnvVar(ctx context.Context, serviceName, key, value string)
 	}
 
 	newContent := strings.Join(lines, "\n")
-	stackDir := shellescape.Quote(c.cfg.StackPath())
 	envPath := filepath.Join(c.cfg.StackPath(), fmt.Sprintf("%s.env", serviceName))
-	escapedContent := strings.ReplaceAll(newContent, "'", "'\\''")
-	cmd := fmt.Sprintf("mkdir -p %s && echo '%s' | install -m 600 /dev/stdin %s", stackDir, escapedContent, shellescape.Quote(envPath))
-	_, err = c.SSH(ctx, cmd)
-	return err
+	return c.WriteFile(ctx, envPath, []byte(newContent), 0o600)
 }
 
 // RemoveEnvVar removes an environment variable from the {serviceName}.env file
@@ -478,23 +822,27 @@ func (c *Client) RemoveEnvVar(ctx context.Context, serviceName, key string) erro
 	}
 
 	newContent := strings.Join(filtered, "\n")
-	stackDir := shellescape.Quote(c.cfg.StackPath())
 	envPath := filepath.Join(c.cfg.StackPath(), fmt.Sprintf("%s.env", serviceName))
-	escapedContent := strings.ReplaceAll(newContent, "'", "'\\''")
-	cmd := fmt.Sprintf("mkdir -p %s && echo '%s' | install -m 600 /dev/stdin %s", stackDir, escapedContent, shellescape.Quote(envPath))
-	_, err = c.SSH(ctx, cmd)
-	return err
+	return c.WriteFile(ctx, envPath, []byte(newContent), 0o600)
+}
+
+// WriteEnvFile replaces the {serviceName}.env file with content, with
+// mode 600. Like every WriteFile, the file is never seen half-written.
+func (c *Client) WriteEnvFile(ctx context.Context, serviceName, content string) error {
+	envPath := filepath.Join(c.cfg.StackPath(), fmt.Sprintf("%s.env", serviceName))
+	return c.WriteFile(ctx, envPath, []byte(content), 0o600)
 }
 
-// CreateStack creates a stack directory and compose.yaml file with atomic write
+// CreateStack creates a stack directory and its compose file with atomic write
 func (c *Client) CreateStack(ctx context.Context, composeContent string) error {
 	if composeContent == "" {
 		return fmt.Errorf("compose content cannot be empty")
 	}
 
 	stackPath := c.cfg.StackPath()
-	tmpFile := filepath.Join(stackPath, "compose.yaml.tmp")
-	finalFile := filepath.Join(stackPath, "compose.yaml")
+	name := c.cfg.ComposeFilename()
+	tmpFile := filepath.Join(stackPath, name+".tmp")
+	finalFile := filepath.Join(stackPath, name)
 
 	// Step 1: Create stack directory
 	mkdirCmd := fmt.Sprintf("mkdir -p %s", shellescape.Quote(stackPath))
@@ -502,31 +850,30 @@ func (c *Client) CreateStack(ctx context.Context, composeContent string) error {
 		return fmt.Errorf("failed to create stack directory: %w", err)
 	}
 
-	// Step 2: Write content to temporary file
-	escapedContent := strings.ReplaceAll(composeContent, "'", "'\\''")
-	writeCmd := fmt.Sprintf("echo '%s' > %s", escapedContent, shellescape.Quote(tmpFile))
-	if _, err := c.SSH(ctx, writeCmd); err != nil {
-		return fmt.Errorf("failed to write compose.yaml.tmp: %w", err)
+	// Step 2: Write content to temporary file; it is only a candidate, so
+	// no rename of its own
+	if err := c.WriteFileInPlace(ctx, tmpFile, []byte(composeContent), 0o644); err != nil {
+		return fmt.Errorf("failed to write %s.tmp: %w", name, err)
 	}
 
-	// Step 3: Validate compose file
-	validateCmd := fmt.Sprintf("cd %s && docker compose -f compose.yaml.tmp config 2>&1", shellescape.Quote(stackPath))
-	if output, err := c.SSH(ctx, validateCmd); err != nil {
-		// Include first line of docker compose output for diagnostics
-		detail := strings.TrimSpace(output)
-		if i := strings.IndexByte(detail, '\n'); i > 0 {
-			detail = detail[:i]
-		}
-		if detail != "" {
-			return fmt.Errorf("compose.yaml validation failed: %s", detail)
-		}
-		return fmt.Errorf("compose.yaml validation failed: %w", err)
+	// Step 3: Validate compose file. Docker's messages arrive as the
+	// command's stderr, in the error. A transient failure never reached
+	// docker and is tried once more; a rejection by docker is final.
+	validateCmd := fmt.Sprintf("cd %s && docker compose -f %s config -q", shellescape.Quote(stackPath), shellescape.Quote(name+".tmp"))
+	_, err := c.SSH(ctx, validateCmd)
+	if IsTransient(err) {
+		_, err = c.SSH(ctx, validateCmd)
+	}
+	if err != nil {
+		// Best effort: a rejected candidate is saved locally, not left behind
+		_, _ = c.SSH(ctx, "rm -f "+shellescape.Quote(tmpFile))
+		return composeValidationError(name, composeContent, commandStderr(err), err)
 	}
 
 	// Step 4: Move temp file to final location
 	moveCmd := fmt.Sprintf("mv %s %s", shellescape.Quote(tmpFile), shellescape.Quote(finalFile))
 	if _, err := c.SSH(ctx, moveCmd); err != nil {
-		return fmt.Errorf("failed to move compose.yaml.tmp to compose.yaml: %w", err)
+		return fmt.Errorf("failed to move %s.tmp to %s: %w", name, name, err)
 	}
 
 	c.composeCached = false
@@ -539,22 +886,207 @@ func (c *Client) PullImage(ctx context.Context, image string) error {
 	return c.SSHInteractive(ctx, cmd)
 }
 
+// ImageDigest returns the pulled image's repo digest reference
+// (name@sha256:...), for pinning a tag to the exact image.
+func (c *Client) ImageDigest(ctx context.Context, image string) (string, error) {
+	cmd := fmt.Sprintf("docker image inspect --format '{{index .RepoDigests 0}}' %s", shellescape.Quote(image))
+	out, err := c.SSH(ctx, cmd)
+	if err != nil {
+		return "", fmt.Errorf("failed to inspect image %s: %w", image, err)
+	}
+	return ParseRepoDigest(image, out)
+}
+
+// ParseRepoDigest validates the repo digest printed for image by an
+// image inspect and returns it.
+func ParseRepoDigest(image, out string) (string, error) {
+	ref := strings.TrimSpace(out)
+	if !config.IsDigestImage(ref) {
+		return "", fmt.Errorf("image %s has no repo digest", image)
+	}
+	if err := config.ValidateImage(ref); err != nil {
+		return "", fmt.Errorf("image %s: %w", image, err)
+	}
+	return ref, nil
+}
+
+// ImageInfo inspects image on the server.
+func (c *Client) ImageInfo(ctx context.Context, image string) (images.Info, error) {
+	out, err := c.SSH(ctx, "docker image inspect "+shellescape.Quote(image))
+	if err != nil {
+		return images.Info{}, fmt.Errorf("failed to inspect image %s: %w", image, err)
+	}
+	return images.ParseInspect(out)
+}
+
 // StartService starts a specific service in the stack
 func (c *Client) StartService(ctx context.Context, serviceName string) error {
 	stackPath := c.cfg.StackPath()
-	cmd := fmt.Sprintf("cd %s && docker compose up -d --force-recreate %s", shellescape.Quote(stackPath), shellescape.Quote(serviceName))
+	recreate := " --force-recreate"
+	if c.run.NoForceRecreate {
+		recreate = ""
+	}
+	cmd := fmt.Sprintf("cd %s && %s%s up -d%s %s", shellescape.Quote(stackPath), ComposeCommand(c.cfg), c.profileArgs(), recreate, shellescape.Quote(serviceName))
 	return c.SSHInteractive(ctx, cmd)
 }
 
-// ensureDockerRollout installs the docker-rollout CLI plugin if not already present (idempotent)
+// StopService stops a single service's containers without removing them
+func (c *Client) StopService(ctx context.Context, serviceName string) error {
+	stackPath := c.cfg.StackPath()
+	cmd := fmt.Sprintf("cd %s && %s stop %s", shellescape.Quote(stackPath), ComposeCommand(c.cfg), shellescape.Quote(serviceName))
+	return c.SSHInteractive(ctx, cmd)
+}
+
+// RunJob runs the service's pre_start job to completion with
+// 'docker compose run --rm'. A non-zero exit fails with an error.
+func (c *Client) RunJob(ctx context.Context, serviceName string) error {
+	stackPath := c.cfg.StackPath()
+	cmd := fmt.Sprintf("cd %s && %s run --rm -T %s", shellescape.Quote(stackPath), ComposeCommand(c.cfg), shellescape.Quote(config.PreStartJobName(serviceName)))
+	if err := c.SSHInteractive(ctx, cmd); err != nil {
+		return fmt.Errorf("pre_start job for %s failed: %w", serviceName, err)
+	}
+	return nil
+}
+
+// ProbeURL requests url with curl on the server, following redirects,
+// and returns the response's status code.
+func (c *Client) ProbeURL(ctx context.Context, url string, timeout time.Duration) (int, error) {
+	seconds := max(int(timeout.Seconds()), 1)
+	cmd := fmt.Sprintf("curl -sS -L -o /dev/null -w '%%{http_code}' --max-time %d %s", seconds, shellescape.Quote(url))
+	out, err := c.SSH(ctx, cmd)
+	if err != nil {
+		return 0, fmt.Errorf("request to %s failed: %w", url, err)
+	}
+	status, err := strconv.Atoi(strings.TrimSpace(out))
+	if err != nil {
+		return 0, fmt.Errorf("unexpected curl output %q", strings.TrimSpace(out))
+	}
+	return status, nil
+}
+
+// healthPollInterval is how often WaitForHealthy re-inspects containers.
+const healthPollInterval = 2 * time.Second
+
+// healthRetryMaxInterval caps WaitForHealthy's backoff between polls that
+// failed in transit.
+const healthRetryMaxInterval = 10 * time.Second
+
+// WaitForHealthy polls the service's containers until all of them are
+// healthy, or until timeout. Containers without a healthcheck count as
+// healthy once they have stayed running for grace (immediately when
+// grace is 0). An unhealthy, exited, or dead container fails right away.
+//
+// A poll that fails in transit (see IsTransient) says nothing about the
+// containers, so it is retried, backing off from healthPollInterval up to
+// healthRetryMaxInterval, for as long as the timeout allows. Other poll
+// errors are retried at the usual interval.
+func (c *Client) WaitForHealthy(ctx context.Context, serviceName string, timeout, grace time.Duration) error {
+	stackPath := c.cfg.StackPath()
+	cmd := fmt.Sprintf("cd %s && docker inspect --format '{{.State.Status}} {{if .State.Health}}{{.State.Health.Status}}{{end}}' $(%s ps -q %s)",
+		shellescape.Quote(stackPath),
+		ComposeCommand(c.cfg),
+		shellescape.Quote(serviceName))
+
+	start := c.clock.Now()
+	deadline := start.Add(timeout)
+	backoff := healthPollInterval
+	var lastErr error
+	for {
+		wait := healthPollInterval
+		output, err := c.SSH(ctx, cmd)
+		if err == nil {
+			done, herr := containerHealth(output, c.clock.Now().Sub(start) >= grace)
+			if herr != nil {
+				return fmt.Errorf("%s: %w", serviceName, herr)
+			}
+			if done {
+				return nil
+			}
+			lastErr = nil
+			backoff = healthPollInterval
+		} else {
+			lastErr = err
+			if IsTransient(err) {
+				wait = min(backoff, max(deadline.Sub(c.clock.Now()), healthPollInterval))
+				backoff = min(2*backoff, healthRetryMaxInterval)
+			}
+		}
+
+		if c.clock.Now().After(deadline) {
+			if lastErr != nil {
+				return fmt.Errorf("timed out after %v waiting for %s to become healthy: %w", timeout, serviceName, lastErr)
+			}
+			return fmt.Errorf("timed out after %v waiting for %s to become healthy", timeout, serviceName)
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-c.clock.After(wait):
+		}
+	}
+}
+
+// containerHealth interprets `docker inspect` output with one
+// "<status> <health>" line per container. Returns done=true once every
+// container is running and healthy (or running without a healthcheck and
+// graceElapsed). Returns an error for states that won't recover.
+func containerHealth(output string, graceElapsed bool) (bool, error) {
+	lines := strings.Split(strings.TrimSpace(output), "\n")
+	if len(lines) == 1 && strings.TrimSpace(lines[0]) == "" {
+		return false, nil
+	}
+	done := true
+	for _, line := range lines {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		status, health := fields[0], ""
+		if len(fields) > 1 {
+			health = fields[1]
+		}
+		switch status {
+		case "exited", "dead":
+			return false, fmt.Errorf("container %s", status)
+		case "running":
+		default:
+			done = false
+			continue
+		}
+		switch health {
+		case "unhealthy":
+			return false, fmt.Errorf("container is unhealthy")
+		case "healthy":
+		case "":
+			if !graceElapsed {
+				done = false
+			}
+		default:
+			done = false
+		}
+	}
+	return done, nil
+}
+
+// ensureDockerRollout checks that `docker rollout` works on the server,
+// installing the docker-rollout CLI plugin into ~/.docker/cli-plugins when
+// it doesn't (idempotent). A successful check is cached per Client.
 func (c *Client) ensureDockerRollout(ctx context.Context) error {
-	cmd := "test -f ~/.docker/cli-plugins/docker-rollout || " +
+	if c.rolloutReady {
+		return nil
+	}
+	cmd := "docker rollout --help >/dev/null 2>&1 || " +
 		"(mkdir -p ~/.docker/cli-plugins && " +
 		"curl -fsSL https://raw.githubusercontent.com/wowu/docker-rollout/main/docker-rollout " +
 		"-o ~/.docker/cli-plugins/docker-rollout && " +
-		"chmod +x ~/.docker/cli-plugins/docker-rollout)"
-	_, err := c.SSH(ctx, cmd)
-	return err
+		"chmod +x ~/.docker/cli-plugins/docker-rollout && " +
+		"docker rollout --help >/dev/null 2>&1)"
+	if _, err := c.SSH(ctx, cmd); err != nil {
+		return fmt.Errorf("docker rollout is not available on %s (%w): install docker-rollout (https://github.com/wowu/docker-rollout) or set deploy.strategy to recreate", c.server, err)
+	}
+	c.rolloutReady = true
+	return nil
 }
 
 // RolloutService performs a zero-downtime update using docker rollout.
@@ -571,7 +1103,7 @@ func (c *Client) RolloutService(ctx context.Context, serviceName string) error {
 // CopyFiles copies local files to the stack directory on the remote server.
 // files maps absolute local paths to container mount paths; only the basename
 // is used for the remote filename in the stack directory.
-// Files are transferred via base64 encoding over SSH, independent of git tracking.
+// Files are transferred with WriteFile, independent of git tracking.
 func (c *Client) CopyFiles(ctx context.Context, files map[string]string) error {
 	if len(files) == 0 {
 		return nil
@@ -587,14 +1119,7 @@ func (c *Client) CopyFiles(ctx context.Context, files map[string]string) error {
 
 		remoteName := filepath.Base(localPath)
 		remotePath := filepath.Join(stackDir, remoteName)
-		encoded := base64.StdEncoding.EncodeToString(data)
-
-		cmd := fmt.Sprintf("mkdir -p %s && echo %s | base64 -d > %s",
-			shellescape.Quote(stackDir),
-			shellescape.Quote(encoded),
-			shellescape.Quote(remotePath))
-
-		if _, err := c.SSH(ctx, cmd); err != nil {
+		if err := c.WriteFile(ctx, remotePath, data, 0o644); err != nil {
 			return fmt.Errorf("failed to copy file %s: %w", remoteName, err)
 		}
 	}
@@ -5,12 +5,16 @@ import (
 	"errors"
 	"fmt"
 	"os"
+	"os/exec"
 	"path/filepath"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/byteink/ssd/config"
+	"github.com/byteink/ssd/internal/clock"
 	"github.com/byteink/ssd/internal/testhelpers"
+	"github.com/byteink/ssd/logs"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 	"github.com/stretchr/testify/require"
@@ -152,6 +156,47 @@ func TestClient_Rsync_Subdirectory(t *testing.T) {
 	mockExec.AssertExpectations(t)
 }
 
+func TestClient_Rsync_ProjectDir(t *testing.T) {
+	project := t.TempDir()
+	require.NoError(t, os.Mkdir(filepath.Join(project, ".git"), 0755))
+	nested := filepath.Join(project, "vendor", "lib")
+	require.NoError(t, os.MkdirAll(nested, 0755))
+
+	cfg := newTestConfig()
+	cfg.ProjectDir = project
+	mockExec := new(testhelpers.MockExecutor)
+	client := NewClientWithExecutor(cfg, mockExec)
+
+	mockExec.On("RunInteractive", "bash", mock.MatchedBy(func(args []string) bool {
+		pipeline := args[1]
+		return strings.Contains(pipeline, "git -C "+project+" archive") &&
+			strings.Contains(pipeline, "-- vendor/lib") &&
+			strings.Contains(pipeline, "--strip-components=2")
+	})).Return(nil)
+
+	err := client.Rsync(context.Background(), nested, "/remote/path")
+
+	require.NoError(t, err)
+	mockExec.AssertExpectations(t)
+}
+
+func TestProjectRoot(t *testing.T) {
+	project := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(project, ".git"), []byte("gitdir: ../.git/modules/app\n"), 0644))
+
+	root, err := ProjectRoot(project, filepath.Join(project, "api"))
+	require.NoError(t, err)
+	assert.Equal(t, project, root)
+
+	_, err = ProjectRoot(project, t.TempDir())
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "is outside project_dir")
+
+	_, err = ProjectRoot(filepath.Join(project, "api"), filepath.Join(project, "api"))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "not a git repository root (no .git)")
+}
+
 func TestClient_Rsync_GitRootError(t *testing.T) {
 	cfg := newTestConfig()
 	mockExec := new(testhelpers.MockExecutor)
@@ -314,6 +359,181 @@ func TestClient_BuildImage_NoTarget(t *testing.T) {
 	mockExec.AssertExpectations(t)
 }
 
+func TestClient_BuildImage_WithPlatform(t *testing.T) {
+	cfg := newTestConfig()
+	cfg.Platform = "linux/amd64"
+	mockExec := new(testhelpers.MockExecutor)
+	client := NewClientWithExecutor(cfg, mockExec)
+
+	mockExec.On("RunInteractive", "ssh", mock.MatchedBy(func(args []string) bool {
+		cmd := args[len(args)-1]
+		return strings.Contains(cmd, "docker build") &&
+			strings.Contains(cmd, "--platform linux/amd64") &&
+			!strings.Contains(cmd, "DOCKER_BUILDKIT")
+	})).Return(nil)
+
+	err := client.BuildImage(context.Background(), "/tmp/build", 1)
+
+	require.NoError(t, err)
+	mockExec.AssertExpectations(t)
+}
+
+func TestClient_BuildImage_WithBuildKit(t *testing.T) {
+	cfg := newTestConfig()
+	cfg.Build = &config.BuildConfig{BuildKit: true}
+	mockExec := new(testhelpers.MockExecutor)
+	client := NewClientWithExecutor(cfg, mockExec)
+
+	mockExec.On("RunInteractive", "ssh", mock.MatchedBy(func(args []string) bool {
+		cmd := args[len(args)-1]
+		return strings.Contains(cmd, "&& DOCKER_BUILDKIT=1 docker build") &&
+			!strings.Contains(cmd, "--platform")
+	})).Return(nil)
+
+	err := client.BuildImage(context.Background(), "/tmp/build", 1)
+
+	require.NoError(t, err)
+	mockExec.AssertExpectations(t)
+}
+
+func TestClient_BuildImage_Pull(t *testing.T) {
+	tests := []struct {
+		name     string
+		build    *config.BuildConfig
+		pullBase bool
+		want     bool
+	}{
+		{name: "default", want: false},
+		{name: "build.pull", build: &config.BuildConfig{Pull: true}, want: true},
+		{name: "deploy --pull", pullBase: true, want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := newTestConfig()
+			cfg.Build = tt.build
+			mockExec := new(testhelpers.MockExecutor)
+			client := NewClientWithExecutor(cfg, mockExec)
+			client.SetRunOptions(RunOptions{PullBase: tt.pullBase})
+
+			var cmd string
+			mockExec.On("RunInteractive", "ssh", mock.Anything).Run(func(args mock.Arguments) {
+				sshArgs := args.Get(1).([]string)
+				cmd = sshArgs[len(sshArgs)-1]
+			}).Return(nil)
+
+			require.NoError(t, client.BuildImage(context.Background(), "/tmp/build", 2))
+
+			if tt.want {
+				assert.Contains(t, cmd, "docker build -t ssd-myapp-myapp:2 -f Dockerfile --pull .")
+			} else {
+				assert.NotContains(t, cmd, "--pull")
+			}
+		})
+	}
+}
+
+func TestBuildFlags_PullIgnoredForPrebuilt(t *testing.T) {
+	cfg := &config.Config{Name: "db", Image: "postgres:16", Build: &config.BuildConfig{Pull: true}}
+	assert.NotContains(t, BuildFlags(cfg, RunOptions{PullBase: true}), "--pull")
+}
+
+func TestClient_BuildImage_BuildKitPlatformAndTarget(t *testing.T) {
+	cfg := newTestConfig()
+	cfg.Target = "production"
+	cfg.Platform = "linux/arm64"
+	cfg.Build = &config.BuildConfig{BuildKit: true}
+	mockExec := new(testhelpers.MockExecutor)
+	client := NewClientWithExecutor(cfg, mockExec)
+
+	mockExec.On("RunInteractive", "ssh", mock.MatchedBy(func(args []string) bool {
+		cmd := args[len(args)-1]
+		return strings.Contains(cmd, "DOCKER_BUILDKIT=1 docker build -t ssd-myapp-myapp:2 -f Dockerfile --target production --platform linux/arm64 .")
+	})).Return(nil)
+
+	err := client.BuildImage(context.Background(), "/tmp/build", 2)
+
+	require.NoError(t, err)
+	mockExec.AssertExpectations(t)
+}
+
+func TestClient_BuildImage_SSHAndSecrets(t *testing.T) {
+	cfg := newTestConfig()
+	cfg.Build = &config.BuildConfig{
+		SSH:     []string{"default", "github=/home/deploy/.ssh/id_ed25519"},
+		Secrets: []string{"id=npmrc,src=/etc/ssd/npmrc", "id=token,env=GITHUB_TOKEN"},
+	}
+	mockExec := new(testhelpers.MockExecutor)
+	client := NewClientWithExecutor(cfg, mockExec)
+
+	mockExec.On("RunInteractive", "ssh", mock.MatchedBy(func(args []string) bool {
+		cmd := args[len(args)-1]
+		return strings.Contains(cmd, "DOCKER_BUILDKIT=1 docker build -t ssd-myapp-myapp:2 -f Dockerfile"+
+			" --ssh default --ssh github=/home/deploy/.ssh/id_ed25519"+
+			" --secret id=npmrc,src=/etc/ssd/npmrc --secret id=token,env=GITHUB_TOKEN .")
+	})).Return(nil)
+
+	err := client.BuildImage(context.Background(), "/tmp/build", 2)
+
+	require.NoError(t, err)
+	mockExec.AssertExpectations(t)
+}
+
+func TestClient_BuildImage_QuietBuild(t *testing.T) {
+	cfg := newTestConfig()
+	mockExec := new(testhelpers.MockExecutor)
+	client := NewClientWithExecutor(cfg, mockExec)
+	client.SetRunOptions(RunOptions{QuietBuild: true})
+
+	mockExec.On("Run", "ssh", mock.MatchedBy(func(args []string) bool {
+		return strings.Contains(args[len(args)-1], "docker build -t ssd-myapp-myapp:2 -f Dockerfile --quiet .")
+	})).Return("sha256:0123abcd\n", nil)
+
+	err := client.BuildImage(context.Background(), "/tmp/build", 2)
+
+	require.NoError(t, err)
+	mockExec.AssertExpectations(t)
+	mockExec.AssertNotCalled(t, "RunInteractive", mock.Anything, mock.Anything)
+}
+
+func TestClient_BuildImage_QuietBuildFailureShowsOutput(t *testing.T) {
+	cfg := newTestConfig()
+	mockExec := new(testhelpers.MockExecutor)
+	client := NewClientWithExecutor(cfg, mockExec)
+	client.SetRunOptions(RunOptions{QuietBuild: true})
+
+	mockExec.On("Run", "ssh", mock.Anything).Return("", errors.New("command failed: exit status 1\nERROR: failed to solve: npm ci: exit code 1"))
+
+	err := client.BuildImage(context.Background(), "/tmp/build", 2)
+
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "failed to solve: npm ci")
+}
+
+// capturingExecutor is a MockExecutor that is also a CapturedRunner.
+type capturingExecutor struct {
+	testhelpers.MockExecutor
+	captured []string
+}
+
+func (e *capturingExecutor) RunCaptured(ctx context.Context, name string, args ...string) (string, error) {
+	e.captured = append(e.captured, args[len(args)-1])
+	return "", nil
+}
+
+func TestClient_BuildImage_QuietBuildUsesCapturedRunner(t *testing.T) {
+	cfg := newTestConfig()
+	executor := new(capturingExecutor)
+	client := NewClientWithExecutor(cfg, executor)
+	client.SetRunOptions(RunOptions{QuietBuild: true})
+
+	require.NoError(t, client.BuildImage(context.Background(), "/tmp/build", 2))
+
+	require.Len(t, executor.captured, 1)
+	assert.Contains(t, executor.captured[0], "docker build")
+	executor.AssertNotCalled(t, "Run", mock.Anything, mock.Anything)
+	executor.AssertNotCalled(t, "RunInteractive", mock.Anything, mock.Anything)
+}
+
 func TestClient_UpdateManifest(t *testing.T) {
 	cfg := newTestConfig()
 	mockExec := new(testhelpers.MockExecutor)
@@ -334,6 +554,62 @@ func TestClient_UpdateManifest(t *testing.T) {
 	mockExec.AssertExpectations(t)
 }
 
+func TestClient_CustomProject_VersionAndManifest(t *testing.T) {
+	cfg := newTestConfig()
+	cfg.Project = "shop"
+	mockExec := new(testhelpers.MockExecutor)
+	client := NewClientWithExecutor(cfg, mockExec)
+
+	composeContent := `services:
+  myapp:
+    image: ssd-myapp-myapp:9
+  other:
+    image: ssd-shop-myapp:4`
+
+	mockExec.On("Run", "ssh", mock.MatchedBy(func(args []string) bool {
+		return strings.Contains(args[1], "cat")
+	})).Return(composeContent, nil).Once()
+	mockExec.On("Run", "ssh", mock.MatchedBy(func(args []string) bool {
+		cmd := args[1]
+		return strings.Contains(cmd, "sed -i 's|ssd-shop-myapp:[0-9][0-9]*|ssd-shop-myapp:5|g'")
+	})).Return("", nil).Once()
+
+	version, err := client.GetCurrentVersion(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 4, version)
+
+	require.NoError(t, client.UpdateManifest(context.Background(), 5))
+	mockExec.AssertExpectations(t)
+}
+
+func TestClient_ImageTemplate_VersionRoundTrip(t *testing.T) {
+	cfg := newTestConfig()
+	cfg.Project = "shop"
+	cfg.ImageTemplate = "registry.example.com/{project}/{service}"
+	mockExec := new(testhelpers.MockExecutor)
+	client := NewClientWithExecutor(cfg, mockExec)
+
+	composeContent := `services:
+  myapp:
+    image: registry.example.com/shop/myapp:12
+  other:
+    image: ssd-shop-myapp:4`
+
+	mockExec.On("Run", "ssh", mock.MatchedBy(func(args []string) bool {
+		return strings.Contains(args[1], "cat")
+	})).Return(composeContent, nil).Once()
+	mockExec.On("Run", "ssh", mock.MatchedBy(func(args []string) bool {
+		return strings.Contains(args[1], `sed -i 's|registry\.example\.com/shop/myapp:[0-9][0-9]*|registry.example.com/shop/myapp:13|g'`)
+	})).Return("", nil).Once()
+
+	version, err := client.GetCurrentVersion(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 12, version)
+
+	require.NoError(t, client.UpdateManifest(context.Background(), version+1))
+	mockExec.AssertExpectations(t)
+}
+
 func TestClient_UpdateManifest_SedError(t *testing.T) {
 	cfg := newTestConfig()
 	mockExec := new(testhelpers.MockExecutor)
@@ -343,22 +619,247 @@ func TestClient_UpdateManifest_SedError(t *testing.T) {
 
 	err := client.UpdateManifest(context.Background(), 5)
 
-	require.Error(t, err)
-	assert.Contains(t, err.Error(), "failed to update compose.yaml")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "failed to update compose.yaml")
+}
+
+func TestClient_RestartStack(t *testing.T) {
+	cfg := newTestConfig()
+	mockExec := new(testhelpers.MockExecutor)
+	client := NewClientWithExecutor(cfg, mockExec)
+
+	mockExec.On("RunInteractive", "ssh", mock.MatchedBy(func(args []string) bool {
+		cmd := args[len(args)-1]
+		return strings.Contains(cmd, "cd /stacks/myapp") &&
+			strings.Contains(cmd, "docker compose up -d")
+	})).Return(nil)
+
+	err := client.RestartStack(context.Background())
+
+	require.NoError(t, err)
+	mockExec.AssertExpectations(t)
+}
+
+func TestClient_RestartStack_WithProfiles(t *testing.T) {
+	cfg := newTestConfig()
+	cfg.ActiveProfiles = []string{"debug"}
+	mockExec := new(testhelpers.MockExecutor)
+	client := NewClientWithExecutor(cfg, mockExec)
+
+	mockExec.On("RunInteractive", "ssh", mock.MatchedBy(func(args []string) bool {
+		cmd := args[len(args)-1]
+		return strings.HasSuffix(cmd, "cd /stacks/myapp && docker compose --profile debug up -d")
+	})).Return(nil)
+
+	err := client.RestartStack(context.Background())
+
+	require.NoError(t, err)
+	mockExec.AssertExpectations(t)
+}
+
+func TestContainerHealth(t *testing.T) {
+	tests := []struct {
+		name         string
+		output       string
+		graceElapsed bool
+		wantDone     bool
+		wantErr      string
+	}{
+		{"no containers yet", "", false, false, ""},
+		{"healthy", "running healthy\n", false, true, ""},
+		{"all replicas healthy", "running healthy\nrunning healthy\n", false, true, ""},
+		{"one replica starting", "running healthy\nrunning starting\n", false, false, ""},
+		{"unhealthy", "running unhealthy\n", false, false, "unhealthy"},
+		{"exited", "exited \n", false, false, "exited"},
+		{"created", "created \n", true, false, ""},
+		{"no healthcheck within grace", "running \n", false, false, ""},
+		{"no healthcheck after grace", "running \n", true, true, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			done, err := containerHealth(tt.output, tt.graceElapsed)
+			if tt.wantErr != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantDone, done)
+		})
+	}
+}
+
+func TestClient_WaitForHealthy_PollsUntilHealthy(t *testing.T) {
+	cfg := newTestConfig()
+	mockExec := new(testhelpers.MockExecutor)
+	client := NewClientWithExecutor(cfg, mockExec)
+	clk := clock.NewFake(time.Now())
+	client.clock = clk
+
+	want := "cd /stacks/myapp && docker inspect --format '{{.State.Status}} {{if .State.Health}}{{.State.Health.Status}}{{end}}' $(docker compose ps -q web)"
+	mockExec.On("Run", "ssh", []string{"testserver", want}).Return("running starting\n", nil).Once()
+	mockExec.On("Run", "ssh", []string{"testserver", want}).Return("running healthy\n", nil).Once()
+
+	err := client.WaitForHealthy(context.Background(), "web", time.Minute, 0)
+
+	require.NoError(t, err)
+	mockExec.AssertExpectations(t)
+	assert.Equal(t, []time.Duration{healthPollInterval}, clk.Waits())
+}
+
+func TestClient_WaitForHealthy_Unhealthy(t *testing.T) {
+	cfg := newTestConfig()
+	mockExec := new(testhelpers.MockExecutor)
+	client := NewClientWithExecutor(cfg, mockExec)
+
+	mockExec.On("Run", "ssh", mock.Anything).Return("running unhealthy\n", nil)
+
+	err := client.WaitForHealthy(context.Background(), "web", time.Second, 0)
+
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "web: container is unhealthy")
+}
+
+// errConnectionBlip is how a dropped SSH connection surfaces from the
+// executor: ssh's own exit status 255.
+var errConnectionBlip = errors.New("command failed: exit status 255\nssh: connect to host testserver port 22: Connection reset by peer")
+
+func TestClient_WaitForHealthy_RetriesTransientErrors(t *testing.T) {
+	cfg := newTestConfig()
+	mockExec := new(testhelpers.MockExecutor)
+	client := NewClientWithExecutor(cfg, mockExec)
+	clk := clock.NewFake(time.Now())
+	client.clock = clk
+
+	mockExec.On("Run", "ssh", mock.Anything).Return("", errConnectionBlip).Times(3)
+	mockExec.On("Run", "ssh", mock.Anything).Return("running healthy\n", nil).Once()
+
+	err := client.WaitForHealthy(context.Background(), "web", time.Minute, 0)
+
+	require.NoError(t, err)
+	mockExec.AssertExpectations(t)
+	assert.Equal(t, []time.Duration{2 * time.Second, 4 * time.Second, 8 * time.Second}, clk.Waits())
+}
+
+func TestClient_WaitForHealthy_UnhealthyAfterTransientErrorFailsAtOnce(t *testing.T) {
+	cfg := newTestConfig()
+	mockExec := new(testhelpers.MockExecutor)
+	client := NewClientWithExecutor(cfg, mockExec)
+	clk := clock.NewFake(time.Now())
+	client.clock = clk
+
+	mockExec.On("Run", "ssh", mock.Anything).Return("", errConnectionBlip).Once()
+	mockExec.On("Run", "ssh", mock.Anything).Return("running unhealthy\n", nil).Once()
+
+	err := client.WaitForHealthy(context.Background(), "web", time.Minute, 0)
+
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "web: container is unhealthy")
+	mockExec.AssertExpectations(t)
+	assert.Len(t, clk.Waits(), 1)
+}
+
+func TestClient_WaitForHealthy_TransientErrorsBoundedByTimeout(t *testing.T) {
+	cfg := newTestConfig()
+	mockExec := new(testhelpers.MockExecutor)
+	client := NewClientWithExecutor(cfg, mockExec)
+	clk := clock.NewFake(time.Now())
+	client.clock = clk
+
+	mockExec.On("Run", "ssh", mock.Anything).Return("", errConnectionBlip)
+
+	err := client.WaitForHealthy(context.Background(), "web", 20*time.Second, 0)
+
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "timed out after 20s")
+	assert.Contains(t, err.Error(), "exit status 255")
+	// 2+4+8s, then only the 6s left of the timeout instead of 10s.
+	assert.Equal(t, []time.Duration{2 * time.Second, 4 * time.Second, 8 * time.Second, 6 * time.Second, 2 * time.Second}, clk.Waits())
+}
+
+func TestIsTransient(t *testing.T) {
+	assert.True(t, IsTransient(errConnectionBlip))
+	assert.True(t, IsTransient(errors.New("ssh: connect to testserver: i/o timeout")))
+	assert.False(t, IsTransient(errors.New("command failed: exit status 1\nError: No such object: web")))
+	assert.False(t, IsTransient(nil))
+}
+
+func TestClient_WaitForHealthy_Timeout(t *testing.T) {
+	cfg := newTestConfig()
+	mockExec := new(testhelpers.MockExecutor)
+	client := NewClientWithExecutor(cfg, mockExec)
+	clk := clock.NewFake(time.Now())
+	client.clock = clk
+
+	mockExec.On("Run", "ssh", mock.Anything).Return("running starting\n", nil)
+
+	err := client.WaitForHealthy(context.Background(), "web", 10*time.Second, 0)
+
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "timed out after 10s")
+	// Polls every 2s from 0 to 12s and gives up on the first one past the
+	// 10s deadline.
+	assert.Len(t, clk.Waits(), 6)
+	mockExec.AssertNumberOfCalls(t, "Run", 7)
+}
+
+func TestClient_WaitForHealthy_GraceWithoutHealthcheck(t *testing.T) {
+	cfg := newTestConfig()
+	mockExec := new(testhelpers.MockExecutor)
+	client := NewClientWithExecutor(cfg, mockExec)
+	clk := clock.NewFake(time.Now())
+	client.clock = clk
+
+	mockExec.On("Run", "ssh", mock.Anything).Return("running\n", nil)
+
+	err := client.WaitForHealthy(context.Background(), "web", time.Minute, 5*time.Second)
+
+	require.NoError(t, err)
+	// Running from the first poll, but only counted healthy once 5s of
+	// grace have passed: the polls at 0, 2 and 4s wait, the one at 6s passes.
+	assert.Len(t, clk.Waits(), 3)
+}
+
+func TestClient_StopService(t *testing.T) {
+	cfg := newTestConfig()
+	mockExec := new(testhelpers.MockExecutor)
+	client := NewClientWithExecutor(cfg, mockExec)
+
+	mockExec.On("RunInteractive", "ssh", mock.MatchedBy(func(args []string) bool {
+		return args[len(args)-1] == "cd /stacks/myapp && docker compose stop web"
+	})).Return(nil)
+
+	err := client.StopService(context.Background(), "web")
+
+	require.NoError(t, err)
+	mockExec.AssertExpectations(t)
+}
+
+func TestClient_Down(t *testing.T) {
+	cfg := newTestConfig()
+	mockExec := new(testhelpers.MockExecutor)
+	client := NewClientWithExecutor(cfg, mockExec)
+
+	mockExec.On("RunInteractive", "ssh", mock.MatchedBy(func(args []string) bool {
+		return args[len(args)-1] == "cd /stacks/myapp && docker compose down"
+	})).Return(nil)
+
+	err := client.Down(context.Background(), false)
+
+	require.NoError(t, err)
+	mockExec.AssertExpectations(t)
 }
 
-func TestClient_RestartStack(t *testing.T) {
+func TestClient_Down_RemoveVolumes(t *testing.T) {
 	cfg := newTestConfig()
 	mockExec := new(testhelpers.MockExecutor)
 	client := NewClientWithExecutor(cfg, mockExec)
 
 	mockExec.On("RunInteractive", "ssh", mock.MatchedBy(func(args []string) bool {
-		cmd := args[len(args)-1]
-		return strings.Contains(cmd, "cd /stacks/myapp") &&
-			strings.Contains(cmd, "docker compose up -d")
+		return args[len(args)-1] == "cd /stacks/myapp && docker compose down -v"
 	})).Return(nil)
 
-	err := client.RestartStack(context.Background())
+	err := client.Down(context.Background(), true)
 
 	require.NoError(t, err)
 	mockExec.AssertExpectations(t)
@@ -373,15 +874,30 @@ func TestClient_GetContainerStatus(t *testing.T) {
 	mockExec.On("Run", "ssh", mock.MatchedBy(func(args []string) bool {
 		cmd := args[1]
 		return strings.Contains(cmd, "cd /stacks/myapp") &&
-			strings.Contains(cmd, "docker compose ps")
+			strings.HasSuffix(cmd, "docker compose ps --format '{{.Name}}\\t{{.Status}}'")
 	})).Return(expectedOutput, nil)
 
-	status, err := client.GetContainerStatus(context.Background())
+	status, err := client.GetContainerStatus(context.Background(), "")
 
 	require.NoError(t, err)
 	assert.Contains(t, status, "Up 5 minutes")
 }
 
+func TestClient_GetContainerStatus_Service(t *testing.T) {
+	cfg := newTestConfig()
+	mockExec := new(testhelpers.MockExecutor)
+	client := NewClientWithExecutor(cfg, mockExec)
+
+	mockExec.On("Run", "ssh", mock.MatchedBy(func(args []string) bool {
+		return strings.HasSuffix(args[1], "docker compose ps --format '{{.Name}}\\t{{.Status}}' web")
+	})).Return("myapp-web-1\tUp 5 minutes", nil)
+
+	_, err := client.GetContainerStatus(context.Background(), "web")
+
+	require.NoError(t, err)
+	mockExec.AssertExpectations(t)
+}
+
 func TestClient_GetLogs_NoFollow(t *testing.T) {
 	cfg := newTestConfig()
 	mockExec := new(testhelpers.MockExecutor)
@@ -394,7 +910,7 @@ func TestClient_GetLogs_NoFollow(t *testing.T) {
 			strings.Contains(cmd, "--tail 100")
 	})).Return(nil)
 
-	err := client.GetLogs(context.Background(), false, 100)
+	err := client.GetLogs(context.Background(), logs.Options{Tail: 100})
 
 	require.NoError(t, err)
 }
@@ -410,11 +926,64 @@ func TestClient_GetLogs_WithFollow(t *testing.T) {
 			strings.Contains(cmd, "-f")
 	})).Return(nil)
 
-	err := client.GetLogs(context.Background(), true, 0)
+	err := client.GetLogs(context.Background(), logs.Options{Follow: true, Tail: 0})
+
+	require.NoError(t, err)
+}
+
+func TestClient_GetLogs_TailAll(t *testing.T) {
+	cfg := newTestConfig()
+	mockExec := new(testhelpers.MockExecutor)
+	client := NewClientWithExecutor(cfg, mockExec)
+
+	mockExec.On("RunInteractive", "ssh", mock.MatchedBy(func(args []string) bool {
+		cmd := args[len(args)-1]
+		return strings.Contains(cmd, "docker compose logs") &&
+			!strings.Contains(cmd, "--tail")
+	})).Return(nil)
+
+	err := client.GetLogs(context.Background(), logs.Options{Tail: logs.TailAll})
 
 	require.NoError(t, err)
 }
 
+func TestClient_GetLogs_Since(t *testing.T) {
+	cfg := newTestConfig()
+	mockExec := new(testhelpers.MockExecutor)
+	client := NewClientWithExecutor(cfg, mockExec)
+
+	mockExec.On("RunInteractive", "ssh", mock.MatchedBy(func(args []string) bool {
+		return strings.HasSuffix(args[len(args)-1], "docker compose logs   --since 2026-03-01T08:00:00Z")
+	})).Return(nil)
+
+	since := time.Date(2026, 3, 1, 9, 0, 0, 0, time.FixedZone("CET", 3600))
+	require.NoError(t, client.GetLogs(context.Background(), logs.Options{Tail: logs.TailAll, Since: since}))
+	mockExec.AssertExpectations(t)
+}
+
+func TestClient_GetLogs_Grep(t *testing.T) {
+	cfg := newTestConfig()
+	mockExec := new(testhelpers.MockExecutor)
+	client := NewClientWithExecutor(cfg, mockExec)
+
+	mockExec.On("RunInteractive", "ssh", mock.MatchedBy(func(args []string) bool {
+		return strings.HasSuffix(args[len(args)-1],
+			"&& { docker compose logs -f --tail 50 | grep --line-buffered -e 'it'\"'\"'s; rm -rf /' || [ $? -eq 1 ]; }")
+	})).Return(nil)
+
+	require.NoError(t, client.GetLogs(context.Background(), logs.Options{Follow: true, Tail: 50, Grep: "it's; rm -rf /"}))
+	mockExec.AssertExpectations(t)
+}
+
+func TestGrepLines_ExitStatus(t *testing.T) {
+	run := func(cmd string) error {
+		return exec.Command("sh", "-c", cmd).Run()
+	}
+	assert.NoError(t, run(GrepLines("printf 'a\\nb\\n'", "b")), "a match succeeds")
+	assert.NoError(t, run(GrepLines("printf 'a\\n'", "b")), "no match is not an error")
+	assert.Error(t, run(GrepLines("true", "[")), "a bad pattern fails")
+}
+
 func TestClient_Cleanup(t *testing.T) {
 	cfg := newTestConfig()
 	mockExec := new(testhelpers.MockExecutor)
@@ -929,9 +1498,10 @@ func TestClient_SetEnvVar(t *testing.T) {
 	mockExec.On("Run", "ssh", mock.MatchedBy(func(args []string) bool {
 		cmd := args[1]
 		return strings.Contains(cmd, "mkdir -p") &&
-			strings.Contains(cmd, "install -m 600 /dev/stdin /stacks/myapp/myservice.env") &&
-			strings.Contains(cmd, "OLD_VAR=old_value") &&
-			strings.Contains(cmd, "NEW_VAR=new_value")
+			strings.Contains(cmd, "install -m 600 /dev/null /stacks/myapp/myservice.env.tmp") &&
+			strings.HasSuffix(cmd, " && mv -f /stacks/myapp/myservice.env.tmp /stacks/myapp/myservice.env") &&
+			strings.Contains(testhelpers.WrittenContent(cmd), "OLD_VAR=old_value") &&
+			strings.Contains(testhelpers.WrittenContent(cmd), "NEW_VAR=new_value")
 	})).Return("", nil).Once()
 
 	err := client.SetEnvVar(context.Background(), "myservice", "NEW_VAR", "new_value")
@@ -956,8 +1526,8 @@ func TestClient_SetEnvVar_UpdateExisting(t *testing.T) {
 		cmd := args[1]
 		return strings.Contains(cmd, "mkdir -p") &&
 			strings.Contains(cmd, "install") &&
-			strings.Contains(cmd, "DB_HOST=newhost") &&
-			!strings.Contains(cmd, "DB_HOST=localhost")
+			strings.Contains(testhelpers.WrittenContent(cmd), "DB_HOST=newhost") &&
+			!strings.Contains(testhelpers.WrittenContent(cmd), "DB_HOST=localhost")
 	})).Return("", nil).Once()
 
 	err := client.SetEnvVar(context.Background(), "myservice", "DB_HOST", "newhost")
@@ -980,7 +1550,7 @@ func TestClient_SetEnvVar_EmptyFile(t *testing.T) {
 		cmd := args[1]
 		return strings.Contains(cmd, "mkdir -p") &&
 			strings.Contains(cmd, "install") &&
-			strings.Contains(cmd, "MY_VAR=value")
+			strings.Contains(testhelpers.WrittenContent(cmd), "MY_VAR=value")
 	})).Return("", nil).Once()
 
 	err := client.SetEnvVar(context.Background(), "myservice", "MY_VAR", "value")
@@ -1017,10 +1587,11 @@ func TestClient_RemoveEnvVar(t *testing.T) {
 	mockExec.On("Run", "ssh", mock.MatchedBy(func(args []string) bool {
 		cmd := args[1]
 		return strings.Contains(cmd, "mkdir -p") &&
-			strings.Contains(cmd, "install -m 600 /dev/stdin /stacks/myapp/myservice.env") &&
-			strings.Contains(cmd, "DB_HOST=localhost") &&
-			!strings.Contains(cmd, "DB_PORT=5432") &&
-			strings.Contains(cmd, "DB_USER=admin")
+			strings.Contains(cmd, "install -m 600 /dev/null /stacks/myapp/myservice.env.tmp") &&
+			strings.HasSuffix(cmd, " && mv -f /stacks/myapp/myservice.env.tmp /stacks/myapp/myservice.env") &&
+			strings.Contains(testhelpers.WrittenContent(cmd), "DB_HOST=localhost") &&
+			!strings.Contains(testhelpers.WrittenContent(cmd), "DB_PORT=5432") &&
+			strings.Contains(testhelpers.WrittenContent(cmd), "DB_USER=admin")
 	})).Return("", nil).Once()
 
 	err := client.RemoveEnvVar(context.Background(), "myservice", "DB_PORT")
@@ -1045,7 +1616,7 @@ func TestClient_RemoveEnvVar_NotFound(t *testing.T) {
 		cmd := args[1]
 		return strings.Contains(cmd, "mkdir -p") &&
 			strings.Contains(cmd, "install") &&
-			strings.Contains(cmd, "DB_HOST=localhost")
+			strings.Contains(testhelpers.WrittenContent(cmd), "DB_HOST=localhost")
 	})).Return("", nil).Once()
 
 	err := client.RemoveEnvVar(context.Background(), "myservice", "NONEXISTENT")
@@ -1103,10 +1674,10 @@ func TestClient_RemoveEnvVar_PreservesOtherVars(t *testing.T) {
 	mockExec.On("Run", "ssh", mock.MatchedBy(func(args []string) bool {
 		cmd := args[1]
 		return strings.Contains(cmd, "install") &&
-			strings.Contains(cmd, "VAR1=value1") &&
-			!strings.Contains(cmd, "VAR2=value2") &&
-			strings.Contains(cmd, "VAR3=value3") &&
-			strings.Contains(cmd, "VAR4=value4")
+			strings.Contains(testhelpers.WrittenContent(cmd), "VAR1=value1") &&
+			!strings.Contains(testhelpers.WrittenContent(cmd), "VAR2=value2") &&
+			strings.Contains(testhelpers.WrittenContent(cmd), "VAR3=value3") &&
+			strings.Contains(testhelpers.WrittenContent(cmd), "VAR4=value4")
 	})).Return("", nil).Once()
 
 	err := client.RemoveEnvVar(context.Background(), "myservice", "VAR2")
@@ -1115,6 +1686,24 @@ func TestClient_RemoveEnvVar_PreservesOtherVars(t *testing.T) {
 	mockExec.AssertExpectations(t)
 }
 
+func TestClient_WriteEnvFile(t *testing.T) {
+	cfg := newTestConfig()
+	mockExec := new(testhelpers.MockExecutor)
+	client := NewClientWithExecutor(cfg, mockExec)
+
+	mockExec.On("Run", "ssh", mock.MatchedBy(func(args []string) bool {
+		cmd := args[1]
+		return strings.Contains(cmd, "install -m 600 /dev/null /stacks/myapp/myservice.env.tmp") &&
+			strings.HasSuffix(cmd, " && mv -f /stacks/myapp/myservice.env.tmp /stacks/myapp/myservice.env") &&
+			testhelpers.WrittenContent(cmd) == "A=1\nB=2\n"
+	})).Return("", nil).Once()
+
+	err := client.WriteEnvFile(context.Background(), "myservice", "A=1\nB=2\n")
+
+	require.NoError(t, err)
+	mockExec.AssertExpectations(t)
+}
+
 func TestClient_CreateStack_Success(t *testing.T) {
 	cfg := newTestConfig()
 	mockExec := new(testhelpers.MockExecutor)
@@ -1136,9 +1725,10 @@ func TestClient_CreateStack_Success(t *testing.T) {
 	// Second call: write temp file
 	mockExec.On("Run", "ssh", mock.MatchedBy(func(args []string) bool {
 		cmd := args[1]
-		return strings.Contains(cmd, "compose.yaml.tmp") &&
-			strings.Contains(cmd, "services:") &&
-			strings.Contains(cmd, "nginx:latest")
+		return strings.Contains(cmd, "install -m 644 /dev/null /stacks/myapp/compose.yaml.tmp &&") &&
+			!strings.Contains(cmd, "mv -f") &&
+			strings.Contains(testhelpers.WrittenContent(cmd), "services:") &&
+			strings.Contains(testhelpers.WrittenContent(cmd), "nginx:latest")
 	})).Return("", nil).Once()
 
 	// Third call: validate with docker compose config
@@ -1219,10 +1809,13 @@ func TestClient_CreateStack_ValidationFails(t *testing.T) {
 		return strings.Contains(cmd, "docker compose -f compose.yaml.tmp config")
 	})).Return("", errors.New("yaml: invalid syntax"))
 
+	mockExec.On("Run", "ssh", []string{"testserver", "rm -f /stacks/myapp/compose.yaml.tmp"}).Return("", nil).Once()
+
 	err := client.CreateStack(context.Background(), invalidCompose)
 
 	require.Error(t, err)
 	assert.Contains(t, err.Error(), "compose.yaml validation failed")
+	mockExec.AssertExpectations(t)
 }
 
 func TestClient_CreateStack_MoveFails(t *testing.T) {
@@ -1296,6 +1889,79 @@ func TestClient_PullImage_SSHError(t *testing.T) {
 	assert.Contains(t, err.Error(), "connection refused")
 }
 
+func TestClient_PullImage_Digest(t *testing.T) {
+	cfg := newTestConfig()
+	mockExec := new(testhelpers.MockExecutor)
+	client := NewClientWithExecutor(cfg, mockExec)
+	image := "nginx@sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+
+	mockExec.On("RunInteractive", "ssh", mock.MatchedBy(func(args []string) bool {
+		return strings.Contains(args[len(args)-1], "docker pull "+image)
+	})).Return(nil)
+
+	err := client.PullImage(context.Background(), image)
+
+	require.NoError(t, err)
+	mockExec.AssertExpectations(t)
+}
+
+func TestClient_ImageDigest(t *testing.T) {
+	cfg := newTestConfig()
+	mockExec := new(testhelpers.MockExecutor)
+	client := NewClientWithExecutor(cfg, mockExec)
+
+	mockExec.On("Run", "ssh", []string{"testserver", "docker image inspect --format '{{index .RepoDigests 0}}' nginx:1.27"}).
+		Return("nginx@sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef\n", nil)
+
+	ref, err := client.ImageDigest(context.Background(), "nginx:1.27")
+
+	require.NoError(t, err)
+	assert.Equal(t, "nginx@sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef", ref)
+}
+
+func TestClient_ImageInfo(t *testing.T) {
+	cfg := newTestConfig()
+	mockExec := new(testhelpers.MockExecutor)
+	client := NewClientWithExecutor(cfg, mockExec)
+
+	mockExec.On("Run", "ssh", []string{"testserver", "docker image inspect myapp-web:5"}).
+		Return(`[{"Id":"sha256:4f1c","Created":"2026-03-14T09:26:53.5Z","Size":142612480}]`+"\n", nil)
+
+	info, err := client.ImageInfo(context.Background(), "myapp-web:5")
+
+	require.NoError(t, err)
+	assert.Equal(t, "sha256:4f1c", info.ID)
+	assert.Equal(t, int64(142612480), info.Size)
+}
+
+func TestClient_ProbeURL(t *testing.T) {
+	cfg := newTestConfig()
+	mockExec := new(testhelpers.MockExecutor)
+	client := NewClientWithExecutor(cfg, mockExec)
+
+	mockExec.On("Run", "ssh", []string{"testserver", "curl -sS -L -o /dev/null -w '%{http_code}' --max-time 10 https://app.example.com/healthz"}).
+		Return("204", nil)
+
+	status, err := client.ProbeURL(context.Background(), "https://app.example.com/healthz", 10*time.Second)
+
+	require.NoError(t, err)
+	assert.Equal(t, 204, status)
+}
+
+func TestParseRepoDigest_Invalid(t *testing.T) {
+	tests := map[string]string{
+		"no digest":  "\n",
+		"bad digest": "nginx@sha256:abc\n",
+	}
+	for name, out := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := ParseRepoDigest("nginx:1.27", out)
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), "nginx:1.27")
+		})
+	}
+}
+
 func TestClient_StartService_Success(t *testing.T) {
 	cfg := newTestConfig()
 	mockExec := new(testhelpers.MockExecutor)
@@ -1313,6 +1979,71 @@ func TestClient_StartService_Success(t *testing.T) {
 	mockExec.AssertExpectations(t)
 }
 
+func TestClient_StartService_WithProfiles(t *testing.T) {
+	cfg := newTestConfig()
+	cfg.ActiveProfiles = []string{"debug", "setup"}
+	mockExec := new(testhelpers.MockExecutor)
+	client := NewClientWithExecutor(cfg, mockExec)
+
+	mockExec.On("RunInteractive", "ssh", mock.MatchedBy(func(args []string) bool {
+		cmd := args[len(args)-1]
+		return strings.Contains(cmd, "docker compose --profile debug --profile setup up -d --force-recreate web")
+	})).Return(nil)
+
+	err := client.StartService(context.Background(), "web")
+
+	require.NoError(t, err)
+	mockExec.AssertExpectations(t)
+}
+
+func TestClient_StartService_NoForceRecreate(t *testing.T) {
+	cfg := newTestConfig()
+	mockExec := new(testhelpers.MockExecutor)
+	client := NewClientWithExecutor(cfg, mockExec)
+	client.SetRunOptions(RunOptions{NoForceRecreate: true})
+
+	mockExec.On("RunInteractive", "ssh", mock.MatchedBy(func(args []string) bool {
+		cmd := args[len(args)-1]
+		return strings.Contains(cmd, "docker compose up -d web") &&
+			!strings.Contains(cmd, "--force-recreate")
+	})).Return(nil)
+
+	err := client.StartService(context.Background(), "web")
+
+	require.NoError(t, err)
+	mockExec.AssertExpectations(t)
+}
+
+func TestClient_RunJob_Success(t *testing.T) {
+	cfg := newTestConfig()
+	mockExec := new(testhelpers.MockExecutor)
+	client := NewClientWithExecutor(cfg, mockExec)
+
+	mockExec.On("RunInteractive", "ssh", mock.MatchedBy(func(args []string) bool {
+		cmd := args[len(args)-1]
+		return cmd == "cd /stacks/myapp && docker compose run --rm -T web-pre-start"
+	})).Return(nil)
+
+	err := client.RunJob(context.Background(), "web")
+
+	require.NoError(t, err)
+	mockExec.AssertExpectations(t)
+}
+
+func TestClient_RunJob_NonZeroExit(t *testing.T) {
+	cfg := newTestConfig()
+	mockExec := new(testhelpers.MockExecutor)
+	client := NewClientWithExecutor(cfg, mockExec)
+
+	mockExec.On("RunInteractive", "ssh", mock.Anything).Return(errors.New("exit status 1"))
+
+	err := client.RunJob(context.Background(), "web")
+
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "pre_start job for web failed")
+	assert.Contains(t, err.Error(), "exit status 1")
+}
+
 func TestClient_StartService_SSHError(t *testing.T) {
 	cfg := newTestConfig()
 	mockExec := new(testhelpers.MockExecutor)
@@ -1367,6 +2098,61 @@ func TestClient_RolloutService_PluginInstallFails(t *testing.T) {
 	assert.Contains(t, err.Error(), "failed to ensure docker-rollout plugin")
 }
 
+func TestClient_RolloutService_PluginMissingIsActionable(t *testing.T) {
+	cfg := newTestConfig()
+	mockExec := new(testhelpers.MockExecutor)
+	client := NewClientWithExecutor(cfg, mockExec)
+
+	mockExec.On("Run", "ssh", mock.MatchedBy(func(args []string) bool {
+		cmd := args[len(args)-1]
+		return strings.HasPrefix(cmd, "docker rollout --help")
+	})).Return("", errors.New("exit status 1"))
+
+	err := client.RolloutService(context.Background(), "myapp")
+
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "docker rollout is not available on testserver")
+	assert.Contains(t, err.Error(), "install docker-rollout")
+	assert.Contains(t, err.Error(), "set deploy.strategy to recreate")
+	mockExec.AssertNotCalled(t, "RunInteractive", mock.Anything, mock.Anything)
+}
+
+func TestClient_RolloutService_PluginCheckCached(t *testing.T) {
+	cfg := newTestConfig()
+	mockExec := new(testhelpers.MockExecutor)
+	client := NewClientWithExecutor(cfg, mockExec)
+
+	mockExec.On("Run", "ssh", mock.MatchedBy(func(args []string) bool {
+		cmd := args[len(args)-1]
+		return strings.HasPrefix(cmd, "docker rollout --help")
+	})).Return("", nil).Once()
+	mockExec.On("RunInteractive", "ssh", mock.MatchedBy(func(args []string) bool {
+		cmd := args[len(args)-1]
+		return strings.Contains(cmd, "docker rollout")
+	})).Return(nil).Twice()
+
+	require.NoError(t, client.RolloutService(context.Background(), "myapp"))
+	require.NoError(t, client.RolloutService(context.Background(), "myapp"))
+
+	mockExec.AssertNumberOfCalls(t, "Run", 1)
+	mockExec.AssertExpectations(t)
+}
+
+func TestClient_RolloutService_PluginCheckNotCachedOnFailure(t *testing.T) {
+	cfg := newTestConfig()
+	mockExec := new(testhelpers.MockExecutor)
+	client := NewClientWithExecutor(cfg, mockExec)
+
+	mockExec.On("Run", "ssh", mock.Anything).Return("", errors.New("curl failed")).Once()
+	mockExec.On("Run", "ssh", mock.Anything).Return("", nil).Once()
+	mockExec.On("RunInteractive", "ssh", mock.Anything).Return(nil)
+
+	require.Error(t, client.RolloutService(context.Background(), "myapp"))
+	require.NoError(t, client.RolloutService(context.Background(), "myapp"))
+
+	mockExec.AssertNumberOfCalls(t, "Run", 2)
+}
+
 func TestClient_RolloutService_RolloutFails(t *testing.T) {
 	cfg := newTestConfig()
 	mockExec := new(testhelpers.MockExecutor)
@@ -1389,6 +2175,83 @@ func TestClient_RolloutService_RolloutFails(t *testing.T) {
 	assert.Contains(t, err.Error(), "rollout failed")
 }
 
+func TestClient_WriteFile_Roundtrip(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+	}{
+		{"empty", ""},
+		{"single quotes", "command: sh -c 'echo it'\\''s fine'\n"},
+		{"backslashes", "path: C:\\temp\\\\share\nre: '\\''\n"},
+		{"dollar and backticks", "PASSWORD=$HOME$(id)`whoami`${X:-y}\n"},
+		{"no trailing newline", "KEY=value"},
+		{"multi-kilobyte", strings.Repeat("      - \"quote's $x \\\"\n", 400)},
+		{"larger than one chunk", strings.Repeat("x", writeChunkSize*2+17)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := testhelpers.NewRecordingExecutor()
+			client := NewClientWithExecutor(newTestConfig(), rec)
+
+			err := client.WriteFile(context.Background(), "/stacks/myapp/compose.yaml", []byte(tt.content), 0o644)
+
+			require.NoError(t, err)
+			var sent []string
+			for _, cmd := range rec.Commands() {
+				arg := cmd.Args[len(cmd.Args)-1]
+				assert.LessOrEqual(t, len(arg), 128*1024, "command exceeds the per-argument limit")
+				sent = append(sent, arg)
+			}
+			assert.Equal(t, tt.content, testhelpers.WrittenContent(strings.Join(sent, "\n")))
+			assert.True(t, strings.HasPrefix(sent[0], "mkdir -p /stacks/myapp && install -m 644 /dev/null /stacks/myapp/compose.yaml.tmp"), sent[0])
+			assert.True(t, strings.HasSuffix(sent[len(sent)-1], " && mv -f /stacks/myapp/compose.yaml.tmp /stacks/myapp/compose.yaml"), sent[len(sent)-1])
+			assert.Len(t, sent, max(1, (len(tt.content)+writeChunkSize-1)/writeChunkSize))
+		})
+	}
+}
+
+func TestClient_WriteFile_StopsOnError(t *testing.T) {
+	rec := testhelpers.NewRecordingExecutor().Fail("install -m", errors.New("disk full"))
+	client := NewClientWithExecutor(newTestConfig(), rec)
+
+	err := client.WriteFile(context.Background(), "/stacks/myapp/web.env", []byte(strings.Repeat("x", writeChunkSize+1)), 0o600)
+
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "disk full")
+	assert.Len(t, rec.Commands(), 1)
+}
+
+func TestClient_WriteFile_FailedChunkLeavesFileAlone(t *testing.T) {
+	// The second chunk is a lone "x", base64 eA==; the first has no padding.
+	rec := testhelpers.NewRecordingExecutor().Fail("printf %s eA==", errors.New("connection reset"))
+	client := NewClientWithExecutor(newTestConfig(), rec)
+
+	err := client.WriteFile(context.Background(), "/stacks/myapp/web.env", []byte(strings.Repeat("x", writeChunkSize+1)), 0o600)
+
+	require.Error(t, err)
+	cmds := rec.Commands()
+	require.Len(t, cmds, 2)
+	for _, cmd := range cmds {
+		arg := cmd.Args[len(cmd.Args)-1]
+		assert.NotContains(t, arg, "install -m 600 /dev/null /stacks/myapp/web.env ", "only the temp file is truncated")
+		assert.NotContains(t, arg, ">> /stacks/myapp/web.env ", "only the temp file is appended to")
+	}
+	// The rename runs only once the last chunk succeeded.
+	assert.True(t, strings.HasSuffix(cmds[1].Args[len(cmds[1].Args)-1], " && mv -f /stacks/myapp/web.env.tmp /stacks/myapp/web.env"))
+}
+
+func TestClient_SetEnvVar_PreservesSpecialCharacters(t *testing.T) {
+	rec := testhelpers.NewRecordingExecutor().Respond("cat /stacks/myapp/web.env", "A='x'\\''y'\n")
+	client := NewClientWithExecutor(newTestConfig(), rec)
+
+	err := client.SetEnvVar(context.Background(), "web", "B", `p@$$w0rd'"\`)
+
+	require.NoError(t, err)
+	cmds := rec.Commands()
+	require.Len(t, cmds, 2)
+	assert.Equal(t, "A='x'\\''y'\nB=p@$$w0rd'\"\\\n", testhelpers.WrittenContent(cmds[1].Args[len(cmds[1].Args)-1]))
+}
+
 func TestClient_CopyFiles_Success(t *testing.T) {
 	cfg := newTestConfig()
 	mockExec := new(testhelpers.MockExecutor)
@@ -1459,3 +2322,86 @@ func TestClient_CopyFiles_SSHError(t *testing.T) {
 	require.Error(t, err)
 	assert.Contains(t, err.Error(), "failed to copy file")
 }
+
+func TestClient_BuildImage_WithBuildArgs(t *testing.T) {
+	cfg := newTestConfig()
+	cfg.BuildArgs = map[string]string{"NODE_ENV": "production", "BUILD_NUMBER": "dev"}
+	mockExec := new(testhelpers.MockExecutor)
+	client := NewClientWithExecutor(cfg, mockExec)
+	client.SetRunOptions(RunOptions{BuildArgs: map[string]string{"BUILD_NUMBER": "42", "NOTE": "a b"}})
+
+	mockExec.On("RunInteractive", "ssh", mock.MatchedBy(func(args []string) bool {
+		cmd := args[len(args)-1]
+		return strings.Contains(cmd, "--build-arg BUILD_NUMBER=42 --build-arg NODE_ENV=production --build-arg 'NOTE=a b'")
+	})).Return(nil)
+
+	err := client.BuildImage(context.Background(), "/tmp/build", 1)
+
+	require.NoError(t, err)
+	mockExec.AssertExpectations(t)
+}
+
+func TestComposeCommand(t *testing.T) {
+	cfg := newTestConfig()
+	assert.Equal(t, "docker compose", ComposeCommand(cfg))
+
+	cfg.ComposeFile = "compose.yaml"
+	assert.Equal(t, "docker compose", ComposeCommand(cfg))
+
+	cfg.ComposeFile = "docker-compose.yml"
+	assert.Equal(t, "docker compose -f docker-compose.yml", ComposeCommand(cfg))
+}
+
+func TestClient_ComposeFilename_UsedByAllCommands(t *testing.T) {
+	cfg := newTestConfig()
+	cfg.ComposeFile = "docker-compose.yml"
+	cfg.PreStart = &config.PreStartConfig{Command: "migrate"}
+	cfg.Schedule = "0 3 * * *"
+	rec := testhelpers.NewRecordingExecutor().
+		Respond("ps -q", "running healthy\n").
+		Respond("echo yes", "yes\n")
+	client := NewClientWithExecutor(cfg, rec)
+	ctx := context.Background()
+
+	_, err := client.ReadManifest(ctx)
+	require.NoError(t, err)
+	exists, err := client.StackExists(ctx)
+	require.NoError(t, err)
+	assert.True(t, exists)
+	require.NoError(t, client.CreateStack(ctx, "services: {}\n"))
+	require.NoError(t, client.UpdateManifest(ctx, 2))
+	require.NoError(t, client.RestartStack(ctx))
+	require.NoError(t, client.StartService(ctx, "myapp"))
+	require.NoError(t, client.StopService(ctx, "myapp"))
+	require.NoError(t, client.RunJob(ctx, "myapp"))
+	require.NoError(t, client.WaitForHealthy(ctx, "myapp", time.Second, 0))
+	_, err = client.IsServiceRunning(ctx, "myapp")
+	require.NoError(t, err)
+	_, err = client.GetContainerStatus(ctx, "myapp")
+	require.NoError(t, err)
+	require.NoError(t, client.GetLogs(ctx, logs.Options{Tail: 10}))
+	require.NoError(t, client.Down(ctx, false))
+	require.NoError(t, client.SyncSchedule(ctx))
+
+	transcript := rec.Transcript()
+	assert.NotContains(t, transcript, "compose.yaml")
+	assert.Contains(t, transcript, "cat /stacks/myapp/docker-compose.yml")
+	assert.Contains(t, transcript, "test -f /stacks/myapp/docker-compose.yml")
+	assert.Contains(t, transcript, "docker compose -f docker-compose.yml.tmp config")
+	assert.Contains(t, transcript, "mv /stacks/myapp/docker-compose.yml.tmp /stacks/myapp/docker-compose.yml")
+	assert.Contains(t, transcript, "|g' /stacks/myapp/docker-compose.yml")
+	var units string
+	for _, cmd := range rec.Commands() {
+		units += testhelpers.WrittenContent(cmd.Args[len(cmd.Args)-1])
+		last := cmd.Args[len(cmd.Args)-1]
+		for rest := last; ; {
+			i := strings.Index(rest, "docker compose ")
+			if i < 0 {
+				break
+			}
+			rest = rest[i+len("docker compose "):]
+			assert.True(t, strings.HasPrefix(rest, "-f docker-compose.yml"), "command without -f: %s", last)
+		}
+	}
+	assert.Contains(t, units, "ExecStart=/usr/bin/env docker compose -f docker-compose.yml run --rm myapp\n")
+}
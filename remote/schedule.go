@@ -0,0 +1,49 @@
+package remote
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+
+	"al.essio.dev/pkg/shellescape"
+	"github.com/byteink/ssd/schedule"
+)
+
+// SyncSchedule makes the service's systemd timer match its schedule.
+// With a schedule, ssd-{project}-{service}.service and .timer are written
+// to the stack directory, linked into systemd and the timer (re)started.
+// Without one, a timer left by an earlier deploy is disabled and its
+// units removed; when there is none this is a single cheap check.
+func (c *Client) SyncSchedule(ctx context.Context) error {
+	unit := schedule.UnitName(c.cfg.ProjectName(), c.cfg.Name)
+	stack := c.cfg.StackPath()
+	servicePath := filepath.Join(stack, unit+".service")
+	timerPath := filepath.Join(stack, unit+".timer")
+	timer := shellescape.Quote(unit + ".timer")
+
+	if c.cfg.Schedule == "" {
+		cmd := fmt.Sprintf("if [ -e %[1]s ]; then sudo systemctl disable --now %[3]s && sudo systemctl disable %[4]s && rm -f %[1]s %[2]s && sudo systemctl daemon-reload; fi",
+			shellescape.Quote(timerPath), shellescape.Quote(servicePath), timer, shellescape.Quote(unit+".service"))
+		if _, err := c.SSH(ctx, cmd); err != nil {
+			return fmt.Errorf("failed to remove schedule: %w", err)
+		}
+		return nil
+	}
+
+	calendar, err := schedule.OnCalendar(c.cfg.Schedule)
+	if err != nil {
+		return err
+	}
+	if err := c.WriteFile(ctx, servicePath, []byte(schedule.ServiceUnit(c.cfg.Name, stack, ComposeCommand(c.cfg))), 0o644); err != nil {
+		return fmt.Errorf("failed to write %s.service: %w", unit, err)
+	}
+	if err := c.WriteFile(ctx, timerPath, []byte(schedule.TimerUnit(c.cfg.Name, stack, calendar)), 0o644); err != nil {
+		return fmt.Errorf("failed to write %s.timer: %w", unit, err)
+	}
+	cmd := fmt.Sprintf("sudo systemctl link %s %s && sudo systemctl daemon-reload && sudo systemctl enable %s && sudo systemctl restart %s",
+		shellescape.Quote(servicePath), shellescape.Quote(timerPath), timer, timer)
+	if _, err := c.SSH(ctx, cmd); err != nil {
+		return fmt.Errorf("failed to enable %s.timer: %w", unit, err)
+	}
+	return nil
+}
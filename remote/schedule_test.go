@@ -0,0 +1,73 @@
+package remote
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/byteink/ssd/internal/testhelpers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClient_SyncSchedule_Installs(t *testing.T) {
+	cfg := newTestConfig()
+	cfg.Schedule = "0 3 * * *"
+	mockExec := new(testhelpers.MockExecutor)
+	client := NewClientWithExecutor(cfg, mockExec)
+
+	var writes []string
+	mockExec.On("Run", "ssh", mock.MatchedBy(func(args []string) bool {
+		return strings.Contains(args[len(args)-1], "base64 -d >>")
+	})).Run(func(a mock.Arguments) {
+		args := a.Get(1).([]string)
+		writes = append(writes, args[len(args)-1])
+	}).Return("", nil)
+	mockExec.On("Run", "ssh", []string{"testserver",
+		"sudo systemctl link /stacks/myapp/ssd-myapp-myapp.service /stacks/myapp/ssd-myapp-myapp.timer" +
+			" && sudo systemctl daemon-reload && sudo systemctl enable ssd-myapp-myapp.timer" +
+			" && sudo systemctl restart ssd-myapp-myapp.timer"}).Return("", nil)
+
+	require.NoError(t, client.SyncSchedule(context.Background()))
+	mockExec.AssertExpectations(t)
+	require.Len(t, writes, 2)
+	assert.Contains(t, writes[0], "/stacks/myapp/ssd-myapp-myapp.service")
+	assert.Contains(t, writes[1], "/stacks/myapp/ssd-myapp-myapp.timer")
+	assert.Contains(t, writes[1], base64.StdEncoding.EncodeToString([]byte("[Unit]\nDescription=Schedule for ssd job myapp")))
+}
+
+func TestClient_SyncSchedule_RemovesWhenUnset(t *testing.T) {
+	cfg := newTestConfig()
+	mockExec := new(testhelpers.MockExecutor)
+	client := NewClientWithExecutor(cfg, mockExec)
+
+	mockExec.On("Run", "ssh", []string{"testserver",
+		"if [ -e /stacks/myapp/ssd-myapp-myapp.timer ]; then sudo systemctl disable --now ssd-myapp-myapp.timer" +
+			" && sudo systemctl disable ssd-myapp-myapp.service" +
+			" && rm -f /stacks/myapp/ssd-myapp-myapp.timer /stacks/myapp/ssd-myapp-myapp.service" +
+			" && sudo systemctl daemon-reload; fi"}).Return("", nil)
+
+	require.NoError(t, client.SyncSchedule(context.Background()))
+	mockExec.AssertExpectations(t)
+}
+
+func TestClient_SyncSchedule_EnableError(t *testing.T) {
+	cfg := newTestConfig()
+	cfg.Schedule = "@daily"
+	mockExec := new(testhelpers.MockExecutor)
+	client := NewClientWithExecutor(cfg, mockExec)
+
+	mockExec.On("Run", "ssh", mock.MatchedBy(func(args []string) bool {
+		return strings.Contains(args[len(args)-1], "base64 -d >>")
+	})).Return("", nil)
+	mockExec.On("Run", "ssh", mock.MatchedBy(func(args []string) bool {
+		return strings.HasPrefix(args[len(args)-1], "sudo systemctl link")
+	})).Return("", errors.New("sudo: a password is required"))
+
+	err := client.SyncSchedule(context.Background())
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "failed to enable ssd-myapp-myapp.timer")
+}
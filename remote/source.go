@@ -0,0 +1,111 @@
+package remote
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"al.essio.dev/pkg/shellescape"
+)
+
+// sourceFile returns the stack-relative file holding the git tree of the
+// build context last deployed for serviceName.
+func sourceFile(serviceName string) string {
+	return ".ssd-sha-" + serviceName
+}
+
+// SourceTree returns the git tree SHA of the build context at HEAD. It
+// only changes when committed files under the context change, which is
+// what Rsync ships to the server. When the Dockerfile lives outside the
+// context and is shipped alongside it (see layout), its blob SHA is
+// hashed together with the tree, so editing only the Dockerfile changes
+// the result too.
+func (c *Client) SourceTree(ctx context.Context) (string, error) {
+	localContext, err := filepath.Abs(c.cfg.Context)
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve context path: %w", err)
+	}
+	gitRoot, err := c.findGitRoot(localContext)
+	if err != nil {
+		return "", err
+	}
+	relPath, err := filepath.Rel(gitRoot, localContext)
+	if err != nil {
+		return "", fmt.Errorf("failed to compute relative path: %w", err)
+	}
+	rev := "HEAD^{tree}"
+	if relPath != "." {
+		rev = "HEAD:" + filepath.ToSlash(relPath)
+	}
+	out, err := c.executor.Run(ctx, "git", "-C", gitRoot, "rev-parse", rev)
+	if err != nil {
+		return "", fmt.Errorf("failed to read source tree: %w", err)
+	}
+	tree := strings.TrimSpace(out)
+
+	layout, err := c.layout(localContext)
+	if err != nil {
+		return "", err
+	}
+	if len(layout.archive) == 0 {
+		return tree, nil
+	}
+	out, err = c.executor.Run(ctx, "git", "-C", gitRoot, "rev-parse", "HEAD:"+layout.dockerfile)
+	if err != nil {
+		return "", fmt.Errorf("failed to read dockerfile %s at HEAD: %w", layout.dockerfile, err)
+	}
+	sum := sha256.Sum256([]byte(tree + "\n" + strings.TrimSpace(out) + "\n"))
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// UncommittedChanges lists the uncommitted and untracked changes under
+// the build context, one `git status --porcelain` line each. Rsync ships
+// `git archive HEAD`, so none of them reach the server.
+func (c *Client) UncommittedChanges(ctx context.Context) ([]string, error) {
+	localContext, err := filepath.Abs(c.cfg.Context)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve context path: %w", err)
+	}
+	gitRoot, err := c.findGitRoot(localContext)
+	if err != nil {
+		return nil, err
+	}
+	relPath, err := filepath.Rel(gitRoot, localContext)
+	if err != nil {
+		return nil, fmt.Errorf("failed to compute relative path: %w", err)
+	}
+	out, err := c.executor.Run(ctx, "git", "-C", gitRoot, "status", "--porcelain", "--untracked-files=all", "--", filepath.ToSlash(relPath))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read git status: %w", err)
+	}
+	var changes []string
+	for _, line := range strings.Split(out, "\n") {
+		if strings.TrimSpace(line) != "" {
+			changes = append(changes, line)
+		}
+	}
+	return changes, nil
+}
+
+// DeployedSourceTree returns the source tree recorded by the last deploy
+// of serviceName, or "" when none was recorded.
+func (c *Client) DeployedSourceTree(ctx context.Context, serviceName string) (string, error) {
+	path := filepath.Join(c.cfg.StackPath(), sourceFile(serviceName))
+	out, err := c.SSH(ctx, fmt.Sprintf("cat %s 2>/dev/null || true", shellescape.Quote(path)))
+	if err != nil {
+		return "", fmt.Errorf("failed to read deployed source tree: %w", err)
+	}
+	return strings.TrimSpace(out), nil
+}
+
+// RecordSourceTree stores tree as the source deployed for serviceName.
+func (c *Client) RecordSourceTree(ctx context.Context, serviceName, tree string) error {
+	path := filepath.Join(c.cfg.StackPath(), sourceFile(serviceName))
+	if err := c.WriteFile(ctx, path, []byte(tree+"\n"), 0644); err != nil {
+		return fmt.Errorf("failed to record source tree: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,197 @@
+package remote
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/byteink/ssd/internal/testhelpers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClient_SourceTree_RootContext(t *testing.T) {
+	cfg := newTestConfig()
+	mockExec := new(testhelpers.MockExecutor)
+	client := NewClientWithExecutor(cfg, mockExec)
+	root, err := filepath.Abs(".")
+	require.NoError(t, err)
+	client.findGitRoot = func(string) (string, error) { return root, nil }
+
+	mockExec.On("Run", "git", []string{"-C", root, "rev-parse", "HEAD^{tree}"}).Return("abc123\n", nil)
+
+	tree, err := client.SourceTree(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, "abc123", tree)
+}
+
+func TestClient_SourceTree_SubdirContext(t *testing.T) {
+	cfg := newTestConfig()
+	cfg.Context = "./api"
+	mockExec := new(testhelpers.MockExecutor)
+	client := NewClientWithExecutor(cfg, mockExec)
+	root, err := filepath.Abs(".")
+	require.NoError(t, err)
+	client.findGitRoot = func(string) (string, error) { return root, nil }
+
+	mockExec.On("Run", "git", []string{"-C", root, "rev-parse", "HEAD:api"}).Return("def456\n", nil)
+
+	tree, err := client.SourceTree(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, "def456", tree)
+}
+
+func TestClient_SourceTree_DockerfileOutsideContext(t *testing.T) {
+	root := newLayoutRepo(t, "docker/web.Dockerfile")
+	sourceTree := func(dockerfileBlob string) string {
+		client, mockExec := newLayoutClient(root, "./apps/web", "./docker/web.Dockerfile")
+		mockExec.On("Run", "git", []string{"-C", root, "rev-parse", "HEAD:apps/web"}).Return("def456\n", nil)
+		mockExec.On("Run", "git", []string{"-C", root, "rev-parse", "HEAD:docker/web.Dockerfile"}).Return(dockerfileBlob+"\n", nil)
+		tree, err := client.SourceTree(context.Background())
+		require.NoError(t, err)
+		mockExec.AssertExpectations(t)
+		return tree
+	}
+
+	before := sourceTree("aaa111")
+	assert.NotEqual(t, "def456", before, "the Dockerfile is part of the recorded source")
+	assert.Equal(t, before, sourceTree("aaa111"))
+	assert.NotEqual(t, before, sourceTree("bbb222"), "a Dockerfile-only change must change the source tree")
+}
+
+func TestClient_SourceTree_DockerfileInContextKeepsTree(t *testing.T) {
+	root := newLayoutRepo(t, "apps/web/Dockerfile")
+	client, mockExec := newLayoutClient(root, "./apps/web", "./apps/web/Dockerfile")
+	mockExec.On("Run", "git", []string{"-C", root, "rev-parse", "HEAD:apps/web"}).Return("def456\n", nil)
+
+	tree, err := client.SourceTree(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, "def456", tree)
+	mockExec.AssertExpectations(t)
+}
+
+func TestClient_SourceTree_NoGitRepo(t *testing.T) {
+	cfg := newTestConfig()
+	client := NewClientWithExecutor(cfg, new(testhelpers.MockExecutor))
+	client.findGitRoot = func(string) (string, error) { return "", errors.New("not a git repository") }
+
+	_, err := client.SourceTree(context.Background())
+	require.Error(t, err)
+}
+
+func TestClient_DeployedSourceTree(t *testing.T) {
+	cfg := newTestConfig()
+	mockExec := new(testhelpers.MockExecutor)
+	client := NewClientWithExecutor(cfg, mockExec)
+
+	mockExec.On("Run", "ssh", []string{"testserver", "cat /stacks/myapp/.ssd-sha-web 2>/dev/null || true"}).Return("abc123\n", nil)
+
+	tree, err := client.DeployedSourceTree(context.Background(), "web")
+	require.NoError(t, err)
+	assert.Equal(t, "abc123", tree)
+}
+
+func TestClient_RecordSourceTree(t *testing.T) {
+	cfg := newTestConfig()
+	mockExec := new(testhelpers.MockExecutor)
+	client := NewClientWithExecutor(cfg, mockExec)
+
+	var cmd string
+	mockExec.On("Run", "ssh", mock.Anything).Return("", nil).Run(func(args mock.Arguments) {
+		sshArgs := args.Get(1).([]string)
+		cmd = sshArgs[len(sshArgs)-1]
+	})
+
+	require.NoError(t, client.RecordSourceTree(context.Background(), "web", "abc123"))
+	assert.Contains(t, cmd, "/stacks/myapp/.ssd-sha-web")
+	assert.Equal(t, "abc123\n", testhelpers.WrittenContent(cmd))
+}
+
+// newGitRepo creates a committed git repository with an api/ subdirectory.
+func newGitRepo(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "api"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "api", "main.go"), []byte("package main\n"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "README.md"), []byte("readme\n"), 0644))
+	for _, args := range [][]string{
+		{"init", "-q"},
+		{"-c", "user.email=test@test.com", "-c", "user.name=Test", "add", "-A"},
+		{"-c", "user.email=test@test.com", "-c", "user.name=Test", "commit", "-q", "-m", "init"},
+	} {
+		out, err := exec.Command("git", append([]string{"-C", dir}, args...)...).CombinedOutput()
+		require.NoError(t, err, "git %v: %s", args, out)
+	}
+	return dir
+}
+
+func TestClient_UncommittedChanges_CleanTree(t *testing.T) {
+	dir := newGitRepo(t)
+	cfg := newTestConfig()
+	cfg.Context = dir
+	client := NewClientWithExecutor(cfg, NewRealExecutor())
+
+	changes, err := client.UncommittedChanges(context.Background())
+	require.NoError(t, err)
+	assert.Empty(t, changes)
+}
+
+func TestClient_UncommittedChanges_DirtyTree(t *testing.T) {
+	dir := newGitRepo(t)
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "api", "main.go"), []byte("package main\n\nfunc main() {}\n"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "api", "new.go"), []byte("package main\n"), 0644))
+	cfg := newTestConfig()
+	cfg.Context = dir
+	client := NewClientWithExecutor(cfg, NewRealExecutor())
+
+	changes, err := client.UncommittedChanges(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, []string{" M api/main.go", "?? api/new.go"}, changes)
+}
+
+func TestClient_UncommittedChanges_OnlyContextDir(t *testing.T) {
+	dir := newGitRepo(t)
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "README.md"), []byte("changed\n"), 0644))
+	cfg := newTestConfig()
+	cfg.Context = filepath.Join(dir, "api")
+	client := NewClientWithExecutor(cfg, NewRealExecutor())
+
+	changes, err := client.UncommittedChanges(context.Background())
+	require.NoError(t, err)
+	assert.Empty(t, changes, "changes outside the build context are not reported")
+}
+
+func TestChangedFiles(t *testing.T) {
+	dir := newGitRepo(t)
+	git := func(args ...string) {
+		args = append([]string{"-C", dir, "-c", "user.email=test@test.com", "-c", "user.name=Test"}, args...)
+		out, err := exec.Command("git", args...).CombinedOutput()
+		require.NoError(t, err, "git %v: %s", args, out)
+	}
+	git("tag", "v1")
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "web"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "web", "index.html"), []byte("hi\n"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "api", "main.go"), []byte("package main\n\nfunc main() {}\n"), 0644))
+	git("add", "-A")
+	git("commit", "-q", "-m", "change")
+
+	files, err := ChangedFiles(dir, "v1")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"api/main.go", "web/index.html"}, files)
+
+	files, err = ChangedFiles(dir, "HEAD")
+	require.NoError(t, err)
+	assert.Empty(t, files)
+
+	_, err = ChangedFiles(dir, "v9")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "git diff v9...HEAD failed")
+
+	_, err = ChangedFiles(dir, "--output=/tmp/x")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "invalid git ref")
+}
@@ -0,0 +1,56 @@
+package remote
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"al.essio.dev/pkg/shellescape"
+	"github.com/byteink/ssd/stacks"
+)
+
+// ListStacks returns every ssd-managed compose stack on the server with
+// its services and their states. A project counts as ssd-managed when one
+// of its containers runs an ssd-built image or its directory holds the
+// deploy history file.
+func (c *Client) ListStacks(ctx context.Context) ([]stacks.Stack, error) {
+	out, err := c.SSH(ctx, stacks.ListCommand)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list compose projects: %w", err)
+	}
+	all, err := stacks.ParseComposeLs(out)
+	if err != nil {
+		return nil, err
+	}
+	if len(all) == 0 {
+		return nil, nil
+	}
+
+	out, err = c.SSH(ctx, stacks.ContainersCommand)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list containers: %w", err)
+	}
+	containers := stacks.ParseContainers(out)
+
+	markers := make([]string, 0, len(all))
+	for _, s := range all {
+		if s.Dir != "" {
+			markers = append(markers, shellescape.Quote(stacks.MarkerPath(s.Dir)))
+		}
+	}
+	var marked []string
+	if len(markers) > 0 {
+		out, err = c.SSH(ctx, fmt.Sprintf("ls -d %s 2>/dev/null || true", strings.Join(markers, " ")))
+		if err != nil {
+			return nil, fmt.Errorf("failed to look for stack markers: %w", err)
+		}
+		for _, line := range strings.Split(out, "\n") {
+			if line = strings.TrimSpace(line); line != "" {
+				marked = append(marked, filepath.Dir(line))
+			}
+		}
+	}
+
+	return stacks.Managed(all, containers, marked), nil
+}
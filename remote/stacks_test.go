@@ -0,0 +1,65 @@
+package remote
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/byteink/ssd/internal/testhelpers"
+	"github.com/byteink/ssd/stacks"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClient_ListStacks(t *testing.T) {
+	cfg := newTestConfig()
+	mockExec := new(testhelpers.MockExecutor)
+	client := NewClientWithExecutor(cfg, mockExec)
+
+	mockExec.On("Run", "ssh", []string{"testserver", stacks.ListCommand}).Return(
+		`[{"Name":"shop","Status":"running(2)","ConfigFiles":"/stacks/shop/compose.yaml"},`+
+			`{"Name":"blog","Status":"exited(1)","ConfigFiles":"/stacks/blog/compose.yaml"},`+
+			`{"Name":"other","Status":"running(1)","ConfigFiles":"/opt/other/compose.yaml"}]`, nil)
+	mockExec.On("Run", "ssh", []string{"testserver", stacks.ContainersCommand}).Return(
+		"shop\tweb\tssd-shop-web:3\trunning\nshop\tdb\tpostgres:16\trunning\nblog\tghost\tghost:5\texited\nother\tapp\tnginx\trunning\n", nil)
+	mockExec.On("Run", "ssh", []string{"testserver",
+		"ls -d /stacks/shop/.ssd-history /stacks/blog/.ssd-history /opt/other/.ssd-history 2>/dev/null || true"}).
+		Return("/stacks/blog/.ssd-history\n", nil)
+
+	list, err := client.ListStacks(context.Background())
+
+	require.NoError(t, err)
+	require.Len(t, list, 2)
+	assert.Equal(t, "blog", list[0].Name)
+	assert.Equal(t, "shop", list[1].Name)
+	assert.Equal(t, "running(2)", list[1].Status)
+	assert.Len(t, list[1].Services, 2)
+	mockExec.AssertExpectations(t)
+}
+
+func TestClient_ListStacks_NoProjects(t *testing.T) {
+	cfg := newTestConfig()
+	mockExec := new(testhelpers.MockExecutor)
+	client := NewClientWithExecutor(cfg, mockExec)
+
+	mockExec.On("Run", "ssh", []string{"testserver", stacks.ListCommand}).Return("[]\n", nil)
+
+	list, err := client.ListStacks(context.Background())
+
+	require.NoError(t, err)
+	assert.Empty(t, list)
+	mockExec.AssertNumberOfCalls(t, "Run", 1)
+}
+
+func TestClient_ListStacks_SSHError(t *testing.T) {
+	cfg := newTestConfig()
+	mockExec := new(testhelpers.MockExecutor)
+	client := NewClientWithExecutor(cfg, mockExec)
+
+	mockExec.On("Run", "ssh", []string{"testserver", stacks.ListCommand}).Return("", errors.New("connection refused"))
+
+	_, err := client.ListStacks(context.Background())
+
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "failed to list compose projects")
+}
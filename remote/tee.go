@@ -0,0 +1,60 @@
+package remote
+
+import (
+	"errors"
+	"io"
+	"os"
+	"sync"
+)
+
+// teeOutput, when set, receives a copy of everything RunInteractive
+// streams to the terminal. It is shared by every executor in the process.
+var teeOutput io.Writer
+
+// TeeOutput copies all streamed command output (builds, rsync, docker
+// rollout) to w as well as to the terminal, e.g. a --log-file kept as a CI
+// artifact. Writes to w are serialized, since stdout, stderr and
+// concurrent deploys all share it. A nil w stops the copying.
+func TeeOutput(w io.Writer) {
+	if w == nil {
+		teeOutput = nil
+		return
+	}
+	teeOutput = &lockedWriter{w: w}
+}
+
+// lockedWriter serializes writes to w.
+type lockedWriter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (l *lockedWriter) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.w.Write(p)
+}
+
+// teeWriter returns a writer that writes to w and, when tee is not nil,
+// to tee as well.
+func teeWriter(w, tee io.Writer) io.Writer {
+	if tee == nil {
+		return w
+	}
+	return io.MultiWriter(w, tee)
+}
+
+// interactiveStreams returns the stdout and stderr RunInteractive hands
+// to a command: the terminal, copied to teeOutput and with every line
+// prefixed when prefix is set. flush writes out held partial lines once
+// the command is done.
+func interactiveStreams(prefix string) (stdout, stderr io.Writer, flush func() error) {
+	stdout = teeWriter(os.Stdout, teeOutput)
+	stderr = teeWriter(os.Stderr, teeOutput)
+	if prefix == "" {
+		return stdout, stderr, func() error { return nil }
+	}
+	pout := NewPrefixWriter(stdout, prefix)
+	perr := NewPrefixWriter(stderr, prefix)
+	return pout, perr, func() error { return errors.Join(pout.Flush(), perr.Flush()) }
+}
@@ -0,0 +1,43 @@
+package remote
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTeeWriter_CopiesAndPassesThrough(t *testing.T) {
+	var terminal, log bytes.Buffer
+	w := teeWriter(&terminal, &log)
+
+	for _, chunk := range []string{"#1 [build 1/3] FROM golang", ":1.23\n", "#2 DONE 0.4s\n"} {
+		n, err := w.Write([]byte(chunk))
+		require.NoError(t, err)
+		assert.Equal(t, len(chunk), n)
+	}
+
+	want := "#1 [build 1/3] FROM golang:1.23\n#2 DONE 0.4s\n"
+	assert.Equal(t, want, terminal.String())
+	assert.Equal(t, want, log.String())
+}
+
+func TestTeeWriter_NoTee(t *testing.T) {
+	var terminal bytes.Buffer
+	assert.Same(t, &terminal, teeWriter(&terminal, nil))
+}
+
+func TestRealExecutor_RunInteractive_TeesOutput(t *testing.T) {
+	var log bytes.Buffer
+	TeeOutput(&log)
+	t.Cleanup(func() { TeeOutput(nil) })
+
+	e := &RealExecutor{Prefix: "[web] "}
+	require.NoError(t, e.RunInteractive(context.Background(), "sh", "-c", "echo building; echo warning >&2; printf done"))
+
+	assert.Contains(t, log.String(), "[web] building\n")
+	assert.Contains(t, log.String(), "[web] warning\n")
+	assert.Contains(t, log.String(), "[web] done\n")
+}
@@ -0,0 +1,130 @@
+package remote
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// snippetContext is how many lines around the offending one
+// validationSnippet shows.
+const snippetContext = 2
+
+var (
+	yamlLineRe = regexp.MustCompile(`line (\d+)`)
+	// serviceRe matches how compose names a service in its errors:
+	// "services.web.ports" or `service "web"`.
+	serviceRe = regexp.MustCompile(`services\.([A-Za-z0-9_-]+)|service "([^"]+)"`)
+)
+
+// commandStderr returns the stderr a failed command carried in its error:
+// executors report "command failed: <status>" followed by stderr on the
+// following lines.
+func commandStderr(err error) string {
+	_, stderr, found := strings.Cut(err.Error(), "\n")
+	if !found {
+		return ""
+	}
+	return strings.TrimSpace(stderr)
+}
+
+// IsTransient reports whether err, from a command run over SSH, failed
+// without an answer from the server: no stderr came back, or ssh exited
+// with its own status 255 (e.g. a dropped connection). Trying again may
+// work. A command that ran and failed is not transient.
+func IsTransient(err error) bool {
+	return err != nil && (commandStderr(err) == "" || strings.Contains(err.Error(), "exit status 255"))
+}
+
+// validationErrorLine picks the line of docker compose config output that
+// explains the failure: the first mentioning "error" or "invalid", else
+// the last non-empty line, since warnings come first and the error last.
+func validationErrorLine(output string) string {
+	var last string
+	for _, line := range strings.Split(output, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		lower := strings.ToLower(line)
+		if strings.Contains(lower, "error") || strings.Contains(lower, "invalid") {
+			return line
+		}
+		last = line
+	}
+	return last
+}
+
+// validationSnippet returns the part of the generated compose content the
+// error line refers to, numbered like an editor: the lines around
+// "line N" for YAML errors, or the start of the service block for errors
+// naming services.<name>. Returns "" when the line points nowhere.
+func validationSnippet(content, errLine string) string {
+	lines := strings.Split(content, "\n")
+	at := -1
+	if m := yamlLineRe.FindStringSubmatch(errLine); m != nil {
+		if n, err := strconv.Atoi(m[1]); err == nil && n >= 1 && n <= len(lines) {
+			at = n - 1
+		}
+	} else if m := serviceRe.FindStringSubmatch(errLine); m != nil {
+		service := m[1] + m[2]
+		for i, line := range lines {
+			if strings.TrimSpace(line) == service+":" {
+				at = i
+				break
+			}
+		}
+	}
+	if at < 0 {
+		return ""
+	}
+	start := max(at-snippetContext, 0)
+	end := min(at+snippetContext+1, len(lines))
+	var b strings.Builder
+	for i := start; i < end; i++ {
+		marker := "  "
+		if i == at {
+			marker = "> "
+		}
+		fmt.Fprintf(&b, "%s%4d | %s\n", marker, i+1, lines[i])
+	}
+	return strings.TrimRight(b.String(), "\n")
+}
+
+// saveRejectedCompose writes the compose content docker rejected to a
+// local temp file so it can be inspected, and returns its path.
+func saveRejectedCompose(name, content string) (string, error) {
+	f, err := os.CreateTemp("", "ssd-rejected-*-"+name)
+	if err != nil {
+		return "", err
+	}
+	if _, err := f.WriteString(content); err != nil {
+		f.Close()
+		return "", err
+	}
+	if err := f.Close(); err != nil {
+		return "", err
+	}
+	return f.Name(), nil
+}
+
+// composeValidationError builds the error for a compose file that failed
+// `docker compose config`: the meaningful line of docker's output, the
+// generated lines it points at, and where the rejected file was saved.
+func composeValidationError(name, content, output string, err error) error {
+	errLine := validationErrorLine(output)
+	if errLine == "" {
+		return fmt.Errorf("%s validation failed: %w", name, err)
+	}
+	msg := fmt.Sprintf("%s validation failed: %s", name, errLine)
+	if snippet := validationSnippet(content, errLine); snippet != "" {
+		msg += "\n" + snippet
+	}
+	if path, saveErr := saveRejectedCompose(name, content); saveErr == nil {
+		msg += "\nRejected " + name + " saved to " + path
+	}
+	return errors.New(msg)
+}
@@ -0,0 +1,126 @@
+package remote
+
+import (
+	"context"
+	"errors"
+	"os"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/byteink/ssd/internal/testhelpers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+const rejectedCompose = `services:
+  web:
+    image: ssd-myapp-web:3
+    ports:
+      - 80
+  worker:
+    image: ssd-myapp-worker:3
+`
+
+func TestValidationErrorLine(t *testing.T) {
+	tests := []struct {
+		name   string
+		output string
+		want   string
+	}{
+		{
+			"warning before error",
+			"WARN[0000] /stacks/myapp/compose.yaml.tmp: the attribute `version` is obsolete\nvalidating /stacks/myapp/compose.yaml.tmp: services.web.ports.0 must be a string\n",
+			"validating /stacks/myapp/compose.yaml.tmp: services.web.ports.0 must be a string",
+		},
+		{
+			"error keyword",
+			"time=\"2026-03-01\" level=warning msg=\"network default: external\"\nyaml: line 5: did not find expected key\nerror while parsing compose.yaml.tmp\n",
+			"error while parsing compose.yaml.tmp",
+		},
+		{
+			"invalid keyword",
+			"some notice\nservice \"web\" has invalid restart policy\ntrailing line\n",
+			"service \"web\" has invalid restart policy",
+		},
+		{"empty", "\n  \n", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, validationErrorLine(tt.output))
+		})
+	}
+}
+
+func TestValidationSnippet(t *testing.T) {
+	assert.Equal(t, strings.Join([]string{
+		"     3 |     image: ssd-myapp-web:3",
+		"     4 |     ports:",
+		">    5 |       - 80",
+		"     6 |   worker:",
+		"     7 |     image: ssd-myapp-worker:3",
+	}, "\n"), validationSnippet(rejectedCompose, "yaml: line 5: did not find expected key"))
+
+	assert.Equal(t, strings.Join([]string{
+		"     4 |     ports:",
+		"     5 |       - 80",
+		">    6 |   worker:",
+		"     7 |     image: ssd-myapp-worker:3",
+		"     8 | ",
+	}, "\n"), validationSnippet(rejectedCompose, `service "worker" refers to undefined network back`))
+
+	assert.Contains(t, validationSnippet(rejectedCompose, "services.web.ports.0 must be a string"), ">    2 |   web:")
+	assert.Empty(t, validationSnippet(rejectedCompose, "yaml: line 99: oops"))
+	assert.Empty(t, validationSnippet(rejectedCompose, "no such file"))
+}
+
+func TestCommandStderr(t *testing.T) {
+	assert.Equal(t, "line one\nline two", commandStderr(errors.New("ssh command failed: command failed: exit status 1\nline one\nline two\n")))
+	assert.Empty(t, commandStderr(errors.New("ssh command failed: command failed: exit status 1\n")))
+	assert.Empty(t, commandStderr(errors.New("context canceled")))
+}
+
+func TestClient_CreateStack_ValidationErrorDetail(t *testing.T) {
+	t.Setenv("TMPDIR", t.TempDir())
+	client := NewClientWithExecutor(newTestConfig(), new(testhelpers.MockExecutor))
+	exec := client.executor.(*testhelpers.MockExecutor)
+	exec.On("Run", "ssh", mock.MatchedBy(func(args []string) bool {
+		return !strings.Contains(args[len(args)-1], "docker compose")
+	})).Return("", nil)
+	exec.On("Run", "ssh", mock.MatchedBy(func(args []string) bool {
+		return strings.Contains(args[len(args)-1], "docker compose -f compose.yaml.tmp config")
+	})).Return("", errors.New("command failed: exit status 15\nWARN[0000] the attribute `version` is obsolete\nvalidating compose.yaml.tmp: services.web.ports.0 must be a string\n")).Once()
+
+	err := client.CreateStack(context.Background(), rejectedCompose)
+
+	require.Error(t, err)
+	msg := err.Error()
+	assert.True(t, strings.HasPrefix(msg, "compose.yaml validation failed: validating compose.yaml.tmp: services.web.ports.0 must be a string\n"), msg)
+	assert.NotContains(t, msg, "obsolete")
+	assert.Contains(t, msg, ">    2 |   web:")
+	m := regexp.MustCompile(`Rejected compose.yaml saved to (\S+)`).FindStringSubmatch(msg)
+	require.NotNil(t, m, msg)
+	saved, readErr := os.ReadFile(m[1])
+	require.NoError(t, readErr)
+	assert.Equal(t, rejectedCompose, string(saved))
+	// mkdir, write, validate, then removing the rejected candidate
+	exec.AssertNumberOfCalls(t, "Run", 4)
+}
+
+func TestClient_CreateStack_ValidationRetriesSilentFailure(t *testing.T) {
+	client := NewClientWithExecutor(newTestConfig(), new(testhelpers.MockExecutor))
+	exec := client.executor.(*testhelpers.MockExecutor)
+	exec.On("Run", "ssh", mock.MatchedBy(func(args []string) bool {
+		return !strings.Contains(args[len(args)-1], "docker compose")
+	})).Return("", nil)
+	exec.On("Run", "ssh", mock.MatchedBy(func(args []string) bool {
+		return strings.Contains(args[len(args)-1], "docker compose -f compose.yaml.tmp config")
+	})).Return("", errors.New("command failed: exit status 255\nConnection closed by 203.0.113.5 port 22\n")).Once()
+	exec.On("Run", "ssh", mock.MatchedBy(func(args []string) bool {
+		return strings.Contains(args[len(args)-1], "docker compose -f compose.yaml.tmp config -q")
+	})).Return("", nil).Once()
+
+	require.NoError(t, client.CreateStack(context.Background(), rejectedCompose))
+	exec.AssertExpectations(t)
+}
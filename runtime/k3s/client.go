@@ -2,12 +2,18 @@ package k3s
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"al.essio.dev/pkg/shellescape"
 	"github.com/byteink/ssd/config"
+	"github.com/byteink/ssd/history"
+	"github.com/byteink/ssd/images"
+	"github.com/byteink/ssd/internal/clock"
+	"github.com/byteink/ssd/logs"
 	"github.com/byteink/ssd/remote"
 )
 
@@ -16,7 +22,9 @@ import (
 type Client struct {
 	inner     *remote.Client // Reuse SSH transport, rsync, env, file ops
 	cfg       *config.Config
-	namespace string // K8s namespace derived from stack path
+	namespace string      // K8s namespace derived from stack path
+	clock     clock.Clock // times WaitForHealthy's retries
+	run       remote.RunOptions
 }
 
 // NewClient creates a K3s client wrapping the shared SSH transport.
@@ -25,6 +33,7 @@ func NewClient(cfg *config.Config) *Client {
 		inner:     remote.NewClient(cfg),
 		cfg:       cfg,
 		namespace: filepath.Base(cfg.Stack),
+		clock:     clock.Real{},
 	}
 }
 
@@ -35,6 +44,7 @@ func NewClientWithExecutor(cfg *config.Config, executor remote.CommandExecutor)
 		inner:     remote.NewClientWithExecutor(cfg, executor),
 		cfg:       cfg,
 		namespace: filepath.Base(cfg.Stack),
+		clock:     clock.Real{},
 	}
 }
 
@@ -48,6 +58,18 @@ func (c *Client) SSHInteractive(ctx context.Context, command string) error {
 	return c.inner.SSHInteractive(ctx, command)
 }
 
+// SetRunOptions keeps the options for the build flags and hands them to
+// the inner client.
+func (c *Client) SetRunOptions(opts remote.RunOptions) {
+	c.run = opts
+	c.inner.SetRunOptions(opts)
+}
+
+// SetOutputPrefix delegates to the inner client.
+func (c *Client) SetOutputPrefix(prefix string) {
+	c.inner.SetOutputPrefix(prefix)
+}
+
 // Rsync delegates to the inner client (git archive is runtime-agnostic).
 func (c *Client) Rsync(ctx context.Context, localPath, remotePath string) error {
 	return c.inner.Rsync(ctx, localPath, remotePath)
@@ -68,6 +90,57 @@ func (c *Client) CopyFiles(ctx context.Context, files map[string]string) error {
 	return c.inner.CopyFiles(ctx, files)
 }
 
+// TryLock delegates to the inner client (the lock lives in the stack dir).
+func (c *Client) TryLock(ctx context.Context, lock, holder string, staleAfter time.Duration) (bool, string, error) {
+	return c.inner.TryLock(ctx, lock, holder, staleAfter)
+}
+
+// Unlock delegates to the inner client.
+func (c *Client) Unlock(ctx context.Context, lock, holder string) error {
+	return c.inner.Unlock(ctx, lock, holder)
+}
+
+// RefreshLock delegates to the inner client.
+func (c *Client) RefreshLock(ctx context.Context, lock, holder string) error {
+	return c.inner.RefreshLock(ctx, lock, holder)
+}
+
+// AppendHistory delegates to the inner client (history lives in the stack dir).
+func (c *Client) AppendHistory(ctx context.Context, serviceName string, version int) error {
+	return c.inner.AppendHistory(ctx, serviceName, version)
+}
+
+// SyncSchedule is a no-op: K3s manifests reject schedule, so there is
+// never a timer to install or remove.
+func (c *Client) SyncSchedule(ctx context.Context) error {
+	return nil
+}
+
+// ReadHistory delegates to the inner client.
+func (c *Client) ReadHistory(ctx context.Context) ([]history.Entry, error) {
+	return c.inner.ReadHistory(ctx)
+}
+
+// SourceTree delegates to the inner client (reads the local git repo).
+func (c *Client) SourceTree(ctx context.Context) (string, error) {
+	return c.inner.SourceTree(ctx)
+}
+
+// UncommittedChanges delegates to the inner client (reads the local git repo).
+func (c *Client) UncommittedChanges(ctx context.Context) ([]string, error) {
+	return c.inner.UncommittedChanges(ctx)
+}
+
+// DeployedSourceTree delegates to the inner client (recorded in the stack dir).
+func (c *Client) DeployedSourceTree(ctx context.Context, serviceName string) (string, error) {
+	return c.inner.DeployedSourceTree(ctx, serviceName)
+}
+
+// RecordSourceTree delegates to the inner client.
+func (c *Client) RecordSourceTree(ctx context.Context, serviceName, tree string) error {
+	return c.inner.RecordSourceTree(ctx, serviceName, tree)
+}
+
 // CreateEnvFile delegates to the inner client (.env files stored on disk same way).
 func (c *Client) CreateEnvFile(ctx context.Context, serviceName string) error {
 	return c.inner.CreateEnvFile(ctx, serviceName)
@@ -98,6 +171,11 @@ func (c *Client) RemoveEnvVar(ctx context.Context, serviceName, key string) erro
 	return c.inner.RemoveEnvVar(ctx, serviceName, key)
 }
 
+// WriteEnvFile delegates to the inner client.
+func (c *Client) WriteEnvFile(ctx context.Context, serviceName, content string) error {
+	return c.inner.WriteEnvFile(ctx, serviceName, content)
+}
+
 // --- K3s-specific implementations ---
 
 // BuildImage builds a container image using nerdctl on the remote server.
@@ -109,19 +187,19 @@ func (c *Client) BuildImage(ctx context.Context, buildDir string, version int) e
 	}
 
 	imageTag := fmt.Sprintf("%s:%d", c.cfg.ImageName(), version)
-	dockerfile := strings.TrimPrefix(c.cfg.Dockerfile, "./")
-
-	targetFlag := ""
-	if c.cfg.Target != "" {
-		targetFlag = " --target " + shellescape.Quote(c.cfg.Target)
+	contextDir, dockerfile, err := c.inner.BuildPaths()
+	if err != nil {
+		return err
 	}
 
-	cmd := fmt.Sprintf("cd %s && sudo nerdctl --namespace k8s.io build -t %s -f %s%s .",
+	// nerdctl always builds through buildkitd, so build.buildkit is a no-op here.
+	cmd := fmt.Sprintf("cd %s && sudo nerdctl --namespace k8s.io build -t %s -f %s%s %s",
 		shellescape.Quote(buildDir),
 		shellescape.Quote(imageTag),
 		shellescape.Quote(dockerfile),
-		targetFlag)
-	return c.SSHInteractive(ctx, cmd)
+		remote.BuildFlags(c.cfg, c.run),
+		shellescape.Quote(contextDir))
+	return c.inner.RunBuild(ctx, cmd)
 }
 
 // PullImage pulls a container image using nerdctl.
@@ -130,14 +208,43 @@ func (c *Client) PullImage(ctx context.Context, image string) error {
 	return c.SSHInteractive(ctx, cmd)
 }
 
+// ImageDigest returns the pulled image's repo digest from the k8s.io
+// containerd namespace.
+func (c *Client) ImageDigest(ctx context.Context, image string) (string, error) {
+	cmd := fmt.Sprintf("sudo nerdctl --namespace k8s.io image inspect --format '{{index .RepoDigests 0}}' %s", shellescape.Quote(image))
+	out, err := c.SSH(ctx, cmd)
+	if err != nil {
+		return "", fmt.Errorf("failed to inspect image %s: %w", image, err)
+	}
+	return remote.ParseRepoDigest(image, out)
+}
+
+// CheckDockerfile delegates to the inner client.
+func (c *Client) CheckDockerfile() error {
+	return c.inner.CheckDockerfile()
+}
+
+// ProbeURL delegates to the inner client.
+func (c *Client) ProbeURL(ctx context.Context, url string, timeout time.Duration) (int, error) {
+	return c.inner.ProbeURL(ctx, url, timeout)
+}
+
+// ImageInfo inspects image in the k8s.io containerd namespace.
+func (c *Client) ImageInfo(ctx context.Context, image string) (images.Info, error) {
+	out, err := c.SSH(ctx, "sudo nerdctl --namespace k8s.io image inspect "+shellescape.Quote(image))
+	if err != nil {
+		return images.Info{}, fmt.Errorf("failed to inspect image %s: %w", image, err)
+	}
+	return images.ParseInspect(out)
+}
+
 // GetCurrentVersion reads the current image version from manifests.yaml on the server.
 func (c *Client) GetCurrentVersion(ctx context.Context) (int, error) {
 	content, err := c.ReadManifest(ctx)
 	if err != nil {
 		return 0, nil
 	}
-	project := filepath.Base(c.cfg.Stack)
-	imageName := fmt.Sprintf("ssd-%s-%s", project, c.cfg.Name)
+	imageName := c.cfg.BuiltImageName()
 	return remote.ParseVersionFromContent(content, imageName)
 }
 
@@ -155,9 +262,8 @@ func (c *Client) ReadManifest(ctx context.Context) (string, error) {
 func (c *Client) UpdateManifest(ctx context.Context, version int) error {
 	manifestPath := filepath.Join(c.cfg.StackPath(), "manifests.yaml")
 	newImage := fmt.Sprintf("%s:%d", c.cfg.ImageName(), version)
-	project := filepath.Base(c.cfg.Stack)
 
-	oldPattern := fmt.Sprintf("ssd-%s-%s:[0-9][0-9]*", project, c.cfg.Name)
+	oldPattern := remote.VersionedImagePattern(c.cfg.BuiltImageName())
 	cmd := fmt.Sprintf("sed -i 's|%s|%s|g' %s", oldPattern, newImage, shellescape.Quote(manifestPath))
 
 	if _, err := c.SSH(ctx, cmd); err != nil {
@@ -206,15 +312,15 @@ func (c *Client) CreateStack(ctx context.Context, manifestContent string) error
 	}
 
 	// Write to temp file
-	escapedContent := strings.ReplaceAll(manifestContent, "'", "'\\''")
-	writeCmd := fmt.Sprintf("echo '%s' > %s", escapedContent, shellescape.Quote(tmpFile))
-	if _, err := c.SSH(ctx, writeCmd); err != nil {
+	if err := c.inner.WriteFileInPlace(ctx, tmpFile, []byte(manifestContent), 0o644); err != nil {
 		return fmt.Errorf("failed to write manifests.yaml.tmp: %w", err)
 	}
 
 	// Validate manifests (dry-run)
 	validateCmd := fmt.Sprintf("k3s kubectl apply --dry-run=server -f %s 2>&1", shellescape.Quote(tmpFile))
 	if output, err := c.SSH(ctx, validateCmd); err != nil {
+		// Best effort: don't leave the rejected candidate behind
+		_, _ = c.SSH(ctx, "rm -f "+shellescape.Quote(tmpFile))
 		detail := strings.TrimSpace(output)
 		if i := strings.IndexByte(detail, '\n'); i > 0 {
 			detail = detail[:i]
@@ -296,6 +402,107 @@ func (c *Client) StartService(ctx context.Context, serviceName string) error {
 	return nil
 }
 
+// StopService scales the deployment to zero replicas. The Deployment and
+// its PVCs stay in place; StartService re-applies the manifest to restore
+// the configured replica count.
+func (c *Client) StopService(ctx context.Context, serviceName string) error {
+	cmd := fmt.Sprintf("k3s kubectl scale deployment/%s -n %s --replicas=0",
+		shellescape.Quote(serviceName),
+		shellescape.Quote(c.namespace))
+	return c.SSHInteractive(ctx, cmd)
+}
+
+// RunJob runs the service's pre_start job as a one-off pod with
+// 'kubectl run --rm --restart=Never' and waits for it to exit. The pod gets
+// the service's env ConfigMap and, unless pre_start.image is set, the
+// image version currently in manifests.yaml.
+func (c *Client) RunJob(ctx context.Context, serviceName string) error {
+	if c.cfg.PreStart == nil {
+		return fmt.Errorf("%s has no pre_start job", serviceName)
+	}
+	if err := c.applyEnvConfigMap(ctx, serviceName); err != nil {
+		return err
+	}
+
+	image := c.cfg.PreStart.Image
+	if image == "" {
+		if c.cfg.IsPrebuilt() {
+			image = c.cfg.Image
+		} else {
+			version, err := c.GetCurrentVersion(ctx)
+			if err != nil {
+				return fmt.Errorf("failed to resolve image for pre_start job: %w", err)
+			}
+			image = fmt.Sprintf("%s:%d", c.cfg.ImageName(), version)
+		}
+	}
+
+	jobName := config.PreStartJobName(serviceName)
+	overrides, err := json.Marshal(map[string]any{
+		"spec": map[string]any{
+			"containers": []map[string]any{{
+				"name":            jobName,
+				"image":           image,
+				"imagePullPolicy": "IfNotPresent",
+				"command":         []string{"sh", "-c", c.cfg.PreStart.Command},
+				"envFrom":         []map[string]any{{"configMapRef": map[string]string{"name": serviceName + "-env"}}},
+			}},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to encode pre_start pod spec: %w", err)
+	}
+
+	cmd := fmt.Sprintf("k3s kubectl run %s -n %s --rm -i --restart=Never --image=%s --overrides=%s",
+		shellescape.Quote(jobName),
+		shellescape.Quote(c.namespace),
+		shellescape.Quote(image),
+		shellescape.Quote(string(overrides)))
+	if err := c.SSHInteractive(ctx, cmd); err != nil {
+		return fmt.Errorf("pre_start job for %s failed: %w", serviceName, err)
+	}
+	return nil
+}
+
+// Backoff between WaitForHealthy's retries after a connection failure.
+const (
+	healthRetryInterval    = 2 * time.Second
+	healthRetryMaxInterval = 10 * time.Second
+)
+
+// WaitForHealthy waits for the deployment rollout to finish, which on K8s
+// means every new pod passed its readiness probe. grace is not used:
+// pods without probes are ready as soon as they start. A rollout that
+// fails, or that kubectl gives up on, fails right away; a connection
+// failure (see remote.IsTransient) is retried with backoff, watching the
+// rollout for whatever is left of timeout.
+func (c *Client) WaitForHealthy(ctx context.Context, serviceName string, timeout, grace time.Duration) error {
+	deadline := c.clock.Now().Add(timeout)
+	backoff := healthRetryInterval
+	remaining := timeout
+	for {
+		cmd := fmt.Sprintf("k3s kubectl rollout status deployment/%s -n %s --timeout=%s",
+			shellescape.Quote(serviceName),
+			shellescape.Quote(c.namespace),
+			remaining)
+		_, err := c.SSH(ctx, cmd)
+		if err == nil {
+			return nil
+		}
+		if !remote.IsTransient(err) || c.clock.Now().Add(backoff).Add(time.Second).After(deadline) {
+			return fmt.Errorf("%s did not become ready: %w", serviceName, err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-c.clock.After(backoff):
+		}
+		backoff = min(2*backoff, healthRetryMaxInterval)
+		remaining = deadline.Sub(c.clock.Now()).Truncate(time.Second)
+	}
+}
+
 // RolloutService applies manifests and waits for rollout completion.
 func (c *Client) RolloutService(ctx context.Context, serviceName string) error {
 	if err := c.applyEnvConfigMap(ctx, serviceName); err != nil {
@@ -330,23 +537,39 @@ func (c *Client) RestartStack(ctx context.Context) error {
 	return c.SSHInteractive(ctx, cmd)
 }
 
-// GetContainerStatus returns pod status for the service.
-func (c *Client) GetContainerStatus(ctx context.Context) (string, error) {
-	cmd := fmt.Sprintf("k3s kubectl get pods -n %s -l app=%s -o wide",
-		shellescape.Quote(c.namespace),
-		shellescape.Quote(c.cfg.Name))
+// Down deletes every workload in the stack namespace. PersistentVolumeClaims
+// (and the data behind them) are kept unless removeVolumes is set.
+func (c *Client) Down(ctx context.Context, removeVolumes bool) error {
+	kinds := "deployment,service,ingress,configmap"
+	if removeVolumes {
+		kinds += ",pvc"
+	}
+	cmd := fmt.Sprintf("k3s kubectl delete %s --all -n %s --ignore-not-found",
+		kinds, shellescape.Quote(c.namespace))
+	return c.SSHInteractive(ctx, cmd)
+}
+
+// GetContainerStatus returns pod status for service, or for every pod in
+// the namespace when service is empty.
+func (c *Client) GetContainerStatus(ctx context.Context, service string) (string, error) {
+	cmd := fmt.Sprintf("k3s kubectl get pods -n %s -o wide", shellescape.Quote(c.namespace))
+	if service != "" {
+		cmd = fmt.Sprintf("k3s kubectl get pods -n %s -l app=%s -o wide",
+			shellescape.Quote(c.namespace),
+			shellescape.Quote(service))
+	}
 	return c.SSH(ctx, cmd)
 }
 
 // GetLogs returns logs for the service pods.
-func (c *Client) GetLogs(ctx context.Context, follow bool, tail int) error {
+func (c *Client) GetLogs(ctx context.Context, opts logs.Options) error {
 	tailArg := ""
-	if tail > 0 {
-		tailArg = fmt.Sprintf("--tail=%d", tail)
+	if opts.Tail != logs.TailAll {
+		tailArg = fmt.Sprintf("--tail=%d", opts.Tail)
 	}
 
 	followArg := ""
-	if follow {
+	if opts.Follow {
 		followArg = "-f"
 	}
 
@@ -355,5 +578,11 @@ func (c *Client) GetLogs(ctx context.Context, follow bool, tail int) error {
 		shellescape.Quote(c.cfg.Name),
 		followArg,
 		tailArg)
+	if !opts.Since.IsZero() {
+		cmd += " --since-time=" + opts.Since.UTC().Format(time.RFC3339)
+	}
+	if opts.Grep != "" {
+		cmd = remote.GrepLines(cmd, opts.Grep)
+	}
 	return c.SSHInteractive(ctx, cmd)
 }
@@ -2,11 +2,16 @@ package k3s
 
 import (
 	"context"
+	"errors"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/byteink/ssd/config"
+	"github.com/byteink/ssd/deploy"
+	"github.com/byteink/ssd/internal/clock"
 	"github.com/byteink/ssd/internal/testhelpers"
+	"github.com/byteink/ssd/logs"
 	"github.com/byteink/ssd/remote"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
@@ -35,6 +40,12 @@ func TestClient_ImplementsRemoteClient(t *testing.T) {
 	client := NewClient(cfg)
 	// Compile-time check that Client satisfies RemoteClient
 	var _ remote.RemoteClient = client
+	// and supports the remote deploy lock
+	var _ deploy.RemoteLocker = client
+	// and can tag streamed output in deploy-all
+	var _ remote.OutputPrefixer = client
+	// and checks the Dockerfile before syncing
+	var _ deploy.DockerfileChecker = client
 }
 
 // recordingExecutor captures the order of SSH commands issued so tests
@@ -137,3 +148,242 @@ func TestClient_RestartStack_PopulatesConfigMapsBeforeApply(t *testing.T) {
 	require.NotEqual(t, -1, applyIdx)
 	assert.Less(t, cmdIdx, applyIdx)
 }
+
+func TestClient_CreateStack_WritesCandidateOnce(t *testing.T) {
+	client, rec := newRecordingClient(t, &config.Config{Name: "web", Server: "s", Stack: "/stacks/app"})
+
+	require.NoError(t, client.CreateStack(context.Background(), "kind: Namespace\n"))
+
+	var write string
+	for _, c := range rec.cmds {
+		if strings.Contains(c, "base64 -d") {
+			write = c
+		}
+	}
+	assert.Contains(t, write, "base64 -d >> /stacks/app/manifests.yaml.tmp")
+	assert.NotContains(t, write, "mv -f", "the candidate is renamed only after validation")
+	assert.Contains(t, rec.cmds[len(rec.cmds)-1], "mv /stacks/app/manifests.yaml.tmp /stacks/app/manifests.yaml")
+}
+
+func TestClient_CreateStack_RemovesRejectedCandidate(t *testing.T) {
+	rec := &recordingExecutor{}
+	rec.On("Run", "ssh", mock.MatchedBy(func(args []string) bool {
+		return strings.Contains(args[len(args)-1], "--dry-run=server")
+	})).Return("", errors.New("exit status 1"))
+	rec.On("Run", "ssh", mock.Anything).Return("", nil)
+	client := NewClientWithExecutor(&config.Config{Name: "web", Server: "s", Stack: "/stacks/app"}, rec)
+
+	err := client.CreateStack(context.Background(), "kind: Namespace\n")
+
+	require.ErrorContains(t, err, "manifest validation failed")
+	assert.Equal(t, "rm -f /stacks/app/manifests.yaml.tmp", rec.cmds[len(rec.cmds)-1])
+}
+
+func TestClient_BuildImage_PassesPlatform(t *testing.T) {
+	cfg := &config.Config{
+		Name:       "web",
+		Server:     "srv",
+		Stack:      "/stacks/myapp",
+		Dockerfile: "./Dockerfile",
+		Platform:   "linux/arm64",
+		Build:      &config.BuildConfig{BuildKit: true},
+	}
+	client, rec := newRecordingClient(t, cfg)
+
+	require.NoError(t, client.BuildImage(context.Background(), "/tmp/build", 3))
+
+	var build string
+	for _, c := range rec.cmds {
+		if strings.Contains(c, "nerdctl --namespace k8s.io build") {
+			build = c
+		}
+	}
+	require.NotEmpty(t, build, "expected nerdctl build command; cmds: %v", rec.cmds)
+	assert.Contains(t, build, "--platform linux/arm64")
+	assert.NotContains(t, build, "DOCKER_BUILDKIT")
+}
+
+func TestClient_BuildImage_Pull(t *testing.T) {
+	cfg := &config.Config{
+		Name:       "web",
+		Server:     "srv",
+		Stack:      "/stacks/myapp",
+		Dockerfile: "./Dockerfile",
+	}
+	client, rec := newRecordingClient(t, cfg)
+	client.SetRunOptions(remote.RunOptions{PullBase: true})
+
+	require.NoError(t, client.BuildImage(context.Background(), "/tmp/build", 3))
+
+	var build string
+	for _, c := range rec.cmds {
+		if strings.Contains(c, "nerdctl --namespace k8s.io build") {
+			build = c
+		}
+	}
+	assert.Contains(t, build, "build -t ssd-myapp-web:3 -f Dockerfile --pull .")
+}
+
+func TestClient_BuildImage_QuietBuild(t *testing.T) {
+	cfg := &config.Config{
+		Name:       "web",
+		Server:     "srv",
+		Stack:      "/stacks/myapp",
+		Dockerfile: "./Dockerfile",
+	}
+	client, rec := newRecordingClient(t, cfg)
+	client.SetRunOptions(remote.RunOptions{QuietBuild: true})
+
+	require.NoError(t, client.BuildImage(context.Background(), "/tmp/build", 3))
+
+	assert.Contains(t, rec.cmds[len(rec.cmds)-1], "build -t ssd-myapp-web:3 -f Dockerfile --quiet .")
+	rec.AssertNotCalled(t, "RunInteractive", mock.Anything, mock.Anything)
+}
+
+func TestClient_Down(t *testing.T) {
+	cfg := &config.Config{Name: "web", Server: "srv", Stack: "/stacks/myapp"}
+
+	client, rec := newRecordingClient(t, cfg)
+	require.NoError(t, client.Down(context.Background(), false))
+	assert.Equal(t, []string{
+		"k3s kubectl delete deployment,service,ingress,configmap --all -n myapp --ignore-not-found",
+	}, rec.cmds)
+
+	client, rec = newRecordingClient(t, cfg)
+	require.NoError(t, client.Down(context.Background(), true))
+	assert.Equal(t, []string{
+		"k3s kubectl delete deployment,service,ingress,configmap,pvc --all -n myapp --ignore-not-found",
+	}, rec.cmds)
+}
+
+func TestClient_StopService_ScalesToZero(t *testing.T) {
+	cfg := &config.Config{Name: "web", Server: "srv", Stack: "/stacks/myapp"}
+	client, rec := newRecordingClient(t, cfg)
+
+	require.NoError(t, client.StopService(context.Background(), "web"))
+	assert.Equal(t, []string{"k3s kubectl scale deployment/web -n myapp --replicas=0"}, rec.cmds)
+}
+
+func TestClient_WaitForHealthy_UsesRolloutStatus(t *testing.T) {
+	cfg := &config.Config{Name: "web", Server: "srv", Stack: "/stacks/myapp"}
+	client, rec := newRecordingClient(t, cfg)
+
+	require.NoError(t, client.WaitForHealthy(context.Background(), "web", 90*time.Second, 0))
+	assert.Equal(t, []string{"k3s kubectl rollout status deployment/web -n myapp --timeout=1m30s"}, rec.cmds)
+}
+
+func TestClient_WaitForHealthy_RetriesTransientErrors(t *testing.T) {
+	cfg := &config.Config{Name: "web", Server: "srv", Stack: "/stacks/myapp"}
+	executor := new(testhelpers.MockExecutor)
+	client := NewClientWithExecutor(cfg, executor)
+	clk := clock.NewFake(time.Now())
+	client.clock = clk
+
+	blip := errors.New("command failed: exit status 255\nssh: connect to host srv port 22: Connection reset by peer")
+	executor.On("Run", "ssh", []string{"srv", "k3s kubectl rollout status deployment/web -n myapp --timeout=1m30s"}).Return("", blip).Once()
+	executor.On("Run", "ssh", []string{"srv", "k3s kubectl rollout status deployment/web -n myapp --timeout=1m28s"}).Return("", nil).Once()
+
+	require.NoError(t, client.WaitForHealthy(context.Background(), "web", 90*time.Second, 0))
+	executor.AssertExpectations(t)
+	assert.Equal(t, []time.Duration{2 * time.Second}, clk.Waits())
+}
+
+func TestClient_WaitForHealthy_FailedRolloutFailsAtOnce(t *testing.T) {
+	cfg := &config.Config{Name: "web", Server: "srv", Stack: "/stacks/myapp"}
+	executor := new(testhelpers.MockExecutor)
+	client := NewClientWithExecutor(cfg, executor)
+	clk := clock.NewFake(time.Now())
+	client.clock = clk
+
+	executor.On("Run", "ssh", mock.Anything).Return("", errors.New("command failed: exit status 1\nerror: deployment \"web\" exceeded its progress deadline")).Once()
+
+	err := client.WaitForHealthy(context.Background(), "web", 90*time.Second, 0)
+
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "web did not become ready")
+	assert.Contains(t, err.Error(), "exceeded its progress deadline")
+	executor.AssertExpectations(t)
+	assert.Empty(t, clk.Waits())
+}
+
+func TestClient_GetLogs_Tail(t *testing.T) {
+	cfg := &config.Config{Name: "web", Server: "srv", Stack: "/stacks/myapp"}
+	client, rec := newRecordingClient(t, cfg)
+
+	require.NoError(t, client.GetLogs(context.Background(), logs.Options{Follow: true, Tail: 500}))
+	require.NoError(t, client.GetLogs(context.Background(), logs.Options{Tail: logs.TailAll}))
+	assert.Equal(t, []string{
+		"k3s kubectl logs -n myapp -l app=web -f --tail=500",
+		"k3s kubectl logs -n myapp -l app=web  ",
+	}, rec.cmds)
+}
+
+func TestClient_GetLogs_Grep(t *testing.T) {
+	cfg := &config.Config{Name: "web", Server: "srv", Stack: "/stacks/myapp"}
+	client, rec := newRecordingClient(t, cfg)
+
+	require.NoError(t, client.GetLogs(context.Background(), logs.Options{Tail: 10, Grep: "timeout"}))
+	assert.Equal(t, []string{
+		"{ k3s kubectl logs -n myapp -l app=web  --tail=10 | grep --line-buffered -e timeout || [ $? -eq 1 ]; }",
+	}, rec.cmds)
+}
+
+func TestClient_GetContainerStatus(t *testing.T) {
+	cfg := &config.Config{Name: "web", Server: "srv", Stack: "/stacks/myapp"}
+	client, rec := newRecordingClient(t, cfg)
+
+	_, err := client.GetContainerStatus(context.Background(), "api")
+	require.NoError(t, err)
+	_, err = client.GetContainerStatus(context.Background(), "")
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		"k3s kubectl get pods -n myapp -l app=api -o wide",
+		"k3s kubectl get pods -n myapp -o wide",
+	}, rec.cmds)
+}
+
+func TestClient_GetLogs_Since(t *testing.T) {
+	cfg := &config.Config{Name: "web", Server: "srv", Stack: "/stacks/myapp"}
+	client, rec := newRecordingClient(t, cfg)
+
+	since := time.Date(2026, 3, 1, 9, 0, 0, 0, time.UTC)
+	require.NoError(t, client.GetLogs(context.Background(), logs.Options{Tail: logs.TailAll, Since: since}))
+	assert.Equal(t, []string{"k3s kubectl logs -n myapp -l app=web   --since-time=2026-03-01T09:00:00Z"}, rec.cmds)
+}
+
+func TestClient_RunJob_RunsPodWithServiceEnv(t *testing.T) {
+	cfg := &config.Config{Name: "web", Server: "srv", Stack: "/stacks/myapp", Image: "shop/web:2",
+		PreStart: &config.PreStartConfig{Command: "npm run migrate"}}
+	client, rec := newRecordingClient(t, cfg)
+
+	require.NoError(t, client.RunJob(context.Background(), "web"))
+
+	require.Len(t, rec.cmds, 2)
+	assert.Equal(t, expectedConfigMapCmd("web", "myapp", "/stacks/myapp"), rec.cmds[0])
+	run := rec.cmds[1]
+	assert.True(t, strings.HasPrefix(run, "k3s kubectl run web-pre-start -n myapp --rm -i --restart=Never --image=shop/web:2 --overrides="), run)
+	assert.Contains(t, run, `"command":["sh","-c","npm run migrate"]`)
+	assert.Contains(t, run, `"configMapRef":{"name":"web-env"}`)
+}
+
+func TestClient_RunJob_ImageOverride(t *testing.T) {
+	cfg := &config.Config{Name: "web", Server: "srv", Stack: "/stacks/myapp",
+		PreStart: &config.PreStartConfig{Command: "migrate up", Image: "migrate/migrate:v4"}}
+	client, rec := newRecordingClient(t, cfg)
+
+	require.NoError(t, client.RunJob(context.Background(), "web"))
+	assert.Contains(t, rec.cmds[len(rec.cmds)-1], "--image=migrate/migrate:v4")
+}
+
+func TestClient_RunJob_Failure(t *testing.T) {
+	cfg := &config.Config{Name: "web", Server: "srv", Stack: "/stacks/myapp", Image: "shop/web:2",
+		PreStart: &config.PreStartConfig{Command: "exit 3"}}
+	rec := &recordingExecutor{}
+	rec.On("Run", "ssh", mock.Anything).Return("", nil)
+	rec.On("RunInteractive", "ssh", mock.Anything).Return(errors.New("exit status 3"))
+	client := NewClientWithExecutor(cfg, rec)
+
+	err := client.RunJob(context.Background(), "web")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "pre_start job for web failed")
+}
@@ -0,0 +1,62 @@
+package k3s
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/byteink/ssd/stacks"
+)
+
+// listDeploymentsCmd prints one tab-separated line per ssd Deployment:
+// namespace, name, ready replicas, desired replicas.
+const listDeploymentsCmd = `k3s kubectl get deployments -A -l managed-by=ssd -o jsonpath='{range .items[*]}{.metadata.namespace}{"\t"}{.metadata.name}{"\t"}{.status.readyReplicas}{"\t"}{.spec.replicas}{"\n"}{end}'`
+
+// ListStacks returns every namespace holding ssd Deployments, one stack
+// per namespace, with each service's ready/desired replica count.
+func (c *Client) ListStacks(ctx context.Context) ([]stacks.Stack, error) {
+	out, err := c.SSH(ctx, listDeploymentsCmd)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list deployments: %w", err)
+	}
+	return parseDeployments(out), nil
+}
+
+// parseDeployments groups listDeploymentsCmd output by namespace. A
+// stack's status counts the services with every replica ready.
+func parseDeployments(out string) []stacks.Stack {
+	byNamespace := make(map[string]*stacks.Stack)
+	ready := make(map[string]int)
+	for _, line := range strings.Split(out, "\n") {
+		fields := strings.Split(strings.TrimSpace(line), "\t")
+		if len(fields) != 4 || fields[0] == "" {
+			continue
+		}
+		readyReplicas := fields[2]
+		if readyReplicas == "" {
+			readyReplicas = "0"
+		}
+		s, ok := byNamespace[fields[0]]
+		if !ok {
+			s = &stacks.Stack{Name: fields[0]}
+			byNamespace[fields[0]] = s
+		}
+		s.Services = append(s.Services, stacks.Service{
+			Name:  fields[1],
+			State: fmt.Sprintf("%s/%s ready", readyReplicas, fields[3]),
+		})
+		if readyReplicas == fields[3] {
+			ready[fields[0]]++
+		}
+	}
+
+	result := make([]stacks.Stack, 0, len(byNamespace))
+	for name, s := range byNamespace {
+		sort.Slice(s.Services, func(i, j int) bool { return s.Services[i].Name < s.Services[j].Name })
+		s.Status = fmt.Sprintf("%d/%d ready", ready[name], len(s.Services))
+		result = append(result, *s)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Name < result[j].Name })
+	return result
+}
@@ -0,0 +1,31 @@
+package k3s
+
+import (
+	"testing"
+
+	"github.com/byteink/ssd/stacks"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseDeployments(t *testing.T) {
+	out := "shop\tweb\t2\t2\n" +
+		"shop\tapi\t\t1\n" +
+		"blog\tghost\t1\t1\n" +
+		"malformed line\n"
+
+	list := parseDeployments(out)
+
+	require.Len(t, list, 2)
+	assert.Equal(t, stacks.Stack{
+		Name:     "blog",
+		Status:   "1/1 ready",
+		Services: []stacks.Service{{Name: "ghost", State: "1/1 ready"}},
+	}, list[0])
+	assert.Equal(t, "1/2 ready", list[1].Status)
+	assert.Equal(t, []stacks.Service{{Name: "api", State: "0/1 ready"}, {Name: "web", State: "2/2 ready"}}, list[1].Services)
+}
+
+func TestParseDeployments_Empty(t *testing.T) {
+	assert.Empty(t, parseDeployments(""))
+}
@@ -0,0 +1,246 @@
+// Package schedule validates cron expressions for scheduled services and
+// renders the systemd timer that runs them on the server.
+package schedule
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// macros maps the cron shorthands to their five-field form.
+var macros = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+// field describes one cron field: its range and, for month and
+// day-of-week, the names accepted in place of numbers.
+type field struct {
+	name     string
+	min, max int
+	names    []string
+}
+
+var (
+	minuteField = field{name: "minute", min: 0, max: 59}
+	hourField   = field{name: "hour", min: 0, max: 23}
+	domField    = field{name: "day-of-month", min: 1, max: 31}
+	monthField  = field{name: "month", min: 1, max: 12,
+		names: []string{"jan", "feb", "mar", "apr", "may", "jun", "jul", "aug", "sep", "oct", "nov", "dec"}}
+	// Day-of-week accepts 0-7 with both 0 and 7 meaning Sunday.
+	dowField = field{name: "day-of-week", min: 0, max: 7,
+		names: []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}}
+)
+
+// systemdDays are the OnCalendar weekday names, indexed like cron (0 = Sunday).
+var systemdDays = []string{"Sun", "Mon", "Tue", "Wed", "Thu", "Fri", "Sat"}
+
+// Validate checks a cron expression: five fields (minute hour
+// day-of-month month day-of-week) or one of @hourly, @daily, @midnight,
+// @weekly, @monthly, @yearly, @annually.
+func Validate(expr string) error {
+	_, err := OnCalendar(expr)
+	return err
+}
+
+// OnCalendar converts a cron expression to a systemd OnCalendar value,
+// e.g. "30 2 * * 1-5" becomes "Mon,Tue,Wed,Thu,Fri *-*-* 02:30:00".
+// Cron runs a job when either day field matches if both are restricted,
+// while systemd requires both, so that combination is rejected.
+func OnCalendar(expr string) (string, error) {
+	expr = strings.TrimSpace(expr)
+	if expr == "" {
+		return "", fmt.Errorf("schedule cannot be empty")
+	}
+	if strings.HasPrefix(expr, "@") {
+		full, ok := macros[expr]
+		if !ok {
+			return "", fmt.Errorf("unknown schedule %q: use five cron fields or @hourly, @daily, @weekly, @monthly, @yearly", expr)
+		}
+		expr = full
+	}
+
+	parts := strings.Fields(expr)
+	if len(parts) != 5 {
+		return "", fmt.Errorf("schedule %q must have 5 fields (minute hour day-of-month month day-of-week), got %d", expr, len(parts))
+	}
+
+	minutes, err := minuteField.parse(parts[0])
+	if err != nil {
+		return "", err
+	}
+	hours, err := hourField.parse(parts[1])
+	if err != nil {
+		return "", err
+	}
+	days, err := domField.parse(parts[2])
+	if err != nil {
+		return "", err
+	}
+	months, err := monthField.parse(parts[3])
+	if err != nil {
+		return "", err
+	}
+	weekdays, err := dowField.parse(parts[4])
+	if err != nil {
+		return "", err
+	}
+	if days != nil && weekdays != nil {
+		return "", fmt.Errorf("schedule %q restricts both day-of-month and day-of-week; use one of them", expr)
+	}
+
+	calendar := fmt.Sprintf("*-%s-%s %s:%s:00", join(months), join(days), join(hours), join(minutes))
+	if weekdays != nil {
+		names := make([]string, 0, len(weekdays))
+		for _, d := range weekdays {
+			if name := systemdDays[d%7]; !slices.Contains(names, name) {
+				names = append(names, name)
+			}
+		}
+		calendar = strings.Join(names, ",") + " " + calendar
+	}
+	return calendar, nil
+}
+
+// parse expands a cron field into its sorted values. A bare "*" returns
+// nil, meaning every value.
+func (f field) parse(s string) ([]int, error) {
+	if s == "*" {
+		return nil, nil
+	}
+	var values []int
+	for _, item := range strings.Split(s, ",") {
+		expanded, err := f.parseItem(item)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s %q: %w", f.name, s, err)
+		}
+		for _, v := range expanded {
+			if !slices.Contains(values, v) {
+				values = append(values, v)
+			}
+		}
+	}
+	slices.Sort(values)
+	return values, nil
+}
+
+// parseItem expands one list item: N, A-B, */S, A-B/S or N/S.
+func (f field) parseItem(item string) ([]int, error) {
+	rng, stepStr, hasStep := strings.Cut(item, "/")
+	step := 1
+	if hasStep {
+		n, err := strconv.Atoi(stepStr)
+		if err != nil || n < 1 {
+			return nil, fmt.Errorf("step %q must be a positive number", stepStr)
+		}
+		step = n
+	}
+
+	lo, hi := f.min, f.max
+	switch {
+	case rng == "*":
+		if f.name == dowField.name {
+			hi = 6
+		}
+	case strings.Contains(rng, "-"):
+		a, b, _ := strings.Cut(rng, "-")
+		var err error
+		if lo, err = f.value(a); err != nil {
+			return nil, err
+		}
+		if hi, err = f.value(b); err != nil {
+			return nil, err
+		}
+		if lo > hi {
+			return nil, fmt.Errorf("range %q is reversed", rng)
+		}
+	default:
+		v, err := f.value(rng)
+		if err != nil {
+			return nil, err
+		}
+		lo = v
+		if !hasStep {
+			hi = v
+		}
+	}
+
+	var out []int
+	for v := lo; v <= hi; v += step {
+		out = append(out, v)
+	}
+	return out, nil
+}
+
+// value parses a single number or name within the field's range.
+func (f field) value(s string) (int, error) {
+	if i := slices.Index(f.names, strings.ToLower(s)); i >= 0 {
+		return i + f.min, nil
+	}
+	v, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, fmt.Errorf("%q is not a number", s)
+	}
+	if v < f.min || v > f.max {
+		return 0, fmt.Errorf("%d is out of range %d-%d", v, f.min, f.max)
+	}
+	return v, nil
+}
+
+// join renders values for OnCalendar: "*" for every value, otherwise a
+// comma-separated two-digit list.
+func join(values []int) string {
+	if values == nil {
+		return "*"
+	}
+	parts := make([]string, len(values))
+	for i, v := range values {
+		parts[i] = fmt.Sprintf("%02d", v)
+	}
+	return strings.Join(parts, ",")
+}
+
+// UnitName returns the systemd unit name (without suffix) for a
+// service's schedule: ssd-{project}-{service}.
+func UnitName(project, service string) string {
+	return fmt.Sprintf("ssd-%s-%s", project, service)
+}
+
+// ServiceUnit renders the oneshot unit that runs the service once with
+// 'docker compose run --rm' in the stack directory. compose is the
+// docker compose invocation, including -f for a non-default filename.
+func ServiceUnit(service, stack, compose string) string {
+	return fmt.Sprintf(`[Unit]
+Description=ssd scheduled job %[1]s (%[2]s)
+After=docker.service
+Requires=docker.service
+
+[Service]
+Type=oneshot
+WorkingDirectory=%[2]s
+ExecStart=/usr/bin/env %[3]s run --rm %[1]s
+`, service, stack, compose)
+}
+
+// TimerUnit renders the timer that starts the service unit on calendar,
+// an OnCalendar value. Persistent catches up on a run missed while the
+// server was down.
+func TimerUnit(service, stack, calendar string) string {
+	return fmt.Sprintf(`[Unit]
+Description=Schedule for ssd job %s (%s)
+
+[Timer]
+OnCalendar=%s
+Persistent=true
+
+[Install]
+WantedBy=timers.target
+`, service, stack, calendar)
+}
@@ -0,0 +1,76 @@
+package schedule
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOnCalendar(t *testing.T) {
+	tests := map[string]string{
+		"0 3 * * *":        "*-*-* 03:00:00",
+		"30 2 * * 1-5":     "Mon,Tue,Wed,Thu,Fri *-*-* 02:30:00",
+		"*/15 * * * *":     "*-*-* *:00,15,30,45:00",
+		"0 0 1 */3 *":      "*-01,04,07,10-01 00:00:00",
+		"0 12 * jan,jul *": "*-01,07-* 12:00:00",
+		"0 0 * * sun":      "Sun *-*-* 00:00:00",
+		"0 0 * * 0,7":      "Sun *-*-* 00:00:00",
+		"5 4 1,15 * *":     "*-*-01,15 04:05:00",
+		"0 9-17/4 * * *":   "*-*-* 09,13,17:00:00",
+		"@daily":           "*-*-* 00:00:00",
+		"@weekly":          "Sun *-*-* 00:00:00",
+		"@hourly":          "*-*-* *:00:00",
+		"  0 3 * * *  ":    "*-*-* 03:00:00",
+	}
+	for expr, want := range tests {
+		t.Run(expr, func(t *testing.T) {
+			got, err := OnCalendar(expr)
+			require.NoError(t, err)
+			assert.Equal(t, want, got)
+		})
+	}
+}
+
+func TestValidate_Invalid(t *testing.T) {
+	tests := map[string]string{
+		"":               "cannot be empty",
+		"@reboot":        "unknown schedule",
+		"0 3 * *":        "must have 5 fields",
+		"0 3 * * * *":    "must have 5 fields",
+		"60 * * * *":     "out of range",
+		"* 24 * * *":     "out of range",
+		"* * 0 * *":      "out of range",
+		"* * * 13 *":     "out of range",
+		"* * * * 8":      "out of range",
+		"*/0 * * * *":    "must be a positive number",
+		"5-1 * * * *":    "reversed",
+		"x * * * *":      "not a number",
+		"0 0 1 * mon":    "both day-of-month and day-of-week",
+		"0 0 * * mon;ls": "not a number",
+	}
+	for expr, want := range tests {
+		t.Run(expr, func(t *testing.T) {
+			err := Validate(expr)
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), want)
+		})
+	}
+}
+
+func TestUnits(t *testing.T) {
+	assert.Equal(t, "ssd-shop-backup", UnitName("shop", "backup"))
+
+	service := ServiceUnit("backup", "/stacks/shop", "docker compose")
+	assert.Contains(t, service, "Type=oneshot\n")
+	assert.Contains(t, service, "WorkingDirectory=/stacks/shop\n")
+	assert.Contains(t, service, "ExecStart=/usr/bin/env docker compose run --rm backup\n")
+
+	service = ServiceUnit("backup", "/stacks/shop", "docker compose -f docker-compose.yml")
+	assert.Contains(t, service, "ExecStart=/usr/bin/env docker compose -f docker-compose.yml run --rm backup\n")
+
+	timer := TimerUnit("backup", "/stacks/shop", "*-*-* 03:00:00")
+	assert.Contains(t, timer, "[Timer]\nOnCalendar=*-*-* 03:00:00\nPersistent=true\n")
+	assert.True(t, strings.HasSuffix(timer, "[Install]\nWantedBy=timers.target\n"))
+}
@@ -0,0 +1,159 @@
+// Package stacks describes the ssd-managed stacks found on a server, as
+// listed by `ssd ps`. It parses the docker output the compose client
+// collects and renders the table; the k3s client builds the same types.
+package stacks
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/byteink/ssd/history"
+)
+
+// Service is one service of a stack and the state of its container(s).
+type Service struct {
+	Name  string
+	State string
+}
+
+// Stack is one deployed stack on the server.
+type Stack struct {
+	Name     string
+	Dir      string // stack directory; empty when unknown (k3s)
+	Status   string // e.g. "running(2)"
+	Services []Service
+}
+
+// Container is one container as reported by ContainersCommand.
+type Container struct {
+	Project string
+	Service string
+	Image   string
+	State   string
+}
+
+// ListCommand lists every compose project on the host, stopped ones included.
+const ListCommand = "docker compose ls --all --format json"
+
+// ContainersCommand prints one tab-separated line per container:
+// compose project, compose service, image, state.
+const ContainersCommand = `docker ps -a --format '{{.Label "com.docker.compose.project"}}\t{{.Label "com.docker.compose.service"}}\t{{.Image}}\t{{.State}}'`
+
+// composeProject is one entry of `docker compose ls --format json`.
+type composeProject struct {
+	Name        string `json:"Name"`
+	Status      string `json:"Status"`
+	ConfigFiles string `json:"ConfigFiles"`
+}
+
+// ParseComposeLs parses the output of ListCommand. The stack directory is
+// taken from the project's first config file.
+func ParseComposeLs(out string) ([]Stack, error) {
+	out = strings.TrimSpace(out)
+	if out == "" {
+		return nil, nil
+	}
+	var projects []composeProject
+	if err := json.Unmarshal([]byte(out), &projects); err != nil {
+		return nil, fmt.Errorf("failed to parse docker compose ls output: %w", err)
+	}
+	result := make([]Stack, 0, len(projects))
+	for _, p := range projects {
+		s := Stack{Name: p.Name, Status: p.Status}
+		if first, _, _ := strings.Cut(p.ConfigFiles, ","); first != "" {
+			s.Dir = filepath.Dir(first)
+		}
+		result = append(result, s)
+	}
+	return result, nil
+}
+
+// ParseContainers parses the output of ContainersCommand. Containers that
+// don't belong to a compose project are dropped.
+func ParseContainers(out string) []Container {
+	var containers []Container
+	for _, line := range strings.Split(out, "\n") {
+		fields := strings.Split(strings.TrimSpace(line), "\t")
+		if len(fields) != 4 || fields[0] == "" {
+			continue
+		}
+		containers = append(containers, Container{
+			Project: fields[0],
+			Service: fields[1],
+			Image:   fields[2],
+			State:   fields[3],
+		})
+	}
+	return containers
+}
+
+// MarkerPath is the file whose presence marks dir as an ssd stack even
+// when none of its containers run an ssd-built image.
+func MarkerPath(dir string) string {
+	return filepath.Join(dir, history.File)
+}
+
+// Managed keeps the stacks ssd deployed: those with a container running an
+// ssd- image, or whose directory is in markedDirs (it holds MarkerPath).
+// Each kept stack gets its services from containers, sorted by name.
+func Managed(all []Stack, containers []Container, markedDirs []string) []Stack {
+	marked := make(map[string]bool, len(markedDirs))
+	for _, d := range markedDirs {
+		marked[d] = true
+	}
+	byProject := make(map[string][]Container)
+	for _, c := range containers {
+		byProject[c.Project] = append(byProject[c.Project], c)
+	}
+
+	var result []Stack
+	for _, s := range all {
+		managed := s.Dir != "" && marked[s.Dir]
+		for _, c := range byProject[s.Name] {
+			if isSSDImage(c.Image) {
+				managed = true
+			}
+			s.Services = append(s.Services, Service{Name: c.Service, State: c.State})
+		}
+		if !managed {
+			continue
+		}
+		sort.Slice(s.Services, func(i, j int) bool { return s.Services[i].Name < s.Services[j].Name })
+		result = append(result, s)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Name < result[j].Name })
+	return result
+}
+
+// isSSDImage reports whether image was built by ssd (ssd-{project}-{name}:{version}).
+func isSSDImage(image string) bool {
+	return strings.HasPrefix(image, "ssd-")
+}
+
+// Write prints one row per stack: name, status, directory and its
+// services with their states.
+func Write(w io.Writer, list []Stack) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	if _, err := fmt.Fprintln(tw, "STACK\tSTATUS\tDIR\tSERVICES"); err != nil {
+		return err
+	}
+	for _, s := range list {
+		services := make([]string, 0, len(s.Services))
+		for _, svc := range s.Services {
+			services = append(services, fmt.Sprintf("%s (%s)", svc.Name, svc.State))
+		}
+		dir := s.Dir
+		if dir == "" {
+			dir = "-"
+		}
+		if _, err := fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", s.Name, s.Status, dir, strings.Join(services, ", ")); err != nil {
+			return err
+		}
+	}
+	return tw.Flush()
+}
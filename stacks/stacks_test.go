@@ -0,0 +1,77 @@
+package stacks
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const composeLs = `[{"Name":"shop","Status":"running(2)","ConfigFiles":"/stacks/shop/compose.yaml"},` +
+	`{"Name":"blog","Status":"exited(1)","ConfigFiles":"/stacks/blog/compose.yaml"},` +
+	`{"Name":"traefik","Status":"running(1)","ConfigFiles":"/stacks/traefik/compose.yaml,/stacks/traefik/override.yaml"},` +
+	`{"Name":"other","Status":"running(1)","ConfigFiles":"/opt/other/docker-compose.yml"}]`
+
+func TestParseComposeLs(t *testing.T) {
+	list, err := ParseComposeLs(composeLs + "\n")
+	require.NoError(t, err)
+	require.Len(t, list, 4)
+	assert.Equal(t, Stack{Name: "shop", Status: "running(2)", Dir: "/stacks/shop"}, list[0])
+	assert.Equal(t, "/stacks/traefik", list[2].Dir, "first config file decides the directory")
+}
+
+func TestParseComposeLs_Empty(t *testing.T) {
+	list, err := ParseComposeLs("  \n")
+	require.NoError(t, err)
+	assert.Empty(t, list)
+
+	list, err = ParseComposeLs("[]")
+	require.NoError(t, err)
+	assert.Empty(t, list)
+}
+
+func TestParseComposeLs_Invalid(t *testing.T) {
+	_, err := ParseComposeLs("NAME STATUS CONFIG FILES")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "docker compose ls")
+}
+
+func TestParseContainers(t *testing.T) {
+	out := "shop\tweb\tssd-shop-web:4\trunning\n" +
+		"shop\tdb\tpostgres:16\trunning\n" +
+		"\t\tbusybox\texited\n" +
+		"garbage\n"
+	containers := ParseContainers(out)
+	require.Len(t, containers, 2)
+	assert.Equal(t, Container{Project: "shop", Service: "web", Image: "ssd-shop-web:4", State: "running"}, containers[0])
+}
+
+func TestManaged(t *testing.T) {
+	all, err := ParseComposeLs(composeLs)
+	require.NoError(t, err)
+	containers := ParseContainers("shop\tweb\tssd-shop-web:4\trunning\n" +
+		"shop\tdb\tpostgres:16\trunning\n" +
+		"blog\tghost\tghost:5\texited\n" +
+		"traefik\ttraefik\ttraefik:v3\trunning\n" +
+		"other\tapp\tnginx\trunning\n")
+
+	list := Managed(all, containers, []string{"/stacks/blog"})
+
+	require.Len(t, list, 2)
+	assert.Equal(t, "blog", list[0].Name, "marker file makes a prebuilt-only stack managed")
+	assert.Equal(t, "shop", list[1].Name)
+	assert.Equal(t, []Service{{Name: "db", State: "running"}, {Name: "web", State: "running"}}, list[1].Services)
+}
+
+func TestWrite(t *testing.T) {
+	var buf bytes.Buffer
+	require.NoError(t, Write(&buf, []Stack{
+		{Name: "shop", Status: "running(2)", Dir: "/stacks/shop", Services: []Service{{"db", "running"}, {"web", "running"}}},
+		{Name: "blog", Status: "1/1 ready"},
+	}))
+	out := buf.String()
+	assert.Contains(t, out, "STACK")
+	assert.Contains(t, out, "db (running), web (running)")
+	assert.Regexp(t, `blog\s+1/1 ready\s+-`, out)
+}